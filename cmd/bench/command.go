@@ -0,0 +1,17 @@
+package bench
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "bench",
+		Short:         "Benchmark a live server or proxy to size the settings of import",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cmd.AddCommand(newWriteCommand())
+	return cmd
+}
@@ -0,0 +1,320 @@
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/history"
+	"github.com/chengshiwen/influx-tool/internal/ratelimit"
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+	"github.com/spf13/cobra"
+)
+
+// maxLoggedErrors is the number of failed writes logged, the others are only counted.
+const maxLoggedErrors = 10
+
+type command struct {
+	cobraCmd         *cobra.Command
+	host             string
+	port             int
+	ssl              bool
+	clientConfig     client.Config
+	database         string
+	retentionPolicy  string
+	skipDDL          bool
+	measurement      string
+	series           int
+	fields           int
+	concurrency      int
+	batchSize        int
+	duration         time.Duration
+	points           int64
+	pps              int
+	progressInterval time.Duration
+}
+
+func newWriteCommand() *cobra.Command {
+	cmd := &command{}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "write",
+		Short:         "Write synthetic points concurrently and measure the points per second and latency of the target",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE()
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.host, "host", "H", "127.0.0.1", "host of the server or proxy to connect to")
+	flags.IntVarP(&cmd.port, "port", "P", 8086, "port to connect to")
+	flags.StringVarP(&cmd.clientConfig.Username, "username", "u", "", "username to connect to the server")
+	flags.StringVarP(&cmd.clientConfig.Password, "password", "p", "", "password to connect to the server")
+	flags.BoolVarP(&cmd.ssl, "ssl", "s", false, "use https for requests (default: false)")
+	flags.StringVarP(&cmd.database, "database", "d", "bench", "database to write to, created if not existing")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "", "retention policy to write to (default: the default retention policy)")
+	flags.BoolVar(&cmd.skipDDL, "skip-ddl", false, "skip creating the database, e.g. for a proxy or influxdb v2 with dbrp mappings (default: false)")
+	flags.StringVarP(&cmd.measurement, "measurement", "m", "bench", "measurement of the points written")
+	flags.IntVar(&cmd.series, "series", 1000, "number of series written, tagged by host")
+	flags.IntVar(&cmd.fields, "fields", 1, "number of float fields per point")
+	flags.IntVarP(&cmd.concurrency, "concurrency", "c", 4, "number of concurrent writers")
+	flags.IntVar(&cmd.batchSize, "batch-size", 5000, "number of points per write")
+	flags.DurationVar(&cmd.duration, "duration", 30*time.Second, "duration of the benchmark, 0 to write until points")
+	flags.Int64Var(&cmd.points, "points", 0, "number of points to write, stopping before duration if reached (default: 0, until duration)")
+	flags.IntVar(&cmd.pps, "pps", 0, "points per second the writers will allow, to check the latency at the rate of import --pps (default: 0, unlimited)")
+	flags.DurationVar(&cmd.clientConfig.Timeout, "timeout", 0, "timeout of requests to the server (default: 0, no timeout)")
+	flags.DurationVar(&cmd.progressInterval, "progress-interval", 10*time.Second, "interval of logging the points written and the points per second, 0 to disable")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) validate() error {
+	if cmd.database == "" {
+		return errors.New("database is invalid")
+	}
+	if cmd.measurement == "" {
+		return errors.New("measurement is invalid")
+	}
+	if cmd.series < 1 {
+		return errors.New("series is invalid")
+	}
+	if cmd.fields < 1 {
+		return errors.New("fields is invalid")
+	}
+	if cmd.concurrency < 1 {
+		return errors.New("concurrency is invalid")
+	}
+	if cmd.batchSize < 1 {
+		return errors.New("batch-size is invalid")
+	}
+	if cmd.duration < 0 || cmd.points < 0 || cmd.pps < 0 || cmd.clientConfig.Timeout < 0 {
+		return errors.New("duration, points, pps and timeout cannot be negative")
+	}
+	if cmd.duration == 0 && cmd.points == 0 {
+		return errors.New("must specify duration or points")
+	}
+	addr := fmt.Sprintf("%s:%d", cmd.host, cmd.port)
+	url, err := client.ParseConnectionString(addr, cmd.ssl)
+	if err != nil {
+		return fmt.Errorf("parse url error: %s", err)
+	}
+	cmd.clientConfig.URL = url
+	cmd.clientConfig.UnsafeSsl = cmd.ssl
+	return nil
+}
+
+func (cmd *command) runE() error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	c, err := client.NewClient(cmd.clientConfig)
+	if err != nil {
+		return fmt.Errorf("could not create client: %s", err)
+	}
+	if _, _, err = c.Ping(); err != nil {
+		return fmt.Errorf("failed to connect to %s: %s", c.Addr(), err)
+	}
+	if !cmd.skipDDL {
+		stmt := "CREATE DATABASE " + influxql.QuoteIdent(cmd.database)
+		resp, err := c.Query(client.Query{Command: stmt})
+		if err == nil {
+			err = resp.Error()
+		}
+		if err != nil {
+			return fmt.Errorf("execute '%s' error: %v", stmt, err)
+		}
+	}
+
+	log.SetFlags(log.LstdFlags)
+	log.Printf("writing %d series of %d fields to %s by %d writers in batches of %d points", cmd.series, cmd.fields, c.Addr(), cmd.concurrency, cmd.batchSize)
+	r := cmd.run(c)
+	r.report(os.Stdout, cmd.batchSize)
+	if r.written == 0 && r.failed > 0 {
+		return fmt.Errorf("all %d points failed, last error: %v", r.failed, r.lastErr)
+	}
+	return nil
+}
+
+// result is the points and the write latencies of a benchmark.
+type result struct {
+	mu        sync.Mutex
+	written   int64 // points written
+	failed    int64 // points of the failed writes
+	bytes     int64 // bytes of the points written
+	latencies []time.Duration
+	errors    int
+	lastErr   error
+	elapsed   time.Duration
+}
+
+func (r *result) add(points, bytes int, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.failed += int64(points)
+		if r.errors++; r.errors <= maxLoggedErrors {
+			log.Printf("write of %d points failed: %s", points, err)
+		}
+		r.lastErr = err
+		return
+	}
+	r.written += int64(points)
+	r.bytes += int64(bytes)
+	r.latencies = append(r.latencies, latency)
+}
+
+// run writes the points by the writers concurrently until the duration or the points reached.
+func (cmd *command) run(c *client.Client) *result {
+	r := &result{}
+	limiter := ratelimit.NewLimiter(float64(cmd.pps))
+	prefixes := cmd.seriesPrefixes()
+	base := time.Now().UnixNano()
+	var seq atomic.Int64 // points generated, the timestamp of a point is base plus its sequence
+	start := time.Now()
+	deadline := time.Time{}
+	if cmd.duration > 0 {
+		deadline = start.Add(cmd.duration)
+	}
+
+	stop := cmd.startProgress(r, start)
+	var wg sync.WaitGroup
+	for i := 0; i < cmd.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf []byte
+			for deadline.IsZero() || time.Now().Before(deadline) {
+				n := int64(cmd.batchSize)
+				first := seq.Add(n) - n
+				if cmd.points > 0 {
+					if first >= cmd.points {
+						return
+					}
+					if first+n > cmd.points {
+						n = cmd.points - first
+					}
+				}
+				buf = cmd.appendBatch(buf[:0], prefixes, base, first, n)
+				limiter.WaitN(int(n))
+				begin := time.Now()
+				_, err := c.WriteLineProtocol(string(buf), cmd.database, cmd.retentionPolicy, "n", "")
+				r.add(int(n), len(buf), time.Since(begin), err)
+			}
+		}()
+	}
+	wg.Wait()
+	stop()
+	r.elapsed = time.Since(start)
+	return r
+}
+
+// seriesPrefixes returns the line prefixes of the series with the measurement and tags escaped.
+func (cmd *command) seriesPrefixes() [][]byte {
+	name := models.EscapeMeasurement([]byte(cmd.measurement))
+	prefixes := make([][]byte, cmd.series)
+	for i := range prefixes {
+		prefixes[i] = append(append([]byte{}, name...), ",host=host"...)
+		prefixes[i] = append(strconv.AppendInt(prefixes[i], int64(i), 10), ' ')
+	}
+	return prefixes
+}
+
+// appendBatch appends the n points from the sequence first to buf, the points go round the series, and the fields
+// of a point are f0, f1, ... of values varying by sequence.
+func (cmd *command) appendBatch(buf []byte, prefixes [][]byte, base, first, n int64) []byte {
+	for seq := first; seq < first+n; seq++ {
+		buf = append(buf, prefixes[seq%int64(len(prefixes))]...)
+		for f := 0; f < cmd.fields; f++ {
+			if f > 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, 'f')
+			buf = strconv.AppendInt(buf, int64(f), 10)
+			buf = append(buf, '=')
+			buf = strconv.AppendFloat(buf, float64((seq+int64(f))%1000)/10, 'f', -1, 64)
+		}
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, base+seq, 10)
+		buf = append(buf, '\n')
+	}
+	return buf
+}
+
+// startProgress logs the points written and the points per second since the last log every progress interval
+// until the returned function is called.
+func (cmd *command) startProgress(r *result, start time.Time) func() {
+	if cmd.progressInterval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(cmd.progressInterval)
+		defer ticker.Stop()
+		var last int64
+		lastTime := start
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				r.mu.Lock()
+				written, failed := r.written, r.failed
+				r.mu.Unlock()
+				log.Printf("points written: %d, failed: %d, points per second: %d", written, failed, int64(float64(written-last)/now.Sub(lastTime).Seconds()))
+				last, lastTime = written, now
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// report writes the throughput and the latency percentiles of the writes, and the points per second suggested for
+// import, which leaves a margin below the throughput measured.
+func (r *result) report(w io.Writer, batchSize int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seconds := r.elapsed.Seconds()
+	fmt.Fprintf(w, "points written: %d, failed: %d, writes: %d, failed writes: %d, elapsed: %s\n",
+		r.written, r.failed, len(r.latencies), r.errors, r.elapsed.Truncate(time.Millisecond))
+	if seconds <= 0 || len(r.latencies) == 0 {
+		return
+	}
+	pps := float64(r.written) / seconds
+	fmt.Fprintf(w, "throughput: %d points/sec, %.1f writes/sec, %s/sec\n",
+		int64(pps), float64(len(r.latencies))/seconds, history.FormatBytes(int64(float64(r.bytes)/seconds)))
+	sorted := append([]time.Duration{}, r.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	fmt.Fprintf(w, "latency: min %s, p50 %s, p90 %s, p95 %s, p99 %s, max %s\n", formatLatency(sorted[0]),
+		formatLatency(percentile(sorted, 50)), formatLatency(percentile(sorted, 90)), formatLatency(percentile(sorted, 95)),
+		formatLatency(percentile(sorted, 99)), formatLatency(sorted[len(sorted)-1]))
+	fmt.Fprintf(w, "suggested import settings: --pps %d --batch-size %d, 80%% of the throughput measured\n", int64(pps*0.8), batchSize)
+}
+
+// percentile returns the latency of the percentile p of the sorted latencies by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func formatLatency(d time.Duration) string {
+	return d.Round(10 * time.Microsecond).String()
+}
@@ -0,0 +1,82 @@
+package bench
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client"
+)
+
+func TestAppendBatch(t *testing.T) {
+	cmd := &command{measurement: "my bench", series: 2, fields: 2}
+	got := string(cmd.appendBatch(nil, cmd.seriesPrefixes(), 100, 1, 3))
+	exp := "my\\ bench,host=host1 f0=0.1,f1=0.2 101\n" +
+		"my\\ bench,host=host0 f0=0.2,f1=0.3 102\n" +
+		"my\\ bench,host=host1 f0=0.3,f1=0.4 103\n"
+	if got != exp {
+		t.Errorf("unexpected batch:\n%s", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var mu sync.Mutex
+	lines := make(map[string]bool)
+	writes := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/write" || r.FormValue("db") != "bench" || r.FormValue("precision") != "n" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		writes++
+		// the third write fails
+		if writes == 3 {
+			http.Error(w, `{"error":"timeout"}`, http.StatusInternalServerError)
+			return
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			lines[line] = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, err := client.NewClient(client.Config{URL: *u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &command{database: "bench", measurement: "bench", series: 10, fields: 1, concurrency: 3, batchSize: 100, points: 950}
+	r := cmd.run(c)
+	// the write failed is of 100 points, or the last 50 points
+	if r.failed != 100 && r.failed != 50 || r.written+r.failed != 950 || len(r.latencies) != 9 || r.errors != 1 {
+		t.Errorf("unexpected points written %d, failed %d, writes %d, failed writes %d", r.written, r.failed, len(r.latencies), r.errors)
+	}
+	if int64(len(lines)) != r.written {
+		t.Errorf("unexpected lines received %d, points written %d", len(lines), r.written)
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := &result{written: 10000, failed: 500, bytes: 1000000, errors: 1, elapsed: 2 * time.Second}
+	for i := 1; i <= 100; i++ {
+		r.latencies = append(r.latencies, time.Duration(101-i)*time.Millisecond)
+	}
+	var buf bytes.Buffer
+	r.report(&buf, 100)
+	exp := "points written: 10000, failed: 500, writes: 100, failed writes: 1, elapsed: 2s\n" +
+		"throughput: 5000 points/sec, 50.0 writes/sec, 488.3 KiB/sec\n" +
+		"latency: min 1ms, p50 50ms, p90 90ms, p95 95ms, p99 99ms, max 100ms\n" +
+		"suggested import settings: --pps 4000 --batch-size 100, 80% of the throughput measured\n"
+	if buf.String() != exp {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
@@ -1,13 +1,16 @@
 package cleanup
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/chengshiwen/influx-tool/internal/audit"
 	"github.com/influxdata/influxdb/client/v2"
 	"github.com/spf13/cobra"
 )
@@ -27,6 +30,9 @@ type command struct {
 	worker   int
 	progress int
 	cleanup  bool
+	dryRun   bool
+	timeout  time.Duration
+	deadline time.Duration
 }
 
 func NewCommand() *cobra.Command {
@@ -37,6 +43,7 @@ func NewCommand() *cobra.Command {
 		Short:         "Cleanup measurements with regexp",
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		Annotations:   map[string]string{audit.DryRunAnnotation: ""},
 		RunE: func(c *cobra.Command, args []string) error {
 			return cmd.runE()
 		},
@@ -56,6 +63,8 @@ func NewCommand() *cobra.Command {
 	flags.IntVarP(&cmd.worker, "worker", "w", 10, "number of concurrent workers to cleanup")
 	flags.IntVarP(&cmd.progress, "progress", "n", 10, "print progress after every <n> measurements cleanup")
 	flags.BoolVarP(&cmd.cleanup, "cleanup", "C", false, "confirm cleanup the measurements (be cautious before doing it, default: false)")
+	flags.DurationVar(&cmd.timeout, "timeout", 0, "timeout of requests to the server (default: 0, no timeout)")
+	flags.DurationVar(&cmd.deadline, "deadline", 0, "deadline of the whole cleanup, after which no more measurement is dropped (default: 0, no deadline)")
 	cmd.cobraCmd.MarkFlagRequired("database")
 	return cmd.cobraCmd
 }
@@ -76,6 +85,12 @@ func (cmd *command) validate() error {
 	if cmd.progress <= 0 {
 		return errors.New("progress is invalid")
 	}
+	if cmd.timeout < 0 {
+		return errors.New("timeout is invalid")
+	}
+	if cmd.deadline < 0 {
+		return errors.New("deadline is invalid")
+	}
 	return nil
 }
 
@@ -83,6 +98,7 @@ func (cmd *command) runE() error {
 	if err := cmd.validate(); err != nil {
 		return err
 	}
+	cmd.dryRun = audit.FromCommand(cmd.cobraCmd).DryRun
 
 	addr := fmt.Sprintf("http://%s:%d", cmd.host, cmd.port)
 	if cmd.ssl {
@@ -93,6 +109,7 @@ func (cmd *command) runE() error {
 		Username:           cmd.username,
 		Password:           cmd.password,
 		InsecureSkipVerify: cmd.ssl,
+		Timeout:            cmd.timeout,
 	})
 	if err != nil {
 		log.Printf("creating influxdb client error: %v", err)
@@ -100,6 +117,13 @@ func (cmd *command) runE() error {
 	}
 	defer c.Close()
 
+	ctx := context.Background()
+	if cmd.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.deadline)
+		defer cancel()
+	}
+
 	var measurements []string
 	query := "SHOW MEASUREMENTS"
 	if cmd.regexp != "" {
@@ -110,15 +134,20 @@ func (cmd *command) runE() error {
 	}
 	log.Printf("query: %s", query)
 	q := client.NewQuery(query, cmd.database, "")
-	if response, err := c.Query(q); err == nil && response.Error() == nil {
-		results := response.Results
-		if len(results) > 0 {
-			if len(results[0].Series) > 0 {
-				if len(results[0].Series[0].Values) > 0 {
-					measurements = make([]string, len(results[0].Series[0].Values))
-					for i, v := range results[0].Series[0].Values {
-						measurements[i] = v[0].(string)
-					}
+	response, err := c.QueryCtx(ctx, q)
+	if err == nil {
+		err = response.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("show measurements error: %v", err)
+	}
+	results := response.Results
+	if len(results) > 0 {
+		if len(results[0].Series) > 0 {
+			if len(results[0].Series[0].Values) > 0 {
+				measurements = make([]string, len(results[0].Series[0].Values))
+				for i, v := range results[0].Series[0].Values {
+					measurements[i] = v[0].(string)
 				}
 			}
 		}
@@ -132,11 +161,20 @@ func (cmd *command) runE() error {
 		return nil
 	}
 
-	cmd.dropMeasurements(c, measurements)
-	return nil
+	return cmd.dropMeasurements(ctx, c, measurements)
 }
 
-func (cmd *command) dropMeasurements(c client.Client, measurements []string) {
+// dropMeasurements drops the measurements by workers, the measurements not dropped once the deadline exceeded
+// are left to a rerun, which shows the remaining measurements only. The statements are logged instead on dry run.
+func (cmd *command) dropMeasurements(ctx context.Context, c client.Client, measurements []string) error {
+	if cmd.cleanup && cmd.dryRun {
+		log.Print("")
+		for _, measurement := range measurements {
+			log.Printf("dry run: DROP MEASUREMENT \"%s\"", escapeIdentifier(measurement))
+		}
+		log.Printf("dry run: %d measurements to be dropped, nothing dropped", len(measurements))
+		return nil
+	}
 	if cmd.cleanup {
 		log.Print("")
 		log.Print("cleanup measurements ...")
@@ -164,8 +202,11 @@ func (cmd *command) dropMeasurements(c client.Client, measurements []string) {
 					<-limit
 				}()
 
+				if ctx.Err() != nil {
+					return
+				}
 				q := client.NewQuery(query, cmd.database, "")
-				if response, err := c.Query(q); err == nil && response.Error() == nil {
+				if response, err := c.QueryCtx(ctx, q); err == nil && response.Error() == nil {
 					atomic.AddInt64(&done, int64(len(response.Results)))
 					if atomic.LoadInt64(&done)%int64(cmd.progress) == 0 {
 						log.Printf("%d/%d cleanup done", done, len(measurements))
@@ -181,8 +222,12 @@ func (cmd *command) dropMeasurements(c client.Client, measurements []string) {
 		if done%int64(cmd.progress) != 0 {
 			log.Printf("%d/%d cleanup done", done, len(measurements))
 		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("cleanup stopped: %v, %d/%d done, rerun to cleanup the remaining measurements", ctx.Err(), done, len(measurements))
+		}
 		log.Print("cleanup measurements done")
 	}
+	return nil
 }
 
 func escapeIdentifier(in string) string {
@@ -0,0 +1,200 @@
+package compact
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// maxTSMFileSize is the size of tsm file to roll over, which is the same as the compactor of influxdb.
+const maxTSMFileSize = uint32(2048 * 1024 * 1024)
+
+// maxMergeBlocks is the blocks of a key merged at a time across the files, which bounds the values of a key buffered.
+var maxMergeBlocks = 64
+
+// compactBuffered compacts the tsm files read by buffered reads fully into the temporary tsm files named after
+// the max generation and sequence as the compactor of influxdb does, since the compactor only reads the tsm files
+// by mmap. The values of a key in the later files overwrite the ones of the same timestamps in the earlier files, and
+// are merged block by block rather than read all at once.
+func (sc *shardCompactor) compactBuffered() ([]string, error) {
+	var generation, sequence int
+	for _, r := range sc.readers {
+		gen, seq, err := tsm1.DefaultParseFileName(r.Path())
+		if err != nil {
+			return nil, err
+		}
+		if gen > generation || gen == generation && seq > sequence {
+			generation, sequence = gen, seq
+		}
+	}
+	w := &tsmFileWriter{dir: sc.path, generation: generation, sequence: sequence}
+	err := mergeKeys(sc.readers, func(key []byte, readers []tsmread.File) error {
+		return mergeBlocks(key, readers, w.write)
+	})
+	if err == nil {
+		err = w.close()
+	}
+	if err != nil {
+		w.remove()
+		return nil, err
+	}
+	return w.files, nil
+}
+
+// mergeKeys calls fn with each key of the readers in order, and the readers containing the key.
+func mergeKeys(readers []tsmread.File, fn func(key []byte, readers []tsmread.File) error) error {
+	idx := make([]int, len(readers))
+	for {
+		var key []byte
+		var matched []tsmread.File
+		for i, r := range readers {
+			if idx[i] >= r.KeyCount() {
+				continue
+			}
+			k, _ := r.KeyAt(idx[i])
+			if c := bytes.Compare(k, key); key == nil || c < 0 {
+				key, matched = k, matched[:0]
+			} else if c > 0 {
+				continue
+			}
+			matched = append(matched, r)
+		}
+		if key == nil {
+			return nil
+		}
+		for i, r := range readers {
+			if idx[i] < r.KeyCount() {
+				if k, _ := r.KeyAt(idx[i]); bytes.Equal(k, key) {
+					idx[i]++
+				}
+			}
+		}
+		if err := fn(key, matched); err != nil {
+			return err
+		}
+	}
+}
+
+// mergeBlocks merges the values of the key in the readers by time windows of about maxMergeBlocks blocks, and calls
+// fn with the values merged of each window in time order. Each window ends before the next block not merged yet, so
+// the blocks overlapping it, such as the ones of the same time range in several files, are merged together. The values
+// of the later readers overwrite the ones of the same timestamps in the earlier readers.
+func mergeBlocks(key []byte, readers []tsmread.File, fn func(key []byte, values tsm1.Values) error) error {
+	var entries []tsm1.IndexEntry
+	for _, r := range readers {
+		entries = append(entries, r.ReadEntries(key, nil)...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].MinTime < entries[j].MinTime })
+	for i := 0; i < len(entries); {
+		j := i + 1
+		for j < len(entries) && (j-i < maxMergeBlocks || entries[j].MinTime == entries[j-1].MinTime) {
+			j++
+		}
+		start, end := entries[i].MinTime, int64(math.MaxInt64)
+		if j < len(entries) {
+			end = entries[j].MinTime - 1
+		}
+		var values tsm1.Values
+		for _, r := range readers {
+			vs, err := r.ReadRange(key, start, end)
+			if err != nil {
+				return fmt.Errorf("read %s error: %v", r.Path(), err)
+			}
+			values = append(values, vs...)
+		}
+		if len(readers) > 1 {
+			values = values.Deduplicate()
+		}
+		if err := fn(key, values); err != nil {
+			return err
+		}
+		i = j
+	}
+	return nil
+}
+
+// tsmFileWriter writes the values into the temporary tsm files of the generation following the sequence, and rolls
+// over to the next file when the file is too large or the blocks of a key exceed.
+type tsmFileWriter struct {
+	dir        string
+	generation int
+	sequence   int
+	files      []string
+	w          tsm1.TSMWriter
+}
+
+func (tw *tsmFileWriter) write(key []byte, values tsm1.Values) error {
+	if tw.w != nil && tw.w.Size() > maxTSMFileSize {
+		if err := tw.close(); err != nil {
+			return err
+		}
+	}
+	for len(values) > 0 {
+		if tw.w == nil {
+			if err := tw.open(); err != nil {
+				return err
+			}
+		}
+		n := len(values)
+		if n > tsdb.DefaultMaxPointsPerBlock {
+			n = tsdb.DefaultMaxPointsPerBlock
+		}
+		err := tw.w.Write(key, values[:n])
+		if err == tsm1.ErrMaxBlocksExceeded {
+			// the block is written, continue the rest of the values in the next file
+			err = tw.close()
+		}
+		if err != nil {
+			return err
+		}
+		values = values[n:]
+	}
+	return nil
+}
+
+func (tw *tsmFileWriter) open() error {
+	name := fmt.Sprintf("%s.%s.%s", tsm1.DefaultFormatFileName(tw.generation, tw.sequence+len(tw.files)+1), tsm1.TSMFileExtension, tsm1.TmpTSMFileExtension)
+	path := filepath.Join(tw.dir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	tw.files = append(tw.files, path)
+	if tw.w, err = tsm1.NewTSMWriter(f); err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+// close writes the index and closes the current file if any.
+func (tw *tsmFileWriter) close() error {
+	if tw.w == nil {
+		return nil
+	}
+	w := tw.w
+	tw.w = nil
+	if err := w.WriteIndex(); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// remove removes the temporary tsm files written.
+func (tw *tsmFileWriter) remove() {
+	if tw.w != nil {
+		tw.w.Close()
+		tw.w = nil
+	}
+	for _, file := range tw.files {
+		os.Remove(file)
+	}
+}
@@ -0,0 +1,174 @@
+package compact
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestCompactBuffered(t *testing.T) {
+	var got [2][]string
+	for i, mode := range []string{tsmread.ModeMmap, tsmread.ModeBuffered} {
+		dir := t.TempDir()
+		writeTSMFile(t, filepath.Join(dir, "000000001-000000001.tsm"), []string{"cpu,host=a#!~#usage", "cpu,host=b#!~#usage"}, [][]tsm1.Value{
+			{tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2, 2.5)},
+			{tsm1.NewFloatValue(1, 10.5)},
+		})
+		writeTSMFile(t, filepath.Join(dir, "000000002-000000001.tsm"), []string{"cpu,host=a#!~#usage", "mem,host=a#!~#used"}, [][]tsm1.Value{
+			{tsm1.NewFloatValue(2, 3.5), tsm1.NewFloatValue(3, 4.5)},
+			{tsm1.NewIntegerValue(1, 20)},
+		})
+		ts := tsm1.NewTombstoner(filepath.Join(dir, "000000001-000000001.tsm"), nil)
+		if err := ts.Add([][]byte{[]byte("cpu,host=b#!~#usage")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		sc, err := newShardCompactor(dir, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = sc.CompactShard(); err != nil {
+			t.Fatal(err)
+		}
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			got[i] = append(got[i], filepath.Base(file))
+		}
+		r, err := tsmread.Open(sc.newTSM[0], tsmread.ModeBuffered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < r.KeyCount(); j++ {
+			key, _ := r.KeyAt(j)
+			values, err := r.ReadAll(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range values {
+				got[i] = append(got[i], fmt.Sprintf("%s %d %v", key, v.UnixNano(), v.Value()))
+			}
+		}
+		r.Close()
+	}
+	exp := []string{
+		"000000002-000000002.tsm",
+		"cpu,host=a#!~#usage 1 1.5",
+		"cpu,host=a#!~#usage 2 3.5",
+		"cpu,host=a#!~#usage 3 4.5",
+		"mem,host=a#!~#used 1 20",
+	}
+	for i, mode := range []string{tsmread.ModeMmap, tsmread.ModeBuffered} {
+		if !cmp.Equal(got[i], exp) {
+			t.Errorf("%s: unexpected result: got=%v, exp=%v", mode, got[i], exp)
+		}
+	}
+}
+
+func TestMergeKeys(t *testing.T) {
+	type file struct {
+		keys   []string
+		values [][]tsm1.Value
+	}
+	tests := []struct {
+		name  string
+		files []file
+		exp   []string
+	}{
+		{
+			name: "key in three files",
+			files: []file{
+				{[]string{"a", "b"}, [][]tsm1.Value{{tsm1.NewFloatValue(1, 1)}, {tsm1.NewFloatValue(1, 1)}}},
+				{[]string{"a", "c"}, [][]tsm1.Value{{tsm1.NewFloatValue(2, 2)}, {tsm1.NewFloatValue(1, 1)}}},
+				{[]string{"a", "b"}, [][]tsm1.Value{{tsm1.NewFloatValue(3, 3)}, {tsm1.NewFloatValue(2, 2)}}},
+			},
+			exp: []string{"a in 3 files", "a 1 1", "a 2 2", "a 3 3", "b in 2 files", "b 1 1", "b 2 2", "c in 1 files", "c 1 1"},
+		},
+		{
+			name: "newest file wins",
+			files: []file{
+				{[]string{"a"}, [][]tsm1.Value{{tsm1.NewFloatValue(1, 1), tsm1.NewFloatValue(2, 2)}}},
+				{[]string{"a"}, [][]tsm1.Value{{tsm1.NewFloatValue(2, 20)}}},
+				{[]string{"a"}, [][]tsm1.Value{{tsm1.NewFloatValue(2, 200), tsm1.NewFloatValue(3, 3)}}},
+			},
+			exp: []string{"a in 3 files", "a 1 1", "a 2 200", "a 3 3"},
+		},
+		{
+			name: "blocks overlapping across files",
+			files: []file{
+				{[]string{"a", "a"}, [][]tsm1.Value{{tsm1.NewFloatValue(1, 1), tsm1.NewFloatValue(2, 2)}, {tsm1.NewFloatValue(5, 5), tsm1.NewFloatValue(6, 6)}}},
+				{[]string{"a"}, [][]tsm1.Value{{tsm1.NewFloatValue(2, 20), tsm1.NewFloatValue(5, 50)}}},
+			},
+			exp: []string{"a in 2 files", "a 1 1", "a 2 20", "a 5 50", "a 6 6"},
+		},
+	}
+	defer func(n int) { maxMergeBlocks = n }(maxMergeBlocks)
+	for _, tt := range tests {
+		// the blocks are merged all at once, or one by one
+		for _, n := range []int{64, 1} {
+			t.Run(fmt.Sprintf("%s/%d", tt.name, n), func(t *testing.T) {
+				maxMergeBlocks = n
+				dir := t.TempDir()
+				var readers []tsmread.File
+				for i, f := range tt.files {
+					path := filepath.Join(dir, fmt.Sprintf("%09d-000000001.tsm", i+1))
+					writeTSMFile(t, path, f.keys, f.values)
+					r, err := tsmread.Open(path, tsmread.ModeBuffered)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer r.Close()
+					readers = append(readers, r)
+				}
+				var got []string
+				err := mergeKeys(readers, func(key []byte, readers []tsmread.File) error {
+					got = append(got, fmt.Sprintf("%s in %d files", key, len(readers)))
+					return mergeBlocks(key, readers, func(key []byte, values tsm1.Values) error {
+						for _, v := range values {
+							got = append(got, fmt.Sprintf("%s %d %v", key, v.UnixNano(), v.Value()))
+						}
+						return nil
+					})
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !cmp.Equal(got, tt.exp) {
+					t.Errorf("unexpected result: got=%v, exp=%v", got, tt.exp)
+				}
+			})
+		}
+	}
+}
+
+func writeTSMFile(t *testing.T, path string, keys []string, values [][]tsm1.Value) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if err = w.Write([]byte(key), values[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.WriteIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -12,8 +12,13 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/chengshiwen/influx-tool/internal/audit"
 	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/chengshiwen/influx-tool/internal/preflight"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
 	"github.com/influxdata/influxdb/pkg/limiter"
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 	"github.com/spf13/cobra"
@@ -23,7 +28,9 @@ type command struct {
 	cobraCmd *cobra.Command
 	path     string
 	force    bool
+	forceAll bool
 	worker   int
+	readMode string
 }
 
 func NewCommand() *cobra.Command {
@@ -34,6 +41,7 @@ func NewCommand() *cobra.Command {
 		Short:         "Compact the all shards fully",
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		Annotations:   map[string]string{audit.Annotation: ""},
 		RunE: func(c *cobra.Command, args []string) error {
 			return cmd.runE()
 		},
@@ -42,7 +50,9 @@ func NewCommand() *cobra.Command {
 	flags.SortFlags = false
 	flags.StringVarP(&cmd.path, "path", "p", "", "path of shard to be compacted like /path/to/influxdb/data/db/rp (required)")
 	flags.BoolVarP(&cmd.force, "force", "f", false, "force compaction without prompting (default: false)")
+	flags.BoolVar(&cmd.forceAll, "force-all", false, "compact all shards including the ones already fully compacted, which are skipped by default (default: false)")
 	flags.IntVarP(&cmd.worker, "worker", "w", 0, "number of concurrent workers to compact (default: 0, unlimited)")
+	flags.StringVar(&cmd.readMode, "tsm-read-mode", tsmread.ModeMmap, "mode of reading tsm files: mmap, or buffered for the filesystems where mmap performs poorly or faults, such as nfs")
 	cmd.cobraCmd.MarkFlagRequired("path")
 	return cmd.cobraCmd
 }
@@ -51,6 +61,9 @@ func (cmd *command) validate() error {
 	if cmd.worker < 0 {
 		return errors.New("worker is invalid")
 	}
+	if !tsmread.ValidMode(cmd.readMode) {
+		return errors.New("tsm-read-mode is invalid, require mmap or buffered")
+	}
 	return nil
 }
 
@@ -58,6 +71,10 @@ func (cmd *command) runE() error {
 	if err := cmd.validate(); err != nil {
 		return err
 	}
+	log.SetFlags(0)
+	if err := cmd.preflight(); err != nil {
+		return err
+	}
 	files, err := os.ReadDir(cmd.path)
 	if err != nil {
 		return err
@@ -71,10 +88,17 @@ func (cmd *command) runE() error {
 		paths = append(paths, filepath.Join(cmd.path, file.Name()))
 	}
 
-	log.SetFlags(0)
 	log.Printf("opening shard at path %q", cmd.path)
 
-	if !cmd.force {
+	if opts := audit.FromCommand(cmd.cobraCmd); opts.Planned() {
+		p, err := cmd.plan(paths)
+		if err != nil {
+			return err
+		}
+		if ok, err := opts.Gate(p); err != nil || !ok {
+			return err
+		}
+	} else if !cmd.force {
 		fmt.Print("proceed? [N] ")
 		scan := bufio.NewScanner(os.Stdin)
 		scan.Scan()
@@ -89,6 +113,7 @@ func (cmd *command) runE() error {
 
 	log.Print("compacting shard")
 
+	var compacted, skipped, walOnly, failed int64
 	limit := make(chan struct{}, cmd.worker)
 	wg := &sync.WaitGroup{}
 	for _, path := range paths {
@@ -105,16 +130,39 @@ func (cmd *command) runE() error {
 				}
 			}()
 
-			sc, err := newShardCompactor(path)
+			if !cmd.forceAll {
+				if ok, err := fullyCompacted(path); err != nil {
+					log.Printf("check compaction %s error: %v", path, err)
+				} else if ok {
+					log.Printf("compaction %s skipped: already fully compacted", path)
+					atomic.AddInt64(&skipped, 1)
+					return
+				}
+			}
+			sc, err := newShardCompactor(path, cmd.readMode)
+			if err == errNoTSMFiles {
+				// the cache of the wal is snapshotted to tsm files by influxd, leave the wal segments untouched
+				if n := walSegments(path); n > 0 {
+					log.Printf("warning: compaction %s skipped: no tsm files but %d wal segments, which are snapshotted by influxd", path, n)
+					atomic.AddInt64(&walOnly, 1)
+				} else {
+					log.Printf("compaction %s skipped: no tsm files", path)
+					atomic.AddInt64(&skipped, 1)
+				}
+				return
+			}
 			if err != nil {
 				log.Printf("newShardCompactor %s error: %v", path, err)
+				atomic.AddInt64(&failed, 1)
 				return
 			}
 			err = sc.CompactShard()
 			if err != nil {
 				log.Printf("compaction %s failed: %v", path, err)
+				atomic.AddInt64(&failed, 1)
 				return
 			}
+			atomic.AddInt64(&compacted, 1)
 			newTSM := make([]string, len(sc.newTSM))
 			for i := range sc.newTSM {
 				newTSM[i] = filepath.Base(sc.newTSM[i])
@@ -123,23 +171,129 @@ func (cmd *command) runE() error {
 		}()
 	}
 	wg.Wait()
-	log.Print("compaction shard done")
+	log.Printf("compaction shard done: %d compacted, %d skipped, %d wal only, %d failed", compacted, skipped, walOnly, failed)
 	return nil
 }
 
+// preflight checks the engine of the shards of path like /path/to/influxdb/data/db/rp, whose tsm files are compacted
+// without the index.
+func (cmd *command) preflight() error {
+	rpDir := filepath.Clean(cmd.path)
+	dbDir := filepath.Dir(rpDir)
+	shards, err := preflight.Inspect(filepath.Dir(dbDir), filepath.Base(dbDir), filepath.Base(rpDir))
+	if err != nil {
+		return err
+	}
+	return preflight.Check(shards, "", log.Printf)
+}
+
+// plan returns the audit plan of compacting the shards of paths, the shards skipped are not planned. The compacted
+// tsm files are named after the max generation and sequence, with more sequences if larger than the max file size.
+func (cmd *command) plan(paths []string) (*audit.Plan, error) {
+	p := &audit.Plan{}
+	for _, path := range paths {
+		if !cmd.forceAll {
+			if ok, err := fullyCompacted(path); err == nil && ok {
+				continue
+			}
+		}
+		tsmFiles, err := filepath.Glob(filepath.Join(path, fmt.Sprintf("*.%s", tsm1.TSMFileExtension)))
+		if err != nil {
+			return nil, err
+		}
+		if len(tsmFiles) == 0 {
+			continue
+		}
+		tombstones, err := filepath.Glob(filepath.Join(path, fmt.Sprintf("*.%s", tsm1.TombstoneFileExtension)))
+		if err != nil {
+			return nil, err
+		}
+		if err = source.SortTSMFiles(tsmFiles, false); err != nil {
+			return nil, err
+		}
+		var generation, sequence int
+		for _, file := range tsmFiles {
+			gen, seq, err := tsm1.DefaultParseFileName(file)
+			if err != nil {
+				return nil, err
+			}
+			if gen > generation || gen == generation && seq > sequence {
+				generation, sequence = gen, seq
+			}
+		}
+		newTSM := filepath.Join(path, fmt.Sprintf("%s.%s", tsm1.DefaultFormatFileName(generation, sequence+1), tsm1.TSMFileExtension))
+		tmpTSM := fmt.Sprintf("%s.%s", newTSM, tsm1.CompactionTempExtension)
+		p.Add(audit.Create, tmpTSM, "and the following sequences if larger than 2GB")
+		p.Rename(tmpTSM, newTSM, "")
+		for _, file := range tsmFiles {
+			p.Add(audit.Delete, file, "")
+		}
+		for _, file := range tombstones {
+			p.Add(audit.Delete, file, "")
+		}
+	}
+	return p, nil
+}
+
+var errNoTSMFiles = errors.New("no tsm files")
+
+// walSegments returns the number of wal segments of the shard, whose wal directory is found by replacing the data
+// directory of path like /path/to/influxdb/data/db/rp/id with the sibling wal directory.
+func walSegments(path string) int {
+	rpDir := filepath.Dir(path)
+	dbDir := filepath.Dir(rpDir)
+	dataDir := filepath.Dir(dbDir)
+	if filepath.Base(dataDir) != "data" {
+		return 0
+	}
+	walDir := filepath.Join(filepath.Dir(dataDir), "wal", filepath.Base(dbDir), filepath.Base(rpDir), filepath.Base(path))
+	segments, err := filepath.Glob(filepath.Join(walDir, fmt.Sprintf("%s*.%s", tsm1.WALFilePrefix, tsm1.WALFileExtension)))
+	if err != nil {
+		return 0
+	}
+	return len(segments)
+}
+
+// fullyCompacted returns whether the tsm files of the shard are of a single generation without tombstones,
+// as left by a full compaction, which is the same as the planner of influxdb decides.
+func fullyCompacted(path string) (bool, error) {
+	files, err := filepath.Glob(filepath.Join(path, fmt.Sprintf("*.%s", tsm1.TSMFileExtension)))
+	if err != nil || len(files) == 0 {
+		return false, err
+	}
+	tombstones, err := filepath.Glob(filepath.Join(path, fmt.Sprintf("*.%s", tsm1.TombstoneFileExtension)))
+	if err != nil || len(tombstones) > 0 {
+		return false, err
+	}
+	generation := -1
+	for _, file := range files {
+		gen, _, err := tsm1.DefaultParseFileName(file)
+		if err != nil {
+			return false, nil
+		}
+		if generation >= 0 && gen != generation {
+			return false, nil
+		}
+		generation = gen
+	}
+	return true, nil
+}
+
 type shardCompactor struct {
 	path      string
+	readMode  string
 	tsm       []string
 	tombstone []string
-	readers   []*tsm1.TSMReader
-	files     map[string]*tsm1.TSMReader
+	readers   []tsmread.File
+	files     map[string]tsmread.File
 	newTSM    []string
 }
 
-func newShardCompactor(path string) (sc *shardCompactor, err error) {
+func newShardCompactor(path, readMode string) (sc *shardCompactor, err error) {
 	sc = &shardCompactor{
-		path:  path,
-		files: make(map[string]*tsm1.TSMReader),
+		path:     path,
+		readMode: readMode,
+		files:    make(map[string]tsmread.File),
 	}
 
 	sc.tsm, err = filepath.Glob(filepath.Join(path, fmt.Sprintf("*.%s", tsm1.TSMFileExtension)))
@@ -147,9 +301,11 @@ func newShardCompactor(path string) (sc *shardCompactor, err error) {
 		return nil, fmt.Errorf("newFileStore: error reading tsm files at path %q: %v", path, err)
 	}
 	if len(sc.tsm) == 0 {
-		return nil, fmt.Errorf("newFileStore: no tsm files at path %q", path)
+		return nil, errNoTSMFiles
+	}
+	if err = source.SortTSMFiles(sc.tsm, false); err != nil {
+		return nil, err
 	}
-	sort.Strings(sc.tsm)
 
 	sc.tombstone, err = filepath.Glob(filepath.Join(path, fmt.Sprintf("*.%s", tsm1.TombstoneFileExtension)))
 	if err != nil {
@@ -164,11 +320,11 @@ func newShardCompactor(path string) (sc *shardCompactor, err error) {
 }
 
 func (sc *shardCompactor) openFiles() error {
-	sc.readers = make([]*tsm1.TSMReader, 0, len(sc.tsm))
+	sc.readers = make([]tsmread.File, 0, len(sc.tsm))
 
 	// struct to hold the result of opening each reader in a goroutine
 	type res struct {
-		r   *tsm1.TSMReader
+		r   tsmread.File
 		err error
 	}
 
@@ -177,33 +333,32 @@ func (sc *shardCompactor) openFiles() error {
 	badTSM := make([]bool, len(sc.tsm))
 	readerC := make(chan *res)
 	for i, fn := range sc.tsm {
-		file, err := os.OpenFile(fn, os.O_RDONLY, 0666)
-		if err != nil {
+		if _, err := os.Stat(fn); err != nil {
 			return fmt.Errorf("newFileStore: failed to open file %q: %v", fn, err)
 		}
 
-		go func(idx int, file *os.File) {
+		go func(idx int, fn string) {
 			// Ensure a limited number of TSM files are loaded at once.
 			// Systems which have very large datasets (1TB+) can have thousands
 			// of TSM files which can cause extremely long load times.
 			lim.Take()
 			defer lim.Release()
 
-			df, err := tsm1.NewTSMReader(file)
+			df, err := tsmread.Open(fn, sc.readMode)
 
 			// If we are unable to read a TSM file then log the error, remove
 			// the file, and continue loading the shard without it.
 			if err != nil {
-				if e := os.Remove(file.Name()); e != nil {
-					log.Printf("cannot remove corrupt tsm file: %s, error: %v", file.Name(), e)
-					readerC <- &res{r: df, err: fmt.Errorf("cannot remove corrupt file %s: %v", file.Name(), e)}
+				if e := os.Remove(fn); e != nil {
+					log.Printf("cannot remove corrupt tsm file: %s, error: %v", fn, e)
+					readerC <- &res{r: df, err: fmt.Errorf("cannot remove corrupt file %s: %v", fn, e)}
 					return
 				}
 				badTSM[idx] = true
 			}
 
 			readerC <- &res{r: df}
-		}(i, file)
+		}(i, fn)
 	}
 
 	for range sc.tsm {
@@ -234,6 +389,14 @@ func (sc *shardCompactor) openFiles() error {
 }
 
 func (sc *shardCompactor) CompactShard() (err error) {
+	if sc.readMode == tsmread.ModeBuffered {
+		tsmFiles, err := sc.compactBuffered()
+		if err == nil {
+			sc.newTSM, err = sc.replace(tsmFiles)
+		}
+		return err
+	}
+
 	c := tsm1.NewCompactor()
 	c.Dir = sc.path
 	c.Size = tsm1.DefaultSegmentSize
@@ -286,14 +449,15 @@ func (sc *shardCompactor) NextGeneration() int {
 }
 
 func (sc *shardCompactor) TSMReader(path string) *tsm1.TSMReader {
-	r := sc.files[path]
-	if r != nil {
-		r.Ref()
+	r, ok := sc.files[path].(tsmread.MmapReader)
+	if !ok {
+		return nil
 	}
-	return r
+	r.Ref()
+	return r.TSMReader
 }
 
-type tsmReaders []*tsm1.TSMReader
+type tsmReaders []tsmread.File
 
 func (a tsmReaders) Len() int           { return len(a) }
 func (a tsmReaders) Less(i, j int) bool { return a[i].Path() < a[j].Path() }
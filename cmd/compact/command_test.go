@@ -0,0 +1,186 @@
+package compact
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/audit"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestPlan(t *testing.T) {
+	rpDir := t.TempDir()
+	dir, compacted := filepath.Join(rpDir, "1"), filepath.Join(rpDir, "2")
+	for _, path := range []string{dir, compacted} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	values := [][]tsm1.Value{{tsm1.NewFloatValue(1, 1.5)}}
+	writeTSMFile(t, filepath.Join(dir, "000000001-000000001.tsm"), []string{"cpu,host=a#!~#usage"}, values)
+	writeTSMFile(t, filepath.Join(dir, "000000002-000000001.tsm"), []string{"cpu,host=b#!~#usage"}, values)
+	writeTSMFile(t, filepath.Join(compacted, "000000001-000000002.tsm"), []string{"cpu,host=a#!~#usage"}, values)
+	ts := tsm1.NewTombstoner(filepath.Join(dir, "000000001-000000001.tsm"), nil)
+	if err := ts.Add([][]byte{[]byte("cpu,host=a#!~#usage")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &command{}
+	p, err := cmd.plan([]string{dir, compacted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTSM := filepath.Join(dir, "000000002-000000002.tsm")
+	exp := []audit.Op{
+		{Action: audit.Create, Path: newTSM + ".tmp", Note: "and the following sequences if larger than 2GB"},
+		{Action: audit.Rename, Path: newTSM + ".tmp", To: newTSM},
+		{Action: audit.Delete, Path: filepath.Join(dir, "000000001-000000001.tsm")},
+		{Action: audit.Delete, Path: filepath.Join(dir, "000000002-000000001.tsm")},
+		{Action: audit.Delete, Path: filepath.Join(dir, "000000001-000000001.tombstone")},
+	}
+	ops := p.Ops()
+	if len(ops) != len(exp) {
+		t.Fatalf("unexpected plan: %v", p.Lines())
+	}
+	for i := range exp {
+		if ops[i] != exp[i] {
+			t.Errorf("unexpected op %d: got=%v, exp=%v", i, ops[i], exp[i])
+		}
+	}
+
+	// the plan is the same as the compaction
+	sc, err := newShardCompactor(dir, tsmread.ModeMmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sc.CompactShard(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.newTSM) != 1 || sc.newTSM[0] != newTSM {
+		t.Errorf("unexpected new tsm files: %v", sc.newTSM)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != newTSM {
+		t.Errorf("unexpected files: %v", files)
+	}
+}
+
+func TestCompactSkip(t *testing.T) {
+	keys := []string{"cpu,host=a#!~#usage", "cpu,host=b#!~#usage"}
+	values := [][]tsm1.Value{{tsm1.NewFloatValue(1, 1.5)}, {tsm1.NewFloatValue(2, 2.5)}}
+	for _, tt := range []struct {
+		name      string
+		files     []string
+		tombstone bool
+		wal       bool // a wal segment of the shard written
+		forceAll  bool
+		exp       []string // files of the shard after compaction
+		logs      []string
+	}{
+		{"fully compacted", []string{"000000001-000000002.tsm"}, false, false, false,
+			[]string{"000000001-000000002.tsm"}, []string{"compaction shard done: 0 compacted, 1 skipped, 0 wal only, 0 failed"}},
+		{"fully compacted by force all", []string{"000000001-000000002.tsm"}, false, false, true,
+			[]string{"000000001-000000003.tsm"}, []string{"compaction shard done: 1 compacted, 0 skipped, 0 wal only, 0 failed"}},
+		{"tombstone", []string{"000000001-000000002.tsm"}, true, false, false,
+			[]string{"000000001-000000003.tsm"}, []string{"compaction shard done: 1 compacted, 0 skipped, 0 wal only, 0 failed"}},
+		{"multiple generations", []string{"000000001-000000001.tsm", "000000002-000000001.tsm"}, false, false, false,
+			[]string{"000000002-000000002.tsm"}, []string{"compaction shard done: 1 compacted, 0 skipped, 0 wal only, 0 failed"}},
+		{"no tsm files", nil, false, false, false,
+			nil, []string{"skipped: no tsm files", "compaction shard done: 0 compacted, 1 skipped, 0 wal only, 0 failed"}},
+		// the wal segments are left to influxd without failing the other shards
+		{"wal only", nil, false, true, false,
+			nil, []string{"warning: compaction", "no tsm files but 1 wal segments", "compaction shard done: 0 compacted, 0 skipped, 1 wal only, 0 failed"}},
+	} {
+		dir := t.TempDir()
+		rpDir := filepath.Join(dir, "data", "db", "rp")
+		shardDir, walDir := filepath.Join(rpDir, "1"), filepath.Join(dir, "wal", "db", "rp", "1")
+		for _, path := range []string{shardDir, walDir} {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, file := range tt.files {
+			writeTSMFile(t, filepath.Join(shardDir, file), keys, values)
+		}
+		if tt.tombstone {
+			ts := tsm1.NewTombstoner(filepath.Join(shardDir, tt.files[0]), nil)
+			if err := ts.Add([][]byte{[]byte(keys[0])}); err != nil {
+				t.Fatal(err)
+			}
+			if err := ts.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.wal {
+			writeWALSegment(t, filepath.Join(walDir, "_00001.wal"), keys[0], values[0])
+		}
+
+		var out bytes.Buffer
+		log.SetOutput(&out)
+		c := NewCommand()
+		args := []string{"-p", rpDir, "-f"}
+		if tt.forceAll {
+			args = append(args, "--force-all")
+		}
+		c.SetArgs(args)
+		err := c.Execute()
+		log.SetOutput(os.Stderr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(shardDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		if !reflect.DeepEqual(got, tt.exp) {
+			t.Errorf("%s: unexpected files: %v", tt.name, got)
+		}
+		for _, line := range tt.logs {
+			if !strings.Contains(out.String(), line) {
+				t.Errorf("%s: %q not logged:\n%s", tt.name, line, out.String())
+			}
+		}
+		if segments, _ := filepath.Glob(filepath.Join(walDir, "*.wal")); tt.wal && len(segments) != 1 {
+			t.Errorf("%s: unexpected wal segments: %v", tt.name, segments)
+		}
+	}
+}
+
+func writeWALSegment(t *testing.T, path, key string, values []tsm1.Value) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tsm1.NewWALSegmentWriter(f)
+	entry := &tsm1.WriteWALEntry{Values: map[string][]tsm1.Value{key: values}}
+	b, err := entry.Encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(entry.Type(), snappy.Encode(nil, b)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,237 @@
+package convertindex
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chengshiwen/influx-tool/internal/audit"
+	"github.com/chengshiwen/influx-tool/internal/preflight"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/spf13/cobra"
+)
+
+type command struct {
+	cobraCmd          *cobra.Command
+	path              string
+	walPath           string
+	retentionPolicy   string
+	index             string
+	rebuildSeriesFile bool
+	force             bool
+	worker            int
+	readMode          string
+}
+
+func NewCommand() *cobra.Command {
+	cmd := &command{}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "convert-index",
+		Short:         "Convert the index of the shards of a database between inmem and tsi1 offline",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Annotations:   map[string]string{audit.Annotation: ""},
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE()
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.path, "path", "p", "", "path of database to be converted like /path/to/influxdb/data/db, influxd must be stopped (required)")
+	flags.StringVarP(&cmd.walPath, "wal-path", "W", "", "path of the wal of database like /path/to/influxdb/wal/db, whose series are also indexed (default: the wal sibling of data if any)")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "", "retention policy to be converted (default: all)")
+	flags.StringVarP(&cmd.index, "index", "i", preflight.IndexTSI1, "index converted to: tsi1 to build the tsi1 index of the shards without it, or inmem to remove the tsi1 index, set index-version of influxd the same")
+	flags.BoolVar(&cmd.rebuildSeriesFile, "rebuild-series-file", false, "remove the series file of database and rebuild it from the series of all shards, the tsi1 index of which is rebuilt as well (default: false)")
+	flags.BoolVarP(&cmd.force, "force", "f", false, "force conversion without prompting (default: false)")
+	flags.IntVarP(&cmd.worker, "worker", "w", 0, "number of concurrent workers to convert (default: 0, unlimited)")
+	flags.StringVar(&cmd.readMode, "tsm-read-mode", tsmread.ModeMmap, "mode of reading tsm files: mmap, or buffered for the filesystems where mmap performs poorly or faults, such as nfs")
+	cmd.cobraCmd.MarkFlagRequired("path")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) validate() error {
+	if cmd.index != preflight.IndexTSI1 && cmd.index != preflight.IndexInmem {
+		return errors.New("index is invalid, require tsi1 or inmem")
+	}
+	if cmd.worker < 0 {
+		return errors.New("worker is invalid")
+	}
+	if !tsmread.ValidMode(cmd.readMode) {
+		return errors.New("tsm-read-mode is invalid, require mmap or buffered")
+	}
+	return nil
+}
+
+func (cmd *command) runE() error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	log.SetFlags(0)
+	dbDir := filepath.Clean(cmd.path)
+	if _, err := os.Stat(dbDir); err != nil {
+		return err
+	}
+	shards, err := preflight.Inspect(filepath.Dir(dbDir), filepath.Base(dbDir), cmd.retentionPolicy)
+	if err != nil {
+		return err
+	}
+	walDir := cmd.walDir(dbDir)
+
+	var converts []preflight.Shard
+	var skipped int64
+	for _, sh := range shards {
+		switch {
+		case sh.Engine != preflight.EngineTSM1:
+			log.Printf("warning: conversion %s skipped: engine %s is not supported", sh, sh.Engine)
+			skipped++
+		case sh.Index == cmd.index && !cmd.rebuildSeriesFile:
+			log.Printf("conversion %s skipped: already %s", sh, cmd.index)
+			skipped++
+		default:
+			converts = append(converts, sh)
+		}
+	}
+	if len(converts) == 0 {
+		log.Printf("convert index done: 0 converted, %d skipped, 0 failed", skipped)
+		return nil
+	}
+
+	log.Printf("converting %d shards of database %q to %s index%s", len(converts), dbDir, cmd.index, cmd.withSeriesFile())
+	if opts := audit.FromCommand(cmd.cobraCmd); opts.Planned() {
+		if ok, err := opts.Gate(cmd.plan(dbDir, converts)); err != nil || !ok {
+			return err
+		}
+	} else if !cmd.force {
+		fmt.Print("proceed? [N] ")
+		scan := bufio.NewScanner(os.Stdin)
+		scan.Scan()
+		if scan.Err() != nil {
+			return fmt.Errorf("error reading stdin: %v", scan.Err())
+		}
+
+		if strings.ToLower(scan.Text()) != "y" {
+			return nil
+		}
+	}
+
+	builder, err := shard.NewIndexBuilder(dbDir, cmd.readMode, cmd.rebuildSeriesFile)
+	if err != nil {
+		return err
+	}
+	var converted, failed, series int64
+	limit := make(chan struct{}, cmd.worker)
+	wg := &sync.WaitGroup{}
+	for _, sh := range converts {
+		wg.Add(1)
+		sh := sh
+		go func() {
+			if cmd.worker > 0 {
+				limit <- struct{}{}
+			}
+			defer func() {
+				wg.Done()
+				if cmd.worker > 0 {
+					<-limit
+				}
+			}()
+
+			var walPath string
+			if walDir != "" {
+				walPath = filepath.Join(walDir, sh.RetentionPolicy, strconv.FormatUint(sh.ID, 10))
+			}
+			var n int
+			var err error
+			if cmd.index == preflight.IndexTSI1 {
+				n, err = builder.BuildTSI1(sh.Path, walPath)
+			} else {
+				n, err = builder.BuildInmem(sh.Path, walPath, cmd.rebuildSeriesFile)
+			}
+			if err != nil {
+				log.Printf("conversion %s failed: %v", sh, err)
+				atomic.AddInt64(&failed, 1)
+				return
+			}
+			atomic.AddInt64(&series, int64(n))
+			log.Printf("conversion %s succeeded with %d series (%d/%d)", sh, n, atomic.AddInt64(&converted, 1), len(converts))
+		}()
+	}
+	wg.Wait()
+	if err = builder.Close(); err != nil {
+		return err
+	}
+	log.Printf("convert index done: %d converted, %d skipped, %d failed, %d series", converted, skipped, failed, series)
+	if converted > 0 {
+		log.Printf("set index-version = %q in the [data] section of the influxd config before starting influxd", cmd.index)
+	}
+	return nil
+}
+
+// plan returns the audit plan of converting the shards of the database dbDir. The series file is opened and compacted
+// even if no series added, and the tsi1 index is built into a temporary directory renamed once done.
+func (cmd *command) plan(dbDir string, shards []preflight.Shard) *audit.Plan {
+	p := &audit.Plan{}
+	seriesPath := filepath.Join(dbDir, tsdb.SeriesFileDirectory)
+	if cmd.rebuildSeriesFile {
+		p.Add(audit.Delete, seriesPath, "")
+		p.Add(audit.Create, seriesPath, "from the series of the shards")
+	} else if cmd.index == preflight.IndexTSI1 {
+		p.Write(seriesPath, "series added and compacted")
+	} else {
+		p.Write(seriesPath, "compacted")
+	}
+	for _, sh := range shards {
+		indexPath := filepath.Join(sh.Path, shard.IndexDirectory)
+		_, err := os.Stat(indexPath)
+		exists := err == nil
+		if cmd.index == preflight.IndexInmem {
+			if exists {
+				p.Add(audit.Delete, indexPath, "")
+			}
+			continue
+		}
+		tmpPath := filepath.Join(sh.Path, shard.TmpIndexDirectory)
+		if _, err = os.Stat(tmpPath); err == nil {
+			p.Add(audit.Delete, tmpPath, "left by a previous run")
+		}
+		p.Add(audit.Create, tmpPath, "")
+		if exists {
+			p.Add(audit.Delete, indexPath, "")
+		}
+		p.Rename(tmpPath, indexPath, "")
+	}
+	return p
+}
+
+// walDir returns the wal path of the database, which is the wal sibling of the data directory of dbDir by default,
+// or empty if missing.
+func (cmd *command) walDir(dbDir string) string {
+	if cmd.walPath != "" {
+		return filepath.Clean(cmd.walPath)
+	}
+	dataDir := filepath.Dir(dbDir)
+	if filepath.Base(dataDir) != "data" {
+		return ""
+	}
+	walDir := filepath.Join(filepath.Dir(dataDir), "wal", filepath.Base(dbDir))
+	if _, err := os.Stat(walDir); err != nil {
+		return ""
+	}
+	return walDir
+}
+
+func (cmd *command) withSeriesFile() string {
+	if cmd.rebuildSeriesFile {
+		return ", rebuilding the series file"
+	}
+	return ""
+}
@@ -1,13 +1,18 @@
 package deletetsm
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sort"
 	"time"
 
+	"github.com/chengshiwen/influx-tool/internal/audit"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
 	"github.com/influxdata/influxdb/models"
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 	"github.com/spf13/cobra"
@@ -15,13 +20,29 @@ import (
 
 type command struct {
 	cobraCmd    *cobra.Command
-	measurement string // measurement to delete
-	sanitize    bool   // remove all keys with non-printable unicode
-	verbose     bool   // verbose logging
+	measurement string              // measurement to delete
+	fields      map[string]struct{} // fields of measurement to delete
+	sanitize    bool                // remove all keys with invalid characters
+	rewrite     bool                // rewrite keys with invalid characters instead of removing them
+	report      string              // report file of removed keys
+	verbose     bool                // verbose logging
+	readMode    string              // mode of reading tsm files
+
+	sanitizer *sanitizer
+	reportOut io.Writer
+}
+
+type tempflag struct {
+	fields         []string
+	sanitizePolicy string
+	sanitizeRegexp string
 }
 
+const stdoutMark = "-"
+
 func NewCommand() *cobra.Command {
-	cmd := &command{}
+	tf := &tempflag{}
+	cmd := &command{fields: make(map[string]struct{})}
 	cmd.cobraCmd = &cobra.Command{
 		Args: func(c *cobra.Command, args []string) error {
 			if err := cobra.MinimumNArgs(1)(c, args); err != nil {
@@ -33,33 +54,75 @@ func NewCommand() *cobra.Command {
 		Short:         "Delete a measurement from a raw tsm file",
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		Annotations:   map[string]string{audit.Annotation: ""},
 		RunE: func(c *cobra.Command, args []string) error {
-			return cmd.runE(args)
+			return cmd.runE(tf, args)
 		},
 	}
 	flags := cmd.cobraCmd.Flags()
 	flags.SortFlags = false
 	flags.StringVarP(&cmd.measurement, "measurement", "m", "", "the name of the measurement to remove")
-	flags.BoolVarP(&cmd.sanitize, "sanitize", "s", false, "remove all keys with non-printable unicode characters (default: false)")
+	flags.StringArrayVarP(&tf.fields, "field", "f", []string{}, "the name of the field to remove, can be set multiple times (require measurement, default: all)")
+	flags.BoolVarP(&cmd.sanitize, "sanitize", "s", false, "remove all keys with invalid characters according to sanitize policy (default: false)")
+	flags.StringVar(&tf.sanitizePolicy, "sanitize-policy", policyPrintable, "policy of invalid characters to sanitize: printable, utf8 or regexp")
+	flags.StringVar(&tf.sanitizeRegexp, "sanitize-regexp", "", "regexp matching invalid characters (require sanitize policy regexp)")
+	flags.BoolVar(&cmd.rewrite, "rewrite", false, "rewrite keys by removing invalid characters instead of removing keys when possible (require sanitize, default: false)")
+	flags.StringVar(&cmd.report, "report", "", "'-' for standard out or the report file to write removed and rewritten keys to (default: none)")
+	flags.StringVar(&cmd.readMode, "tsm-read-mode", tsmread.ModeMmap, "mode of reading tsm files: mmap, or buffered for the filesystems where mmap performs poorly or faults, such as nfs")
 	flags.BoolVarP(&cmd.verbose, "verbose", "v", false, "enable verbose logging (default: false)")
 	return cmd.cobraCmd
 }
 
-func (cmd *command) validate() error {
+func (cmd *command) validate(tf *tempflag) error {
 	// Validate measurement or sanitize flag.
 	if cmd.measurement == "" && !cmd.sanitize {
 		return fmt.Errorf("--measurement or --sanitize flag required")
 	}
+	if len(tf.fields) > 0 && cmd.measurement == "" {
+		return errors.New("must specify a measurement when field given")
+	}
+	for _, str := range tf.fields {
+		cmd.fields[str] = struct{}{}
+	}
+	if cmd.rewrite && !cmd.sanitize {
+		return errors.New("must specify sanitize when rewrite given")
+	}
+	if !tsmread.ValidMode(cmd.readMode) {
+		return errors.New("tsm-read-mode is invalid, require mmap or buffered")
+	}
+	if cmd.sanitize {
+		s, err := newSanitizer(tf.sanitizePolicy, tf.sanitizeRegexp)
+		if err != nil {
+			return err
+		}
+		cmd.sanitizer = s
+	}
 	return nil
 }
 
-func (cmd *command) runE(args []string) error {
-	if err := cmd.validate(); err != nil {
+func (cmd *command) runE(tf *tempflag, args []string) error {
+	if err := cmd.validate(tf); err != nil {
+		return err
+	}
+	if ok, err := audit.FromCommand(cmd.cobraCmd).Gate(cmd.auditPlan(args)); err != nil || !ok {
 		return err
 	}
 	if !cmd.verbose {
 		log.SetOutput(io.Discard)
 	}
+	cmd.reportOut = io.Discard
+	if cmd.report == stdoutMark {
+		cmd.reportOut = os.Stdout
+	} else if cmd.report != "" {
+		f, err := os.Create(cmd.report)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		bw := bufio.NewWriter(f)
+		defer bw.Flush()
+		cmd.reportOut = bw
+	}
 
 	// Process each TSM file.
 	for _, path := range args {
@@ -71,15 +134,32 @@ func (cmd *command) runE(args []string) error {
 	return nil
 }
 
+// auditPlan returns the audit plan of processing the tsm files of paths, each of which is rewritten into a temporary
+// file replacing it.
+func (cmd *command) auditPlan(paths []string) *audit.Plan {
+	p := &audit.Plan{}
+	if cmd.report != "" && cmd.report != stdoutMark {
+		p.Write(cmd.report, "")
+	}
+	for _, path := range paths {
+		outputPath := path + ".rewriting.tmp"
+		for _, tmp := range []string{outputPath, outputPath + ".idx.tmp"} {
+			if _, err := os.Stat(tmp); err == nil {
+				p.Add(audit.Delete, tmp, "left by a previous run")
+			}
+		}
+		p.Add(audit.Create, outputPath, "")
+		p.Rename(outputPath, path, "replacing the tsm file")
+	}
+	return p
+}
+
 func (cmd *command) process(path string) (retErr error) {
 	// Open TSM reader.
-	input, err := os.Open(path)
-	if err != nil {
+	if _, err := os.Stat(path); err != nil {
 		return err
 	}
-	defer input.Close()
-
-	r, err := tsm1.NewTSMReader(input)
+	r, err := tsmread.Open(path, cmd.readMode)
 	if err != nil {
 		return fmt.Errorf("unable to read %s: %s", path, err)
 	}
@@ -106,30 +186,32 @@ func (cmd *command) process(path string) (retErr error) {
 	}
 	defer w.Close()
 
-	// Iterate over the input blocks.
-	itr := r.BlockIterator()
-	for itr.Next() {
-		// Read key & time range.
-		key, minTime, maxTime, _, _, block, err := itr.Read()
-		if err != nil {
-			return err
-		}
+	// Plan the keys to write, delete or rewrite.
+	keys, err := cmd.plan(path, r)
+	if err != nil {
+		return err
+	}
 
-		// Skip block if this is the measurement and time range we are deleting.
-		series, _ := tsm1.SeriesAndFieldFromCompositeKey(key)
-		measurement, tags := models.ParseKey(series)
-		if string(measurement) == cmd.measurement || (cmd.sanitize && !models.ValidKeyTokens(measurement, tags)) {
-			log.Printf("deleting block: %s (%s-%s) sz=%d",
-				key,
-				time.Unix(0, minTime).UTC().Format(time.RFC3339Nano),
-				time.Unix(0, maxTime).UTC().Format(time.RFC3339Nano),
-				len(block),
-			)
-			continue
-		}
+	// Copy the blocks of planned keys.
+	for _, k := range keys {
+		for _, e := range r.ReadEntries(k.key, nil) {
+			if k.newKey == nil {
+				log.Printf("deleting block: %s (%s-%s) sz=%d",
+					k.key,
+					time.Unix(0, e.MinTime).UTC().Format(time.RFC3339Nano),
+					time.Unix(0, e.MaxTime).UTC().Format(time.RFC3339Nano),
+					e.Size,
+				)
+				continue
+			}
 
-		if err := w.WriteBlock(key, minTime, maxTime, block); err != nil {
-			return err
+			_, block, err := r.ReadBytes(&e, nil)
+			if err != nil {
+				return err
+			}
+			if err := w.WriteBlock(k.newKey, e.MinTime, e.MaxTime, block); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -143,3 +225,82 @@ func (cmd *command) process(path string) (retErr error) {
 	// Replace original file with new file.
 	return os.Rename(outputPath, path)
 }
+
+type plannedKey struct {
+	key    []byte // key in the input file
+	newKey []byte // key in the output file, nil if deleted
+}
+
+// plan decides for every key of r whether it is kept, deleted or rewritten,
+// and returns the keys sorted by the key in the output file.
+func (cmd *command) plan(path string, r tsmread.File) ([]plannedKey, error) {
+	keys := make([]plannedKey, 0, r.KeyCount())
+	rewrites := make([]plannedKey, 0)
+	kept := make(map[string]struct{})
+	for i := 0; i < r.KeyCount(); i++ {
+		key, _ := r.KeyAt(i)
+		series, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+		measurement, tags := models.ParseKeyBytes(series)
+		if cmd.matchField(string(measurement), field) {
+			cmd.reportf("%s: removed key %q, reason: match measurement %q", path, key, cmd.measurement)
+			keys = append(keys, plannedKey{key: key})
+			continue
+		}
+		if cmd.sanitize && !cmd.sanitizer.Valid(measurement, tags) {
+			if cmd.rewrite {
+				if newSeries := cmd.sanitizer.Fix(measurement, tags); newSeries != nil {
+					rewrites = append(rewrites, plannedKey{key: key, newKey: tsm1.SeriesFieldKeyBytes(string(newSeries), string(field))})
+					continue
+				}
+			}
+			cmd.reportf("%s: removed key %q, reason: %s", path, key, cmd.sanitizer.Reason())
+			keys = append(keys, plannedKey{key: key})
+			continue
+		}
+		keys = append(keys, plannedKey{key: key, newKey: key})
+		kept[string(key)] = struct{}{}
+	}
+
+	if len(rewrites) == 0 {
+		return keys, nil
+	}
+	for _, k := range rewrites {
+		// Merging into an existing key may mix block types or overlapping blocks, so remove it instead.
+		if _, ok := kept[string(k.newKey)]; ok {
+			cmd.reportf("%s: removed key %q, reason: %s, rewritten key %q already exists", path, k.key, cmd.sanitizer.Reason(), k.newKey)
+			k.newKey = nil
+		} else {
+			cmd.reportf("%s: rewritten key %q to %q, reason: %s", path, k.key, k.newKey, cmd.sanitizer.Reason())
+			kept[string(k.newKey)] = struct{}{}
+		}
+		keys = append(keys, k)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i].sortKey(), keys[j].sortKey()) < 0
+	})
+	return keys, nil
+}
+
+// sortKey returns the key in the output file, or the key in the input file if deleted.
+func (k plannedKey) sortKey() []byte {
+	if k.newKey != nil {
+		return k.newKey
+	}
+	return k.key
+}
+
+func (cmd *command) reportf(format string, v ...interface{}) {
+	fmt.Fprintf(cmd.reportOut, format+"\n", v...)
+}
+
+// matchField returns true if the block of measurement and field should be deleted.
+func (cmd *command) matchField(measurement string, field []byte) bool {
+	if measurement != cmd.measurement {
+		return false
+	}
+	if len(cmd.fields) == 0 {
+		return true
+	}
+	_, ok := cmd.fields[string(field)]
+	return ok
+}
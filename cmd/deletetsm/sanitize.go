@@ -0,0 +1,124 @@
+package deletetsm
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+const (
+	policyPrintable = "printable"
+	policyUTF8      = "utf8"
+	policyRegexp    = "regexp"
+)
+
+// sanitizer checks and fixes series keys according to a character policy.
+type sanitizer struct {
+	policy string
+	re     *regexp.Regexp
+}
+
+func newSanitizer(policy, expr string) (*sanitizer, error) {
+	s := &sanitizer{policy: policy}
+	switch policy {
+	case policyPrintable, policyUTF8:
+		if expr != "" {
+			return nil, fmt.Errorf("sanitize-regexp cannot be specified with sanitize policy %s", policy)
+		}
+	case policyRegexp:
+		if expr == "" {
+			return nil, fmt.Errorf("sanitize-regexp required with sanitize policy %s", policy)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("sanitize-regexp: %s, compile error: %v", expr, err)
+		}
+		s.re = re
+	default:
+		return nil, fmt.Errorf("sanitize-policy is invalid, require %s, %s or %s", policyPrintable, policyUTF8, policyRegexp)
+	}
+	return s, nil
+}
+
+// Reason returns the reason why a key is considered invalid.
+func (s *sanitizer) Reason() string {
+	switch s.policy {
+	case policyUTF8:
+		return "invalid utf-8 characters"
+	case policyRegexp:
+		return fmt.Sprintf("characters matching regexp %q", s.re.String())
+	default:
+		return "non-printable unicode characters"
+	}
+}
+
+// Valid returns true if the measurement name and all tags are valid.
+func (s *sanitizer) Valid(name []byte, tags models.Tags) bool {
+	if !s.validToken(name) {
+		return false
+	}
+	for _, tag := range tags {
+		if !s.validToken(tag.Key) || !s.validToken(tag.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fix returns a new series key with all invalid characters removed,
+// or nil if the key cannot be fixed, e.g. the measurement name or
+// a tag becomes empty, or two tag keys become the same.
+func (s *sanitizer) Fix(name []byte, tags models.Tags) []byte {
+	name = s.fixToken(name)
+	if len(name) == 0 {
+		return nil
+	}
+	fixed := make(models.Tags, 0, len(tags))
+	for _, tag := range tags {
+		k, v := s.fixToken(tag.Key), s.fixToken(tag.Value)
+		if len(k) == 0 || len(v) == 0 {
+			return nil
+		}
+		fixed = append(fixed, models.NewTag(k, v))
+	}
+	sort.Sort(fixed)
+	for i := 1; i < len(fixed); i++ {
+		if bytes.Equal(fixed[i-1].Key, fixed[i].Key) {
+			return nil
+		}
+	}
+	return models.MakeKey(name, fixed)
+}
+
+func (s *sanitizer) validToken(tok []byte) bool {
+	switch s.policy {
+	case policyUTF8:
+		return utf8.Valid(tok)
+	case policyRegexp:
+		return !s.re.Match(tok)
+	default:
+		return models.ValidKeyToken(string(tok))
+	}
+}
+
+func (s *sanitizer) fixToken(tok []byte) []byte {
+	switch s.policy {
+	case policyUTF8:
+		return bytes.ToValidUTF8(tok, nil)
+	case policyRegexp:
+		return s.re.ReplaceAll(tok, nil)
+	default:
+		return []byte(strings.Map(func(r rune) rune {
+			if !unicode.IsPrint(r) || r == unicode.ReplacementChar {
+				return -1
+			}
+			return r
+		}, strings.ToValidUTF8(string(tok), "")))
+	}
+}
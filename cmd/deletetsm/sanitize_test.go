@@ -0,0 +1,92 @@
+package deletetsm
+
+import (
+	"testing"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+func TestSanitizer(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		expr   string
+		key    string
+		valid  bool
+		fixed  string
+	}{
+		{
+			name:   "printable.valid",
+			policy: policyPrintable,
+			key:    "cpu,host=a",
+			valid:  true,
+			fixed:  "cpu,host=a",
+		},
+		{
+			name:   "printable.measurement",
+			policy: policyPrintable,
+			key:    "c\x01pu,host=a",
+			fixed:  "cpu,host=a",
+		},
+		{
+			name:   "printable.empty",
+			policy: policyPrintable,
+			key:    "cpu,host=\x01",
+			fixed:  "",
+		},
+		{
+			name:   "utf8.printable",
+			policy: policyUTF8,
+			key:    "c\x01pu,host=a",
+			valid:  true,
+			fixed:  "c\x01pu,host=a",
+		},
+		{
+			name:   "utf8.invalid",
+			policy: policyUTF8,
+			key:    "cpu,host=a\xff",
+			fixed:  "cpu,host=a",
+		},
+		{
+			name:   "regexp.tag",
+			policy: policyRegexp,
+			expr:   `[^a-z0-9]`,
+			key:    "cpu,hos-t=a",
+			fixed:  "cpu,host=a",
+		},
+		{
+			name:   "regexp.duplicate",
+			policy: policyRegexp,
+			expr:   `[^a-z0-9]`,
+			key:    "cpu,host=a,host_=b",
+			fixed:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newSanitizer(tt.policy, tt.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			name, tags := models.ParseKeyBytes([]byte(tt.key))
+			if got := s.Valid(name, tags); got != tt.valid {
+				t.Errorf("unexpected valid: got=%v, exp=%v", got, tt.valid)
+			}
+			if got := string(s.Fix(name, tags)); got != tt.fixed {
+				t.Errorf("unexpected fixed: got=%q, exp=%q", got, tt.fixed)
+			}
+		})
+	}
+}
+
+func TestNewSanitizer_Invalid(t *testing.T) {
+	if _, err := newSanitizer("unknown", ""); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+	if _, err := newSanitizer(policyRegexp, ""); err == nil {
+		t.Error("expected error for regexp policy without regexp")
+	}
+	if _, err := newSanitizer(policyPrintable, "[a-z]"); err == nil {
+		t.Error("expected error for regexp without regexp policy")
+	}
+}
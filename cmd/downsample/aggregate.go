@@ -0,0 +1,191 @@
+package downsample
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+const (
+	fnCount = "count"
+	fnFirst = "first"
+	fnLast  = "last"
+	fnMax   = "max"
+	fnMean  = "mean"
+	fnMin   = "min"
+	fnSum   = "sum"
+)
+
+var functions = []string{fnCount, fnFirst, fnLast, fnMax, fnMean, fnMin, fnSum}
+
+func validFunction(fn string) bool {
+	for _, f := range functions {
+		if f == fn {
+			return true
+		}
+	}
+	return false
+}
+
+// supportFunction returns true if the function can aggregate a field of the data type,
+// only count, first and last support boolean and string fields.
+func supportFunction(fn string, typ influxql.DataType) bool {
+	switch fn {
+	case fnCount, fnFirst, fnLast:
+		return true
+	default:
+		return typ == influxql.Float || typ == influxql.Integer || typ == influxql.Unsigned
+	}
+}
+
+// aggregator aggregates the values of a series field into windows of interval,
+// each aggregated value is timestamped with the start time of its window.
+// The values are added in time order across windows, so that each window is aggregated
+// once the values pass it, and only the values of the current window are kept.
+// The aggregated values are emitted by blocks of at most tsdb.DefaultMaxPointsPerBlock.
+type aggregator struct {
+	fn       string
+	interval int64
+	emit     func(values tsm1.Values) error
+	start    int64       // start time of the current window
+	window   tsm1.Values // values of the current window
+	values   tsm1.Values // aggregated values not emitted yet
+}
+
+func newAggregator(fn string, interval time.Duration, emit func(values tsm1.Values) error) *aggregator {
+	return &aggregator{fn: fn, interval: int64(interval), emit: emit}
+}
+
+func (a *aggregator) Reset() {
+	a.window = a.window[:0]
+	a.values = a.values[:0]
+}
+
+// Add adds a value of the current window or a later one, the values of a window may come in any order.
+func (a *aggregator) Add(v tsm1.Value) error {
+	ts := a.windowStart(v.UnixNano())
+	if len(a.window) > 0 && ts != a.start {
+		if ts < a.start {
+			return fmt.Errorf("value at %d is out of order, earlier than the window at %d", v.UnixNano(), a.start)
+		}
+		if err := a.closeWindow(); err != nil {
+			return err
+		}
+	}
+	a.start = ts
+	a.window = append(a.window, v)
+	return nil
+}
+
+// Flush aggregates the current window and emits the aggregated values left.
+func (a *aggregator) Flush() error {
+	if len(a.window) > 0 {
+		if err := a.closeWindow(); err != nil {
+			return err
+		}
+	}
+	if len(a.values) == 0 {
+		return nil
+	}
+	return a.emitValues()
+}
+
+func (a *aggregator) closeWindow() error {
+	a.values = append(a.values, a.aggregate(a.start, a.window.Deduplicate()))
+	a.window = a.window[:0]
+	if len(a.values) < tsdb.DefaultMaxPointsPerBlock {
+		return nil
+	}
+	return a.emitValues()
+}
+
+func (a *aggregator) emitValues() error {
+	err := a.emit(a.values)
+	a.values = a.values[:0]
+	return err
+}
+
+func (a *aggregator) windowStart(ts int64) int64 {
+	r := ts % a.interval
+	if r < 0 {
+		r += a.interval
+	}
+	return ts - r
+}
+
+func (a *aggregator) aggregate(ts int64, vs tsm1.Values) tsm1.Value {
+	switch a.fn {
+	case fnCount:
+		return tsm1.NewIntegerValue(ts, int64(len(vs)))
+	case fnFirst:
+		return tsm1.NewValue(ts, vs[0].Value())
+	case fnLast:
+		return tsm1.NewValue(ts, vs[len(vs)-1].Value())
+	case fnMin, fnMax:
+		v := vs[0]
+		for _, u := range vs[1:] {
+			if less(u, v) == (a.fn == fnMin) {
+				v = u
+			}
+		}
+		return tsm1.NewValue(ts, v.Value())
+	case fnSum:
+		return tsm1.NewValue(ts, sum(vs))
+	default:
+		var s float64
+		for _, v := range vs {
+			s += toFloat(v)
+		}
+		return tsm1.NewFloatValue(ts, s/float64(len(vs)))
+	}
+}
+
+func less(a, b tsm1.Value) bool {
+	switch v := a.Value().(type) {
+	case int64:
+		return v < b.Value().(int64)
+	case uint64:
+		return v < b.Value().(uint64)
+	default:
+		return toFloat(a) < toFloat(b)
+	}
+}
+
+func sum(vs tsm1.Values) interface{} {
+	switch vs[0].Value().(type) {
+	case int64:
+		var s int64
+		for _, v := range vs {
+			s += v.Value().(int64)
+		}
+		return s
+	case uint64:
+		var s uint64
+		for _, v := range vs {
+			s += v.Value().(uint64)
+		}
+		return s
+	default:
+		var s float64
+		for _, v := range vs {
+			s += toFloat(v)
+		}
+		return s
+	}
+}
+
+func toFloat(v tsm1.Value) float64 {
+	switch x := v.Value().(type) {
+	case float64:
+		return x
+	case int64:
+		return float64(x)
+	case uint64:
+		return float64(x)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,144 @@
+package downsample
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+func TestAggregator(t *testing.T) {
+	floats := tsm1.Values{
+		tsm1.NewFloatValue(-3, 6), tsm1.NewFloatValue(0, 4), tsm1.NewFloatValue(5, 2),
+		tsm1.NewFloatValue(9, 3), tsm1.NewFloatValue(12, 1),
+	}
+	// the values of a window may come in any order
+	integers := tsm1.Values{
+		tsm1.NewIntegerValue(9, 3), tsm1.NewIntegerValue(0, 4), tsm1.NewIntegerValue(5, 2),
+		tsm1.NewIntegerValue(12, 1),
+	}
+	tests := []struct {
+		name   string
+		fn     string
+		values tsm1.Values
+		exp    tsm1.Values
+	}{
+		{
+			name:   "mean.float",
+			fn:     fnMean,
+			values: floats,
+			exp:    tsm1.Values{tsm1.NewFloatValue(-10, 6), tsm1.NewFloatValue(0, 3), tsm1.NewFloatValue(10, 1)},
+		},
+		{
+			name:   "mean.integer",
+			fn:     fnMean,
+			values: integers,
+			exp:    tsm1.Values{tsm1.NewFloatValue(0, 3), tsm1.NewFloatValue(10, 1)},
+		},
+		{
+			name:   "sum.integer",
+			fn:     fnSum,
+			values: integers,
+			exp:    tsm1.Values{tsm1.NewIntegerValue(0, 9), tsm1.NewIntegerValue(10, 1)},
+		},
+		{
+			name:   "count",
+			fn:     fnCount,
+			values: floats,
+			exp:    tsm1.Values{tsm1.NewIntegerValue(-10, 1), tsm1.NewIntegerValue(0, 3), tsm1.NewIntegerValue(10, 1)},
+		},
+		{
+			name:   "first.integer",
+			fn:     fnFirst,
+			values: integers,
+			exp:    tsm1.Values{tsm1.NewIntegerValue(0, 4), tsm1.NewIntegerValue(10, 1)},
+		},
+		{
+			name:   "last.integer",
+			fn:     fnLast,
+			values: integers,
+			exp:    tsm1.Values{tsm1.NewIntegerValue(0, 3), tsm1.NewIntegerValue(10, 1)},
+		},
+		{
+			name:   "min.float",
+			fn:     fnMin,
+			values: floats,
+			exp:    tsm1.Values{tsm1.NewFloatValue(-10, 6), tsm1.NewFloatValue(0, 2), tsm1.NewFloatValue(10, 1)},
+		},
+		{
+			name:   "max.integer",
+			fn:     fnMax,
+			values: integers,
+			exp:    tsm1.Values{tsm1.NewIntegerValue(0, 4), tsm1.NewIntegerValue(10, 1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got tsm1.Values
+			agg := newAggregator(tt.fn, 10*time.Nanosecond, func(values tsm1.Values) error {
+				got = append(got, values...)
+				return nil
+			})
+			for _, v := range tt.values {
+				if err := agg.Add(v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := agg.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.exp) {
+				t.Fatalf("unexpected length: got=%d, exp=%d", len(got), len(tt.exp))
+			}
+			for i := range got {
+				if got[i].UnixNano() != tt.exp[i].UnixNano() || !cmp.Equal(got[i].Value(), tt.exp[i].Value()) {
+					t.Errorf("unexpected value at %d: got=%v, exp=%v", i, got[i], tt.exp[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAggregatorEmit(t *testing.T) {
+	var blocks []int
+	agg := newAggregator(fnCount, time.Nanosecond, func(values tsm1.Values) error {
+		blocks = append(blocks, len(values))
+		return nil
+	})
+	// each window is aggregated and emitted by blocks once the values pass it
+	for i := 0; i < 2*tsdb.DefaultMaxPointsPerBlock+1; i++ {
+		if err := agg.Add(tsm1.NewFloatValue(int64(i), 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !cmp.Equal(blocks, []int{tsdb.DefaultMaxPointsPerBlock, tsdb.DefaultMaxPointsPerBlock}) {
+		t.Errorf("unexpected blocks before flush: %v", blocks)
+	}
+	if err := agg.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(blocks, []int{tsdb.DefaultMaxPointsPerBlock, tsdb.DefaultMaxPointsPerBlock, 1}) {
+		t.Errorf("unexpected blocks after flush: %v", blocks)
+	}
+	if err := agg.Add(tsm1.NewFloatValue(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := agg.Add(tsm1.NewFloatValue(-1, 1)); err == nil {
+		t.Error("expected error of the value earlier than the window")
+	}
+}
+
+func TestSupportFunction(t *testing.T) {
+	if !supportFunction(fnLast, influxql.String) {
+		t.Error("last should support string fields")
+	}
+	if supportFunction(fnMean, influxql.Boolean) {
+		t.Error("mean should not support boolean fields")
+	}
+	if !supportFunction(fnSum, influxql.Unsigned) {
+		t.Error("sum should support unsigned fields")
+	}
+}
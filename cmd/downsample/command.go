@@ -0,0 +1,353 @@
+package downsample
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/chengshiwen/influx-tool/internal/snapshot"
+	"github.com/chengshiwen/influx-tool/internal/storage"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/spf13/cobra"
+)
+
+type command struct {
+	cobraCmd              *cobra.Command
+	sourceDir             string
+	targetDir             string
+	database              string
+	retentionPolicy       string
+	targetRetentionPolicy string
+	duration              time.Duration
+	shardDuration         time.Duration
+	interval              time.Duration
+	function              string
+	startTime             int64
+	endTime               int64
+	worker                int
+	skipTsi               bool
+	noBackup              bool
+}
+
+type tempflag struct {
+	start string
+	end   string
+}
+
+func NewCommand() *cobra.Command {
+	tf := &tempflag{}
+	cmd := &command{}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "downsample",
+		Short:         "Downsample influxdb persist data on disk into a new retention policy",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE(tf)
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.sourceDir, "source-dir", "s", "", "source influxdb directory containing meta, data and wal (required)")
+	flags.StringVarP(&cmd.targetDir, "target-dir", "t", "", "target influxdb directory containing meta, data and wal, different from source-dir (required)")
+	flags.StringVarP(&cmd.database, "database", "d", "", "database name (required)")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "", "source retention policy (default: default retention policy of database)")
+	flags.StringVarP(&cmd.targetRetentionPolicy, "target-retention-policy", "R", "", "target retention policy to write downsampled data (required)")
+	flags.DurationVar(&cmd.duration, "duration", time.Hour*0, "target retention policy duration (default: 0)")
+	flags.DurationVar(&cmd.shardDuration, "shard-duration", time.Hour*24*7, "target retention policy shard duration, must be a multiple of interval")
+	flags.DurationVarP(&cmd.interval, "interval", "i", time.Hour, "interval to aggregate each field per")
+	flags.StringVarP(&cmd.function, "function", "f", fnMean, "aggregation function: "+strings.Join(functions, ", "))
+	flags.StringVarP(&tf.start, "start", "S", "", "start time to downsample (RFC3339 format, optional)")
+	flags.StringVarP(&tf.end, "end", "E", "", "end time to downsample (RFC3339 format, optional)")
+	flags.IntVarP(&cmd.worker, "worker", "w", 0, "number of concurrent workers to downsample (default: 0, unlimited)")
+	flags.BoolVar(&cmd.skipTsi, "skip-tsi", false, "skip building TSI index on disk (default: false)")
+	flags.BoolVar(&cmd.noBackup, "no-backup", false, "skip the snapshot of the meta of target-dir into its .influx-tool-backup/<time> before written if exists (default: false)")
+	cmd.cobraCmd.MarkFlagRequired("source-dir")
+	cmd.cobraCmd.MarkFlagRequired("target-dir")
+	cmd.cobraCmd.MarkFlagRequired("database")
+	cmd.cobraCmd.MarkFlagRequired("target-retention-policy")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) validate(tf *tempflag) error {
+	if tf.start != "" {
+		s, err := time.Parse(time.RFC3339, tf.start)
+		if err != nil {
+			return errors.New("start time is invalid")
+		}
+		cmd.startTime = s.UnixNano()
+	} else {
+		cmd.startTime = math.MinInt64
+	}
+	if tf.end != "" {
+		e, err := time.Parse(time.RFC3339, tf.end)
+		if err != nil {
+			return errors.New("end time is invalid")
+		}
+		cmd.endTime = e.UnixNano()
+	} else {
+		cmd.endTime = math.MaxInt64
+	}
+	if cmd.startTime != 0 && cmd.endTime != 0 && cmd.endTime < cmd.startTime {
+		return errors.New("end time before start time")
+	}
+
+	if filepath.Clean(cmd.sourceDir) == filepath.Clean(cmd.targetDir) {
+		return errors.New("target-dir cannot be the same as source-dir")
+	}
+	if cmd.interval <= 0 {
+		return errors.New("interval is invalid")
+	}
+	if cmd.shardDuration <= 0 || cmd.shardDuration%cmd.interval != 0 {
+		return errors.New("shard-duration is invalid, require a multiple of interval")
+	}
+	if !validFunction(cmd.function) {
+		return fmt.Errorf("function is invalid, require %s", strings.Join(functions, ", "))
+	}
+	if cmd.worker < 0 {
+		return errors.New("worker is invalid")
+	}
+	return nil
+}
+
+func (cmd *command) runE(tf *tempflag) error {
+	if err := cmd.validate(tf); err != nil {
+		return err
+	}
+	exportServer, err := server.NewServer(cmd.sourceDir, !cmd.skipTsi)
+	if err != nil {
+		return err
+	}
+	defer exportServer.Close()
+
+	client := exportServer.MetaClient()
+	dbi := client.Database(cmd.database)
+	if dbi == nil {
+		return fmt.Errorf("database '%s' does not exist", cmd.database)
+	}
+	if cmd.retentionPolicy == "" {
+		// select default rp
+		cmd.retentionPolicy = dbi.DefaultRetentionPolicy
+	}
+	rpi, err := client.RetentionPolicy(cmd.database, cmd.retentionPolicy)
+	if rpi == nil || err != nil {
+		return fmt.Errorf("retention policy '%s' does not exist", cmd.retentionPolicy)
+	}
+	groups, err := client.ShardGroupsByTimeRange(cmd.database, cmd.retentionPolicy, time.Unix(0, models.MinNanoTime), time.Unix(0, models.MaxNanoTime))
+	if err != nil {
+		return err
+	}
+	sort.Sort(meta.ShardGroupInfos(groups))
+
+	if !cmd.noBackup {
+		path, err := snapshot.Take(cmd.targetDir, snapshot.ID(time.Now()), nil)
+		if err != nil {
+			return fmt.Errorf("backup error: %v", err)
+		}
+		if path != "" {
+			log.Printf("target meta backed up to %s", path)
+		}
+	}
+	importServer, err := server.NewServer(cmd.targetDir, !cmd.skipTsi)
+	if err != nil {
+		return err
+	}
+	defer importServer.Close()
+	imp, err := shard.NewImporter(importServer, cmd.database, cmd.targetRetentionPolicy, cmd.shardDuration, cmd.duration, !cmd.skipTsi, false)
+	if err != nil {
+		return err
+	}
+	defer imp.Close()
+
+	return cmd.downsample(exportServer.TSDBConfig(), groups, imp)
+}
+
+// downsample downsamples the target shard groups with up to worker in parallel, and returns the errors of the shard
+// groups failed, the other shard groups are still downsampled.
+func (cmd *command) downsample(tsdbConfig tsdb.Config, sourceGroups []meta.ShardGroupInfo, imp *shard.Importer) error {
+	log.SetFlags(log.LstdFlags)
+	log.Printf("downsample %s.%s into %s.%s with %s(%s)", cmd.database, cmd.retentionPolicy, cmd.database, cmd.targetRetentionPolicy, cmd.function, cmd.interval)
+	start := time.Now().UTC()
+	defer func() {
+		elapsed := time.Since(start)
+		if elapsed.Minutes() > 10 {
+			log.Printf("total time: %0.1f minutes", elapsed.Minutes())
+		} else {
+			log.Printf("total time: %0.1f seconds", elapsed.Seconds())
+		}
+	}()
+
+	targetGroups := shard.PlanShardGroups(sourceGroups, cmd.shardDuration, cmd.startTime, cmd.endTime)
+	log.Printf("total shard groups: %d", len(targetGroups))
+	limit := make(chan struct{}, cmd.worker)
+	wg := &sync.WaitGroup{}
+	el := errlist.NewErrorList()
+	mu := &sync.Mutex{}
+	for _, g := range targetGroups {
+		g := g
+		wg.Add(1)
+		go func() {
+			if cmd.worker > 0 {
+				limit <- struct{}{}
+			}
+			defer func() {
+				wg.Done()
+				if cmd.worker > 0 {
+					<-limit
+				}
+			}()
+
+			min, max := g.StartTime, g.EndTime
+			if err := cmd.downsampleShardGroup(tsdbConfig, sourceGroups, imp, min, max); err != nil {
+				log.Printf("downsample error: %s, shard group: %d, min: %d, max: %d", err, g.ID, min.Unix(), max.Unix())
+				mu.Lock()
+				el.Add(fmt.Errorf("shard group %d downsample error: %v", g.ID, err))
+				mu.Unlock()
+				return
+			}
+			log.Printf("shard group done: %d", g.ID)
+		}()
+	}
+	wg.Wait()
+	if err := el.Err(); err != nil {
+		return err
+	}
+	log.Print("downsample done")
+	return nil
+}
+
+func (cmd *command) downsampleShardGroup(tsdbConfig tsdb.Config, sourceGroups []meta.ShardGroupInfo, imp *shard.Importer, min, max time.Time) error {
+	// clamp the time range to read, aggregated windows are still aligned to interval
+	start, end := min.UnixNano(), max.UnixNano()-1
+	if cmd.startTime > start {
+		start = cmd.startTime
+	}
+	if cmd.endTime < end {
+		end = cmd.endTime
+	}
+
+	sr := storage.NewReader(tsdbConfig, cmd.database, cmd.retentionPolicy, sourceGroups)
+	if err := sr.Open(); err != nil {
+		return err
+	}
+	defer sr.Close()
+	rs, err := sr.Read(time.Unix(0, start), time.Unix(0, end))
+	if err != nil {
+		return err
+	}
+	if rs == nil {
+		return nil
+	}
+	defer rs.Close()
+
+	iw := shard.NewImportWorker(imp)
+	if err = iw.StartShardGroup(min.UnixNano(), max.UnixNano()); err != nil {
+		return err
+	}
+	defer iw.Close()
+
+	// the series is added before the first aggregated values written, so the series without values are skipped
+	var seriesKey, key []byte
+	var added bool
+	agg := newAggregator(cmd.function, cmd.interval, func(values tsm1.Values) error {
+		if !added {
+			if err := iw.AddSeries(seriesKey); err != nil {
+				return err
+			}
+			added = true
+		}
+		return iw.Write(key, values)
+	})
+	for rs.Next() {
+		if !supportFunction(cmd.function, rs.FieldType()) {
+			continue
+		}
+		seriesKey = models.MakeKey(rs.Name(), rs.Tags())
+		key = tsm1.SeriesFieldKeyBytes(string(seriesKey), string(rs.Field()))
+		added = false
+		agg.Reset()
+		if err = readCursors(rs.CursorIterator(), agg); err != nil {
+			return err
+		}
+		if err = agg.Flush(); err != nil {
+			return err
+		}
+	}
+	return iw.CloseShardGroup()
+}
+
+func readCursors(ci *storage.CursorIterator, agg *aggregator) error {
+	for ci.Next() {
+		if err := readCursor(ci.Cursor(), agg); err != nil {
+			return err
+		}
+	}
+	return ci.Err()
+}
+
+// readCursor adds the values of the cursor to the aggregator and closes the cursor.
+func readCursor(cur tsdb.Cursor, agg *aggregator) error {
+	if cur == nil {
+		// no data for series key + field combination in this shard
+		return nil
+	}
+	defer cur.Close()
+	switch c := cur.(type) {
+	case tsdb.FloatArrayCursor:
+		for a := c.Next(); a.Len() > 0; a = c.Next() {
+			for i, ts := range a.Timestamps {
+				if err := agg.Add(tsm1.NewFloatValue(ts, a.Values[i])); err != nil {
+					return err
+				}
+			}
+		}
+	case tsdb.IntegerArrayCursor:
+		for a := c.Next(); a.Len() > 0; a = c.Next() {
+			for i, ts := range a.Timestamps {
+				if err := agg.Add(tsm1.NewIntegerValue(ts, a.Values[i])); err != nil {
+					return err
+				}
+			}
+		}
+	case tsdb.UnsignedArrayCursor:
+		for a := c.Next(); a.Len() > 0; a = c.Next() {
+			for i, ts := range a.Timestamps {
+				if err := agg.Add(tsm1.NewUnsignedValue(ts, a.Values[i])); err != nil {
+					return err
+				}
+			}
+		}
+	case tsdb.BooleanArrayCursor:
+		for a := c.Next(); a.Len() > 0; a = c.Next() {
+			for i, ts := range a.Timestamps {
+				if err := agg.Add(tsm1.NewBooleanValue(ts, a.Values[i])); err != nil {
+					return err
+				}
+			}
+		}
+	case tsdb.StringArrayCursor:
+		for a := c.Next(); a.Len() > 0; a = c.Next() {
+			for i, ts := range a.Timestamps {
+				if err := agg.Add(tsm1.NewStringValue(ts, a.Values[i])); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unreachable: %T", c)
+	}
+	return cur.Err()
+}
@@ -0,0 +1,50 @@
+package downsample
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+)
+
+func TestDownsampleError(t *testing.T) {
+	dir := t.TempDir()
+	svr, err := server.NewServer(filepath.Join(dir, "target"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	imp, err := shard.NewImporter(svr, "db", "rp", time.Hour, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imp.Close()
+
+	// the source data directory is a file, so that the shard groups fail to be read
+	dataDir := filepath.Join(dir, "data")
+	if err = os.WriteFile(dataDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := tsdb.NewConfig()
+	config.Dir = dataDir
+	groups := []meta.ShardGroupInfo{
+		{ID: 1, StartTime: time.Unix(0, 0), EndTime: time.Unix(3600, 0), Shards: []meta.ShardInfo{{ID: 1}}},
+		{ID: 2, StartTime: time.Unix(3600, 0), EndTime: time.Unix(7200, 0), Shards: []meta.ShardInfo{{ID: 2}}},
+	}
+	cmd := &command{database: "db", retentionPolicy: "autogen", targetRetentionPolicy: "rp", shardDuration: time.Hour,
+		interval: time.Minute, function: fnMean, startTime: math.MinInt64, endTime: math.MaxInt64, worker: 1}
+	err = cmd.downsample(config, groups, imp)
+	if err == nil {
+		t.Fatal("expected error of the shard groups failed")
+	}
+	if n := strings.Count(err.Error(), "downsample error"); n != 2 {
+		t.Errorf("got %d errors, expected 2: %v", n, err)
+	}
+}
@@ -0,0 +1,370 @@
+package enforceretention
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/audit"
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/snapshot"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/spf13/cobra"
+)
+
+type command struct {
+	cobraCmd       *cobra.Command
+	dir            string
+	database       string
+	durations      map[string]time.Duration
+	updateDuration bool
+	now            time.Time
+	force          bool
+	noBackup       bool
+}
+
+type tempflag struct {
+	durations []string
+	now       string
+}
+
+func NewCommand() *cobra.Command {
+	tf := &tempflag{}
+	cmd := &command{durations: make(map[string]time.Duration)}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "enforce-retention",
+		Short:         "Enforce retention policies by deleting expired shards on disk",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Annotations:   map[string]string{audit.Annotation: ""},
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE(tf)
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.dir, "dir", "D", "", "influxdb directory containing meta, data and wal (required)")
+	flags.StringVarP(&cmd.database, "database", "d", "", "database to enforce retention (default: all)")
+	flags.StringArrayVarP(&tf.durations, "policy-duration", "p", []string{}, "target duration of retention policy like [db.]rp=720h, can be set multiple times (default: duration of retention policy)")
+	flags.BoolVar(&cmd.updateDuration, "update-duration", false, "update the duration of retention policy to its target duration (default: false)")
+	flags.StringVar(&tf.now, "now", "", "current time to determine expired shards (RFC3339 format, default: now)")
+	flags.BoolVarP(&cmd.force, "force", "f", false, "force deletion without prompting (default: false)")
+	flags.BoolVar(&cmd.noBackup, "no-backup", false, "skip the snapshot of meta.db into .influx-tool-backup/<time> of dir before the meta is edited (default: false)")
+	cmd.cobraCmd.MarkFlagRequired("dir")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) validate(tf *tempflag) error {
+	if tf.now != "" {
+		t, err := time.Parse(time.RFC3339, tf.now)
+		if err != nil {
+			return errors.New("now time is invalid")
+		}
+		cmd.now = t
+	} else {
+		cmd.now = time.Now().UTC()
+	}
+	for _, str := range tf.durations {
+		key, value, ok := strings.Cut(str, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("policy-duration: %s is invalid, require [db.]rp=duration", str)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("policy-duration: %s is invalid, duration error: %v", str, err)
+		}
+		cmd.durations[key] = d
+	}
+	if cmd.updateDuration && len(cmd.durations) == 0 {
+		return errors.New("must specify policy duration when update duration given")
+	}
+	return nil
+}
+
+// expiredGroup is a shard group to delete with the size of its shards on disk.
+type expiredGroup struct {
+	db, rp   string
+	group    meta.ShardGroupInfo
+	deleted  bool // already deleted from meta
+	dataSize int64
+	walSize  int64
+}
+
+func (cmd *command) runE(tf *tempflag) error {
+	if err := cmd.validate(tf); err != nil {
+		return err
+	}
+	svr, err := server.NewServer(cmd.dir, false)
+	if err != nil {
+		return err
+	}
+	defer svr.Close()
+
+	log.SetFlags(0)
+	groups, err := cmd.plan(svr)
+	if err != nil {
+		return err
+	}
+	opts := audit.FromCommand(cmd.cobraCmd)
+	if len(groups) == 0 {
+		log.Print("no expired shard groups found")
+		if !cmd.updateDuration {
+			return nil
+		}
+		if opts.Planned() {
+			if ok, err := opts.Gate(cmd.auditPlan(svr, groups)); err != nil || !ok {
+				return err
+			}
+		}
+		if err := cmd.backup(); err != nil {
+			return err
+		}
+		return cmd.updateDurations(svr.MetaClient())
+	}
+	cmd.report(groups)
+
+	if opts.Planned() {
+		if ok, err := opts.Gate(cmd.auditPlan(svr, groups)); err != nil || !ok {
+			return err
+		}
+	} else if !cmd.force {
+		fmt.Print("proceed? [N] ")
+		scan := bufio.NewScanner(os.Stdin)
+		scan.Scan()
+		if scan.Err() != nil {
+			return fmt.Errorf("error reading stdin: %v", scan.Err())
+		}
+
+		if strings.ToLower(scan.Text()) != "y" {
+			return nil
+		}
+	}
+
+	if err := cmd.backup(); err != nil {
+		return err
+	}
+	if err := cmd.enforce(svr, groups); err != nil {
+		return err
+	}
+	return cmd.updateDurations(svr.MetaClient())
+}
+
+// backup snapshots the meta before edited, the shards deleted are not backed up.
+func (cmd *command) backup() error {
+	if cmd.noBackup {
+		return nil
+	}
+	path, err := snapshot.Take(cmd.dir, snapshot.ID(time.Now()), nil)
+	if err != nil {
+		return fmt.Errorf("backup error: %v", err)
+	}
+	if path != "" {
+		log.Printf("meta backed up to %s", path)
+	}
+	return nil
+}
+
+// plan returns the expired shard groups and the deleted shard groups whose shards are still on disk.
+func (cmd *command) plan(svr *server.Server) ([]*expiredGroup, error) {
+	client := svr.MetaClient()
+	config := svr.TSDBConfig()
+	if cmd.database != "" && client.Database(cmd.database) == nil {
+		return nil, fmt.Errorf("database '%s' does not exist", cmd.database)
+	}
+
+	var groups []*expiredGroup
+	for _, dbi := range client.Databases() {
+		if cmd.database != "" && dbi.Name != cmd.database {
+			continue
+		}
+		for _, rpi := range dbi.RetentionPolicies {
+			d := cmd.targetDuration(dbi.Name, rpi)
+			for _, g := range rpi.ShardGroups {
+				deleted := g.Deleted()
+				if !deleted && (d == 0 || !g.EndTime.Add(d).Before(cmd.now)) {
+					continue
+				}
+				eg := &expiredGroup{db: dbi.Name, rp: rpi.Name, group: g, deleted: deleted}
+				for _, sh := range g.Shards {
+					id := strconv.FormatUint(sh.ID, 10)
+					eg.dataSize += dirSize(filepath.Join(config.Dir, dbi.Name, rpi.Name, id))
+					eg.walSize += dirSize(filepath.Join(config.WALDir, dbi.Name, rpi.Name, id))
+				}
+				if deleted && eg.dataSize == 0 && eg.walSize == 0 {
+					continue
+				}
+				groups = append(groups, eg)
+			}
+		}
+	}
+	return groups, nil
+}
+
+func (cmd *command) targetDuration(db string, rpi meta.RetentionPolicyInfo) time.Duration {
+	if d, ok := cmd.durations[db+"."+rpi.Name]; ok {
+		return d
+	}
+	if d, ok := cmd.durations[rpi.Name]; ok {
+		return d
+	}
+	return rpi.Duration
+}
+
+func (cmd *command) report(groups []*expiredGroup) {
+	var dataSize, walSize int64
+	var shards int
+	for _, eg := range groups {
+		status := "expired"
+		if eg.deleted {
+			status = "deleted"
+		}
+		log.Printf("%s.%s: shard group %d (%s - %s) %s, shards: %d, data: %s, wal: %s", eg.db, eg.rp, eg.group.ID,
+			eg.group.StartTime.Format(time.RFC3339), eg.group.EndTime.Format(time.RFC3339), status,
+			len(eg.group.Shards), formatSize(eg.dataSize), formatSize(eg.walSize))
+		dataSize += eg.dataSize
+		walSize += eg.walSize
+		shards += len(eg.group.Shards)
+	}
+	log.Printf("total: %d shard groups, %d shards, data: %s, wal: %s, reclaimed: %s", len(groups), shards,
+		formatSize(dataSize), formatSize(walSize), formatSize(dataSize+walSize))
+}
+
+// auditPlan returns the audit plan of deleting the shards of the groups and updating the durations.
+func (cmd *command) auditPlan(svr *server.Server, groups []*expiredGroup) *audit.Plan {
+	config := svr.TSDBConfig()
+	p := &audit.Plan{}
+	if !cmd.noBackup && snapshot.Exists(cmd.dir) {
+		p.Add(audit.Create, filepath.Join(cmd.dir, snapshot.Directory), "snapshot of meta.db")
+	}
+	var dbs []string
+	var expired int
+	for _, eg := range groups {
+		for _, sh := range eg.group.Shards {
+			id := strconv.FormatUint(sh.ID, 10)
+			for _, path := range []string{filepath.Join(config.Dir, eg.db, eg.rp, id), filepath.Join(config.WALDir, eg.db, eg.rp, id)} {
+				if _, err := os.Stat(path); err == nil {
+					p.Add(audit.Delete, path, "")
+				}
+			}
+		}
+		if len(dbs) == 0 || dbs[len(dbs)-1] != eg.db {
+			dbs = append(dbs, eg.db)
+		}
+		if !eg.deleted {
+			expired++
+		}
+	}
+	for _, db := range dbs {
+		p.Add(audit.Rewrite, filepath.Join(config.Dir, db, tsdb.SeriesFileDirectory), "series of the shards deleted removed")
+	}
+	var notes []string
+	if expired > 0 {
+		notes = append(notes, fmt.Sprintf("%d shard groups deleted", expired))
+	}
+	if cmd.updateDuration {
+		notes = append(notes, "durations updated")
+	}
+	if len(notes) > 0 {
+		p.Add(audit.Rewrite, snapshot.MetaPath(cmd.dir), strings.Join(notes, ", "))
+	}
+	return p
+}
+
+func (cmd *command) enforce(svr *server.Server, groups []*expiredGroup) error {
+	client := svr.MetaClient()
+	stores := make(map[string]*tsdb.Store)
+	defer func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	}()
+
+	el := errlist.NewErrorList()
+	for _, eg := range groups {
+		store, ok := stores[eg.db]
+		if !ok {
+			store = newStore(svr.TSDBConfig(), eg.db)
+			if err := store.Open(); err != nil {
+				return err
+			}
+			stores[eg.db] = store
+		}
+		// store deletes shards with both data and wal, and removes their series no longer used from series file
+		for _, sh := range eg.group.Shards {
+			el.Add(store.DeleteShard(sh.ID))
+		}
+		if !eg.deleted {
+			el.Add(client.DeleteShardGroup(eg.db, eg.rp, eg.group.ID))
+		}
+		log.Printf("%s.%s: shard group %d deleted", eg.db, eg.rp, eg.group.ID)
+	}
+	return el.Err()
+}
+
+func (cmd *command) updateDurations(client *meta.Client) error {
+	if !cmd.updateDuration {
+		return nil
+	}
+	for _, dbi := range client.Databases() {
+		if cmd.database != "" && dbi.Name != cmd.database {
+			continue
+		}
+		for _, rpi := range dbi.RetentionPolicies {
+			d := cmd.targetDuration(dbi.Name, rpi)
+			if d == rpi.Duration {
+				continue
+			}
+			if err := client.UpdateRetentionPolicy(dbi.Name, rpi.Name, &meta.RetentionPolicyUpdate{Duration: &d}, false); err != nil {
+				return fmt.Errorf("update retention policy %s.%s error: %v", dbi.Name, rpi.Name, err)
+			}
+			log.Printf("%s.%s: duration updated from %s to %s", dbi.Name, rpi.Name, rpi.Duration, d)
+		}
+	}
+	return nil
+}
+
+func newStore(tsdbConfig tsdb.Config, db string) *tsdb.Store {
+	store := tsdb.NewStore(tsdbConfig.Dir)
+	store.EngineOptions.MonitorDisabled = true
+	store.EngineOptions.CompactionDisabled = true
+	store.EngineOptions.Config = tsdbConfig
+	store.EngineOptions.EngineVersion = tsdbConfig.Engine
+	store.EngineOptions.IndexVersion = tsdbConfig.Index
+	store.EngineOptions.DatabaseFilter = func(database string) bool {
+		return database == db
+	}
+	return store
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,102 @@
+package enforceretention
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/audit"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/spf13/cobra"
+)
+
+// enforce runs enforce-retention as a subcommand of a root command with the audit flags.
+func enforce(t *testing.T, args ...string) {
+	root := &cobra.Command{Use: "root"}
+	root.PersistentFlags().Bool(audit.PlanFlag, false, "")
+	root.PersistentFlags().String(audit.ApproveFlag, "", "")
+	root.PersistentFlags().Bool(audit.DryRunFlag, false, "")
+	root.AddCommand(NewCommand())
+	root.SetArgs(append([]string{"enforce-retention"}, args...))
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	dir := t.TempDir()
+	svr, err := server.NewServer(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := svr.MetaClient()
+	if _, err = client.CreateDatabaseWithRetentionPolicy("db", &meta.RetentionPolicySpec{Name: "rp", Duration: durationPtr(24 * time.Hour), ShardGroupDuration: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	// the shard group ended 2 days before now is expired, the one ended at now is live
+	expired, err := client.CreateShardGroup("db", "rp", now.Add(-49*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := client.CreateShardGroup("db", "rp", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.Close()
+	shardPaths := func(g *meta.ShardGroupInfo) []string {
+		id := strconv.FormatUint(g.Shards[0].ID, 10)
+		return []string{filepath.Join(dir, "data", "db", "rp", id), filepath.Join(dir, "wal", "db", "rp", id)}
+	}
+	for _, path := range append(shardPaths(expired), shardPaths(live)...) {
+		if err = os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(name string, deleted bool) {
+		for _, path := range shardPaths(live) {
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("%s: live shard removed: %v", name, err)
+			}
+		}
+		for _, path := range shardPaths(expired) {
+			if _, err := os.Stat(path); os.IsNotExist(err) != deleted {
+				t.Errorf("%s: expired shard %s deleted %v, expected %v", name, path, !deleted, deleted)
+			}
+		}
+		svr, err := server.NewServer(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer svr.Close()
+		groups, err := svr.MetaClient().ShardGroupsByTimeRange("db", "rp", now.Add(-72*time.Hour), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make(map[uint64]bool)
+		for _, g := range groups {
+			ids[g.ID] = true
+		}
+		if !ids[live.ID] || ids[expired.ID] != !deleted {
+			t.Errorf("%s: unexpected shard groups in meta: %v", name, ids)
+		}
+	}
+
+	// nothing is expired by a longer target duration
+	enforce(t, "-D", dir, "--now", now.Format(time.RFC3339), "-p", "rp=72h", "-f", "--no-backup")
+	check("longer duration", false)
+
+	// nothing is deleted on dry run
+	enforce(t, "-D", dir, "--now", now.Format(time.RFC3339), "--dry-run")
+	check("dry run", false)
+
+	enforce(t, "-D", dir, "--now", now.Format(time.RFC3339), "-f", "--no-backup")
+	check("enforce", true)
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
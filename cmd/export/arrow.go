@@ -0,0 +1,243 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+const (
+	arrowTimeColumn  = "time"
+	arrowFieldColumn = "field"
+	arrowBatchRows   = 64 * 1024
+)
+
+// arrowValueColumns are the columns of the values by type, only the ones of the types in a batch are in its schema.
+var arrowValueColumns = []struct {
+	name string
+	typ  arrow.DataType
+}{
+	{"value_float", arrow.PrimitiveTypes.Float64},
+	{"value_integer", arrow.PrimitiveTypes.Int64},
+	{"value_unsigned", arrow.PrimitiveTypes.Uint64},
+	{"value_boolean", arrow.FixedWidthTypes.Boolean},
+	{"value_string", arrow.BinaryTypes.String},
+}
+
+// arrowSeries is the values of a field of a series buffered into a batch.
+type arrowSeries struct {
+	tags   models.Tags
+	field  string
+	values []tsm1.Value
+}
+
+// arrowWriter writes the values of the series of a shard as arrow record batches, one per measurement of at most
+// arrowBatchRows rows, the series of a measurement read consecutively are in the same batch. A row is a value of a
+// field, the columns are the time, the tags of the series in the batch, the field and the value column of its type,
+// and the database, retention policy and measurement are in the metadata of the schema. Each batch is written to w
+// as a frame of an arrow ipc stream of its own, prefixed by its length in 4 bytes big endian.
+type arrowWriter struct {
+	cmd    *command
+	w      io.Writer
+	db, rp string
+	mem    memory.Allocator
+	buf    bytes.Buffer
+
+	name   []byte // measurement of the batch
+	series []byte // escaped series key of the last values
+	tags   models.Tags
+	batch  []arrowSeries
+	rows   int
+}
+
+func (cmd *command) newArrowWriter(w io.Writer, db, rp string) *arrowWriter {
+	return &arrowWriter{cmd: cmd, w: w, db: db, rp: rp, mem: memory.NewGoAllocator()}
+}
+
+// add buffers the values of the field of the line prefix "<series_key> <field>=" into the batch of its measurement.
+func (aw *arrowWriter) add(prefix []byte, values []tsm1.Value) error {
+	if len(values) == 0 {
+		return nil
+	}
+	n := seriesLen(prefix)
+	series, field := prefix[:n], escape.Unescape(prefix[n+1:len(prefix)-1])
+	if !bytes.Equal(series, aw.series) {
+		name, tags := models.ParseKeyBytes(series)
+		if !bytes.Equal(name, aw.name) {
+			if err := aw.flush(); err != nil {
+				return err
+			}
+			aw.name = name
+		}
+		aw.series, aw.tags = append(aw.series[:0], series...), tags
+	}
+	// the values may be reused by the source once returned
+	aw.batch = append(aw.batch, arrowSeries{tags: aw.tags, field: string(field), values: append([]tsm1.Value(nil), values...)})
+	aw.rows += len(values)
+	if aw.rows >= arrowBatchRows {
+		return aw.flush()
+	}
+	return nil
+}
+
+// flush writes the values buffered as a record batch.
+func (aw *arrowWriter) flush() error {
+	if aw.rows == 0 {
+		return nil
+	}
+	defer func() {
+		aw.batch, aw.rows = aw.batch[:0], 0
+	}()
+
+	tagIdx := make(map[string]int)
+	valueIdx := make(map[int]int) // column index by value column
+	for _, s := range aw.batch {
+		for _, tag := range s.tags {
+			tagIdx[string(tag.Key)] = 0
+		}
+		valueIdx[arrowValueColumn(s.values[0])] = 0
+	}
+	fields := []arrow.Field{{Name: arrowTimeColumn, Type: arrow.FixedWidthTypes.Timestamp_ns}}
+	tagKeys := sortedKeys(tagIdx)
+	for _, key := range tagKeys {
+		tagIdx[key] = len(fields)
+		fields = append(fields, arrow.Field{Name: key, Type: arrow.BinaryTypes.String, Nullable: true})
+	}
+	fields = append(fields, arrow.Field{Name: arrowFieldColumn, Type: arrow.BinaryTypes.String})
+	for i, col := range arrowValueColumns {
+		if _, ok := valueIdx[i]; ok {
+			valueIdx[i] = len(fields)
+			fields = append(fields, arrow.Field{Name: col.name, Type: col.typ, Nullable: true})
+		}
+	}
+	md := arrow.NewMetadata([]string{"database", "retention_policy", "measurement"}, []string{aw.db, aw.rp, string(aw.name)})
+	schema := arrow.NewSchema(fields, &md)
+
+	builders := make([]array.Builder, len(fields))
+	for i, f := range fields {
+		builders[i] = array.NewBuilder(aw.mem, f.Type)
+		defer builders[i].Release()
+		builders[i].Reserve(aw.rows)
+	}
+	tagValues := make([]string, len(fields))
+	written := 0
+	for _, s := range aw.batch {
+		for i := range tagValues {
+			tagValues[i] = ""
+		}
+		for _, tag := range s.tags {
+			tagValues[tagIdx[string(tag.Key)]] = string(tag.Value)
+		}
+		col := valueIdx[arrowValueColumn(s.values[0])]
+		for _, v := range s.values {
+			builders[0].(*array.TimestampBuilder).Append(arrow.Timestamp(v.UnixNano()))
+			for _, key := range tagKeys {
+				i := tagIdx[key]
+				if tagValues[i] == "" {
+					builders[i].AppendNull()
+				} else {
+					builders[i].(*array.StringBuilder).Append(tagValues[i])
+				}
+			}
+			builders[len(tagKeys)+1].(*array.StringBuilder).Append(s.field)
+			for _, i := range valueIdx {
+				if i != col {
+					builders[i].AppendNull()
+				}
+			}
+			switch v := v.Value().(type) {
+			case float64:
+				builders[col].(*array.Float64Builder).Append(v)
+			case int64:
+				builders[col].(*array.Int64Builder).Append(v)
+			case uint64:
+				builders[col].(*array.Uint64Builder).Append(v)
+			case bool:
+				builders[col].(*array.BooleanBuilder).Append(v)
+			case string:
+				builders[col].(*array.StringBuilder).Append(v)
+			}
+			written++
+		}
+	}
+	cols := make([]arrow.Array, len(builders))
+	for i, b := range builders {
+		cols[i] = b.NewArray()
+		defer cols[i].Release()
+	}
+	rec := array.NewRecord(schema, cols, int64(written))
+	defer rec.Release()
+
+	aw.buf.Reset()
+	aw.buf.Write(make([]byte, 4))
+	iw := ipc.NewWriter(&aw.buf, ipc.WithSchema(schema), ipc.WithAllocator(aw.mem))
+	if err := iw.Write(rec); err != nil {
+		return err
+	}
+	if err := iw.Close(); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(aw.buf.Bytes(), uint32(aw.buf.Len()-4))
+	aw.cmd.addWritten(written, aw.buf.Len())
+	_, err := aw.w.Write(aw.buf.Bytes())
+	return err
+}
+
+// arrowValueColumn returns the index of the value column of the type of the value in arrowValueColumns.
+func arrowValueColumn(v tsm1.Value) int {
+	switch v.(type) {
+	case tsm1.FloatValue:
+		return 0
+	case tsm1.IntegerValue:
+		return 1
+	case tsm1.UnsignedValue:
+		return 2
+	case tsm1.BooleanValue:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// readArrow reads the values of the shard from source and writes the record batches of its measurements to w with
+// the line prefixes cached in prefixes.
+func (cmd *command) readArrow(sh *source.Shard, w io.Writer, prefixes *prefixCache) error {
+	aw := cmd.newArrowWriter(w, sh.Database, sh.RetentionPolicy)
+	if err := cmd.readSource(sh, cmd.handleSeries(prefixes, aw.add)); err != nil {
+		return err
+	}
+	return aw.flush()
+}
+
+// frameWriter splits the frames written by arrowWriter, and calls send with each arrow ipc stream once complete.
+type frameWriter struct {
+	send func(msg []byte) error
+	buf  []byte
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	fw.buf = append(fw.buf, p...)
+	for len(fw.buf) >= 4 {
+		n := int(binary.BigEndian.Uint32(fw.buf))
+		if len(fw.buf) < 4+n {
+			break
+		}
+		if err := fw.send(fw.buf[4 : 4+n]); err != nil {
+			return 0, err
+		}
+		fw.buf = fw.buf[4+n:]
+	}
+	if len(fw.buf) == 0 {
+		fw.buf = nil
+	}
+	return len(p), nil
+}
@@ -0,0 +1,85 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestArrowWriter(t *testing.T) {
+	cmd := newTestCommand()
+	var msgs [][]byte
+	fw := &frameWriter{send: func(msg []byte) error {
+		msgs = append(msgs, append([]byte(nil), msg...))
+		return nil
+	}}
+	var buf bytes.Buffer
+	aw := cmd.newArrowWriter(&buf, "db", "rp")
+	fn := cmd.handleSeries(cmd.prefixes, aw.add)
+	series := []struct {
+		key, field string
+		values     []tsm1.Value
+	}{
+		{`cpu,host=a\ b`, "usage", []tsm1.Value{tsm1.NewFloatValue(1, 0.5), tsm1.NewFloatValue(2, 1.5)}},
+		{`cpu,host=a\ b`, "msg", []tsm1.Value{tsm1.NewStringValue(3, "x")}},
+		{`cpu,region=us`, "up", []tsm1.Value{tsm1.NewBooleanValue(4, true)}},
+		{`mem`, "used", []tsm1.Value{tsm1.NewIntegerValue(5, 7)}},
+		{`mem`, "free", []tsm1.Value{tsm1.NewUnsignedValue(6, 8)}},
+	}
+	for _, s := range series {
+		if err := fn([]byte(s.key), []byte(s.field), s.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	// the frames are split in any chunks
+	for b := buf.Bytes(); len(b) > 0; b = b[min(len(b), 7):] {
+		if _, err := fw.Write(b[:min(len(b), 7)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(msgs) != 2 || len(fw.buf) != 0 {
+		t.Fatalf("unexpected batches: %d, %d bytes left", len(msgs), len(fw.buf))
+	}
+
+	var got []string
+	for _, msg := range msgs {
+		r, err := ipc.NewReader(bytes.NewReader(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		md := r.Schema().Metadata()
+		got = append(got, md.Values()[md.FindKey("database")]+"."+md.Values()[md.FindKey("retention_policy")]+"."+md.Values()[md.FindKey("measurement")])
+		for r.Next() {
+			rec := r.Record()
+			for i, col := range rec.Columns() {
+				got = append(got, rec.ColumnName(i)+"="+col.(fmt.Stringer).String())
+			}
+		}
+		r.Release()
+	}
+	exp := []string{
+		"db.rp.cpu",
+		"time=[1 2 3 4]",
+		`host=["a b" "a b" "a b" (null)]`,
+		`region=[(null) (null) (null) "us"]`,
+		`field=["usage" "usage" "msg" "up"]`,
+		"value_float=[0.5 1.5 (null) (null)]",
+		"value_boolean=[(null) (null) (null) true]",
+		`value_string=[(null) (null) "x" (null)]`,
+		"db.rp.mem",
+		"time=[5 6]",
+		`field=["used" "free"]`,
+		"value_integer=[7 (null)]",
+		"value_unsigned=[(null) 8]",
+	}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected batches: %s", cmp.Diff(exp, got))
+	}
+}
@@ -0,0 +1,63 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/chengshiwen/influx-tool/internal/blocks"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// writeBlocks writes the tsm files of the shards into a tsm blocks container. The blocks of matched series fields
+// are copied as they are stored without decoding the values, which is much faster than writing line protocol.
+func (cmd *command) writeBlocks(w io.Writer) error {
+	bs, ok := cmd.src.(source.BlockSource)
+	if !ok {
+		return fmt.Errorf("%s cannot be exported in tsm-blocks format", cmd.kind)
+	}
+	msgOut := cmd.msgOut()
+	bw := blocks.NewWriter(w)
+
+	var lastKey []byte
+	var matched bool
+	writeBlock := func(key []byte, minTime, maxTime int64, block []byte) error {
+		// blocks of a key are consecutive, so the series and field are matched once per key
+		if !bytes.Equal(key, lastKey) {
+			lastKey = append(lastKey[:0], key...)
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			matched = cmd.matchSeriesField(seriesKey, field)
+			if matched {
+				cmd.addSeries(seriesKey, field)
+			}
+		}
+		if !matched {
+			return nil
+		}
+		if cmd.ppsLimiter != nil {
+			// the points of a block are counted from its timestamps without decoding the values
+			n, _ := tsm1.BlockCount(block)
+			cmd.ppsLimiter.WaitN(n)
+		}
+		cmd.addWritten(0, len(block))
+		return bw.WriteBlock(key, minTime, maxTime, block)
+	}
+
+	for _, key := range cmd.manifest() {
+		cmd.startKey(key)
+		fmt.Fprintf(msgOut, "writing out %s blocks for %s%s...", cmd.kind, filepath.Join(key.db, key.rp), cmd.withMeasurement())
+		for _, sh := range key.shards {
+			if err := bw.WriteShard(sh.Database, sh.RetentionPolicy, sh.ID); err != nil {
+				return err
+			}
+			if err := bs.ReadBlocks(sh, bw.WriteFile, writeBlock); err != nil {
+				return err
+			}
+			cmd.shardRead(sh)
+		}
+		fmt.Fprintf(msgOut, "complete%s.\n", cmd.keyDone())
+	}
+	return bw.Flush()
+}
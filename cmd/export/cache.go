@@ -0,0 +1,85 @@
+package exporter
+
+import (
+	"sync"
+
+	"github.com/chengshiwen/influx-tool/internal/empty"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+)
+
+const (
+	maxCachedPrefixes = 100000
+	keyFieldSeparator = "#!~#"
+)
+
+// bufPool pools the buffers of lines encoded before written.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 64*1024)
+		return &b
+	},
+}
+
+// prefixCache caches the line prefix "<series_key> <field>=" of series and whether the series and field match. The
+// series of a shard are repeated across its tsm files and wal, so that a prefix is built once rather than per read.
+// The cache is reset once full to bound the memory, and it is not safe for concurrent use.
+type prefixCache struct {
+	max     int
+	key     []byte // composite key to look up without allocation
+	entries map[string]prefixEntry
+	empty   *empty.Handler      // handler of empty tags and fields of the matched series if not nil
+	tags    *tagRewriter        // rewriter of the tags of the matched series if not nil
+	names   *measurementRenamer // renamer of the measurements of the matched series if not nil
+}
+
+type prefixEntry struct {
+	prefix  []byte
+	matched bool
+	result  empty.Result
+}
+
+func newPrefixCache(max int) *prefixCache {
+	return &prefixCache{max: max, entries: make(map[string]prefixEntry)}
+}
+
+// Get returns the prefix of the series and the result of handling its empty tags and field, or false if it is not
+// matched by match or its points are dropped by the handler.
+func (c *prefixCache) Get(seriesKey, field []byte, match func(seriesKey, field []byte) bool) ([]byte, empty.Result, bool) {
+	c.key = append(append(append(c.key[:0], seriesKey...), keyFieldSeparator...), field...)
+	if e, ok := c.entries[string(c.key)]; ok {
+		return e.prefix, e.result, e.matched
+	}
+	if len(c.entries) >= c.max {
+		clear(c.entries)
+	}
+
+	e := prefixEntry{matched: match(seriesKey, field)}
+	if e.matched && c.empty != nil {
+		name, tags := empty.ParseKey(seriesKey)
+		var fixed models.Tags
+		fixed, field, e.result = c.empty.Handle(tags, field)
+		if e.result == empty.TagDropped || e.result == empty.Substituted {
+			seriesKey = models.MakeKey(name, fixed)
+		}
+		e.matched = e.result != empty.PointDropped
+	}
+	if e.matched && (c.tags != nil || c.names != nil) {
+		name, tags := empty.ParseKey(seriesKey)
+		if c.tags != nil {
+			tags = c.tags.rewrite(tags)
+		}
+		seriesKey = models.MakeKey(c.names.rename(name), tags)
+	}
+	if e.matched {
+		// seriesKey are stored escaped, field names are not
+		field = escape.Bytes(field)
+		e.prefix = make([]byte, 0, len(seriesKey)+len(field)+2)
+		e.prefix = append(e.prefix, seriesKey...)
+		e.prefix = append(e.prefix, ' ')
+		e.prefix = append(e.prefix, field...)
+		e.prefix = append(e.prefix, '=')
+	}
+	c.entries[string(c.key)] = e
+	return e.prefix, e.result, e.matched
+}
@@ -0,0 +1,124 @@
+package exporter
+
+import (
+	"io"
+	"math"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+// readWindows reads the shards window by window of chunk interval if given, or at once otherwise. The shards
+// overlapping a window are read within it, so that the values of a series are decoded one window at a time, and
+// the lines written are flushed, or rotated into the next part by chunk rotate, once the window is done. The windows
+// without any shard overlapping them are skipped.
+func (cmd *command) readWindows(shards []*source.Shard, w io.Writer) error {
+	if cmd.chunkInterval <= 0 {
+		return cmd.readShards(shards, w)
+	}
+	cmd.readTimeRanges(shards)
+	start, end := cmd.startTime, cmd.endTime
+	defer func() {
+		cmd.startTime, cmd.endTime = start, end
+	}()
+	// the shards out of the time range are done without being read
+	for _, sh := range shards {
+		if sh.StartTime > end || sh.EndTime < start {
+			cmd.shardRead(sh)
+		}
+	}
+	for ws := start; ws <= end; {
+		we := windowEnd(ws, end, int64(cmd.chunkInterval))
+		var overlaps []*source.Shard
+		for _, sh := range shards {
+			if sh.StartTime <= we && sh.EndTime >= ws {
+				overlaps = append(overlaps, sh)
+			}
+		}
+		if len(overlaps) == 0 {
+			next, ok := nextStart(shards, we)
+			if !ok || next > end {
+				break
+			}
+			ws = next
+			continue
+		}
+		cmd.startTime, cmd.endTime = ws, we
+		if err := cmd.readShards(overlaps, w); err != nil {
+			return err
+		}
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+		for _, sh := range overlaps {
+			if sh.EndTime <= we || we == end {
+				cmd.shardRead(sh)
+			}
+		}
+		if we == end {
+			break
+		}
+		ws = we + 1
+	}
+	return nil
+}
+
+// nextStart returns the earliest start time of the shards after t, or false if none.
+func nextStart(shards []*source.Shard, t int64) (int64, bool) {
+	next, ok := int64(math.MaxInt64), false
+	for _, sh := range shards {
+		if sh.StartTime > t && sh.StartTime <= next {
+			next, ok = sh.StartTime, true
+		}
+	}
+	return next, ok
+}
+
+// readTimeRanges sets the time ranges of the shards listed without them, so that a shard is only read in the windows
+// overlapping its data: the ones of their shard groups in the meta of metadir if given, or the ones of their files.
+func (cmd *command) readTimeRanges(shards []*source.Shard) {
+	rs, _ := cmd.src.(source.RangeSource)
+	for _, sh := range shards {
+		if sh.StartTime != math.MinInt64 || sh.EndTime != math.MaxInt64 {
+			continue
+		}
+		if sgi := shardGroup(cmd.metaData, sh); sgi != nil {
+			// the end time of a shard group is exclusive
+			sh.StartTime, sh.EndTime = sgi.StartTime.UnixNano(), sgi.EndTime.UnixNano()-1
+		} else if rs != nil {
+			rs.ReadTimeRange(sh)
+		}
+	}
+}
+
+// shardGroup returns the shard group of the shard in the meta, or nil if not found.
+func shardGroup(data *meta.Data, sh *source.Shard) *meta.ShardGroupInfo {
+	if data == nil {
+		return nil
+	}
+	rpi, err := data.RetentionPolicy(sh.Database, sh.RetentionPolicy)
+	if err != nil || rpi == nil {
+		return nil
+	}
+	for i := range rpi.ShardGroups {
+		for _, si := range rpi.ShardGroups[i].Shards {
+			if si.ID == sh.ID {
+				return &rpi.ShardGroups[i]
+			}
+		}
+	}
+	return nil
+}
+
+// windowEnd returns the end of the window starting at start, which is before the next multiple of interval since
+// the epoch, such as the end of the day in UTC for 24h, and no later than end.
+func windowEnd(start, end, interval int64) int64 {
+	step := interval - (start%interval+interval)%interval
+	// the distance is compared unsigned, which never overflows
+	if uint64(step-1) > uint64(end)-uint64(start) {
+		return end
+	}
+	return start + step - 1
+}
@@ -0,0 +1,156 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestWindowEnd(t *testing.T) {
+	day := int64(24 * time.Hour)
+	tests := []struct {
+		start, end, exp int64
+	}{
+		{0, 3 * day, day - 1},
+		{day / 2, 3 * day, day - 1},
+		{day, 3 * day, 2*day - 1},
+		{day / 2, day / 2, day / 2},
+		{-day / 2, 3 * day, -1},
+		{-day, 3 * day, -1},
+		{math.MaxInt64 - 1, math.MaxInt64, math.MaxInt64},
+		{math.MinInt64, 0, math.MinInt64 - math.MinInt64%day - 1},
+	}
+	for _, tt := range tests {
+		if got := windowEnd(tt.start, tt.end, day); got != tt.exp {
+			t.Errorf("windowEnd(%d, %d): got=%d, exp=%d", tt.start, tt.end, got, tt.exp)
+		}
+	}
+}
+
+func TestChunkRotate(t *testing.T) {
+	day := int64(24 * time.Hour)
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	shardDir := filepath.Join(dataDir, "db", "autogen", "1")
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// no points in the second day, which is merged into the third one
+	writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2*day, 2.5), tsm1.NewFloatValue(3*day, 3.5)},
+		"cpu,host=b#!~#usage": {tsm1.NewFloatValue(2, 4.5), tsm1.NewFloatValue(2*day+1, 5.5)},
+	})
+
+	cmd := newTestCommand()
+	cmd.precision, cmd.out = precisionNs, filepath.Join(dir, "export")
+	cmd.chunkInterval, cmd.chunkRotate = 24*time.Hour, true
+	cmd.startTime, cmd.endTime = 0, 3*day-1
+	cmd.kind = "tsm and wal file"
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	if err = cmd.writeFile(); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.startTime != 0 || cmd.endTime != 3*day-1 {
+		t.Errorf("unexpected time range restored: %d-%d", cmd.startTime, cmd.endTime)
+	}
+	if cmd.progress.read.Load() != 1 {
+		t.Errorf("unexpected shards read: %d", cmd.progress.read.Load())
+	}
+
+	// the first line is the time range of the part in local time
+	ddl := "# DDL\nCREATE DATABASE db WITH NAME autogen\n# DML\n# CONTEXT-DATABASE:db\n# CONTEXT-RETENTION-POLICY:autogen\n"
+	parts := []string{
+		ddl + "# writing tsm and wal file data\ncpu,host=a usage=1.5 1\ncpu,host=b usage=4.5 2\n",
+		ddl + fmt.Sprintf("cpu,host=a usage=2.5 %d\ncpu,host=b usage=5.5 %d\n", 2*day, 2*day+1),
+	}
+	if _, err = os.Stat(filepath.Join(dir, "export.002")); !os.IsNotExist(err) {
+		t.Errorf("unexpected part 2: %v", err)
+	}
+	for i, exp := range parts {
+		b, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("export.%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, got, _ := strings.Cut(string(b), "\n"); got != exp {
+			t.Errorf("unexpected part %d:\n%s", i, got)
+		}
+	}
+}
+
+// windowSource counts the windows a shard is read in.
+type windowSource struct {
+	*source.FileSource
+	windows []string
+}
+
+func (s *windowSource) ReadValues(sh *source.Shard, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	s.windows = append(s.windows, fmt.Sprintf("%d-%d", start, end))
+	return s.FileSource.ReadValues(sh, start, end, fn)
+}
+
+func TestReadWindowsSkip(t *testing.T) {
+	day := int64(24 * time.Hour)
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	for _, id := range []string{"1", "2"} {
+		if err := os.MkdirAll(filepath.Join(dataDir, "db", "autogen", id), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(10*day, 1.5)},
+	})
+	writeFollowWAL(t, filepath.Join(walDir, "db", "autogen", "1", "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(11*day+1, 2.5)},
+	})
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(2000*day, 3.5)},
+	})
+
+	cmd := newTestCommand()
+	cmd.precision, cmd.out = precisionNs, filepath.Join(dir, "export")
+	cmd.chunkInterval = 24 * time.Hour
+	cmd.startTime, cmd.endTime = 0, 1000*day
+	cmd.kind = "tsm and wal file"
+	src := &windowSource{FileSource: source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)}
+	cmd.src = src
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	if err = cmd.writeFile(); err != nil {
+		t.Fatal(err)
+	}
+	// the shards are only read in the windows of their data, the one out of the time range is done without being read
+	if exp := []string{fmt.Sprintf("%d-%d", 10*day, 11*day-1), fmt.Sprintf("%d-%d", 11*day, 12*day-1)}; !cmp.Equal(src.windows, exp) {
+		t.Errorf("unexpected windows read: %v", src.windows)
+	}
+	if cmd.progress.read.Load() != 2 {
+		t.Errorf("unexpected shards read: %d", cmd.progress.read.Load())
+	}
+	b, err := os.ReadFile(cmd.out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := fmt.Sprintf("cpu,host=a usage=1.5 %d\ncpu,host=a usage=2.5 %d\n", 10*day, 11*day+1); !strings.HasSuffix(string(b), exp) {
+		t.Errorf("unexpected export:\n%s", b)
+	}
+}
@@ -2,22 +2,35 @@ package exporter
 
 import (
 	"bufio"
-	"compress/gzip"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"sort"
+	"runtime"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/chengshiwen/influx-tool/internal/agent"
+	"github.com/chengshiwen/influx-tool/internal/empty"
+	"github.com/chengshiwen/influx-tool/internal/keyset"
+	"github.com/chengshiwen/influx-tool/internal/notify"
+	"github.com/chengshiwen/influx-tool/internal/preflight"
+	"github.com/chengshiwen/influx-tool/internal/ratelimit"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/chengshiwen/influx-tool/pkg/transform"
+	"github.com/influxdata/influxdb/client"
 	"github.com/influxdata/influxdb/models"
 	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
 	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 	"github.com/influxdata/influxql"
 	"github.com/spf13/cobra"
@@ -27,38 +40,186 @@ type command struct {
 	cobraCmd          *cobra.Command
 	dataDir           string
 	walDir            string
+	sourceKind        string
+	strictOrder       bool
+	tsmReadMode       string
+	ignoreTombstones  bool
+	strict            bool
+	errorReport       string
+	maxMemory         int64
+	backupPath        string
+	host              string
+	port              int
+	ssl               bool
+	clientConfig      client.Config
 	out               string
 	database          string
-	retentionPolicy   string
+	retentionPolicy   []string
+	excludeRps        []string
+	shardIDs          []uint64
 	measurement       map[string]struct{}
 	regexpMeasurement []*regexp.Regexp
+	tagFilters        []*tagFilter
+	seriesSet         *keyset.Set // series keys to export if series file given
+	field             map[string]struct{}
+	regexpField       []*regexp.Regexp
 	startTime         int64
 	endTime           int64
 	compress          bool
+	compression       string
+	compressLevel     int
+	compressWorkers   int
+	readWorkers       int
 	lponly            bool
+	includeDeletes    bool
+	dedup             bool
+	sorted            bool
+	dryRun            bool
+	format            string
+	floatFormat       byte
+	floatPrecision    int
+	boolFormat        string
+	uintAsInt         bool
+	groupFields       bool
+	derives           []*derivedField // fields derived from the fields grouped
+	rekey             *rekey          // fields promoted to tags and tags demoted to fields if given
+	historyFile       string
+	statsOut          string
+	histogramOut      string
+	histogramInterval time.Duration
+	histogramFormat   string
+	manifestPath      string
+	precision         string
+	targetDatabase    string
+	defaultPolicies   map[string]string // default retention policies of the databases exported, empty if unknown
+	metaDir           string
+	metaData          *meta.Data // meta of metadir with the durations of the retention policies, nil if not given
+	parquetLayout     string
+	parquetOut        string // out directory of the parquet files written in addition to the format if given
+	splitBy           string
+	maxFileSize       int64
+	chunkInterval     time.Duration
+	chunkRotate       bool
+	emptyMode         string
+	emptyPlaceholder  string
+	nonfinite         string
+	follow            bool
+	followInterval    time.Duration
+	targetURL         string
+	targetConfig      client.Config
+	transform         *transform.Hook // transform of the series and values if given
+	counters          *counterMap     // counter fields normalized if given
+	counterUnit       time.Duration
+	shiftTime         time.Duration
+	shift             int64 // nanoseconds the timestamps are offset by, 0 if not shifted
+	v2URL             string
+	v2Token           string
+	v2Org             string
+	serve             string
+	serveConfig       agent.ServerConfig
+	webhook           notify.Webhook
+	progressInterval  time.Duration
+	quiet             bool
+	pps               int
+	bps               int64
 
-	manifest map[string]struct{}
-	tsmFiles map[string][]string
-	walFiles map[string][]string
+	src          source.Source
+	kind         string // kind of data read from source
+	shards       []*source.Shard
+	overflows    atomic.Int64 // unsigned values skipped as overflowing integer
+	nans         atomic.Int64 // NaN and Inf float values handled by nonfinite
+	strs         atomic.Int64 // string values skipped as not numbers by openmetrics format
+	rekeySkipped atomic.Int64 // tag values demoted unable to be converted to the field types
+	shiftSkipped atomic.Int64 // values shifted out of the time range of influxdb
+	prefixes     *prefixCache
+	stats        stats
+	summary      summary
+	histogram    histogram
+	progress     progress
+	msgs         *messageWriter
+	precDiv      int64               // nanoseconds per unit of precision
+	buckets      *bucketMap          // buckets of the databases and retention policies streamed to influxdb 2.x
+	context      *manifestKey        // database and retention policy of the lines being written
+	outManifest  *outputManifest     // manifest of the output files if manifest path given
+	tee          *parquetWriter      // parquet writer of the shards being read if parquet out given
+	teeConflicts int                 // values skipped by the parquet out as conflicting with the column types
+	ppsLimiter   *ratelimit.Limiter  // points read, nil if unlimited
+	bpsLimiter   *ratelimit.Limiter  // bytes read from disk and written out, nil if unlimited
+	corrupt      *source.Corruptions // files and keys unable to be read, nil if not read from files
 }
 
 type tempflag struct {
+	format            []string
+	out               []string
 	start             string
 	end               string
 	measurement       []string
 	regexpMeasurement []string
+	tagFilter         []string
+	seriesFile        string
+	shardID           []string
+	field             []string
+	regexpField       []string
+	floatFormat       string
+	v2Bucket          []string
+	defaultPolicy     []string
+	transform         string
+	derive            []string
+	fieldToTag        []string
+	tagToField        []string
+	counter           []string
+	addTag            []string
+	renameTag         []string
+	renameMeasurement []string
+	setStart          string
 }
 
 const stdoutMark = "-"
 
+const compressBlockSize = 1024 * 1024
+
+const (
+	formatLine      = "line"
+	formatTSMBlocks = "tsm-blocks"
+	formatParquet   = "parquet"
+	formatCSV       = "annotated-csv"
+	formatOM        = "openmetrics"
+)
+
+const (
+	precisionNs = "ns"
+	precisionUs = "us" // alias of u
+)
+
+// precisions are the nanoseconds per unit of the precisions accepted by influx -import.
+var precisions = map[string]int64{
+	"h":         int64(time.Hour),
+	"m":         int64(time.Minute),
+	"s":         int64(time.Second),
+	"ms":        int64(time.Millisecond),
+	"u":         int64(time.Microsecond),
+	precisionNs: 1,
+}
+
+const (
+	nonfiniteDrop   = "drop"
+	nonfiniteZero   = "zero"
+	nonfiniteString = "string"
+)
+
+const (
+	boolTrue = "true"
+	boolT    = "t"
+	boolInt  = "int"
+)
+
 func NewCommand() *cobra.Command {
 	tf := &tempflag{}
 	cmd := &command{
 		measurement:       make(map[string]struct{}),
 		regexpMeasurement: make([]*regexp.Regexp, 0),
-		manifest:          make(map[string]struct{}),
-		tsmFiles:          make(map[string][]string),
-		walFiles:          make(map[string][]string),
+		field:             make(map[string]struct{}),
+		prefixes:          newPrefixCache(maxCachedPrefixes),
 	}
 	cmd.cobraCmd = &cobra.Command{
 		Args:          cobra.NoArgs,
@@ -67,28 +228,113 @@ func NewCommand() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			return cmd.runE(tf)
+			start := time.Now()
+			err := cmd.runE(tf)
+			cmd.notify(start, err)
+			return err
 		},
 	}
 	flags := cmd.cobraCmd.Flags()
 	flags.SortFlags = false
-	flags.StringVarP(&cmd.dataDir, "datadir", "D", "", "data storage path (required)")
-	flags.StringVarP(&cmd.walDir, "waldir", "W", "", "wal storage path (required)")
-	flags.StringVarP(&cmd.out, "out", "o", "./export", "'-' for standard out or the destination file to export to")
-	flags.StringVarP(&cmd.database, "database", "d", "", "database to export without _internal (default: all)")
-	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "", "retention policy to export (require database)")
+	flags.StringVarP(&cmd.dataDir, "datadir", "D", "", "data storage path, or its zip or tar archive read without extracting, preferably zip (required without backup-path or host)")
+	flags.StringVarP(&cmd.walDir, "waldir", "W", "", "wal storage path, or its archive when datadir is an archive, which is also read for the wal (required without backup-path, host, datadir archive or source tsm)")
+	flags.StringVar(&cmd.sourceKind, "source", source.KindAll, "files of datadir and waldir to export: all, tsm for the stable data only, or wal for the data not snapshotted into tsm files yet only, such as to replay the recent writes onto a restored backup")
+	flags.BoolVar(&cmd.strictOrder, "strict-order", false, "fail if tsm or wal file numbers are repeated or unparsable, or wal segments are missing (require datadir, default: false)")
+	flags.StringVar(&cmd.tsmReadMode, "tsm-read-mode", tsmread.ModeMmap, "mode of reading tsm files in datadir: mmap, or buffered for the filesystems where mmap performs poorly or faults, such as nfs")
+	flags.BoolVar(&cmd.ignoreTombstones, "ignore-tombstones", false, "export the values deleted by the tombstone files of the tsm files too, such as to recover the data deleted by mistake, the tsm files in datadir are read by buffered reads then (require datadir or backup path, default: false)")
+	flags.BoolVar(&cmd.strict, "strict", false, "fail the export on the first tsm or wal file or key unable to be read, which is logged and skipped otherwise, and counted at the end (require datadir or backup path, default: false)")
+	flags.StringVar(&cmd.errorReport, "error-report", "", "file writing the tsm and wal files and keys unable to be read as json, written even if the export fails, so that a partial export can be audited (require datadir or backup path, default: none)")
+	flags.Int64Var(&cmd.maxMemory, "max-memory", 0, "max bytes of the values of a series decoded at once by each read worker, the blocks of a series in a tsm file are decoded and written in chunks within it instead of all at once (default: 0, unlimited)")
+	flags.StringVarP(&cmd.backupPath, "backup-path", "B", "", "influxd backup directory or backup tarball in portable or legacy format to export instead of datadir and waldir, a directory with manifests is read by them, the shards of the latest backup are read if followed by incremental ones, and the retention policies of the DDL are read from its meta unless metadir")
+	flags.StringVarP(&cmd.host, "host", "H", "", "host of a live server to export from by chunked queries instead of datadir and waldir")
+	flags.IntVarP(&cmd.port, "port", "P", 8086, "port of the live server to connect to")
+	flags.StringVarP(&cmd.clientConfig.Username, "username", "u", "", "username to connect to the live server")
+	flags.StringVarP(&cmd.clientConfig.Password, "password", "p", "", "password to connect to the live server")
+	flags.BoolVarP(&cmd.ssl, "ssl", "s", false, "use https for requests to the live server (default: false)")
+	flags.StringArrayVarP(&tf.out, "out", "o", []string{"./export"}, "'-' for standard out or the destination file to export to, or the destination directory for parquet format, split by or line, annotated-csv and openmetrics format of all databases, can be set twice in pairs with format")
+	flags.StringVarP(&cmd.database, "database", "d", "", "database to export without _internal (default: all, each retention policy into <out>/<db>/<rp>.lp, .csv or .om for line, annotated-csv or openmetrics format)")
+	flags.StringSliceVarP(&cmd.retentionPolicy, "retention-policy", "r", nil, "retention policies to export delimited by comma (require database, default: all)")
+	flags.StringSliceVar(&cmd.excludeRps, "exclude-retention-policy", nil, "retention policies not to export delimited by comma (default: none)")
+	flags.StringSliceVar(&tf.shardID, "shard-id", nil, "ids of the shards to export delimited by comma, can be set multiple times, the other shards are skipped (default: all)")
 	flags.StringArrayVarP(&tf.measurement, "measurement", "m", []string{}, "measurement to export, can be set multiple times (require database, default: all)")
 	flags.StringArrayVarP(&tf.regexpMeasurement, "regexp-measurement", "M", []string{}, "regexp measurement to export, can be set multiple times (require database, default: all)")
+	flags.StringArrayVar(&tf.field, "field", []string{}, "field to export, can be set multiple times (default: all)")
+	flags.StringArrayVar(&tf.regexpField, "regexp-field", []string{}, "regexp field to export, can be set multiple times (default: all)")
+	flags.StringArrayVar(&tf.tagFilter, "tag-filter", []string{}, "tag predicate the series exported must match like host=web01, host!=web01, host=~^web or host!~^web, can be set multiple times and all must match, a missing tag has an empty value (default: none)")
+	flags.StringArrayVar(&tf.addTag, "add-tag", []string{}, "tag added to all series exported like node=node1, replacing the tag of the same key, can be set multiple times (require line, annotated-csv or openmetrics format, default: none)")
+	flags.StringArrayVar(&tf.renameTag, "rename-tag", []string{}, "tag renamed in all series exported like old=new, replacing the tag of the new key, the tag filters match the tags before renamed, can be set multiple times (require line, annotated-csv or openmetrics format, default: none)")
+	flags.StringArrayVar(&tf.renameMeasurement, "rename-measurement", []string{}, "measurement renamed in all series exported like old:new, or /regexp/:new replacing the matches of the regexp with new, in which $1 expands to the first submatch, such as /^legacy_(.*)/:$1, the first rule matched is applied, and the measurement filters match the measurements before renamed, can be set multiple times (require line, annotated-csv or openmetrics format, default: none)")
+	flags.StringVar(&tf.seriesFile, "series-file", "", "file of the series keys to export, one per line like cpu,host=a,region=us as written by influx_inspect, the other series are skipped (default: none)")
 	flags.StringVarP(&tf.start, "start", "S", "", "start time to export (RFC3339 format, optional)")
 	flags.StringVarP(&tf.end, "end", "E", "", "end time to export (RFC3339 format, optional)")
+	flags.DurationVar(&cmd.shiftTime, "shift-time", 0, "offset added to the timestamps of the points exported, such as 168h or -24h, to construct staging data in the present time window from historical data, start and end match the timestamps before shifted, and the points shifted out of the time range of influxdb are skipped (require line, annotated-csv or openmetrics format, default: 0, not shifted)")
+	flags.StringVar(&tf.setStart, "set-start", "", "time the start time is shifted to, the timestamps of the points exported are offset by the same (RFC3339 format, require start, and line, annotated-csv or openmetrics format, default: none)")
 	flags.BoolVarP(&cmd.lponly, "lponly", "l", false, "only export line protocol (default: false)")
-	flags.BoolVarP(&cmd.compress, "compress", "c", false, "compress the output (default: false)")
-	cmd.cobraCmd.MarkFlagRequired("datadir")
-	cmd.cobraCmd.MarkFlagRequired("waldir")
+	flags.BoolVar(&cmd.includeDeletes, "include-deletes", false, "write the deletes in wal as DROP SERIES or DELETE statements after the DDL, executed on the database of the context comment by import before the lines are written (require datadir and line format, default: false)")
+	flags.BoolVar(&cmd.dryRun, "dry-run", false, "scan the tsm indexes without reading the blocks, and print the series, blocks, points and estimated uncompressed output size by database, retention policy and measurement instead of exporting, the points in the wal are not counted, which shadows the dry-run of the root command (require datadir directory, default: false)")
+	flags.BoolVar(&cmd.dedup, "dedup", false, "merge the values of each series across the tsm and wal files of a shard and keep the value written last for each timestamp, so that the points both in tsm and wal files or in overlapping tsm generations are exported once, the values in the wal of a shard are loaded into memory (require datadir directory, default: false)")
+	flags.BoolVar(&cmd.sorted, "sorted", false, "merge the values of each series across the tsm and wal files of a shard like dedup, and write the points of a series in ascending timestamp order with the fields at the same timestamp in field order (require datadir directory and line format, default: false)")
+	flags.BoolVarP(&cmd.compress, "compress", "c", false, "compress the output with gzip, the same as --compression gzip (default: false)")
+	flags.StringVar(&cmd.compression, "compression", compressionNone, "compression of the output: gzip, zstd, snappy or none, zstd compresses about twice as fast as gzip at a similar ratio")
+	flags.IntVar(&cmd.compressLevel, "compression-level", 0, "compression level: 1-9 for gzip, 1-22 for zstd, 1-3 for snappy (default: 0, the default level of the compression)")
+	flags.IntVar(&cmd.compressWorkers, "compress-workers", 0, "number of blocks compressed in parallel (require compression, default: 0, the number of cpus)")
+	flags.IntVar(&cmd.readWorkers, "read-workers", 0, "number of shards in datadir read in parallel for line format without split by, the lines are still written in shard order (default: 0, the number of cpus)")
+	flags.StringArrayVar(&tf.format, "format", []string{formatLine}, "output format: line for line protocol, tsm-blocks for compressed tsm blocks copied without decoding, imported by import --format tsm-blocks, parquet for parquet files with columns of time, tags and fields, annotated-csv for the annotated csv of influxdb 2.x written by influx write, or openmetrics for the samples of metrics named <measurement>_<field> and labeled by tags, backfilled into prometheus compatible tsdbs by their import tools, can be set twice in pairs with out to write parquet in addition to line, annotated-csv or openmetrics from one read of the shards, whose parquet series are not retagged or renamed, and values not transformed, shifted, normalized or grouped")
+	flags.StringVar(&tf.floatFormat, "float-format", "g", "format of float values: g for exponent for large exponents only, f for no exponent or e for exponent")
+	flags.IntVar(&cmd.floatPrecision, "float-precision", -1, "digits of float values after the decimal point, or significant digits for g, -1 for the fewest digits to be exact")
+	flags.StringVar(&cmd.boolFormat, "bool-format", boolTrue, "format of boolean values: true for true/false, t for t/f, or int for 1i/0i as integer fields")
+	flags.BoolVar(&cmd.uintAsInt, "uint-as-int", false, "write unsigned values as integers without u suffix, values overflowing integer are skipped (default: false)")
+	flags.BoolVar(&cmd.groupFields, "group-fields", false, "merge the fields of a series at the same timestamp into a line like m,tags f1=1,f2=2 ts, the fields read from separate tsm or wal files are still written in separate lines (require line format, default: false)")
+	flags.StringArrayVar(&tf.derive, "derive", []string{}, "field derived from the fields grouped in a line by an influxql expression like power=voltage*current, appended unless a field of the name exists or a field of the expression is missing, can be set multiple times (require group fields, default: none)")
+	flags.StringArrayVar(&tf.fieldToTag, "field-to-tag", []string{}, "field promoted to a tag of the same name in the lines of the fields grouped, replacing the tag of the same key, floats are written in the fewest digits, booleans as true or false, and empty strings are not tagged, can be set multiple times (require group fields, default: none)")
+	flags.StringArrayVar(&tf.tagToField, "tag-to-field", []string{}, "tag demoted to a field of the same name in the lines of the fields grouped like name or name:type, whose values are converted to type: string, float, integer, unsigned or boolean, the values unable to be converted are skipped, and a field of the same name of the series is kept instead, can be set multiple times (require group fields, default: none)")
+	flags.StringVar(&cmd.splitBy, "split-by", "", "split the output into files under the out directory: measurement for a file per measurement named <db>/<rp>/<measurement>.lp, .gz, .zst or .sz appended if compressed (default: none)")
+	flags.Int64Var(&cmd.maxFileSize, "max-file-size", 0, "max bytes of each output file after compression if any, the output is rotated into <out>.000, <out>.001, ... with the DDL and context repeated (default: 0, unlimited)")
+	flags.DurationVar(&cmd.chunkInterval, "chunk-interval", 0, "read the time range in windows of the interval aligned to its multiples since the epoch, such as 24h for the days in UTC, the output is flushed once a window is done, bounding the values decoded at a time (require start, end and line format, default: 0, no windows)")
+	flags.BoolVar(&cmd.chunkRotate, "chunk-rotate", false, "write each window of chunk interval into its own part <out>.000, <out>.001, ... with the DDL and context repeated, a window without points is merged into the next one (require chunk interval and out file, default: false)")
+	flags.StringVar(&cmd.emptyMode, "empty-mode", empty.ModeKeep, "handling of the series with empty tag keys, tag values or field names rejected by influxdb: keep, drop-tag to drop the empty tags and the points of empty fields, drop-point, or placeholder to substitute empty-placeholder")
+	flags.StringVar(&cmd.emptyPlaceholder, "empty-placeholder", "_", "placeholder of the empty tag keys, tag values and field names in placeholder empty mode")
+	flags.StringVar(&cmd.nonfinite, "nonfinite", nonfiniteDrop, "handling of NaN and Inf float values, which are not importable: drop, zero, or string to write them as string values \"NaN\", \"+Inf\" and \"-Inf\"")
+	flags.StringVar(&tf.transform, "transform", "", "go plugin exporting a Transformer of package pkg/transform, which filters, modifies and renames the series and values exported (require line, annotated-csv or openmetrics format, not split by, default: none)")
+	flags.BoolVar(&cmd.follow, "follow", false, "keep following the wal after the export, and append the points written since to the out file or standard out, or write them to target-url, until interrupted (require datadir and waldir directories, line format and a single output, default: false)")
+	flags.DurationVar(&cmd.followInterval, "follow-interval", time.Second, "interval of polling the wal files for the points appended by follow")
+	flags.StringVar(&cmd.targetURL, "target-url", "", "url of an influxdb 1.x server or influx-proxy to write the points followed to instead of the out file, the databases and retention policies are created if not exist, e.g. http://127.0.0.1:8086 (require follow, default: none)")
+	flags.StringVar(&cmd.targetConfig.Username, "target-username", "", "username to connect to the target-url")
+	flags.StringVar(&cmd.targetConfig.Password, "target-password", "", "password to connect to the target-url")
+	flags.StringArrayVar(&tf.counter, "counter", []string{}, "rule of a counter field whose resets are normalized: measurement/field=mode, or field=mode for any measurement, mode cumulative for the values corrected to keep increasing across the resets, or rate for the float increase per counter unit, whose first value of a series is dropped, can be set multiple times (require dedup or sorted, default: none)")
+	flags.DurationVar(&cmd.counterUnit, "counter-unit", time.Second, "unit of time of the rates of counter, such as 1s or 1m")
+	flags.StringVar(&cmd.precision, "precision", precisionNs, "precision of timestamps truncated to: h, m, s, ms, u (or us) or ns, written as a context comment, import by influx -import with the same -precision")
+	flags.StringVar(&cmd.targetDatabase, "target-database", "", "database name written into the DDL and context comments instead of the database exported (require database)")
+	flags.StringVar(&cmd.metaDir, "metadir", "", "meta storage path to read the duration, replication and shard duration of the retention policies written into the DDL, and their default ones (default: none, DURATION 0s REPLICATION 1)")
+	flags.StringArrayVar(&tf.defaultPolicy, "default-retention-policy", []string{}, "default retention policy of a database as db=rp marked as default in the DDL, can be set multiple times, overriding the one read from the meta of datadir or the live server (default: none)")
+	flags.StringVar(&cmd.parquetLayout, "parquet-layout", parquetLayoutMeasurement, "layout of parquet files: measurement for a file per measurement, or retention-policy for a file per retention policy with a measurement column")
+	flags.StringVar(&cmd.v2URL, "v2-url", "", "url of an influxdb 2.x server to stream the lines to by /api/v2/write instead of writing out, such as http://127.0.0.1:8086 (require line format, default: none)")
+	flags.StringVar(&cmd.v2Token, "v2-token", "", "api token of the influxdb 2.x server with the write permission of the buckets")
+	flags.StringVar(&cmd.v2Org, "v2-org", "", "organization of the buckets in the influxdb 2.x server (required with v2-url)")
+	flags.StringArrayVar(&tf.v2Bucket, "v2-bucket", []string{}, "rule of the bucket to write a database and retention policy to: db/rp=bucket, db=bucket, or a template with %db and %rp replaced for the others, can be set multiple times (default: %db/%rp)")
+	flags.StringVar(&cmd.serve, "serve", "", "address to serve the export on by grpc instead of writing out, such as :8089, each pull streams the arrow record batches of the measurements of the shards, with columns of time, tags, field and values (require line format, default: none)")
+	flags.StringVar(&cmd.serveConfig.Token, "serve-token", "", "token to authenticate pull requests of serve (default: no authentication)")
+	flags.StringVar(&cmd.serveConfig.TLSCert, "serve-tls-cert", "", "tls certificate file to serve with (default: no tls)")
+	flags.StringVar(&cmd.serveConfig.TLSKey, "serve-tls-key", "", "tls private key file to serve with (require serve-tls-cert)")
+	flags.StringVar(&cmd.webhook.URL, "notify-webhook", "", "url posted with the summary and statistics as json once the export finishes or fails (default: none)")
+	flags.StringVar(&cmd.webhook.Format, "notify-format", notify.FormatJSON, "payload format of notify-webhook: json, or slack for slack incoming webhooks")
+	flags.DurationVar(&cmd.progressInterval, "progress-interval", 10*time.Second, "interval of reporting the progress of shards, points and bytes written with the eta, 0 to disable")
+	flags.BoolVarP(&cmd.quiet, "quiet", "q", false, "suppress the progress messages and reports (default: false)")
+	flags.IntVar(&cmd.pps, "pps", 0, "points per second the export will read, so that a production node is not saturated (default: 0, unlimited)")
+	flags.Int64Var(&cmd.bps, "bps", 0, "bytes per second the export will read from the tsm blocks and wal files and write to the output in total, so that the disk io of a production node is not saturated (default: 0, unlimited)")
+	flags.StringVar(&cmd.historyFile, "history-file", "", "file recording the statistics of exports to estimate the duration of later ones, '-' to disable (default: ~/.influx-tool/history.jsonl)")
+	flags.StringVar(&cmd.statsOut, "stats-out", "", "file writing the summary of the measurements, series, fields, points, bytes and elapsed time exported per database and retention policy as json, which is printed at the end of the export anyway (default: none)")
+	flags.StringVar(&cmd.histogramOut, "histogram-out", "", "file writing the number of values exported per database, retention policy, measurement and time bucket, to find the gaps and spikes of the data (default: none)")
+	flags.StringVar(&cmd.manifestPath, "manifest", "", "file writing the json manifest of the output files, with the sha256, points and time range of each file to verify them once transferred (require line format and out file, default: none)")
+	flags.DurationVar(&cmd.histogramInterval, "histogram-interval", time.Hour, "interval of the time buckets of histogram-out, such as 1h or 24h")
+	flags.StringVar(&cmd.histogramFormat, "histogram-format", histogramCSV, "format of histogram-out: csv or json")
 	return cmd.cobraCmd
 }
 
 func (cmd *command) validate(tf *tempflag) error {
+	if err := cmd.parseOutputs(tf.format, tf.out); err != nil {
+		return err
+	}
 	if tf.start != "" {
 		s, err := time.Parse(time.RFC3339, tf.start)
 		if err != nil {
@@ -110,15 +356,338 @@ func (cmd *command) validate(tf *tempflag) error {
 	if cmd.startTime != 0 && cmd.endTime != 0 && cmd.endTime < cmd.startTime {
 		return errors.New("end time before start time")
 	}
+	if tf.setStart != "" {
+		if cmd.shiftTime != 0 {
+			return errors.New("shift time and set start cannot be used together")
+		}
+		if tf.start == "" {
+			return errors.New("set start requires start")
+		}
+		s, err := time.Parse(time.RFC3339, tf.setStart)
+		if err != nil {
+			return errors.New("set start is invalid")
+		}
+		cmd.shift = s.UnixNano() - cmd.startTime
+	} else {
+		cmd.shift = int64(cmd.shiftTime)
+	}
+	switch tf.floatFormat {
+	case "g", "f", "e":
+		cmd.floatFormat = tf.floatFormat[0]
+	default:
+		return errors.New("float format is invalid, require g, f or e")
+	}
+	if cmd.floatPrecision < -1 {
+		return errors.New("float precision is invalid")
+	}
+	if cmd.boolFormat != boolTrue && cmd.boolFormat != boolT && cmd.boolFormat != boolInt {
+		return errors.New("bool format is invalid, require true, t or int")
+	}
+	if err := cmd.validateCompression(); err != nil {
+		return err
+	}
+	if cmd.compressWorkers < 0 {
+		return errors.New("compress workers is invalid")
+	}
+	if cmd.compressWorkers == 0 {
+		cmd.compressWorkers = runtime.GOMAXPROCS(0)
+	}
+	if cmd.readWorkers < 0 {
+		return errors.New("read workers is invalid")
+	}
+	if cmd.readWorkers == 0 {
+		cmd.readWorkers = runtime.GOMAXPROCS(0)
+	}
+	if cmd.pps < 0 {
+		return errors.New("pps is invalid")
+	}
+	if cmd.bps < 0 {
+		return errors.New("bps is invalid")
+	}
+	cmd.ppsLimiter, cmd.bpsLimiter = ratelimit.NewLimiter(float64(cmd.pps)), ratelimit.NewLimiter(float64(cmd.bps))
+	if cmd.format != formatLine && cmd.format != formatTSMBlocks && cmd.format != formatParquet && cmd.format != formatCSV && cmd.format != formatOM {
+		return errors.New("format is invalid, require line, tsm-blocks, parquet, annotated-csv or openmetrics")
+	}
+	if cmd.format == formatTSMBlocks && (cmd.host != "" || tf.start != "" || tf.end != "" || cmd.lponly) {
+		return errors.New("host, start, end and lponly are not available for tsm-blocks format")
+	}
+	if cmd.precision == precisionUs {
+		// us of influxdb 2.x is written as u accepted by influx -import
+		cmd.precision = "u"
+	}
+	div, ok := precisions[cmd.precision]
+	if !ok {
+		return errors.New("precision is invalid, require h, m, s, ms, u, us or ns")
+	}
+	cmd.precDiv = div
+	if cmd.format == formatTSMBlocks && (cmd.precision != precisionNs || cmd.targetDatabase != "") {
+		return errors.New("precision and target database are not available for tsm-blocks format")
+	}
+	if cmd.format == formatCSV && (cmd.lponly || cmd.precision != precisionNs || cmd.targetDatabase != "" || cmd.boolFormat != boolTrue || cmd.nonfinite == nonfiniteString) {
+		return errors.New("lponly, precision, target database, bool format and nonfinite string are not available for annotated-csv format")
+	}
+	if cmd.format == formatOM && (cmd.lponly || cmd.precision != precisionNs || cmd.targetDatabase != "" || cmd.boolFormat != boolTrue || cmd.uintAsInt) {
+		return errors.New("lponly, precision, target database, bool format and uint as int are not available for openmetrics format")
+	}
+	if cmd.groupFields && cmd.format != formatLine {
+		return errors.New("group fields is only available for line format")
+	}
+	if len(tf.derive) > 0 && !cmd.groupFields {
+		return errors.New("derive requires group fields")
+	}
+	for _, expr := range tf.derive {
+		d, err := parseDerive(expr)
+		if err != nil {
+			return err
+		}
+		cmd.derives = append(cmd.derives, d)
+	}
+	if (len(tf.fieldToTag) > 0 || len(tf.tagToField) > 0) && !cmd.groupFields {
+		return errors.New("field to tag and tag to field require group fields")
+	}
+	rk, err := parseRekey(tf.fieldToTag, tf.tagToField)
+	if err != nil {
+		return err
+	}
+	cmd.rekey = rk
+	if cmd.splitBy != "" && cmd.splitBy != splitByMeasurement {
+		return errors.New("split by is invalid, require measurement")
+	}
+	if cmd.splitBy != "" && (cmd.format != formatLine || cmd.usingStdOut()) {
+		return errors.New("split by is only available for line format and not standard out")
+	}
+	if cmd.maxFileSize < 0 {
+		return errors.New("max file size is invalid")
+	}
+	if cmd.maxFileSize > 0 && (cmd.format != formatLine || cmd.usingStdOut() || cmd.splitBy != "") {
+		return errors.New("max file size is only available for line format and not standard out or split by")
+	}
+	if cmd.chunkInterval < 0 {
+		return errors.New("chunk interval is invalid")
+	}
+	if cmd.chunkInterval > 0 && (tf.start == "" || tf.end == "") {
+		return errors.New("must specify start and end when chunk interval given")
+	}
+	if cmd.chunkInterval > 0 && (cmd.format != formatLine || cmd.splitBy != "" || cmd.v2URL != "") {
+		return errors.New("chunk interval is only available for line format, and not split by or v2 url")
+	}
+	if cmd.chunkRotate && (cmd.chunkInterval == 0 || cmd.usingStdOut()) {
+		return errors.New("chunk rotate is only available for chunk interval and not standard out")
+	}
+	if cmd.nonfinite != nonfiniteDrop && cmd.nonfinite != nonfiniteZero && cmd.nonfinite != nonfiniteString {
+		return errors.New("nonfinite is invalid, require drop, zero or string")
+	}
+	if !notify.ValidFormat(cmd.webhook.Format) {
+		return errors.New("notify format is invalid, require json or slack")
+	}
+	if !empty.ValidMode(cmd.emptyMode) {
+		return errors.New("empty mode is invalid, require keep, drop-tag, drop-point or placeholder")
+	}
+	if cmd.emptyMode != empty.ModeKeep && cmd.format != formatLine {
+		return errors.New("empty mode is only available for line format")
+	}
+	if cmd.emptyMode == empty.ModePlaceholder && cmd.emptyPlaceholder == "" {
+		return errors.New("empty placeholder is invalid")
+	}
+	cmd.prefixes.empty = empty.NewHandler(cmd.emptyMode, cmd.emptyPlaceholder)
+	tags, err := parseTagRewriter(tf.addTag, tf.renameTag)
+	if err != nil {
+		return err
+	}
+	if tags != nil && cmd.format != formatLine && cmd.format != formatCSV && cmd.format != formatOM {
+		return errors.New("add tag and rename tag are only available for line, annotated-csv and openmetrics format")
+	}
+	cmd.prefixes.tags = tags
+	names, err := parseMeasurementRenamer(tf.renameMeasurement)
+	if err != nil {
+		return err
+	}
+	if names != nil && cmd.format != formatLine && cmd.format != formatCSV && cmd.format != formatOM {
+		return errors.New("rename measurement is only available for line, annotated-csv and openmetrics format")
+	}
+	cmd.prefixes.names = names
+	if cmd.shift != 0 && cmd.format != formatLine && cmd.format != formatCSV && cmd.format != formatOM {
+		return errors.New("shift time and set start are only available for line, annotated-csv and openmetrics format")
+	}
+	if cmd.format == formatParquet {
+		if cmd.usingStdOut() || cmd.compress || cmd.lponly || cmd.targetDatabase != "" {
+			return errors.New("standard out, compression, lponly and target database are not available for parquet format")
+		}
+		if _, ok := parquetUnits[cmd.precision]; !ok {
+			return errors.New("precision is invalid for parquet format, require ms, u or ns")
+		}
+		if cmd.parquetLayout != parquetLayoutMeasurement && cmd.parquetLayout != parquetLayoutRP {
+			return errors.New("parquet layout is invalid, require measurement or retention-policy")
+		}
+	}
+	if cmd.backupPath != "" && cmd.host != "" {
+		return errors.New("only one of backup path and host can be specified")
+	}
+	if (cmd.backupPath != "" || cmd.host != "") && (cmd.dataDir != "" || cmd.walDir != "") {
+		return errors.New("datadir and waldir cannot be specified when backup path or host given")
+	}
+	if cmd.sourceKind != source.KindAll && cmd.sourceKind != source.KindTSM && cmd.sourceKind != source.KindWAL {
+		return errors.New("source is invalid, require all, tsm or wal")
+	}
+	if cmd.sourceKind != source.KindAll && cmd.dataDir == "" {
+		return errors.New("must specify datadir and waldir when source is tsm or wal")
+	}
+	if cmd.backupPath == "" && cmd.host == "" && (cmd.dataDir == "" || (cmd.walDir == "" && cmd.sourceKind != source.KindTSM && !source.IsDataArchive(cmd.dataDir))) {
+		return errors.New("must specify datadir and waldir, backup path or host")
+	}
+	if cmd.walDir != "" && source.IsDataArchive(cmd.dataDir) != source.IsDataArchive(cmd.walDir) {
+		return errors.New("waldir is invalid, require both datadir and waldir to be archives or directories")
+	}
+	if cmd.strictOrder && cmd.dataDir == "" {
+		return errors.New("must specify datadir and waldir when strict order given")
+	}
+	if cmd.includeDeletes && (cmd.dataDir == "" || cmd.format != formatLine || cmd.lponly || cmd.splitBy != "") {
+		return errors.New("include deletes is only available for datadir and line format, and not lponly or split by")
+	}
+	if cmd.v2URL == "" && (cmd.v2Token != "" || cmd.v2Org != "" || len(tf.v2Bucket) > 0) {
+		return errors.New("must specify v2 url when v2 token, v2 org or v2 bucket given")
+	}
+	if cmd.v2URL != "" {
+		if cmd.v2Org == "" {
+			return errors.New("must specify v2 org when v2 url given")
+		}
+		if cmd.format != formatLine || cmd.splitBy != "" || cmd.maxFileSize > 0 || cmd.compression != compressionNone || cmd.includeDeletes {
+			return errors.New("v2 url is only available for line format, and not split by, max file size, compression or include deletes")
+		}
+		if _, ok := v2Precisions[cmd.precision]; !ok {
+			return errors.New("precision is invalid for v2 url, require s, ms, u or ns")
+		}
+		buckets, err := parseBucketMap(tf.v2Bucket)
+		if err != nil {
+			return err
+		}
+		cmd.buckets = buckets
+	}
+	if cmd.serve == "" && (cmd.serveConfig.Token != "" || cmd.serveConfig.TLSCert != "" || cmd.serveConfig.TLSKey != "") {
+		return errors.New("must specify serve when serve token, serve tls cert or serve tls key given")
+	}
+	if cmd.serve != "" {
+		if cmd.format != formatLine || cmd.lponly || cmd.includeDeletes || cmd.groupFields || cmd.splitBy != "" || cmd.maxFileSize > 0 || cmd.chunkInterval > 0 || cmd.v2URL != "" || cmd.compression != compressionNone || cmd.dryRun || cmd.histogramOut != "" {
+			return errors.New("serve is only available for line format, and not lponly, include deletes, group fields, split by, max file size, chunk interval, v2 url, compression, dry run or histogram out")
+		}
+		if cmd.precision != precisionNs || cmd.targetDatabase != "" || cmd.boolFormat != boolTrue || cmd.uintAsInt {
+			return errors.New("precision, target database, bool format and uint as int are not available for serve")
+		}
+		if (cmd.serveConfig.TLSCert == "") != (cmd.serveConfig.TLSKey == "") {
+			return errors.New("serve-tls-cert and serve-tls-key must be specified together")
+		}
+	}
+	cmd.defaultPolicies = make(map[string]string)
+	for _, value := range tf.defaultPolicy {
+		db, rp, ok := strings.Cut(value, "=")
+		if !ok || db == "" || rp == "" {
+			return fmt.Errorf("default retention policy is invalid: %s, require db=rp", value)
+		}
+		cmd.defaultPolicies[db] = rp
+	}
+	if cmd.dedup && (cmd.dataDir == "" || source.IsDataArchive(cmd.dataDir) || cmd.format == formatTSMBlocks) {
+		return errors.New("dedup is only available for datadir directory, and not tsm-blocks format")
+	}
+	if cmd.sorted && (cmd.dataDir == "" || source.IsDataArchive(cmd.dataDir) || cmd.format != formatLine) {
+		return errors.New("sorted is only available for datadir directory and line format")
+	}
+	if cmd.dryRun && (cmd.dataDir == "" || source.IsDataArchive(cmd.dataDir)) {
+		return errors.New("dry run is only available for datadir directory")
+	}
+	if cmd.dryRun && cmd.statsOut != "" {
+		return errors.New("stats out is not available for dry run")
+	}
+	if cmd.histogramInterval <= 0 {
+		return errors.New("histogram interval is invalid")
+	}
+	if cmd.histogramFormat != histogramCSV && cmd.histogramFormat != histogramJSON {
+		return errors.New("histogram format is invalid, require csv or json")
+	}
+	if cmd.manifestPath != "" {
+		if cmd.format != formatLine || cmd.usingStdOut() || cmd.v2URL != "" || cmd.serve != "" || cmd.dryRun {
+			return errors.New("manifest is only available for line format written to out file, and not v2 url, serve or dry run")
+		}
+		cmd.outManifest = cmd.newOutputManifest()
+	}
+	if cmd.parquetOut != "" {
+		if cmd.parquetOut == stdoutMark || cmd.splitBy != "" || cmd.chunkInterval > 0 || cmd.v2URL != "" || cmd.serve != "" || cmd.follow || cmd.dryRun {
+			return errors.New("parquet out in addition to another format is not available for standard out, split by, chunk interval, v2 url, serve, follow or dry run")
+		}
+		if _, ok := parquetUnits[cmd.precision]; !ok {
+			return errors.New("precision is invalid for parquet format, require ms, u or ns")
+		}
+		if cmd.parquetLayout != parquetLayoutMeasurement && cmd.parquetLayout != parquetLayoutRP {
+			return errors.New("parquet layout is invalid, require measurement or retention-policy")
+		}
+		// the parquet writer is fed by the shards read one by one
+		cmd.readSerially("parquet out in addition to another format")
+	}
+	if cmd.follow {
+		if cmd.dataDir == "" || cmd.walDir == "" || source.IsDataArchive(cmd.dataDir) || cmd.sourceKind == source.KindTSM {
+			return errors.New("follow is only available for datadir and waldir directories, and not source tsm")
+		}
+		if cmd.format != formatLine || cmd.groupFields || cmd.splitBy != "" || cmd.maxFileSize > 0 || cmd.chunkRotate || cmd.compression != compressionNone || cmd.v2URL != "" || cmd.serve != "" || cmd.manifestPath != "" || cmd.dryRun {
+			return errors.New("follow is only available for line format, and not group fields, split by, max file size, chunk rotate, compression, v2 url, serve, manifest or dry run")
+		}
+		if cmd.database == "" && !cmd.usingStdOut() && cmd.targetURL == "" {
+			return errors.New("follow requires a database, standard out or target url")
+		}
+		if cmd.followInterval <= 0 {
+			return errors.New("follow interval is invalid")
+		}
+	}
+	if cmd.targetURL == "" && (cmd.targetConfig.Username != "" || cmd.targetConfig.Password != "") {
+		return errors.New("must specify target url when target username or target password given")
+	}
+	if cmd.targetURL != "" {
+		if !cmd.follow {
+			return errors.New("target url is only available for follow")
+		}
+		u, err := url.Parse(cmd.targetURL)
+		if err != nil {
+			return fmt.Errorf("parse target url error: %s", err)
+		}
+		cmd.targetConfig.URL = *u
+		cmd.targetConfig.UnsafeSsl = u.Scheme == "https"
+		cmd.targetConfig.Timeout = followWriteTimeout
+	}
+	if cmd.histogramOut != "" && (cmd.dryRun || cmd.format == formatTSMBlocks) {
+		return errors.New("histogram out is not available for dry run or tsm-blocks format")
+	}
+	if cmd.ignoreTombstones && cmd.host != "" {
+		return errors.New("ignore tombstones is not available for host")
+	}
+	if (cmd.strict || cmd.errorReport != "") && cmd.host != "" {
+		return errors.New("strict and error report are not available for host")
+	}
+	if !tsmread.ValidMode(cmd.tsmReadMode) {
+		return errors.New("tsm-read-mode is invalid, require mmap or buffered")
+	}
+	if cmd.maxMemory < 0 {
+		return errors.New("max memory is invalid")
+	}
+	if cmd.maxMemory > 0 && (cmd.host != "" || cmd.dedup || cmd.sorted || cmd.format == formatTSMBlocks) {
+		return errors.New("max memory is not available for host, dedup, sorted or tsm-blocks format")
+	}
 	if cmd.database == "_internal" {
 		return errors.New("database cannot be _internal")
 	}
-	if cmd.retentionPolicy != "" && cmd.database == "" {
+	if cmd.targetDatabase != "" && cmd.database == "" {
+		return errors.New("must specify a database when target database given")
+	}
+	if len(cmd.retentionPolicy) > 0 && cmd.database == "" {
 		return errors.New("must specify a database when retention policy given")
 	}
 	if len(cmd.measurement) > 0 && cmd.database == "" {
 		return errors.New("must specify a database when measurement given")
 	}
+	for _, str := range tf.shardID {
+		id, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return fmt.Errorf("shard id is invalid: %s", str)
+		}
+		cmd.shardIDs = append(cmd.shardIDs, id)
+	}
 	for _, str := range tf.measurement {
 		cmd.measurement[str] = struct{}{}
 	}
@@ -132,6 +701,68 @@ func (cmd *command) validate(tf *tempflag) error {
 			return fmt.Errorf("regexp measurement: %s, compile error: %v", str, err)
 		}
 	}
+	for _, str := range tf.field {
+		cmd.field[str] = struct{}{}
+	}
+	for _, str := range tf.regexpField {
+		if ref, err := regexp.Compile(str); err == nil {
+			cmd.regexpField = append(cmd.regexpField, ref)
+		} else {
+			return fmt.Errorf("regexp field: %s, compile error: %v", str, err)
+		}
+	}
+	for _, str := range tf.tagFilter {
+		f, err := parseTagFilter(str)
+		if err != nil {
+			return err
+		}
+		cmd.tagFilters = append(cmd.tagFilters, f)
+	}
+	if tf.seriesFile != "" {
+		s, err := keyset.Load(tf.seriesFile)
+		if err != nil {
+			return fmt.Errorf("series file: %v", err)
+		}
+		cmd.seriesSet = s
+	}
+	if tf.transform != "" {
+		if (cmd.format != formatLine && cmd.format != formatCSV && cmd.format != formatOM) || cmd.splitBy != "" {
+			return errors.New("transform is only available for line, annotated-csv and openmetrics format, and not split by")
+		}
+		t, err := transform.Load(tf.transform)
+		if err != nil {
+			return fmt.Errorf("transform: %v", err)
+		}
+		cmd.transform = transform.NewHook(t)
+	}
+	if len(tf.counter) > 0 {
+		if !cmd.dedup && !cmd.sorted {
+			return errors.New("counter requires dedup or sorted")
+		}
+		if cmd.format == formatTSMBlocks || cmd.format == formatParquet {
+			return errors.New("counter is not available for tsm-blocks or parquet format")
+		}
+		if cmd.counterUnit <= 0 {
+			return errors.New("counter unit is invalid")
+		}
+		counters, err := parseCounterMap(tf.counter, cmd.counterUnit)
+		if err != nil {
+			return err
+		}
+		cmd.counters = counters
+		// the values of a series are normalized across the shards in order
+		cmd.readSerially("counter")
+	}
+	if cmd.host != "" {
+		addr := fmt.Sprintf("%s:%d", cmd.host, cmd.port)
+		url, err := client.ParseConnectionString(addr, cmd.ssl)
+		if err != nil {
+			return fmt.Errorf("parse url error: %s", err)
+		}
+		cmd.clientConfig.URL = url
+		cmd.clientConfig.UnsafeSsl = cmd.ssl
+		cmd.clientConfig.Precision = "ns"
+	}
 	return nil
 }
 
@@ -139,133 +770,399 @@ func (cmd *command) runE(tf *tempflag) error {
 	if err := cmd.validate(tf); err != nil {
 		return err
 	}
-	if err := cmd.walkTSMFiles(); err != nil {
+	if err := cmd.newSource(); err != nil {
 		return err
 	}
-	if err := cmd.walkWALFiles(); err != nil {
+	if err := cmd.preflight(); err != nil {
 		return err
 	}
-
-	return cmd.write()
-}
-
-func (cmd *command) walkTSMFiles() error {
-	return filepath.Walk(cmd.dataDir, func(path string, f os.FileInfo, err error) error {
-		if err != nil {
+	shards, err := cmd.listShards()
+	if err != nil {
+		return err
+	}
+	cmd.shards = shards
+	if cmd.dryRun {
+		return cmd.writeDryRun(os.Stdout)
+	}
+	if cmd.serve != "" {
+		return cmd.serveExport()
+	}
+	if cmd.metaDir != "" {
+		if err = cmd.loadMeta(); err != nil {
 			return err
 		}
-
-		// check to see if this is a tsm file
-		if filepath.Ext(path) != "."+tsm1.TSMFileExtension {
-			return nil
+	} else if ps, ok := cmd.src.(*source.PortableSource); ok {
+		cmd.metaData = ps.Meta()
+	}
+	if cmd.format == formatLine && !cmd.lponly && cmd.splitBy == "" && cmd.v2URL == "" {
+		if err = cmd.loadDefaultPolicies(); err != nil {
+			return err
 		}
+	}
 
-		relPath, err := filepath.Rel(cmd.dataDir, path)
-		if err != nil {
+	var f *follower
+	if cmd.follow {
+		// the wal is marked before the export, so that no point written meanwhile is missed
+		if f, err = cmd.newFollower(); err != nil {
 			return err
 		}
-		dirs := strings.Split(relPath, string(byte(os.PathSeparator)))
-		if len(dirs) < 2 {
-			return fmt.Errorf("invalid directory structure for %s", path)
+	}
+
+	cmd.startStats(cmd.msgOut())
+	stopProgress := cmd.startProgress()
+	err = cmd.write()
+	stopProgress()
+	if reportErr := cmd.writeErrorReport(); err == nil {
+		err = reportErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = cmd.writeSummary(cmd.msgOut()); err != nil {
+		return err
+	}
+	if err = cmd.writeHistogram(); err != nil {
+		return err
+	}
+	if err = cmd.writeManifest(); err != nil {
+		return err
+	}
+	cmd.saveStats(cmd.msgOut())
+	if f != nil {
+		return f.follow()
+	}
+	return nil
+}
+
+// listShards lists the shards of the retention policies included and not excluded, and of the shard ids if given.
+func (cmd *command) listShards() ([]*source.Shard, error) {
+	rp := ""
+	if len(cmd.retentionPolicy) == 1 && len(cmd.excludeRps) == 0 {
+		rp = cmd.retentionPolicy[0]
+	}
+	shards, err := cmd.src.ListShards(cmd.database, rp)
+	if err != nil {
+		return nil, err
+	}
+	filtered := shards[:0]
+	for _, sh := range shards {
+		if cmd.includeRp(sh.RetentionPolicy) && cmd.includeShard(sh.ID) {
+			filtered = append(filtered, sh)
 		}
-		if dirs[0] != "_internal" && (dirs[0] == cmd.database || cmd.database == "") {
-			if dirs[1] == cmd.retentionPolicy || cmd.retentionPolicy == "" {
-				key := filepath.Join(dirs[0], dirs[1])
-				cmd.manifest[key] = struct{}{}
-				cmd.tsmFiles[key] = append(cmd.tsmFiles[key], path)
+	}
+	// a shard id not found is likely mistyped, or not in the database and retention policies exported
+	for _, id := range cmd.shardIDs {
+		found := false
+		for _, sh := range filtered {
+			if sh.ID == id {
+				found = true
+				break
 			}
 		}
+		if !found {
+			return nil, fmt.Errorf("shard %d not found", id)
+		}
+	}
+	return filtered, nil
+}
+
+func (cmd *command) includeShard(id uint64) bool {
+	if len(cmd.shardIDs) == 0 {
+		return true
+	}
+	for _, shardID := range cmd.shardIDs {
+		if shardID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmd *command) includeRp(rp string) bool {
+	return (len(cmd.retentionPolicy) == 0 || containsString(cmd.retentionPolicy, rp)) && !containsString(cmd.excludeRps, rp)
+}
+
+// preflight checks the engine of the shards in datadir, whose tsm files are read directly without the index.
+func (cmd *command) preflight() error {
+	if _, ok := cmd.src.(*source.FileSource); !ok {
 		return nil
+	}
+	shards, err := preflight.Inspect(cmd.dataDir, cmd.database, "")
+	if err != nil {
+		return err
+	}
+	filtered := shards[:0]
+	for _, sh := range shards {
+		if cmd.includeRp(sh.RetentionPolicy) {
+			filtered = append(filtered, sh)
+		}
+	}
+	return preflight.Check(filtered, "", func(format string, v ...interface{}) {
+		fmt.Fprintf(cmd.msgOut(), format+"\n", v...)
 	})
 }
 
-func (cmd *command) walkWALFiles() error {
-	return filepath.Walk(cmd.walDir, func(path string, f os.FileInfo, err error) error {
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmd *command) newSource() error {
+	switch {
+	case source.IsPortableBackup(cmd.backupPath):
+		ps, err := source.NewPortableSource(cmd.backupPath)
 		if err != nil {
 			return err
 		}
-
-		// check to see if this is a wal file
-		fileName := filepath.Base(path)
-		if filepath.Ext(path) != "."+tsm1.WALFileExtension || !strings.HasPrefix(fileName, tsm1.WALFilePrefix) {
-			return nil
+		cmd.src, cmd.kind = ps, "backup file"
+	case cmd.backupPath != "":
+		cmd.src, cmd.kind = source.NewArchiveSource(cmd.backupPath), "backup file"
+	case cmd.host != "":
+		c, err := client.NewClient(cmd.clientConfig)
+		if err != nil {
+			return fmt.Errorf("could not create client: %s", err)
+		}
+		if _, _, err = c.Ping(); err != nil {
+			return fmt.Errorf("failed to connect to %s: %s", c.Addr(), err)
 		}
+		cmd.src, cmd.kind = source.NewHTTPSource(c), "live server"
+	case source.IsDataArchive(cmd.dataDir):
+		paths := []string{cmd.dataDir}
+		if cmd.walDir != "" && cmd.walDir != cmd.dataDir {
+			paths = append(paths, cmd.walDir)
+		}
+		cmd.src, cmd.kind = source.NewDataArchiveSource(paths, cmd.strictOrder), "archived "+kindFiles(cmd.sourceKind)
+	default:
+		cmd.src, cmd.kind = source.NewFileSource(cmd.dataDir, cmd.walDir, cmd.strictOrder, cmd.tsmReadMode), kindFiles(cmd.sourceKind)
+	}
+	if ks, ok := cmd.src.(source.KindSource); ok && cmd.sourceKind != source.KindAll {
+		ks.SelectKind(cmd.sourceKind)
+	}
+	if ts, ok := cmd.src.(source.TombstoneSource); ok && cmd.ignoreTombstones {
+		ts.IgnoreTombstones()
+	}
+	if ls, ok := cmd.src.(source.LimitSource); ok && cmd.bpsLimiter != nil {
+		ls.LimitReads(cmd.bpsLimiter)
+	}
+	if cs, ok := cmd.src.(source.CorruptSource); ok {
+		cmd.corrupt = source.NewCorruptions(cmd.strict)
+		cs.RecordCorruptions(cmd.corrupt)
+	}
+	return nil
+}
+
+// limitWriter returns w limited by bps if given, sharing the limit with the reads.
+func (cmd *command) limitWriter(w io.Writer) io.Writer {
+	if cmd.bpsLimiter == nil {
+		return w
+	}
+	return ratelimit.NewWriter(w, cmd.bpsLimiter)
+}
+
+// kindFiles returns the description of the files of the source kind.
+func kindFiles(kind string) string {
+	switch kind {
+	case source.KindTSM:
+		return "tsm file"
+	case source.KindWAL:
+		return "wal file"
+	}
+	return "tsm and wal file"
+}
 
-		relPath, err := filepath.Rel(cmd.walDir, path)
+// loadDefaultPolicies reads the default retention policies of the databases exported from the meta of metadir if
+// given, or the source otherwise for the DDL, except the ones given by default retention policy.
+func (cmd *command) loadDefaultPolicies() error {
+	ps, ok := cmd.src.(source.PolicySource)
+	if !ok && cmd.metaData == nil {
+		return nil
+	}
+	for _, key := range cmd.manifest() {
+		if _, ok := cmd.defaultPolicies[key.db]; ok {
+			continue
+		}
+		if cmd.metaData != nil {
+			if dbi := cmd.metaData.Database(key.db); dbi != nil {
+				cmd.defaultPolicies[key.db] = dbi.DefaultRetentionPolicy
+			}
+			continue
+		}
+		rp, err := ps.DefaultPolicy(key.db)
 		if err != nil {
 			return err
 		}
-		dirs := strings.Split(relPath, string(byte(os.PathSeparator)))
-		if len(dirs) < 2 {
-			return fmt.Errorf("invalid directory structure for %s", path)
-		}
-		if dirs[0] != "_internal" && (dirs[0] == cmd.database || cmd.database == "") {
-			if dirs[1] == cmd.retentionPolicy || cmd.retentionPolicy == "" {
-				key := filepath.Join(dirs[0], dirs[1])
-				cmd.manifest[key] = struct{}{}
-				cmd.walFiles[key] = append(cmd.walFiles[key], path)
-			}
+		cmd.defaultPolicies[key.db] = rp
+	}
+	return nil
+}
+
+// loadMeta reads the meta.db of metadir.
+func (cmd *command) loadMeta() error {
+	b, err := os.ReadFile(filepath.Join(cmd.metaDir, "meta.db"))
+	if err != nil {
+		return fmt.Errorf("read meta file error: %v", err)
+	}
+	cmd.metaData = &meta.Data{}
+	if err = cmd.metaData.UnmarshalBinary(b); err != nil {
+		return fmt.Errorf("read meta file error: %v", err)
+	}
+	return nil
+}
+
+// policyOptions returns the options of the retention policy of the database exported read from metadir, which are
+// "DURATION 0s REPLICATION 1" if unknown, and the shard duration too if known.
+func (cmd *command) policyOptions(db, rp string) string {
+	var rpi *meta.RetentionPolicyInfo
+	if cmd.metaData != nil {
+		rpi, _ = cmd.metaData.RetentionPolicy(db, rp)
+	}
+	if rpi == nil {
+		return "DURATION 0s REPLICATION 1"
+	}
+	return fmt.Sprintf("DURATION %s REPLICATION %d SHARD DURATION %s", influxql.FormatDuration(rpi.Duration), rpi.ReplicaN,
+		influxql.FormatDuration(rpi.ShardGroupDuration))
+}
+
+// alterAutogen writes the statement altering autogen created with the database to the options read from metadir, if
+// known.
+func (cmd *command) alterAutogen(w io.Writer, qdb, db string) {
+	if cmd.metaData == nil {
+		return
+	}
+	if rpi, _ := cmd.metaData.RetentionPolicy(db, "autogen"); rpi != nil {
+		fmt.Fprintf(w, "ALTER RETENTION POLICY autogen ON %s %s\n", qdb, cmd.policyOptions(db, "autogen"))
+	}
+}
+
+// databaseOptions returns the options of creating the database with the retention policy as the default, with the
+// duration, replication and shard duration of it if read from metadir.
+func (cmd *command) databaseOptions(db, rp string) string {
+	qrp := influxql.QuoteIdent(rp)
+	if cmd.metaData != nil {
+		if rpi, _ := cmd.metaData.RetentionPolicy(db, rp); rpi != nil {
+			return "WITH " + cmd.policyOptions(db, rp) + " NAME " + qrp
 		}
-		return nil
-	})
+	}
+	return "WITH NAME " + qrp
 }
 
+// writeDDL writes the statements creating the databases and retention policies exported. A database is created with
+// its retention policy as the default, or autogen if several, unless its default retention policy is known to be
+// another one, then the default is marked by altering it, since creating a database with a retention policy fails
+// once the database exists with another default.
 func (cmd *command) writeDDL(mw io.Writer, w io.Writer) error {
 	// Write out all the DDL
 	fmt.Fprintln(mw, "# DDL")
+	var dbs []string
+	sources := make(map[string]string) // database exported of each database written
 	manifest := make(map[string][]string)
-	for key := range cmd.manifest {
-		keys := strings.Split(key, string(os.PathSeparator))
-		db, rp := influxql.QuoteIdent(keys[0]), influxql.QuoteIdent(keys[1])
-		manifest[db] = append(manifest[db], rp)
-	}
-	for db, rps := range manifest {
-		if len(rps) > 1 {
-			fmt.Fprintf(w, "CREATE DATABASE %s WITH NAME autogen\n", db)
-			for _, rp := range rps {
-				if rp != "autogen" {
-					fmt.Fprintf(w, "CREATE RETENTION POLICY %s ON %s DURATION 0s REPLICATION 1\n", rp, db)
-				}
+	for _, key := range cmd.manifest() {
+		db := cmd.contextDatabase(key.db)
+		if _, ok := manifest[db]; !ok {
+			dbs = append(dbs, db)
+			sources[db] = key.db
+		}
+		manifest[db] = append(manifest[db], key.rp)
+	}
+	for _, db := range dbs {
+		rps, qdb := manifest[db], influxql.QuoteIdent(db)
+		def := cmd.defaultPolicies[sources[db]]
+		switch {
+		case len(rps) > 1 && (def == "" || def == "autogen"):
+			fmt.Fprintf(w, "CREATE DATABASE %s %s\n", qdb, cmd.databaseOptions(sources[db], "autogen"))
+			cmd.writeRetentionPolicies(w, qdb, sources[db], rps)
+		case len(rps) == 1 && (def == "" || def == rps[0]):
+			fmt.Fprintf(w, "CREATE DATABASE %s %s\n", qdb, cmd.databaseOptions(sources[db], rps[0]))
+		default:
+			fmt.Fprintf(w, "CREATE DATABASE %s\n", qdb)
+			if containsString(rps, "autogen") {
+				cmd.alterAutogen(w, qdb, sources[db])
+			}
+			cmd.writeRetentionPolicies(w, qdb, sources[db], rps)
+			if def != "autogen" && containsString(rps, def) {
+				fmt.Fprintf(w, "ALTER RETENTION POLICY %s ON %s DEFAULT\n", influxql.QuoteIdent(def), qdb)
 			}
-		} else {
-			fmt.Fprintf(w, "CREATE DATABASE %s WITH NAME %s\n", db, rps[0])
 		}
 	}
 
 	return nil
 }
 
+// writeRetentionPolicies writes the statements creating the retention policies of the database exported db but
+// autogen, which is created with the database.
+func (cmd *command) writeRetentionPolicies(w io.Writer, qdb, db string, rps []string) {
+	for _, rp := range rps {
+		if rp != "autogen" {
+			fmt.Fprintf(w, "CREATE RETENTION POLICY %s ON %s %s\n", influxql.QuoteIdent(rp), qdb, cmd.policyOptions(db, rp))
+		}
+	}
+}
+
 func (cmd *command) writeDML(mw io.Writer, w io.Writer) error {
 	fmt.Fprintln(mw, "# DML")
-	var msgOut io.Writer
-	if cmd.usingStdOut() {
-		msgOut = os.Stderr
-	} else {
-		msgOut = os.Stdout
-	}
-	for key := range cmd.manifest {
-		keys := strings.Split(key, string(os.PathSeparator))
-		fmt.Fprintf(mw, "# CONTEXT-DATABASE:%s\n", keys[0])
-		fmt.Fprintf(mw, "# CONTEXT-RETENTION-POLICY:%s\n", keys[1])
-		if files, ok := cmd.tsmFiles[key]; ok {
-			fmt.Fprintf(msgOut, "writing out tsm file data for %s%s...", key, cmd.withMeasurement())
-			if err := cmd.writeTsmFiles(mw, w, files); err != nil {
-				return err
-			}
-			fmt.Fprintln(msgOut, "complete.")
-		}
-		if _, ok := cmd.walFiles[key]; ok {
-			fmt.Fprintf(msgOut, "writing out wal file data for %s%s...", key, cmd.withMeasurement())
-			if err := cmd.writeWALFiles(mw, w, cmd.walFiles[key], key); err != nil {
-				return err
-			}
-			fmt.Fprintln(msgOut, "complete.")
+	msgOut := cmd.msgOut()
+	for _, key := range cmd.manifest() {
+		cmd.context = key
+		cmd.startKey(key)
+		cmd.writeContext(mw, key.db, key.rp)
+		fmt.Fprintf(msgOut, "writing out %s data for %s%s...", cmd.kind, filepath.Join(key.db, key.rp), cmd.withMeasurement())
+		fmt.Fprintf(mw, "# writing %s data\n", cmd.kind)
+		if err := cmd.readWindows(key.shards, w); err != nil {
+			return err
 		}
+		fmt.Fprintf(msgOut, "complete%s.\n", cmd.keyDone())
 	}
-
 	return nil
 }
 
+// reportSkipped reports the values skipped and the points with empty tags or fields, if any.
+func (cmd *command) reportSkipped() {
+	if overflows := cmd.overflows.Load(); overflows > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d unsigned values overflowing integer\n", overflows)
+	}
+	if nans := cmd.nans.Load(); nans > 0 {
+		done := map[string]string{nonfiniteDrop: "dropped", nonfiniteZero: "zeroed", nonfiniteString: "written as strings"}[cmd.nonfinite]
+		fmt.Fprintf(os.Stderr, "%s %d non-finite float values\n", done, nans)
+	}
+	if strs := cmd.strs.Load(); strs > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d string values not written as openmetrics samples\n", strs)
+	}
+	if report := cmd.prefixes.empty.Report("points"); report != "" {
+		fmt.Fprintln(os.Stderr, report)
+	}
+	if report := cmd.transform.Report("series of shards"); report != "" {
+		fmt.Fprintln(os.Stderr, report)
+	}
+	if skipped := cmd.rekeySkipped.Load(); skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d tag values unable to be converted to the field types of tag to field\n", skipped)
+	}
+	if skipped := cmd.shiftSkipped.Load(); skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d values shifted out of the time range of influxdb\n", skipped)
+	}
+	if report := cmd.counters.report(); report != "" {
+		fmt.Fprintln(os.Stderr, report)
+	}
+	if cmd.teeConflicts > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d values conflicting with the field types of parquet columns\n", cmd.teeConflicts)
+	}
+	if report := cmd.corruptReport(); report != "" {
+		fmt.Fprintln(os.Stderr, report)
+	}
+}
+
+// writeContext writes the context comments of the lines of the database and retention policy following.
+func (cmd *command) writeContext(mw io.Writer, db, rp string) {
+	fmt.Fprintf(mw, "# CONTEXT-DATABASE:%s\n", cmd.contextDatabase(db))
+	fmt.Fprintf(mw, "# CONTEXT-RETENTION-POLICY:%s\n", rp)
+	if cmd.precision != precisionNs {
+		fmt.Fprintf(mw, "# CONTEXT-PRECISION:%s\n", cmd.precision)
+	}
+}
+
 // writeFull writes the full DML and DDL to the supplied io.Writers.  mw is the
 // "meta" writer where comments and other informational writes go and w is for
 // the actual payload of the writes -- DML and DDL.
@@ -282,6 +1179,12 @@ func (cmd *command) writeFull(mw io.Writer, w io.Writer) error {
 		if err := cmd.writeDDL(mw, w); err != nil {
 			return err
 		}
+		// the deletes are not repeated in the rotated files, which are imported after the first one
+		if cmd.includeDeletes {
+			if err := cmd.writeDeletes(mw, w); err != nil {
+				return err
+			}
+		}
 	}
 
 	if err := cmd.writeDML(mw, w); err != nil {
@@ -292,6 +1195,33 @@ func (cmd *command) writeFull(mw io.Writer, w io.Writer) error {
 }
 
 func (cmd *command) write() error {
+	if cmd.format == formatParquet {
+		return cmd.writeParquet()
+	}
+	if cmd.splitBy != "" {
+		return cmd.writeSplit()
+	}
+	var err error
+	if cmd.v2URL != "" {
+		err = cmd.writeV2()
+	} else if (cmd.format == formatLine || cmd.format == formatCSV || cmd.format == formatOM) && cmd.database == "" && !cmd.usingStdOut() {
+		err = cmd.writeDatabases()
+	} else {
+		err = cmd.writeFile()
+	}
+	if err != nil {
+		return err
+	}
+	cmd.reportSkipped()
+	return nil
+}
+
+// writeFile writes the shards to the out file, or the files rotated by max file size or chunk rotate.
+func (cmd *command) writeFile() (err error) {
+	if cmd.maxFileSize > 0 || cmd.chunkRotate {
+		return cmd.writeRotated()
+	}
+
 	var w io.Writer
 	if cmd.usingStdOut() {
 		w = os.Stdout
@@ -304,17 +1234,45 @@ func (cmd *command) write() error {
 		defer f.Close()
 		w = f
 	}
+	w = cmd.limitWriter(w)
 	// Because calling (*os.File).Write is relatively expensive,
 	// and we don't *need* to sync to disk on every written line of export,
 	// use a sized buffered writer so that we only sync the file every megabyte.
 	bw := bufio.NewWriterSize(w, 1024*1024)
 	defer bw.Flush()
 	w = bw
+	below := []flusher{bw}
 
 	if cmd.compress {
-		gzw := gzip.NewWriter(w)
-		defer gzw.Close()
-		w = gzw
+		cw, err := cmd.newCompressWriter(w)
+		if err != nil {
+			return err
+		}
+		defer cw.Close()
+		w = cw
+		if f, ok := cw.(flusher); ok {
+			below = append([]flusher{f}, below...)
+		}
+	}
+
+	// the lines are encoded while the previous ones are compressed and written
+	pw := newPipeWriter(cmd.manifestWriter(w, cmd.out), pipelineChunkSize, pipelineDepth)
+	pw.below = below
+	defer func() {
+		if cerr := pw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	w = pw
+
+	if cmd.format == formatTSMBlocks {
+		return cmd.writeBlocks(w)
+	}
+	if cmd.format == formatCSV {
+		return cmd.writeCSV(w)
+	}
+	if cmd.format == formatOM {
+		return cmd.writeOpenMetrics(w)
 	}
 
 	// mw is our "meta writer" -- the io.Writer to which meta/out-of-band data
@@ -334,192 +1292,315 @@ func (cmd *command) write() error {
 	return cmd.writeFull(mw, w)
 }
 
-func (cmd *command) writeTsmFiles(mw io.Writer, w io.Writer, files []string) error {
-	fmt.Fprintln(mw, "# writing tsm data")
-
-	// we need to make sure we write the same order that the files were written
-	sort.Strings(files)
-
-	for _, f := range files {
-		if err := cmd.exportTSMFile(f, w); err != nil {
-			return err
-		}
-	}
-
-	return nil
+// writeSeries returns the function writing the values of a series read from source to w with the line prefixes
+// cached in prefixes, the unmatched series are skipped.
+func (cmd *command) writeSeries(w io.Writer, prefixes *prefixCache) func(seriesKey, field []byte, values []tsm1.Value) error {
+	return cmd.handleSeries(prefixes, func(prefix []byte, values []tsm1.Value) error {
+		// An error from writeValues indicates an IO error, which should be returned.
+		return cmd.writeValues(w, prefix, values)
+	})
 }
 
-func (cmd *command) exportTSMFile(tsmFilePath string, w io.Writer) error {
-	f, err := os.Open(tsmFilePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			fmt.Fprintf(w, "skipped missing file: %s", tsmFilePath)
+// handleSeries returns the function passing the values of a matched series read from source to fn with the line
+// prefix "<series_key> <field>=" cached in prefixes, the unmatched series are skipped. The series and values are
+// transformed first if transform given, and the values of counters normalized then.
+func (cmd *command) handleSeries(prefixes *prefixCache, fn func(prefix []byte, values []tsm1.Value) error) func(seriesKey, field []byte, values []tsm1.Value) error {
+	var lastKey, lastField []byte
+	var series *transform.Series // series of the last key transformed, nil if dropped
+	var transformed []byte       // line prefix of the series transformed
+	var counter *counterState    // state of the last key if a counter
+	var buf, shifted, counted []tsm1.Value
+	return func(seriesKey, field []byte, values []tsm1.Value) error {
+		prefix, r, ok := prefixes.Get(seriesKey, field, cmd.matchSeriesField)
+		if r != empty.None {
+			prefixes.empty.Add(r, len(values))
+		}
+		if !ok {
 			return nil
 		}
-		return err
-	}
-	defer f.Close()
-
-	r, err := tsm1.NewTSMReader(f)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "unable to read %s, skipping: %s\n", tsmFilePath, err.Error())
-		return nil
-	}
-	defer r.Close()
-
-	if sgStart, sgEnd := r.TimeRange(); sgStart > cmd.endTime || sgEnd < cmd.startTime {
-		return nil
-	}
-
-	for i := 0; i < r.KeyCount(); i++ {
-		key, _ := r.KeyAt(i)
-		values, err := r.ReadAll(key)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "unable to read key %q in %s, skipping: %s\n", string(key), tsmFilePath, err.Error())
-			continue
+		// the values of a series are read consecutively in a shard
+		if !bytes.Equal(seriesKey, lastKey) || !bytes.Equal(field, lastField) {
+			lastKey, lastField = append(lastKey[:0], seriesKey...), append(lastField[:0], field...)
+			cmd.addSeries(seriesKey, field)
+			if cmd.transform != nil {
+				series, transformed = cmd.transformSeries(prefix)
+			}
+			if cmd.counters != nil {
+				counter = cmd.counterState(seriesKey, field)
+			}
 		}
-		seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
-		name := models.ParseName(seriesKey)
-		if !cmd.matchMeasurement(string(name)) {
-			continue
+		cmd.addHistogram(seriesKey, values)
+		if cmd.transform != nil {
+			if series == nil {
+				cmd.transform.DropValues(len(values))
+				return nil
+			}
+			// the values may be the ones of source, so that they are transformed into buf
+			buf = buf[:0]
+			for _, v := range values {
+				tv, ok, err := cmd.transform.Value(series, v.UnixNano(), v.Value())
+				if err != nil {
+					return err
+				}
+				if ok {
+					buf = append(buf, tsm1.NewValue(v.UnixNano(), tv))
+				}
+			}
+			prefix, values = transformed, buf
 		}
-		// seriesKey are stored escaped, field names are not
-		field = escape.Bytes(field)
-		if err := cmd.writeValues(w, seriesKey, string(field), values); err != nil {
-			// An error from writeValues indicates an IO error, which should be returned.
-			return err
+		if cmd.shift != 0 {
+			shifted = cmd.shiftValues(shifted[:0], values)
+			values = shifted
+		}
+		if counter != nil {
+			counted = counter.apply(counted[:0], values)
+			values = counted
+		}
+		if len(values) == 0 {
+			return nil
 		}
+		return fn(prefix, values)
 	}
-	return nil
 }
 
-func (cmd *command) writeWALFiles(mw io.Writer, w io.Writer, files []string, key string) error {
-	fmt.Fprintln(mw, "# writing wal data")
-
-	// we need to make sure we write the same order that the wal received the data
-	sort.Strings(files)
-
-	var once sync.Once
-	warnDelete := func() {
-		once.Do(func() {
-			msg := fmt.Sprintf(`WARNING: detected deletes in wal file.
-Some series for %q may be brought back by replaying this data.
-To resolve, you can either let the shard snapshot prior to exporting the data
-or manually editing the exported file.
-			`, key)
-			fmt.Fprintln(os.Stderr, msg)
-		})
+// counterState returns the counter state of the field of the series in the database and retention policy of the
+// context, or nil if not a counter.
+func (cmd *command) counterState(seriesKey, field []byte) *counterState {
+	var db, rp string
+	if cmd.context != nil {
+		db, rp = cmd.context.db, cmd.context.rp
 	}
+	return cmd.counters.state(db, rp, seriesKey, field)
+}
 
-	for _, f := range files {
-		if err := cmd.exportWALFile(f, w, warnDelete); err != nil {
-			return err
-		}
+// transformSeries returns the series of the line prefix "<series_key> <field>=" transformed and its line prefix, or
+// nil if dropped.
+func (cmd *command) transformSeries(prefix []byte) (*transform.Series, []byte) {
+	var db, rp string
+	if cmd.context != nil {
+		db, rp = cmd.context.db, cmd.context.rp
 	}
-
-	return nil
+	n := seriesLen(prefix)
+	name, tags := empty.ParseKey(prefix[:n])
+	s, ok := cmd.transform.Series(db, rp, name, tags, escape.Unescape(prefix[n+1:len(prefix)-1]))
+	if !ok {
+		cmd.transform.DropSeries()
+		return nil, nil
+	}
+	name, tags, field := s.Key()
+	transformed := models.MakeKey(name, tags)
+	transformed = append(transformed, ' ')
+	transformed = append(transformed, escape.Bytes(field)...)
+	return s, append(transformed, '=')
 }
 
-// exportWAL reads every WAL entry from r and exports it to w.
-func (cmd *command) exportWALFile(walFilePath string, w io.Writer, warnDelete func()) error {
-	f, err := os.Open(walFilePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			fmt.Fprintf(w, "skipped missing file: %s", walFilePath)
-			return nil
-		}
+// readValues reads the values of the shard from source and writes the lines to w with the line prefixes cached in
+// prefixes, the fields of a series are merged into lines if grouping fields, or by timestamp if sorted.
+func (cmd *command) readValues(sh *source.Shard, w io.Writer, prefixes *prefixCache) error {
+	if cmd.format == formatCSV {
+		return cmd.readCSV(sh, w, prefixes)
+	}
+	if cmd.format == formatOM {
+		return cmd.readOpenMetrics(sh, w, prefixes)
+	}
+	if cmd.serve != "" {
+		return cmd.readArrow(sh, w, prefixes)
+	}
+	if !cmd.groupFields && !cmd.sorted {
+		return cmd.readSource(sh, cmd.writeSeries(w, prefixes))
+	}
+	g := cmd.newFieldGroup(w)
+	if err := cmd.readSource(sh, cmd.handleSeries(prefixes, g.add)); err != nil {
 		return err
 	}
-	defer f.Close()
+	return g.flush()
+}
 
-	r := tsm1.NewWALSegmentReader(f)
-	defer r.Close()
+// valueMemory is the bytes of a decoded value in memory, the interface and the timestamp and value it points to.
+const valueMemory = 40
 
-	for r.Next() {
-		entry, err := r.Read()
-		if err != nil {
-			n := r.Count()
-			fmt.Fprintf(os.Stderr, "file %s corrupt at position %d: %v", walFilePath, n, err)
-			break
+// readSource reads the values of the shard from source within the time range, merged by series with the value
+// written last kept for each timestamp if deduplicating or sorted, or in chunks of the values within max memory if given.
+func (cmd *command) readSource(sh *source.Shard, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	if cmd.tee != nil {
+		fn = cmd.teeSeries(sh, fn)
+	}
+	if l := cmd.ppsLimiter; l != nil {
+		next := fn
+		fn = func(seriesKey, field []byte, values []tsm1.Value) error {
+			l.WaitN(len(values))
+			return next(seriesKey, field, values)
 		}
-
-		switch t := entry.(type) {
-		case *tsm1.DeleteWALEntry, *tsm1.DeleteRangeWALEntry:
-			warnDelete()
-			continue
-		case *tsm1.WriteWALEntry:
-			for key, values := range t.Values {
-				seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(key))
-				name := models.ParseName(seriesKey)
-				if !cmd.matchMeasurement(string(name)) {
-					continue
-				}
-				// seriesKey are stored escaped, field names are not
-				field = escape.Bytes(field)
-				if err := cmd.writeValues(w, seriesKey, string(field), values); err != nil {
-					// An error from writeValues indicates an IO error, which should be returned.
-					return err
-				}
-			}
+	}
+	if cmd.dedup || cmd.sorted {
+		return cmd.src.(source.MergeSource).ReadMergedValues(sh, cmd.startTime, cmd.endTime, fn)
+	}
+	if cs, ok := cmd.src.(source.ChunkSource); ok && cmd.maxMemory > 0 {
+		// a block is decoded at once anyway
+		maxValues := int(cmd.maxMemory / valueMemory)
+		if maxValues < tsdb.DefaultMaxPointsPerBlock {
+			maxValues = tsdb.DefaultMaxPointsPerBlock
 		}
+		return cs.ReadChunkedValues(sh, cmd.startTime, cmd.endTime, maxValues, fn)
 	}
-	return nil
+	return cmd.src.ReadValues(sh, cmd.startTime, cmd.endTime, fn)
 }
 
-// writeValues writes every value in values to w, using the given series key and field name.
-// If any call to w.Write fails, that error is returned.
-func (cmd *command) writeValues(w io.Writer, seriesKey []byte, field string, values []tsm1.Value) error {
-	buf := []byte(string(seriesKey) + " " + field + "=")
-	prefixLen := len(buf)
+// writeValues writes every value in values to w, using the given prefix "<series_key> <field>=".
+// The lines are encoded into a pooled buffer and written at once, and an error of w.Write is returned.
+func (cmd *command) writeValues(w io.Writer, prefix []byte, values []tsm1.Value) error {
+	bp := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bp)
+	buf := (*bp)[:0]
 
+	points := 0
 	for _, value := range values {
-		ts := value.UnixNano()
-		if (ts < cmd.startTime) || (ts > cmd.endTime) {
+		n := len(buf)
+		buf = append(buf, prefix...)
+		var ok bool
+		if buf, ok = cmd.appendValue(buf, value); !ok {
+			buf = buf[:n]
 			continue
 		}
+		// Now buf has "<series_key> <field>=<value>".
+		buf = cmd.appendTimestamp(buf, value.UnixNano())
+		points++
+	}
+	*bp = buf
+	cmd.addWritten(points, len(buf))
 
-		// Re-slice buf to be "<series_key> <field>=".
-		buf = buf[:prefixLen]
+	// Underlying IO error needs to be returned.
+	_, err := w.Write(buf)
+	return err
+}
 
-		// Append the correct representation of the value.
-		switch v := value.Value().(type) {
-		case float64:
-			buf = strconv.AppendFloat(buf, v, 'g', -1, 64)
-		case int64:
-			buf = strconv.AppendInt(buf, v, 10)
-			buf = append(buf, 'i')
-		case uint64:
+// appendValue appends the representation of the value, or returns false if the value is skipped, such as the
+// non-finite floats dropped and the unsigned values overflowing integer.
+func (cmd *command) appendValue(buf []byte, value tsm1.Value) ([]byte, bool) {
+	// The concrete types are switched first, so that Value() is inlined without allocating for the interface
+	// it returns.
+	switch tv := value.(type) {
+	case tsm1.FloatValue:
+		v := tv.Value().(float64)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			cmd.nans.Add(1)
+			if cmd.nonfinite == nonfiniteDrop {
+				return buf, false
+			} else if cmd.nonfinite == nonfiniteString {
+				buf = append(buf, '"')
+				buf = strconv.AppendFloat(buf, v, 'g', -1, 64)
+				return append(buf, '"'), true
+			}
+			v = 0
+		}
+		return strconv.AppendFloat(buf, v, cmd.floatFormat, cmd.floatPrecision, 64), true
+	case tsm1.IntegerValue:
+		buf = strconv.AppendInt(buf, tv.Value().(int64), 10)
+		return append(buf, 'i'), true
+	case tsm1.UnsignedValue:
+		v := tv.Value().(uint64)
+		if !cmd.uintAsInt {
 			buf = strconv.AppendUint(buf, v, 10)
-			buf = append(buf, 'u')
-		case bool:
-			buf = strconv.AppendBool(buf, v)
-		case string:
-			buf = append(buf, '"')
-			buf = append(buf, models.EscapeStringField(v)...)
-			buf = append(buf, '"')
-		default:
-			// This shouldn't be possible, but we'll format it anyway.
-			buf = append(buf, fmt.Sprintf("%v", v)...)
+			return append(buf, 'u'), true
+		} else if v > math.MaxInt64 {
+			cmd.overflows.Add(1)
+			return buf, false
 		}
+		buf = strconv.AppendUint(buf, v, 10)
+		return append(buf, 'i'), true
+	case tsm1.BooleanValue:
+		return cmd.appendBool(buf, tv.Value().(bool)), true
+	case tsm1.StringValue:
+		buf = append(buf, '"')
+		buf = append(buf, models.EscapeStringField(tv.Value().(string))...)
+		return append(buf, '"'), true
+	default:
+		// This shouldn't be possible, but we'll format it anyway.
+		return append(buf, fmt.Sprintf("%v", value.Value())...), true
+	}
+}
 
-		// Now buf has "<series_key> <field>=<value>".
-		// Append the timestamp and a newline, then write it.
-		buf = append(buf, ' ')
-		buf = strconv.AppendInt(buf, ts, 10)
-		buf = append(buf, '\n')
-		if _, err := w.Write(buf); err != nil {
-			// Underlying IO error needs to be returned.
-			return err
+// appendTimestamp appends the timestamp in precision and a newline.
+func (cmd *command) appendTimestamp(buf []byte, ts int64) []byte {
+	if cmd.precDiv > 1 {
+		ts = floorDiv(ts, cmd.precDiv)
+	}
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, ts, 10)
+	return append(buf, '\n')
+}
+
+// appendBool appends the boolean value in the bool format.
+func (cmd *command) appendBool(buf []byte, v bool) []byte {
+	switch cmd.boolFormat {
+	case boolT:
+		if v {
+			return append(buf, 't')
+		}
+		return append(buf, 'f')
+	case boolInt:
+		if v {
+			return append(buf, '1', 'i')
 		}
+		return append(buf, '0', 'i')
+	default:
+		return strconv.AppendBool(buf, v)
 	}
+}
 
-	return nil
+type manifestKey struct {
+	db, rp string
+	shards []*source.Shard
+}
+
+// manifest returns the shards grouped by database and retention policy, in the order of shards.
+func (cmd *command) manifest() []*manifestKey {
+	var keys []*manifestKey
+	for _, sh := range cmd.shards {
+		if n := len(keys); n == 0 || keys[n-1].db != sh.Database || keys[n-1].rp != sh.RetentionPolicy {
+			keys = append(keys, &manifestKey{db: sh.Database, rp: sh.RetentionPolicy})
+		}
+		keys[len(keys)-1].shards = append(keys[len(keys)-1].shards, sh)
+	}
+	return keys
+}
+
+// contextDatabase returns the database name written for db, which is the target database if given.
+func (cmd *command) contextDatabase(db string) string {
+	if cmd.targetDatabase != "" {
+		return cmd.targetDatabase
+	}
+	return db
+}
+
+// floorDiv divides the timestamp rounding towards negative infinity, so that the timestamps before
+// the epoch are truncated to the start of their unit as well.
+func floorDiv(ts, div int64) int64 {
+	q := ts / div
+	if ts%div < 0 {
+		q--
+	}
+	return q
 }
 
 func (cmd *command) usingStdOut() bool {
 	return cmd.out == stdoutMark
 }
 
+// msgOut returns the writer of progress messages, which is stderr when exporting to stdout, or discards them
+// if quiet.
+func (cmd *command) msgOut() io.Writer {
+	if cmd.msgs == nil {
+		cmd.msgs = &messageWriter{w: os.Stdout}
+		if cmd.quiet {
+			cmd.msgs.w = io.Discard
+		} else if cmd.usingStdOut() {
+			cmd.msgs.w = os.Stderr
+		}
+	}
+	return cmd.msgs
+}
+
 func (cmd *command) matchMeasurement(m string) bool {
 	if len(cmd.measurement) == 0 && len(cmd.regexpMeasurement) == 0 {
 		return true
@@ -539,6 +1620,21 @@ func (cmd *command) matchMeasurement(m string) bool {
 	return false
 }
 
+func (cmd *command) matchField(f []byte) bool {
+	if len(cmd.field) == 0 && len(cmd.regexpField) == 0 {
+		return true
+	}
+	if _, ok := cmd.field[string(f)]; ok {
+		return true
+	}
+	for _, ref := range cmd.regexpField {
+		if ref.Match(f) {
+			return true
+		}
+	}
+	return false
+}
+
 func (cmd *command) withMeasurement() string {
 	if len(cmd.measurement) > 0 && len(cmd.regexpMeasurement) > 0 {
 		return fmt.Sprintf(" with %d measurements and %d regexp measurements", len(cmd.measurement), len(cmd.regexpMeasurement))
@@ -0,0 +1,660 @@
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/empty"
+	"github.com/chengshiwen/influx-tool/internal/ratelimit"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/chengshiwen/influx-tool/pkg/transform"
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func newTestCommand() *command {
+	return &command{
+		measurement:    make(map[string]struct{}),
+		floatFormat:    'g',
+		floatPrecision: -1,
+		boolFormat:     boolTrue,
+		nonfinite:      nonfiniteDrop,
+		prefixes:       newPrefixCache(maxCachedPrefixes),
+	}
+}
+
+func TestWriteSeries(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.regexpMeasurement = []*regexp.Regexp{regexp.MustCompile("^c")}
+	var buf bytes.Buffer
+	fn := cmd.writeSeries(&buf, cmd.prefixes)
+	for i := 0; i < 2; i++ {
+		values := []tsm1.Value{tsm1.NewFloatValue(int64(i), 1.5), tsm1.NewIntegerValue(int64(i), 2)}
+		if err := fn([]byte("cpu,host=a"), []byte("usage idle"), values); err != nil {
+			t.Fatal(err)
+		}
+		if err := fn([]byte("mem,host=a"), []byte("used"), values); err != nil {
+			t.Fatal(err)
+		}
+		if err := fn([]byte("cpu,host=a"), []byte("msg"), []tsm1.Value{tsm1.NewStringValue(int64(i), `a "b"`), tsm1.NewBooleanValue(int64(i), true)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := `cpu,host=a usage\ idle=1.5 0
+cpu,host=a usage\ idle=2i 0
+cpu,host=a msg="a \"b\"" 0
+cpu,host=a msg=true 0
+cpu,host=a usage\ idle=1.5 1
+cpu,host=a usage\ idle=2i 1
+cpu,host=a msg="a \"b\"" 1
+cpu,host=a msg=true 1
+`
+	if buf.String() != exp {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestWriteValuesFormat(t *testing.T) {
+	floats := []tsm1.Value{tsm1.NewFloatValue(0, 1.5), tsm1.NewFloatValue(1, 123456789.125), tsm1.NewFloatValue(2, 1e-7)}
+	bools := []tsm1.Value{tsm1.NewBooleanValue(0, true), tsm1.NewBooleanValue(1, false)}
+	uints := []tsm1.Value{tsm1.NewUnsignedValue(0, 1), tsm1.NewUnsignedValue(1, math.MaxInt64), tsm1.NewUnsignedValue(2, math.MaxInt64+1), tsm1.NewUnsignedValue(3, math.MaxUint64)}
+	floatFormat := func(format byte, precision int) func(cmd *command) {
+		return func(cmd *command) {
+			cmd.floatFormat, cmd.floatPrecision = format, precision
+		}
+	}
+	for _, tt := range []struct {
+		name      string
+		set       func(cmd *command)
+		values    []tsm1.Value
+		exp       string
+		overflows int64
+	}{
+		{"float g", floatFormat('g', -1), floats, "cpu v=1.5 0\ncpu v=1.23456789125e+08 1\ncpu v=1e-07 2\n", 0},
+		{"float f", floatFormat('f', -1), floats, "cpu v=1.5 0\ncpu v=123456789.125 1\ncpu v=0.0000001 2\n", 0},
+		{"float f 2", floatFormat('f', 2), floats, "cpu v=1.50 0\ncpu v=123456789.12 1\ncpu v=0.00 2\n", 0},
+		{"float e", floatFormat('e', -1), floats, "cpu v=1.5e+00 0\ncpu v=1.23456789125e+08 1\ncpu v=1e-07 2\n", 0},
+		{"float e 3", floatFormat('e', 3), floats, "cpu v=1.500e+00 0\ncpu v=1.235e+08 1\ncpu v=1.000e-07 2\n", 0},
+		{"bool true", func(cmd *command) { cmd.boolFormat = boolTrue }, bools, "cpu v=true 0\ncpu v=false 1\n", 0},
+		{"bool t", func(cmd *command) { cmd.boolFormat = boolT }, bools, "cpu v=t 0\ncpu v=f 1\n", 0},
+		{"bool int", func(cmd *command) { cmd.boolFormat = boolInt }, bools, "cpu v=1i 0\ncpu v=0i 1\n", 0},
+		{"uint", func(cmd *command) {}, uints, "cpu v=1u 0\ncpu v=9223372036854775807u 1\ncpu v=9223372036854775808u 2\ncpu v=18446744073709551615u 3\n", 0},
+		// the values overflowing integer are skipped and counted
+		{"uint as int", func(cmd *command) { cmd.uintAsInt = true }, uints, "cpu v=1i 0\ncpu v=9223372036854775807i 1\n", 2},
+	} {
+		cmd := newTestCommand()
+		tt.set(cmd)
+		var buf bytes.Buffer
+		if err := cmd.writeValues(&buf, []byte("cpu v="), tt.values); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.exp || cmd.overflows.Load() != tt.overflows {
+			t.Errorf("%s: unexpected output of %d overflows:\n%s", tt.name, cmd.overflows.Load(), buf.String())
+		}
+	}
+
+	for _, args := range [][]string{{"--float-format", "x"}, {"--float-format", "gf"}, {"--float-precision", "-2"}} {
+		c := NewCommand()
+		c.SetArgs(append([]string{"-D", "data", "-W", "wal"}, args...))
+		if err := c.Execute(); err == nil || !strings.HasPrefix(err.Error(), "float") {
+			t.Errorf("%v: expected float error, got %v", args, err)
+		}
+	}
+}
+
+func TestWriteSeriesEmpty(t *testing.T) {
+	for _, tt := range []struct {
+		mode string
+		exp  string
+	}{
+		{empty.ModeKeep, "cpu,host= =1i 0\ncpu,host= v=1i 0\n"},
+		{empty.ModeDropTag, "cpu v=1i 0\n"},
+		{empty.ModeDropPoint, ""},
+		{empty.ModePlaceholder, "cpu,host=_ _=1i 0\ncpu,host=_ v=1i 0\n"},
+	} {
+		cmd := newTestCommand()
+		cmd.prefixes.empty = empty.NewHandler(tt.mode, "_")
+		var buf bytes.Buffer
+		fn := cmd.writeSeries(&buf, cmd.prefixes)
+		for _, field := range []string{"", "v"} {
+			if err := fn([]byte("cpu,host="), []byte(field), []tsm1.Value{tsm1.NewIntegerValue(0, 1)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if buf.String() != tt.exp {
+			t.Errorf("%s: unexpected output:\n%s", tt.mode, buf.String())
+		}
+		if report := cmd.prefixes.empty.Report("points"); report == "" {
+			t.Errorf("%s: unexpected report: %s", tt.mode, report)
+		}
+	}
+}
+
+// testTransformer renames tenant a to b and the field temp_f to temp_c in celsius, and drops the measurement debug and
+// the negative values.
+type testTransformer struct {
+	typed bool // whether the type of the values is kept
+}
+
+func (tt testTransformer) Series(s *transform.Series) bool {
+	if s.Tags["tenant"] == "a" {
+		s.Tags["tenant"] = "b"
+	}
+	if s.Field == "temp_f" {
+		s.Field = "temp_c"
+	}
+	return s.Measurement != "debug"
+}
+
+func (tt testTransformer) Value(s *transform.Series, v *transform.Value) bool {
+	f := v.Value.(float64)
+	if !tt.typed {
+		v.Value = int64(f)
+	} else if s.Field == "temp_c" {
+		v.Value = (f - 32) * 5 / 9
+	}
+	return f >= 0
+}
+
+func TestWriteSeriesTransform(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.context = &manifestKey{db: "db", rp: "rp"}
+	cmd.transform = transform.NewHook(testTransformer{typed: true})
+	var buf bytes.Buffer
+	fn := cmd.writeSeries(&buf, cmd.prefixes)
+	values := []tsm1.Value{tsm1.NewFloatValue(0, 212), tsm1.NewFloatValue(1, -1)}
+	for _, key := range []string{"room,tenant=a", "room,tenant=c", "debug,tenant=a"} {
+		if err := fn([]byte(key), []byte("temp_f"), values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := "room,tenant=b temp_c=100 0\nroom,tenant=c temp_c=100 0\n"; buf.String() != exp {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+	if report := cmd.transform.Report("series"); report != "transform dropped 1 series and 4 values" {
+		t.Errorf("unexpected report: %s", report)
+	}
+
+	cmd = newTestCommand()
+	cmd.transform = transform.NewHook(testTransformer{})
+	if err := cmd.writeSeries(io.Discard, cmd.prefixes)([]byte("room"), []byte("temp_f"), values); err == nil {
+		t.Error("expect error of the type changed")
+	}
+}
+
+func TestWriteValuesNonFinite(t *testing.T) {
+	values := []tsm1.Value{tsm1.NewFloatValue(0, math.NaN()), tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2, math.Inf(1)), tsm1.NewFloatValue(3, math.Inf(-1))}
+	for mode, exp := range map[string]string{
+		nonfiniteDrop:   "cpu v=1.5 1\n",
+		nonfiniteZero:   "cpu v=0 0\ncpu v=1.5 1\ncpu v=0 2\ncpu v=0 3\n",
+		nonfiniteString: "cpu v=\"NaN\" 0\ncpu v=1.5 1\ncpu v=\"+Inf\" 2\ncpu v=\"-Inf\" 3\n",
+	} {
+		cmd := newTestCommand()
+		cmd.nonfinite = mode
+		var buf bytes.Buffer
+		if err := cmd.writeValues(&buf, []byte("cpu v="), values); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != exp || cmd.nans.Load() != 3 {
+			t.Errorf("%s: unexpected output of %d non-finite values:\n%s", mode, cmd.nans.Load(), buf.String())
+		}
+	}
+}
+
+func TestWriteValuesPrecision(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.precDiv = precisions["s"]
+	var buf bytes.Buffer
+	values := []tsm1.Value{tsm1.NewIntegerValue(1500000000, 1), tsm1.NewIntegerValue(-1500000000, 2), tsm1.NewIntegerValue(-2000000000, 3)}
+	if err := cmd.writeValues(&buf, []byte("cpu value="), values); err != nil {
+		t.Fatal(err)
+	}
+	exp := "cpu value=1i 1\ncpu value=2i -2\ncpu value=3i -2\n"
+	if buf.String() != exp {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestReadValuesDedup(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	shardDir := filepath.Join(dataDir, "db", "autogen", "1")
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the second generation overlaps the first one, such as a compaction interrupted
+	writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2, 2.5)},
+	})
+	writeTSMFile(t, filepath.Join(shardDir, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(2, 3.5), tsm1.NewFloatValue(3, 4.5)},
+	})
+
+	for _, dedup := range []bool{false, true} {
+		cmd := newTestCommand()
+		cmd.startTime, cmd.endTime, cmd.dedup = math.MinInt64, math.MaxInt64, dedup
+		cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+		shards, err := cmd.src.ListShards("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = cmd.readValues(shards[0], &buf, cmd.prefixes); err != nil {
+			t.Fatal(err)
+		}
+		exp := "cpu,host=a usage=1.5 1\ncpu,host=a usage=2.5 2\ncpu,host=a usage=3.5 2\ncpu,host=a usage=4.5 3\n"
+		if dedup {
+			exp = "cpu,host=a usage=1.5 1\ncpu,host=a usage=3.5 2\ncpu,host=a usage=4.5 3\n"
+		}
+		if buf.String() != exp {
+			t.Errorf("dedup %v: unexpected output:\n%s", dedup, buf.String())
+		}
+	}
+}
+
+func TestListShards(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, sh := range []string{"db/autogen/1", "db/autogen/2", "db/rp1/3"} {
+		if err := os.MkdirAll(filepath.Join(dataDir, sh), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTSMFile(t, filepath.Join(dataDir, sh, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5)},
+		})
+	}
+	tests := []struct {
+		rps      []string
+		shardIDs []uint64
+		exp      []uint64
+		err      string
+	}{
+		{exp: []uint64{1, 2, 3}},
+		{shardIDs: []uint64{3, 1}, exp: []uint64{1, 3}},
+		{rps: []string{"autogen"}, shardIDs: []uint64{2}, exp: []uint64{2}},
+		{rps: []string{"autogen"}, shardIDs: []uint64{3}, err: "shard 3 not found"},
+	}
+	for _, tt := range tests {
+		cmd := newTestCommand()
+		cmd.database, cmd.retentionPolicy, cmd.shardIDs = "db", tt.rps, tt.shardIDs
+		cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+		shards, err := cmd.listShards()
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%v %v: unexpected error: %v", tt.rps, tt.shardIDs, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []uint64
+		for _, sh := range shards {
+			got = append(got, sh.ID)
+		}
+		if !cmp.Equal(got, tt.exp) {
+			t.Errorf("%v %v: unexpected shards: got=%v, exp=%v", tt.rps, tt.shardIDs, got, tt.exp)
+		}
+	}
+}
+
+func TestReadSourceMaxMemory(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	shardDir := filepath.Join(dataDir, "db", "autogen", "1")
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(shardDir, "000000001-000000001.tsm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the values of a series are written in blocks of 1000 values as influxd does
+	data := benchmarkSeries(2, 2500)
+	for _, key := range []string{"cpu,host=server0000,region=us-west#!~#usage_user", "cpu,host=server0001,region=us-west#!~#usage_user"} {
+		for values := data[key]; len(values) > 0; values = values[min(len(values), 1000):] {
+			if err = w.Write([]byte(key), values[:min(len(values), 1000)]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = w.WriteIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		maxMemory int64
+		calls     int
+	}{
+		{maxMemory: 0, calls: 2},
+		{maxMemory: 1, calls: 6},
+		{maxMemory: 2000 * valueMemory, calls: 4},
+	} {
+		cmd := newTestCommand()
+		cmd.startTime, cmd.endTime, cmd.maxMemory = math.MinInt64, math.MaxInt64, tt.maxMemory
+		cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+		shards, err := cmd.src.ListShards("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls, values := 0, 0
+		last := int64(math.MinInt64)
+		err = cmd.readSource(shards[0], func(seriesKey, field []byte, vs []tsm1.Value) error {
+			if values%2500 == 0 {
+				last = math.MinInt64
+			}
+			for _, v := range vs {
+				if v.UnixNano() <= last {
+					t.Fatalf("max memory %d: values out of order at %d", tt.maxMemory, v.UnixNano())
+				}
+				last = v.UnixNano()
+			}
+			calls, values = calls+1, values+len(vs)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != tt.calls || values != 5000 {
+			t.Errorf("max memory %d: unexpected calls %d of %d values", tt.maxMemory, calls, values)
+		}
+	}
+}
+
+// benchmarkSeries returns the composite keys and values of series with n float values per series.
+func benchmarkSeries(series, n int) map[string][]tsm1.Value {
+	data := make(map[string][]tsm1.Value, series)
+	for i := 0; i < series; i++ {
+		values := make([]tsm1.Value, n)
+		for j := range values {
+			values[j] = tsm1.NewFloatValue(int64(j)*1e9, float64(i*j)/3)
+		}
+		data[fmt.Sprintf("cpu,host=server%04d,region=us-west#!~#usage_user", i)] = values
+	}
+	return data
+}
+
+func BenchmarkWriteValues(b *testing.B) {
+	data := benchmarkSeries(1000, 100)
+	cmd := newTestCommand()
+	fn := cmd.writeSeries(io.Discard, cmd.prefixes)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for key, values := range data {
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(key))
+			if err := fn(seriesKey, field, values); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(len(data)*100*b.N)/b.Elapsed().Seconds(), "values/s")
+}
+
+// BenchmarkExportShard exports a shard with the same series in several tsm files.
+func BenchmarkExportShard(b *testing.B) {
+	dir := b.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	shardDir := filepath.Join(dataDir, "db", "autogen", "1")
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		b.Fatal(err)
+	}
+	for gen := 1; gen <= 4; gen++ {
+		writeTSMFile(b, filepath.Join(shardDir, fmt.Sprintf("%09d-000000001.tsm", gen)), benchmarkSeries(1000, 250))
+	}
+
+	cmd := newTestCommand()
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, sh := range shards {
+			if err := cmd.src.ReadValues(sh, math.MinInt64, math.MaxInt64, cmd.writeSeries(io.Discard, cmd.prefixes)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(4*1000*250*b.N)/b.Elapsed().Seconds(), "values/s")
+}
+
+func writeTSMFile(tb testing.TB, path string, data map[string][]tsm1.Value) {
+	f, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err = w.Write([]byte(key), data[key]); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err = w.WriteIndex(); err != nil {
+		tb.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+type failWriter struct {
+	n int
+}
+
+func (w *failWriter) Write(b []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errors.New("disk full")
+	}
+	w.n--
+	return len(b), nil
+}
+
+func TestReadSourcePPS(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	shardDir := filepath.Join(dataDir, "db", "autogen", "1")
+	for _, d := range []string{shardDir, walDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2, 2.5)},
+		"cpu,host=b#!~#usage": {tsm1.NewFloatValue(1, 3.5)},
+	})
+
+	cmd := newTestCommand()
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	cmd.ppsLimiter = ratelimit.NewLimiter(4)
+	cmd.ppsLimiter.WaitN(4) // the burst is used up, so every point read is waited for
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, points := time.Now(), 0
+	err = cmd.readSource(shards[0], func(seriesKey, field []byte, values []tsm1.Value) error {
+		points += len(values)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points != 3 {
+		t.Errorf("unexpected points: %d", points)
+	}
+	if elapsed := time.Since(start); elapsed < 600*time.Millisecond {
+		t.Errorf("unexpected elapsed: %s", elapsed)
+	}
+}
+
+func TestWriteDDL(t *testing.T) {
+	shards := func(dbrps ...string) []*source.Shard {
+		var list []*source.Shard
+		for i, dbrp := range dbrps {
+			db, rp, _ := strings.Cut(dbrp, ".")
+			list = append(list, &source.Shard{ID: uint64(i + 1), Database: db, RetentionPolicy: rp})
+		}
+		return list
+	}
+	tests := []struct {
+		name     string
+		shards   []*source.Shard
+		policies map[string]string
+		target   string
+		meta     bool
+		exp      string
+	}{
+		{
+			name:   "unknown default",
+			shards: shards("db.autogen", "db.rp1", "my db.rp2"),
+			exp:    "CREATE DATABASE db WITH NAME autogen\nCREATE RETENTION POLICY rp1 ON db DURATION 0s REPLICATION 1\nCREATE DATABASE \"my db\" WITH NAME rp2\n",
+		},
+		{
+			name:     "autogen default",
+			shards:   shards("db.autogen", "db.rp1"),
+			policies: map[string]string{"db": "autogen"},
+			exp:      "CREATE DATABASE db WITH NAME autogen\nCREATE RETENTION POLICY rp1 ON db DURATION 0s REPLICATION 1\n",
+		},
+		{
+			name:     "default of several",
+			shards:   shards("db.autogen", "db.rp1", "db.rp 2"),
+			policies: map[string]string{"db": "rp 2"},
+			exp:      "CREATE DATABASE db\nCREATE RETENTION POLICY rp1 ON db DURATION 0s REPLICATION 1\nCREATE RETENTION POLICY \"rp 2\" ON db DURATION 0s REPLICATION 1\nALTER RETENTION POLICY \"rp 2\" ON db DEFAULT\n",
+		},
+		{
+			name:     "default exported alone",
+			shards:   shards("db.rp1"),
+			policies: map[string]string{"db": "rp1"},
+			exp:      "CREATE DATABASE db WITH NAME rp1\n",
+		},
+		{
+			name:     "default not exported",
+			shards:   shards("db.rp1"),
+			policies: map[string]string{"db": "rp2"},
+			exp:      "CREATE DATABASE db\nCREATE RETENTION POLICY rp1 ON db DURATION 0s REPLICATION 1\n",
+		},
+		{
+			name:     "target database",
+			shards:   shards("db.rp1", "db.rp2"),
+			policies: map[string]string{"db": "rp2", "new": "rp1"},
+			target:   "new",
+			exp:      "CREATE DATABASE new\nCREATE RETENTION POLICY rp1 ON new DURATION 0s REPLICATION 1\nCREATE RETENTION POLICY rp2 ON new DURATION 0s REPLICATION 1\nALTER RETENTION POLICY rp2 ON new DEFAULT\n",
+		},
+		{
+			name:     "metadir durations",
+			shards:   shards("db.autogen", "db.rp1", "db.rp2", "other.rp1"),
+			policies: map[string]string{"db": "rp1", "other": "rp1"},
+			meta:     true,
+			exp: "CREATE DATABASE db\nALTER RETENTION POLICY autogen ON db DURATION 0s REPLICATION 1 SHARD DURATION 1w\n" +
+				"CREATE RETENTION POLICY rp1 ON db DURATION 2w REPLICATION 1 SHARD DURATION 1d\nCREATE RETENTION POLICY rp2 ON db DURATION 0s REPLICATION 1\n" +
+				"ALTER RETENTION POLICY rp1 ON db DEFAULT\nCREATE DATABASE other WITH DURATION 36h REPLICATION 2 SHARD DURATION 1h NAME rp1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newTestCommand()
+			cmd.shards, cmd.defaultPolicies, cmd.targetDatabase = tt.shards, tt.policies, tt.target
+			if tt.meta {
+				cmd.metaData = &meta.Data{}
+				for _, rpi := range []struct {
+					db  string
+					rpi *meta.RetentionPolicyInfo
+				}{
+					{"db", &meta.RetentionPolicyInfo{Name: "autogen", ReplicaN: 1, ShardGroupDuration: 7 * 24 * time.Hour}},
+					{"db", &meta.RetentionPolicyInfo{Name: "rp1", ReplicaN: 1, Duration: 14 * 24 * time.Hour, ShardGroupDuration: 24 * time.Hour}},
+					{"other", &meta.RetentionPolicyInfo{Name: "rp1", ReplicaN: 2, Duration: 36 * time.Hour, ShardGroupDuration: time.Hour}},
+				} {
+					if cmd.metaData.Database(rpi.db) == nil {
+						if err := cmd.metaData.CreateDatabase(rpi.db); err != nil {
+							t.Fatal(err)
+						}
+					}
+					if err := cmd.metaData.CreateRetentionPolicy(rpi.db, rpi.rpi, false); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			var buf bytes.Buffer
+			if err := cmd.writeDDL(io.Discard, &buf); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.exp {
+				t.Errorf("unexpected ddl:\ngot=%s\nexp=%s", buf.String(), tt.exp)
+			}
+		})
+	}
+}
+
+func TestPipeWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var exp []byte
+	pw := newPipeWriter(&buf, 16, 2)
+	for i := 0; i < 100; i++ {
+		line := []byte(fmt.Sprintf("cpu value=%d %d\n", i, i))
+		exp = append(exp, line...)
+		if _, err := pw.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), exp) {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+
+	pw = newPipeWriter(&failWriter{n: 1}, 16, 2)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = pw.Write([]byte("cpu value=1 1\n"))
+	}
+	if cerr := pw.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil || err.Error() != "disk full" {
+		t.Fatalf("expected error of the underlying writer, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package exporter
+
+import (
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+const (
+	compressionGzip   = "gzip"
+	compressionZstd   = "zstd"
+	compressionSnappy = "snappy"
+	compressionNone   = "none"
+)
+
+// compressionExts are the extensions of the files compressed, also recognized by import.
+var compressionExts = map[string]string{
+	compressionGzip:   ".gz",
+	compressionZstd:   ".zst",
+	compressionSnappy: ".sz",
+}
+
+// validateCompression validates the compression and its level, the compress flag is the same as gzip.
+func (cmd *command) validateCompression() error {
+	if cmd.compress {
+		if cmd.cobraCmd != nil && cmd.cobraCmd.Flags().Changed("compression") && cmd.compression != compressionGzip {
+			return errors.New("compress is the same as gzip compression, which conflicts with the compression given")
+		}
+		cmd.compression = compressionGzip
+	}
+	switch cmd.compression {
+	case compressionGzip:
+		if cmd.compressLevel < 0 || cmd.compressLevel > 9 {
+			return errors.New("compression level is invalid, require 1-9 for gzip")
+		}
+	case compressionZstd:
+		if cmd.compressLevel < 0 || cmd.compressLevel > 22 {
+			return errors.New("compression level is invalid, require 1-22 for zstd")
+		}
+	case compressionSnappy:
+		if cmd.compressLevel < 0 || cmd.compressLevel > 3 {
+			return errors.New("compression level is invalid, require 1-3 for snappy")
+		}
+	case compressionNone:
+		if cmd.compressLevel != 0 {
+			return errors.New("compression level is invalid without compression")
+		}
+	default:
+		return errors.New("compression is invalid, require gzip, zstd, snappy or none")
+	}
+	cmd.compress = cmd.compression != compressionNone
+	return nil
+}
+
+// newCompressWriter returns the writer compressing blocks of the output to w in parallel, which must be closed
+// to flush the output. The output is a regular stream of the compression, and the streams appended one after
+// another are read as a whole by the readers of gzip, zstd and snappy framing format.
+func (cmd *command) newCompressWriter(w io.Writer) (io.WriteCloser, error) {
+	switch cmd.compression {
+	case compressionZstd:
+		level := zstd.SpeedDefault
+		if cmd.compressLevel > 0 {
+			level = zstd.EncoderLevelFromZstd(cmd.compressLevel)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(cmd.compressWorkers))
+	case compressionSnappy:
+		opts := []s2.WriterOption{s2.WriterSnappyCompat(), s2.WriterConcurrency(cmd.compressWorkers)}
+		switch cmd.compressLevel {
+		case 2:
+			opts = append(opts, s2.WriterBetterCompression())
+		case 3:
+			opts = append(opts, s2.WriterBestCompression())
+		}
+		return s2.NewWriter(w, opts...), nil
+	default:
+		level := pgzip.DefaultCompression
+		if cmd.compressLevel > 0 {
+			level = cmd.compressLevel
+		}
+		gzw, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		if err = gzw.SetConcurrency(compressBlockSize, cmd.compressWorkers); err != nil {
+			return nil, err
+		}
+		return gzw, nil
+	}
+}
@@ -0,0 +1,51 @@
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressWriter(t *testing.T) {
+	readers := map[string]func(r io.Reader) (io.Reader, error){
+		compressionGzip:   func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		compressionZstd:   func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		compressionSnappy: func(r io.Reader) (io.Reader, error) { return s2.NewReader(r), nil },
+	}
+	lines := []string{"cpu,host=a usage=1.5 1\n", "cpu,host=b usage=2.5 2\n"}
+	for compression, newReader := range readers {
+		for _, level := range []int{0, 1, 3} {
+			cmd := newTestCommand()
+			cmd.compression, cmd.compressLevel, cmd.compressWorkers = compression, level, 2
+			// the streams appended, as the files split by measurement, are read as a whole
+			var buf bytes.Buffer
+			for _, line := range lines {
+				cw, err := cmd.newCompressWriter(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = cw.Write([]byte(line)); err != nil {
+					t.Fatal(err)
+				}
+				if err = cw.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r, err := newReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s level %d: %v", compression, level, err)
+			}
+			if exp := lines[0] + lines[1]; string(b) != exp {
+				t.Errorf("%s level %d: got %q, want %q", compression, level, b, exp)
+			}
+		}
+	}
+}
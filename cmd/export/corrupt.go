@@ -0,0 +1,50 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+)
+
+// errorReport lists the tsm and wal files and keys unable to be read by an export, which are missing from its output.
+type errorReport struct {
+	Strict      bool                `json:"strict"`
+	Files       int                 `json:"files"`
+	Keys        int                 `json:"keys"`
+	Corruptions []source.Corruption `json:"corruptions"`
+}
+
+// corruptReport returns the numbers of the files and keys skipped as unable to be read, or empty if none.
+func (cmd *command) corruptReport() string {
+	if cmd.corrupt == nil {
+		return ""
+	}
+	files, keys := cmd.corrupt.Count()
+	if files == 0 && keys == 0 {
+		return ""
+	}
+	report := fmt.Sprintf("skipped %d files and %d keys unable to be read", files, keys)
+	if cmd.errorReport != "" {
+		report += ", listed in " + cmd.errorReport
+	}
+	return report
+}
+
+// writeErrorReport writes the error report as json to the error report file if given, even if none is skipped.
+func (cmd *command) writeErrorReport() error {
+	if cmd.errorReport == "" || cmd.corrupt == nil {
+		return nil
+	}
+	r := errorReport{Strict: cmd.strict, Corruptions: cmd.corrupt.List()}
+	r.Files, r.Keys = cmd.corrupt.Count()
+	if r.Corruptions == nil {
+		r.Corruptions = []source.Corruption{}
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cmd.errorReport, append(b, '\n'), 0644)
+}
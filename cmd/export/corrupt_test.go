@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestWriteErrorReport(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	shardDir := filepath.Join(dataDir, "db", "autogen", "1")
+	for _, d := range []string{shardDir, walDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5)},
+	})
+	if err := os.WriteFile(filepath.Join(shardDir, "000000002-000000001.tsm"), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := newTestCommand()
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	cmd.dataDir, cmd.walDir, cmd.tsmReadMode = dataDir, walDir, tsmread.ModeMmap
+	cmd.errorReport = filepath.Join(dir, "errors.json")
+	if err := cmd.newSource(); err != nil {
+		t.Fatal(err)
+	}
+	if report := cmd.corruptReport(); report != "" {
+		t.Errorf("unexpected report before reading: %s", report)
+	}
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := 0
+	err = cmd.readSource(shards[0], func(seriesKey, field []byte, values []tsm1.Value) error {
+		points += len(values)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points != 1 {
+		t.Errorf("unexpected points: %d", points)
+	}
+	if report, want := cmd.corruptReport(), "skipped 1 files and 0 keys unable to be read, listed in "+cmd.errorReport; report != want {
+		t.Errorf("unexpected report: %s", report)
+	}
+
+	if err = cmd.writeErrorReport(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(cmd.errorReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r errorReport
+	if err = json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Strict || r.Files != 1 || r.Keys != 0 || len(r.Corruptions) != 1 || filepath.Base(r.Corruptions[0].File) != "000000002-000000001.tsm" {
+		t.Errorf("unexpected error report: %s", b)
+	}
+
+	cmd.strict = true
+	if err = cmd.newSource(); err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.readSource(shards[0], func(seriesKey, field []byte, values []tsm1.Value) error { return nil })
+	if err == nil {
+		t.Error("expected error of strict")
+	}
+}
@@ -0,0 +1,161 @@
+package exporter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+const (
+	counterCumulative = "cumulative"
+	counterRate       = "rate"
+)
+
+// counterRule is the mode of the counter fields of a measurement, empty measurement means any.
+type counterRule struct {
+	measurement string
+	field       string
+	mode        string
+}
+
+// counterMap normalizes the values of the counter fields, whose resets are detected by the values decreasing, into
+// the cumulative values corrected across the resets, or the rates of increase per unit. The values of a series
+// must be passed in time order across the shards, the values not after the last one of the series are dropped.
+type counterMap struct {
+	rules   []counterRule
+	unit    time.Duration
+	states  map[string]*counterState
+	resets  int64
+	dropped int64
+}
+
+// counterState is the state of a counter series.
+type counterState struct {
+	m      *counterMap
+	mode   string
+	seen   bool
+	time   int64       // time of the last value
+	prev   interface{} // last value read
+	offset interface{} // sum of the values before the resets, of the type of the values
+}
+
+// parseCounterMap parses the rules "measurement/field=mode" and "field=mode" of any measurement, where the
+// measurement ends at the first slash.
+func parseCounterMap(values []string, unit time.Duration) (*counterMap, error) {
+	m := &counterMap{unit: unit, states: make(map[string]*counterState)}
+	for _, value := range values {
+		i := strings.LastIndexByte(value, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("counter is invalid: %s, require measurement/field=mode or field=mode", value)
+		}
+		rule := counterRule{field: value[:i], mode: value[i+1:]}
+		name, field, ok := strings.Cut(rule.field, "/")
+		if ok {
+			rule.measurement, rule.field = name, field
+		}
+		if rule.field == "" || (ok && name == "") {
+			return nil, fmt.Errorf("counter is invalid: %s, require measurement/field=mode or field=mode", value)
+		}
+		if rule.mode != counterCumulative && rule.mode != counterRate {
+			return nil, fmt.Errorf("counter mode is invalid: %s, require cumulative or rate", value)
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m, nil
+}
+
+// state returns the state of the field of the series in the database and retention policy, or nil if not a counter.
+// The rule of the measurement is matched before the ones of any measurement.
+func (m *counterMap) state(db, rp string, seriesKey, field []byte) *counterState {
+	name := models.ParseName(seriesKey)
+	mode := ""
+	for _, rule := range m.rules {
+		if rule.field != string(field) || (rule.measurement != "" && rule.measurement != string(name)) {
+			continue
+		}
+		if mode = rule.mode; rule.measurement != "" {
+			break
+		}
+	}
+	if mode == "" {
+		return nil
+	}
+	key := db + "\x00" + rp + "\x00" + string(seriesKey) + "\x00" + string(field)
+	s := m.states[key]
+	if s == nil {
+		s = &counterState{m: m, mode: mode}
+		m.states[key] = s
+	}
+	return s
+}
+
+// report returns the summary of the resets corrected and the values dropped, or empty if none.
+func (m *counterMap) report() string {
+	if m == nil || (m.resets == 0 && m.dropped == 0) {
+		return ""
+	}
+	return fmt.Sprintf("normalized %d counter resets, dropped %d counter values out of order", m.resets, m.dropped)
+}
+
+// apply appends the values normalized to dst, the values not numbers are appended as they are. The first value of
+// a series has no rate and is not appended by rate mode.
+func (s *counterState) apply(dst, values []tsm1.Value) []tsm1.Value {
+	for _, v := range values {
+		t := v.UnixNano()
+		switch v.Value().(type) {
+		case float64, int64, uint64:
+		default:
+			dst = append(dst, v)
+			continue
+		}
+		if s.seen && t <= s.time {
+			s.m.dropped++
+			continue
+		}
+		var nv interface{}
+		switch x := v.Value().(type) {
+		case float64:
+			nv = normalize(s, x, t)
+		case int64:
+			nv = normalize(s, x, t)
+		case uint64:
+			nv = normalize(s, x, t)
+		}
+		s.seen, s.time = true, t
+		if nv != nil {
+			dst = append(dst, tsm1.NewValue(t, nv))
+		}
+	}
+	return dst
+}
+
+// normalize returns the value at t normalized by the mode of the state, or nil if unknown, and keeps v as the last
+// value.
+func normalize[N float64 | int64 | uint64](s *counterState, v N, t int64) interface{} {
+	prev, ok := s.prev.(N)
+	s.prev = v
+	reset := s.seen && ok && v < prev
+	if reset {
+		s.m.resets++
+	}
+	if s.mode == counterCumulative {
+		offset, _ := s.offset.(N)
+		if reset {
+			// the counter restarted from zero
+			offset += prev
+			s.offset = offset
+		}
+		return v + offset
+	}
+	if !s.seen || !ok {
+		return nil
+	}
+	delta := v - prev
+	if reset {
+		delta = v
+	}
+	return float64(delta) / (float64(t-s.time) / float64(s.m.unit))
+}
@@ -0,0 +1,94 @@
+package exporter
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestParseCounterMap(t *testing.T) {
+	m, err := parseCounterMap([]string{"net/bytes_recv=rate", "bytes_recv=cumulative", "a/b/c=cumulative"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []counterRule{{"net", "bytes_recv", counterRate}, {"", "bytes_recv", counterCumulative}, {"a", "b/c", counterCumulative}}
+	if !reflect.DeepEqual(m.rules, exp) {
+		t.Fatalf("unexpected rules: %+v, exp %+v", m.rules, exp)
+	}
+	tests := []struct {
+		key, field string
+		mode       string
+	}{
+		{"net,host=a", "bytes_recv", counterRate},
+		{"disk,host=a", "bytes_recv", counterCumulative},
+		{"net,host=a", "bytes_sent", ""},
+	}
+	for _, tt := range tests {
+		s := m.state("db", "rp", []byte(tt.key), []byte(tt.field))
+		if (s == nil) != (tt.mode == "") || (s != nil && s.mode != tt.mode) {
+			t.Errorf("unexpected state of %s %s: %+v, exp mode %q", tt.key, tt.field, s, tt.mode)
+		}
+	}
+	if m.state("db", "rp", []byte("net,host=a"), []byte("bytes_recv")) != m.state("db", "rp", []byte("net,host=a"), []byte("bytes_recv")) {
+		t.Error("expected the same state of a series")
+	}
+	for _, values := range [][]string{{"bytes"}, {"bytes=sum"}, {"=rate"}, {"/bytes=rate"}, {"net/=rate"}} {
+		if _, err = parseCounterMap(values, time.Second); err == nil {
+			t.Errorf("expected error of %v", values)
+		}
+	}
+}
+
+func TestCounterApply(t *testing.T) {
+	m, _ := parseCounterMap([]string{"c=cumulative", "r=rate"}, time.Second)
+	s := m.state("db", "rp", []byte("m"), []byte("c"))
+	sec := int64(time.Second)
+	got := s.apply(nil, []tsm1.Value{tsm1.NewValue(1*sec, int64(10)), tsm1.NewValue(2*sec, int64(15)), tsm1.NewValue(3*sec, int64(3))})
+	// the values are passed in time order across the calls
+	got = s.apply(got, []tsm1.Value{tsm1.NewValue(3*sec, int64(100)), tsm1.NewValue(4*sec, int64(5)), tsm1.NewValue(5*sec, int64(2))})
+	exp := []tsm1.Value{
+		tsm1.NewValue(1*sec, int64(10)), tsm1.NewValue(2*sec, int64(15)), tsm1.NewValue(3*sec, int64(18)),
+		tsm1.NewValue(4*sec, int64(20)), tsm1.NewValue(5*sec, int64(22)),
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected cumulative values: %v, exp %v", got, exp)
+	}
+
+	s = m.state("db", "rp", []byte("m"), []byte("r"))
+	got = s.apply(nil, []tsm1.Value{tsm1.NewValue(0, 10.0), tsm1.NewValue(2*sec, 30.0), tsm1.NewValue(4*sec, 4.0), tsm1.NewValue(5*sec, "s")})
+	exp = []tsm1.Value{tsm1.NewValue(2*sec, 10.0), tsm1.NewValue(4*sec, 2.0), tsm1.NewValue(5*sec, "s")}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected rate values: %v, exp %v", got, exp)
+	}
+	if m.resets != 3 || m.dropped != 1 {
+		t.Fatalf("unexpected resets %d and dropped %d, exp 3 and 1", m.resets, m.dropped)
+	}
+	if r := m.report(); r != "normalized 3 counter resets, dropped 1 counter values out of order" {
+		t.Fatalf("unexpected report: %s", r)
+	}
+}
+
+func TestWriteSeriesCounter(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.counters, _ = parseCounterMap([]string{"cpu/v=cumulative"}, time.Second)
+	var buf bytes.Buffer
+	fn := cmd.writeSeries(&buf, cmd.prefixes)
+	for _, values := range [][]tsm1.Value{
+		{tsm1.NewValue(1, int64(5)), tsm1.NewValue(2, int64(1))},
+		{tsm1.NewValue(3, int64(2))},
+	} {
+		if err := fn([]byte("cpu"), []byte("v"), values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fn([]byte("mem"), []byte("v"), []tsm1.Value{tsm1.NewValue(1, int64(5)), tsm1.NewValue(2, int64(1))}); err != nil {
+		t.Fatal(err)
+	}
+	exp := "cpu v=5i 1\ncpu v=6i 2\ncpu v=7i 3\nmem v=5i 1\nmem v=1i 2\n"
+	if buf.String() != exp {
+		t.Fatalf("unexpected lines:\n%s\nexp:\n%s", buf.String(), exp)
+	}
+}
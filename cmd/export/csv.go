@@ -0,0 +1,192 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// csvColumns are the columns of the annotated csv before the tags, the first one is the annotation column.
+var csvColumns = []string{"", "result", "table", "_time", "_value", "_field", "_measurement"}
+
+// csvWriter writes the values of series in the annotated csv of influxdb 2.x query results, a table per field of a
+// series, which can be written by influx write. The annotations and the header are written again before a table
+// whose tag keys or value type differ from the last one.
+type csvWriter struct {
+	cmd    *command
+	w      io.Writer
+	buf    bytes.Buffer
+	cw     *csv.Writer
+	record []string
+
+	series   []byte   // escaped series key of the last table
+	name     string   // measurement of the series
+	tags     []string // tag values of the series ordered by the tag keys
+	keys     []string // tag keys of the header
+	datatype string   // datatype of _value of the header
+	table    int      // number of the last table
+}
+
+func (cmd *command) newCSVWriter(w io.Writer) *csvWriter {
+	cw := &csvWriter{cmd: cmd, w: w, table: -1}
+	cw.cw = csv.NewWriter(&cw.buf)
+	return cw
+}
+
+// add writes the values of the field of the line prefix "<series_key> <field>=" as a table.
+func (cw *csvWriter) add(prefix []byte, values []tsm1.Value) error {
+	if len(values) == 0 {
+		return nil
+	}
+	n := seriesLen(prefix)
+	series, field := prefix[:n], escape.Unescape(prefix[n+1:len(prefix)-1])
+	if !bytes.Equal(series, cw.series) {
+		cw.series = append(cw.series[:0], series...)
+		name, tags := models.ParseKeyBytes(series)
+		cw.name, cw.tags = string(name), cw.tags[:0]
+		keys := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			keys = append(keys, string(tag.Key))
+			cw.tags = append(cw.tags, string(tag.Value))
+		}
+		if !slices.Equal(keys, cw.keys) {
+			cw.keys, cw.datatype = keys, ""
+		}
+	}
+	datatype := cw.valueDatatype(values[0])
+	if datatype != cw.datatype {
+		cw.datatype = datatype
+		cw.writeHeader()
+	}
+	cw.table++
+
+	table := strconv.Itoa(cw.table)
+	points := 0
+	for _, value := range values {
+		v, ok := cw.formatValue(value)
+		if !ok {
+			continue
+		}
+		cw.record = append(cw.record[:0], "", "", table, time.Unix(0, value.UnixNano()).UTC().Format(time.RFC3339Nano), v, string(field), cw.name)
+		cw.record = append(cw.record, cw.tags...)
+		cw.cw.Write(cw.record)
+		points++
+	}
+	return cw.flush(points)
+}
+
+// writeHeader writes the annotations and the header of the tables following, separated from the last tables
+// by an empty line.
+func (cw *csvWriter) writeHeader() {
+	if cw.table >= 0 {
+		cw.buf.WriteString("\n")
+	}
+	cw.table = -1
+	group := []string{"#group", "false", "false", "false", "false", "true", "true"}
+	datatype := []string{"#datatype", "string", "long", "dateTime:RFC3339", cw.datatype, "string", "string"}
+	defaults := []string{"#default", "_result", "", "", "", "", ""}
+	header := append([]string(nil), csvColumns...)
+	for _, key := range cw.keys {
+		group = append(group, "true")
+		datatype = append(datatype, "string")
+		defaults = append(defaults, "")
+		header = append(header, key)
+	}
+	for _, record := range [][]string{group, datatype, defaults, header} {
+		cw.cw.Write(record)
+	}
+}
+
+// flush writes the rows buffered to w at once.
+func (cw *csvWriter) flush(points int) error {
+	cw.cw.Flush()
+	if err := cw.cw.Error(); err != nil {
+		return err
+	}
+	cw.cmd.addWritten(points, cw.buf.Len())
+	_, err := cw.w.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+// valueDatatype returns the datatype annotation of the value, unsigned values are long if written as integers.
+func (cw *csvWriter) valueDatatype(value tsm1.Value) string {
+	switch value.(type) {
+	case tsm1.FloatValue:
+		return "double"
+	case tsm1.IntegerValue:
+		return "long"
+	case tsm1.UnsignedValue:
+		if cw.cmd.uintAsInt {
+			return "long"
+		}
+		return "unsignedLong"
+	case tsm1.BooleanValue:
+		return "boolean"
+	default:
+		return "string"
+	}
+}
+
+// formatValue returns the value formatted for the csv, or false if it is skipped, such as the non-finite float
+// values dropped and the unsigned values overflowing integer.
+func (cw *csvWriter) formatValue(value tsm1.Value) (string, bool) {
+	switch v := value.Value().(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			cw.cmd.nans.Add(1)
+			if cw.cmd.nonfinite != nonfiniteZero {
+				return "", false
+			}
+			v = 0
+		}
+		return strconv.FormatFloat(v, cw.cmd.floatFormat, cw.cmd.floatPrecision, 64), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint64:
+		if cw.cmd.uintAsInt && v > math.MaxInt64 {
+			cw.cmd.overflows.Add(1)
+			return "", false
+		}
+		return strconv.FormatUint(v, 10), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case string:
+		return v, true
+	default:
+		return fmt.Sprintf("%v", v), true
+	}
+}
+
+// readCSV reads the values of the shard from source and writes the tables of the annotated csv to w with the line
+// prefixes cached in prefixes.
+func (cmd *command) readCSV(sh *source.Shard, w io.Writer, prefixes *prefixCache) error {
+	cw := cmd.newCSVWriter(w)
+	return cmd.readSource(sh, cmd.handleSeries(prefixes, cw.add))
+}
+
+// writeCSV writes the annotated csv of the shards to w.
+func (cmd *command) writeCSV(w io.Writer) error {
+	msgOut := cmd.msgOut()
+	for _, key := range cmd.manifest() {
+		cmd.context = key
+		cmd.startKey(key)
+		fmt.Fprintf(msgOut, "writing out %s data for %s%s...", cmd.kind, filepath.Join(key.db, key.rp), cmd.withMeasurement())
+		if err := cmd.readShards(key.shards, w); err != nil {
+			return err
+		}
+		fmt.Fprintf(msgOut, "complete%s.\n", cmd.keyDone())
+	}
+	return nil
+}
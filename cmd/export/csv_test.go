@@ -0,0 +1,54 @@
+package exporter
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestCSVWriter(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.format = formatCSV
+	var buf bytes.Buffer
+	cw := cmd.newCSVWriter(&buf)
+	fn := cmd.handleSeries(cmd.prefixes, cw.add)
+	series := []struct {
+		key, field string
+		values     []tsm1.Value
+	}{
+		{`cpu,host=a\ b`, "idle", []tsm1.Value{tsm1.NewFloatValue(1, 0.5), tsm1.NewFloatValue(2, math.NaN())}},
+		{`cpu,host=c`, "idle", []tsm1.Value{tsm1.NewFloatValue(1, 1)}},
+		{`cpu,host=c`, "msg", []tsm1.Value{tsm1.NewStringValue(1500000000, `x,"y"`)}},
+		{`m\,1`, "f=1", []tsm1.Value{tsm1.NewIntegerValue(3, 7), tsm1.NewIntegerValue(4, 8)}},
+	}
+	for _, s := range series {
+		if err := fn([]byte(s.key), []byte(s.field), s.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := `#group,false,false,false,false,true,true,true
+#datatype,string,long,dateTime:RFC3339,double,string,string,string
+#default,_result,,,,,,
+,result,table,_time,_value,_field,_measurement,host
+,,0,1970-01-01T00:00:00.000000001Z,0.5,idle,cpu,a b
+,,1,1970-01-01T00:00:00.000000001Z,1,idle,cpu,c
+
+#group,false,false,false,false,true,true,true
+#datatype,string,long,dateTime:RFC3339,string,string,string,string
+#default,_result,,,,,,
+,result,table,_time,_value,_field,_measurement,host
+,,0,1970-01-01T00:00:01.5Z,"x,""y""",msg,cpu,c
+
+#group,false,false,false,false,true,true
+#datatype,string,long,dateTime:RFC3339,long,string,string
+#default,_result,,,,,
+,result,table,_time,_value,_field,_measurement
+,,0,1970-01-01T00:00:00.000000003Z,7,f=1,"m,1"
+,,0,1970-01-01T00:00:00.000000004Z,8,f=1,"m,1"
+`
+	if buf.String() != exp || cmd.nans.Load() != 1 {
+		t.Errorf("unexpected output of %d non-finite values:\n%s", cmd.nans.Load(), buf.String())
+	}
+}
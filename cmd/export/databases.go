@@ -0,0 +1,43 @@
+package exporter
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// databasePath returns the path of the file of the database and retention policy under the out directory, named
+// <db>/<rp>.lp, <db>/<rp>.csv for annotated csv, or <db>/<rp>.om for openmetrics, with .gz, .zst or .sz appended if compressed.
+func (cmd *command) databasePath(out, db, rp string) string {
+	ext := ".lp"
+	switch cmd.format {
+	case formatCSV:
+		ext = ".csv"
+	case formatOM:
+		ext = ".om"
+	}
+	path := filepath.Join(out, url.PathEscape(db), url.PathEscape(rp)+ext)
+	if cmd.compress {
+		path += compressionExts[cmd.compression]
+	}
+	return path
+}
+
+// writeDatabases writes each database and retention policy into its own file under the out directory with the DDL
+// of its own, when exporting all the databases, so that they can be restored selectively.
+func (cmd *command) writeDatabases() error {
+	shards, out := cmd.shards, cmd.out
+	defer func() {
+		cmd.shards, cmd.out = shards, out
+	}()
+	for _, key := range cmd.manifest() {
+		cmd.shards, cmd.out = key.shards, cmd.databasePath(out, key.db, key.rp)
+		if err := os.MkdirAll(filepath.Dir(cmd.out), 0755); err != nil {
+			return err
+		}
+		if err := cmd.writeFile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package exporter
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestWriteDatabases(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	for i, path := range []string{"db/rp1/1", "db/rp2/2", "my db/autogen/3"} {
+		shardDir := filepath.Join(dataDir, path)
+		if err := os.MkdirAll(shardDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#usage": {tsm1.NewFloatValue(int64(i+1), 1.5)},
+		})
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := newTestCommand()
+	cmd.format, cmd.precision, cmd.out = formatLine, precisionNs, filepath.Join(dir, "out")
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	if err = cmd.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"db/rp1.lp":          "# DDL\nCREATE DATABASE db WITH NAME rp1\n# DML\n# CONTEXT-DATABASE:db\n# CONTEXT-RETENTION-POLICY:rp1\n# writing  data\ncpu,host=a usage=1.5 1\n",
+		"db/rp2.lp":          "# DDL\nCREATE DATABASE db WITH NAME rp2\n# DML\n# CONTEXT-DATABASE:db\n# CONTEXT-RETENTION-POLICY:rp2\n# writing  data\ncpu,host=a usage=1.5 2\n",
+		"my%20db/autogen.lp": "# DDL\nCREATE DATABASE \"my db\" WITH NAME autogen\n# DML\n# CONTEXT-DATABASE:my db\n# CONTEXT-RETENTION-POLICY:autogen\n# writing  data\ncpu,host=a usage=1.5 3\n",
+	}
+	for name, exp := range files {
+		b, err := os.ReadFile(filepath.Join(cmd.out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the first line is the time range of the export in local time
+		if _, got, _ := strings.Cut(string(b), "\n"); got != exp {
+			t.Errorf("unexpected %s:\n%s", name, got)
+		}
+	}
+}
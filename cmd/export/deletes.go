@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+// writeDeletes writes the deletes in the wal of the shards as statements after the DDL, following the context
+// comment of the database they are executed on. The time ranges of the deletes are limited to the time range
+// of the export, and the deletes of the series not exported are skipped.
+func (cmd *command) writeDeletes(mw io.Writer, w io.Writer) error {
+	ds, ok := cmd.src.(source.DeleteSource)
+	if !ok {
+		return nil
+	}
+	// a delete applies to all the retention policies of the database, so it is written once per database
+	written := make(map[string]struct{})
+	lastDB := ""
+	for _, key := range cmd.manifest() {
+		db := cmd.contextDatabase(key.db)
+		for _, sh := range key.shards {
+			err := ds.ReadDeletes(sh, func(seriesKeys [][]byte, min, max int64) error {
+				if min < cmd.startTime {
+					min = cmd.startTime
+				}
+				if max > cmd.endTime {
+					max = cmd.endTime
+				}
+				if min > max {
+					return nil
+				}
+				for _, seriesKey := range seriesKeys {
+					if !cmd.matchSeries(seriesKey) {
+						continue
+					}
+					stmt := deleteStatement(seriesKey, min, max)
+					if _, ok := written[db+"\n"+stmt]; ok {
+						continue
+					}
+					written[db+"\n"+stmt] = struct{}{}
+					if db != lastDB {
+						fmt.Fprintf(mw, "# CONTEXT-DATABASE:%s\n", db)
+						lastDB = db
+					}
+					fmt.Fprintln(w, stmt)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteStatement returns the statement deleting the series within the time range [min, max], DROP SERIES if the
+// series is deleted entirely, or DELETE otherwise. The series is matched by its measurement and tags.
+func deleteStatement(seriesKey []byte, min, max int64) string {
+	name, tags := models.ParseKeyBytes(seriesKey)
+	var conds []string
+	for _, tag := range tags {
+		conds = append(conds, fmt.Sprintf("%s = %s", influxql.QuoteIdent(string(tag.Key)), influxql.QuoteString(string(tag.Value))))
+	}
+	stmt := "DROP SERIES FROM "
+	if min != math.MinInt64 || max != math.MaxInt64 {
+		stmt = "DELETE FROM "
+		if min != math.MinInt64 {
+			conds = append(conds, fmt.Sprintf("time >= %d", min))
+		}
+		if max != math.MaxInt64 {
+			conds = append(conds, fmt.Sprintf("time <= %d", max))
+		}
+	}
+	stmt += influxql.QuoteIdent(string(name))
+	if len(conds) > 0 {
+		stmt += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return stmt
+}
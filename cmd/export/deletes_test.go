@@ -0,0 +1,87 @@
+package exporter
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestWriteDeletes(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	for _, rp := range []string{"rp1", "rp2"} {
+		if err := os.MkdirAll(filepath.Join(dataDir, "db", rp, "1"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		walFile := filepath.Join(walDir, "db", rp, "1", "_00001.wal")
+		if err := os.MkdirAll(filepath.Dir(walFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(walFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := tsm1.NewWALSegmentWriter(f)
+		for _, entry := range []tsm1.WALEntry{
+			&tsm1.DeleteRangeWALEntry{Keys: [][]byte{[]byte(`cpu,host=a\ b#!~#usage`), []byte(`cpu,host=a\ b#!~#idle`)}, Min: 1, Max: 20},
+			&tsm1.DeleteRangeWALEntry{Keys: [][]byte{[]byte("mem#!~#used")}, Min: math.MinInt64, Max: math.MaxInt64},
+			&tsm1.DeleteWALEntry{Keys: [][]byte{[]byte("disk,path=/#!~#used")}},
+		} {
+			b, err := entry.Encode(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Write(entry.Type(), snappy.Encode(nil, b)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	cmd := newTestCommand()
+	cmd.measurement = map[string]struct{}{"cpu": {}, "mem": {}}
+	cmd.startTime, cmd.endTime = 10, math.MaxInt64
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	var buf bytes.Buffer
+	if err = cmd.writeDeletes(&buf, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// the deletes of both retention policies are written once, and the range of mem is limited by start
+	exp := `# CONTEXT-DATABASE:db
+DELETE FROM cpu WHERE host = 'a b' AND time >= 10 AND time <= 20
+DELETE FROM mem WHERE time >= 10
+`
+	if buf.String() != exp {
+		t.Errorf("unexpected deletes:\n%s", buf.String())
+	}
+
+	cmd.measurement = map[string]struct{}{}
+	cmd.startTime = math.MinInt64
+	buf.Reset()
+	if err = cmd.writeDeletes(&buf, &buf); err != nil {
+		t.Fatal(err)
+	}
+	exp = `# CONTEXT-DATABASE:db
+DELETE FROM cpu WHERE host = 'a b' AND time >= 1 AND time <= 20
+DROP SERIES FROM mem
+DROP SERIES FROM disk WHERE path = '/'
+`
+	if buf.String() != exp {
+		t.Errorf("unexpected deletes:\n%s", buf.String())
+	}
+}
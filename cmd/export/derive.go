@@ -0,0 +1,57 @@
+package exporter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+// derivedField is a field derived from the fields of a line merged by group fields, such as power=voltage*current.
+type derivedField struct {
+	name   string
+	prefix []byte // escaped "<name>="
+	expr   influxql.Expr
+}
+
+// parseDerive parses the derived field of s like "power=voltage*current", whose expression is an influxql
+// expression of the field names, operators, numbers and strings. The field names requiring quotes are double
+// quoted, such as "power"="input voltage" * current.
+func parseDerive(s string) (*derivedField, error) {
+	name, expr, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("derive %q is invalid, require name=expression", s)
+	}
+	// the name is parsed as an expression too, so that it can be quoted like the field names of the expression
+	ref, err := influxql.ParseExpr(name)
+	if err != nil {
+		return nil, fmt.Errorf("derive %q is invalid: %v", s, err)
+	}
+	vr, ok := ref.(*influxql.VarRef)
+	if !ok {
+		return nil, fmt.Errorf("derive %q is invalid, require a field name before =", s)
+	}
+	e, err := influxql.ParseExpr(expr)
+	if err != nil {
+		return nil, fmt.Errorf("derive %q is invalid: %v", s, err)
+	}
+	return &derivedField{name: vr.Val, prefix: append(escape.Bytes([]byte(vr.Val)), '='), expr: e}, nil
+}
+
+// eval returns the value of the derived field at ts of the values of the fields by name, or false if the value is
+// unknown, such as a field of the expression missing at ts, or the result not a field value.
+func (d *derivedField) eval(values influxql.MapValuer, ts int64) (tsm1.Value, bool) {
+	if _, ok := values[d.name]; ok {
+		// a field of the same name is written instead
+		return nil, false
+	}
+	v := (&influxql.ValuerEval{Valuer: values, IntegerFloatDivision: true}).Eval(d.expr)
+	switch v.(type) {
+	case float64, int64, uint64, bool, string:
+		return tsm1.NewValue(ts, v), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,62 @@
+package exporter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestParseDerive(t *testing.T) {
+	for s, name := range map[string]string{
+		"power=voltage*current":         "power",
+		`"p w"="input voltage"*current`: "p w",
+		"ratio = used / total":          "ratio",
+	} {
+		d, err := parseDerive(s)
+		if err != nil || d.name != name {
+			t.Errorf("%s: unexpected derive %v, %v", s, d, err)
+		}
+	}
+	for _, s := range []string{"power", "=a*b", "power=", "a+b=c", "power=a*"} {
+		if _, err := parseDerive(s); err == nil {
+			t.Errorf("%s: expect error", s)
+		}
+	}
+}
+
+func TestFieldGroupDerive(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.groupFields = true
+	for _, s := range []string{"power=voltage*current", "half=current/2", `"p w"=power*1`} {
+		d, err := parseDerive(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd.derives = append(cmd.derives, d)
+	}
+	var buf bytes.Buffer
+	g := cmd.newFieldGroup(&buf)
+	fn := cmd.handleSeries(cmd.prefixes, g.add)
+	for _, s := range []struct {
+		field  string
+		values []tsm1.Value
+	}{
+		{"current", []tsm1.Value{tsm1.NewIntegerValue(1, 3), tsm1.NewIntegerValue(2, 4)}},
+		{"power", []tsm1.Value{tsm1.NewFloatValue(2, 9.5)}},
+		{"voltage", []tsm1.Value{tsm1.NewFloatValue(1, 1.5)}},
+	} {
+		if err := fn([]byte("ups,host=a"), []byte(s.field), s.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.flush(); err != nil {
+		t.Fatal(err)
+	}
+	exp := `ups,host=a current=3i,voltage=1.5,power=4.5,half=1.5 1
+ups,host=a current=4i,power=9.5,half=2,p\ w=9.5 2
+`
+	if buf.String() != exp {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,175 @@
+package exporter
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/chengshiwen/influx-tool/internal/history"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// valueLengths are the typical lengths of the values written by block type, such as 12.3456 or 123i.
+var valueLengths = map[byte]int{
+	tsm1.BlockFloat64:  7,
+	tsm1.BlockInteger:  4,
+	tsm1.BlockUnsigned: 4,
+	tsm1.BlockBoolean:  4,
+	tsm1.BlockString:   18,
+}
+
+// blockBytesPerPoint are the compressed bytes per point by block type assumed if no full block is scanned.
+var blockBytesPerPoint = map[byte]float64{
+	tsm1.BlockFloat64:  2,
+	tsm1.BlockInteger:  1,
+	tsm1.BlockUnsigned: 1,
+	tsm1.BlockBoolean:  0.5,
+	tsm1.BlockString:   8,
+}
+
+// blockOverheads are the bytes of a block with a point by block type, the checksum, headers, lengths and the first
+// timestamp and value.
+var blockOverheads = map[byte]float64{
+	tsm1.BlockFloat64:  34,
+	tsm1.BlockInteger:  24,
+	tsm1.BlockUnsigned: 24,
+	tsm1.BlockBoolean:  18,
+	tsm1.BlockString:   20,
+}
+
+// estimate is the estimated output of a measurement in a database and retention policy. The blocks of a key but
+// the last one are counted as full, and the points of the last one are estimated by its size and the bytes per
+// point of the full blocks of its type besides the overhead, between a point and a full block each.
+type estimate struct {
+	series      map[uint64]struct{}
+	blocks      int64
+	points      float64          // points of the full blocks
+	bytes       float64          // bytes of the lines of the full blocks
+	partBlocks  map[byte]float64 // count of the last blocks by type
+	partSize    map[byte]float64 // compressed size of the last blocks by type
+	partLineSum map[byte]float64 // compressed size of the last blocks multiplied by their line length, by type
+}
+
+// writeDryRun scans the indexes of the tsm files of the shards without reading the blocks, and writes the series, blocks,
+// points and uncompressed size of the output estimated by database, retention policy and measurement.
+func (cmd *command) writeDryRun(w io.Writer) error {
+	is, ok := cmd.src.(source.IndexSource)
+	if !ok {
+		return fmt.Errorf("dry run is not available for %s", cmd.kind)
+	}
+	estimates := make(map[string]*estimate)
+	fullSize, fullPoints := make(map[byte]float64), make(map[byte]float64)
+	for _, sh := range cmd.shards {
+		var lastMeasurement []byte
+		var e *estimate
+		err := is.ReadIndexes(sh, func(key []byte, typ byte, entries []tsm1.IndexEntry) error {
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			if !cmd.matchSeriesField(seriesKey, field) {
+				return nil
+			}
+			if name := models.ParseName(seriesKey); e == nil || string(name) != string(lastMeasurement) {
+				lastMeasurement = append(lastMeasurement[:0], name...)
+				path := filepath.Join(sh.Database, sh.RetentionPolicy, string(name))
+				if e = estimates[path]; e == nil {
+					e = &estimate{series: make(map[uint64]struct{}), partBlocks: make(map[byte]float64), partSize: make(map[byte]float64), partLineSum: make(map[byte]float64)}
+					estimates[path] = e
+				}
+			}
+
+			prefix := len(seriesKey) + len(field) + 2
+			matched := false
+			for i := range entries {
+				entry := &entries[i]
+				frac := cmd.overlap(entry.MinTime, entry.MaxTime)
+				if frac == 0 {
+					continue
+				}
+				matched = true
+				e.blocks++
+				line := float64(prefix + valueLengths[typ] + len(strconv.FormatInt(entry.MaxTime/cmd.precDiv, 10)) + 2)
+				if i < len(entries)-1 {
+					points := frac * tsdb.DefaultMaxPointsPerBlock
+					e.points += points
+					e.bytes += points * line
+					fullSize[typ] += float64(entry.Size)
+					fullPoints[typ] += tsdb.DefaultMaxPointsPerBlock
+				} else {
+					size := frac * float64(entry.Size)
+					e.partBlocks[typ]++
+					e.partSize[typ] += size
+					e.partLineSum[typ] += size * line
+				}
+			}
+			if matched {
+				h := fnv.New64a()
+				h.Write(seriesKey)
+				e.series[h.Sum64()] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	paths := make([]string, 0, len(estimates))
+	for path := range estimates {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var totalSeries, totalBlocks int64
+	var totalPoints, totalBytes float64
+	for _, path := range paths {
+		e := estimates[path]
+		if len(e.series) == 0 {
+			continue
+		}
+		points, bytes := e.points, e.bytes
+		for typ, size := range e.partSize {
+			bpp := blockBytesPerPoint[typ]
+			if fullPoints[typ] > 0 {
+				bpp = fullSize[typ] / fullPoints[typ]
+			}
+			n := e.partBlocks[typ] + (size-e.partBlocks[typ]*blockOverheads[typ])/bpp
+			if n < e.partBlocks[typ] {
+				n = e.partBlocks[typ]
+			} else if n > e.partBlocks[typ]*tsdb.DefaultMaxPointsPerBlock {
+				n = e.partBlocks[typ] * tsdb.DefaultMaxPointsPerBlock
+			}
+			points += n
+			bytes += e.partLineSum[typ] / size * n
+		}
+		fmt.Fprintf(w, "%s: %d series, %d blocks, ~%d points, ~%s\n", path, len(e.series), e.blocks, int64(points), history.FormatBytes(int64(bytes)))
+		totalSeries += int64(len(e.series))
+		totalBlocks += e.blocks
+		totalPoints += points
+		totalBytes += bytes
+	}
+	fmt.Fprintf(w, "total: %d series, %d blocks, ~%d points, ~%s uncompressed, estimated from the tsm indexes without the points in the wal\n",
+		totalSeries, totalBlocks, int64(totalPoints), history.FormatBytes(int64(totalBytes)))
+	return nil
+}
+
+// overlap returns the fraction of the time range [min, max] within the time range exported.
+func (cmd *command) overlap(min, max int64) float64 {
+	if max < cmd.startTime || min > cmd.endTime {
+		return 0
+	}
+	if min >= cmd.startTime && max <= cmd.endTime {
+		return 1
+	}
+	lo, hi := min, max
+	if lo < cmd.startTime {
+		lo = cmd.startTime
+	}
+	if hi > cmd.endTime {
+		hi = cmd.endTime
+	}
+	return (float64(hi) - float64(lo) + 1) / (float64(max) - float64(min) + 1)
+}
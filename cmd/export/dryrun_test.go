@@ -0,0 +1,89 @@
+package exporter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestOverlap(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.startTime, cmd.endTime = 10, 19
+	tests := []struct {
+		min, max int64
+		exp      float64
+	}{
+		{0, 9, 0},
+		{20, 29, 0},
+		{10, 19, 1},
+		{12, 15, 1},
+		{0, 19, 0.5},
+		{15, 24, 0.5},
+		{0, 39, 0.25},
+	}
+	for _, tt := range tests {
+		if got := cmd.overlap(tt.min, tt.max); got != tt.exp {
+			t.Errorf("overlap(%d, %d): got=%v, exp=%v", tt.min, tt.max, got, tt.exp)
+		}
+	}
+}
+
+func TestWriteDryRun(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	for i, rp := range []string{"autogen", "rp1"} {
+		shardDir := filepath.Join(dataDir, "db", rp, strconv.Itoa(i+1))
+		if err := os.MkdirAll(shardDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#usage":  {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2, 2.5)},
+			"cpu,host=a#!~#idle":   {tsm1.NewFloatValue(1, 0.5)},
+			"cpu,host=b#!~#usage":  {tsm1.NewFloatValue(1, 4.5)},
+			"mem,host=a#!~#used":   {tsm1.NewIntegerValue(1, 100)},
+			"disk,host=a#!~#mount": {tsm1.NewStringValue(100, "/")},
+		})
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := newTestCommand()
+	cmd.precision, cmd.precDiv = precisionNs, 1
+	cmd.startTime, cmd.endTime = 0, 10
+	cmd.kind = "tsm and wal file"
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	var buf bytes.Buffer
+	if err = cmd.writeDryRun(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// the block of disk is out of the time range, and the last blocks are estimated by their sizes besides the overhead
+	exp := []string{
+		"db/autogen/cpu: 2 series, 3 blocks, ~5 points, ~",
+		"db/autogen/mem: 1 series, 1 blocks, ~1 points, ~",
+		"db/rp1/cpu: 2 series, 3 blocks, ~5 points, ~",
+		"db/rp1/mem: 1 series, 1 blocks, ~1 points, ~",
+		"total: 6 series, 8 blocks, ~13 points, ~",
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(exp) {
+		t.Fatalf("unexpected dry run:\n%s", buf.String())
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, exp[i]) {
+			t.Errorf("unexpected line %d: %s", i, line)
+		}
+	}
+}
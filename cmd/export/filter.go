@@ -0,0 +1,83 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// tagFilter is a predicate on the value of a tag like the where clause of influxql: key=value, key!=value,
+// key=~regexp or key!~regexp, a series without the tag has an empty value.
+type tagFilter struct {
+	key   []byte
+	value []byte
+	re    *regexp.Regexp
+	not   bool
+}
+
+// parseTagFilter parses the tag filter, the first operator after a non-empty key is taken.
+func parseTagFilter(s string) (*tagFilter, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return nil, fmt.Errorf("tag filter: %s, require key=value, key!=value, key=~regexp or key!~regexp", s)
+	}
+	f := &tagFilter{key: []byte(s[:i])}
+	op, value := s[i:], ""
+	switch {
+	case strings.HasPrefix(op, "=~"), strings.HasPrefix(op, "!~"):
+		value = op[2:]
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("tag filter: %s, compile error: %v", s, err)
+		}
+		f.re = re
+	case strings.HasPrefix(op, "!="):
+		value = op[2:]
+	case op[0] == '=':
+		value = op[1:]
+	default:
+		return nil, fmt.Errorf("tag filter: %s, require key=value, key!=value, key=~regexp or key!~regexp", s)
+	}
+	f.value, f.not = []byte(value), op[0] == '!'
+	return f, nil
+}
+
+func (f *tagFilter) match(tags models.Tags) bool {
+	v := tags.Get(f.key)
+	if f.re != nil {
+		return f.re.Match(v) != f.not
+	}
+	return bytes.Equal(v, f.value) != f.not
+}
+
+// matchTags returns whether the tags match all the tag filters.
+func (cmd *command) matchTags(tags models.Tags) bool {
+	for _, f := range cmd.tagFilters {
+		if !f.match(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchSeriesSet returns whether the series key is in the series file if given.
+func (cmd *command) matchSeriesSet(seriesKey []byte) bool {
+	return cmd.seriesSet == nil || cmd.seriesSet.Has(seriesKey)
+}
+
+// matchSeries returns whether the series key is in the series file if given, and both the measurement and the tags
+// of it are matched.
+func (cmd *command) matchSeries(seriesKey []byte) bool {
+	if !cmd.matchSeriesSet(seriesKey) || !cmd.matchMeasurement(string(models.ParseName(seriesKey))) {
+		return false
+	}
+	return len(cmd.tagFilters) == 0 || cmd.matchTags(models.ParseTags(seriesKey))
+}
+
+// matchSeriesField returns whether the field of the series is matched besides the series.
+func (cmd *command) matchSeriesField(seriesKey, field []byte) bool {
+	return cmd.matchField(field) && cmd.matchSeries(seriesKey)
+}
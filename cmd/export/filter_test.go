@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestTagFilter(t *testing.T) {
+	for _, s := range []string{"host", "=web01", "host!web01", "host=~(", "host!"} {
+		if _, err := parseTagFilter(s); err == nil {
+			t.Errorf("%s: expect error", s)
+		}
+	}
+
+	tests := []struct {
+		filters []string
+		key     string
+		matched bool
+	}{
+		{nil, "cpu,host=web01", true},
+		{[]string{"host=web01"}, "cpu,host=web01,region=us", true},
+		{[]string{"host=web01"}, "cpu,host=web02", false},
+		{[]string{"host!=web01"}, "cpu,host=web02", true},
+		{[]string{"host=~^web0[12]$"}, "cpu,host=web02", true},
+		{[]string{"host!~^web"}, "cpu,host=web02", false},
+		{[]string{"host=web01", "region=us"}, "cpu,host=web01,region=eu", false},
+		{[]string{"host=a b,c=d"}, `cpu,host=a\ b\,c\=d`, true},
+		// a missing tag has an empty value
+		{[]string{"region="}, "cpu,host=web01", true},
+		{[]string{"region!=us"}, "cpu,host=web01", true},
+		{[]string{"region=us"}, "cpu", false},
+	}
+	for _, tt := range tests {
+		cmd := newTestCommand()
+		for _, s := range tt.filters {
+			f, err := parseTagFilter(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd.tagFilters = append(cmd.tagFilters, f)
+		}
+		if matched := cmd.matchSeries([]byte(tt.key)); matched != tt.matched {
+			t.Errorf("%v %s: got %v", tt.filters, tt.key, matched)
+		}
+	}
+}
+
+func TestFieldFilter(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.field = map[string]struct{}{"idle": {}}
+	cmd.regexpField = []*regexp.Regexp{regexp.MustCompile("^temp_")}
+	var buf bytes.Buffer
+	fn := cmd.writeSeries(&buf, cmd.prefixes)
+	for _, field := range []string{"idle", "user", "temp_cpu", "cpu_temp_max"} {
+		if err := fn([]byte("cpu,host=a"), []byte(field), []tsm1.Value{tsm1.NewIntegerValue(0, 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := "cpu,host=a idle=1i 0\ncpu,host=a temp_cpu=1i 0\n"; buf.String() != exp {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
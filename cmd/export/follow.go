@@ -0,0 +1,197 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/sink"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+const followWriteTimeout = time.Minute
+
+// v1Precisions are the precisions of the /write endpoint of influxdb 1.x by the precisions of export.
+var v1Precisions = map[string]string{
+	"h":         "h",
+	"m":         "m",
+	"s":         "s",
+	"ms":        "ms",
+	"u":         "u",
+	precisionNs: "n",
+}
+
+// followKey is a database and retention policy followed.
+type followKey struct {
+	db, rp string
+}
+
+// follower writes the points appended to the wal after the export, to the out file or standard out, or to the
+// target url if given.
+type follower struct {
+	cmd     *command
+	tail    *source.WALTail
+	w       *bufio.Writer // writer of the out file or standard out, nil if target url given
+	hc      *http.Client
+	created map[followKey]bool // databases and retention policies created on the target url
+	context followKey          // database and retention policy of the context comments written last
+	buf     bytes.Buffer
+	backoff time.Duration // backoff before the first retry, doubled for each later one
+}
+
+// newFollower returns the follower of the wal, whose files are marked as read, so that the points written to the
+// wal since are followed once the export done. The points written to the wal while exporting may be written twice,
+// which are the same points overwritten once imported.
+func (cmd *command) newFollower() (*follower, error) {
+	tail := source.NewWALTail(cmd.walDir, cmd.database)
+	if err := tail.Mark(); err != nil {
+		return nil, err
+	}
+	return &follower{cmd: cmd, tail: tail, created: make(map[followKey]bool), backoff: time.Second}, nil
+}
+
+// follow polls the wal by the follow interval and writes the points appended until interrupted, a failed write is
+// retried by the next poll, since the wal files are read from the last written entries again.
+func (f *follower) follow() error {
+	cmd := f.cmd
+	if cmd.targetURL != "" {
+		f.hc = &http.Client{Timeout: followWriteTimeout}
+	} else if cmd.usingStdOut() {
+		f.w = bufio.NewWriterSize(cmd.limitWriter(os.Stdout), 1024*1024)
+	} else {
+		out, err := os.OpenFile(cmd.out, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		f.w = bufio.NewWriterSize(cmd.limitWriter(out), 1024*1024)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	ticker := time.NewTicker(cmd.followInterval)
+	defer ticker.Stop()
+
+	log.SetFlags(log.LstdFlags)
+	log.Printf("following wal of %s, press ctrl-c to stop", cmd.walDir)
+	exported := cmd.progress.points.Load()
+	for {
+		if err := f.tail.Read(f.writeEntries); err != nil {
+			log.Printf("follow error: %v, retrying in %s", err, cmd.followInterval)
+		}
+		select {
+		case <-sig:
+			log.Printf("follow stopped, %d points written", cmd.progress.points.Load()-exported)
+			cmd.reportSkipped()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeEntries writes the values of the entries appended to the wal of the shard within the time range, the
+// series and fields are matched and transformed as exported.
+func (f *follower) writeEntries(sh *source.Shard, path string, offset int64, entries []*tsm1.WriteWALEntry) error {
+	cmd := f.cmd
+	if !cmd.includeRp(sh.RetentionPolicy) || !cmd.includeShard(sh.ID) {
+		return nil
+	}
+	key := followKey{db: sh.Database, rp: sh.RetentionPolicy}
+	cmd.context = &manifestKey{db: key.db, rp: key.rp}
+	f.buf.Reset()
+	write := cmd.writeSeries(&f.buf, cmd.prefixes)
+	for _, entry := range entries {
+		for k, values := range entry.Values {
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(k))
+			values = tsm1.Values(values).Include(cmd.startTime, cmd.endTime)
+			if len(values) == 0 {
+				continue
+			}
+			if err := write(seriesKey, field, values); err != nil {
+				return err
+			}
+		}
+	}
+	if f.buf.Len() == 0 {
+		return nil
+	}
+	if f.w == nil {
+		return f.post(key, shardRangeID(sh.ID, path, offset), f.buf.Bytes())
+	}
+	if f.context != key && !cmd.lponly {
+		cmd.writeContext(f.w, key.db, key.rp)
+		f.context = key
+	}
+	if _, err := f.w.Write(f.buf.Bytes()); err != nil {
+		return err
+	}
+	return f.w.Flush()
+}
+
+// post writes the lines to the target url in batches, tagged with the idempotency keys of the wal range, so that
+// the batches written again by the next poll once failed are the same. The database and retention policy are
+// created at first.
+func (f *follower) post(key followKey, id uint64, data []byte) error {
+	cmd := f.cmd
+	db := cmd.contextDatabase(key.db)
+	if !f.created[key] {
+		c, err := client.NewClient(cmd.targetConfig)
+		if err != nil {
+			return err
+		}
+		if err = sink.CreateSchema(c, db, key.rp); err != nil {
+			return err
+		}
+		f.created[key] = true
+	}
+	for seq := 0; len(data) > 0; seq++ {
+		// the end of the last line of the batch
+		n := 0
+		for i := 0; i < sink.DefaultBatchSize && n < len(data); i++ {
+			n += bytes.IndexByte(data[n:], '\n') + 1
+		}
+		idemKey := fmt.Sprintf("%s/%s/wal/%d/%d", url.PathEscape(db), url.PathEscape(key.rp), id, seq)
+		if err := f.postBatch(db, key.rp, idemKey, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// postBatch writes the batch, it is retried with backoff if failed by a network error or a server error.
+func (f *follower) postBatch(db, rp, idemKey string, data []byte) error {
+	cmd := f.cmd
+	backoff := f.backoff
+	for i := 0; ; i++ {
+		retry, err := sink.WriteV1(f.hc, cmd.targetConfig, db, rp, v1Precisions[cmd.precision], idemKey, data)
+		if err == nil {
+			return nil
+		}
+		if !retry || i >= sink.DefaultRetries {
+			return err
+		}
+		log.Printf("%s, retrying batch %s in %s", err, idemKey, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// shardRangeID returns the id of the entries of the wal file of the shard from the offset.
+func shardRangeID(id uint64, path string, offset int64) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%d", id, filepath.Base(path), offset)
+	return h.Sum64()
+}
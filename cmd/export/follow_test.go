@@ -0,0 +1,142 @@
+package exporter
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func writeFollowWAL(t *testing.T, path string, values map[string][]tsm1.Value) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entry := &tsm1.WriteWALEntry{Values: values}
+	b, err := entry.Encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tsm1.NewWALSegmentWriter(f)
+	if err = w.Write(entry.Type(), snappy.Encode(nil, b)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	dir := t.TempDir()
+	walDir, out := filepath.Join(dir, "wal"), filepath.Join(dir, "out")
+	seg := filepath.Join(walDir, "db", "rp", "1", "_00001.wal")
+	writeFollowWAL(t, seg, map[string][]tsm1.Value{"cpu#!~#v": {tsm1.NewValue(1, 1.0)}})
+	if err := os.WriteFile(out, []byte("exported\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := newTestCommand()
+	cmd.walDir, cmd.out, cmd.database, cmd.precision = walDir, out, "db", precisionNs
+	cmd.startTime, cmd.endTime = 2, math.MaxInt64
+	f, err := cmd.newFollower()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := os.OpenFile(out, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	f.w = bufio.NewWriter(w)
+
+	writeFollowWAL(t, seg, map[string][]tsm1.Value{"cpu#!~#v": {tsm1.NewValue(1, 1.0), tsm1.NewValue(2, 2.0)}})
+	writeFollowWAL(t, seg, map[string][]tsm1.Value{"mem#!~#v": {tsm1.NewValue(3, int64(3))}})
+	if err = f.tail.Read(f.writeEntries); err != nil {
+		t.Fatal(err)
+	}
+	// nothing appended since
+	if err = f.tail.Read(f.writeEntries); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "exported\n# CONTEXT-DATABASE:db\n# CONTEXT-RETENTION-POLICY:rp\ncpu v=2 2\nmem v=3i 3\n"
+	if string(b) != exp {
+		t.Fatalf("unexpected output:\n%s\nexp:\n%s", b, exp)
+	}
+}
+
+func TestFollowTarget(t *testing.T) {
+	var writes []string
+	var keys []string
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/query":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"results":[{"statement_id":0,"series":[{"name":"retention_policies","columns":["name"],"values":[["rp"]]}]}]}`)
+		case "/write":
+			q := r.URL.Query()
+			if q.Get("db") != "target" || q.Get("rp") != "rp" || q.Get("precision") != "s" {
+				http.Error(w, "bad request "+r.URL.String(), http.StatusBadRequest)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			keys = append(keys, r.Header.Get("Idempotency-Key"))
+			if fail {
+				fail = false
+				http.Error(w, "rejected", http.StatusBadRequest)
+				return
+			}
+			writes = append(writes, string(body))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	walDir := filepath.Join(dir, "wal")
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := newTestCommand()
+	cmd.walDir, cmd.database, cmd.targetDatabase, cmd.precision, cmd.precDiv = walDir, "db", "target", "s", 1e9
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	u, _ := url.Parse(ts.URL)
+	cmd.targetURL, cmd.targetConfig.URL = ts.URL, *u
+	f, err := cmd.newFollower()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.hc = ts.Client()
+
+	writeFollowWAL(t, filepath.Join(walDir, "db", "rp", "1", "_00001.wal"), map[string][]tsm1.Value{"cpu#!~#v": {tsm1.NewValue(1e9, 1.0)}})
+	if err = f.tail.Read(f.writeEntries); err == nil {
+		t.Fatal("expected error of the failed write")
+	}
+	if err = f.tail.Read(f.writeEntries); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 1 || writes[0] != "cpu v=1 1\n" {
+		t.Fatalf("unexpected writes: %q", writes)
+	}
+	if len(keys) != 2 || keys[0] != keys[1] || !strings.HasPrefix(keys[0], "target/rp/wal/") {
+		t.Fatalf("unexpected idempotency keys: %q", keys)
+	}
+}
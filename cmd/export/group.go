@@ -0,0 +1,259 @@
+package exporter
+
+import (
+	"bytes"
+	"io"
+	"math"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+// fieldGroup merges the fields of a series read consecutively into a line per timestamp like
+// "<series_key> <field1>=<value1>,<field2>=<value2> <timestamp>". The fields of a series are read in order from a
+// tsm file, so a field not after the last one starts a new group, such as the fields read again from the next tsm
+// file or the wal, which are written in separate lines. If not grouping fields but sorted, the fields of a series
+// merged across the files are written in a line per field in the order of timestamp instead.
+type fieldGroup struct {
+	cmd    *command
+	w      io.Writer
+	split  bool   // write a line per field at a timestamp
+	series []byte // escaped series key of the fields buffered
+	fields []groupField
+	heads  []int              // index of the next value of each field while merging
+	values influxql.MapValuer // values of the fields of a line by name if deriving fields
+
+	// the series key of the fields buffered split by rekey if given
+	name    []byte
+	tags    models.Tags    // tags not demoted
+	key     []byte         // escaped series key without the tags demoted
+	demoted []demotedField // fields of the tags demoted
+}
+
+type groupField struct {
+	prefix   []byte // "<field>="
+	name     string // unescaped field name if deriving fields or rekeying
+	promoted bool   // promoted to a tag by rekey
+	values   []tsm1.Value
+}
+
+func (cmd *command) newFieldGroup(w io.Writer) *fieldGroup {
+	g := &fieldGroup{cmd: cmd, w: w, split: cmd.sorted && !cmd.groupFields}
+	if len(cmd.derives) > 0 {
+		g.values = make(influxql.MapValuer)
+	}
+	return g
+}
+
+// add buffers the values of the field of the line prefix "<series_key> <field>=", the fields buffered are written
+// first if the series changes or the field is not after the last one.
+func (g *fieldGroup) add(prefix []byte, values []tsm1.Value) error {
+	n := seriesLen(prefix)
+	series, field := prefix[:n], prefix[n+1:]
+	if !bytes.Equal(series, g.series) || len(g.fields) > 0 && bytes.Compare(field, g.fields[len(g.fields)-1].prefix) <= 0 {
+		if err := g.flush(); err != nil {
+			return err
+		}
+		g.series = append(g.series[:0], series...)
+		if r := g.cmd.rekey; r != nil {
+			g.name, g.tags, g.demoted = r.demote(g.series)
+			g.key = models.AppendMakeKey(g.key[:0], g.name, g.tags)
+		}
+	}
+	// the values may be reused by source once returned
+	f := groupField{prefix: field, values: append([]tsm1.Value(nil), values...)}
+	if g.values != nil || g.cmd.rekey != nil {
+		f.name = string(escape.Unescape(field[:len(field)-1]))
+	}
+	if g.cmd.rekey != nil {
+		f.promoted = g.cmd.rekey.promoted(f.name)
+	}
+	g.fields = append(g.fields, f)
+	return nil
+}
+
+// flush writes the fields buffered merged by timestamp, the values of a field are sorted by time.
+func (g *fieldGroup) flush() error {
+	if len(g.fields) == 0 {
+		return nil
+	}
+	bp := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bp)
+	buf := (*bp)[:0]
+
+	g.heads = append(g.heads[:0], make([]int, len(g.fields))...)
+	points := 0
+	for {
+		ts := int64(math.MaxInt64)
+		done := true
+		for i, f := range g.fields {
+			if h := g.heads[i]; h < len(f.values) {
+				done = false
+				if t := f.values[h].UnixNano(); t < ts {
+					ts = t
+				}
+			}
+		}
+		if done {
+			break
+		}
+		var n int
+		if g.split {
+			buf, n = g.appendLines(buf, ts)
+		} else {
+			buf, n = g.appendLine(buf, ts)
+		}
+		points += n
+	}
+	*bp = buf
+	g.fields = g.fields[:0]
+	g.cmd.addWritten(points, len(buf))
+	_, err := g.w.Write(buf)
+	return err
+}
+
+// appendLine appends the line of the fields at the timestamp followed by the fields demoted and derived, and returns
+// the number of lines appended, which is 0 if all the values are skipped.
+func (g *fieldGroup) appendLine(buf []byte, ts int64) ([]byte, int) {
+	n := len(buf)
+	buf = g.appendKey(buf, ts)
+	sep := byte(' ')
+	clear(g.values)
+	for i, f := range g.fields {
+		h := g.heads[i]
+		if h >= len(f.values) || f.values[h].UnixNano() != ts {
+			continue
+		}
+		g.heads[i]++
+		if g.values != nil {
+			g.values[f.name] = f.values[h].Value()
+		}
+		if f.promoted {
+			continue
+		}
+		m := len(buf)
+		buf = append(buf, sep)
+		buf = append(buf, f.prefix...)
+		var ok bool
+		if buf, ok = g.cmd.appendValue(buf, f.values[h]); !ok {
+			buf = buf[:m]
+			continue
+		}
+		sep = ','
+	}
+	for _, d := range g.demoted {
+		if g.hasField(d.name) {
+			// a field of the same name is written instead
+			continue
+		}
+		if !d.ok {
+			g.cmd.rekeySkipped.Add(1)
+			continue
+		}
+		m := len(buf)
+		buf = append(buf, sep)
+		buf = append(buf, d.prefix...)
+		var ok bool
+		if buf, ok = g.cmd.appendValue(buf, tsm1.NewValue(ts, d.value)); !ok {
+			buf = buf[:m]
+			continue
+		}
+		sep = ','
+	}
+	for _, d := range g.cmd.derives {
+		v, ok := d.eval(g.values, ts)
+		if !ok {
+			continue
+		}
+		m := len(buf)
+		buf = append(buf, sep)
+		buf = append(buf, d.prefix...)
+		if buf, ok = g.cmd.appendValue(buf, v); !ok {
+			buf = buf[:m]
+			continue
+		}
+		sep = ','
+	}
+	// all the values at the timestamp are skipped
+	if sep == ' ' {
+		return buf[:n], 0
+	}
+	return g.cmd.appendTimestamp(buf, ts), 1
+}
+
+// appendKey appends the series key of the line at the timestamp, which is tagged by the fields promoted at it and
+// without the tags demoted if rekeying. The heads of the fields are not moved.
+func (g *fieldGroup) appendKey(buf []byte, ts int64) []byte {
+	if g.cmd.rekey == nil {
+		return append(buf, g.series...)
+	}
+	var tags models.Tags
+	for i, f := range g.fields {
+		h := g.heads[i]
+		if !f.promoted || h >= len(f.values) || f.values[h].UnixNano() != ts {
+			continue
+		}
+		v, ok := tagValue(f.values[h])
+		if !ok {
+			continue
+		}
+		if tags == nil {
+			tags = g.tags.Clone()
+		}
+		tags.SetString(f.name, v)
+	}
+	if tags == nil {
+		return append(buf, g.key...)
+	}
+	return models.AppendMakeKey(buf, g.name, tags)
+}
+
+// hasField returns whether a field of the name is buffered.
+func (g *fieldGroup) hasField(name string) bool {
+	for _, f := range g.fields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// appendLines appends a line per field at the timestamp, and returns the number of lines appended.
+func (g *fieldGroup) appendLines(buf []byte, ts int64) ([]byte, int) {
+	lines := 0
+	for i, f := range g.fields {
+		h := g.heads[i]
+		if h >= len(f.values) || f.values[h].UnixNano() != ts {
+			continue
+		}
+		g.heads[i]++
+		n := len(buf)
+		buf = append(buf, g.series...)
+		buf = append(buf, ' ')
+		buf = append(buf, f.prefix...)
+		var ok bool
+		if buf, ok = g.cmd.appendValue(buf, f.values[h]); !ok {
+			buf = buf[:n]
+			continue
+		}
+		buf = g.cmd.appendTimestamp(buf, ts)
+		lines++
+	}
+	return buf, lines
+}
+
+// seriesLen returns the length of the series key of the line prefix "<series_key> <field>=", which is ended by
+// the first unescaped space.
+func seriesLen(prefix []byte) int {
+	for i := 0; i < len(prefix); i++ {
+		switch prefix[i] {
+		case '\\':
+			i++
+		case ' ':
+			return i
+		}
+	}
+	return len(prefix)
+}
@@ -0,0 +1,79 @@
+package exporter
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestFieldGroup(t *testing.T) {
+	cmd := newTestCommand()
+	var buf bytes.Buffer
+	g := cmd.newFieldGroup(&buf)
+	fn := cmd.handleSeries(cmd.prefixes, g.add)
+	series := []struct {
+		key, field string
+		values     []tsm1.Value
+	}{
+		{`cpu,host=a\ b`, "idle", []tsm1.Value{tsm1.NewFloatValue(1, 0.5), tsm1.NewFloatValue(3, math.NaN())}},
+		{`cpu,host=a\ b`, "msg", []tsm1.Value{tsm1.NewStringValue(2, "x,y"), tsm1.NewStringValue(3, "z")}},
+		{`cpu,host=a\ b`, "user", []tsm1.Value{tsm1.NewIntegerValue(1, 7), tsm1.NewIntegerValue(4, 8)}},
+		// the fields of the next tsm file start a new group
+		{`cpu,host=a\ b`, "idle", []tsm1.Value{tsm1.NewFloatValue(5, 1.5)}},
+		{"mem,host=a", "used", []tsm1.Value{tsm1.NewFloatValue(3, math.Inf(1))}},
+		{"mem,host=c", "used", []tsm1.Value{tsm1.NewIntegerValue(1, 1)}},
+	}
+	for _, s := range series {
+		if err := fn([]byte(s.key), []byte(s.field), s.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.flush(); err != nil {
+		t.Fatal(err)
+	}
+	exp := `cpu,host=a\ b idle=0.5,user=7i 1
+cpu,host=a\ b msg="x,y" 2
+cpu,host=a\ b msg="z" 3
+cpu,host=a\ b user=8i 4
+cpu,host=a\ b idle=1.5 5
+mem,host=c used=1i 1
+`
+	if buf.String() != exp || cmd.nans.Load() != 2 {
+		t.Errorf("unexpected output of %d non-finite values:\n%s", cmd.nans.Load(), buf.String())
+	}
+}
+
+func TestFieldGroupSorted(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.sorted = true
+	var buf bytes.Buffer
+	g := cmd.newFieldGroup(&buf)
+	fn := cmd.handleSeries(cmd.prefixes, g.add)
+	series := []struct {
+		key, field string
+		values     []tsm1.Value
+	}{
+		{"cpu,host=a", "idle", []tsm1.Value{tsm1.NewFloatValue(1, 0.5), tsm1.NewFloatValue(3, math.NaN()), tsm1.NewFloatValue(4, 1.5)}},
+		{"cpu,host=a", "user", []tsm1.Value{tsm1.NewIntegerValue(2, 7), tsm1.NewIntegerValue(4, 8)}},
+		{"mem,host=a", "used", []tsm1.Value{tsm1.NewIntegerValue(1, 1)}},
+	}
+	for _, s := range series {
+		if err := fn([]byte(s.key), []byte(s.field), s.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.flush(); err != nil {
+		t.Fatal(err)
+	}
+	exp := `cpu,host=a idle=0.5 1
+cpu,host=a user=7i 2
+cpu,host=a idle=1.5 4
+cpu,host=a user=8i 4
+mem,host=a used=1i 1
+`
+	if buf.String() != exp || cmd.progress.points.Load() != 5 {
+		t.Errorf("unexpected output of %d points:\n%s", cmd.progress.points.Load(), buf.String())
+	}
+}
@@ -0,0 +1,124 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+const (
+	histogramCSV  = "csv"
+	histogramJSON = "json"
+)
+
+type histogramKey struct {
+	db, rp, name string
+	bucket       int64 // start of the time bucket in nanoseconds
+}
+
+// histogram counts the values exported per measurement and time bucket, to find the gaps and spikes of the data.
+type histogram struct {
+	mu     sync.Mutex
+	counts map[histogramKey]int64
+}
+
+// histogramRow is a row of the histogram written out.
+type histogramRow struct {
+	Database        string    `json:"database"`
+	RetentionPolicy string    `json:"retention_policy"`
+	Measurement     string    `json:"measurement"`
+	Time            time.Time `json:"time"`
+	Values          int64     `json:"values"`
+}
+
+// addHistogram counts the values of the series in their time buckets, if histogram out given.
+func (cmd *command) addHistogram(seriesKey []byte, values []tsm1.Value) {
+	if cmd.histogramOut == "" || len(values) == 0 {
+		return
+	}
+	key := histogramKey{name: string(models.ParseName(seriesKey))}
+	if k := cmd.progress.key.Load(); k != nil {
+		key.db, key.rp = k.db, k.rp
+	}
+	interval := int64(cmd.histogramInterval)
+	h := &cmd.histogram
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.counts = make(map[histogramKey]int64)
+	}
+	// the values are sorted by time, so the values of a bucket are counted at once
+	for i := 0; i < len(values); {
+		key.bucket = floorDiv(values[i].UnixNano(), interval) * interval
+		j := i + 1
+		for j < len(values) && values[j].UnixNano() < key.bucket+interval {
+			j++
+		}
+		h.counts[key] += int64(j - i)
+		i = j
+	}
+}
+
+// rows returns the rows of the histogram sorted by database, retention policy, measurement and time.
+func (h *histogram) rows() []histogramRow {
+	keys := make([]histogramKey, 0, len(h.counts))
+	for k := range h.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.db != b.db {
+			return a.db < b.db
+		}
+		if a.rp != b.rp {
+			return a.rp < b.rp
+		}
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		return a.bucket < b.bucket
+	})
+	rows := make([]histogramRow, len(keys))
+	for i, k := range keys {
+		rows[i] = histogramRow{Database: k.db, RetentionPolicy: k.rp, Measurement: k.name, Time: time.Unix(0, k.bucket).UTC(), Values: h.counts[k]}
+	}
+	return rows
+}
+
+// writeHistogram writes the histogram to the histogram out file in the histogram format, if given.
+func (cmd *command) writeHistogram() error {
+	if cmd.histogramOut == "" {
+		return nil
+	}
+	rows := cmd.histogram.rows()
+	f, err := os.Create(cmd.histogramOut)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if cmd.histogramFormat == histogramJSON {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(rows); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"database", "retention_policy", "measurement", "time", "values"})
+	for _, r := range rows {
+		w.Write([]string{r.Database, r.RetentionPolicy, r.Measurement, r.Time.Format(time.RFC3339), strconv.FormatInt(r.Values, 10)})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
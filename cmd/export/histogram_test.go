@@ -0,0 +1,39 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestWriteHistogram(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.histogramOut = filepath.Join(t.TempDir(), "histogram.csv")
+	cmd.histogramInterval = time.Hour
+	cmd.histogramFormat = histogramCSV
+	hour := int64(time.Hour)
+	cmd.startKey(&manifestKey{db: "db0", rp: "autogen"})
+	cmd.addHistogram([]byte("mem,host=a"), []tsm1.Value{tsm1.NewIntegerValue(0, 1)})
+	cmd.addHistogram([]byte("cpu,host=a"), []tsm1.Value{tsm1.NewFloatValue(0, 1), tsm1.NewFloatValue(hour-1, 1), tsm1.NewFloatValue(2*hour, 1)})
+	// the buckets before the epoch are floored
+	cmd.addHistogram([]byte("cpu,host=b"), []tsm1.Value{tsm1.NewFloatValue(-1, 1), tsm1.NewFloatValue(1, 1)})
+	if err := cmd.writeHistogram(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(cmd.histogramOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `database,retention_policy,measurement,time,values
+db0,autogen,cpu,1969-12-31T23:00:00Z,1
+db0,autogen,cpu,1970-01-01T00:00:00Z,3
+db0,autogen,cpu,1970-01-01T02:00:00Z,1
+db0,autogen,mem,1970-01-01T00:00:00Z,1
+`
+	if string(b) != exp {
+		t.Errorf("unexpected histogram:\n%s", b)
+	}
+}
@@ -0,0 +1,75 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/history"
+	"github.com/chengshiwen/influx-tool/internal/notify"
+)
+
+// stats are the statistics of the export, recorded into the history file to estimate the duration of later exports.
+type stats struct {
+	path   string
+	name   string
+	est    *history.Estimator
+	start  time.Time
+	total  int64
+	series atomic.Int64
+}
+
+// startStats loads the previous exports of the same format from the history file, and writes the estimate to w.
+func (cmd *command) startStats(w io.Writer) {
+	st := &cmd.stats
+	st.path, st.name, st.start = history.Path(cmd.historyFile), "export "+cmd.format, time.Now()
+	for _, sh := range cmd.shards {
+		st.total += sh.Size()
+	}
+	var records []history.Record
+	if st.path != "" {
+		var err error
+		if records, err = history.Load(st.path, st.name); err != nil {
+			fmt.Fprintf(w, "load history error: %v\n", err)
+		}
+	}
+	st.est = history.NewEstimator(records)
+	if st.total > 0 {
+		fmt.Fprintln(w, st.est.String(st.total))
+	}
+}
+
+// record returns the statistics of the export so far.
+func (cmd *command) record() history.Record {
+	st := &cmd.stats
+	return history.NewRecord(st.name, st.total, st.series.Load(), time.Since(st.start))
+}
+
+// notify posts the summary of the export started at start to the webhook if any, with the statistics if the
+// export was started.
+func (cmd *command) notify(start time.Time, err error) {
+	if cmd.webhook.URL == "" {
+		return
+	}
+	var stats []history.Record
+	if cmd.stats.name != "" {
+		stats = append(stats, cmd.record())
+	}
+	s := notify.NewSummary(cmd.cobraCmd.CommandPath(), start, err, stats)
+	if err := cmd.webhook.Send(s); err != nil {
+		fmt.Fprintf(cmd.msgOut(), "notify webhook error: %v\n", err)
+	}
+}
+
+// saveStats appends the statistics of the export finished to the history file, the exports of unknown size are
+// not recorded.
+func (cmd *command) saveStats(w io.Writer) {
+	st := &cmd.stats
+	if st.path == "" || st.total <= 0 {
+		return
+	}
+	if err := history.Append(st.path, cmd.record()); err != nil {
+		fmt.Fprintf(w, "save history error: %v\n", err)
+	}
+}
@@ -0,0 +1,163 @@
+package exporter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// outputManifest lists the output files of an export with their checksums, points and time ranges, so that the
+// integrity of the files can be verified once transferred, such as by sha256sum before they are imported.
+type outputManifest struct {
+	Created     time.Time       `json:"created"`
+	Format      string          `json:"format"`
+	Precision   string          `json:"precision"`
+	Compression string          `json:"compression"`
+	Points      int64           `json:"points"`
+	Files       []*manifestFile `json:"files"`
+
+	byPath map[string]*manifestFile
+}
+
+// manifestFile is an output file in the manifest, whose points and time range are counted from the lines of
+// points written to it before compressed, and the size and checksum are of the file once written.
+type manifestFile struct {
+	Path    string     `json:"path"` // relative to the directory of the manifest if under it
+	Size    int64      `json:"size"`
+	SHA256  string     `json:"sha256"`
+	Points  int64      `json:"points"`
+	MinTime *time.Time `json:"min_time,omitempty"`
+	MaxTime *time.Time `json:"max_time,omitempty"`
+
+	path     string
+	precDiv  int64
+	dml      bool // whether the lines following are the lines of points
+	partial  []byte
+	min, max int64
+}
+
+func (cmd *command) newOutputManifest() *outputManifest {
+	return &outputManifest{Format: cmd.format, Precision: cmd.precision, Compression: cmd.compression, byPath: make(map[string]*manifestFile)}
+}
+
+// file returns the entry of the output file of path, which is added at first, the lines written to the file are
+// written to the entry as well. The lines written without the DDL are all lines of points.
+func (m *outputManifest) file(path string, lponly bool, precDiv int64) *manifestFile {
+	if f, ok := m.byPath[path]; ok {
+		return f
+	}
+	f := &manifestFile{path: path, precDiv: precDiv, dml: lponly}
+	m.byPath[path] = f
+	m.Files = append(m.Files, f)
+	return f
+}
+
+// Write counts the lines of points in b, a line may be split across the writes.
+func (f *manifestFile) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			f.partial = append(f.partial, b...)
+			break
+		}
+		line := b[:i]
+		if len(f.partial) > 0 {
+			f.partial = append(f.partial, line...)
+			line = f.partial
+		}
+		f.addLine(line)
+		f.partial, b = f.partial[:0], b[i+1:]
+	}
+	return n, nil
+}
+
+func (f *manifestFile) addLine(line []byte) {
+	if len(line) == 0 {
+		return
+	}
+	if line[0] == '#' {
+		if string(line) == "# DML" {
+			f.dml = true
+		}
+		return
+	}
+	if !f.dml {
+		return
+	}
+	ts, err := strconv.ParseInt(string(line[bytes.LastIndexByte(line, ' ')+1:]), 10, 64)
+	if err != nil {
+		return
+	}
+	ts *= f.precDiv
+	if f.Points == 0 || ts < f.min {
+		f.min = ts
+	}
+	if f.Points == 0 || ts > f.max {
+		f.max = ts
+	}
+	f.Points++
+}
+
+// manifestWriter returns the writer of the lines written to the output file of path as well, or w if no manifest.
+func (cmd *command) manifestWriter(w io.Writer, path string) io.Writer {
+	if cmd.outManifest == nil {
+		return w
+	}
+	return io.MultiWriter(w, cmd.outManifest.file(path, cmd.lponly, cmd.precDiv))
+}
+
+// writeManifest writes the manifest of the output files to the manifest path, if given.
+func (cmd *command) writeManifest() error {
+	m := cmd.outManifest
+	if m == nil {
+		return nil
+	}
+	dir, err := filepath.Abs(filepath.Dir(cmd.manifestPath))
+	if err != nil {
+		return err
+	}
+	m.Created = time.Now().UTC()
+	for _, f := range m.Files {
+		if f.Size, f.SHA256, err = checksum(f.path); err != nil {
+			return err
+		}
+		f.Path = f.path
+		if abs, err := filepath.Abs(f.path); err == nil {
+			if rel, err := filepath.Rel(dir, abs); err == nil && filepath.IsLocal(rel) {
+				f.Path = rel
+			}
+		}
+		if f.Points > 0 {
+			min, max := time.Unix(0, f.min).UTC(), time.Unix(0, f.max).UTC()
+			f.MinTime, f.MaxTime = &min, &max
+		}
+		m.Points += f.Points
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cmd.manifestPath, append(b, '\n'), 0644)
+}
+
+// checksum returns the size and the hex sha256 of the file.
+func checksum(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	cmd := newTestCommand()
+	cmd.format, cmd.precision, cmd.precDiv, cmd.compression = formatLine, "s", int64(time.Second), compressionNone
+	cmd.manifestPath = filepath.Join(dir, "manifest.json")
+	cmd.outManifest = cmd.newOutputManifest()
+
+	path := filepath.Join(dir, "out.lp")
+	data := "# DDL\nCREATE DATABASE db WITH NAME autogen\n# DML\n# CONTEXT-DATABASE:db\ncpu v=1 20\ncpu v=2 10\nmem v=1i 30\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := cmd.manifestWriter(io.Discard, path)
+	// the lines are split across the writes
+	for _, part := range []string{data[:50], data[50:62], data[62:]} {
+		if _, err := w.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cmd.writeManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(cmd.manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m outputManifest
+	if err = json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Points != 3 || len(m.Files) != 1 {
+		t.Fatalf("unexpected manifest: %s", b)
+	}
+	f := m.Files[0]
+	sum := sha256.Sum256([]byte(data))
+	if f.Path != "out.lp" || f.Size != int64(len(data)) || f.SHA256 != hex.EncodeToString(sum[:]) || f.Points != 3 {
+		t.Errorf("unexpected file: %+v", f)
+	}
+	if !f.MinTime.Equal(time.Unix(10, 0)) || !f.MaxTime.Equal(time.Unix(30, 0)) {
+		t.Errorf("unexpected time range: %v - %v", f.MinTime, f.MaxTime)
+	}
+}
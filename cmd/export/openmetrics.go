@@ -0,0 +1,204 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// omWriter writes the values of series in the openmetrics text format, the metric of a field is named
+// <measurement>_<field> and labeled by the tags of the series, with the timestamps in seconds. The metric family is
+// typed as gauge again before the samples whose metric differs from the last one, so that a metric read from several
+// shards has several families of the same name.
+type omWriter struct {
+	cmd *command
+	w   io.Writer
+	buf bytes.Buffer
+
+	series []byte // escaped series key of the last samples
+	name   string // metric name of the measurement of the series
+	labels []byte // label set of the series, empty if no tags
+	metric string // metric of the last samples
+}
+
+func (cmd *command) newOMWriter(w io.Writer) *omWriter {
+	return &omWriter{cmd: cmd, w: w}
+}
+
+// add writes the values of the field of the line prefix "<series_key> <field>=" as the samples of a metric.
+func (ow *omWriter) add(prefix []byte, values []tsm1.Value) error {
+	if len(values) == 0 {
+		return nil
+	}
+	// the values of a field are of the same type, and string values are not numbers
+	if _, ok := values[0].(tsm1.StringValue); ok {
+		ow.cmd.strs.Add(int64(len(values)))
+		return nil
+	}
+	n := seriesLen(prefix)
+	series, field := prefix[:n], escape.Unescape(prefix[n+1:len(prefix)-1])
+	if !bytes.Equal(series, ow.series) {
+		ow.series = append(ow.series[:0], series...)
+		name, tags := models.ParseKeyBytes(series)
+		ow.name, ow.labels = omName(name, true), ow.labels[:0]
+		for i, tag := range tags {
+			if i == 0 {
+				ow.labels = append(ow.labels, '{')
+			} else {
+				ow.labels = append(ow.labels, ',')
+			}
+			ow.labels = append(ow.labels, omName(tag.Key, false)...)
+			ow.labels = append(ow.labels, '=', '"')
+			ow.labels = appendLabelValue(ow.labels, tag.Value)
+			ow.labels = append(ow.labels, '"')
+		}
+		if len(tags) > 0 {
+			ow.labels = append(ow.labels, '}')
+		}
+	}
+	metric := ow.name + "_" + omName(field, true)
+	if metric != ow.metric {
+		ow.metric = metric
+		fmt.Fprintf(&ow.buf, "# TYPE %s gauge\n", metric)
+	}
+
+	points := 0
+	for _, value := range values {
+		v, ok := ow.formatValue(value)
+		if !ok {
+			continue
+		}
+		ow.buf.WriteString(metric)
+		ow.buf.Write(ow.labels)
+		ow.buf.WriteByte(' ')
+		ow.buf.WriteString(v)
+		ow.buf.WriteByte(' ')
+		ow.buf.Write(appendSeconds(nil, value.UnixNano()))
+		ow.buf.WriteByte('\n')
+		points++
+	}
+	ow.cmd.addWritten(points, ow.buf.Len())
+	_, err := ow.w.Write(ow.buf.Bytes())
+	ow.buf.Reset()
+	return err
+}
+
+// formatValue returns the value formatted as a sample value, or false if it is skipped as the non-finite float values
+// dropped. Booleans are 1 and 0, and the non-finite float values are written as NaN, +Inf and -Inf by nonfinite string.
+func (ow *omWriter) formatValue(value tsm1.Value) (string, bool) {
+	cmd := ow.cmd
+	switch v := value.Value().(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			cmd.nans.Add(1)
+			switch cmd.nonfinite {
+			case nonfiniteDrop:
+				return "", false
+			case nonfiniteString:
+				if math.IsNaN(v) {
+					return "NaN", true
+				}
+				return strconv.FormatFloat(v, 'f', -1, 64), true
+			}
+			v = 0
+		}
+		return strconv.FormatFloat(v, cmd.floatFormat, cmd.floatPrecision, 64), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	default:
+		return "", false
+	}
+}
+
+// omName returns the name as a metric name if metric, or a label name otherwise: the characters other than letters,
+// digits, underscores and colons of metric names are replaced by underscores, and a leading digit is prefixed by one.
+func omName(name []byte, metric bool) string {
+	b := make([]byte, 0, len(name)+1)
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':' && metric:
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b = append(b, '_')
+			}
+		default:
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+// appendLabelValue appends the label value with backslashes, double quotes and line feeds escaped.
+func appendLabelValue(b, value []byte) []byte {
+	for _, c := range value {
+		switch c {
+		case '\\':
+			b = append(b, '\\', '\\')
+		case '"':
+			b = append(b, '\\', '"')
+		case '\n':
+			b = append(b, '\\', 'n')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// appendSeconds appends the timestamp in nanoseconds as seconds, with the fraction of nanoseconds if any.
+func appendSeconds(b []byte, ns int64) []byte {
+	u := uint64(ns)
+	if ns < 0 {
+		b = append(b, '-')
+		u = uint64(-ns)
+	}
+	b = strconv.AppendUint(b, u/1e9, 10)
+	if frac := u % 1e9; frac > 0 {
+		digits := strconv.AppendUint(nil, frac+1e9, 10)[1:]
+		b = append(b, '.')
+		b = append(b, bytes.TrimRight(digits, "0")...)
+	}
+	return b
+}
+
+// readOpenMetrics reads the values of the shard from source and writes the samples of openmetrics to w with the line
+// prefixes cached in prefixes.
+func (cmd *command) readOpenMetrics(sh *source.Shard, w io.Writer, prefixes *prefixCache) error {
+	ow := cmd.newOMWriter(w)
+	return cmd.readSource(sh, cmd.handleSeries(prefixes, ow.add))
+}
+
+// writeOpenMetrics writes the openmetrics of the shards to w, terminated by the EOF marker.
+func (cmd *command) writeOpenMetrics(w io.Writer) error {
+	msgOut := cmd.msgOut()
+	for _, key := range cmd.manifest() {
+		cmd.context = key
+		cmd.startKey(key)
+		fmt.Fprintf(msgOut, "writing out %s data for %s%s...", cmd.kind, filepath.Join(key.db, key.rp), cmd.withMeasurement())
+		if err := cmd.readShards(key.shards, w); err != nil {
+			return err
+		}
+		fmt.Fprintf(msgOut, "complete%s.\n", cmd.keyDone())
+	}
+	_, err := io.WriteString(w, "# EOF\n")
+	return err
+}
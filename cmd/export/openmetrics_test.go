@@ -0,0 +1,47 @@
+package exporter
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestOMWriter(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.format = formatOM
+	cmd.nonfinite = nonfiniteString
+	var buf bytes.Buffer
+	ow := cmd.newOMWriter(&buf)
+	fn := cmd.handleSeries(cmd.prefixes, ow.add)
+	series := []struct {
+		key, field string
+		values     []tsm1.Value
+	}{
+		{`cpu,host=a\ b,region=us"e\ast`, "usage-idle", []tsm1.Value{tsm1.NewFloatValue(1, 0.5), tsm1.NewFloatValue(1500000000, math.Inf(-1))}},
+		{`cpu,host=c`, "usage-idle", []tsm1.Value{tsm1.NewFloatValue(-1500000000, 1)}},
+		{`cpu,host=c`, "msg", []tsm1.Value{tsm1.NewStringValue(2e9, "x")}},
+		{`cpu,host=c`, "up", []tsm1.Value{tsm1.NewBooleanValue(2e9, true), tsm1.NewBooleanValue(3e9, false)}},
+		{`9m\,1`, "f", []tsm1.Value{tsm1.NewIntegerValue(3, 7), tsm1.NewUnsignedValue(4, math.MaxUint64)}},
+	}
+	for _, s := range series {
+		if err := fn([]byte(s.key), []byte(s.field), s.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := `# TYPE cpu_usage_idle gauge
+cpu_usage_idle{host="a b",region="us\"e\\ast"} 0.5 0.000000001
+cpu_usage_idle{host="a b",region="us\"e\\ast"} -Inf 1.5
+cpu_usage_idle{host="c"} 1 -1.5
+# TYPE cpu_up gauge
+cpu_up{host="c"} 1 2
+cpu_up{host="c"} 0 3
+# TYPE _9m_1_f gauge
+_9m_1_f 7 0.000000003
+_9m_1_f 18446744073709551615 0.000000004
+`
+	if buf.String() != exp || cmd.nans.Load() != 1 || cmd.strs.Load() != 1 {
+		t.Errorf("unexpected output of %d non-finite and %d string values:\n%s", cmd.nans.Load(), cmd.strs.Load(), buf.String())
+	}
+}
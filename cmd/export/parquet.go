@@ -0,0 +1,393 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	parquetLayoutMeasurement = "measurement"
+	parquetLayoutRP          = "retention-policy"
+
+	parquetTimeColumn        = "time"
+	parquetMeasurementColumn = "measurement"
+	parquetRowGroupSize      = 1024 * 1024
+)
+
+// parquetUnits are the time units of the time column by the precisions available for parquet format.
+var parquetUnits = map[string]parquet.TimeUnit{
+	"ms":        parquet.Millisecond,
+	"u":         parquet.Microsecond,
+	precisionNs: parquet.Nanosecond,
+}
+
+// parquetTable is a parquet file, the schema of which is inferred from the tag keys and field types of the series
+// written into it. The columns are the time, the measurement if the file has several, the fields and the tags.
+type parquetTable struct {
+	path                    string
+	name                    string                       // measurement of the file, empty if the file has several
+	tags                    map[string]int               // column index by tag key
+	fields                  map[string]int               // column index by field
+	types                   map[string]influxql.DataType // type by field, the first one seen
+	group                   parquet.Group
+	timeCol, measurementCol int
+
+	file *os.File
+	w    *parquet.Writer
+}
+
+func newParquetTable(path, name string) *parquetTable {
+	return &parquetTable{
+		path:   path,
+		name:   name,
+		tags:   make(map[string]int),
+		fields: make(map[string]int),
+		types:  make(map[string]influxql.DataType),
+	}
+}
+
+// addSeries adds the tag keys and the field of a series to the schema.
+func (t *parquetTable) addSeries(tags models.Tags, field string, typ influxql.DataType) {
+	for _, tag := range tags {
+		t.tags[string(tag.Key)] = -1
+	}
+	if _, ok := t.types[field]; !ok && typ != influxql.Unknown {
+		t.types[field] = typ
+	}
+}
+
+// build builds the schema. A name taken by a previous column is suffixed as influxql does, e.g. a tag named as
+// a field is renamed to tag_1. The columns are indexed in the order of names, as the group orders them.
+func (t *parquetTable) build(unit parquet.TimeUnit) {
+	t.group = make(parquet.Group)
+	add := func(name string, node parquet.Node) string {
+		col := name
+		for i := 1; t.group[col] != nil; i++ {
+			col = name + "_" + strconv.Itoa(i)
+		}
+		t.group[col] = node
+		return col
+	}
+	timeName := add(parquetTimeColumn, parquet.Timestamp(unit))
+	measurementName := ""
+	if t.name == "" {
+		measurementName = add(parquetMeasurementColumn, parquet.String())
+	}
+	fieldNames := make(map[string]string, len(t.types))
+	for _, field := range sortedKeys(t.types) {
+		fieldNames[field] = add(field, parquet.Optional(parquetNode(t.types[field])))
+	}
+	tagNames := make(map[string]string, len(t.tags))
+	for _, tag := range sortedKeys(t.tags) {
+		tagNames[tag] = add(tag, parquet.Optional(parquet.String()))
+	}
+
+	index := make(map[string]int, len(t.group))
+	for i, name := range sortedKeys(t.group) {
+		index[name] = i
+	}
+	t.timeCol, t.measurementCol = index[timeName], -1
+	if measurementName != "" {
+		t.measurementCol = index[measurementName]
+	}
+	for field, name := range fieldNames {
+		t.fields[field] = index[name]
+	}
+	for tag, name := range tagNames {
+		t.tags[tag] = index[name]
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func parquetNode(typ influxql.DataType) parquet.Node {
+	switch typ {
+	case influxql.Float:
+		return parquet.Leaf(parquet.DoubleType)
+	case influxql.Integer:
+		return parquet.Int(64)
+	case influxql.Unsigned:
+		return parquet.Uint(64)
+	case influxql.Boolean:
+		return parquet.Leaf(parquet.BooleanType)
+	default:
+		return parquet.String()
+	}
+}
+
+// open creates the file of the table written through limit, which is deferred until the first row so that no empty
+// file is created.
+func (t *parquetTable) open(limit func(w io.Writer) io.Writer) error {
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(t.path)
+	if err != nil {
+		return err
+	}
+	name := t.name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(t.path), ".parquet")
+	}
+	t.file = f
+	t.w = parquet.NewWriter(limit(f), parquet.NewSchema(name, t.group), parquet.Compression(&parquet.Snappy), parquet.MaxRowsPerRowGroup(parquetRowGroupSize))
+	return nil
+}
+
+func (t *parquetTable) close() error {
+	if t.w == nil {
+		return nil
+	}
+	err := t.w.Close()
+	if cerr := t.file.Close(); err == nil {
+		err = cerr
+	}
+	t.w, t.file = nil, nil
+	return err
+}
+
+// parquetWriter writes the values read from the shards into the parquet files of a retention policy. The fields
+// of a series are pivoted into rows by timestamp, they are read consecutively in a tsm file so that they are merged
+// into the same rows unless the series is repeated in several files.
+type parquetWriter struct {
+	cmd    *command
+	out    string // output directory
+	tee    bool   // written in addition to the output of another format, which counts the summary and progress
+	tables map[string]*parquetTable
+
+	key       []byte
+	field     []byte
+	table     *parquetTable
+	template  parquet.Row
+	points    []parquetPoint
+	rows      []parquet.Row
+	conflicts int // values skipped as conflicting with the type of their column
+}
+
+type parquetPoint struct {
+	ts    int64
+	value parquet.Value
+}
+
+func (cmd *command) newParquetWriter(out string, tee bool) *parquetWriter {
+	return &parquetWriter{cmd: cmd, out: out, tee: tee, tables: make(map[string]*parquetTable)}
+}
+
+// tablePath returns the path of the parquet file of the measurement, and the measurement of the file if it has one.
+func (pw *parquetWriter) tablePath(db, rp, name string) (string, string) {
+	if pw.cmd.parquetLayout == parquetLayoutRP {
+		return filepath.Join(pw.out, url.PathEscape(db), url.PathEscape(rp)+".parquet"), ""
+	}
+	return filepath.Join(pw.out, url.PathEscape(db), url.PathEscape(rp), url.PathEscape(name)+".parquet"), name
+}
+
+// readSchema reads the series of the shards to infer the schemas of the tables, without reading the values.
+func (pw *parquetWriter) readSchema(shards []*source.Shard) error {
+	for _, sh := range shards {
+		err := pw.cmd.src.ReadSeries(sh, func(seriesKey, field []byte, typ influxql.DataType) error {
+			name, tags := models.ParseKeyBytes(seriesKey)
+			if !pw.cmd.matchSeriesSet(seriesKey) || !pw.cmd.matchMeasurement(string(name)) || !pw.cmd.matchTags(tags) || !pw.cmd.matchField(field) {
+				return nil
+			}
+			path, m := pw.tablePath(sh.Database, sh.RetentionPolicy, string(name))
+			t := pw.tables[path]
+			if t == nil {
+				t = newParquetTable(path, m)
+				pw.tables[path] = t
+			}
+			t.addSeries(tags, string(field), typ)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range pw.tables {
+		t.build(parquetUnits[pw.cmd.precision])
+	}
+	return nil
+}
+
+// writeSeries returns the function writing the values of a series read from the shard into its table.
+func (pw *parquetWriter) writeSeries(sh *source.Shard) func(seriesKey, field []byte, values []tsm1.Value) error {
+	return func(seriesKey, field []byte, values []tsm1.Value) error {
+		if !bytes.Equal(seriesKey, pw.key) {
+			if err := pw.flush(); err != nil {
+				return err
+			}
+			pw.key = append(pw.key[:0], seriesKey...)
+			pw.field = pw.field[:0]
+			if err := pw.startSeries(sh, seriesKey); err != nil {
+				return err
+			}
+		}
+		if pw.table == nil || !pw.cmd.matchField(field) {
+			return nil
+		}
+		if !bytes.Equal(field, pw.field) {
+			pw.field = append(pw.field[:0], field...)
+			if !pw.tee {
+				pw.cmd.addSeries(pw.key, field)
+			}
+		}
+		if !pw.tee {
+			pw.cmd.addHistogram(pw.key, values)
+		}
+		// a field unknown to the schema was written after the series were read
+		col, ok := pw.table.fields[string(field)]
+		if !ok {
+			pw.conflicts += len(values)
+			return nil
+		}
+		typ := pw.table.types[string(field)]
+		n := len(pw.points)
+		for _, v := range values {
+			if source.ValueType(v) != typ {
+				pw.conflicts++
+				continue
+			}
+			pw.points = append(pw.points, parquetPoint{ts: v.UnixNano(), value: parquetValue(v).Level(0, 1, col)})
+		}
+		if !pw.tee {
+			pw.cmd.addWritten(len(pw.points)-n, 0)
+		}
+		return nil
+	}
+}
+
+// startSeries sets the table of the series and the template of its rows, the table is nil if the series is skipped.
+func (pw *parquetWriter) startSeries(sh *source.Shard, seriesKey []byte) error {
+	pw.table = nil
+	name, tags := models.ParseKeyBytes(seriesKey)
+	if !pw.cmd.matchSeriesSet(seriesKey) || !pw.cmd.matchMeasurement(string(name)) || !pw.cmd.matchTags(tags) {
+		return nil
+	}
+	path, _ := pw.tablePath(sh.Database, sh.RetentionPolicy, string(name))
+	t := pw.tables[path]
+	if t == nil {
+		return nil
+	}
+	if t.w == nil {
+		if err := t.open(pw.cmd.limitWriter); err != nil {
+			return err
+		}
+	}
+	pw.table = t
+	pw.template = make(parquet.Row, len(t.group))
+	for i := range pw.template {
+		pw.template[i] = parquet.NullValue().Level(0, 0, i)
+	}
+	if t.measurementCol >= 0 {
+		pw.template[t.measurementCol] = parquet.ByteArrayValue([]byte(string(name))).Level(0, 0, t.measurementCol)
+	}
+	for _, tag := range tags {
+		if col, ok := t.tags[string(tag.Key)]; ok {
+			pw.template[col] = parquet.ByteArrayValue([]byte(string(tag.Value))).Level(0, 1, col)
+		}
+	}
+	return nil
+}
+
+// flush writes the points of the current series as rows by timestamp, a later value of the same field and timestamp
+// overwrites the earlier one as influxdb does.
+func (pw *parquetWriter) flush() error {
+	if len(pw.points) == 0 {
+		return nil
+	}
+	sort.SliceStable(pw.points, func(i, j int) bool { return pw.points[i].ts < pw.points[j].ts })
+	t := pw.table
+	pw.rows = pw.rows[:0]
+	for i, p := range pw.points {
+		if i == 0 || p.ts != pw.points[i-1].ts {
+			row := append(parquet.Row(nil), pw.template...)
+			ts := p.ts
+			if pw.cmd.precDiv > 1 {
+				ts = floorDiv(ts, pw.cmd.precDiv)
+			}
+			row[t.timeCol] = parquet.Int64Value(ts).Level(0, 0, t.timeCol)
+			pw.rows = append(pw.rows, row)
+		}
+		pw.rows[len(pw.rows)-1][p.value.Column()] = p.value
+	}
+	pw.points = pw.points[:0]
+	_, err := t.w.WriteRows(pw.rows)
+	return err
+}
+
+// close flushes the current series and closes the files of the tables.
+func (pw *parquetWriter) close() error {
+	err := pw.flush()
+	for _, t := range pw.tables {
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func parquetValue(v tsm1.Value) parquet.Value {
+	switch tv := v.(type) {
+	case tsm1.FloatValue:
+		return parquet.DoubleValue(tv.Value().(float64))
+	case tsm1.IntegerValue:
+		return parquet.Int64Value(tv.Value().(int64))
+	case tsm1.UnsignedValue:
+		return parquet.Int64Value(int64(tv.Value().(uint64)))
+	case tsm1.BooleanValue:
+		return parquet.BooleanValue(tv.Value().(bool))
+	default:
+		return parquet.ByteArrayValue([]byte(fmt.Sprint(v.Value())))
+	}
+}
+
+// writeParquet writes the values of the shards into parquet files under the output directory, a file per measurement
+// or per retention policy. The schemas are inferred from the series read first, so that the values are read once.
+func (cmd *command) writeParquet() error {
+	msgOut := cmd.msgOut()
+	conflicts := 0
+	for _, key := range cmd.manifest() {
+		cmd.startKey(key)
+		fmt.Fprintf(msgOut, "writing out %s data for %s%s into parquet...", cmd.kind, filepath.Join(key.db, key.rp), cmd.withMeasurement())
+		pw := cmd.newParquetWriter(cmd.out, false)
+		err := pw.readSchema(key.shards)
+		for _, sh := range key.shards {
+			if err != nil {
+				break
+			}
+			err = cmd.readSource(sh, pw.writeSeries(sh))
+			cmd.shardRead(sh)
+		}
+		if cerr := pw.close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		conflicts += pw.conflicts
+		fmt.Fprintf(msgOut, "complete%s.\n", cmd.keyDone())
+	}
+	if conflicts > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d values conflicting with the field types of parquet columns\n", conflicts)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestWriteParquet(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	shardDir := filepath.Join(dataDir, "db", "autogen", "1")
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage":         {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2, 2.5)},
+		"cpu,host=a#!~#cores":         {tsm1.NewIntegerValue(2, 4)},
+		"cpu,host=b,host_1=x#!~#ok":   {tsm1.NewBooleanValue(3, true)},
+		"cpu,host=b,host_1=x#!~#host": {tsm1.NewStringValue(3, "b")},
+		"mem#!~#free":                 {tsm1.NewUnsignedValue(1, 1<<40)},
+	})
+
+	cmd := newTestCommand()
+	cmd.format, cmd.precision, cmd.parquetLayout, cmd.out = formatParquet, precisionNs, parquetLayoutMeasurement, filepath.Join(dir, "out")
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	if err = cmd.writeParquet(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readParquet(t, filepath.Join(cmd.out, "db", "autogen", "cpu.parquet"))
+	exp := []string{
+		"[cores host host_1 host_1_1 ok time usage]",
+		"[<null> <null> a <null> <null> 1 1.5]",
+		"[4 <null> a <null> <null> 2 2.5]",
+		"[<null> b b x true 3 <null>]",
+	}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected cpu rows:\n%v", got)
+	}
+	got = readParquet(t, filepath.Join(cmd.out, "db", "autogen", "mem.parquet"))
+	if exp = []string{"[free time]", "[1099511627776 1]"}; !cmp.Equal(got, exp) {
+		t.Errorf("unexpected mem rows:\n%v", got)
+	}
+}
+
+// readParquet returns the column names and the rows of the parquet file.
+func readParquet(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(f, st.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, field := range pf.Schema().Fields() {
+		names = append(names, field.Name())
+	}
+	lines := []string{fmt.Sprint(names)}
+	rows := make([]parquet.Row, pf.NumRows())
+	n, err := parquet.NewReader(pf).ReadRows(rows)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	for _, row := range rows[:n] {
+		lines = append(lines, fmt.Sprint(row))
+	}
+	return lines
+}
@@ -0,0 +1,127 @@
+package exporter
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	pipelineChunkSize = 1024 * 1024
+	pipelineDepth     = 8
+)
+
+// pipeWriter decouples encoding from compressing and writing. The bytes written are gathered into chunks,
+// which are sent through a bounded channel to a goroutine writing them to the underlying writer in order,
+// so that reading and encoding tsm files isn't stalled by the compression, and vice versa.
+//
+// An error of the underlying writer is returned by the following Write or Close, and the chunks left are dropped.
+type pipeWriter struct {
+	w       io.Writer
+	below   []flusher // writers under w flushed in order by Flush, such as the compressor and the file buffer
+	chunk   []byte
+	chunks  chan []byte
+	free    chan []byte
+	flushed chan struct{}
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newPipeWriter(w io.Writer, chunkSize, depth int) *pipeWriter {
+	p := &pipeWriter{
+		w:       w,
+		chunks:  make(chan []byte, depth),
+		free:    make(chan []byte, depth+1),
+		flushed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	// one more chunk is filled while the channel is full
+	for i := 0; i < depth+1; i++ {
+		p.free <- make([]byte, 0, chunkSize)
+	}
+	go p.run()
+	return p
+}
+
+func (p *pipeWriter) run() {
+	defer close(p.done)
+	for chunk := range p.chunks {
+		// a nil chunk marks all the chunks before written
+		if chunk == nil {
+			p.flushed <- struct{}{}
+			continue
+		}
+		if p.Err() == nil {
+			if _, err := p.w.Write(chunk); err != nil {
+				p.mu.Lock()
+				p.err = err
+				p.mu.Unlock()
+			}
+		}
+		p.free <- chunk[:0]
+	}
+}
+
+func (p *pipeWriter) Write(b []byte) (int, error) {
+	if err := p.Err(); err != nil {
+		return 0, err
+	}
+	n := len(b)
+	for len(b) > 0 {
+		if p.chunk == nil {
+			p.chunk = <-p.free
+		}
+		m := copy(p.chunk[len(p.chunk):cap(p.chunk)], b)
+		p.chunk = p.chunk[:len(p.chunk)+m]
+		b = b[m:]
+		if len(p.chunk) == cap(p.chunk) {
+			p.chunks <- p.chunk
+			p.chunk = nil
+		}
+	}
+	return n, nil
+}
+
+// flusher is implemented by the writers buffering the bytes written, such as bufio.Writer and the compressors.
+type flusher interface {
+	Flush() error
+}
+
+// Flush sends the chunk being filled and waits for all the chunks written, then flushes the writers below, so that
+// the bytes written so far reach the file.
+func (p *pipeWriter) Flush() error {
+	if len(p.chunk) > 0 {
+		p.chunks <- p.chunk
+		p.chunk = nil
+	}
+	p.chunks <- nil
+	<-p.flushed
+	if err := p.Err(); err != nil {
+		return err
+	}
+	for _, f := range p.below {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close sends the last chunk and waits for all the chunks written, it must be called once.
+func (p *pipeWriter) Close() error {
+	if len(p.chunk) > 0 {
+		p.chunks <- p.chunk
+		p.chunk = nil
+	}
+	close(p.chunks)
+	<-p.done
+	return p.Err()
+}
+
+// Err returns the error of the underlying writer.
+func (p *pipeWriter) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
@@ -0,0 +1,133 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/history"
+	"github.com/chengshiwen/influx-tool/internal/source"
+)
+
+// progress is the progress of the export, updated by the writers and reported periodically by another goroutine.
+type progress struct {
+	keys    int
+	shards  int
+	key     atomic.Pointer[manifestKey] // database and retention policy being read
+	keyIdx  atomic.Int64                // index of the key, from 1
+	read    atomic.Int64                // shards read
+	done    atomic.Int64                // size of the shards read
+	points  atomic.Int64                // points written
+	written atomic.Int64                // bytes written before compression
+}
+
+// startKey marks the key started to read, and starts its summary.
+func (cmd *command) startKey(key *manifestKey) {
+	cmd.progress.key.Store(key)
+	cmd.progress.keyIdx.Add(1)
+	cmd.startSummary(key)
+}
+
+// shardRead marks the shard read.
+func (cmd *command) shardRead(sh *source.Shard) {
+	cmd.progress.read.Add(1)
+	cmd.progress.done.Add(sh.Size())
+}
+
+// addWritten adds the points and bytes written.
+func (cmd *command) addWritten(points, bytes int) {
+	cmd.progress.points.Add(int64(points))
+	cmd.progress.written.Add(int64(bytes))
+}
+
+// keyDone returns the progress once a key is done.
+func (cmd *command) keyDone() string {
+	st := &cmd.stats
+	if st.est == nil || st.total <= 0 {
+		return ""
+	}
+	return ", " + st.est.Progress(cmd.progress.done.Load(), st.total, time.Since(st.start))
+}
+
+// report returns the progress of files, points and bytes, with the eta if the size is known.
+func (cmd *command) report() string {
+	p := &cmd.progress
+	var buf bytes.Buffer
+	buf.WriteString("status: ")
+	if key := p.key.Load(); key != nil {
+		fmt.Fprintf(&buf, "%s (%d/%d), ", filepath.Join(key.db, key.rp), p.keyIdx.Load(), p.keys)
+	}
+	fmt.Fprintf(&buf, "shards %d/%d", p.read.Load(), p.shards)
+	if points := p.points.Load(); points > 0 {
+		fmt.Fprintf(&buf, ", %d points", points)
+	}
+	if written := p.written.Load(); written > 0 {
+		fmt.Fprintf(&buf, ", %s written", history.FormatBytes(written))
+	}
+	if st := &cmd.stats; st.est != nil && st.total > 0 {
+		fmt.Fprintf(&buf, ", %s", st.est.Progress(p.done.Load(), st.total, time.Since(st.start)))
+	}
+	return buf.String()
+}
+
+// startProgress reports the progress to the messages every progress interval until the returned function is
+// called, nothing is reported if quiet or the interval is 0.
+func (cmd *command) startProgress() func() {
+	cmd.progress.keys, cmd.progress.shards = len(cmd.manifest()), len(cmd.shards)
+	if cmd.quiet || cmd.progressInterval <= 0 {
+		return func() {}
+	}
+	mw := cmd.msgOut().(*messageWriter)
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cmd.progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				mw.writeLine(cmd.report())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// messageWriter writes the messages, which are written in parts like "writing out...complete.", so that a progress
+// report in the middle of a message is written on its own line.
+type messageWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	midLine bool
+}
+
+func (mw *messageWriter) Write(b []byte) (int, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if len(b) > 0 {
+		mw.midLine = b[len(b)-1] != '\n'
+	}
+	return mw.w.Write(b)
+}
+
+// writeLine writes the line, after a newline if a message is in the middle.
+func (mw *messageWriter) writeLine(line string) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if mw.midLine {
+		line = "\n" + line
+		mw.midLine = false
+	}
+	fmt.Fprintln(mw.w, line)
+}
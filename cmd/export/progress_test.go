@@ -0,0 +1,46 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/history"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestProgress(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.shards = []*source.Shard{{ID: 1, Database: "db", RetentionPolicy: "rp0"}, {ID: 2, Database: "db", RetentionPolicy: "rp1"}}
+	var buf bytes.Buffer
+	cmd.msgs = &messageWriter{w: &buf}
+	stop := cmd.startProgress()
+	stop()
+
+	keys := cmd.manifest()
+	cmd.startKey(keys[0])
+	if err := cmd.writeValues(&bytes.Buffer{}, []byte("cpu value="), []tsm1.Value{tsm1.NewFloatValue(0, 1), tsm1.NewFloatValue(1, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	cmd.shardRead(keys[0].shards[0])
+	if exp := "status: db/rp0 (1/2), shards 1/2, 2 points, 28 B written"; cmd.report() != exp {
+		t.Errorf("got %q, expect %q", cmd.report(), exp)
+	}
+
+	cmd.stats.est, cmd.stats.total, cmd.stats.start = history.NewEstimator(nil), 100, time.Now()
+	cmd.progress.done.Store(50)
+	if report := cmd.report(); !bytes.Contains([]byte(report), []byte(", progress 50.0%")) {
+		t.Errorf("unexpected report: %s", report)
+	}
+
+	// a report in the middle of a message is written on its own line
+	fmt.Fprint(cmd.msgOut(), "writing out...")
+	cmd.msgs.writeLine("progress report")
+	fmt.Fprint(cmd.msgOut(), "complete.\n")
+	cmd.msgs.writeLine("progress report")
+	if exp := "writing out...\nprogress report\ncomplete.\nprogress report\n"; buf.String() != exp {
+		t.Errorf("got %q", buf.String())
+	}
+}
@@ -0,0 +1,164 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+)
+
+const (
+	shardChunkSize  = 256 * 1024
+	shardChunkDepth = 4
+)
+
+var errReadAborted = errors.New("read aborted")
+
+// chunk is the lines of a shard gathered from the writes of its reader, which are ended at ends.
+type chunk struct {
+	buf  []byte
+	ends []int
+}
+
+// chunkPool pools the chunks of lines read from shards.
+var chunkPool = sync.Pool{
+	New: func() interface{} {
+		return &chunk{buf: make([]byte, 0, shardChunkSize)}
+	},
+}
+
+// writeTo writes the chunk to w by the writes gathered, so that w sees the same writes as written by the reader,
+// such as the rotated writer rotating between them.
+func (c *chunk) writeTo(w io.Writer) error {
+	start := 0
+	for _, end := range c.ends {
+		if _, err := w.Write(c.buf[start:end]); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// shardBuffer gathers the lines of a shard written by its reader into chunks, which are sent through a bounded
+// channel to be written out in shard order. The chunks buffered ahead of the writing are bounded by the depth of
+// the channel.
+type shardBuffer struct {
+	chunk  *chunk
+	chunks chan *chunk
+	done   <-chan struct{}
+	err    error // error of reading the shard, set before chunks are closed
+}
+
+func newShardBuffer(done <-chan struct{}) *shardBuffer {
+	return &shardBuffer{chunks: make(chan *chunk, shardChunkDepth), done: done}
+}
+
+func (b *shardBuffer) Write(p []byte) (int, error) {
+	if b.chunk == nil {
+		b.chunk = chunkPool.Get().(*chunk)
+	}
+	b.chunk.buf = append(b.chunk.buf, p...)
+	b.chunk.ends = append(b.chunk.ends, len(b.chunk.buf))
+	if len(b.chunk.buf) >= shardChunkSize {
+		if err := b.send(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// send sends the current chunk, or returns errReadAborted once the writing is aborted.
+func (b *shardBuffer) send() error {
+	select {
+	case b.chunks <- b.chunk:
+		b.chunk = nil
+		return nil
+	case <-b.done:
+		return errReadAborted
+	}
+}
+
+// close sends the last chunk and closes the channel with the error of reading the shard.
+func (b *shardBuffer) close(err error) {
+	if err == nil && b.chunk != nil && len(b.chunk.buf) > 0 {
+		err = b.send()
+	}
+	b.err = err
+	close(b.chunks)
+}
+
+// readSerially reduces the read workers to 1 for the reason, which is noted if more read workers were given.
+func (cmd *command) readSerially(reason string) {
+	if cmd.readWorkers > 1 && cmd.cobraCmd != nil && cmd.cobraCmd.Flags().Changed("read-workers") {
+		fmt.Fprintf(os.Stderr, "read workers reduced from %d to 1 for %s\n", cmd.readWorkers, reason)
+	}
+	cmd.readWorkers = 1
+}
+
+// readShards reads the values of the shards with up to the read workers in parallel, each shard into its own
+// buffer, and writes the lines to w in shard order, so that the output is the same as reading the shards one by one.
+// The shards are read one by one for the sources other than datadir. The parquet files of the shards are written
+// as well if parquet out given.
+func (cmd *command) readShards(shards []*source.Shard, w io.Writer) error {
+	if cmd.parquetOut != "" && cmd.tee == nil {
+		return cmd.readShardsTee(shards, w)
+	}
+	workers := 1
+	if _, ok := cmd.src.(*source.FileSource); ok && cmd.readWorkers > 1 {
+		workers = cmd.readWorkers
+	}
+	// a prefix cache is used by a reader at a time, so that the prefixes are reused across the shards
+	caches := make(chan *prefixCache, workers)
+	caches <- cmd.prefixes
+	for i := 1; i < workers; i++ {
+		c := newPrefixCache(maxCachedPrefixes)
+		c.empty, c.tags, c.names = cmd.prefixes.empty, cmd.prefixes.tags, cmd.prefixes.names
+		caches <- c
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	bufs := make([]*shardBuffer, len(shards))
+	for i := range bufs {
+		bufs[i] = newShardBuffer(done)
+	}
+	// the readers are started in shard order, so that the shard written out next is always being read or done
+	go func() {
+		for i, sh := range shards {
+			var c *prefixCache
+			select {
+			case c = <-caches:
+			case <-done:
+				return
+			}
+			go func(b *shardBuffer, sh *source.Shard) {
+				err := cmd.readValues(sh, b, c)
+				caches <- c
+				b.close(err)
+			}(bufs[i], sh)
+		}
+	}()
+
+	for i, b := range bufs {
+		for c := range b.chunks {
+			err := c.writeTo(w)
+			c.buf, c.ends = c.buf[:0], c.ends[:0]
+			chunkPool.Put(c)
+			if err != nil {
+				return err
+			}
+		}
+		if b.err != nil {
+			return b.err
+		}
+		// a shard read in chunk windows is marked read by readWindows once its last window is done
+		if cmd.chunkInterval <= 0 {
+			cmd.shardRead(shards[i])
+		}
+	}
+	return nil
+}
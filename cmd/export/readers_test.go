@@ -0,0 +1,63 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+)
+
+func TestReadShards(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 6; id++ {
+		shardDir := filepath.Join(dataDir, "db", "autogen", fmt.Sprint(id))
+		if err := os.MkdirAll(shardDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), benchmarkSeries(500*id, 20))
+	}
+
+	read := func(workers int, w *bytes.Buffer) error {
+		cmd := newTestCommand()
+		cmd.readWorkers, cmd.startTime, cmd.endTime = workers, math.MinInt64, math.MaxInt64
+		cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+		shards, err := cmd.src.ListShards("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmd.readShards(shards, w)
+	}
+	var exp, got bytes.Buffer
+	if err := read(1, &exp); err != nil {
+		t.Fatal(err)
+	}
+	if exp.Len() < 4*shardChunkSize {
+		t.Fatalf("expect the output to span chunks, got %d bytes", exp.Len())
+	}
+	if err := read(4, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), exp.Bytes()) {
+		t.Fatalf("unexpected output of 4 workers: %d bytes, expect %d bytes", got.Len(), exp.Len())
+	}
+
+	cmd := newTestCommand()
+	cmd.readWorkers, cmd.startTime, cmd.endTime = 4, math.MinInt64, math.MaxInt64
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmd.readShards(shards, &failWriter{n: 10}); err == nil || err.Error() != "disk full" {
+		t.Fatalf("expected error of the writer, got %v", err)
+	}
+}
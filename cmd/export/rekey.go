@@ -0,0 +1,134 @@
+package exporter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/internal/empty"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// The types of the fields demoted from tags.
+const (
+	rekeyString   = "string"
+	rekeyFloat    = "float"
+	rekeyInteger  = "integer"
+	rekeyUnsigned = "unsigned"
+	rekeyBoolean  = "boolean"
+)
+
+// rekey promotes fields to tags and demotes tags to fields in the lines of the fields grouped, so that the schema
+// mistakes of the series can be fixed by the export instead of reprocessing the data exported.
+type rekey struct {
+	toTag   map[string]struct{} // fields promoted to tags
+	toField map[string]string   // type of the fields by the tags demoted to them
+}
+
+// demotedField is a tag of a series demoted to a field, whose value is converted once per series.
+type demotedField struct {
+	name   string
+	prefix []byte // escaped "<name>="
+	value  interface{}
+	ok     bool // whether the tag value is converted to the type
+}
+
+// parseRekey parses the fields promoted like name and the tags demoted like name or name:type, or returns nil if none.
+func parseRekey(fieldToTag, tagToField []string) (*rekey, error) {
+	if len(fieldToTag) == 0 && len(tagToField) == 0 {
+		return nil, nil
+	}
+	r := &rekey{toTag: make(map[string]struct{}), toField: make(map[string]string)}
+	for _, name := range fieldToTag {
+		if name == "" {
+			return nil, fmt.Errorf("field to tag is invalid: %s, require name", name)
+		}
+		r.toTag[name] = struct{}{}
+	}
+	for _, value := range tagToField {
+		name, typ, ok := strings.Cut(value, ":")
+		if !ok {
+			typ = rekeyString
+		}
+		if name == "" {
+			return nil, fmt.Errorf("tag to field is invalid: %s, require name or name:type", value)
+		}
+		switch typ {
+		case rekeyString, rekeyFloat, rekeyInteger, rekeyUnsigned, rekeyBoolean:
+		default:
+			return nil, fmt.Errorf("tag to field type is invalid: %s, require string, float, integer, unsigned or boolean", value)
+		}
+		if _, ok := r.toTag[name]; ok {
+			return nil, fmt.Errorf("tag to field is invalid: %s, %s is promoted to a tag by field to tag", value, name)
+		}
+		r.toField[name] = typ
+	}
+	return r, nil
+}
+
+// demote returns the name, the tags kept and the fields demoted of the escaped series key, the fields are in the
+// order of the tags.
+func (r *rekey) demote(seriesKey []byte) ([]byte, models.Tags, []demotedField) {
+	name, tags := empty.ParseKey(seriesKey)
+	if len(r.toField) == 0 {
+		return name, tags, nil
+	}
+	var kept models.Tags
+	var fields []demotedField
+	for _, t := range tags {
+		typ, ok := r.toField[string(t.Key)]
+		if !ok {
+			kept = append(kept, t)
+			continue
+		}
+		f := demotedField{name: string(t.Key), prefix: append(escape.Bytes(t.Key), '=')}
+		f.value, f.ok = convertTag(string(t.Value), typ)
+		fields = append(fields, f)
+	}
+	return name, kept, fields
+}
+
+// promoted returns whether the field is promoted to a tag.
+func (r *rekey) promoted(field string) bool {
+	_, ok := r.toTag[field]
+	return ok
+}
+
+// convertTag returns the tag value converted to the field type, or false if unable to be converted.
+func convertTag(s, typ string) (interface{}, bool) {
+	var v interface{}
+	var err error
+	switch typ {
+	case rekeyFloat:
+		v, err = strconv.ParseFloat(s, 64)
+	case rekeyInteger:
+		v, err = strconv.ParseInt(s, 10, 64)
+	case rekeyUnsigned:
+		v, err = strconv.ParseUint(s, 10, 64)
+	case rekeyBoolean:
+		v, err = strconv.ParseBool(s)
+	default:
+		v = s
+	}
+	return v, err == nil
+}
+
+// tagValue returns the value of a field promoted as a tag value, floats in the fewest digits to be exact and
+// booleans as true or false, or false if the value is an empty string, which is not tagged.
+func tagValue(v tsm1.Value) (string, bool) {
+	switch x := v.Value().(type) {
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case int64:
+		return strconv.FormatInt(x, 10), true
+	case uint64:
+		return strconv.FormatUint(x, 10), true
+	case bool:
+		return strconv.FormatBool(x), true
+	case string:
+		return x, x != ""
+	}
+	return "", false
+}
@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestParseRekey(t *testing.T) {
+	r, err := parseRekey([]string{"status"}, []string{"region", "rack:integer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.promoted("status") || r.promoted("region") {
+		t.Errorf("unexpected fields promoted: %v", r.toTag)
+	}
+	if r.toField["region"] != rekeyString || r.toField["rack"] != rekeyInteger {
+		t.Errorf("unexpected tags demoted: %v", r.toField)
+	}
+	if r, err = parseRekey(nil, nil); r != nil || err != nil {
+		t.Errorf("unexpected rekey of none: %v %v", r, err)
+	}
+	for _, tt := range []struct {
+		fieldToTag, tagToField []string
+	}{
+		{[]string{""}, nil},
+		{nil, []string{":float"}},
+		{nil, []string{"rack:int"}},
+		{[]string{"status"}, []string{"status"}},
+	} {
+		if _, err := parseRekey(tt.fieldToTag, tt.tagToField); err == nil {
+			t.Errorf("expected error of %v %v", tt.fieldToTag, tt.tagToField)
+		}
+	}
+}
+
+func TestFieldGroupRekey(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.groupFields = true
+	cmd.rekey, _ = parseRekey([]string{"status", "code"}, []string{"rack:integer", "region"})
+	var buf bytes.Buffer
+	g := cmd.newFieldGroup(&buf)
+	fn := cmd.handleSeries(cmd.prefixes, g.add)
+	series := []struct {
+		key, field string
+		values     []tsm1.Value
+	}{
+		{"cpu,host=a,rack=7,region=us\\ west", "code", []tsm1.Value{tsm1.NewFloatValue(1, 2.5)}},
+		{"cpu,host=a,rack=7,region=us\\ west", "status", []tsm1.Value{tsm1.NewStringValue(1, "ok"), tsm1.NewStringValue(2, "")}},
+		{"cpu,host=a,rack=7,region=us\\ west", "usage", []tsm1.Value{tsm1.NewFloatValue(1, 0.5), tsm1.NewFloatValue(2, 1.5), tsm1.NewFloatValue(3, 2.5)}},
+		// the field of the same name is kept, and the rack not an integer is skipped
+		{"mem,rack=x,region=eu", "region", []tsm1.Value{tsm1.NewIntegerValue(1, 1)}},
+	}
+	for _, s := range series {
+		if err := fn([]byte(s.key), []byte(s.field), s.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.flush(); err != nil {
+		t.Fatal(err)
+	}
+	exp := `cpu,code=2.5,host=a,status=ok usage=0.5,rack=7i,region="us west" 1
+cpu,host=a usage=1.5,rack=7i,region="us west" 2
+cpu,host=a usage=2.5,rack=7i,region="us west" 3
+mem region=1i 1
+`
+	if buf.String() != exp {
+		t.Errorf("unexpected output:\n%s\nexp:\n%s", buf.String(), exp)
+	}
+	if skipped := cmd.rekeySkipped.Load(); skipped != 1 {
+		t.Errorf("unexpected skipped: %d", skipped)
+	}
+}
@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// measurementRenamer renames the measurements of the series exported by the first rule matched, so that the legacy
+// naming schemes can be consolidated by the export instead of rewriting the lines exported.
+type measurementRenamer struct {
+	rules []renameRule
+}
+
+// renameRule renames the measurement of the old name, or replaces the matches of re if given.
+type renameRule struct {
+	old string
+	re  *regexp.Regexp
+	new string
+}
+
+// parseMeasurementRenamer parses the rules like old:new, or /regexp/:new replacing the matches of the regexp with
+// new expanded as regexp.ReplaceAll, such as /^legacy_(.*)/:$1, or returns nil if none. The last colon separates
+// the names of old:new.
+func parseMeasurementRenamer(values []string) (*measurementRenamer, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	r := &measurementRenamer{}
+	for _, value := range values {
+		var rule renameRule
+		if strings.HasPrefix(value, "/") {
+			i := strings.LastIndex(value, "/:")
+			if i <= 0 {
+				return nil, fmt.Errorf("rename measurement is invalid: %s, require old:new or /regexp/:new", value)
+			}
+			re, err := regexp.Compile(value[1:i])
+			if err != nil {
+				return nil, fmt.Errorf("rename measurement: %s, compile error: %v", value, err)
+			}
+			rule.re, rule.new = re, value[i+2:]
+		} else {
+			i := strings.LastIndexByte(value, ':')
+			if i <= 0 || i == len(value)-1 {
+				return nil, fmt.Errorf("rename measurement is invalid: %s, require old:new or /regexp/:new", value)
+			}
+			rule.old, rule.new = value[:i], value[i+1:]
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// rename returns the measurement renamed by the first rule matched, or name if none is matched or renamed to empty.
+func (r *measurementRenamer) rename(name []byte) []byte {
+	if r == nil {
+		return name
+	}
+	for _, rule := range r.rules {
+		if rule.re == nil {
+			if string(name) == rule.old {
+				return []byte(rule.new)
+			}
+			continue
+		}
+		if rule.re.Match(name) {
+			if renamed := rule.re.ReplaceAll(name, []byte(rule.new)); len(renamed) > 0 {
+				return renamed
+			}
+			return name
+		}
+	}
+	return name
+}
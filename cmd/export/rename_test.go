@@ -0,0 +1,52 @@
+package exporter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestMeasurementRenamer(t *testing.T) {
+	r, err := parseMeasurementRenamer([]string{"node:cpu:node_cpu", "/^legacy_(.*)/:$1", "/x/:", "mem:memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name, exp string
+	}{
+		{"node:cpu", "node_cpu"},
+		{"legacy_disk", "disk"},
+		{"mem", "memory"},
+		{"x", "x"}, // renamed to empty
+		{"net", "net"},
+	} {
+		if got := string(r.rename([]byte(tt.name))); got != tt.exp {
+			t.Errorf("unexpected rename of %s: %s, exp %s", tt.name, got, tt.exp)
+		}
+	}
+	if r, err = parseMeasurementRenamer(nil); r != nil || err != nil || string(r.rename([]byte("cpu"))) != "cpu" {
+		t.Errorf("unexpected renamer of none: %v %v", r, err)
+	}
+	for _, value := range []string{"cpu", ":cpu", "cpu:", "/cpu", "/(/:cpu"} {
+		if _, err = parseMeasurementRenamer([]string{value}); err == nil {
+			t.Errorf("expected error of %s", value)
+		}
+	}
+}
+
+func TestWriteSeriesRenameMeasurement(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.prefixes.names, _ = parseMeasurementRenamer([]string{"/^legacy_(.*)/:$1 new"})
+	cmd.prefixes.tags, _ = parseTagRewriter([]string{"node=n1"}, nil)
+	var buf bytes.Buffer
+	fn := cmd.writeSeries(&buf, cmd.prefixes)
+	for _, key := range []string{"legacy_cpu,host=a", "mem,host=a"} {
+		if err := fn([]byte(key), []byte("v"), []tsm1.Value{tsm1.NewValue(1, 1.0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := "cpu\\ new,host=a,node=n1 v=1 1\nmem,host=a,node=n1 v=1 1\n"; buf.String() != exp {
+		t.Fatalf("unexpected lines: %q, exp %q", buf.String(), exp)
+	}
+}
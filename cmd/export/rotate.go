@@ -0,0 +1,184 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// rotateWriter writes the output into parts named <out>.000, <out>.001, ..., and switches to the next part once
+// the current one exceeds max-file-size, or a chunk window is done by chunk rotate. The DDL and the context of the lines following are repeated at the head
+// of each part but the first, which is written by writeFull, so that every part can be imported independently.
+//
+// A part is only switched before the lines of points, which must be written at once, and never within the DDL
+// or the comments, so that the context repeated is that of the lines following. The size of a compressed
+// part is counted as written into the file, which lags behind the lines being compressed, so a compressed part
+// may exceed max-file-size by the lines still in the pipeline.
+type rotateWriter struct {
+	cmd     *command
+	index   int
+	file    *os.File
+	counter *countWriter
+	bw      *bufio.Writer
+	cw      io.WriteCloser
+	pw      *pipeWriter
+	size    int64 // bytes written to the part before compression
+	points  bool  // whether any line of points is written to the part
+	window  bool  // whether a chunk window is done, after which the part is rotated by chunk rotate
+}
+
+// countWriter counts the bytes written to w, which is read by another goroutine.
+type countWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (cmd *command) newRotateWriter() (*rotateWriter, error) {
+	rw := &rotateWriter{cmd: cmd}
+	if err := rw.open(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+func (rw *rotateWriter) partPath() string {
+	return fmt.Sprintf("%s.%03d", rw.cmd.out, rw.index)
+}
+
+func (rw *rotateWriter) open() error {
+	f, err := os.Create(rw.partPath())
+	if err != nil {
+		return err
+	}
+	rw.file, rw.size, rw.points, rw.window = f, 0, false, false
+	rw.counter = &countWriter{w: rw.cmd.limitWriter(f)}
+	rw.bw = bufio.NewWriterSize(rw.counter, 1024*1024)
+	var w io.Writer = rw.bw
+	if rw.cmd.compress {
+		if rw.cw, err = rw.cmd.newCompressWriter(rw.bw); err != nil {
+			return err
+		}
+		w = rw.cw
+	}
+	rw.pw = newPipeWriter(rw.cmd.manifestWriter(w, rw.partPath()), pipelineChunkSize, pipelineDepth)
+	rw.pw.below = []flusher{rw.bw}
+	if f, ok := rw.cw.(flusher); ok {
+		rw.pw.below = []flusher{f, rw.bw}
+	}
+	return nil
+}
+
+// partSize returns the size of the part counted against max-file-size.
+func (rw *rotateWriter) partSize() int64 {
+	if rw.cw != nil {
+		return rw.counter.n.Load()
+	}
+	return rw.size
+}
+
+// rotate closes the part and opens the next one with the header.
+func (rw *rotateWriter) rotate() error {
+	if err := rw.Close(); err != nil {
+		return err
+	}
+	rw.index++
+	if err := rw.open(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	rw.cmd.writePartHeader(&buf)
+	n, err := rw.pw.Write(buf.Bytes())
+	rw.size += int64(n)
+	return err
+}
+
+func (rw *rotateWriter) Write(b []byte) (int, error) {
+	// a part is never rotated before a line of points is written to it, even if its header exceeds max-file-size
+	if rw.cmd.context != nil && len(b) > 0 && b[0] != '#' {
+		if rw.points && (rw.window || rw.cmd.maxFileSize > 0 && rw.partSize() >= rw.cmd.maxFileSize) {
+			if err := rw.rotate(); err != nil {
+				return 0, err
+			}
+		}
+		rw.points = true
+	}
+	n, err := rw.pw.Write(b)
+	rw.size += int64(n)
+	return n, err
+}
+
+// Flush flushes the lines written to the part, or marks the part to be rotated before the next line of points by
+// chunk rotate, once a chunk window is done.
+func (rw *rotateWriter) Flush() error {
+	if rw.cmd.chunkRotate {
+		rw.window = true
+		return nil
+	}
+	return rw.pw.Flush()
+}
+
+// Close closes the part open, if any.
+func (rw *rotateWriter) Close() error {
+	if rw.file == nil {
+		return nil
+	}
+	err := rw.pw.Close()
+	if rw.cw != nil {
+		if werr := rw.cw.Close(); err == nil {
+			err = werr
+		}
+	}
+	if ferr := rw.bw.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := rw.file.Close(); err == nil {
+		err = cerr
+	}
+	rw.file, rw.counter, rw.bw, rw.cw, rw.pw = nil, nil, nil, nil, nil
+	return err
+}
+
+// writePartHeader writes the header of a rotated part, which repeats the DDL and the context of the lines following.
+func (cmd *command) writePartHeader(w io.Writer) {
+	if cmd.lponly {
+		return
+	}
+	s, e := time.Unix(0, cmd.startTime).Format(time.RFC3339), time.Unix(0, cmd.endTime).Format(time.RFC3339)
+	fmt.Fprintf(w, "# INFLUXDB EXPORT: %s - %s\n", s, e)
+	cmd.writeDDL(w, w)
+	fmt.Fprintln(w, "# DML")
+	if cmd.context != nil {
+		cmd.writeContext(w, cmd.context.db, cmd.context.rp)
+	}
+}
+
+// writeRotated writes the full DML and DDL into parts of max-file-size or chunk windows.
+func (cmd *command) writeRotated() (err error) {
+	rw, err := cmd.newRotateWriter()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := rw.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			fmt.Fprintf(cmd.msgOut(), "last part written: %s\n", rw.partPath())
+		}
+	}()
+	var mw io.Writer = rw
+	if cmd.lponly {
+		mw = io.Discard
+	}
+	return cmd.writeFull(mw, rw)
+}
@@ -0,0 +1,70 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestWriteRotated(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	for i, rp := range []string{"rp1", "rp2"} {
+		shardDir := filepath.Join(dataDir, "db", rp, "1")
+		if err := os.MkdirAll(shardDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		ts := int64(i + 2)
+		writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#usage": {tsm1.NewFloatValue(ts, 1.5)},
+			"cpu,host=b#!~#usage": {tsm1.NewFloatValue(ts, 2.5)},
+		})
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := newTestCommand()
+	cmd.precision, cmd.out, cmd.maxFileSize = precisionNs, filepath.Join(dir, "export"), 1
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	cmd.kind = "tsm and wal file"
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	if err = cmd.writeRotated(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a part is rotated before the lines of each series, the first line is the time range of the export in local time
+	ddl := "# DDL\nCREATE DATABASE db WITH NAME autogen\nCREATE RETENTION POLICY rp1 ON db DURATION 0s REPLICATION 1\nCREATE RETENTION POLICY rp2 ON db DURATION 0s REPLICATION 1\n# DML\n"
+	rp1 := "# CONTEXT-DATABASE:db\n# CONTEXT-RETENTION-POLICY:rp1\n"
+	rp2 := "# CONTEXT-DATABASE:db\n# CONTEXT-RETENTION-POLICY:rp2\n"
+	parts := []string{
+		ddl + rp1 + "# writing tsm and wal file data\ncpu,host=a usage=1.5 2\n",
+		ddl + rp1 + "cpu,host=b usage=2.5 2\n" + rp2 + "# writing tsm and wal file data\n",
+		ddl + rp2 + "cpu,host=a usage=1.5 3\n",
+		ddl + rp2 + "cpu,host=b usage=2.5 3\n",
+	}
+	if _, err = os.Stat(filepath.Join(dir, "export.004")); !os.IsNotExist(err) {
+		t.Errorf("unexpected part 4: %v", err)
+	}
+	for i, exp := range parts {
+		b, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("export.%03d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, got, _ := strings.Cut(string(b), "\n"); got != exp {
+			t.Errorf("unexpected part %d:\n%s", i, got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/chengshiwen/influx-tool/internal/agent"
+)
+
+// exportHandler streams the shards listed as the arrow record batches to the clients pulling the export, one at a time.
+type exportHandler struct {
+	cmd *command
+	mu  sync.Mutex
+}
+
+// serveExport serves the shards listed to the clients pulling them until interrupted.
+func (cmd *command) serveExport() error {
+	srv, err := agent.NewExportServer(&exportHandler{cmd: cmd}, cmd.serveConfig)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", cmd.serve)
+	if err != nil {
+		return err
+	}
+
+	log.SetFlags(log.LstdFlags)
+	if cmd.serveConfig.Token == "" {
+		log.Print("warning: no serve token specified, pull requests are not authenticated")
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Print("export stopping, waiting for pulls in progress")
+		srv.Stop()
+	}()
+	log.Printf("export serving %s on %s", cmd.kind, ln.Addr())
+	return srv.Serve(ln)
+}
+
+// Export implements agent.ExportHandler, each message is an arrow ipc stream of a record batch.
+func (h *exportHandler) Export(ctx context.Context, send func(msg []byte) error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cmd := h.cmd
+	// the summary and progress are of the pull
+	cmd.summary = summary{}
+	cmd.progress = progress{keys: len(cmd.manifest()), shards: len(cmd.shards)}
+
+	msgOut := cmd.msgOut()
+	fw := &frameWriter{send: func(msg []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return send(msg)
+	}}
+	for _, key := range cmd.manifest() {
+		cmd.context = key
+		cmd.startKey(key)
+		fmt.Fprintf(msgOut, "streaming %s data for %s%s...", cmd.kind, filepath.Join(key.db, key.rp), cmd.withMeasurement())
+		if err := cmd.readShards(key.shards, fw); err != nil {
+			fmt.Fprintf(msgOut, "failed: %v\n", err)
+			return err
+		}
+		fmt.Fprintln(msgOut, "complete.")
+	}
+	return cmd.writeSummary(msgOut)
+}
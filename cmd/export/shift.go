@@ -0,0 +1,29 @@
+package exporter
+
+import (
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// shiftValues appends the values with the timestamps offset by shift to dst, so that the historical data can be
+// moved into the present time window, the values shifted out of the time range of influxdb are skipped.
+func (cmd *command) shiftValues(dst, values []tsm1.Value) []tsm1.Value {
+	for _, v := range values {
+		t, ok := shiftTime(v.UnixNano(), cmd.shift)
+		if !ok {
+			cmd.shiftSkipped.Add(1)
+			continue
+		}
+		dst = append(dst, tsm1.NewValue(t, v.Value()))
+	}
+	return dst
+}
+
+// shiftTime returns t offset by shift, or false if out of the time range of influxdb.
+func shiftTime(t, shift int64) (int64, bool) {
+	if (shift > 0 && t > models.MaxNanoTime-shift) || (shift < 0 && t < models.MinNanoTime-shift) {
+		return 0, false
+	}
+	t += shift
+	return t, t >= models.MinNanoTime && t <= models.MaxNanoTime
+}
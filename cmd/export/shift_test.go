@@ -0,0 +1,50 @@
+package exporter
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestShiftTime(t *testing.T) {
+	for _, tt := range []struct {
+		t, shift, exp int64
+		ok            bool
+	}{
+		{1, 10, 11, true},
+		{1, -10, -9, true},
+		{models.MaxNanoTime, 1, 0, false},
+		{models.MinNanoTime, -1, 0, false},
+		{math.MaxInt64 - 1, 10, 0, false},
+		{math.MinInt64 + 1, -10, 0, false},
+	} {
+		if got, ok := shiftTime(tt.t, tt.shift); got != tt.exp || ok != tt.ok {
+			t.Errorf("unexpected shift of %d by %d: %d %v, exp %d %v", tt.t, tt.shift, got, ok, tt.exp, tt.ok)
+		}
+	}
+}
+
+func TestWriteSeriesShift(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.shift = int64(time.Hour)
+	var buf bytes.Buffer
+	fn := cmd.writeSeries(&buf, cmd.prefixes)
+	values := []tsm1.Value{tsm1.NewValue(1, 1.0), tsm1.NewValue(models.MaxNanoTime, 2.0)}
+	if err := fn([]byte("cpu"), []byte("v"), values); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "cpu v=1 3600000000001\n"; buf.String() != exp {
+		t.Fatalf("unexpected lines: %q, exp %q", buf.String(), exp)
+	}
+	if skipped := cmd.shiftSkipped.Load(); skipped != 1 {
+		t.Fatalf("unexpected skipped: %d", skipped)
+	}
+	// the values of source are not modified
+	if values[0].UnixNano() != 1 {
+		t.Fatalf("source values shifted: %d", values[0].UnixNano())
+	}
+}
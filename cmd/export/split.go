@@ -0,0 +1,181 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+const splitByMeasurement = "measurement"
+
+// splitWriter writes the lines of each measurement into its own file under the output directory, named
+// <db>/<rp>/<measurement>.lp, with the extension of the compression appended if compressed. A file is reopened to
+// append once its measurement is read again, e.g. from the next shard, so that only one file is open at a time.
+// The compressed files are appended with a stream of the compression each time, which is read as a whole.
+type splitWriter struct {
+	cmd     *command
+	path    string
+	file    *os.File
+	bw      *bufio.Writer
+	cw      io.WriteCloser
+	w       io.Writer
+	created map[string]struct{}
+}
+
+func (cmd *command) newSplitWriter() *splitWriter {
+	return &splitWriter{cmd: cmd, created: make(map[string]struct{})}
+}
+
+func (sw *splitWriter) filePath(db, rp, name string) string {
+	path := filepath.Join(sw.cmd.out, url.PathEscape(db), url.PathEscape(rp), url.PathEscape(name)+".lp")
+	if sw.cmd.compress {
+		path += compressionExts[sw.cmd.compression]
+	}
+	return path
+}
+
+// open switches to the file of the measurement, which is created with the header at first, or appended later.
+func (sw *splitWriter) open(db, rp, name string) error {
+	path := sw.filePath(db, rp, name)
+	if path == sw.path {
+		return nil
+	}
+	if err := sw.Close(); err != nil {
+		return err
+	}
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	_, created := sw.created[path]
+	if !created {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		flag |= os.O_TRUNC
+		sw.created[path] = struct{}{}
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return err
+	}
+	sw.path, sw.file = path, f
+	sw.bw = bufio.NewWriterSize(sw.cmd.limitWriter(f), 1024*1024)
+	sw.w = sw.bw
+	if sw.cmd.compress {
+		if sw.cw, err = sw.cmd.newCompressWriter(sw.bw); err != nil {
+			return err
+		}
+		sw.w = sw.cw
+	}
+	sw.w = sw.cmd.manifestWriter(sw.w, path)
+	if !created && !sw.cmd.lponly {
+		sw.cmd.writeSplitHeader(sw.w, db, rp)
+	}
+	return nil
+}
+
+func (sw *splitWriter) Write(b []byte) (int, error) {
+	return sw.w.Write(b)
+}
+
+// Close closes the file open, if any.
+func (sw *splitWriter) Close() error {
+	if sw.file == nil {
+		return nil
+	}
+	var err error
+	if sw.cw != nil {
+		err = sw.cw.Close()
+	}
+	if ferr := sw.bw.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := sw.file.Close(); err == nil {
+		err = cerr
+	}
+	sw.path, sw.file, sw.bw, sw.cw, sw.w = "", nil, nil, nil, nil
+	return err
+}
+
+// writeSplitHeader writes the header of a file split by measurement, which is a complete export of its database and
+// retention policy. The database is created without a default retention policy, so that the files of several
+// retention policies can be imported in any order.
+func (cmd *command) writeSplitHeader(w io.Writer, db, rp string) {
+	s, e := time.Unix(0, cmd.startTime).Format(time.RFC3339), time.Unix(0, cmd.endTime).Format(time.RFC3339)
+	fmt.Fprintf(w, "# INFLUXDB EXPORT: %s - %s\n", s, e)
+	fmt.Fprintln(w, "# DDL")
+	qdb := influxql.QuoteIdent(cmd.contextDatabase(db))
+	fmt.Fprintf(w, "CREATE DATABASE %s\n", qdb)
+	if rp != "autogen" {
+		fmt.Fprintf(w, "CREATE RETENTION POLICY %s ON %s %s\n", influxql.QuoteIdent(rp), qdb, cmd.policyOptions(db, rp))
+	} else {
+		cmd.alterAutogen(w, qdb, db)
+	}
+	fmt.Fprintln(w, "# DML")
+	cmd.writeContext(w, db, rp)
+}
+
+// writeSplit writes the lines of the shards into a file per measurement under the output directory.
+func (cmd *command) writeSplit() (err error) {
+	msgOut := cmd.msgOut()
+	sw := cmd.newSplitWriter()
+	defer func() {
+		if cerr := sw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	write := cmd.writeSeries(sw, cmd.prefixes)
+	flush := func() error { return nil }
+	if cmd.groupFields || cmd.sorted {
+		g := cmd.newFieldGroup(sw)
+		write, flush = cmd.handleSeries(cmd.prefixes, g.add), g.flush
+	}
+	for _, key := range cmd.manifest() {
+		cmd.startKey(key)
+		fmt.Fprintf(msgOut, "writing out %s data for %s%s by measurement...", cmd.kind, filepath.Join(key.db, key.rp), cmd.withMeasurement())
+		var lastName []byte
+		var opened bool
+		for _, sh := range key.shards {
+			err = cmd.readSource(sh, func(seriesKey, field []byte, values []tsm1.Value) error {
+				// the series of a measurement are consecutive in a tsm file, so the file is switched once per measurement
+				if name := models.ParseName(seriesKey); !bytes.Equal(name, lastName) {
+					lastName = append(lastName[:0], name...)
+					opened = false
+				}
+				if _, _, ok := cmd.prefixes.Get(seriesKey, field, cmd.matchSeriesField); !ok {
+					return nil
+				}
+				// the file is not created until a series of the measurement is matched
+				if !opened {
+					// the fields grouped belong to the file of the last measurement
+					if err := flush(); err != nil {
+						return err
+					}
+					// the measurements renamed to the same one are written into its file
+					if err := sw.open(key.db, key.rp, string(cmd.prefixes.names.rename(lastName))); err != nil {
+						return err
+					}
+					opened = true
+				}
+				return write(seriesKey, field, values)
+			})
+			if err == nil {
+				err = flush()
+			}
+			if err != nil {
+				return err
+			}
+			cmd.shardRead(sh)
+		}
+		fmt.Fprintf(msgOut, "complete%s.\n", cmd.keyDone())
+	}
+	cmd.reportSkipped()
+	return nil
+}
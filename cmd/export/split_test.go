@@ -0,0 +1,61 @@
+package exporter
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestWriteSplit(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	for i, id := range []string{"1", "2"} {
+		shardDir := filepath.Join(dataDir, "db", "rp", id)
+		if err := os.MkdirAll(shardDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		ts := int64(i + 2)
+		writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#usage": {tsm1.NewFloatValue(ts, 1.5)},
+			"m/s#!~#v":            {tsm1.NewIntegerValue(ts, 1)},
+		})
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := newTestCommand()
+	cmd.splitBy, cmd.precision, cmd.out = splitByMeasurement, precisionNs, filepath.Join(dir, "out")
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	if err = cmd.writeSplit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first line is the time range of the export in local time
+	header := "# DDL\nCREATE DATABASE db\nCREATE RETENTION POLICY rp ON db DURATION 0s REPLICATION 1\n# DML\n# CONTEXT-DATABASE:db\n# CONTEXT-RETENTION-POLICY:rp\n"
+	files := map[string]string{
+		"cpu.lp":   header + "cpu,host=a usage=1.5 2\ncpu,host=a usage=1.5 3\n",
+		"m%2Fs.lp": header + "m/s v=1i 2\nm/s v=1i 3\n",
+	}
+	for name, exp := range files {
+		b, err := os.ReadFile(filepath.Join(cmd.out, "db", "rp", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, got, _ := strings.Cut(string(b), "\n"); got != exp {
+			t.Errorf("unexpected %s:\n%s", name, got)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/history"
+	"github.com/influxdata/influxdb/models"
+)
+
+// keySummary is the summary of the data exported of a database and retention policy.
+type keySummary struct {
+	Database        string        `json:"database,omitempty"`
+	RetentionPolicy string        `json:"retention_policy,omitempty"`
+	Measurements    int           `json:"measurements"`
+	Series          int           `json:"series"`
+	Fields          int           `json:"fields"` // fields of the measurements
+	Points          int64         `json:"points"`
+	Bytes           int64         `json:"bytes"` // bytes written before compression
+	Elapsed         time.Duration `json:"elapsed"`
+}
+
+// summary counts the measurements, series and fields exported of the database and retention policy being read,
+// the series are counted by the hashes of their keys so that a series in several shards is counted once.
+type summary struct {
+	mu           sync.Mutex
+	seed         maphash.Seed
+	keys         []*keySummary
+	cur          *keySummary
+	measurements map[string]struct{}
+	series       map[uint64]struct{}
+	fields       map[string]struct{}
+	points       int64 // points written when the key started
+	written      int64
+	start        time.Time
+}
+
+// startSummary finishes the summary of the key read before, and starts the one of key if not nil.
+func (cmd *command) startSummary(key *manifestKey) {
+	s := &cmd.summary
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur != nil {
+		s.cur.Measurements, s.cur.Series, s.cur.Fields = len(s.measurements), len(s.series), len(s.fields)
+		s.cur.Points = cmd.progress.points.Load() - s.points
+		s.cur.Bytes = cmd.progress.written.Load() - s.written
+		s.cur.Elapsed = time.Since(s.start)
+		s.keys = append(s.keys, s.cur)
+		s.cur = nil
+	}
+	if key == nil {
+		return
+	}
+	if s.series == nil {
+		s.seed = maphash.MakeSeed()
+	}
+	s.cur = &keySummary{Database: key.db, RetentionPolicy: key.rp}
+	s.measurements, s.series, s.fields = make(map[string]struct{}), make(map[uint64]struct{}), make(map[string]struct{})
+	s.points, s.written, s.start = cmd.progress.points.Load(), cmd.progress.written.Load(), time.Now()
+}
+
+// addSeries counts the field of the series exported, which is called once per series field of a shard.
+func (cmd *command) addSeries(seriesKey, field []byte) {
+	cmd.stats.series.Add(1)
+	s := &cmd.summary
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur == nil {
+		return
+	}
+	// the keys are looked up first, which does not allocate the strings of the keys already added
+	name := models.ParseName(seriesKey)
+	if _, ok := s.measurements[string(name)]; !ok {
+		s.measurements[string(name)] = struct{}{}
+	}
+	s.series[maphash.Bytes(s.seed, seriesKey)] = struct{}{}
+	fieldKey := string(name) + "\x00" + string(field)
+	if _, ok := s.fields[fieldKey]; !ok {
+		s.fields[fieldKey] = struct{}{}
+	}
+}
+
+// total returns the sum of the summaries of the keys.
+func (s *summary) total() keySummary {
+	var t keySummary
+	for _, k := range s.keys {
+		t.Measurements += k.Measurements
+		t.Series += k.Series
+		t.Fields += k.Fields
+		t.Points += k.Points
+		t.Bytes += k.Bytes
+		t.Elapsed += k.Elapsed
+	}
+	return t
+}
+
+// write writes the summary as a line named name.
+func (k *keySummary) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "  %s: %d measurements, %d series, %d fields, %d points, %s in %s\n", name, k.Measurements, k.Series,
+		k.Fields, k.Points, history.FormatBytes(k.Bytes), k.Elapsed.Round(time.Millisecond))
+}
+
+// writeSummary finishes the summary of the last key, and writes the summary per database and retention policy to w,
+// and as json to the stats out file if given.
+func (cmd *command) writeSummary(w io.Writer) error {
+	cmd.startSummary(nil)
+	s := &cmd.summary
+	t := s.total()
+	fmt.Fprintln(w, "summary:")
+	for _, k := range s.keys {
+		k.write(w, filepath.Join(k.Database, k.RetentionPolicy))
+	}
+	t.write(w, "total")
+	if cmd.statsOut == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(struct {
+		Keys  []*keySummary `json:"retention_policies"`
+		Total keySummary    `json:"total"`
+	}{s.keys, t}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cmd.statsOut, append(b, '\n'), 0644)
+}
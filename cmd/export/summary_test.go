@@ -0,0 +1,58 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestWriteSummary(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.statsOut = filepath.Join(t.TempDir(), "stats.json")
+	keys := []struct {
+		key    *manifestKey
+		series [][2]string
+		points int
+	}{
+		// the series of several shards are counted once
+		{&manifestKey{db: "db0", rp: "autogen"}, [][2]string{{"cpu,host=a", "idle"}, {"cpu,host=a", "user"}, {"cpu,host=b", "idle"}, {"cpu,host=a", "idle"}, {"mem,host=a", "used"}}, 10},
+		{&manifestKey{db: "db1", rp: "rp1"}, [][2]string{{"cpu,host=a", "idle"}}, 3},
+	}
+	for _, k := range keys {
+		cmd.startKey(k.key)
+		for _, s := range k.series {
+			cmd.addSeries([]byte(s[0]), []byte(s[1]))
+		}
+		cmd.addWritten(k.points, 100)
+	}
+	var buf bytes.Buffer
+	if err := cmd.writeSummary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	exp := regexp.MustCompile(`^summary:
+  db0/autogen: 2 measurements, 3 series, 3 fields, 10 points, 100 B in \S+
+  db1/rp1: 1 measurements, 1 series, 1 fields, 3 points, 100 B in \S+
+  total: 3 measurements, 4 series, 4 fields, 13 points, 200 B in \S+
+$`)
+	if !exp.MatchString(buf.String()) {
+		t.Errorf("unexpected summary:\n%s", buf.String())
+	}
+
+	b, err := os.ReadFile(cmd.statsOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Keys  []keySummary `json:"retention_policies"`
+		Total keySummary   `json:"total"`
+	}
+	if err = json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Keys) != 2 || out.Keys[1].Database != "db1" || out.Keys[1].Points != 3 || out.Total.Series != 4 || out.Total.Bytes != 200 {
+		t.Errorf("unexpected stats out: %s", b)
+	}
+}
@@ -0,0 +1,61 @@
+package exporter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// tagRewriter renames the tags of the series exported and adds extra tags to them, such as the tag of the source
+// node name before the data of several nodes are merged into one cluster.
+type tagRewriter struct {
+	rename map[string]string
+	add    models.Tags
+}
+
+// parseTagRewriter parses the tags added and renamed like key=value and old=new, or returns nil if none.
+func parseTagRewriter(add, rename []string) (*tagRewriter, error) {
+	if len(add) == 0 && len(rename) == 0 {
+		return nil, nil
+	}
+	r := &tagRewriter{rename: make(map[string]string)}
+	for _, value := range add {
+		k, v, ok := strings.Cut(value, "=")
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("add tag is invalid: %s, require key=value", value)
+		}
+		r.add.SetString(k, v)
+	}
+	for _, value := range rename {
+		from, to, ok := strings.Cut(value, "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("rename tag is invalid: %s, require old=new", value)
+		}
+		if _, ok := r.rename[from]; ok {
+			return nil, fmt.Errorf("rename tag is invalid: %s, tag %s renamed twice", value, from)
+		}
+		r.rename[from] = to
+	}
+	return r, nil
+}
+
+// rewrite returns the tags renamed and added, a tag renamed or added replaces the tag of the same key.
+func (r *tagRewriter) rewrite(tags models.Tags) models.Tags {
+	out := make(models.Tags, 0, len(tags)+len(r.add))
+	var renamed models.Tags
+	for _, t := range tags {
+		if to, ok := r.rename[string(t.Key)]; ok {
+			renamed = append(renamed, models.NewTag([]byte(to), t.Value))
+			continue
+		}
+		out = append(out, t)
+	}
+	for _, t := range renamed {
+		out.Set(t.Key, t.Value)
+	}
+	for _, t := range r.add {
+		out.Set(t.Key, t.Value)
+	}
+	return out
+}
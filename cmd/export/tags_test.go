@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestTagRewriter(t *testing.T) {
+	r, err := parseTagRewriter([]string{"node=n1", "dc=east"}, []string{"host=hostname", "region=dc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tags, exp string
+	}{
+		{"host=a,zone=z", "dc=east,hostname=a,node=n1,zone=z"},
+		{"region=west", "dc=east,node=n1"},
+		{"hostname=b,host=a", "dc=east,hostname=a,node=n1"},
+		{"", "dc=east,node=n1"},
+	}
+	for _, tt := range tests {
+		_, tags := models.ParseKeyBytes([]byte("m," + tt.tags))
+		if got := string(models.MakeKey(nil, r.rewrite(tags))); got != ","+tt.exp {
+			t.Errorf("rewrite %s: got %s, exp ,%s", tt.tags, got, tt.exp)
+		}
+	}
+	if r, err = parseTagRewriter(nil, nil); r != nil || err != nil {
+		t.Errorf("unexpected rewriter %v, %v", r, err)
+	}
+	for _, tt := range []struct{ add, rename []string }{
+		{[]string{"node"}, nil},
+		{[]string{"=v"}, nil},
+		{nil, []string{"a="}},
+		{nil, []string{"a=b", "a=c"}},
+	} {
+		if _, err = parseTagRewriter(tt.add, tt.rename); err == nil {
+			t.Errorf("expected error of %v %v", tt.add, tt.rename)
+		}
+	}
+}
+
+func TestWriteSeriesTags(t *testing.T) {
+	cmd := newTestCommand()
+	cmd.prefixes.tags, _ = parseTagRewriter([]string{"node=n 1"}, []string{"host=hostname"})
+	var buf bytes.Buffer
+	fn := cmd.writeSeries(&buf, cmd.prefixes)
+	if err := fn([]byte(`cpu,host=a\ b`), []byte("v"), []tsm1.Value{tsm1.NewValue(1, 1.0)}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "cpu,hostname=a\\ b,node=n\\ 1 v=1 1\n"; buf.String() != exp {
+		t.Fatalf("unexpected lines: %q, exp %q", buf.String(), exp)
+	}
+}
@@ -0,0 +1,67 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// parseOutputs sets the format and out of the format and out flags given in pairs, a parquet output in addition to
+// a line, annotated-csv or openmetrics output is written from the same read of the shards. No other pairs are
+// available, as only the parquet writer is fed by the values read for another format.
+func (cmd *command) parseOutputs(formats, outs []string) error {
+	if len(formats) <= 1 && len(outs) <= 1 {
+		if len(formats) == 1 {
+			cmd.format = formats[0]
+		}
+		if len(outs) == 1 {
+			cmd.out = outs[0]
+		}
+		return nil
+	}
+	if len(formats) != len(outs) {
+		return fmt.Errorf("format and out must be given in pairs when multiple formats given, got %d formats and %d outs", len(formats), len(outs))
+	}
+	p := 0
+	if formats[1] == formatParquet {
+		p = 1
+	}
+	other := formats[1-p]
+	if len(formats) != 2 || formats[p] != formatParquet || (other != formatLine && other != formatCSV && other != formatOM) {
+		return fmt.Errorf("multiple formats are only available for a parquet out in addition to a line, annotated-csv or openmetrics out, got %s", strings.Join(formats, ", "))
+	}
+	cmd.format, cmd.out, cmd.parquetOut = other, outs[1-p], outs[p]
+	return nil
+}
+
+// readShardsTee reads the shards of a database and retention policy as readShards, and writes their values into the
+// parquet files under the parquet out as well.
+func (cmd *command) readShardsTee(shards []*source.Shard, w io.Writer) error {
+	pw := cmd.newParquetWriter(cmd.parquetOut, true)
+	err := pw.readSchema(shards)
+	if err == nil {
+		cmd.tee = pw
+		err = cmd.readShards(shards, w)
+		cmd.tee = nil
+	}
+	if cerr := pw.close(); err == nil {
+		err = cerr
+	}
+	cmd.teeConflicts += pw.conflicts
+	return err
+}
+
+// teeSeries returns the function writing the values of a series read from the shard into the parquet files before
+// passing them to fn, the values are not modified by the parquet writer.
+func (cmd *command) teeSeries(sh *source.Shard, fn func(seriesKey, field []byte, values []tsm1.Value) error) func(seriesKey, field []byte, values []tsm1.Value) error {
+	write := cmd.tee.writeSeries(sh)
+	return func(seriesKey, field []byte, values []tsm1.Value) error {
+		if err := write(seriesKey, field, values); err != nil {
+			return err
+		}
+		return fn(seriesKey, field, values)
+	}
+}
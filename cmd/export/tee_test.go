@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestParseOutputs(t *testing.T) {
+	cmd := newTestCommand()
+	if err := cmd.parseOutputs([]string{formatParquet, formatLine}, []string{"pq", "out.lp"}); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.format != formatLine || cmd.out != "out.lp" || cmd.parquetOut != "pq" {
+		t.Fatalf("unexpected outputs: %s %s %s", cmd.format, cmd.out, cmd.parquetOut)
+	}
+	cmd = newTestCommand()
+	if err := cmd.parseOutputs([]string{formatCSV}, []string{"out.csv"}); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.format != formatCSV || cmd.out != "out.csv" || cmd.parquetOut != "" {
+		t.Fatalf("unexpected outputs: %s %s %s", cmd.format, cmd.out, cmd.parquetOut)
+	}
+	for _, tt := range []struct {
+		formats, outs []string
+	}{
+		{[]string{formatLine, formatParquet}, []string{"out"}},
+		{[]string{formatLine, formatCSV}, []string{"a", "b"}},
+		{[]string{formatParquet, formatParquet}, []string{"a", "b"}},
+		{[]string{formatTSMBlocks, formatParquet}, []string{"a", "b"}},
+		{[]string{formatLine, formatParquet, formatOM}, []string{"a", "b", "c"}},
+	} {
+		if err := newTestCommand().parseOutputs(tt.formats, tt.outs); err == nil {
+			t.Errorf("expected error of %v %v", tt.formats, tt.outs)
+		}
+	}
+	// the formats given are named by the error of an unavailable pair
+	err := newTestCommand().parseOutputs([]string{formatLine, formatCSV}, []string{"a", "b"})
+	if err == nil || !strings.Contains(err.Error(), "got line, annotated-csv") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWriteTee(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	for _, shard := range []string{"1", "2"} {
+		shardDir := filepath.Join(dataDir, "db", "autogen", shard)
+		if err := os.MkdirAll(shardDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5)},
+		"cpu,host=a#!~#cores": {tsm1.NewIntegerValue(1, 4)},
+	})
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=b#!~#usage": {tsm1.NewFloatValue(2, 2.5)},
+	})
+
+	cmd := newTestCommand()
+	cmd.format, cmd.precision, cmd.parquetLayout, cmd.lponly, cmd.quiet = formatLine, precisionNs, parquetLayoutMeasurement, true, true
+	cmd.database, cmd.out, cmd.parquetOut = "db", filepath.Join(dir, "out.lp"), filepath.Join(dir, "pq")
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	if err = cmd.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(cmd.out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "cpu,host=a cores=4i 1\ncpu,host=a usage=1.5 1\ncpu,host=b usage=2.5 2\n"; string(b) != exp {
+		t.Errorf("unexpected lines:\n%s", b)
+	}
+	got := readParquet(t, filepath.Join(cmd.parquetOut, "db", "autogen", "cpu.parquet"))
+	exp := []string{
+		"[cores host time usage]",
+		"[4 a 1 1.5]",
+		"[<null> b 2 2.5]",
+	}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected cpu rows:\n%v", got)
+	}
+	if cmd.tee != nil {
+		t.Error("tee not reset")
+	}
+}
@@ -0,0 +1,162 @@
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/sink"
+)
+
+const (
+	defaultBucketTemplate = "%db/%rp"
+	v2WriteTimeout        = time.Minute
+)
+
+// v2Precisions are the precisions of the /api/v2/write endpoint by the precisions of export.
+var v2Precisions = map[string]string{
+	"s":         "s",
+	"ms":        "ms",
+	"u":         "us",
+	precisionNs: "ns",
+}
+
+// bucketMap maps the databases and retention policies to the buckets of influxdb 2.x, by the rules of a database
+// and retention policy "db/rp=bucket" first, then the rules of a database "db=bucket", and then the template with
+// %db and %rp replaced.
+type bucketMap struct {
+	template string
+	rules    map[string]string
+}
+
+// parseBucketMap parses the rules "db/rp=bucket" and "db=bucket", and at most one template.
+func parseBucketMap(values []string) (*bucketMap, error) {
+	m := &bucketMap{template: defaultBucketTemplate, rules: make(map[string]string)}
+	template := false
+	for _, value := range values {
+		from, bucket, ok := strings.Cut(value, "=")
+		if !ok {
+			if template || value == "" {
+				return nil, fmt.Errorf("v2 bucket is invalid: %s, require only one template", value)
+			}
+			m.template, template = value, true
+			continue
+		}
+		if from == "" || bucket == "" {
+			return nil, fmt.Errorf("v2 bucket is invalid: %s, require db/rp=bucket or db=bucket", value)
+		}
+		m.rules[from] = bucket
+	}
+	return m, nil
+}
+
+// bucket returns the bucket of the database and retention policy.
+func (m *bucketMap) bucket(db, rp string) string {
+	if bucket, ok := m.rules[db+"/"+rp]; ok {
+		return bucket
+	}
+	if bucket, ok := m.rules[db]; ok {
+		return bucket
+	}
+	return strings.NewReplacer("%db", db, "%rp", rp).Replace(m.template)
+}
+
+// v2Writer writes the lines to a bucket of an influxdb 2.x server in batches of whole lines, the bytes of an
+// incomplete line are kept until the rest written.
+type v2Writer struct {
+	cmd     *command
+	hc      *http.Client
+	bucket  string
+	buf     []byte
+	lines   int
+	backoff time.Duration // backoff before the first retry, doubled for each later one
+}
+
+func (cmd *command) newV2Writer() *v2Writer {
+	return &v2Writer{cmd: cmd, hc: &http.Client{Timeout: v2WriteTimeout}, backoff: time.Second}
+}
+
+func (w *v2Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	w.lines += bytes.Count(p, []byte{'\n'})
+	for w.lines >= sink.DefaultBatchSize {
+		// the end of the last line of the batch
+		n := 0
+		for i := 0; i < sink.DefaultBatchSize; i++ {
+			n += bytes.IndexByte(w.buf[n:], '\n') + 1
+		}
+		if err := w.post(w.buf[:n]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+		w.lines -= sink.DefaultBatchSize
+	}
+	return len(p), nil
+}
+
+// flush writes the lines buffered, an incomplete line left is an error.
+func (w *v2Writer) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if w.buf[len(w.buf)-1] != '\n' {
+		return errors.New("incomplete line written to bucket " + w.bucket)
+	}
+	err := w.post(w.buf)
+	w.buf, w.lines = w.buf[:0], 0
+	return err
+}
+
+// post writes the batch to the bucket, it is retried with backoff if failed by a network error or a server error.
+func (w *v2Writer) post(data []byte) error {
+	backoff := w.backoff
+	for i := 0; ; i++ {
+		retry, err := sink.WriteV2(w.hc, w.cmd.v2URL, w.cmd.v2Token, w.cmd.v2Org, w.bucket, v2Precisions[w.cmd.precision], "", data)
+		if err == nil {
+			return nil
+		}
+		if !retry || i >= sink.DefaultRetries {
+			return err
+		}
+		log.Printf("%s, retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// writeV2 streams the lines of the shards to the buckets of the influxdb 2.x server mapped by the databases and
+// retention policies, instead of writing the output files.
+func (cmd *command) writeV2() error {
+	msgOut := cmd.msgOut()
+	w := cmd.newV2Writer()
+	for _, key := range cmd.manifest() {
+		cmd.context = key
+		cmd.startKey(key)
+		w.bucket = cmd.buckets.bucket(cmd.contextDatabase(key.db), key.rp)
+		fmt.Fprintf(msgOut, "writing out %s data for %s%s to bucket %s...", cmd.kind, filepath.Join(key.db, key.rp), cmd.withMeasurement(), w.bucket)
+		if err := cmd.writeBucket(key, w); err != nil {
+			return err
+		}
+		fmt.Fprintf(msgOut, "complete%s.\n", cmd.keyDone())
+	}
+	return nil
+}
+
+// writeBucket writes the lines of the key to the bucket of w, the lines are encoded while the previous batches
+// are written.
+func (cmd *command) writeBucket(key *manifestKey, w *v2Writer) error {
+	pw := newPipeWriter(cmd.limitWriter(w), pipelineChunkSize, pipelineDepth)
+	err := cmd.readShards(key.shards, pw)
+	if cerr := pw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return w.flush()
+}
@@ -0,0 +1,118 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/sink"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestBucketMap(t *testing.T) {
+	m, err := parseBucketMap([]string{"db/rp1=b1", "db=b2", "%db_%rp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		db, rp, exp string
+	}{
+		{"db", "rp1", "b1"},
+		{"db", "rp2", "b2"},
+		{"other", "autogen", "other_autogen"},
+	}
+	for _, tt := range tests {
+		if got := m.bucket(tt.db, tt.rp); got != tt.exp {
+			t.Errorf("bucket of %s/%s: got %s, exp %s", tt.db, tt.rp, got, tt.exp)
+		}
+	}
+	if m, _ = parseBucketMap(nil); m.bucket("db", "rp") != "db/rp" {
+		t.Errorf("unexpected default bucket %s", m.bucket("db", "rp"))
+	}
+	for _, values := range [][]string{{"a", "b"}, {"=b"}, {"db="}} {
+		if _, err = parseBucketMap(values); err == nil {
+			t.Errorf("expected error of %v", values)
+		}
+	}
+}
+
+func TestWriteV2(t *testing.T) {
+	var mu sync.Mutex
+	writes := make(map[string]string)
+	batches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v2/write" || q.Get("org") != "org" || q.Get("precision") != "ns" || r.Header.Get("Authorization") != "Token token" {
+			http.Error(w, "bad request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		writes[q.Get("bucket")] += string(body)
+		batches++
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	values := make([]tsm1.Value, sink.DefaultBatchSize+1)
+	for i := range values {
+		values[i] = tsm1.NewIntegerValue(int64(i), 1)
+	}
+	for i, path := range []string{"db/rp1/1", "db/rp2/2"} {
+		shardDir := filepath.Join(dataDir, path)
+		if err := os.MkdirAll(shardDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+			"cpu,host=a#!~#usage": values[:len(values)-i],
+		})
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := newTestCommand()
+	cmd.format, cmd.precision, cmd.out, cmd.quiet = formatLine, precisionNs, filepath.Join(dir, "out"), true
+	cmd.v2URL, cmd.v2Token, cmd.v2Org = ts.URL, "token", "org"
+	cmd.buckets, _ = parseBucketMap([]string{"db/rp1=b1"})
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	cmd.src = source.NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := cmd.src.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.shards = shards
+	if err = cmd.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(cmd.out); !os.IsNotExist(err) {
+		t.Errorf("unexpected output file: %v", err)
+	}
+	for bucket, n := range map[string]int{"b1": len(values), "db/rp2": len(values) - 1} {
+		lines := strings.Split(strings.TrimSuffix(writes[bucket], "\n"), "\n")
+		if len(lines) != n {
+			t.Fatalf("unexpected lines of %s: %d, exp %d", bucket, len(lines), n)
+		}
+		for i, line := range lines {
+			if exp := fmt.Sprintf("cpu,host=a usage=1i %d", i); line != exp {
+				t.Fatalf("unexpected line of %s: %s, exp %s", bucket, line, exp)
+			}
+		}
+	}
+	if batches != 3 {
+		t.Errorf("unexpected batches: %d, exp 3", batches)
+	}
+}
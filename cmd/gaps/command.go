@@ -0,0 +1,279 @@
+package gaps
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/spf13/cobra"
+)
+
+type command struct {
+	cobraCmd        *cobra.Command
+	dataDir         string
+	walDir          string
+	database        string
+	retentionPolicy string
+	measurement     map[string]struct{}
+	expectEvery     time.Duration
+	tolerance       time.Duration
+	startTime       int64
+	endTime         int64
+	out             string
+}
+
+type tempflag struct {
+	start       string
+	end         string
+	measurement []string
+}
+
+func NewCommand() *cobra.Command {
+	tf := &tempflag{}
+	cmd := &command{measurement: make(map[string]struct{})}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "gaps",
+		Short:         "Report the missing time ranges of series from tsm and wal files",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE(tf)
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.dataDir, "datadir", "D", "", "data storage path (required)")
+	flags.StringVarP(&cmd.walDir, "waldir", "W", "", "wal storage path (required)")
+	flags.StringVarP(&cmd.database, "database", "d", "", "database to scan without _internal (default: all)")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "", "retention policy to scan (require database, default: all)")
+	flags.StringArrayVarP(&tf.measurement, "measurement", "m", []string{}, "measurement to scan, can be set multiple times (require database, default: all)")
+	flags.DurationVar(&cmd.expectEvery, "expect-every", 0, "expected interval of the points of a series, such as 10s (required)")
+	flags.DurationVar(&cmd.tolerance, "tolerance", 0, "jitter tolerated on top of expect-every before a gap is reported (default: 0)")
+	flags.StringVarP(&tf.start, "start", "S", "", "start time to scan, the gap from it to the first point of a series is reported too (RFC3339 format, optional)")
+	flags.StringVarP(&tf.end, "end", "E", "", "end time to scan, the gap from the last point of a series to it is reported too (RFC3339 format, optional)")
+	flags.StringVarP(&cmd.out, "out", "o", "-", "'-' for standard out or the file to write the gaps to")
+	cmd.cobraCmd.MarkFlagRequired("datadir")
+	cmd.cobraCmd.MarkFlagRequired("waldir")
+	cmd.cobraCmd.MarkFlagRequired("expect-every")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) validate(tf *tempflag) error {
+	if cmd.expectEvery <= 0 {
+		return errors.New("expect every is invalid")
+	}
+	if cmd.tolerance < 0 {
+		return errors.New("tolerance is invalid")
+	}
+	cmd.startTime, cmd.endTime = math.MinInt64, math.MaxInt64
+	if tf.start != "" {
+		s, err := time.Parse(time.RFC3339, tf.start)
+		if err != nil {
+			return errors.New("start time is invalid")
+		}
+		cmd.startTime = s.UnixNano()
+	}
+	if tf.end != "" {
+		e, err := time.Parse(time.RFC3339, tf.end)
+		if err != nil {
+			return errors.New("end time is invalid")
+		}
+		cmd.endTime = e.UnixNano()
+	}
+	if cmd.startTime > cmd.endTime {
+		return errors.New("end time before start time")
+	}
+	if cmd.database == "_internal" {
+		return errors.New("database cannot be _internal")
+	}
+	if cmd.retentionPolicy != "" && cmd.database == "" {
+		return errors.New("must specify a database when retention policy given")
+	}
+	if len(tf.measurement) > 0 && cmd.database == "" {
+		return errors.New("must specify a database when measurement given")
+	}
+	for _, m := range tf.measurement {
+		cmd.measurement[m] = struct{}{}
+	}
+	return nil
+}
+
+// gap is a missing time range of a series, between the points around it, or the start or end time of the scan.
+type gap struct {
+	series   string
+	from, to int64
+}
+
+// span is the time range of the points of a series in a shard.
+type span struct {
+	min, max int64
+}
+
+// scanner finds the gaps of the series of a retention policy. The shards of a retention policy do not overlap in
+// time, so the gaps inside a shard are found from its points, and the ones across shards from the spans of the
+// series in each shard.
+type scanner struct {
+	threshold int64 // the longest interval not reported as a gap
+	spans     map[string][]span
+	gaps      []gap
+	series    []byte // series being read in a shard
+	times     []int64
+}
+
+func newScanner(threshold int64) *scanner {
+	return &scanner{threshold: threshold, spans: make(map[string][]span)}
+}
+
+// add adds the values of a field of the series, the fields of a series are read consecutively in a shard.
+func (s *scanner) add(seriesKey []byte, values []tsm1.Value) {
+	if string(seriesKey) != string(s.series) {
+		s.flush()
+		s.series = append(s.series[:0], seriesKey...)
+	}
+	for _, v := range values {
+		s.times = append(s.times, v.UnixNano())
+	}
+}
+
+// flush finds the gaps of the points of the series read in the shard, and records its span.
+func (s *scanner) flush() {
+	if len(s.times) == 0 {
+		return
+	}
+	times := s.times
+	if len(times) > 1 {
+		// the times of a field are sorted, but not the ones of the fields together
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	}
+	series := string(s.series)
+	for i := 1; i < len(times); i++ {
+		if times[i]-times[i-1] > s.threshold {
+			s.gaps = append(s.gaps, gap{series: series, from: times[i-1], to: times[i]})
+		}
+	}
+	s.spans[series] = append(s.spans[series], span{min: times[0], max: times[len(times)-1]})
+	s.times = s.times[:0]
+}
+
+// finish returns the gaps of the series sorted by series and time, with the ones across shards and the ones from
+// start and to end if known.
+func (s *scanner) finish(start, end int64) []gap {
+	s.flush()
+	for series, spans := range s.spans {
+		sort.Slice(spans, func(i, j int) bool { return spans[i].min < spans[j].min })
+		if start != math.MinInt64 && spans[0].min-start > s.threshold {
+			s.gaps = append(s.gaps, gap{series: series, from: start, to: spans[0].min})
+		}
+		last := spans[0].max
+		for _, sp := range spans[1:] {
+			if sp.min-last > s.threshold {
+				s.gaps = append(s.gaps, gap{series: series, from: last, to: sp.min})
+			}
+			if sp.max > last {
+				last = sp.max
+			}
+		}
+		if end != math.MaxInt64 && end-last > s.threshold {
+			s.gaps = append(s.gaps, gap{series: series, from: last, to: end})
+		}
+	}
+	sort.Slice(s.gaps, func(i, j int) bool {
+		if s.gaps[i].series != s.gaps[j].series {
+			return s.gaps[i].series < s.gaps[j].series
+		}
+		return s.gaps[i].from < s.gaps[j].from
+	})
+	return s.gaps
+}
+
+func (cmd *command) runE(tf *tempflag) error {
+	if err := cmd.validate(tf); err != nil {
+		return err
+	}
+	src := source.NewFileSource(cmd.dataDir, cmd.walDir, false, tsmread.ModeMmap)
+	shards, err := src.ListShards(cmd.database, cmd.retentionPolicy)
+	if err != nil {
+		return err
+	}
+	if len(shards) == 0 {
+		return errors.New("no shards found")
+	}
+
+	var w io.Writer = os.Stdout
+	if cmd.out != "-" {
+		f, err := os.Create(cmd.out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	log.SetFlags(0)
+	var gaps, series int
+	for len(shards) > 0 {
+		// the shards are sorted by database and retention policy
+		n := 1
+		for n < len(shards) && shards[n].Database == shards[0].Database && shards[n].RetentionPolicy == shards[0].RetentionPolicy {
+			n++
+		}
+		db, rp := shards[0].Database, shards[0].RetentionPolicy
+		s := newScanner(int64(cmd.expectEvery + cmd.tolerance))
+		for _, sh := range shards[:n] {
+			err = src.ReadMergedValues(sh, cmd.startTime, cmd.endTime, func(seriesKey, field []byte, values []tsm1.Value) error {
+				if len(cmd.measurement) > 0 {
+					if _, ok := cmd.measurement[string(models.ParseName(seriesKey))]; !ok {
+						return nil
+					}
+				}
+				s.add(seriesKey, values)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			s.flush()
+		}
+		rpGaps := s.finish(cmd.startTime, cmd.endTime)
+		if len(rpGaps) > 0 {
+			fmt.Fprintf(bw, "# %s\n", filepath.Join(db, rp))
+		}
+		for _, g := range rpGaps {
+			cmd.writeGap(bw, g)
+		}
+		log.Printf("%s: %d gaps in %d series of %d shards", filepath.Join(db, rp), len(rpGaps), len(s.spans), n)
+		gaps += len(rpGaps)
+		series += len(s.spans)
+		shards = shards[n:]
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	log.Printf("%d gaps in %d series found", gaps, series)
+	return nil
+}
+
+// writeGap writes the gap as a line like "<series_key> <from> <to> <duration> <missing points>", the missing
+// points are the ones expected every interval in the gap.
+func (cmd *command) writeGap(w io.Writer, g gap) {
+	d := time.Duration(g.to - g.from)
+	missing := (d - 1) / cmd.expectEvery
+	if missing < 1 {
+		missing = 1
+	}
+	fmt.Fprintf(w, "%s %s %s %s %d\n", g.series, time.Unix(0, g.from).UTC().Format(time.RFC3339Nano),
+		time.Unix(0, g.to).UTC().Format(time.RFC3339Nano), d, missing)
+}
@@ -0,0 +1,43 @@
+package gaps
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func values(times ...int64) []tsm1.Value {
+	vs := make([]tsm1.Value, len(times))
+	for i, t := range times {
+		vs[i] = tsm1.NewFloatValue(t, 1)
+	}
+	return vs
+}
+
+func TestScanner(t *testing.T) {
+	cmd := &command{expectEvery: 10 * time.Nanosecond}
+	s := newScanner(12)
+	// shard 2 is read before shard 1, such as a shard created by backfilling
+	s.add([]byte("cpu,host=a"), values(100, 110, 150))
+	s.add([]byte("cpu,host=a"), values(120))
+	s.add([]byte("cpu,host=b"), values(100, 111))
+	s.flush()
+	s.add([]byte("cpu,host=a"), values(40, 50, 62))
+	s.add([]byte("cpu,host=b"), values(90))
+	s.flush()
+
+	var buf bytes.Buffer
+	for _, g := range s.finish(30, math.MaxInt64) {
+		cmd.writeGap(&buf, g)
+	}
+	exp := `cpu,host=a 1970-01-01T00:00:00.000000062Z 1970-01-01T00:00:00.0000001Z 38ns 3
+cpu,host=a 1970-01-01T00:00:00.00000012Z 1970-01-01T00:00:00.00000015Z 30ns 2
+cpu,host=b 1970-01-01T00:00:00.00000003Z 1970-01-01T00:00:00.00000009Z 60ns 5
+`
+	if buf.String() != exp {
+		t.Errorf("unexpected gaps:\n%s", buf.String())
+	}
+}
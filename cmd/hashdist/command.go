@@ -7,9 +7,10 @@ import (
 	"io"
 	"log"
 	"os"
+	"slices"
 	"strings"
 
-	"github.com/chengshiwen/influx-tool/internal/hash"
+	"github.com/chengshiwen/influx-tool/pkg/hash"
 	"github.com/spf13/cobra"
 )
 
@@ -22,7 +23,10 @@ type command struct {
 	org         string
 	bucket      string
 	database    string
+	rp          string
 	measurement string
+	spreadMms   []string
+	tagValue    string
 	separator   string
 	file        string
 	dist        string
@@ -52,13 +56,16 @@ func NewCommand() *cobra.Command {
 	flags.StringVarP(&cmd.version, "version", "v", "v1", "influxdb version: v1, v2")
 	flags.IntVarP(&cmd.nodeTotal, "node-total", "n", 1, "total number of node in a circle")
 	flags.StringVarP(&cmd.hashKey, "hash-key", "k", "", "hash key for influx proxy: idx, exi or template containing %idx (v1 default \"idx\", v2 default \"%idx\")")
-	flags.StringVarP(&cmd.shardKey, "shard-key", "K", "", "shard key for influx proxy, which containing %org, %bk, %db or %mm (v1 default \"%db,%mm\", v2 default \"%org,%bk,%mm\")")
+	flags.StringVarP(&cmd.shardKey, "shard-key", "K", "", "shard key for influx proxy, which containing %org, %bk, %db, %rp or %mm (v1 default \"%db,%mm\", v2 default \"%org,%bk,%mm\")")
 	flags.StringVarP(&cmd.org, "org", "o", "", "org name under influxdb v2, note that --file cannot be specified when --org specified")
 	flags.StringVarP(&cmd.bucket, "bucket", "b", "", "bucket name under influxdb v2, note that --file cannot be specified when --bucket specified")
 	flags.StringVarP(&cmd.database, "database", "d", "", "database name under influxdb v1, note that --file cannot be specified when --database specified")
+	flags.StringVarP(&cmd.rp, "retention-policy", "r", "autogen", "retention policy name under influxdb v1 rendered by %rp of shard key")
 	flags.StringVarP(&cmd.measurement, "measurement", "m", "", "measurement name, note that --file cannot be specified when --measurement specified")
+	flags.StringSliceVar(&cmd.spreadMms, "spread-measurement", nil, "measurements under influxdb v1 routed by the spread tag value appended to the shard key, delimited by comma (default: none)")
+	flags.StringVar(&cmd.tagValue, "tag-value", "", "spread tag value of a series of --measurement, which must be a spread measurement")
 	flags.StringVarP(&cmd.separator, "separator", "s", ",", "separator character to separate each line in the file")
-	flags.StringVarP(&cmd.file, "file", "f", "", "path to the file to read, format of each line is like 'db,mm' separated by a separator")
+	flags.StringVarP(&cmd.file, "file", "f", "", "path to the file to read, format of each line is like 'db,mm' separated by a separator, or 'db,mm,value' for the series of a spread measurement")
 	flags.StringVarP(&cmd.dist, "dist", "D", "./dist", "'-' for standard out or the distribution file to write to when --file specified")
 	return cmd.cobraCmd
 }
@@ -80,8 +87,8 @@ func (cmd *command) validate() error {
 		if cmd.hashKey != hash.HashKeyIdx && cmd.hashKey != hash.HashKeyExi && !strings.Contains(cmd.hashKey, hash.HashKeyVarIdx) {
 			return errors.New("hash-key is invalid, require idx, exi or template containing %idx")
 		}
-		if !strings.Contains(cmd.shardKey, hash.ShardKeyVarDb) && !strings.Contains(cmd.shardKey, hash.ShardKeyVarMm) {
-			return errors.New("shard-key is invalid, require template containing %db or %mm")
+		if !hash.ValidShardKey(cmd.shardKey) {
+			return errors.New("shard-key is invalid, require template containing %db, %rp or %mm")
 		}
 		if (cmd.database != "" || cmd.measurement != "") && cmd.file != "" {
 			return errors.New("--file cannot be specified when --database or --measurement specified")
@@ -89,6 +96,9 @@ func (cmd *command) validate() error {
 		if cmd.database == "" && cmd.measurement == "" && cmd.file == "" {
 			return errors.New("--database, --measurement or --file flag required")
 		}
+		if cmd.tagValue != "" && !slices.Contains(cmd.spreadMms, cmd.measurement) {
+			return errors.New("--tag-value requires --measurement which is in --spread-measurement")
+		}
 	} else {
 		if !cmd.cobraCmd.Flags().Changed("hash-key") {
 			cmd.hashKey = hash.HashKeyVarIdx
@@ -96,6 +106,9 @@ func (cmd *command) validate() error {
 		if !cmd.cobraCmd.Flags().Changed("shard-key") {
 			cmd.shardKey = hash.ShardKeyOrgBkMm
 		}
+		if len(cmd.spreadMms) > 0 || cmd.tagValue != "" {
+			return errors.New("--spread-measurement and --tag-value are only supported under influxdb v1")
+		}
 		if !strings.Contains(cmd.hashKey, hash.HashKeyVarIdx) {
 			return errors.New("hash-key is invalid, require template containing %idx")
 		}
@@ -137,10 +150,26 @@ func (cmd *command) runE() error {
 func (cmd *command) hashdist() error {
 	ch := hash.NewConsistentHash(cmd.nodeTotal, cmd.hashKey)
 	st := hash.NewShardTpl(cmd.shardKey)
+	// the warning is written into the distribution when --file specified
+	warning := hash.CircleWarning(cmd.nodeTotal, cmd.hashKey)
+	if warning != "" && cmd.file == "" {
+		log.Printf("warning: %s", warning)
+	}
+	ss := hash.NewSpreadShard(st, cmd.spreadMms, "")
 	if cmd.version == version1 {
 		if cmd.database != "" || cmd.measurement != "" {
+			key := st.GetKey(cmd.database, cmd.rp, []byte(cmd.measurement))
+			if cmd.tagValue != "" {
+				log.Printf("node total: %d, hash key: %s, shard key: %s, database: %s, measurement: %s, tag value: %s", cmd.nodeTotal, cmd.hashKey, cmd.shardKey, cmd.database, cmd.measurement, cmd.tagValue)
+				log.Printf("node index: %d", ch.Get(hash.SpreadKey(key, cmd.tagValue)))
+				return nil
+			}
 			log.Printf("node total: %d, hash key: %s, shard key: %s, database: %s, measurement: %s", cmd.nodeTotal, cmd.hashKey, cmd.shardKey, cmd.database, cmd.measurement)
-			log.Printf("node index: %d", ch.Get(st.GetKey(cmd.database, []byte(cmd.measurement))))
+			if ss.Spread([]byte(cmd.measurement)) {
+				log.Printf("node index: spread by tag value, specify --tag-value for the node index of a series")
+				return nil
+			}
+			log.Printf("node index: %d", ch.Get(key))
 			return nil
 		}
 	} else {
@@ -188,7 +217,15 @@ func (cmd *command) hashdist() error {
 				}
 				continue
 			}
-			dist[ch.Get(st.GetKey(db, []byte(mm)))] += 1
+			var value string
+			if len(cmd.spreadMms) > 0 {
+				mm, value, _ = strings.Cut(mm, cmd.separator)
+			}
+			key := st.GetKey(db, cmd.rp, []byte(mm))
+			if value != "" && ss.Spread([]byte(mm)) {
+				key = hash.SpreadKey(key, value)
+			}
+			dist[ch.Get(key)] += 1
 		} else {
 			items := strings.Split(line, cmd.separator)
 			if len(items) == 0 || len(items) != 3 {
@@ -214,8 +251,14 @@ func (cmd *command) hashdist() error {
 	if _, err := w.Write([]byte(fmt.Sprintf("node total: %d, hash key: %s, shard key: %s, total hits: %d\n", cmd.nodeTotal, cmd.hashKey, cmd.shardKey, tHits))); err != nil {
 		return err
 	}
+	points := hash.VirtualPoints(cmd.nodeTotal, cmd.hashKey)
 	for i := 0; i < cmd.nodeTotal; i++ {
-		if _, err := w.Write([]byte(fmt.Sprintf("node index: %d, hits: %d, percent: %4.1f%%, expect: %4.1f%%\n", i, dist[i], float64(dist[i])*100/float64(tHits), 100/float64(cmd.nodeTotal)))); err != nil {
+		if _, err := w.Write([]byte(fmt.Sprintf("node index: %d, hits: %d, percent: %4.1f%%, expect: %4.1f%%, virtual points: %d\n", i, dist[i], float64(dist[i])*100/float64(tHits), 100/float64(cmd.nodeTotal), points[i]))); err != nil {
+			return err
+		}
+	}
+	if warning != "" {
+		if _, err := w.Write([]byte(fmt.Sprintf("\nwarning: %s\n", warning))); err != nil {
 			return err
 		}
 	}
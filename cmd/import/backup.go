@@ -0,0 +1,214 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/backup"
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+func (cmd *command) importBackup() error {
+	data, files, err := backup.LoadPortable(cmd.backupPath)
+	if err != nil {
+		return err
+	}
+	c, err := cmd.newClient()
+	if err != nil {
+		return err
+	}
+
+	log.SetFlags(log.LstdFlags)
+	start := time.Now().UTC()
+	defer func() {
+		elapsed := time.Since(start)
+		if elapsed.Minutes() > 10 {
+			log.Printf("total time: %0.1f minutes", elapsed.Minutes())
+		} else {
+			log.Printf("total time: %0.1f seconds", elapsed.Seconds())
+		}
+	}()
+
+	if !cmd.skipDDL {
+		if err = cmd.createDatabases(c, data); err != nil {
+			return err
+		}
+	}
+
+	var sc *schema
+	if cmd.checkSchema {
+		sc = newSchema(cmd.typeConflict)
+		for _, file := range files {
+			if !cmd.matchDatabase(file.Database, file.Policy) {
+				continue
+			}
+			err = cmd.walkShardFile(filepath.Join(cmd.backupPath, file.FileName), func(tr *backup.TSMReader) error {
+				for i := 0; i < tr.KeyCount(); i++ {
+					key, typ := tr.KeyAt(i)
+					seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+					if m := string(models.ParseName(seriesKey)); cmd.matchMeasurement(m) {
+						sc.Observe(file.Database, m, string(field), blockType(typ))
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if err = sc.Check(c); err != nil {
+			return err
+		}
+	}
+
+	p := cmd.newProgress()
+	bw := cmd.newBatchWriter(c, p)
+	for _, file := range files {
+		if !cmd.matchDatabase(file.Database, file.Policy) {
+			continue
+		}
+		bw.SetContext(file.Database, file.Policy)
+		if err = cmd.importShardFile(filepath.Join(cmd.backupPath, file.FileName), bw, sc); err != nil {
+			return err
+		}
+		log.Printf("shard %d of %s.%s imported from %s", file.ShardID, file.Database, file.Policy, file.FileName)
+	}
+	sc.Report()
+	return p.Done()
+}
+
+func (cmd *command) createDatabases(c *client.Client, data *meta.Data) error {
+	for _, dbi := range data.Databases {
+		if !cmd.matchDatabase(dbi.Name, "") {
+			continue
+		}
+		db := influxql.QuoteIdent(dbi.Name)
+		if err := cmd.execute(c, "", "CREATE DATABASE "+db); err != nil {
+			return err
+		}
+		for _, rpi := range dbi.RetentionPolicies {
+			if !cmd.matchDatabase(dbi.Name, rpi.Name) {
+				continue
+			}
+			stmt := fmt.Sprintf("CREATE RETENTION POLICY %s ON %s DURATION %s REPLICATION %d SHARD DURATION %s",
+				influxql.QuoteIdent(rpi.Name), db, influxql.FormatDuration(rpi.Duration), rpi.ReplicaN, influxql.FormatDuration(rpi.ShardGroupDuration))
+			if rpi.Name == dbi.DefaultRetentionPolicy {
+				stmt += " DEFAULT"
+			}
+			if err := cmd.execute(c, "", stmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// execute executes the statement on the database, which is logged only on dry run.
+func (cmd *command) execute(c *client.Client, db, stmt string) error {
+	if cmd.dryRun {
+		log.Printf("dry run: %s", stmt)
+		return nil
+	}
+	resp, err := c.QueryContext(cmd.ctx, client.Query{Command: stmt, Database: db})
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("execute '%s' error: %v", stmt, err)
+	}
+	log.Printf("executed: %s", stmt)
+	return nil
+}
+
+// walkShardFile calls fn for each tsm file in the shard backup file overlapping the time range, until fn returns an error.
+func (cmd *command) walkShardFile(path string, fn func(tr *backup.TSMReader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return backup.WalkArchive(f, path, func(name string, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read %s in %s error: %v", name, path, err)
+		}
+		tr, err := backup.NewTSMReader(b)
+		if err != nil {
+			log.Printf("unable to read %s in %s, skipping: %s", name, path, err)
+			return nil
+		}
+		if min, max := tr.TimeRange(); min > cmd.endTime || max < cmd.startTime {
+			return nil
+		}
+		return fn(tr)
+	})
+}
+
+func (cmd *command) importShardFile(path string, bw *batchWriter, sc *schema) error {
+	return cmd.walkShardFile(path, func(tr *backup.TSMReader) error {
+		for i := 0; i < tr.KeyCount(); i++ {
+			key, _ := tr.KeyAt(i)
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			m := string(models.ParseName(seriesKey))
+			if !cmd.matchMeasurement(m) {
+				continue
+			}
+			values, err := tr.ReadAll(key)
+			if err != nil {
+				log.Printf("unable to read key %q in %s, skipping: %s", string(key), path, err)
+				continue
+			}
+			values = sc.FixValues(bw.db, m, string(field), values)
+			// seriesKey are stored escaped, field names are not
+			prefix := string(seriesKey) + " " + string(escape.Bytes(field)) + "="
+			for _, v := range values {
+				if ts := v.UnixNano(); ts < cmd.startTime || ts > cmd.endTime {
+					continue
+				}
+				bw.Add(formatLine(prefix, v))
+			}
+			if bw.err != nil {
+				return bw.err
+			}
+		}
+		bw.Flush()
+		return bw.err
+	})
+}
+
+// formatLine formats a value as line protocol "<series_key> <field>=<value> <timestamp>".
+func formatLine(prefix string, value tsm1.Value) string {
+	buf := []byte(prefix)
+	switch v := value.Value().(type) {
+	case float64:
+		buf = strconv.AppendFloat(buf, v, 'g', -1, 64)
+	case int64:
+		buf = strconv.AppendInt(buf, v, 10)
+		buf = append(buf, 'i')
+	case uint64:
+		buf = strconv.AppendUint(buf, v, 10)
+		buf = append(buf, 'u')
+	case bool:
+		buf = strconv.AppendBool(buf, v)
+	case string:
+		buf = append(buf, '"')
+		buf = append(buf, models.EscapeStringField(v)...)
+		buf = append(buf, '"')
+	default:
+		buf = append(buf, fmt.Sprintf("%v", v)...)
+	}
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, value.UnixNano(), 10)
+	return string(buf)
+}
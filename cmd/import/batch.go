@@ -0,0 +1,267 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/ratelimit"
+	"github.com/influxdata/influxdb/client"
+)
+
+const (
+	defaultBatchSize = 5000
+	minBatchSize     = 100
+	maxBatchSize     = 100000
+	progressLines    = 100000
+)
+
+// batchSizer adapts the number of lines per write to the server latency, a fixed size never changes.
+type batchSizer struct {
+	size    int
+	fixed   bool
+	target  time.Duration
+	ceiling int // a quarter below the smallest size rejected as too large or timed out
+}
+
+func newBatchSizer(size int, target time.Duration) *batchSizer {
+	if size > 0 {
+		return &batchSizer{size: size, fixed: true}
+	}
+	return &batchSizer{size: defaultBatchSize, target: target, ceiling: maxBatchSize}
+}
+
+// Observe adjusts the size by the latency of a successful write of n lines. It grows only after full
+// batches faster than half of the target, and shrinks in proportion when slower than the target.
+func (s *batchSizer) Observe(n int, latency time.Duration) {
+	if s.fixed || latency <= 0 {
+		return
+	}
+	if latency > s.target {
+		s.resize(int(float64(s.size) * float64(s.target) / float64(latency)))
+	} else if latency < s.target/2 && n >= s.size {
+		s.resize(s.size + s.size/2)
+	}
+}
+
+// Shrink halves the size below a write of n lines rejected as too large or timed out,
+// it reports false if unable to shrink.
+func (s *batchSizer) Shrink(n int) bool {
+	if n > s.size {
+		n = s.size
+	}
+	if s.fixed || n <= minBatchSize {
+		return false
+	}
+	if c := n - n/4; c < s.ceiling {
+		s.ceiling = c
+	}
+	s.resize(n / 2)
+	return true
+}
+
+func (s *batchSizer) resize(size int) {
+	if size < minBatchSize {
+		size = minBatchSize
+	} else if size > s.ceiling {
+		size = s.ceiling
+	}
+	s.size = size
+}
+
+// retryable reports whether a write error means the batch is too large or too slow for the server.
+func retryable(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "too large") || strings.Contains(msg, "timeout")
+}
+
+// progress is shared by the batch writers importing concurrently, it throttles them in total
+// by points per second and bandwidth, and logs the consolidated progress.
+type progress struct {
+	mu       sync.Mutex
+	pps      int
+	dryRun   bool
+	limiter  *ratelimit.Limiter
+	start    time.Time
+	written  int
+	failed   int
+	skipped  int
+	reported int
+}
+
+func (cmd *command) newProgress() *progress {
+	return &progress{
+		pps:     cmd.pps,
+		dryRun:  cmd.dryRun,
+		limiter: ratelimit.NewLimiterMbps(cmd.maxNetworkMbps),
+		start:   time.Now(),
+	}
+}
+
+// wait blocks until the points per second and the bandwidth allow to write n bytes.
+func (p *progress) wait(n int) {
+	if p.pps > 0 {
+		p.mu.Lock()
+		expected := time.Duration(float64(p.written+p.failed) / float64(p.pps) * float64(time.Second))
+		p.mu.Unlock()
+		if d := expected - time.Since(p.start); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	p.limiter.WaitN(n)
+}
+
+func (p *progress) add(written, failed, skipped, batchSize int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.written += written
+	p.failed += failed
+	p.skipped += skipped
+	if processed := p.written + p.failed; processed-p.reported >= progressLines {
+		p.reported = processed
+		elapsed := time.Since(p.start)
+		log.Printf("processed %d lines, time elapsed: %s, points per second: %d, batch size: %d",
+			processed, elapsed.Truncate(time.Millisecond), int64(float64(processed)/elapsed.Seconds()), batchSize)
+	}
+}
+
+// Done logs the number of points, it returns an error if any point failed.
+func (p *progress) Done() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dryRun {
+		log.Printf("dry run: points to be imported: %d, skipped as existing: %d, nothing written", p.written, p.skipped)
+		return nil
+	}
+	if p.skipped > 0 {
+		log.Printf("points imported: %d, failed: %d, skipped as existing: %d", p.written, p.failed, p.skipped)
+	} else {
+		log.Printf("points imported: %d, failed: %d", p.written, p.failed)
+	}
+	if p.failed > 0 {
+		return fmt.Errorf("%d points were not inserted", p.failed)
+	}
+	return nil
+}
+
+// batchWriter writes lines to a database and retention policy in batches, a batch writer is not safe
+// for concurrent use, but batch writers sharing a progress are.
+type batchWriter struct {
+	ctx      context.Context
+	client   *client.Client
+	progress *progress
+	sizer    *batchSizer
+	exists   *existChecker
+	db, rp   string
+	lines    []string
+	offset   int64 // lines consumed and flushed, from which to resume
+	pending  int64 // lines consumed since the last flush, including the lines skipped
+	err      error // stops writing once set
+}
+
+func (cmd *command) newBatchWriter(c *client.Client, p *progress) *batchWriter {
+	return &batchWriter{
+		ctx:      cmd.ctx,
+		client:   c,
+		progress: p,
+		sizer:    newBatchSizer(cmd.batchSize, cmd.targetLatency),
+		exists:   newExistChecker(cmd.ctx, c, cmd.onConflict),
+	}
+}
+
+// SetContext flushes the pending lines and switches to another database and retention policy.
+func (bw *batchWriter) SetContext(db, rp string) {
+	if db != bw.db || rp != bw.rp {
+		bw.Flush()
+		bw.db, bw.rp = db, rp
+	}
+}
+
+func (bw *batchWriter) Add(line string) {
+	if bw.err != nil {
+		return
+	}
+	bw.lines = append(bw.lines, line)
+	bw.pending++
+	if len(bw.lines) >= bw.sizer.size {
+		bw.Flush()
+	}
+}
+
+// Skip consumes a line not to be written, which is counted in the offset after the pending lines flushed.
+func (bw *batchWriter) Skip() {
+	if bw.err == nil {
+		bw.pending++
+	}
+}
+
+func (bw *batchWriter) Flush() {
+	if bw.err != nil {
+		return
+	}
+	if err := bw.ctx.Err(); err != nil {
+		bw.err = fmt.Errorf("import stopped: %v", err)
+		return
+	}
+	defer func() {
+		if bw.err == nil {
+			bw.offset += bw.pending
+			bw.pending = 0
+		}
+	}()
+	if len(bw.lines) == 0 {
+		return
+	}
+	n := len(bw.lines)
+	lines, err := bw.exists.Filter(bw.db, bw.rp, bw.lines)
+	if err != nil {
+		bw.err = err
+		return
+	}
+	if skipped := n - len(lines); skipped > 0 {
+		bw.progress.add(0, 0, skipped, bw.sizer.size)
+	}
+	if len(lines) > 0 {
+		bw.write(lines)
+	}
+	bw.lines = bw.lines[:0]
+}
+
+func (bw *batchWriter) write(lines []string) {
+	if bw.progress.dryRun {
+		bw.progress.add(len(lines), 0, 0, bw.sizer.size)
+		return
+	}
+	data := strings.Join(lines, "\n")
+	bw.progress.wait(len(data))
+	begin := time.Now()
+	_, err := bw.client.WriteLineProtocol(data, bw.db, bw.rp, "n", "")
+	if err == nil {
+		bw.sizer.Observe(len(lines), time.Since(begin))
+		bw.progress.add(len(lines), 0, 0, bw.sizer.size)
+		return
+	}
+	if retryable(err) && bw.sizer.Shrink(len(lines)) {
+		log.Printf("write of %d lines to %s.%s failed: %s, retrying with batch size %d", len(lines), bw.db, bw.rp, err, bw.sizer.size)
+		for len(lines) > 0 {
+			n := bw.sizer.size
+			if n > len(lines) {
+				n = len(lines)
+			}
+			bw.write(lines[:n])
+			lines = lines[n:]
+		}
+		return
+	}
+	log.Printf("error writing batch to %s.%s: %s", bw.db, bw.rp, err)
+	bw.progress.add(0, len(lines), 0, bw.sizer.size)
+}
@@ -0,0 +1,168 @@
+package importer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client"
+)
+
+func TestBatchSizer(t *testing.T) {
+	s := newBatchSizer(0, time.Second)
+	s.Observe(defaultBatchSize, 100*time.Millisecond)
+	if s.size != 7500 {
+		t.Errorf("unexpected size after fast write: %d", s.size)
+	}
+	s.Observe(100, 100*time.Millisecond)
+	if s.size != 7500 {
+		t.Errorf("unexpected size after partial batch: %d", s.size)
+	}
+	s.Observe(s.size, 3*time.Second)
+	if s.size != 2500 {
+		t.Errorf("unexpected size after slow write: %d", s.size)
+	}
+	if !s.Shrink(1000) || s.size != 500 {
+		t.Errorf("unexpected size after shrink: %d", s.size)
+	}
+	s.Observe(s.size, time.Hour)
+	if s.size != minBatchSize || s.Shrink(s.size) {
+		t.Errorf("unexpected size below min: %d", s.size)
+	}
+
+	s = newBatchSizer(10, time.Second)
+	s.Observe(10, time.Millisecond)
+	if s.size != 10 || s.Shrink(10) {
+		t.Errorf("unexpected fixed size: %d", s.size)
+	}
+}
+
+func TestBatchWriterTooLarge(t *testing.T) {
+	var lines int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		n := strings.Count(string(b), "\n") + 1
+		if n > 1000 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(`{"error":"Request Entity Too Large"}`))
+			return
+		}
+		lines += n
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, err := client.NewClient(client.Config{URL: *u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &command{targetLatency: time.Second, ctx: context.Background()}
+	bw := cmd.newBatchWriter(c, cmd.newProgress())
+	for i := 0; i < 12000; i++ {
+		bw.Add("cpu value=1")
+	}
+	bw.Flush()
+	if bw.progress.written != 12000 || bw.progress.failed != 0 || lines != 12000 {
+		t.Errorf("unexpected written: %d, failed: %d, received: %d", bw.progress.written, bw.progress.failed, lines)
+	}
+	if bw.sizer.size > 1000 {
+		t.Errorf("unexpected batch size: %d", bw.sizer.size)
+	}
+
+	cmd.batchSize = 2000
+	bw = cmd.newBatchWriter(c, cmd.newProgress())
+	for i := 0; i < 3000; i++ {
+		bw.Add("cpu value=1")
+	}
+	bw.Flush()
+	if bw.progress.written != 1000 || bw.progress.failed != 2000 {
+		t.Errorf("unexpected written with fixed size: %d, failed: %d", bw.progress.written, bw.progress.failed)
+	}
+}
+
+func TestBatchWriterDeadline(t *testing.T) {
+	var lines int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		lines += strings.Count(string(b), "\n") + 1
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, err := client.NewClient(client.Config{URL: *u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := &command{batchSize: 100, ctx: ctx}
+	bw := cmd.newBatchWriter(c, cmd.newProgress())
+	for i := 0; i < 250; i++ {
+		if i%10 == 0 {
+			bw.Skip()
+		}
+		bw.Add("cpu value=1")
+	}
+	cancel()
+	bw.Flush()
+	if bw.err == nil || lines != 200 {
+		t.Fatalf("unexpected err: %v, received: %d", bw.err, lines)
+	}
+	if bw.offset != 220 {
+		t.Errorf("unexpected offset: %d", bw.offset)
+	}
+	bw.Add("cpu value=1")
+	if bw.pending != 55 {
+		t.Errorf("unexpected pending after stopped: %d", bw.pending)
+	}
+}
+
+func TestBatchWriterDryRun(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, err := client.NewClient(client.Config{URL: *u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &command{batchSize: 100, ctx: context.Background(), dryRun: true}
+	bw := cmd.newBatchWriter(c, cmd.newProgress())
+	for i := 0; i < 250; i++ {
+		bw.Add("cpu value=1")
+	}
+	bw.Flush()
+	if bw.progress.written != 250 || requests != 0 {
+		t.Errorf("unexpected written: %d, requests: %d", bw.progress.written, requests)
+	}
+	if err = cmd.execute(c, "db", "DROP MEASUREMENT cpu"); err != nil || requests != 0 {
+		t.Errorf("unexpected execute error: %v, requests: %d", err, requests)
+	}
+}
+
+func TestNewClientDryRun(t *testing.T) {
+	// no server listens on the port
+	u, _ := url.Parse("http://127.0.0.1:1")
+	cmd := &command{clientConfig: client.Config{URL: *u}, onConflict: conflictOverwrite, dryRun: true}
+	if c, err := cmd.newClient(); c != nil || err != nil {
+		t.Errorf("unexpected client of dry run: %v, %v", c, err)
+	}
+	cmd.checkSchema = true
+	if _, err := cmd.newClient(); err == nil {
+		t.Error("expected error of connecting to check schema")
+	}
+	cmd.checkSchema, cmd.onConflict = false, conflictSkip
+	if _, err := cmd.newClient(); err == nil {
+		t.Error("expected error of connecting to check the points existing")
+	}
+}
@@ -0,0 +1,204 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/blocks"
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+const (
+	fileFormatLine      = "line"
+	fileFormatTSMBlocks = "tsm-blocks"
+	fileFormatCSV       = "csv"
+)
+
+// importBlocks writes the blocks of a tsm blocks container into the shards of the offline target dir as they are.
+// Each tsm file in the container is written into a new tsm file of the shard group covering its time range.
+func (cmd *command) importBlocks() error {
+	log.SetFlags(log.LstdFlags)
+	start := time.Now().UTC()
+	defer func() {
+		elapsed := time.Since(start)
+		if elapsed.Minutes() > 10 {
+			log.Printf("total time: %0.1f minutes", elapsed.Minutes())
+		} else {
+			log.Printf("total time: %0.1f seconds", elapsed.Seconds())
+		}
+	}()
+
+	f, err := os.Open(cmd.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := cmd.decompress(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	br, err := blocks.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	// the target directory is not opened on dry run, whose blocks are read and counted only
+	bi := &blockImporter{cmd: cmd, importers: make(map[string]*shard.Importer)}
+	if !cmd.dryRun {
+		bi.svr, err = server.NewServer(cmd.targetDir, !cmd.skipTsi)
+		if err != nil {
+			return fmt.Errorf("create server error: %s", err)
+		}
+		defer bi.svr.Close()
+	}
+	err = bi.run(br)
+	el := errlist.NewErrorList()
+	el.Add(err)
+	el.Add(bi.close())
+	if err = el.Err(); err != nil {
+		return err
+	}
+	if cmd.dryRun {
+		log.Printf("dry run: %d blocks of %d tsm files in %d shards to be imported into %s, nothing written", bi.blocks, bi.files, bi.shards, cmd.targetDir)
+		return nil
+	}
+	log.Printf("imported %d blocks of %d tsm files in %d shards", bi.blocks, bi.files, bi.shards)
+	return nil
+}
+
+// blockImporter writes the records of a container, the shard group of a file is started by its first block.
+type blockImporter struct {
+	cmd       *command
+	svr       *server.Server
+	importers map[string]*shard.Importer // importers by db.rp
+	imp       *shard.Importer            // importer of the current shard
+	iw        *shard.ImportWorker        // worker of the current file, nil until its first block
+	inFile    bool                       // whether a file record is read since the last shard record
+	start     int64                      // start time of the shard group of the current file
+	seriesKey []byte                     // last series key added
+
+	shards, files, blocks int
+}
+
+func (bi *blockImporter) run(br *blocks.Reader) error {
+	var sh *blocks.Record
+	for {
+		rec, err := br.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch rec.Type {
+		case blocks.ShardRecord:
+			if err = bi.closeFile(); err != nil {
+				return err
+			}
+			if bi.imp, err = bi.importer(rec.Database, rec.RetentionPolicy); err != nil {
+				return err
+			}
+			sh = &blocks.Record{Database: rec.Database, RetentionPolicy: rec.RetentionPolicy, ShardID: rec.ShardID}
+			bi.inFile = false
+			bi.shards++
+		case blocks.FileRecord:
+			if err = bi.closeFile(); err != nil {
+				return err
+			}
+			if sh == nil {
+				return errors.New("tsm file record before shard record")
+			}
+			sd := bi.cmd.shardDuration
+			bi.start = time.Unix(0, rec.MinTime).Truncate(sd).UnixNano()
+			if rec.MaxTime >= bi.start+int64(sd) {
+				return fmt.Errorf("tsm file of shard %d in %s.%s spans shard groups of %v, rerun with a larger shard duration",
+					sh.ShardID, sh.Database, sh.RetentionPolicy, sd)
+			}
+			bi.inFile = true
+			bi.files++
+		case blocks.BlockRecord:
+			if !bi.inFile {
+				return errors.New("block record before tsm file record")
+			}
+			if err = bi.writeBlock(rec); err != nil {
+				return fmt.Errorf("write block of shard %d in %s.%s error: %v", sh.ShardID, sh.Database, sh.RetentionPolicy, err)
+			}
+		}
+	}
+}
+
+func (bi *blockImporter) importer(db, rp string) (*shard.Importer, error) {
+	key := db + "." + rp
+	if imp, ok := bi.importers[key]; ok {
+		return imp, nil
+	}
+	if bi.cmd.dryRun {
+		// the meta is validated once per retention policy without creating it, nil is kept as validated
+		err := shard.ValidateRetentionPolicy(bi.cmd.targetDir, db, rp, bi.cmd.shardDuration, 0, bi.cmd.adoptRP)
+		if err != nil {
+			return nil, err
+		}
+		bi.importers[key] = nil
+		return nil, nil
+	}
+	imp, err := shard.NewImporter(bi.svr, db, rp, bi.cmd.shardDuration, 0, !bi.cmd.skipTsi, bi.cmd.adoptRP)
+	if err != nil {
+		imp.Close()
+		return nil, err
+	}
+	bi.importers[key] = imp
+	return imp, nil
+}
+
+func (bi *blockImporter) writeBlock(rec *blocks.Record) error {
+	if bi.cmd.dryRun {
+		bi.blocks++
+		return nil
+	}
+	if bi.iw == nil {
+		bi.iw = shard.NewImportWorker(bi.imp)
+		if err := bi.iw.StartShardGroup(bi.start, bi.start+int64(bi.cmd.shardDuration)); err != nil {
+			bi.iw = nil
+			return err
+		}
+		bi.seriesKey = bi.seriesKey[:0]
+	}
+	// blocks are sorted by key, so the fields of a series are consecutive
+	if seriesKey, _ := tsm1.SeriesAndFieldFromCompositeKey(rec.Key); !bytes.Equal(seriesKey, bi.seriesKey) {
+		if err := bi.iw.AddSeries(seriesKey); err != nil {
+			return err
+		}
+		bi.seriesKey = append(bi.seriesKey[:0], seriesKey...)
+	}
+	bi.blocks++
+	return bi.iw.WriteBlock(rec.Key, rec.MinTime, rec.MaxTime, rec.Block)
+}
+
+func (bi *blockImporter) closeFile() error {
+	if bi.iw == nil {
+		return nil
+	}
+	err := bi.iw.Close()
+	bi.iw = nil
+	return err
+}
+
+func (bi *blockImporter) close() error {
+	el := errlist.NewErrorList()
+	el.Add(bi.closeFile())
+	for _, imp := range bi.importers {
+		if imp != nil {
+			el.Add(imp.Close())
+		}
+	}
+	return el.Err()
+}
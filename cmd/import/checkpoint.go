@@ -0,0 +1,91 @@
+package importer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checkpoint records the number of DML lines consumed of each file, to resume an interrupted import by skipping
+// them. A nil checkpoint records nothing and skips nothing, and a read-only one is never saved or removed.
+type checkpoint struct {
+	mu       sync.Mutex
+	path     string
+	readOnly bool
+	Lines    map[string]int64 `json:"lines"`
+}
+
+// loadCheckpoint loads the checkpoint file if exists, an empty path returns a nil checkpoint.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+	cp := &checkpoint{path: path, Lines: make(map[string]int64)}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cp, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Skip returns the number of DML lines of the file consumed by a previous import.
+func (cp *checkpoint) Skip(file string) int64 {
+	if cp == nil {
+		return 0
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.Lines[checkpointKey(file)]
+}
+
+func (cp *checkpoint) Set(file string, lines int64) {
+	if cp == nil {
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Lines[checkpointKey(file)] = lines
+}
+
+// Save writes the checkpoint file atomically.
+func (cp *checkpoint) Save() error {
+	if cp == nil || cp.readOnly {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+// Remove removes the checkpoint file once the import is completed.
+func (cp *checkpoint) Remove() error {
+	if cp == nil || cp.readOnly {
+		return nil
+	}
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func checkpointKey(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
@@ -1,10 +1,15 @@
 package importer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"time"
 
+	"github.com/chengshiwen/influx-tool/internal/audit"
 	"github.com/influxdata/influxdb/client"
-	v8 "github.com/influxdata/influxdb/importer/v8"
 	"github.com/spf13/cobra"
 )
 
@@ -13,20 +18,68 @@ type command struct {
 	host         string
 	port         int
 	ssl          bool
-	config       v8.Config
+	path         string
+	dir          string
+	worker       int
+	compressed   bool
+	pps          int
 	clientConfig client.Config
+
+	format        string
+	targetDir     string
+	shardDuration time.Duration
+	skipTsi       bool
+	adoptRP       bool
+	strictMeta    bool
+	csv           csvOptions
+
+	batchSize      int
+	targetLatency  time.Duration
+	maxNetworkMbps float64
+	deadline       time.Duration
+	checkpointPath string
+
+	backupPath        string
+	database          string
+	retentionPolicy   string
+	measurement       map[string]struct{}
+	regexpMeasurement []*regexp.Regexp
+	startTime         int64
+	endTime           int64
+	skipDDL           bool
+
+	checkSchema  bool
+	typeConflict string
+	onConflict   string
+
+	ctx    context.Context // canceled once the deadline exceeded
+	cp     *checkpoint
+	dryRun bool // reads the input without writing the points, executing the statements or saving the checkpoint
+}
+
+type tempflag struct {
+	start             string
+	end               string
+	measurement       []string
+	regexpMeasurement []string
+	csvFieldType      []string
 }
 
 func NewCommand() *cobra.Command {
-	cmd := &command{}
+	tf := &tempflag{}
+	cmd := &command{
+		measurement:       make(map[string]struct{}),
+		regexpMeasurement: make([]*regexp.Regexp, 0),
+	}
 	cmd.cobraCmd = &cobra.Command{
 		Args:          cobra.NoArgs,
 		Use:           "import",
 		Short:         "Import a previous export from file",
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		Annotations:   map[string]string{audit.DryRunAnnotation: ""},
 		RunE: func(c *cobra.Command, args []string) error {
-			return cmd.runE()
+			return cmd.runE(tf)
 		},
 	}
 	flags := cmd.cobraCmd.Flags()
@@ -36,14 +89,175 @@ func NewCommand() *cobra.Command {
 	flags.StringVarP(&cmd.clientConfig.Username, "username", "u", "", "username to connect to the server")
 	flags.StringVarP(&cmd.clientConfig.Password, "password", "p", "", "password to connect to the server")
 	flags.BoolVarP(&cmd.ssl, "ssl", "s", false, "use https for requests (default: false)")
-	flags.StringVarP(&cmd.config.Path, "path", "f", "", "path to the file to import (required)")
-	flags.BoolVarP(&cmd.config.Compressed, "compressed", "c", false, "set to true if the import file is compressed (default: false)")
-	flags.IntVar(&cmd.config.PPS, "pps", 0, "points per second the import will allow (default: 0, unlimited)")
-	cmd.cobraCmd.MarkFlagRequired("path")
+	flags.StringVarP(&cmd.path, "path", "f", "", "path to the file to import (required without backup-path or dir)")
+	flags.StringVar(&cmd.dir, "dir", "", "directory of files to import concurrently instead of path, such as exports split by measurement")
+	flags.IntVarP(&cmd.worker, "worker", "w", 1, "number of concurrent workers to import files in dir")
+	flags.BoolVarP(&cmd.compressed, "compressed", "c", false, "set to true if the import file is compressed with gzip, which is detected without it as well as zstd and snappy (default: false)")
+	flags.StringVar(&cmd.format, "format", fileFormatLine, "format of the file to import: line for line protocol, tsm-blocks for a container written by export --format tsm-blocks, or csv for plain csv mapped by the csv flags or the annotated csv of influxdb 2.x")
+	flags.StringVarP(&cmd.targetDir, "target-dir", "t", "", "offline influxdb directory containing meta, data and wal to write tsm blocks or the points of csv to, the points of csv are loaded into memory (require tsm-blocks or csv format and path)")
+	flags.DurationVar(&cmd.shardDuration, "shard-duration", time.Hour*24*7, "retention policy shard duration of target-dir, no less than that of the exported shards")
+	flags.BoolVar(&cmd.skipTsi, "skip-tsi", false, "skip building TSI index on disk of target-dir (default: false)")
+	flags.BoolVar(&cmd.adoptRP, "adopt-existing-rp", false, "adopt the retention policy existing in target-dir with a different duration, replication or shard duration, whose shard duration must be a multiple of shard-duration, with a warning of the mismatch instead of failing (require target-dir, default: false)")
+	flags.BoolVar(&cmd.strictMeta, "strict-meta", false, "fail once the retention policy exists in target-dir with different parameters, which is the behavior without adopt-existing-rp, checked by dry-run as well (require target-dir, default: false)")
+	flags.StringVar(&cmd.csv.delimiter, "csv-delimiter", ",", "delimiter of the cells of csv, such as ; for the csv of the locales with decimal comma")
+	flags.StringVar(&cmd.csv.measurement, "csv-measurement", "", "measurement of the rows of plain csv (default: the file name without extensions)")
+	flags.StringVar(&cmd.csv.measurementColumn, "csv-measurement-column", "", "column of the measurement of the rows of plain csv instead of csv-measurement (default: none)")
+	flags.StringVar(&cmd.csv.timeColumn, "csv-time-column", "time", "column of the time of the rows of plain csv")
+	flags.StringVar(&cmd.csv.timeFormat, "csv-time-format", csvTimeRFC3339, "format of the time column of plain csv: rfc3339, unix, unix_ms, unix_us, unix_ns, or a go layout such as \"2006-01-02 15:04:05\" in csv-timezone")
+	flags.StringVar(&cmd.csv.timezone, "csv-timezone", "UTC", "timezone of the times of csv-time-format layout without zone, such as Local or Europe/Berlin")
+	flags.StringSliceVar(&cmd.csv.tagColumns, "csv-tag-columns", nil, "columns of the tags of plain csv delimited by comma, the empty cells are omitted (default: none)")
+	flags.StringSliceVar(&cmd.csv.fieldColumns, "csv-field-columns", nil, "columns of the fields of plain csv delimited by comma, the empty cells are omitted (default: the columns other than time, measurement and tags)")
+	flags.StringArrayVar(&tf.csvFieldType, "csv-field-type", []string{}, "type of a field column of plain csv as column=type of float, integer, unsigned, boolean or string, can be set multiple times, the others are floats if numbers, booleans if true or false, or strings (default: inferred)")
+	flags.StringVar(&cmd.csv.decimal, "csv-decimal-separator", ".", "decimal separator of the numbers of csv: . or ,")
+	flags.StringVar(&cmd.csv.thousands, "csv-thousands-separator", "", "thousands separator of the numbers of csv removed before parsing: ., ,, space or ' (default: none)")
+	flags.IntVar(&cmd.pps, "pps", 0, "points per second the import will allow (default: 0, unlimited)")
+	flags.IntVar(&cmd.batchSize, "batch-size", 0, "number of lines per write (default: 0, adapted to the server latency)")
+	flags.DurationVar(&cmd.targetLatency, "target-latency", time.Second, "target latency per write to adapt the batch size to")
+	flags.DurationVar(&cmd.clientConfig.Timeout, "timeout", 0, "timeout of requests to the server, a timed out write is retried in smaller batches (default: 0, no timeout)")
+	flags.DurationVar(&cmd.deadline, "deadline", 0, "deadline of the whole import, after which no more write is started (default: 0, no deadline)")
+	flags.StringVar(&cmd.checkpointPath, "checkpoint", "", "file to record the lines imported when interrupted, and to resume from by the next import (require path or dir)")
+	flags.Float64Var(&cmd.maxNetworkMbps, "max-network-mbps", 0, "max bandwidth in Mbps to write to the server (default: 0, unlimited)")
+	flags.StringVarP(&cmd.backupPath, "backup-path", "B", "", "influxd backup directory in portable format to import instead of path")
+	flags.StringVarP(&cmd.database, "database", "d", "", "database to import from backup without _internal, or to import csv into (required with csv format, default: all)")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "", "retention policy to import from backup, or to import csv into (require database, default: the default one, or autogen of target-dir)")
+	flags.StringArrayVarP(&tf.measurement, "measurement", "m", []string{}, "measurement to import from backup, can be set multiple times (require database, default: all)")
+	flags.StringArrayVarP(&tf.regexpMeasurement, "regexp-measurement", "M", []string{}, "regexp measurement to import from backup, can be set multiple times (require database, default: all)")
+	flags.StringVarP(&tf.start, "start", "S", "", "start time to import from backup (RFC3339 format, optional)")
+	flags.StringVarP(&tf.end, "end", "E", "", "end time to import from backup (RFC3339 format, optional)")
+	flags.BoolVar(&cmd.skipDDL, "skip-ddl", false, "skip creating databases and retention policies from backup, e.g. for influxdb v2 with dbrp mappings (default: false)")
+	flags.BoolVar(&cmd.checkSchema, "check-schema", false, "check field types against the target before writing and report conflicts (default: false)")
+	flags.StringVar(&cmd.typeConflict, "type-conflict", conflictError, "how to handle field type conflicts: error, coerce or skip values (require check-schema)")
+	flags.StringVar(&cmd.onConflict, "on-conflict", conflictOverwrite, "how to handle points already existing in the target: overwrite, skip or error, checked by querying before each write unless overwrite")
 	return cmd.cobraCmd
 }
 
-func (cmd *command) validate() error {
+func (cmd *command) validate(tf *tempflag) error {
+	sources := 0
+	for _, source := range []string{cmd.path, cmd.backupPath, cmd.dir} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources == 0 {
+		return errors.New("must specify path, backup path or dir")
+	}
+	if sources > 1 {
+		return errors.New("only one of path, backup path and dir can be specified")
+	}
+	if cmd.format != fileFormatLine && cmd.format != fileFormatTSMBlocks && cmd.format != fileFormatCSV {
+		return errors.New("format is invalid, require line, tsm-blocks or csv")
+	}
+	if cmd.format == fileFormatTSMBlocks && (cmd.path == "" || cmd.targetDir == "") {
+		return errors.New("must specify path and target dir for tsm-blocks format")
+	}
+	if cmd.format == fileFormatTSMBlocks && cmd.checkpointPath != "" {
+		return errors.New("checkpoint is not available for tsm-blocks format")
+	}
+	if cmd.format != fileFormatTSMBlocks && cmd.format != fileFormatCSV && cmd.targetDir != "" {
+		return errors.New("target dir is only available for tsm-blocks or csv format")
+	}
+	if cmd.format == fileFormatCSV {
+		if cmd.backupPath != "" {
+			return errors.New("backup path is not available for csv format")
+		}
+		if cmd.database == "" {
+			return errors.New("must specify database for csv format")
+		}
+		if cmd.targetDir != "" && (cmd.path == "" || cmd.checkpointPath != "" || cmd.checkSchema || cmd.onConflict != conflictOverwrite) {
+			return errors.New("target dir of csv format requires path, and is not available for checkpoint, check schema or on conflict")
+		}
+		if err := cmd.csv.validate(tf.csvFieldType); err != nil {
+			return err
+		}
+	} else if len(tf.csvFieldType) > 0 || len(cmd.csv.tagColumns) > 0 || len(cmd.csv.fieldColumns) > 0 || cmd.csv.measurement != "" || cmd.csv.measurementColumn != "" {
+		return errors.New("csv flags are only available for csv format")
+	}
+	if cmd.shardDuration <= 0 {
+		return errors.New("shard-duration is invalid")
+	}
+	if (cmd.adoptRP || cmd.strictMeta) && cmd.targetDir == "" {
+		return errors.New("adopt-existing-rp and strict-meta require target dir")
+	}
+	if cmd.adoptRP && cmd.strictMeta {
+		return errors.New("adopt-existing-rp cannot be specified with strict-meta")
+	}
+	if cmd.worker < 1 {
+		return errors.New("worker is invalid")
+	}
+	if cmd.backupPath == "" && ((cmd.database != "" && cmd.format != fileFormatCSV) || len(tf.measurement) > 0 || len(tf.regexpMeasurement) > 0 || tf.start != "" || tf.end != "" || cmd.skipDDL) {
+		return errors.New("filters and skip ddl are only available when backup path given")
+	}
+	if cmd.typeConflict != conflictError && cmd.typeConflict != conflictCoerce && cmd.typeConflict != conflictSkip {
+		return errors.New("type conflict is invalid")
+	}
+	if cmd.typeConflict != conflictError && !cmd.checkSchema {
+		return errors.New("must specify check schema when type conflict given")
+	}
+	if cmd.onConflict != conflictOverwrite && cmd.onConflict != conflictSkip && cmd.onConflict != conflictError {
+		return errors.New("on conflict is invalid")
+	}
+	if cmd.batchSize < 0 {
+		return errors.New("batch-size is invalid")
+	}
+	if cmd.targetLatency <= 0 {
+		return errors.New("target-latency is invalid")
+	}
+	if cmd.clientConfig.Timeout < 0 {
+		return errors.New("timeout is invalid")
+	}
+	if cmd.deadline < 0 {
+		return errors.New("deadline is invalid")
+	}
+	if cmd.checkpointPath != "" && cmd.backupPath != "" {
+		return errors.New("checkpoint is only available when path or dir given")
+	}
+	if cmd.maxNetworkMbps < 0 {
+		return errors.New("max-network-mbps is invalid")
+	}
+	if tf.start != "" {
+		s, err := time.Parse(time.RFC3339, tf.start)
+		if err != nil {
+			return errors.New("start time is invalid")
+		}
+		cmd.startTime = s.UnixNano()
+	} else {
+		cmd.startTime = math.MinInt64
+	}
+	if tf.end != "" {
+		e, err := time.Parse(time.RFC3339, tf.end)
+		if err != nil {
+			return errors.New("end time is invalid")
+		}
+		cmd.endTime = e.UnixNano()
+	} else {
+		cmd.endTime = math.MaxInt64
+	}
+	if cmd.endTime < cmd.startTime {
+		return errors.New("end time before start time")
+	}
+	if cmd.database == "_internal" {
+		return errors.New("database cannot be _internal")
+	}
+	if cmd.retentionPolicy != "" && cmd.database == "" {
+		return errors.New("must specify a database when retention policy given")
+	}
+	if len(tf.measurement) > 0 && cmd.database == "" {
+		return errors.New("must specify a database when measurement given")
+	}
+	for _, str := range tf.measurement {
+		cmd.measurement[str] = struct{}{}
+	}
+	if len(tf.regexpMeasurement) > 0 && cmd.database == "" {
+		return errors.New("must specify a database when regexp measurement given")
+	}
+	for _, str := range tf.regexpMeasurement {
+		if rem, err := regexp.Compile(str); err == nil {
+			cmd.regexpMeasurement = append(cmd.regexpMeasurement, rem)
+		} else {
+			return fmt.Errorf("regexp measurement: %s, compile error: %v", str, err)
+		}
+	}
+
 	addr := fmt.Sprintf("%s:%d", cmd.host, cmd.port)
 	url, err := client.ParseConnectionString(addr, cmd.ssl)
 	if err != nil {
@@ -54,15 +268,59 @@ func (cmd *command) validate() error {
 	return nil
 }
 
-func (cmd *command) runE() error {
-	if err := cmd.validate(); err != nil {
+func (cmd *command) runE(tf *tempflag) error {
+	if err := cmd.validate(tf); err != nil {
 		return err
 	}
-	config := cmd.config
-	config.Config = cmd.clientConfig
-	i := v8.NewImporter(config)
-	if err := i.Import(); err != nil {
-		return err
+	cp, err := loadCheckpoint(cmd.checkpointPath)
+	if err != nil {
+		return fmt.Errorf("load checkpoint error: %v", err)
 	}
-	return nil
+	cmd.cp = cp
+	if cmd.dryRun = audit.FromCommand(cmd.cobraCmd).DryRun; cmd.dryRun && cp != nil {
+		cp.readOnly = true
+	}
+	ctx := context.Background()
+	if cmd.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.deadline)
+		defer cancel()
+	}
+	cmd.ctx = ctx
+	if cmd.format == fileFormatTSMBlocks {
+		return cmd.importBlocks()
+	}
+	if cmd.format == fileFormatCSV && cmd.targetDir != "" {
+		return cmd.importCSVOffline()
+	}
+	if cmd.backupPath != "" {
+		return cmd.importBackup()
+	}
+	if cmd.dir != "" {
+		return cmd.importDir()
+	}
+	return cmd.importFile()
+}
+
+// matchDatabase reports whether the database and retention policy should be imported, an empty rp matches any.
+func (cmd *command) matchDatabase(db, rp string) bool {
+	if db == "_internal" || (cmd.database != "" && db != cmd.database) {
+		return false
+	}
+	return rp == "" || cmd.retentionPolicy == "" || rp == cmd.retentionPolicy
+}
+
+func (cmd *command) matchMeasurement(m string) bool {
+	if len(cmd.measurement) == 0 && len(cmd.regexpMeasurement) == 0 {
+		return true
+	}
+	if _, ok := cmd.measurement[m]; ok {
+		return true
+	}
+	for _, rem := range cmd.regexpMeasurement {
+		if rem.MatchString(m) {
+			return true
+		}
+	}
+	return false
 }
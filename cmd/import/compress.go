@@ -0,0 +1,37 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// decompress returns the reader of r decompressed by the compression detected from its magic bytes, which is gzip,
+// zstd or snappy framing format as compressed by export, or gzip if compressed given. The reader must be closed.
+func (cmd *command) decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(snappyMagic))
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, snappyMagic):
+		return io.NopCloser(s2.NewReader(br)), nil
+	case bytes.HasPrefix(magic, gzipMagic) || cmd.compressed:
+		return gzip.NewReader(br)
+	}
+	return io.NopCloser(br), nil
+}
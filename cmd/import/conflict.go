@@ -0,0 +1,134 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+const conflictOverwrite = "overwrite"
+
+type pointKey struct {
+	series string
+	ts     int64
+}
+
+// existChecker finds the lines of points already existing in the target, by querying each measurement of a batch
+// within the time window of the batch before writing. A nil existChecker finds nothing, which means to overwrite.
+type existChecker struct {
+	ctx    context.Context
+	client *client.Client
+	policy string
+}
+
+func newExistChecker(ctx context.Context, c *client.Client, policy string) *existChecker {
+	if policy == conflictOverwrite {
+		return nil
+	}
+	return &existChecker{ctx: ctx, client: c, policy: policy}
+}
+
+// Filter returns the lines not existing in the target, filtered in place. It returns an error
+// if any line exists with the error policy. Lines without timestamp are never found existing.
+func (ec *existChecker) Filter(db, rp string, lines []string) ([]string, error) {
+	if ec == nil || len(lines) == 0 {
+		return lines, nil
+	}
+	type window struct{ min, max int64 }
+	windows := make(map[string]*window)
+	keys := make([]pointKey, len(lines))
+	for i, line := range lines {
+		key, rest := splitKey(line)
+		ts, ok := lineTime(rest)
+		if !ok {
+			continue
+		}
+		name, tags := models.ParseKeyBytes([]byte(key))
+		keys[i] = pointKey{string(models.MakeKey(name, tags)), ts}
+		if w, ok := windows[string(name)]; !ok {
+			windows[string(name)] = &window{ts, ts}
+		} else if ts < w.min {
+			w.min = ts
+		} else if ts > w.max {
+			w.max = ts
+		}
+	}
+
+	existing := make(map[pointKey]struct{})
+	for m, w := range windows {
+		stmt := fmt.Sprintf("SELECT * FROM %s WHERE time >= %d AND time <= %d GROUP BY *", influxql.QuoteIdent(rp, m), w.min, w.max)
+		resp, err := ec.client.QueryContext(ec.ctx, client.Query{Command: stmt, Database: db, Chunked: true})
+		if err == nil {
+			err = resp.Error()
+		}
+		if err != nil {
+			if strings.Contains(err.Error(), "database not found") {
+				return lines, nil
+			}
+			return nil, fmt.Errorf("execute '%s' error: %v", stmt, err)
+		}
+		for _, result := range resp.Results {
+			for _, row := range result.Series {
+				series := string(models.MakeKey([]byte(row.Name), models.NewTags(row.Tags)))
+				for _, v := range row.Values {
+					if len(v) == 0 {
+						continue
+					}
+					s, _ := v[0].(string)
+					if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+						existing[pointKey{series, t.UnixNano()}] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+	if len(existing) == 0 {
+		return lines, nil
+	}
+
+	n, first := 0, ""
+	kept := lines[:0]
+	for i, line := range lines {
+		if _, ok := existing[keys[i]]; ok {
+			if n == 0 {
+				first = line
+			}
+			n++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if n > 0 && ec.policy == conflictError {
+		return nil, fmt.Errorf("%d points already exist in %s.%s, such as: %s", n, db, rp, first)
+	}
+	return kept, nil
+}
+
+// splitKey splits a line protocol into the series key and the rest at the first unescaped space.
+func splitKey(line string) (string, string) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ' ':
+			return line[:i], line[i+1:]
+		}
+	}
+	return line, ""
+}
+
+// lineTime parses the timestamp in nanoseconds at the end of fields and timestamp of a line protocol.
+func lineTime(rest string) (int64, bool) {
+	i := strings.LastIndexByte(rest, ' ')
+	if i < 0 {
+		return 0, false
+	}
+	ts, err := strconv.ParseInt(rest[i+1:], 10, 64)
+	return ts, err == nil
+}
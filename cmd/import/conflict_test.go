@@ -0,0 +1,26 @@
+package importer
+
+import (
+	"testing"
+)
+
+func TestSplitKey(t *testing.T) {
+	tests := []struct {
+		line string
+		key  string
+		ts   int64
+		ok   bool
+	}{
+		{"cpu value=1 10", "cpu", 10, true},
+		{`c\ pu,host=a\ b value="x y" 20`, `c\ pu,host=a\ b`, 20, true},
+		{"cpu value=1", "cpu", 0, false},
+		{`cpu value="a 1"`, "cpu", 0, false},
+	}
+	for _, tt := range tests {
+		key, rest := splitKey(tt.line)
+		ts, ok := lineTime(rest)
+		if key != tt.key || ts != tt.ts || ok != tt.ok {
+			t.Errorf("%q: got=%q %d %v, exp=%q %d %v", tt.line, key, ts, ok, tt.key, tt.ts, tt.ok)
+		}
+	}
+}
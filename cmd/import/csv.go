@@ -0,0 +1,641 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+const (
+	csvTimeRFC3339 = "rfc3339"
+	csvTimeUnix    = "unix"
+	csvTimeUnixMs  = "unix_ms"
+	csvTimeUnixUs  = "unix_us"
+	csvTimeUnixNs  = "unix_ns"
+)
+
+const (
+	csvTypeFloat    = "float"
+	csvTypeInteger  = "integer"
+	csvTypeUnsigned = "unsigned"
+	csvTypeBoolean  = "boolean"
+	csvTypeString   = "string"
+)
+
+// csvDatatypes are the field types of the datatype annotations of the annotated csv of influxdb 2.x.
+var csvDatatypes = map[string]string{
+	"double":       csvTypeFloat,
+	"long":         csvTypeInteger,
+	"unsignedLong": csvTypeUnsigned,
+	"boolean":      csvTypeBoolean,
+	"string":       csvTypeString,
+}
+
+// csvMaxLogged is the number of rows skipped logged of a file, the others are counted only.
+const csvMaxLogged = 10
+
+var csvNumber = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// csvOptions are the mapping of the columns of plain csv to points, and the formats of the cells.
+type csvOptions struct {
+	delimiter         string
+	measurement       string
+	measurementColumn string
+	timeColumn        string
+	timeFormat        string
+	timezone          string
+	tagColumns        []string
+	fieldColumns      []string
+	fieldTypes        map[string]string // field types by column, inferred for the others
+	decimal           string
+	thousands         string
+
+	location *time.Location
+}
+
+// validate validates the options, and parses the field types of "column=type".
+func (o *csvOptions) validate(fieldTypes []string) error {
+	if len([]rune(o.delimiter)) != 1 || o.delimiter == "\n" || o.delimiter == "\r" || o.delimiter == "\"" {
+		return errors.New("csv delimiter is invalid, require a single character")
+	}
+	if o.decimal != "." && o.decimal != "," {
+		return errors.New("csv decimal separator is invalid, require . or ,")
+	}
+	switch o.thousands {
+	case "", ".", ",", " ", "'":
+	default:
+		return errors.New("csv thousands separator is invalid, require none, ., ,, space or '")
+	}
+	if o.thousands == o.decimal {
+		return errors.New("csv thousands separator must differ from decimal separator")
+	}
+	if o.measurement != "" && o.measurementColumn != "" {
+		return errors.New("csv measurement and csv measurement column cannot be specified together")
+	}
+	if o.timeColumn == "" {
+		return errors.New("csv time column is invalid")
+	}
+	loc, err := time.LoadLocation(o.timezone)
+	if err != nil {
+		return fmt.Errorf("csv timezone is invalid: %v", err)
+	}
+	o.location = loc
+	o.fieldTypes = make(map[string]string)
+	for _, value := range fieldTypes {
+		col, typ, ok := strings.Cut(value, "=")
+		switch typ {
+		case csvTypeFloat, csvTypeInteger, csvTypeUnsigned, csvTypeBoolean, csvTypeString:
+		default:
+			ok = false
+		}
+		if !ok || col == "" {
+			return fmt.Errorf("csv field type is invalid: %s, require column=type of float, integer, unsigned, boolean or string", value)
+		}
+		o.fieldTypes[col] = typ
+	}
+	return nil
+}
+
+// parseTime returns the timestamp of the cell in the time format, a layout without zone is in the timezone.
+func (o *csvOptions) parseTime(s string) (int64, error) {
+	var mul int64
+	switch o.timeFormat {
+	case csvTimeRFC3339:
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return 0, err
+		}
+		return t.UnixNano(), nil
+	case csvTimeUnix:
+		mul = int64(time.Second)
+	case csvTimeUnixMs:
+		mul = int64(time.Millisecond)
+	case csvTimeUnixUs:
+		mul = int64(time.Microsecond)
+	case csvTimeUnixNs:
+		mul = 1
+	default:
+		t, err := time.ParseInLocation(o.timeFormat, s, o.location)
+		if err != nil {
+			return 0, err
+		}
+		return t.UnixNano(), nil
+	}
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ts > math.MaxInt64/mul || ts < math.MinInt64/mul {
+		return 0, fmt.Errorf("timestamp %s out of range", s)
+	}
+	return ts * mul, nil
+}
+
+// number returns the cell as a number of the C locale, with the thousands separators removed and the decimal separator
+// replaced by a point, or false if not a number, such as the digits misgrouped by the thousands separator.
+func (o *csvOptions) number(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if o.thousands != "" && strings.Contains(s, o.thousands) {
+		// the digits are grouped by three before the decimal separator, such as 1.234.567,8 but not 1.5
+		integer, _, _ := strings.Cut(s, o.decimal)
+		groups := strings.Split(strings.TrimLeft(integer, "+-"), o.thousands)
+		for i, g := range groups {
+			if (i == 0 && (len(g) < 1 || len(g) > 3)) || (i > 0 && len(g) != 3) {
+				return "", false
+			}
+		}
+		s = strings.ReplaceAll(s, o.thousands, "")
+	}
+	if o.decimal != "." {
+		// a point is neither the decimal nor the thousands separator then
+		if strings.Contains(s, ".") {
+			return "", false
+		}
+		s = strings.ReplaceAll(s, o.decimal, ".")
+	}
+	return s, csvNumber.MatchString(s)
+}
+
+// fieldValue returns the value of the cell as the field type, or inferred if empty: a float if a number, a boolean
+// if true or false, or a string otherwise.
+func (o *csvOptions) fieldValue(s, typ string) (interface{}, error) {
+	switch typ {
+	case "":
+		if n, ok := o.number(s); ok {
+			return strconv.ParseFloat(n, 64)
+		}
+		if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+			return b, nil
+		}
+		return s, nil
+	case csvTypeFloat:
+		if n, ok := o.number(s); ok {
+			return strconv.ParseFloat(n, 64)
+		}
+	case csvTypeInteger:
+		if n, ok := o.number(s); ok {
+			return strconv.ParseInt(n, 10, 64)
+		}
+	case csvTypeUnsigned:
+		if n, ok := o.number(s); ok {
+			return strconv.ParseUint(n, 10, 64)
+		}
+	case csvTypeBoolean:
+		return strconv.ParseBool(strings.TrimSpace(s))
+	case csvTypeString:
+		return s, nil
+	}
+	return nil, fmt.Errorf("%q is not a %s", s, typ)
+}
+
+// csvTable is the columns of the rows following a header, the annotations of annotated csv apply to its columns.
+type csvTable struct {
+	header    []string
+	datatypes []string // datatype annotations by column, nil if none
+	defaults  []string // default annotations by column, nil if none
+	annotated bool
+
+	time, measurement int // column indexes, -1 if missing
+	field, value      int // column indexes of annotated csv
+	tags              []int
+	fields            []int // field column indexes of plain csv
+}
+
+// csvReader reads the points of the rows of a plain or annotated csv file.
+type csvReader struct {
+	opts        *csvOptions
+	path        string
+	measurement string // measurement of plain csv without measurement column
+	cr          *csv.Reader
+	table       *csvTable
+	datatypes   []string // annotations read before the next header
+	defaults    []string
+	lastLine    int // line of the end of the last record
+	skipped     int
+}
+
+func (cmd *command) newCSVReader(path string, r io.Reader) *csvReader {
+	cr := csv.NewReader(r)
+	cr.Comma = []rune(cmd.csv.delimiter)[0]
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	measurement := cmd.csv.measurement
+	if measurement == "" {
+		// such as cpu.csv.gz exported from a spreadsheet
+		measurement, _, _ = strings.Cut(filepath.Base(path), ".")
+	}
+	return &csvReader{opts: &cmd.csv, path: path, measurement: measurement, cr: cr}
+}
+
+// walk calls fn with the point of each row, the rows not parsable are logged and skipped, and an error returned by
+// fn stops reading.
+func (r *csvReader) walk(fn func(pt models.Point) error) error {
+	defer func() {
+		if r.skipped > csvMaxLogged {
+			log.Printf("%s: %d rows skipped in total", r.path, r.skipped)
+		}
+	}()
+	for {
+		record, err := r.cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := r.cr.FieldPos(0)
+		// a new table of annotated csv follows an empty line, which is skipped by the csv reader
+		gap := line > r.lastLine+1
+		r.lastLine = line
+		for _, cell := range record {
+			r.lastLine += strings.Count(cell, "\n")
+		}
+
+		// the annotations start a table with the header following, the other comments are skipped
+		switch {
+		case record[0] == "#datatype":
+			r.datatypes, r.table = record, nil
+			continue
+		case record[0] == "#default":
+			r.defaults, r.table = record, nil
+			continue
+		case record[0] == "#group":
+			r.table = nil
+			continue
+		case strings.HasPrefix(record[0], "#"):
+			continue
+		}
+		if r.table == nil || (gap && r.table.annotated) {
+			if r.table, err = r.newTable(record); err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			r.datatypes, r.defaults = nil, nil
+			continue
+		}
+		pt, err := r.table.point(r, record)
+		if err != nil {
+			if r.skipped++; r.skipped <= csvMaxLogged {
+				log.Printf("%s:%d: %v, row skipped", r.path, line, err)
+			}
+			continue
+		}
+		if pt == nil {
+			continue
+		}
+		if err = fn(pt); err != nil {
+			return err
+		}
+	}
+}
+
+// newTable returns the table of the header, which is of annotated csv if it has _field and _value columns or the
+// annotations before it.
+func (r *csvReader) newTable(header []string) (*csvTable, error) {
+	header = append([]string(nil), header...)
+	t := &csvTable{header: header, datatypes: r.datatypes, defaults: r.defaults, time: -1, measurement: -1, field: -1, value: -1}
+	index := make(map[string]int, len(header))
+	for i, col := range header {
+		if _, ok := index[col]; !ok {
+			index[col] = i
+		}
+	}
+	_, hasField := index["_field"]
+	_, hasValue := index["_value"]
+	t.annotated = r.datatypes != nil || r.defaults != nil || (hasField && hasValue)
+	if t.annotated {
+		for i, col := range header {
+			switch col {
+			case "_time":
+				t.time = i
+			case "_measurement":
+				t.measurement = i
+			case "_field":
+				t.field = i
+			case "_value":
+				t.value = i
+			case "", "result", "table":
+			default:
+				// such as _start and _stop of query results
+				if !strings.HasPrefix(col, "_") {
+					t.tags = append(t.tags, i)
+				}
+			}
+		}
+		if t.time < 0 || t.field < 0 || t.value < 0 {
+			return nil, errors.New("annotated csv header requires _time, _field and _value columns")
+		}
+		return t, nil
+	}
+
+	opts := r.opts
+	column := func(col string) (int, error) {
+		i, ok := index[col]
+		if !ok {
+			return 0, fmt.Errorf("column %q not found in header", col)
+		}
+		return i, nil
+	}
+	var err error
+	if t.time, err = column(opts.timeColumn); err != nil {
+		return nil, err
+	}
+	used := map[int]bool{t.time: true}
+	if opts.measurementColumn != "" {
+		if t.measurement, err = column(opts.measurementColumn); err != nil {
+			return nil, err
+		}
+		used[t.measurement] = true
+	}
+	for _, col := range opts.tagColumns {
+		i, err := column(col)
+		if err != nil {
+			return nil, err
+		}
+		t.tags = append(t.tags, i)
+		used[i] = true
+	}
+	if len(opts.fieldColumns) > 0 {
+		for _, col := range opts.fieldColumns {
+			i, err := column(col)
+			if err != nil {
+				return nil, err
+			}
+			t.fields = append(t.fields, i)
+		}
+	} else {
+		for i, col := range header {
+			if !used[i] && col != "" {
+				t.fields = append(t.fields, i)
+			}
+		}
+	}
+	if len(t.fields) == 0 {
+		return nil, errors.New("no field column in header")
+	}
+	return t, nil
+}
+
+// cell returns the cell of the column, or its default annotation if empty.
+func (t *csvTable) cell(record []string, i int) string {
+	var s string
+	if i < len(record) {
+		s = record[i]
+	}
+	if s == "" && i < len(t.defaults) {
+		s = t.defaults[i]
+	}
+	return s
+}
+
+// point returns the point of the row, or nil if it has no field value.
+func (t *csvTable) point(r *csvReader, record []string) (models.Point, error) {
+	opts := r.opts
+	var ts int64
+	var err error
+	timeCell := t.cell(record, t.time)
+	if timeCell == "" {
+		return nil, errors.New("time is empty")
+	}
+	if t.annotated {
+		ts, err = parseAnnotatedTime(timeCell, t.datatype(t.time))
+	} else {
+		ts, err = opts.parseTime(timeCell)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("time is invalid: %v", err)
+	}
+	name := r.measurement
+	if t.measurement >= 0 {
+		if name = t.cell(record, t.measurement); name == "" {
+			return nil, errors.New("measurement is empty")
+		}
+	}
+	tags := make(map[string]string, len(t.tags))
+	for _, i := range t.tags {
+		if v := t.cell(record, i); v != "" {
+			tags[t.header[i]] = v
+		}
+	}
+
+	fields := make(map[string]interface{})
+	if t.annotated {
+		field, value := t.cell(record, t.field), t.cell(record, t.value)
+		if value == "" {
+			return nil, nil
+		}
+		typ := csvDatatypes[t.datatype(t.value)]
+		if fields[field], err = opts.fieldValue(value, typ); err != nil {
+			return nil, fmt.Errorf("field %s: %v", field, err)
+		}
+	} else {
+		for _, i := range t.fields {
+			value := t.cell(record, i)
+			if value == "" {
+				continue
+			}
+			field := t.header[i]
+			if fields[field], err = opts.fieldValue(value, opts.fieldTypes[field]); err != nil {
+				return nil, fmt.Errorf("field %s: %v", field, err)
+			}
+		}
+		if len(fields) == 0 {
+			return nil, nil
+		}
+	}
+	return models.NewPoint(name, models.NewTags(tags), fields, time.Unix(0, ts))
+}
+
+// datatype returns the datatype annotation of the column, empty if none.
+func (t *csvTable) datatype(i int) string {
+	if i < len(t.datatypes) {
+		return t.datatypes[i]
+	}
+	return ""
+}
+
+// parseAnnotatedTime returns the timestamp of the time cell of annotated csv, in nanoseconds for dateTime:number or
+// long datatype, or RFC3339 otherwise.
+func parseAnnotatedTime(s, datatype string) (int64, error) {
+	if datatype == "dateTime:number" || datatype == "long" {
+		return strconv.ParseInt(s, 10, 64)
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano(), nil
+}
+
+// walkCSV reads the csv file from r, creating the database by ddl if not nil and calling dml for the line of the
+// point of each row with the database and retention policy given.
+func (cmd *command) walkCSV(path string, r io.Reader, ddl func(db, stmt string), dml func(db, rp, line string) error) error {
+	if ddl != nil {
+		ddl("", "CREATE DATABASE "+influxql.QuoteIdent(cmd.database))
+	}
+	return cmd.newCSVReader(path, r).walk(func(pt models.Point) error {
+		return dml(cmd.database, cmd.retentionPolicy, pt.String())
+	})
+}
+
+// importCSVOffline writes the points of the csv file into the shards of the offline target dir, the points are loaded
+// into memory to be written in the order of series keys per shard group.
+func (cmd *command) importCSVOffline() error {
+	log.SetFlags(log.LstdFlags)
+	start := time.Now().UTC()
+	defer func() {
+		elapsed := time.Since(start)
+		if elapsed.Minutes() > 10 {
+			log.Printf("total time: %0.1f minutes", elapsed.Minutes())
+		} else {
+			log.Printf("total time: %0.1f seconds", elapsed.Seconds())
+		}
+	}()
+
+	f, err := os.Open(cmd.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := cmd.decompress(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	sd := cmd.shardDuration
+	groups := make(map[int64]map[string]tsm1.Values) // values by series field key by shard group start
+	points := 0
+	err = cmd.newCSVReader(cmd.path, r).walk(func(pt models.Point) error {
+		ts := pt.UnixNano()
+		sg := time.Unix(0, ts).Truncate(sd).UnixNano()
+		if groups[sg] == nil {
+			groups[sg] = make(map[string]tsm1.Values)
+		}
+		iter := pt.FieldIterator()
+		for iter.Next() {
+			key := string(tsm1.SeriesFieldKeyBytes(string(pt.Key()), string(iter.FieldKey())))
+			var v tsm1.Value
+			switch iter.Type() {
+			case models.Float:
+				fv, _ := iter.FloatValue()
+				v = tsm1.NewFloatValue(ts, fv)
+			case models.Integer:
+				iv, _ := iter.IntegerValue()
+				v = tsm1.NewIntegerValue(ts, iv)
+			case models.Unsigned:
+				uv, _ := iter.UnsignedValue()
+				v = tsm1.NewUnsignedValue(ts, uv)
+			case models.Boolean:
+				bv, _ := iter.BooleanValue()
+				v = tsm1.NewBooleanValue(ts, bv)
+			default:
+				v = tsm1.NewStringValue(ts, iter.StringValue())
+			}
+			groups[sg][key] = append(groups[sg][key], v)
+		}
+		points++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", cmd.path, err)
+	}
+	rp := cmd.retentionPolicy
+	if rp == "" {
+		rp = "autogen"
+	}
+	if cmd.dryRun {
+		if err := shard.ValidateRetentionPolicy(cmd.targetDir, cmd.database, rp, sd, 0, cmd.adoptRP); err != nil {
+			return err
+		}
+		log.Printf("dry run: %d points in %d shard groups to be imported into %s, nothing written", points, len(groups), cmd.targetDir)
+		return nil
+	}
+
+	svr, err := server.NewServer(cmd.targetDir, !cmd.skipTsi)
+	if err != nil {
+		return fmt.Errorf("create server error: %s", err)
+	}
+	defer svr.Close()
+	imp, err := shard.NewImporter(svr, cmd.database, rp, sd, 0, !cmd.skipTsi, cmd.adoptRP)
+	if err != nil {
+		imp.Close()
+		return err
+	}
+	el := errlist.NewErrorList()
+	for _, sg := range sortedStarts(groups) {
+		el.Add(writeShardGroup(imp, sg, sg+int64(sd), groups[sg]))
+		if el.Err() != nil {
+			break
+		}
+	}
+	el.Add(imp.Close())
+	if err = el.Err(); err != nil {
+		return err
+	}
+	log.Printf("imported %d points in %d shard groups into %s.%s", points, len(groups), cmd.database, rp)
+	return nil
+}
+
+func sortedStarts(groups map[int64]map[string]tsm1.Values) []int64 {
+	starts := make([]int64, 0, len(groups))
+	for sg := range groups {
+		starts = append(starts, sg)
+	}
+	slices.Sort(starts)
+	return starts
+}
+
+// writeShardGroup writes the values of the series field keys into the shard group from start to end, the values of a
+// key are sorted and deduplicated with the value read last kept for each timestamp.
+func writeShardGroup(imp *shard.Importer, start, end int64, values map[string]tsm1.Values) error {
+	iw := shard.NewImportWorker(imp)
+	if err := iw.StartShardGroup(start, end); err != nil {
+		return err
+	}
+	defer iw.Close()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	var lastSeries []byte
+	for _, key := range keys {
+		if seriesKey, _ := tsm1.SeriesAndFieldFromCompositeKey([]byte(key)); !bytes.Equal(seriesKey, lastSeries) {
+			if err := iw.AddSeries(seriesKey); err != nil {
+				return err
+			}
+			lastSeries = seriesKey
+		}
+		vs := values[key].Deduplicate()
+		for len(vs) > 0 {
+			n := len(vs)
+			if n > tsdb.DefaultMaxPointsPerBlock {
+				n = tsdb.DefaultMaxPointsPerBlock
+			}
+			if err := iw.Write([]byte(key), vs[:n]); err != nil {
+				return err
+			}
+			vs = vs[n:]
+		}
+	}
+	return iw.CloseShardGroup()
+}
@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWalkCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  csvOptions
+		types []string
+		input string
+		exp   []string
+	}{
+		{
+			name:  "plain",
+			opts:  csvOptions{tagColumns: []string{"host"}},
+			types: []string{"count=integer"},
+			input: "time,host,usage,count,ok,msg\n" +
+				"2024-01-01T00:00:00Z,a b,0.5,3,true,hello\n" +
+				"2024-01-01T00:00:01Z,,1e3,,,\n" +
+				"bad,a,1,1,,\n" +
+				"2024-01-01T00:00:02Z,a,,,,\n" +
+				"# comment\n" +
+				"2024-01-01T00:00:03Z,a,x,1.5,,\n",
+			exp: []string{
+				`cpu,host=a\ b count=3i,msg="hello",ok=true,usage=0.5 1704067200000000000`,
+				`cpu usage=1000 1704067201000000000`,
+			},
+		},
+		{
+			name: "locale",
+			opts: csvOptions{delimiter: ";", decimal: ",", thousands: ".", measurementColumn: "name", timeColumn: "Zeit",
+				timeFormat: "02.01.2006 15:04", timezone: "Europe/Berlin"},
+			input: "Zeit;name;Wert\n" +
+				"01.07.2024 12:00;temp;1.234,5\n" +
+				"01.07.2024 12:01;temp;\"-0,25\"\n" +
+				"01.07.2024 12:02;temp;1.5.\n",
+			exp: []string{
+				`temp Wert=1234.5 1719828000000000000`,
+				`temp Wert=-0.25 1719828060000000000`,
+				`temp Wert="1.5." 1719828120000000000`,
+			},
+		},
+		{
+			name: "annotated",
+			opts: csvOptions{timeFormat: csvTimeUnix},
+			input: "#group,false,false,false,false,true,true,true\n" +
+				"#datatype,string,long,dateTime:RFC3339,long,string,string,string\n" +
+				"#default,_result,,,,,,a\n" +
+				",result,table,_time,_value,_field,_measurement,host\n" +
+				",,0,2024-01-01T00:00:00Z,7,count,cpu,\n" +
+				",,0,2024-01-01T00:00:01Z,,count,cpu,b\n" +
+				"\n" +
+				",result,table,_time,_value,_field,_measurement\n" +
+				",,1,2024-01-01T00:00:02Z,0.5,usage,mem\n",
+			exp: []string{
+				`cpu,host=a count=7i 1704067200000000000`,
+				`mem usage=0.5 1704067202000000000`,
+			},
+		},
+		{
+			name:  "unix",
+			opts:  csvOptions{measurement: "m", timeColumn: "ts", timeFormat: csvTimeUnixMs, fieldColumns: []string{"v"}},
+			input: "ts,v,other\n1500,10,x\n",
+			exp:   []string{`m v=10 1500000000`},
+		},
+	}
+	for _, tt := range tests {
+		cmd := &command{database: "db", csv: tt.opts}
+		o := &cmd.csv
+		if o.delimiter == "" {
+			o.delimiter = ","
+		}
+		if o.decimal == "" {
+			o.decimal = "."
+		}
+		if o.timeColumn == "" {
+			o.timeColumn = "time"
+		}
+		if o.timeFormat == "" {
+			o.timeFormat = csvTimeRFC3339
+		}
+		if o.timezone == "" {
+			o.timezone = "UTC"
+		}
+		if err := o.validate(tt.types); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var stmts, lines []string
+		err := cmd.walkCSV("/tmp/cpu.csv", strings.NewReader(tt.input), func(db, stmt string) {
+			stmts = append(stmts, stmt)
+		}, func(db, rp, line string) error {
+			lines = append(lines, line)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !cmp.Equal(stmts, []string{"CREATE DATABASE db"}) {
+			t.Errorf("%s: unexpected statements: %v", tt.name, stmts)
+		}
+		if !cmp.Equal(lines, tt.exp) {
+			t.Errorf("%s: unexpected lines: %s", tt.name, cmp.Diff(tt.exp, lines))
+		}
+	}
+}
+
+func TestCSVNumber(t *testing.T) {
+	o := &csvOptions{decimal: ",", thousands: " "}
+	for s, exp := range map[string]string{
+		"1 234,5":  "1234.5",
+		" -3 ":     "-3",
+		",5":       ".5",
+		"1.5":      "",
+		"1,2,3":    "",
+		"NaN":      "",
+		"1e-3":     "1e-3",
+		"12 34":    "",
+		"-123 456": "-123456",
+	} {
+		n, ok := o.number(s)
+		if ok != (exp != "") || (ok && n != exp) {
+			t.Errorf("number(%q): got %q %v, expected %q", s, n, ok, exp)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errDMLReached = errors.New("dml reached")
+
+// importDir imports the files in a directory concurrently, such as exports split by measurement. The DDL of all
+// files is executed once before writing, and all workers share the throttles and the progress.
+func (cmd *command) importDir() error {
+	paths, err := listFiles(cmd.dir)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no file found in %s", cmd.dir)
+	}
+	c, err := cmd.newClient()
+	if err != nil {
+		return err
+	}
+
+	log.SetFlags(log.LstdFlags)
+	start := time.Now().UTC()
+	defer func() {
+		elapsed := time.Since(start)
+		if elapsed.Minutes() > 10 {
+			log.Printf("total time: %0.1f minutes", elapsed.Minutes())
+		} else {
+			log.Printf("total time: %0.1f seconds", elapsed.Seconds())
+		}
+	}()
+
+	executed := make(map[string]struct{})
+	for _, path := range paths {
+		err = cmd.walkFile(path, func(db, stmt string) {
+			if _, ok := executed[db+"\n"+stmt]; ok {
+				return
+			}
+			executed[db+"\n"+stmt] = struct{}{}
+			if err := cmd.execute(c, db, stmt); err != nil {
+				log.Printf("error: %s", err)
+			}
+		}, func(db, rp, line string) error {
+			return errDMLReached
+		})
+		if err != nil && err != errDMLReached {
+			return fmt.Errorf("reading %s: %s", path, err)
+		}
+	}
+	log.Printf("processed %d commands of %d files", len(executed), len(paths))
+
+	sc, err := cmd.checkFiles(c, paths)
+	if err != nil {
+		return err
+	}
+
+	p := cmd.newProgress()
+	var done, failed int64
+	limit := make(chan struct{}, cmd.worker)
+	wg := &sync.WaitGroup{}
+	for _, path := range paths {
+		wg.Add(1)
+		path := path
+		go func() {
+			limit <- struct{}{}
+			defer func() {
+				wg.Done()
+				<-limit
+			}()
+
+			if err := cmd.writeFile(path, nil, cmd.newBatchWriter(c, p), sc); err != nil {
+				log.Printf("import %s error: %v", path, err)
+				atomic.AddInt64(&failed, 1)
+				return
+			}
+			log.Printf("file %s imported, %d/%d done", path, atomic.AddInt64(&done, 1), len(paths))
+		}()
+	}
+	wg.Wait()
+
+	sc.Report()
+	err = p.Done()
+	if failed > 0 {
+		cmd.saveCheckpoint()
+		return fmt.Errorf("%d of %d files failed to import", failed, len(paths))
+	}
+	if rerr := cmd.cp.Remove(); rerr != nil {
+		log.Printf("remove checkpoint error: %v", rerr)
+	}
+	return err
+}
+
+// listFiles returns the regular files in the directory and its subdirectories sorted by path, hidden files and
+// directories are ignored.
+func listFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
@@ -0,0 +1,230 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client"
+)
+
+// importFile imports a file written by export or influx_inspect export, which consists of
+// a DDL section of statements and a DML section of lines with context comments.
+func (cmd *command) importFile() error {
+	c, err := cmd.newClient()
+	if err != nil {
+		return err
+	}
+
+	log.SetFlags(log.LstdFlags)
+	start := time.Now().UTC()
+	defer func() {
+		elapsed := time.Since(start)
+		if elapsed.Minutes() > 10 {
+			log.Printf("total time: %0.1f minutes", elapsed.Minutes())
+		} else {
+			log.Printf("total time: %0.1f seconds", elapsed.Seconds())
+		}
+	}()
+
+	sc, err := cmd.checkFiles(c, []string{cmd.path})
+	if err != nil {
+		return err
+	}
+	commands := 0
+	p := cmd.newProgress()
+	err = cmd.writeFile(cmd.path, func(db, stmt string) {
+		commands++
+		if err := cmd.execute(c, db, stmt); err != nil {
+			log.Printf("error: %s", err)
+		}
+	}, cmd.newBatchWriter(c, p), sc)
+	if err != nil {
+		cmd.saveCheckpoint()
+		return err
+	}
+	if err = cmd.cp.Remove(); err != nil {
+		log.Printf("remove checkpoint error: %v", err)
+	}
+	sc.Report()
+	log.Printf("processed %d commands", commands)
+	return p.Done()
+}
+
+// saveCheckpoint saves the lines consumed of each file to resume an interrupted import, if checkpoint given.
+func (cmd *command) saveCheckpoint() {
+	if cmd.cp == nil || cmd.cp.readOnly {
+		return
+	}
+	if err := cmd.cp.Save(); err != nil {
+		log.Printf("save checkpoint error: %v", err)
+		return
+	}
+	log.Printf("checkpoint saved to %s, rerun with the same checkpoint to resume", cmd.checkpointPath)
+}
+
+// checkFiles checks the schema of the files against the target if required, or returns a nil schema.
+func (cmd *command) checkFiles(c *client.Client, paths []string) (*schema, error) {
+	if !cmd.checkSchema {
+		return nil, nil
+	}
+	sc := newSchema(cmd.typeConflict)
+	for _, path := range paths {
+		err := cmd.walkFile(path, nil, func(db, rp, line string) error {
+			sc.ObserveLine(db, line)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %s", path, err)
+		}
+	}
+	return sc, sc.Check(c)
+}
+
+// writeFile writes the lines of the file by the batch writer, and executes the statements by ddl if not nil.
+// The lines consumed by a previous import are skipped, and the lines consumed are recorded in checkpoint.
+func (cmd *command) writeFile(path string, ddl func(db, stmt string), bw *batchWriter, sc *schema) error {
+	skip := cmd.cp.Skip(path)
+	if skip > 0 {
+		log.Printf("resuming %s after %d lines imported", path, skip)
+		// the deletes executed on a database would delete the lines imported if executed again
+		if exec := ddl; exec != nil {
+			ddl = func(db, stmt string) {
+				if db == "" {
+					exec(db, stmt)
+				}
+			}
+		}
+	}
+	bw.offset = skip
+	var n int64
+	err := cmd.walkFile(path, ddl, func(db, rp, line string) error {
+		if n++; n <= skip {
+			return nil
+		}
+		line, ok := sc.FixLine(db, line)
+		if !ok {
+			bw.Skip()
+			return nil
+		}
+		bw.SetContext(db, rp)
+		bw.Add(line)
+		return bw.err
+	})
+	bw.Flush()
+	cmd.cp.Set(path, bw.offset)
+	if bw.err != nil {
+		return bw.err
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", path, err)
+	}
+	return nil
+}
+
+// newClient returns the client of the server connected, or nil on a dry run which needs no server unless checking the
+// schema or the points existing against it.
+func (cmd *command) newClient() (*client.Client, error) {
+	if cmd.dryRun && !cmd.checkSchema && cmd.onConflict == conflictOverwrite {
+		return nil, nil
+	}
+	c, err := client.NewClient(cmd.clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create client: %s", err)
+	}
+	if _, _, err = c.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %s", c.Addr(), err)
+	}
+	return c, nil
+}
+
+// walkFile reads the file, which is decompressed if compressed given or detected as compressed, calling ddl for each statement in the DDL section and dml for each line in the
+// DML section with the database and retention policy given by the latest context comments, the statements following
+// a context comment of database in the DDL section, such as the deletes exported, are executed on the database. The timestamps of lines are
+// converted to nanoseconds by the precision of the latest context comment. A nil ddl skips the statements,
+// and an error returned by dml stops reading. A file of csv format is read by walkCSV instead.
+func (cmd *command) walkFile(path string, ddl func(db, stmt string), dml func(db, rp, line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := cmd.decompress(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if cmd.format == fileFormatCSV {
+		return cmd.walkCSV(path, r, ddl, dml)
+	}
+
+	br := bufio.NewReader(r)
+	inDML := false
+	db, rp := "", ""
+	var mul int64 = 1
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		switch {
+		case !inDML && strings.HasPrefix(line, "# DML"):
+			inDML = true
+		case strings.HasPrefix(line, "# CONTEXT-DATABASE:"):
+			db = strings.TrimSpace(strings.TrimPrefix(line, "# CONTEXT-DATABASE:"))
+		case inDML && strings.HasPrefix(line, "# CONTEXT-RETENTION-POLICY:"):
+			rp = strings.TrimSpace(strings.TrimPrefix(line, "# CONTEXT-RETENTION-POLICY:"))
+		case inDML && strings.HasPrefix(line, "# CONTEXT-PRECISION:"):
+			p := strings.TrimSpace(strings.TrimPrefix(line, "# CONTEXT-PRECISION:"))
+			var ok bool
+			if mul, ok = precisions[p]; !ok {
+				return fmt.Errorf("precision %q is invalid", p)
+			}
+		case strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "":
+		case !inDML:
+			if ddl != nil {
+				ddl(db, strings.TrimSpace(line))
+			}
+		default:
+			line = strings.TrimRight(line, "\r\n")
+			if mul > 1 {
+				line = scaleTimestamp(line, mul)
+			}
+			if err := dml(db, rp, line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// precisions are the nanoseconds per unit of the precisions of context comments, as accepted by influx -import.
+var precisions = map[string]int64{
+	"h":  int64(time.Hour),
+	"m":  int64(time.Minute),
+	"s":  int64(time.Second),
+	"ms": int64(time.Millisecond),
+	"u":  int64(time.Microsecond),
+	"ns": 1,
+}
+
+// scaleTimestamp multiplies the trailing timestamp of the line by mul, the line is returned unchanged if it has none.
+func scaleTimestamp(line string, mul int64) string {
+	i := strings.LastIndexByte(line, ' ')
+	if i < 0 {
+		return line
+	}
+	ts, err := strconv.ParseInt(line[i+1:], 10, 64)
+	if err != nil {
+		return line
+	}
+	return line[:i+1] + strconv.FormatInt(ts*mul, 10)
+}
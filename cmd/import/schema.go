@@ -0,0 +1,352 @@
+package importer
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+const (
+	conflictError  = "error"
+	conflictCoerce = "coerce"
+	conflictSkip   = "skip"
+)
+
+// field types named as in SHOW FIELD KEYS
+const (
+	typeFloat    = "float"
+	typeInteger  = "integer"
+	typeUnsigned = "unsigned"
+	typeString   = "string"
+	typeBoolean  = "boolean"
+)
+
+type fieldKey struct {
+	db          string
+	measurement string
+	field       string
+}
+
+type measurementKey struct {
+	db          string
+	measurement string
+}
+
+// schema checks field types of the source against the target before writing, and resolves the
+// conflicts by coercing or skipping values. A nil schema resolves nothing. Once checked, it is safe for concurrent use.
+type schema struct {
+	policy       string
+	source       map[fieldKey][]string
+	target       map[fieldKey]string
+	expected     map[fieldKey]string // type of conflicting fields, which is the target type or the first one in source
+	measurements map[measurementKey]struct{}
+	coerced      int64
+	skipped      int64
+}
+
+func newSchema(policy string) *schema {
+	return &schema{
+		policy:       policy,
+		source:       make(map[fieldKey][]string),
+		target:       make(map[fieldKey]string),
+		expected:     make(map[fieldKey]string),
+		measurements: make(map[measurementKey]struct{}),
+	}
+}
+
+// Observe records a field type seen in source.
+func (s *schema) Observe(db, measurement, field, typ string) {
+	key := fieldKey{db, measurement, field}
+	for _, t := range s.source[key] {
+		if t == typ {
+			return
+		}
+	}
+	s.source[key] = append(s.source[key], typ)
+}
+
+// ObserveLine records the field types of a line protocol, unparsable lines are left to the server to reject.
+func (s *schema) ObserveLine(db, line string) {
+	points, err := models.ParsePointsString(line)
+	if err != nil {
+		return
+	}
+	for _, p := range points {
+		iter := p.FieldIterator()
+		for iter.Next() {
+			if typ := lineFieldType(iter.Type()); typ != "" {
+				s.Observe(db, string(p.Name()), string(iter.FieldKey()), typ)
+			}
+		}
+	}
+}
+
+// Check loads the target schema of observed databases and reports the conflicts,
+// it returns an error if any conflict found with the error policy.
+func (s *schema) Check(c *client.Client) error {
+	dbs := make(map[string]struct{})
+	for key := range s.source {
+		dbs[key.db] = struct{}{}
+	}
+	for db := range dbs {
+		if err := s.loadTarget(c, db); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]fieldKey, 0, len(s.source))
+	for key := range s.source {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.db != b.db {
+			return a.db < b.db
+		}
+		if a.measurement != b.measurement {
+			return a.measurement < b.measurement
+		}
+		return a.field < b.field
+	})
+	for _, key := range keys {
+		types := s.source[key]
+		expected, inTarget := s.target[key]
+		if !inTarget {
+			expected = types[0]
+		}
+		if len(types) == 1 && types[0] == expected {
+			continue
+		}
+		s.expected[key] = expected
+		s.measurements[measurementKey{key.db, key.measurement}] = struct{}{}
+		if inTarget {
+			log.Printf("field type conflict: %s.%s field %q is %s in target, %s in source", key.db, key.measurement, key.field, expected, strings.Join(types, ", "))
+		} else {
+			log.Printf("field type conflict: %s.%s field %q is %s in source, %s comes first", key.db, key.measurement, key.field, strings.Join(types, ", "), expected)
+		}
+	}
+	if len(s.expected) == 0 {
+		log.Printf("schema check passed, %d fields checked", len(s.source))
+		return nil
+	}
+	if s.policy == conflictError {
+		return fmt.Errorf("%d field type conflicts found, use --type-conflict coerce or skip to import anyway", len(s.expected))
+	}
+	log.Printf("%d field type conflicts found, conflicting values will be %s", len(s.expected), map[string]string{conflictCoerce: "coerced", conflictSkip: "skipped"}[s.policy])
+	return nil
+}
+
+func (s *schema) loadTarget(c *client.Client, db string) error {
+	stmt := "SHOW FIELD KEYS ON " + influxql.QuoteIdent(db)
+	resp, err := c.Query(client.Query{Command: stmt})
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "database not found") {
+			return nil
+		}
+		return fmt.Errorf("execute '%s' error: %v", stmt, err)
+	}
+	for _, result := range resp.Results {
+		for _, row := range result.Series {
+			for _, v := range row.Values {
+				if len(v) < 2 {
+					continue
+				}
+				field, _ := v[0].(string)
+				typ, _ := v[1].(string)
+				s.target[fieldKey{db, row.Name, field}] = typ
+			}
+		}
+	}
+	return nil
+}
+
+// FixLine resolves the conflicting fields of a line, it reports false if no field is left.
+func (s *schema) FixLine(db, line string) (string, bool) {
+	if s == nil || len(s.measurements) == 0 {
+		return line, true
+	}
+	if _, ok := s.measurements[measurementKey{db, string(models.ParseName([]byte(line)))}]; !ok {
+		return line, true
+	}
+	points, err := models.ParsePointsString(line)
+	if err != nil || len(points) != 1 {
+		return line, true
+	}
+	p := points[0]
+	fields, err := p.Fields()
+	if err != nil {
+		return line, true
+	}
+	name := string(p.Name())
+	changed := false
+	for field, v := range fields {
+		expected, ok := s.expected[fieldKey{db, name, field}]
+		if !ok || valueType(v) == expected {
+			continue
+		}
+		changed = true
+		if cv, ok := s.fix(v, expected); ok {
+			fields[field] = cv
+		} else {
+			delete(fields, field)
+		}
+	}
+	if !changed {
+		return line, true
+	}
+	if len(fields) == 0 {
+		return "", false
+	}
+	np, err := models.NewPoint(name, p.Tags(), fields, p.Time())
+	if err != nil {
+		return line, true
+	}
+	return np.String(), true
+}
+
+// FixValues resolves the values of a tsm field, it returns nil if all values are skipped.
+func (s *schema) FixValues(db, measurement, field string, values []tsm1.Value) []tsm1.Value {
+	if s == nil || len(values) == 0 {
+		return values
+	}
+	expected, ok := s.expected[fieldKey{db, measurement, field}]
+	if !ok || valueType(values[0].Value()) == expected {
+		return values
+	}
+	fixed := values[:0]
+	for _, v := range values {
+		if cv, ok := s.fix(v.Value(), expected); ok {
+			fixed = append(fixed, tsm1.NewValue(v.UnixNano(), cv))
+		}
+	}
+	if len(fixed) == 0 {
+		return nil
+	}
+	return fixed
+}
+
+// Report logs the number of values coerced and skipped.
+func (s *schema) Report() {
+	if s == nil || len(s.expected) == 0 {
+		return
+	}
+	log.Printf("field values coerced: %d, skipped: %d", atomic.LoadInt64(&s.coerced), atomic.LoadInt64(&s.skipped))
+}
+
+func (s *schema) fix(v interface{}, typ string) (interface{}, bool) {
+	if s.policy == conflictCoerce {
+		if cv, ok := coerce(v, typ); ok {
+			atomic.AddInt64(&s.coerced, 1)
+			return cv, true
+		}
+	}
+	atomic.AddInt64(&s.skipped, 1)
+	return nil, false
+}
+
+// coerce converts a value to the type, numbers are converted to each other with truncation
+// toward zero and anything can be formatted as a string, but nothing can be a boolean.
+func coerce(v interface{}, typ string) (interface{}, bool) {
+	switch typ {
+	case typeFloat:
+		switch x := v.(type) {
+		case int64:
+			return float64(x), true
+		case uint64:
+			return float64(x), true
+		}
+	case typeInteger:
+		switch x := v.(type) {
+		case float64:
+			if x >= -(1<<63) && x < 1<<63 {
+				return int64(x), true
+			}
+		case uint64:
+			if x < 1<<63 {
+				return int64(x), true
+			}
+		}
+	case typeUnsigned:
+		switch x := v.(type) {
+		case float64:
+			if x >= 0 && x < 1<<64 {
+				return uint64(x), true
+			}
+		case int64:
+			if x >= 0 {
+				return uint64(x), true
+			}
+		}
+	case typeString:
+		switch x := v.(type) {
+		case float64:
+			return strconv.FormatFloat(x, 'g', -1, 64), true
+		case int64:
+			return strconv.FormatInt(x, 10), true
+		case uint64:
+			return strconv.FormatUint(x, 10), true
+		case bool:
+			return strconv.FormatBool(x), true
+		}
+	}
+	return nil, false
+}
+
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return typeFloat
+	case int64:
+		return typeInteger
+	case uint64:
+		return typeUnsigned
+	case string:
+		return typeString
+	case bool:
+		return typeBoolean
+	}
+	return ""
+}
+
+func lineFieldType(t models.FieldType) string {
+	switch t {
+	case models.Float:
+		return typeFloat
+	case models.Integer:
+		return typeInteger
+	case models.Unsigned:
+		return typeUnsigned
+	case models.String:
+		return typeString
+	case models.Boolean:
+		return typeBoolean
+	}
+	return ""
+}
+
+func blockType(t byte) string {
+	switch t {
+	case tsm1.BlockFloat64:
+		return typeFloat
+	case tsm1.BlockInteger:
+		return typeInteger
+	case tsm1.BlockUnsigned:
+		return typeUnsigned
+	case tsm1.BlockString:
+		return typeString
+	case tsm1.BlockBoolean:
+		return typeBoolean
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package importer
+
+import (
+	"testing"
+)
+
+func TestFixLine(t *testing.T) {
+	tests := []struct {
+		policy string
+		line   string
+		exp    string
+		ok     bool
+	}{
+		{conflictCoerce, "cpu,host=a count=1i,usage=0.5 1", "cpu,host=a count=1,usage=0i 1", true},
+		{conflictCoerce, "cpu,host=a usage=2.9 1", "cpu,host=a usage=2i 1", true},
+		{conflictCoerce, "cpu,host=a ok=1i,usage=1i 1", "cpu,host=a usage=1i 1", true},
+		{conflictCoerce, "cpu,host=a ok=1i 1", "", false},
+		{conflictSkip, "cpu,host=a count=1i,usage=2i 1", "cpu,host=a usage=2i 1", true},
+		{conflictSkip, "mem v=1i 1", "mem v=1i 1", true},
+		{conflictSkip, "cpu,host=a count=1.5 1", "cpu,host=a count=1.5 1", true},
+	}
+	for _, tt := range tests {
+		s := newSchema(tt.policy)
+		s.expected[fieldKey{"db", "cpu", "count"}] = typeFloat
+		s.expected[fieldKey{"db", "cpu", "usage"}] = typeInteger
+		s.expected[fieldKey{"db", "cpu", "ok"}] = typeBoolean
+		s.measurements[measurementKey{"db", "cpu"}] = struct{}{}
+		line, ok := s.FixLine("db", tt.line)
+		if line != tt.exp || ok != tt.ok {
+			t.Errorf("%s %q: got=%q %v, exp=%q %v", tt.policy, tt.line, line, ok, tt.exp, tt.ok)
+		}
+	}
+
+	var s *schema
+	if line, ok := s.FixLine("db", "cpu v=1"); line != "cpu v=1" || !ok {
+		t.Errorf("unexpected line of nil schema: %q", line)
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		v   interface{}
+		typ string
+		exp interface{}
+		ok  bool
+	}{
+		{int64(3), typeFloat, float64(3), true},
+		{uint64(3), typeInteger, int64(3), true},
+		{float64(-1.5), typeInteger, int64(-1), true},
+		{float64(-1.5), typeUnsigned, nil, false},
+		{int64(-1), typeUnsigned, nil, false},
+		{true, typeString, "true", true},
+		{float64(0.25), typeString, "0.25", true},
+		{"1", typeFloat, nil, false},
+		{int64(1), typeBoolean, nil, false},
+	}
+	for _, tt := range tests {
+		v, ok := coerce(tt.v, tt.typ)
+		if v != tt.exp || ok != tt.ok {
+			t.Errorf("coerce %v to %s: got=%v %v, exp=%v %v", tt.v, tt.typ, v, ok, tt.exp, tt.ok)
+		}
+	}
+}
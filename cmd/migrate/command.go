@@ -0,0 +1,368 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/sink"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/spf13/cobra"
+)
+
+type command struct {
+	cobraCmd        *cobra.Command
+	dataDir         string
+	walDir          string
+	strictOrder     bool
+	backupPath      string
+	host            string
+	port            int
+	ssl             bool
+	clientConfig    client.Config
+	out             string
+	compress        bool
+	archive         string
+	objectURL       string
+	targetDir       string
+	duration        time.Duration
+	shardDuration   time.Duration
+	skipTsi         bool
+	targetURL       string
+	targetConfig    client.Config
+	targetV2URL     string
+	token           string
+	org             string
+	batchSize       int
+	retries         int
+	ledgerPath      string
+	database        string
+	retentionPolicy string
+	startTime       int64
+	endTime         int64
+
+	svr    *server.Server
+	ledger *sink.Ledger
+}
+
+type tempflag struct {
+	start string
+	end   string
+}
+
+func NewCommand() *cobra.Command {
+	tf := &tempflag{}
+	cmd := &command{}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "migrate",
+		Short:         "Migrate data from tsm files, backups or live server to shards, live server, archives or files",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE(tf)
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.dataDir, "datadir", "D", "", "data storage path to migrate from (require waldir)")
+	flags.StringVarP(&cmd.walDir, "waldir", "W", "", "wal storage path to migrate from (require datadir)")
+	flags.BoolVar(&cmd.strictOrder, "strict-order", false, "fail if tsm or wal file numbers are repeated or unparsable, or wal segments are missing (require datadir, default: false)")
+	flags.StringVarP(&cmd.backupPath, "backup-path", "B", "", "influxd backup directory or backup tarball in portable or legacy format to migrate from")
+	flags.StringVarP(&cmd.host, "host", "H", "", "host of a live server to migrate from by chunked queries")
+	flags.IntVarP(&cmd.port, "port", "P", 8086, "port of the live server to migrate from")
+	flags.StringVarP(&cmd.clientConfig.Username, "username", "u", "", "username to connect to the live server to migrate from")
+	flags.StringVarP(&cmd.clientConfig.Password, "password", "p", "", "password to connect to the live server to migrate from")
+	flags.BoolVarP(&cmd.ssl, "ssl", "s", false, "use https for requests to the live server to migrate from (default: false)")
+	flags.StringVarP(&cmd.out, "out", "o", "", "line protocol file in export format to migrate to")
+	flags.BoolVarP(&cmd.compress, "compress", "c", false, "compress the line protocol file (require out, default: false)")
+	flags.StringVar(&cmd.archive, "archive", "", "tar.gz archive of an export file per database and retention policy to migrate to")
+	flags.StringVar(&cmd.objectURL, "object-url", "", "url of an object store, usually presigned, to upload the tar.gz archive to by a PUT request")
+	flags.StringVarP(&cmd.targetDir, "target-dir", "t", "", "offline influxdb directory containing meta, data and wal to migrate to")
+	flags.DurationVar(&cmd.duration, "duration", time.Hour*0, "retention policy duration of target-dir (default: 0)")
+	flags.DurationVar(&cmd.shardDuration, "shard-duration", time.Hour*24*7, "retention policy shard duration of target-dir")
+	flags.BoolVar(&cmd.skipTsi, "skip-tsi", false, "skip building TSI index on disk of target-dir (default: false)")
+	flags.StringVar(&cmd.targetURL, "target-url", "", "url of an influxdb 1.x server or influx-proxy to migrate to, e.g. http://127.0.0.1:8086")
+	flags.StringVar(&cmd.targetConfig.Username, "target-username", "", "username to connect to the target-url")
+	flags.StringVar(&cmd.targetConfig.Password, "target-password", "", "password to connect to the target-url")
+	flags.StringVar(&cmd.targetV2URL, "target-v2-url", "", "url of an influxdb 2.x server to migrate to, the bucket db/rp must be mapped by dbrp mappings")
+	flags.StringVar(&cmd.token, "token", "", "token to authorize to the target-v2-url")
+	flags.StringVar(&cmd.org, "org", "", "organization of the target-v2-url")
+	flags.IntVarP(&cmd.batchSize, "batch-size", "b", sink.DefaultBatchSize, "number of lines per write to target-url or target-v2-url")
+	flags.IntVar(&cmd.retries, "retries", sink.DefaultRetries, "retries of a batch to target-url or target-v2-url failed by network errors or server errors")
+	flags.StringVar(&cmd.ledgerPath, "ledger", "", "local file recording the batches acked by target-url or target-v2-url, which are skipped when migrating again with the same source, time range and batch size")
+	flags.StringVarP(&cmd.database, "database", "d", "", "database to migrate without _internal (default: all)")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "", "retention policy to migrate (require database)")
+	flags.StringVarP(&tf.start, "start", "S", "", "start time to migrate (RFC3339 format, optional)")
+	flags.StringVarP(&tf.end, "end", "E", "", "end time to migrate (RFC3339 format, optional)")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) validate(tf *tempflag) error {
+	sources := 0
+	if cmd.dataDir != "" || cmd.walDir != "" {
+		if cmd.dataDir == "" || cmd.walDir == "" {
+			return errors.New("must specify both datadir and waldir")
+		}
+		sources++
+	} else if cmd.strictOrder {
+		return errors.New("must specify datadir and waldir when strict order given")
+	}
+	for _, s := range []string{cmd.backupPath, cmd.host} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("must specify exactly one of datadir and waldir, backup path or host")
+	}
+	sinks := 0
+	for _, s := range []string{cmd.out, cmd.archive, cmd.objectURL, cmd.targetDir, cmd.targetURL, cmd.targetV2URL} {
+		if s != "" {
+			sinks++
+		}
+	}
+	if sinks != 1 {
+		return errors.New("must specify exactly one of out, archive, object url, target dir, target url or target v2 url")
+	}
+	if cmd.compress && cmd.out == "" {
+		return errors.New("must specify out when compress given")
+	}
+	if cmd.batchSize <= 0 {
+		return errors.New("batch-size is invalid")
+	}
+	if cmd.retries < 0 {
+		return errors.New("retries is invalid")
+	}
+	if cmd.ledgerPath != "" && cmd.targetURL == "" && cmd.targetV2URL == "" {
+		return errors.New("must specify target url or target v2 url when ledger given")
+	}
+	if cmd.shardDuration <= 0 {
+		return errors.New("shard-duration is invalid")
+	}
+	if tf.start != "" {
+		s, err := time.Parse(time.RFC3339, tf.start)
+		if err != nil {
+			return errors.New("start time is invalid")
+		}
+		cmd.startTime = s.UnixNano()
+	} else {
+		cmd.startTime = math.MinInt64
+	}
+	if tf.end != "" {
+		e, err := time.Parse(time.RFC3339, tf.end)
+		if err != nil {
+			return errors.New("end time is invalid")
+		}
+		cmd.endTime = e.UnixNano()
+	} else {
+		cmd.endTime = math.MaxInt64
+	}
+	if cmd.endTime < cmd.startTime {
+		return errors.New("end time before start time")
+	}
+	if cmd.database == "_internal" {
+		return errors.New("database cannot be _internal")
+	}
+	if cmd.retentionPolicy != "" && cmd.database == "" {
+		return errors.New("must specify a database when retention policy given")
+	}
+	if cmd.host != "" {
+		addr := fmt.Sprintf("%s:%d", cmd.host, cmd.port)
+		u, err := client.ParseConnectionString(addr, cmd.ssl)
+		if err != nil {
+			return fmt.Errorf("parse url error: %s", err)
+		}
+		cmd.clientConfig.URL = u
+		cmd.clientConfig.UnsafeSsl = cmd.ssl
+		cmd.clientConfig.Precision = "ns"
+	}
+	if cmd.targetURL != "" {
+		u, err := url.Parse(cmd.targetURL)
+		if err != nil || u.Host == "" {
+			return errors.New("target url is invalid")
+		}
+		cmd.targetConfig.URL = *u
+		cmd.targetConfig.UnsafeSsl = u.Scheme == "https"
+	}
+	for _, s := range []string{cmd.targetV2URL, cmd.objectURL} {
+		if s == "" {
+			continue
+		}
+		if u, err := url.Parse(s); err != nil || u.Host == "" {
+			return errors.New("target v2 url or object url is invalid")
+		}
+	}
+	return nil
+}
+
+func (cmd *command) runE(tf *tempflag) error {
+	if err := cmd.validate(tf); err != nil {
+		return err
+	}
+	src, err := cmd.newSource()
+	if err != nil {
+		return err
+	}
+	shards, err := src.ListShards(cmd.database, cmd.retentionPolicy)
+	if err != nil {
+		return err
+	}
+	snk, err := cmd.newSink()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cmd.svr != nil {
+			cmd.svr.Close()
+		}
+		cmd.ledger.Close()
+	}()
+	if err = cmd.migrate(src, snk, shards); err != nil {
+		snk.Close()
+		return err
+	}
+	if err = snk.Close(); err != nil {
+		return err
+	}
+	if hs, ok := snk.(*sink.HTTPSink); ok && hs.Skipped() > 0 {
+		log.Printf("skipped %d batches acked in ledger", hs.Skipped())
+	}
+	return nil
+}
+
+func (cmd *command) newSource() (source.Source, error) {
+	switch {
+	case cmd.backupPath != "":
+		return source.NewArchiveSource(cmd.backupPath), nil
+	case cmd.host != "":
+		c, err := client.NewClient(cmd.clientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not create client: %s", err)
+		}
+		if _, _, err = c.Ping(); err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %s", c.Addr(), err)
+		}
+		return source.NewHTTPSource(c), nil
+	default:
+		return source.NewFileSource(cmd.dataDir, cmd.walDir, cmd.strictOrder, tsmread.ModeMmap), nil
+	}
+}
+
+func (cmd *command) newSink() (sink.Sink, error) {
+	hc := http.DefaultClient
+	switch {
+	case cmd.out != "":
+		f, err := os.Create(cmd.out)
+		if err != nil {
+			return nil, err
+		}
+		return sink.NewLineSink(f, cmd.compress), nil
+	case cmd.archive != "":
+		f, err := os.Create(cmd.archive)
+		if err != nil {
+			return nil, err
+		}
+		s, err := sink.NewArchiveSink(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return s, nil
+	case cmd.objectURL != "":
+		return sink.NewObjectSink(hc, cmd.objectURL)
+	case cmd.targetDir != "":
+		svr, err := server.NewServer(cmd.targetDir, !cmd.skipTsi)
+		if err != nil {
+			return nil, err
+		}
+		cmd.svr = svr
+		return sink.NewShardSink(svr, cmd.shardDuration, cmd.duration, !cmd.skipTsi), nil
+	case cmd.targetURL != "":
+		c, err := client.NewClient(cmd.targetConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not create client: %s", err)
+		}
+		if _, _, err = c.Ping(); err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %s", c.Addr(), err)
+		}
+		opts, err := cmd.httpOptions()
+		if err != nil {
+			return nil, err
+		}
+		return sink.NewHTTPSink(c, cmd.targetConfig, opts), nil
+	default:
+		opts, err := cmd.httpOptions()
+		if err != nil {
+			return nil, err
+		}
+		return sink.NewHTTPv2Sink(hc, cmd.targetV2URL, cmd.token, cmd.org, opts), nil
+	}
+}
+
+// httpOptions opens the ledger if given for the sinks of live servers.
+func (cmd *command) httpOptions() (sink.HTTPOptions, error) {
+	ledger, err := sink.OpenLedger(cmd.ledgerPath)
+	if err != nil {
+		return sink.HTTPOptions{}, fmt.Errorf("open ledger error: %v", err)
+	}
+	if ledger != nil {
+		log.Printf("ledger %s: %d batches acked", cmd.ledgerPath, ledger.Len())
+	}
+	cmd.ledger = ledger
+	return sink.HTTPOptions{BatchSize: cmd.batchSize, Retries: cmd.retries, Ledger: ledger}, nil
+}
+
+// migrate creates the schemas of all shards first, then writes the values of the shards in order.
+func (cmd *command) migrate(src source.Source, snk sink.Sink, shards []*source.Shard) error {
+	log.SetFlags(log.LstdFlags)
+	start := time.Now().UTC()
+	defer func() {
+		elapsed := time.Since(start)
+		if elapsed.Minutes() > 10 {
+			log.Printf("total time: %0.1f minutes", elapsed.Minutes())
+		} else {
+			log.Printf("total time: %0.1f seconds", elapsed.Seconds())
+		}
+	}()
+
+	created := make(map[string]bool)
+	for _, sh := range shards {
+		key := filepath.Join(sh.Database, sh.RetentionPolicy)
+		if created[key] {
+			continue
+		}
+		if err := snk.CreateSchema(sh.Database, sh.RetentionPolicy); err != nil {
+			return err
+		}
+		created[key] = true
+	}
+	for _, sh := range shards {
+		log.Printf("migrating shard %d of %s", sh.ID, filepath.Join(sh.Database, sh.RetentionPolicy))
+		if ss, ok := snk.(sink.ShardStarter); ok {
+			if err := ss.StartShard(sh.Database, sh.RetentionPolicy, sh.ID); err != nil {
+				return err
+			}
+		}
+		values := 0
+		err := src.ReadValues(sh, cmd.startTime, cmd.endTime, func(seriesKey, field []byte, vs []tsm1.Value) error {
+			values += len(vs)
+			return snk.WriteSeries(sh.Database, sh.RetentionPolicy, seriesKey, field, vs)
+		})
+		if err != nil {
+			return fmt.Errorf("migrate shard %d of %s error: %v", sh.ID, filepath.Join(sh.Database, sh.RetentionPolicy), err)
+		}
+		log.Printf("migrated shard %d: %d values", sh.ID, values)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package plan
+
+import (
+	"github.com/chengshiwen/influx-tool/cmd/transfer"
+	"github.com/spf13/cobra"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "plan",
+		Short:         "Plan a transfer and print it for review without executing it",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cmd.AddCommand(transfer.NewPlanCommand())
+	return cmd
+}
@@ -1,19 +1,33 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
 	"strings"
 
+	"github.com/chengshiwen/influx-tool/cmd/bench"
 	"github.com/chengshiwen/influx-tool/cmd/cleanup"
 	"github.com/chengshiwen/influx-tool/cmd/compact"
+	"github.com/chengshiwen/influx-tool/cmd/convertindex"
 	"github.com/chengshiwen/influx-tool/cmd/deletetsm"
+	"github.com/chengshiwen/influx-tool/cmd/downsample"
+	"github.com/chengshiwen/influx-tool/cmd/enforceretention"
 	exporter "github.com/chengshiwen/influx-tool/cmd/export"
+	"github.com/chengshiwen/influx-tool/cmd/gaps"
 	"github.com/chengshiwen/influx-tool/cmd/hashdist"
 	importer "github.com/chengshiwen/influx-tool/cmd/import"
+	"github.com/chengshiwen/influx-tool/cmd/migrate"
+	"github.com/chengshiwen/influx-tool/cmd/plan"
+	"github.com/chengshiwen/influx-tool/cmd/run"
+	"github.com/chengshiwen/influx-tool/cmd/selftest"
+	"github.com/chengshiwen/influx-tool/cmd/selfupdate"
 	"github.com/chengshiwen/influx-tool/cmd/transfer"
+	"github.com/chengshiwen/influx-tool/cmd/wizard"
+	"github.com/chengshiwen/influx-tool/internal/audit"
+	"github.com/chengshiwen/influx-tool/internal/spec"
 	"github.com/spf13/cobra"
 )
 
@@ -28,14 +42,18 @@ func init() {
 	log.SetOutput(os.Stdout)
 }
 
+// errSpecSaved stops the command from running once its job spec is saved.
+var errSpecSaved = errors.New("job spec saved")
+
 func Execute() {
 	cmd := NewCommand()
-	if err := cmd.Execute(); err != nil {
+	if err := cmd.Execute(); err != nil && err != errSpecSaved {
 		log.Fatal(err)
 	}
 }
 
 func NewCommand() *cobra.Command {
+	var saveSpec string
 	cmd := &cobra.Command{
 		Args:          cobra.NoArgs,
 		Use:           "influx-tool",
@@ -43,18 +61,58 @@ func NewCommand() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		Version:       version(),
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := audit.Validate(c); err != nil {
+				return err
+			}
+			if saveSpec == "" {
+				return nil
+			}
+			return saveJobSpec(c, saveSpec)
+		},
 	}
 	cmd.SetVersionTemplate(`{{.Version}}`)
+	cmd.PersistentFlags().StringVar(&saveSpec, run.SaveSpecFlag, "", "save the command line as a job spec file to replay by the run command, then exit without running")
+	cmd.PersistentFlags().Bool(audit.PlanFlag, false, "list the files to be created, rewritten, renamed or deleted by compact, convert-index, deletetsm, enforce-retention, reshard or transfer before anything happens, and proceed once approved interactively or by approve-file (default: false)")
+	cmd.PersistentFlags().String(audit.ApproveFlag, "", "audit plan written before and reviewed, the operation proceeds only if its audit plan is the same (require audit-plan)")
+	cmd.PersistentFlags().Bool(audit.DryRunFlag, false, "report what would be changed without changing anything: the audit plan of compact, convert-index, deletetsm, enforce-retention, reshard or transfer, the measurements dropped by cleanup, or the points and statements of import without connecting unless check-schema or on-conflict queries the server, export has a dry-run of its own estimating the output instead (default: false)")
+	cmd.AddCommand(bench.NewCommand())
 	cmd.AddCommand(cleanup.NewCommand())
 	cmd.AddCommand(compact.NewCommand())
+	cmd.AddCommand(convertindex.NewCommand())
 	cmd.AddCommand(deletetsm.NewCommand())
+	cmd.AddCommand(downsample.NewCommand())
+	cmd.AddCommand(enforceretention.NewCommand())
 	cmd.AddCommand(exporter.NewCommand())
+	cmd.AddCommand(gaps.NewCommand())
 	cmd.AddCommand(hashdist.NewCommand())
 	cmd.AddCommand(importer.NewCommand())
+	cmd.AddCommand(migrate.NewCommand())
+	cmd.AddCommand(plan.NewCommand())
+	cmd.AddCommand(transfer.NewReshardCommand())
+	cmd.AddCommand(run.NewCommand())
+	cmd.AddCommand(selftest.NewCommand())
+	cmd.AddCommand(selfupdate.NewCommand(Version))
 	cmd.AddCommand(transfer.NewCommand())
+	cmd.AddCommand(wizard.NewCommand())
 	return cmd
 }
 
+// saveJobSpec saves the flags set on the command line of c, which must be complete to run.
+func saveJobSpec(c *cobra.Command, path string) error {
+	if c.Name() == "run" && c.Parent() == c.Root() {
+		return errors.New("run command cannot be saved as a job spec")
+	}
+	if err := c.ValidateRequiredFlags(); err != nil {
+		return err
+	}
+	if err := spec.FromCommand(c, run.SaveSpecFlag).Save(path); err != nil {
+		return err
+	}
+	log.Printf("job spec saved to %s, run it with: %s run %s", path, c.Root().Name(), path)
+	return errSpecSaved
+}
+
 func version() string {
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("Version:    %s\n", Version))
@@ -0,0 +1,66 @@
+package run
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/internal/spec"
+	"github.com/spf13/cobra"
+)
+
+// SaveSpecFlag is the flag of the root command saving a job spec, which is not allowed in a job spec.
+const SaveSpecFlag = "save-spec"
+
+type command struct {
+	cobraCmd *cobra.Command
+	check    bool
+}
+
+func NewCommand() *cobra.Command {
+	cmd := &command{}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.ExactArgs(1),
+		Use:           "run job.yaml",
+		Short:         "Run a job spec file saved by --save-spec",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE(args[0])
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.BoolVar(&cmd.check, "check", false, "validate the job spec and print the command line, then exit without running (default: false)")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) runE(path string) error {
+	s, err := spec.Load(path)
+	if err != nil {
+		return err
+	}
+	root := cmd.cobraCmd.Root()
+	c, err := s.Find(root)
+	if err != nil {
+		return fmt.Errorf("spec %s is invalid: %v", path, err)
+	}
+	if c == cmd.cobraCmd {
+		return fmt.Errorf("spec %s is invalid: command %q cannot be run by a spec", path, s.Command)
+	}
+	args, err := s.Args(c, SaveSpecFlag)
+	if err != nil {
+		return fmt.Errorf("spec %s is invalid: %v", path, err)
+	}
+	if cmd.check {
+		for i, arg := range args {
+			if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`") {
+				args[i] = strconv.Quote(arg)
+			}
+		}
+		fmt.Printf("%s %s\n", root.Name(), strings.Join(args, " "))
+		return nil
+	}
+	root.SetArgs(args)
+	return root.Execute()
+}
@@ -0,0 +1,247 @@
+package selftest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/cmd/compact"
+	exporter "github.com/chengshiwen/influx-tool/cmd/export"
+	importer "github.com/chengshiwen/influx-tool/cmd/import"
+	"github.com/chengshiwen/influx-tool/cmd/transfer"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/sink"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/spf13/cobra"
+)
+
+const (
+	database        = "selftest"
+	retentionPolicy = "autogen"
+	shardDuration   = 24 * time.Hour
+	days            = 3
+	hosts           = 5
+	nodeTotal       = 2
+	minOpenFiles    = 65536
+)
+
+type command struct {
+	cobraCmd *cobra.Command
+	dir      string
+	keep     bool
+
+	failed int
+}
+
+func NewCommand() *cobra.Command {
+	cmd := &command{}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "selftest",
+		Short:         "Self test export, import, transfer and compact against a temporary mini dataset",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE()
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVar(&cmd.dir, "dir", "", "directory to create the temporary dataset in, to check its permissions and space (default: system temp dir)")
+	flags.BoolVar(&cmd.keep, "keep", false, "keep the temporary dataset for inspection (default: false)")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) runE() error {
+	log.SetFlags(log.LstdFlags)
+	if n, err := openFileLimit(); err != nil {
+		log.Printf("WARN open file limit unknown: %v", err)
+	} else if n < minOpenFiles {
+		log.Printf("WARN open file limit is %d, at least %d is recommended for real data", n, minOpenFiles)
+	} else {
+		log.Printf("PASS open file limit is %d", n)
+	}
+
+	dir, err := os.MkdirTemp(cmd.dir, "influx-tool-selftest-")
+	if err != nil {
+		return fmt.Errorf("create temporary directory error: %v", err)
+	}
+	if cmd.keep {
+		log.Printf("temporary dataset kept in %s", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	log.Printf("PASS temporary directory %s is writable", dir)
+
+	src := filepath.Join(dir, "source")
+	points, err := generate(src)
+	if err != nil {
+		return fmt.Errorf("generate dataset error: %v", err)
+	}
+	log.Printf("PASS generated %d points of %d days in %s", points, days, src)
+
+	// the line protocol export of the source is the baseline of all the round trips
+	base, err := cmd.exportLines(filepath.Join(dir, "base.txt"), src)
+	if err != nil {
+		return err
+	}
+	cmd.check("export", len(base) == points, "%d lines exported, %d expected", len(base), points)
+
+	blocks := filepath.Join(dir, "blocks")
+	imported := filepath.Join(dir, "imported")
+	err = runCommand(exporter.NewCommand(), "-D", filepath.Join(src, "data"), "-W", filepath.Join(src, "wal"), "--format", "tsm-blocks", "-o", blocks, "--history-file", "-")
+	if err == nil {
+		err = runCommand(importer.NewCommand(), "--format", "tsm-blocks", "-f", blocks, "-t", imported, "--shard-duration", shardDuration.String())
+	}
+	cmd.compare("export and import tsm blocks", base, err, func() ([]string, error) {
+		return cmd.exportLines(filepath.Join(dir, "imported.txt"), imported)
+	})
+
+	target := filepath.Join(dir, "transfer")
+	err = runCommand(transfer.NewCommand(), "-s", src, "-t", target, "-d", database, "-n", fmt.Sprint(nodeTotal), "--shard-duration", shardDuration.String(), "--history-file", "-")
+	var nodes [nodeTotal][]string
+	cmd.compare("transfer", base, err, func() ([]string, error) {
+		var lines []string
+		for idx := range nodes {
+			if nodes[idx], err = cmd.exportLines(filepath.Join(dir, fmt.Sprintf("transfer-%d.txt", idx)), fmt.Sprintf("%s-%d", target, idx)); err != nil {
+				return nil, err
+			}
+			lines = append(lines, nodes[idx]...)
+		}
+		sort.Strings(lines)
+		return lines, nil
+	})
+
+	node := fmt.Sprintf("%s-%d", target, 0)
+	err = runCommand(compact.NewCommand(), "-p", filepath.Join(node, "data", database, retentionPolicy), "-f", "--force-all")
+	cmd.compare("compact", nodes[0], err, func() ([]string, error) {
+		return cmd.exportLines(filepath.Join(dir, "compact.txt"), node)
+	})
+
+	if cmd.failed > 0 {
+		return fmt.Errorf("selftest failed: %d checks failed", cmd.failed)
+	}
+	log.Print("selftest passed")
+	return nil
+}
+
+// generate writes the points of several measurements, field types and shard groups into an offline influxdb
+// directory, and returns the number of points written.
+func generate(dir string) (int, error) {
+	svr, err := server.NewServer(dir, true)
+	if err != nil {
+		return 0, err
+	}
+	defer svr.Close()
+	if err = os.MkdirAll(filepath.Join(dir, "wal"), 0755); err != nil {
+		return 0, err
+	}
+
+	s := sink.NewShardSink(svr, shardDuration, 0, true)
+	if err = s.CreateSchema(database, retentionPolicy); err != nil {
+		s.Close()
+		return 0, err
+	}
+	start := time.Now().UTC().Truncate(shardDuration).Add(-days * shardDuration)
+	n := days * 24
+	points := 0
+	write := func(seriesKey, field string, value func(i int, ts int64) tsm1.Value) {
+		if err != nil {
+			return
+		}
+		values := make([]tsm1.Value, n)
+		for i := range values {
+			values[i] = value(i, start.Add(time.Duration(i)*time.Hour).UnixNano())
+		}
+		err = s.WriteSeries(database, retentionPolicy, []byte(seriesKey), []byte(field), values)
+		points += n
+	}
+	for h := 0; h < hosts; h++ {
+		cpu := string(models.MakeKey([]byte("cpu"), models.NewTags(map[string]string{"host": fmt.Sprintf("server-%d", h)})))
+		write(cpu, "usage", func(i int, ts int64) tsm1.Value { return tsm1.NewFloatValue(ts, float64(i)/3) })
+		write(cpu, "cores", func(i int, ts int64) tsm1.Value { return tsm1.NewIntegerValue(ts, int64(h+1)) })
+		disk := string(models.MakeKey([]byte("disk"), models.NewTags(map[string]string{"host": fmt.Sprintf("server-%d", h), "path": "/"})))
+		write(disk, "free", func(i int, ts int64) tsm1.Value { return tsm1.NewUnsignedValue(ts, uint64(i)<<40) })
+		write(disk, "ok", func(i int, ts int64) tsm1.Value { return tsm1.NewBooleanValue(ts, i%2 == 0) })
+		write(disk, "status", func(i int, ts int64) tsm1.Value { return tsm1.NewStringValue(ts, fmt.Sprintf(`mounted "%d"`, i)) })
+	}
+	if err != nil {
+		s.Close()
+		return 0, err
+	}
+	return points, s.Close()
+}
+
+// exportLines exports the data and wal of the database of the influxdb directory to path, and returns the sorted lines.
+func (cmd *command) exportLines(path, dir string) ([]string, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "wal"), 0755); err != nil {
+		return nil, err
+	}
+	if err := runCommand(exporter.NewCommand(), "-D", filepath.Join(dir, "data"), "-W", filepath.Join(dir, "wal"), "-d", database, "-l", "-o", path, "--history-file", "-"); err != nil {
+		return nil, fmt.Errorf("export %s error: %v", dir, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	sort.Strings(lines)
+	return lines, scanner.Err()
+}
+
+// compare checks the lines read by fn against the expected ones, unless the step failed with err.
+func (cmd *command) compare(step string, exp []string, err error, fn func() ([]string, error)) {
+	var got []string
+	if err == nil {
+		got, err = fn()
+	}
+	if err != nil {
+		cmd.check(step, false, "%v", err)
+		return
+	}
+	if len(got) != len(exp) {
+		cmd.check(step, false, "%d lines, %d expected", len(got), len(exp))
+		return
+	}
+	for i := range got {
+		if got[i] != exp[i] {
+			cmd.check(step, false, "line %q, %q expected", got[i], exp[i])
+			return
+		}
+	}
+	cmd.check(step, true, "%d lines round trip", len(got))
+}
+
+func (cmd *command) check(step string, ok bool, format string, args ...interface{}) {
+	if ok {
+		log.Printf("PASS %s: %s", step, fmt.Sprintf(format, args...))
+		return
+	}
+	cmd.failed++
+	log.Printf("FAIL %s: %s", step, fmt.Sprintf(format, args...))
+}
+
+// runCommand runs a command with the arguments as if from the command line.
+func runCommand(c *cobra.Command, args ...string) error {
+	c.SetArgs(args)
+	if err := c.Execute(); err != nil {
+		return fmt.Errorf("%s %s: %v", c.Name(), strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+var errUnsupported = errors.New("not supported on this platform")
@@ -0,0 +1,11 @@
+package selftest
+
+import "testing"
+
+func TestSelftest(t *testing.T) {
+	c := NewCommand()
+	c.SetArgs([]string{"--dir", t.TempDir()})
+	if err := c.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package selftest
+
+import "syscall"
+
+// openFileLimit returns the soft limit of open files of the process.
+func openFileLimit() (uint64, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, err
+	}
+	return uint64(rl.Cur), nil
+}
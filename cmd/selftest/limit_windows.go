@@ -0,0 +1,6 @@
+package selftest
+
+// openFileLimit is unknown on windows, where open files are not limited per process by rlimit.
+func openFileLimit() (uint64, error) {
+	return 0, errUnsupported
+}
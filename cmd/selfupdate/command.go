@@ -0,0 +1,96 @@
+package selfupdate
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/selfupdate"
+	"github.com/spf13/cobra"
+)
+
+type command struct {
+	cobraCmd  *cobra.Command
+	current   string
+	version   string
+	check     bool
+	apiURL    string
+	publicKey string
+	timeout   time.Duration
+}
+
+// NewCommand returns the command updating the binary of the current version.
+func NewCommand(current string) *cobra.Command {
+	cmd := &command{current: current}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "self-update",
+		Short:         "Update the binary in place to a release verified by the signed checksums",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE()
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVar(&cmd.version, "version", "", "release tag to update to, such as v0.4.1, even if older than the current version (default: latest)")
+	flags.BoolVar(&cmd.check, "check", false, "only check whether a release other than the current version is available (default: false)")
+	flags.StringVar(&cmd.apiURL, "api-url", selfupdate.DefaultAPIURL, "github api url of the repository to fetch the releases from, or of a mirror serving the same api")
+	flags.StringVar(&cmd.publicKey, "public-key", "", "base64 of the der of the ed25519 public key verifying the signed checksums (default: the release key built in)")
+	flags.DurationVar(&cmd.timeout, "timeout", 10*time.Minute, "timeout of each request to the api and the downloads")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) validate() error {
+	if cmd.publicKey == "" {
+		cmd.publicKey = selfupdate.PublicKey
+	}
+	if cmd.publicKey == "" && !cmd.check {
+		return errors.New("must specify public key for a binary built without the release key")
+	}
+	if cmd.timeout <= 0 {
+		return errors.New("timeout is invalid")
+	}
+	return nil
+}
+
+func (cmd *command) runE() error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	u := &selfupdate.Updater{Client: &http.Client{Timeout: cmd.timeout}, APIURL: cmd.apiURL, PublicKey: cmd.publicKey}
+	r, err := u.Fetch(cmd.version)
+	if err != nil {
+		return err
+	}
+	if r.Version() == cmd.current {
+		log.Printf("already at version %s", cmd.current)
+		return nil
+	}
+	if cmd.check {
+		log.Printf("version %s is available, current version %s", r.Version(), cmd.current)
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	log.Printf("downloading version %s", r.Version())
+	bin, err := u.Download(r)
+	if err != nil {
+		return err
+	}
+	if err = selfupdate.Replace(exe, bin); err != nil {
+		return err
+	}
+	log.Printf("updated %s from version %s to %s", exe, cmd.current, r.Version())
+	return nil
+}
@@ -0,0 +1,71 @@
+package transfer
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/chengshiwen/influx-tool/internal/audit"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/snapshot"
+	"github.com/influxdata/influxdb/tsdb"
+)
+
+// auditPlan returns the audit plan of transferring the retention policies into the node directories, the shard groups
+// transferred already are not planned. The shards are planned by the directories of their retention policies, since
+// their ids are allocated by the meta of the nodes once created.
+func (cmd *command) auditPlan(svr *server.Server, rps []string) (*audit.Plan, error) {
+	exps := make([]*exporter, 0, len(rps))
+	for _, rp := range rps {
+		exp, err := newExporter(svr, cmd.database, rp, cmd.shardDuration, cmd.startTime, cmd.endTime)
+		if err != nil {
+			return nil, err
+		}
+		exps = append(exps, exp)
+	}
+	idxs := make([]int, 0, len(cmd.nodeIndex))
+	for idx := range cmd.nodeIndex {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+
+	p := &audit.Plan{}
+	if cmd.eventsFile != "" {
+		p.Write(cmd.eventsFile, "appended with the events")
+	}
+	for _, idx := range idxs {
+		dir := cmd.nodeDir(idx)
+		if !cmd.noBackup && snapshot.Exists(dir) {
+			note := "snapshot of meta.db"
+			if cmd.backupShards {
+				note += " and the shards overlapping the shard groups transferred"
+			}
+			p.Add(audit.Create, filepath.Join(dir, snapshot.Directory), note)
+		}
+		p.Write(snapshot.MetaPath(dir), "database, retention policies and shard groups")
+		for _, exp := range exps {
+			starts, err := readState(dir, exp.db, exp.rp)
+			if err != nil {
+				return nil, err
+			}
+			exp.Skip(idx, starts)
+			groups := 0
+			for _, g := range exp.targetGroups {
+				if !exp.skipped(idx, g.StartTime.UnixNano()) {
+					groups++
+				}
+			}
+			if groups == 0 {
+				continue
+			}
+			note := fmt.Sprintf("tsm files of %d shard groups", groups)
+			if !cmd.skipTsi {
+				note += " with the tsi index"
+			}
+			p.Add(audit.Create, filepath.Join(dir, "data", exp.db, exp.rp), note)
+		}
+		p.Write(filepath.Join(dir, "data", cmd.database, tsdb.SeriesFileDirectory), "series file")
+		p.Write(filepath.Join(dir, stateFile), "appended with the shard groups transferred")
+	}
+	return p, nil
+}
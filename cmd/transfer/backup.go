@@ -0,0 +1,90 @@
+package transfer
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/snapshot"
+)
+
+// backup snapshots the meta of the node directories existing before they are written, and the shards of their shard
+// groups overlapping the ones to transfer if backup-shards. The new node directories have nothing to snapshot.
+func (cmd *command) backup(exportServer *server.Server, rps []string) error {
+	if cmd.noBackup || cmd.explain {
+		return nil
+	}
+	for idx := range cmd.nodeIndex {
+		dir := cmd.nodeDir(idx)
+		if !snapshot.Exists(dir) {
+			continue
+		}
+		var paths []string
+		if cmd.backupShards {
+			var err error
+			if paths, err = cmd.touchedShards(exportServer, dir, rps); err != nil {
+				return err
+			}
+		}
+		path, err := snapshot.Take(dir, cmd.runID, paths)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s backed up to %s with %d shards", cmd.nodeName(idx), path, len(paths))
+	}
+	return nil
+}
+
+// touchedShards returns the data and wal directories relative to the node directory dir of the shards written by
+// the transfer of the retention policies, which are the shards of the existing shard groups overlapping the target
+// shard groups not transferred yet.
+func (cmd *command) touchedShards(exportServer *server.Server, dir string, rps []string) ([]string, error) {
+	svr, err := server.NewServer(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	defer svr.Close()
+	client := svr.MetaClient()
+	var paths []string
+	seen := make(map[uint64]struct{})
+	for _, rp := range rps {
+		exp, err := newExporter(exportServer, cmd.database, rp, cmd.shardDuration, cmd.startTime, cmd.endTime)
+		if err != nil {
+			return nil, err
+		}
+		if rpi, _ := client.RetentionPolicy(exp.db, exp.rp); rpi == nil {
+			continue
+		}
+		starts, err := readState(dir, exp.db, exp.rp)
+		if err != nil {
+			return nil, err
+		}
+		exp.Skip(0, starts)
+		for _, g := range exp.targetGroups {
+			if exp.skipped(0, g.StartTime.UnixNano()) {
+				continue
+			}
+			groups, err := client.ShardGroupsByTimeRange(exp.db, exp.rp, g.StartTime, g.EndTime.Add(-1))
+			if err != nil {
+				return nil, err
+			}
+			for _, sg := range groups {
+				for _, sh := range sg.Shards {
+					if _, ok := seen[sh.ID]; ok {
+						continue
+					}
+					seen[sh.ID] = struct{}{}
+					id := strconv.FormatUint(sh.ID, 10)
+					for _, path := range []string{filepath.Join("data", exp.db, exp.rp, id), filepath.Join("wal", exp.db, exp.rp, id)} {
+						if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+							paths = append(paths, path)
+						}
+					}
+				}
+			}
+		}
+	}
+	return paths, nil
+}
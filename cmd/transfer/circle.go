@@ -0,0 +1,145 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/pkg/hash"
+)
+
+// circle is a circle of influx proxy the source is transferred to. The nodes of all the circles are numbered one
+// circle after another as the targets of a transfer, e.g. node index 1 of the second circle after a circle of 3 nodes
+// is target 4, so that each series is read once and written to a node of every circle.
+type circle struct {
+	id        int
+	nodeTotal int
+	hashKey   string
+	targetDir string
+	base      int // target of node index 0
+	ch        *hash.ConsistentHash
+}
+
+type circles []*circle
+
+// targets appends the targets of the shard key to dst, which are a node of each circle.
+func (cs circles) targets(dst []int, key string) []int {
+	for _, c := range cs {
+		dst = append(dst, c.base+c.ch.Get(key))
+	}
+	return dst
+}
+
+// node returns the circle and the node index of the target.
+func (cs circles) node(target int) (*circle, int) {
+	for _, c := range cs {
+		if target < c.base+c.nodeTotal {
+			return c, target - c.base
+		}
+	}
+	panic(fmt.Sprintf("unknown target: %d", target))
+}
+
+// circleFlag is the value of the repeatable circle flag, like "1:node-total=4,hash-key=exi,target-dir=/data/c1".
+type circleFlag struct {
+	circles *circles
+}
+
+func (f circleFlag) Type() string {
+	return "circle"
+}
+
+func (f circleFlag) String() string {
+	strs := make([]string, len(*f.circles))
+	for i, c := range *f.circles {
+		strs[i] = fmt.Sprintf("%d:node-total=%d", c.id, c.nodeTotal)
+	}
+	return strings.Join(strs, " ")
+}
+
+func (f circleFlag) Set(v string) error {
+	id, opts, ok := strings.Cut(v, ":")
+	if !ok {
+		return errors.New("require id:node-total=n,hash-key=k,target-dir=d")
+	}
+	c := &circle{}
+	var err error
+	if c.id, err = strconv.Atoi(id); err != nil || c.id < 0 {
+		return fmt.Errorf("invalid circle id: %s", id)
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "node-total":
+			if c.nodeTotal, err = strconv.Atoi(value); err != nil {
+				return fmt.Errorf("invalid node-total: %s", value)
+			}
+		case "hash-key":
+			c.hashKey = value
+		case "target-dir":
+			c.targetDir = value
+		default:
+			return fmt.Errorf("unknown option: %s", key)
+		}
+	}
+	*f.circles = append(*f.circles, c)
+	return nil
+}
+
+// validateCircles validates the circles given, or makes the only circle of node-total and hash-key, and selects
+// all the nodes of the circles given as the targets.
+func (cmd *command) validateCircles() error {
+	if len(cmd.circles) == 0 {
+		c := &circle{nodeTotal: cmd.nodeTotal, hashKey: cmd.hashKey, targetDir: cmd.targetDir}
+		c.ch = hash.NewConsistentHash(c.nodeTotal, c.hashKey)
+		cmd.circles = circles{c}
+		return nil
+	}
+	if flags := cmd.cobraCmd.Flags(); flags.Changed("node-total") || flags.Changed("node-index") {
+		return errors.New("node-total and node-index cannot be specified with circle")
+	}
+	sort.Slice(cmd.circles, func(i, j int) bool { return cmd.circles[i].id < cmd.circles[j].id })
+	cmd.nodeIndex = make(intSet)
+	base := 0
+	for i, c := range cmd.circles {
+		if i > 0 && c.id == cmd.circles[i-1].id {
+			return fmt.Errorf("circle %d is duplicated", c.id)
+		}
+		if c.nodeTotal <= 0 {
+			return fmt.Errorf("node-total of circle %d is invalid", c.id)
+		}
+		if c.hashKey == "" {
+			c.hashKey = cmd.hashKey
+		}
+		if !validHashKey(c.hashKey) {
+			return fmt.Errorf("hash-key of circle %d is invalid, require idx, exi or template containing %%idx", c.id)
+		}
+		if c.targetDir == "" {
+			c.targetDir = fmt.Sprintf("%s-circle%d", strings.TrimRight(cmd.targetDir, "/"), c.id)
+		}
+		c.base = base
+		c.ch = hash.NewConsistentHash(c.nodeTotal, c.hashKey)
+		for idx := 0; idx < c.nodeTotal; idx++ {
+			cmd.nodeIndex[base+idx] = struct{}{}
+		}
+		base += c.nodeTotal
+	}
+	return nil
+}
+
+// nodeDir returns the influxdb directory of the target.
+func (cmd *command) nodeDir(target int) string {
+	c, idx := cmd.circles.node(target)
+	return nodeDir(c.targetDir, idx)
+}
+
+// nodeName returns the name of the target in logs, which is the node index, prefixed with the circle if several.
+func (cmd *command) nodeName(target int) string {
+	c, idx := cmd.circles.node(target)
+	if len(cmd.circles) == 1 {
+		return fmt.Sprintf("node index %d", idx)
+	}
+	return fmt.Sprintf("circle %d node index %d", c.id, idx)
+}
@@ -0,0 +1,40 @@
+package transfer
+
+import (
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/pkg/hash"
+)
+
+func TestCircles(t *testing.T) {
+	var cs circles
+	f := circleFlag{circles: &cs}
+	for _, v := range []string{"1:node-total=4,hash-key=exi,target-dir=/data/c1", "0:node-total=3"} {
+		if err := f.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, v := range []string{"node-total=3", "a:node-total=3", "0:node-total=x", "0:node-count=3"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("%s: expect error", v)
+		}
+	}
+	if len(cs) != 2 || cs[0].id != 1 || cs[0].nodeTotal != 4 || cs[0].hashKey != "exi" || cs[0].targetDir != "/data/c1" {
+		t.Fatalf("unexpected circles: %s", f.String())
+	}
+
+	cs[0].base, cs[1].base = 0, 4
+	for _, c := range cs {
+		c.ch = hash.NewConsistentHash(c.nodeTotal, c.hashKey)
+	}
+	targets := cs.targets(nil, "db,cpu")
+	if len(targets) != 2 {
+		t.Fatalf("unexpected targets: %v", targets)
+	}
+	for i, target := range targets {
+		c, idx := cs.node(target)
+		if c != cs[i] || idx != c.ch.Get("db,cpu") {
+			t.Errorf("target %d: got circle %d node index %d", target, c.id, idx)
+		}
+	}
+}
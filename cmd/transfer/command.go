@@ -1,19 +1,34 @@
 package transfer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"math"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/chengshiwen/influx-tool/internal/audit"
 	"github.com/chengshiwen/influx-tool/internal/binary"
-	"github.com/chengshiwen/influx-tool/internal/hash"
+	"github.com/chengshiwen/influx-tool/internal/empty"
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/chengshiwen/influx-tool/internal/events"
+	"github.com/chengshiwen/influx-tool/internal/history"
+	"github.com/chengshiwen/influx-tool/internal/keyset"
+	"github.com/chengshiwen/influx-tool/internal/notify"
+	"github.com/chengshiwen/influx-tool/internal/preflight"
 	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/chengshiwen/influx-tool/internal/sink"
+	"github.com/chengshiwen/influx-tool/internal/snapshot"
+	"github.com/chengshiwen/influx-tool/pkg/hash"
+	"github.com/chengshiwen/influx-tool/pkg/plan"
+	"github.com/chengshiwen/influx-tool/pkg/transform"
 	"github.com/djherbis/nio/v3"
 	"github.com/spf13/cobra"
 )
@@ -24,23 +39,66 @@ type command struct {
 	targetDir       string
 	database        string
 	retentionPolicy string
+	excludeRps      []string
 	duration        time.Duration
 	shardDuration   time.Duration
 	startTime       int64
 	endTime         int64
 	worker          int
 	skipTsi         bool
+	maxPoints       int
+	regularTime     bool
 	nodeTotal       int
 	nodeIndex       intSet
+	circles         circles
 	hashKey         string
 	shardKey        string
+	spreadMms       []string
+	spreadTag       string
+	explain         bool
+	deadline        time.Duration
+	historyFile     string
+	maxSeries       int
+	maxSeriesAction string
+	escapeMode      string
+	emptyMode       string
+	placeholder     string
+	nonfinite       string
+	webhook         notify.Webhook
+	eventsFile      string
+	noBackup        bool
+	adoptRP         bool
+	strictMeta      bool
+	backupShards    bool
+	seriesSet       *keyset.Set           // series keys to transfer if series file given
+	transform       transform.Transformer // transform of the series and values if given
+
+	runID     string // id of the transfer run recorded in the state, which names the snapshots of the run
+	events    *events.Log
+	stateMu   sync.Mutex // serializes the state files of node directories
+	guard     *seriesGuard
+	encodings *shard.EncodingStats // encodings of the blocks written of the retention policy being transferred
+	records   []history.Record     // statistics of the retention policies transferred
 }
 
 type tempflag struct {
-	start string
-	end   string
+	start      string
+	end        string
+	seriesFile string
+	transform  string
 }
 
+const (
+	escapeModeDiscard = "discard"
+	escapeModeEscape  = "escape"
+)
+
+const (
+	nonfiniteKeep = "keep"
+	nonfiniteDrop = "drop"
+	nonfiniteZero = "zero"
+)
+
 func NewCommand() *cobra.Command {
 	tf := &tempflag{}
 	cmd := &command{nodeIndex: make(intSet)}
@@ -50,8 +108,13 @@ func NewCommand() *cobra.Command {
 		Short:         "Transfer influxdb persist data on disk from one to another",
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		Annotations:   map[string]string{audit.Annotation: ""},
 		RunE: func(c *cobra.Command, args []string) error {
-			return cmd.runE(tf)
+			start := time.Now()
+			err := cmd.runE(tf)
+			cmd.finish(err)
+			cmd.notify(start, err)
+			return err
 		},
 	}
 	flags := cmd.cobraCmd.Flags()
@@ -59,20 +122,47 @@ func NewCommand() *cobra.Command {
 	flags.StringVarP(&cmd.sourceDir, "source-dir", "s", "", "source influxdb directory containing meta, data and wal (required)")
 	flags.StringVarP(&cmd.targetDir, "target-dir", "t", "", "target influxdb directory containing meta, data and wal (required)")
 	flags.StringVarP(&cmd.database, "database", "d", "", "database name (required)")
-	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "autogen", "retention policy")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "autogen", "retention policies delimited by comma, transferred one by one")
+	flags.StringSliceVar(&cmd.excludeRps, "exclude-retention-policy", nil, "retention policies to exclude delimited by comma, all the others of the database are transferred unless retention-policy given (default: none)")
 	flags.DurationVar(&cmd.duration, "duration", time.Hour*0, "retention policy duration (default: 0)")
 	flags.DurationVar(&cmd.shardDuration, "shard-duration", time.Hour*24*7, "retention policy shard duration")
 	flags.StringVarP(&tf.start, "start", "S", "", "start time to transfer (RFC3339 format, optional)")
 	flags.StringVarP(&tf.end, "end", "E", "", "end time to transfer (RFC3339 format, optional)")
 	flags.IntVarP(&cmd.worker, "worker", "w", 0, "number of concurrent workers to transfer (default: 0, unlimited)")
 	flags.BoolVar(&cmd.skipTsi, "skip-tsi", false, "skip building TSI index on disk (default: false)")
+	flags.IntVar(&cmd.maxPoints, "max-points-per-block", 0, "max points per tsm block of the target shards, the values of a series streamed in chunks are merged into blocks up to it, the encodings of the blocks written are logged at the end (default: 0, the chunks streamed of at most 1000 points)")
+	flags.BoolVar(&cmd.regularTime, "regular-time-blocks", false, "cut the tsm blocks at the start and the end of the runs of timestamps at a regular interval of at least a quarter of max-points-per-block, so that the runs are encoded by run length, such as the telemetry sampled at a fixed interval with gaps (require max-points-per-block, default: false)")
 	flags.IntVarP(&cmd.nodeTotal, "node-total", "n", 1, "total number of node in target circle")
 	flags.VarP(&cmd.nodeIndex, "node-index", "i", "index of node in target circle delimited by comma, [0, node-total) (default: all)")
 	flags.StringVarP(&cmd.hashKey, "hash-key", "k", "idx", "hash key for influx proxy: idx, exi or template containing %idx")
-	flags.StringVarP(&cmd.shardKey, "shard-key", "K", "%db,%mm", "shard key for influx proxy, which containing %db or %mm")
+	flags.StringVarP(&cmd.shardKey, "shard-key", "K", "%db,%mm", "shard key for influx proxy, which containing %db, %rp or %mm")
+	flags.Var(circleFlag{&cmd.circles}, "circle", "circle transferred in the same pass like 1:node-total=4,hash-key=exi,target-dir=dir, instead of node-total and node-index, can be set multiple times, hash-key defaults to that of the flag and target-dir to <target-dir>-circle<id> (default: none)")
+	flags.StringSliceVar(&cmd.spreadMms, "spread-measurement", nil, "measurements too large for one node delimited by comma, whose series are routed across the nodes by spread-tag (default: none)")
+	flags.StringVar(&cmd.spreadTag, "spread-tag", "", "tag whose value is appended to the shard key of the series of spread-measurement, as the secondary routing key of influx proxy")
+	flags.BoolVar(&cmd.explain, "explain-routing", false, "print the routing function and the node index of sample measurements, then exit without transferring (default: false)")
+	flags.DurationVar(&cmd.deadline, "deadline", 0, "deadline of the whole transfer, after which no more shard group is started, resumed by the next transfer (default: 0, no deadline)")
+	flags.IntVar(&cmd.maxSeries, "max-series-per-node", 0, "max series transferred to a node, checked before each shard group is written (default: 0, unlimited)")
+	flags.StringVar(&cmd.maxSeriesAction, "max-series-action", maxSeriesAbort, "action once a node exceeds max series per node: abort to stop before writing the shard group, or warn to log and continue")
+	flags.StringVar(&cmd.escapeMode, "escape-mode", escapeModeDiscard, "mode of the series whose measurement or tags contain comma, space or equals: discard to skip them, or escape to transfer them escaped")
+	flags.StringVar(&tf.seriesFile, "series-file", "", "file of the series keys to transfer, one per line like cpu,host=a,region=us as written by influx_inspect, the other series are skipped (default: none)")
+	flags.StringVar(&cmd.emptyMode, "empty-mode", empty.ModeKeep, "handling of the series with empty tag keys, tag values or field names rejected by influxdb: keep, drop-tag to drop the empty tags and the points of empty fields, drop-point, or placeholder to substitute empty-placeholder")
+	flags.StringVar(&cmd.placeholder, "empty-placeholder", "_", "placeholder of the empty tag keys, tag values and field names in placeholder empty mode")
+	flags.StringVar(&cmd.nonfinite, "nonfinite", nonfiniteKeep, "handling of NaN and Inf float values rejected by influxdb writes: keep, drop or zero")
+	flags.StringVar(&tf.transform, "transform", "", "go plugin exporting a Transformer of package pkg/transform, which filters, modifies and routes the series and values transferred (default: none)")
+	flags.StringVar(&cmd.webhook.URL, "notify-webhook", "", "url posted with the summary and statistics as json once the transfer finishes or fails (default: none)")
+	flags.StringVar(&cmd.webhook.Format, "notify-format", notify.FormatJSON, "payload format of notify-webhook: json, or slack for slack incoming webhooks")
+	flags.StringVar(&cmd.eventsFile, "events-file", "", "file appended with the events of the transfer as json lines, such as the start and finish of shard groups, checkpoints and errors (default: none)")
+	flags.StringVar(&cmd.historyFile, "history-file", "", "file recording the statistics of transfers to estimate the duration of later ones, '-' to disable (default: ~/.influx-tool/history.jsonl)")
+	flags.BoolVar(&cmd.noBackup, "no-backup", false, "skip the snapshot of the meta of the existing target directories into their .influx-tool-backup/<time> before written (default: false)")
+	flags.BoolVar(&cmd.backupShards, "backup-shards", false, "snapshot the existing target shards overlapping the shard groups transferred as well as the meta (default: false)")
+	flags.BoolVar(&cmd.adoptRP, "adopt-existing-rp", false, "adopt the retention policy existing in the targets with a different duration, replication or shard duration, whose shard duration must be a multiple of shard-duration, with a warning of the mismatch instead of failing, so that a transfer can be rerun into differently configured targets (default: false)")
+	flags.BoolVar(&cmd.strictMeta, "strict-meta", false, "fail once the retention policy exists in a target with different parameters, which is the behavior without adopt-existing-rp, the targets are validated before any of them is written (default: false)")
 	cmd.cobraCmd.MarkFlagRequired("source-dir")
 	cmd.cobraCmd.MarkFlagRequired("target-dir")
 	cmd.cobraCmd.MarkFlagRequired("database")
+	cmd.cobraCmd.AddCommand(newServeCommand())
+	cmd.cobraCmd.AddCommand(newPushCommand())
+	cmd.cobraCmd.AddCommand(newRollbackCommand())
 	return cmd.cobraCmd
 }
 
@@ -102,6 +192,15 @@ func (cmd *command) validate(tf *tempflag) error {
 	if cmd.worker < 0 {
 		return errors.New("worker is invalid")
 	}
+	if cmd.maxPoints < 0 {
+		return errors.New("max-points-per-block is invalid")
+	}
+	if cmd.regularTime && cmd.maxPoints == 0 {
+		return errors.New("regular-time-blocks requires max-points-per-block")
+	}
+	if cmd.deadline < 0 {
+		return errors.New("deadline is invalid")
+	}
 	if cmd.nodeTotal <= 0 {
 		return errors.New("node-total is invalid")
 	}
@@ -115,15 +214,107 @@ func (cmd *command) validate(tf *tempflag) error {
 			cmd.nodeIndex[idx] = struct{}{}
 		}
 	}
-	if cmd.hashKey != hash.HashKeyIdx && cmd.hashKey != hash.HashKeyExi && !strings.Contains(cmd.hashKey, hash.HashKeyVarIdx) {
+	if !validHashKey(cmd.hashKey) {
 		return errors.New("hash-key is invalid, require idx, exi or template containing %idx")
 	}
-	if !strings.Contains(cmd.shardKey, hash.ShardKeyVarDb) && !strings.Contains(cmd.shardKey, hash.ShardKeyVarMm) {
-		return errors.New("shard-key is invalid, require template containing %db or %mm")
+	if err := cmd.validateCircles(); err != nil {
+		return err
+	}
+	if !hash.ValidShardKey(cmd.shardKey) {
+		return errors.New("shard-key is invalid, require template containing %db, %rp or %mm")
+	}
+	if len(cmd.spreadMms) > 0 && cmd.spreadTag == "" {
+		return errors.New("spread-tag is invalid, required by spread-measurement")
+	}
+	if cmd.spreadTag != "" && len(cmd.spreadMms) == 0 {
+		return errors.New("spread-measurement is invalid, required by spread-tag")
+	}
+	if cmd.maxSeries < 0 {
+		return errors.New("max-series-per-node is invalid")
+	}
+	if cmd.maxSeriesAction != maxSeriesAbort && cmd.maxSeriesAction != maxSeriesWarn {
+		return errors.New("max-series-action is invalid, require abort or warn")
+	}
+	if cmd.escapeMode != escapeModeDiscard && cmd.escapeMode != escapeModeEscape {
+		return errors.New("escape-mode is invalid, require discard or escape")
+	}
+	if !notify.ValidFormat(cmd.webhook.Format) {
+		return errors.New("notify-format is invalid, require json or slack")
 	}
+	if !empty.ValidMode(cmd.emptyMode) {
+		return errors.New("empty-mode is invalid, require keep, drop-tag, drop-point or placeholder")
+	}
+	if cmd.emptyMode == empty.ModePlaceholder && cmd.placeholder == "" {
+		return errors.New("empty-placeholder is invalid")
+	}
+	if cmd.nonfinite != nonfiniteKeep && cmd.nonfinite != nonfiniteDrop && cmd.nonfinite != nonfiniteZero {
+		return errors.New("nonfinite is invalid, require keep, drop or zero")
+	}
+	if cmd.noBackup && cmd.backupShards {
+		return errors.New("backup-shards cannot be specified with no-backup")
+	}
+	if cmd.adoptRP && cmd.strictMeta {
+		return errors.New("adopt-existing-rp cannot be specified with strict-meta")
+	}
+	if tf.seriesFile != "" {
+		s, err := keyset.Load(tf.seriesFile)
+		if err != nil {
+			return fmt.Errorf("series file: %v", err)
+		}
+		cmd.seriesSet = s
+	}
+	if tf.transform != "" {
+		t, err := transform.Load(tf.transform)
+		if err != nil {
+			return fmt.Errorf("transform: %v", err)
+		}
+		cmd.transform = t
+	}
+	cmd.guard = newSeriesGuard(cmd.maxSeries, cmd.maxSeriesAction)
+	cmd.guard.nodeName = cmd.nodeName
 	return nil
 }
 
+// openEvents opens the events file if any, the events are dropped otherwise.
+func (cmd *command) openEvents() (err error) {
+	cmd.events, err = events.Open(cmd.eventsFile, cmd.cobraCmd.CommandPath())
+	return err
+}
+
+// finish appends the finish or error event to the events file if any, and closes it.
+func (cmd *command) finish(err error) {
+	if err := cmd.events.Finish(err); err != nil {
+		log.Printf("events file error: %v", err)
+	}
+}
+
+// nodeEvent returns the event of node index for the retention policy of exp.
+func (cmd *command) nodeEvent(typ string, exp *exporter, idx int) events.Event {
+	return events.Event{Type: typ, Database: exp.db, RetentionPolicy: exp.rp, Node: cmd.nodeName(idx)}
+}
+
+// emitNodeError appends the error of node index to the events.
+func (cmd *command) emitNodeError(exp *exporter, idx int, msg string, err error) {
+	ev := cmd.nodeEvent(events.TypeError, exp, idx)
+	ev.Message, ev.Error = msg, err.Error()
+	cmd.events.Emit(ev)
+}
+
+// notify posts the summary of the command started at start to the webhook if any.
+func (cmd *command) notify(start time.Time, err error) {
+	if cmd.webhook.URL == "" {
+		return
+	}
+	s := notify.NewSummary(cmd.cobraCmd.CommandPath(), start, err, cmd.records)
+	if err := cmd.webhook.Send(s); err != nil {
+		log.Printf("notify webhook error: %v", err)
+	}
+}
+
+func validHashKey(hashKey string) bool {
+	return hashKey == hash.HashKeyIdx || hashKey == hash.HashKeyExi || strings.Contains(hashKey, hash.HashKeyVarIdx)
+}
+
 func (cmd *command) runE(tf *tempflag) error {
 	if err := cmd.validate(tf); err != nil {
 		return err
@@ -133,13 +324,104 @@ func (cmd *command) runE(tf *tempflag) error {
 		return err
 	}
 	defer exportServer.Close()
-	exp, err := newExporter(exportServer, cmd.database, cmd.retentionPolicy, cmd.shardDuration, cmd.startTime, cmd.endTime)
+	rps, err := cmd.retentionPolicies(exportServer)
 	if err != nil {
 		return err
 	}
+	log.SetFlags(log.LstdFlags)
+	if err = cmd.preflight(exportServer, rps); err != nil {
+		return err
+	}
+	// the target directories and the events file are untouched until the audit plan approved, and on dry run
+	if opts := audit.FromCommand(cmd.cobraCmd); opts.Planned() && !cmd.explain {
+		p, err := cmd.auditPlan(exportServer, rps)
+		if err != nil {
+			return err
+		}
+		if ok, err := opts.Gate(p); err != nil || !ok {
+			return err
+		}
+	}
+	cmd.runID = snapshot.ID(time.Now())
+	if !cmd.explain {
+		log.Printf("transfer run: %s", cmd.runID)
+	}
+	if err = cmd.backup(exportServer, rps); err != nil {
+		return fmt.Errorf("backup error: %v", err)
+	}
+	if err = cmd.openEvents(); err != nil {
+		return err
+	}
+	if !cmd.explain {
+		cmd.warnCircle()
+	}
+	ctx, cancel := cmd.context()
+	defer cancel()
+	for _, rp := range rps {
+		if len(rps) > 1 {
+			log.Printf("transfer retention policy: %s", rp)
+		}
+		if err = cmd.transferRetentionPolicy(ctx, exportServer, rp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retentionPolicies returns the retention policies to transfer, which are the ones given, or all the ones of the
+// database if excluding any, without the excluded.
+func (cmd *command) retentionPolicies(svr *server.Server) ([]string, error) {
+	var rps []string
+	if len(cmd.excludeRps) > 0 && !cmd.cobraCmd.Flags().Changed("retention-policy") {
+		dbi := svr.MetaClient().Database(cmd.database)
+		if dbi == nil {
+			return nil, fmt.Errorf("database '%s' does not exist", cmd.database)
+		}
+		for _, rpi := range dbi.RetentionPolicies {
+			rps = append(rps, rpi.Name)
+		}
+		sort.Strings(rps)
+	} else {
+		rps = splitList(cmd.retentionPolicy)
+	}
+	filtered := rps[:0]
+	for _, rp := range rps {
+		if !containsString(cmd.excludeRps, rp) {
+			filtered = append(filtered, rp)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, errors.New("no retention policy to transfer")
+	}
+	return filtered, nil
+}
+
+// preflight checks the engine and index of the shards of the retention policies, which are opened by influxdb with
+// the index of the server, tsi1 unless skipping tsi.
+func (cmd *command) preflight(svr *server.Server, rps []string) error {
+	var shards []preflight.Shard
+	for _, rp := range rps {
+		s, err := preflight.Inspect(svr.TSDBConfig().Dir, cmd.database, rp)
+		if err != nil {
+			return err
+		}
+		shards = append(shards, s...)
+	}
+	return preflight.Check(shards, svr.TSDBConfig().Index, log.Printf)
+}
+
+// transferRetentionPolicy transfers a retention policy of the database, no more shard group is started once ctx is done.
+func (cmd *command) transferRetentionPolicy(ctx context.Context, exportServer *server.Server, rp string) error {
+	exp, err := newExporter(exportServer, cmd.database, rp, cmd.shardDuration, cmd.startTime, cmd.endTime)
+	if err != nil {
+		return err
+	}
+	if cmd.explain {
+		return cmd.explainRouting(os.Stdout, exp)
+	}
 
 	svrs := make(map[int]*server.Server)
-	imps := make(map[int]*importer)
+	imps := make(map[int]*shard.Importer)
 	defer func() {
 		for _, imp := range imps {
 			imp.Close()
@@ -148,27 +430,72 @@ func (cmd *command) runE(tf *tempflag) error {
 			svr.Close()
 		}
 	}()
+	// the meta of the targets are validated before any of them is created, so that no target is left half created
+	for idx := range cmd.nodeIndex {
+		if err := shard.ValidateRetentionPolicy(cmd.nodeDir(idx), cmd.database, exp.rp, cmd.shardDuration, cmd.duration, cmd.adoptRP); err != nil {
+			return fmt.Errorf("%s: %v", cmd.nodeName(idx), err)
+		}
+	}
+	cmd.encodings = shard.NewEncodingStats()
 	for idx := range cmd.nodeIndex {
-		dir := fmt.Sprintf("%s-%d", strings.TrimRight(cmd.targetDir, "/"), idx)
-		importServer, err := server.NewServer(dir, !cmd.skipTsi)
+		importServer, err := server.NewServer(cmd.nodeDir(idx), !cmd.skipTsi)
 		if err != nil {
 			return err
 		}
 		svrs[idx] = importServer
-		imp, err := newImporter(importServer, cmd.database, cmd.retentionPolicy, cmd.shardDuration, cmd.duration, !cmd.skipTsi)
+		imp, err := shard.NewImporter(importServer, cmd.database, exp.rp, cmd.shardDuration, cmd.duration, !cmd.skipTsi, cmd.adoptRP)
 		if err != nil {
 			return err
 		}
+		imp.SetWriterOptions(cmd.writerOptions()...)
 		imps[idx] = imp
+		// resume from the shard groups already imported by a previous transfer
+		starts, err := readState(cmd.nodeDir(idx), exp.db, exp.rp)
+		if err != nil {
+			return err
+		}
+		if len(starts) > 0 {
+			log.Printf("%s resumes with %d shard groups transferred", cmd.nodeName(idx), len(starts))
+		}
+		exp.Skip(idx, starts)
 	}
 
-	cmd.transfer(exp, imps)
-	return nil
+	return cmd.transfer(ctx, exp, func(idx int, prChan chan *nio.PipeReader) {
+		cmd.transferNode(exp, imps[idx], prChan, idx)
+	})
+}
+
+// writerOptions returns the options of the tsm writers of the target shards, which record the encodings of the blocks.
+func (cmd *command) writerOptions() []shard.WriterOption {
+	opts := []shard.WriterOption{shard.RecordEncodings(cmd.encodings)}
+	if cmd.maxPoints > 0 {
+		opts = append(opts, shard.MaxPointsPerBlock(cmd.maxPoints))
+	}
+	if cmd.regularTime {
+		opts = append(opts, shard.RegularTimeBlocks())
+	}
+	return opts
+}
+
+// context returns the context of the whole transfer, which is canceled once the deadline exceeded.
+func (cmd *command) context() (context.Context, context.CancelFunc) {
+	if cmd.deadline > 0 {
+		return context.WithTimeout(context.Background(), cmd.deadline)
+	}
+	return context.WithCancel(context.Background())
 }
 
-func (cmd *command) transfer(exp *exporter, imps map[int]*importer) {
+// transfer exports the binary streams of each node index to prChan consumed by nodeFn, no more shard group
+// is exported once ctx is done, and an error is returned to resume the shard groups left by the next transfer.
+func (cmd *command) transfer(ctx context.Context, exp *exporter, nodeFn func(idx int, prChan chan *nio.PipeReader)) error {
 	log.SetFlags(log.LstdFlags)
-	log.Printf("transfer node total: %d, node index: %s, hash key: %s", cmd.nodeTotal, cmd.nodeIndex, cmd.hashKey)
+	if len(cmd.circles) == 1 {
+		log.Printf("transfer node total: %d, node index: %s, hash key: %s", cmd.nodeTotal, cmd.nodeIndex, cmd.hashKey)
+	} else {
+		for _, c := range cmd.circles {
+			log.Printf("transfer circle %d node total: %d, hash key: %s, target dir: %s", c.id, c.nodeTotal, c.hashKey, c.targetDir)
+		}
+	}
 	start := time.Now().UTC()
 	defer func() {
 		elapsed := time.Since(start)
@@ -179,6 +506,19 @@ func (cmd *command) transfer(exp *exporter, imps map[int]*importer) {
 		}
 	}()
 
+	exp.startStats(cmd.historyFile, strings.TrimPrefix(cmd.cobraCmd.CommandPath(), cmd.cobraCmd.Root().Name()+" "))
+	// the guard aborts the transfer by canceling ctx with the cause
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	exp.guard, exp.abort = cmd.guard, cancel
+	exp.escapeMode = cmd.escapeMode
+	exp.seriesSet = cmd.seriesSet
+	exp.empty = empty.NewHandler(cmd.emptyMode, cmd.placeholder)
+	exp.nonfinite = cmd.nonfinite
+	exp.events = cmd.events
+	if cmd.transform != nil {
+		exp.transform = transform.NewHook(cmd.transform)
+	}
 	prChans := make(map[int]chan *nio.PipeReader)
 	for idx := range cmd.nodeIndex {
 		prChans[idx] = make(chan *nio.PipeReader, 4)
@@ -190,24 +530,45 @@ func (cmd *command) transfer(exp *exporter, imps map[int]*importer) {
 				close(prChan)
 			}
 		}()
-		exp.WriteTo(prChans, cmd.nodeTotal, cmd.hashKey, cmd.shardKey, cmd.worker)
+		exp.WriteTo(ctx, prChans, cmd.circles, cmd.shard(), cmd.worker)
 	}()
 
 	wg := &sync.WaitGroup{}
-	for idx := range imps {
+	for idx := range prChans {
 		wg.Add(1)
 		idx := idx
 		go func() {
 			defer wg.Done()
-			cmd.transferNode(imps[idx], prChans[idx], idx)
+			nodeFn(idx, prChans[idx])
 		}()
 	}
 	wg.Wait()
+	cmd.guard.logSeries()
+	if report := exp.empty.Report("series of shard groups"); report != "" {
+		log.Print(report)
+	}
+	if n := exp.nonfinites.Load(); n > 0 {
+		log.Printf("non-finite float values %s: %d", map[string]string{nonfiniteDrop: "dropped", nonfiniteZero: "zeroed"}[cmd.nonfinite], n)
+	}
+	if report := exp.transform.Report("series of shard groups"); report != "" {
+		log.Print(report)
+	}
+	if report := cmd.encodings.Report(); report != "" {
+		log.Print(report)
+	}
+	if err := context.Cause(ctx); errors.Is(err, errMaxSeries) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("transfer stopped: %v, rerun to resume the shard groups left", err)
+	}
+	exp.saveStats()
+	cmd.records = append(cmd.records, exp.record())
 	log.Print("transfer done")
+	return nil
 }
 
-func (cmd *command) transferNode(imp *importer, prChan chan *nio.PipeReader, idx int) {
-	log.Printf("node index %d transfer start", idx)
+func (cmd *command) transferNode(exp *exporter, imp *shard.Importer, prChan chan *nio.PipeReader, idx int) {
+	log.Printf("%s transfer start", cmd.nodeName(idx))
 	wg := &sync.WaitGroup{}
 	for pr := range prChan {
 		wg.Add(1)
@@ -216,31 +577,85 @@ func (cmd *command) transferNode(imp *importer, prChan chan *nio.PipeReader, idx
 			defer wg.Done()
 			defer pr.Close()
 
-			iw := newImportWorker(imp)
+			iw := shard.NewImportWorker(imp)
 
 			reader := binary.NewReader(pr)
-			_, err := reader.ReadHeader()
+			h, err := reader.ReadHeader()
 			if err != nil {
 				log.Printf("read header error: %s", err)
+				cmd.emitNodeError(exp, idx, "read header", err)
 				return
 			}
+			iw.SetHeader(h)
 
 			var bh *binary.BucketHeader
 			for bh, err = reader.NextBucket(); (bh != nil) && (err == nil); bh, err = reader.NextBucket() {
-				err = iw.ImportShard(reader, bh.Start, bh.End)
+				created, err := importBucket(iw, h, reader, bh)
 				if err != nil {
 					log.Printf("import shard error: %s, idx: %d", err, idx)
+					cmd.emitNodeError(exp, idx, "import shard", err)
 					return
 				}
+				cmd.stateMu.Lock()
+				err = appendState(cmd.nodeDir(idx), &bucketState{Database: exp.db, RetentionPolicy: exp.rp, Start: bh.Start, End: bh.End, Run: cmd.runID, Created: created})
+				cmd.stateMu.Unlock()
+				if err != nil {
+					log.Printf("save state error: %s, idx: %d", err, idx)
+					cmd.emitNodeError(exp, idx, "save state", err)
+					return
+				}
+				// the bucket imported is resumed by the next transfer
+				ev := cmd.nodeEvent(events.TypeCheckpoint, exp, idx)
+				ev.Start, ev.End = events.Range(time.Unix(0, bh.Start), time.Unix(0, bh.End))
+				cmd.events.Emit(ev)
 			}
 			if err != nil {
 				log.Printf("next bucket error: %s", err)
+				cmd.emitNodeError(exp, idx, "next bucket", err)
 				return
 			}
 		}()
 	}
 	wg.Wait()
-	log.Printf("node index %d transfer done", idx)
+	log.Printf("%s transfer done", cmd.nodeName(idx))
+}
+
+// importBucket writes the bucket started by the reader into its shard group through the sink of the group, and returns
+// whether the shard group is created rather than existing before.
+func importBucket(iw *shard.ImportWorker, h *binary.Header, reader *binary.Reader, bh *binary.BucketHeader) (bool, error) {
+	s, err := sink.NewGroupSink(iw, bh.Start, bh.End)
+	if err != nil {
+		return false, err
+	}
+	el := errlist.NewErrorList()
+	el.Add(sink.CopyBucket(s, h.Database, h.RetentionPolicy, reader))
+	el.Add(s.Close())
+	return s.Created(), el.Err()
+}
+
+// nodeDir returns the influxdb directory of node index, suffixed to target directory.
+func nodeDir(targetDir string, idx int) string {
+	return plan.NodeDir(targetDir, idx)
+}
+
+// splitList splits the comma delimited list, empty items are dropped.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
 
 type intSet map[int]struct{}
@@ -255,7 +670,11 @@ func (is intSet) String() string {
 		values = append(values, k)
 	}
 	sort.Ints(values)
-	return strings.Trim(fmt.Sprint(values), "[]")
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = strconv.Itoa(v)
+	}
+	return strings.Join(strs, ",")
 }
 
 func (is intSet) Set(v string) error {
@@ -272,3 +691,8 @@ func (is intSet) Set(v string) error {
 	}
 	return nil
 }
+
+// shard returns the shard key template, spreading the series of spread-measurement by spread-tag.
+func (cmd *command) shard() *hash.SpreadShard {
+	return hash.NewSpreadShard(hash.NewShardTpl(cmd.shardKey), cmd.spreadMms, cmd.spreadTag)
+}
@@ -4,28 +4,58 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
+	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chengshiwen/influx-tool/internal/binary"
+	"github.com/chengshiwen/influx-tool/internal/empty"
 	"github.com/chengshiwen/influx-tool/internal/escape"
-	"github.com/chengshiwen/influx-tool/internal/hash"
+	"github.com/chengshiwen/influx-tool/internal/events"
+	"github.com/chengshiwen/influx-tool/internal/keyset"
 	"github.com/chengshiwen/influx-tool/internal/server"
-	"github.com/chengshiwen/influx-tool/internal/storage"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/chengshiwen/influx-tool/pkg/hash"
+	"github.com/chengshiwen/influx-tool/pkg/transform"
 	"github.com/djherbis/buffer"
 	"github.com/djherbis/nio/v3"
 	"github.com/influxdata/influxdb/models"
 	"github.com/influxdata/influxdb/services/meta"
 	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
 )
 
+// shardSource is the source of the shards transferred, whose series are read merged in key order across the shards
+// of a target shard group.
+type shardSource interface {
+	source.Source
+	source.MergeSource
+}
+
 type exporter struct {
-	tsdbConfig   tsdb.Config
+	src          shardSource
 	db, rp       string
 	sd           time.Duration
 	sourceGroups []meta.ShardGroupInfo
 	targetGroups []meta.ShardGroupInfo
+	shards       []*source.Shard            // shards of the source groups with their time ranges, in time order
+	skips        map[int]map[int64]struct{} // start time of target groups to skip by node index
+	stats        stats
+	guard        *seriesGuard
+	abort        context.CancelCauseFunc
+	escapeMode   string
+	seriesSet    *keyset.Set // series to transfer if not nil
+	empty        *empty.Handler
+	nonfinite    string
+	nonfinites   atomic.Int64 // non-finite float values dropped or zeroed
+	events       *events.Log
+	transform    *transform.Hook // transform of the series and values if not nil
 }
 
 func newExporter(svr *server.Server, db, rp string, sd time.Duration, start, end int64) (*exporter, error) {
@@ -47,10 +77,11 @@ func newExporter(svr *server.Server, db, rp string, sd time.Duration, start, end
 	}
 
 	e := &exporter{
-		tsdbConfig: svr.TSDBConfig(),
-		db:         db,
-		rp:         rp,
-		sd:         sd,
+		src:   newShardSource(svr.TSDBConfig()),
+		db:    db,
+		rp:    rp,
+		sd:    sd,
+		skips: make(map[int]map[int64]struct{}),
 	}
 
 	// load shard groups
@@ -63,24 +94,107 @@ func newExporter(svr *server.Server, db, rp string, sd time.Duration, start, end
 	if len(groups) > 0 {
 		sort.Sort(meta.ShardGroupInfos(groups))
 		e.sourceGroups = groups
-		e.targetGroups = planShardGroups(groups, sd, start, end)
+		e.targetGroups = shard.PlanShardGroups(groups, sd, start, end)
+		if e.shards, err = e.listShards(); err != nil {
+			return nil, err
+		}
 	}
 
 	return e, nil
 }
 
+// newShardSource returns the source of the tsm and wal files of influxd, the wal directory is not read if missing,
+// such as the data restored from a backup.
+func newShardSource(config tsdb.Config) *source.FileSource {
+	src := source.NewFileSource(config.Dir, config.WALDir, false, tsmread.ModeMmap)
+	if _, err := os.Stat(config.WALDir); os.IsNotExist(err) {
+		src.SelectKind(source.KindTSM)
+	}
+	return src
+}
+
+// listShards returns the shards of the source in the source shard groups with the time ranges of their groups, the
+// shards on disk out of the groups are not read as influxd doesn't load them.
+func (e *exporter) listShards() ([]*source.Shard, error) {
+	shards, err := e.src.ListShards(e.db, e.rp)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[uint64]meta.ShardGroupInfo)
+	for _, g := range e.sourceGroups {
+		for _, sh := range g.Shards {
+			groups[sh.ID] = g
+		}
+	}
+	listed := shards[:0]
+	for _, sh := range shards {
+		if g, ok := groups[sh.ID]; ok {
+			sh.StartTime, sh.EndTime = g.StartTime.UnixNano(), g.EndTime.UnixNano()-1
+			listed = append(listed, sh)
+		}
+	}
+	sort.SliceStable(listed, func(i, j int) bool { return listed[i].StartTime < listed[j].StartTime })
+	return listed, nil
+}
+
+// shardsOf returns the source shards overlapping the time range [min, max) in time order.
+func (e *exporter) shardsOf(min, max time.Time) []*source.Shard {
+	var shards []*source.Shard
+	for _, sh := range e.shards {
+		if sh.StartTime < max.UnixNano() && sh.EndTime >= min.UnixNano() {
+			shards = append(shards, sh)
+		}
+	}
+	return shards
+}
+
 func (e *exporter) SourceShardGroups() []meta.ShardGroupInfo { return e.sourceGroups }
 func (e *exporter) TargetShardGroups() []meta.ShardGroupInfo { return e.targetGroups }
 
-func (e *exporter) WriteTo(prChans map[int]chan *nio.PipeReader, nodeTotal int, hashKey string, shardKey string, worker int) {
+// Skip skips the target shard groups of node index with the start times, which have been transferred.
+func (e *exporter) Skip(idx int, starts []int64) {
+	if e.skips[idx] == nil {
+		e.skips[idx] = make(map[int64]struct{})
+	}
+	for _, start := range starts {
+		e.skips[idx][start] = struct{}{}
+	}
+}
+
+func (e *exporter) skipped(idx int, start int64) bool {
+	_, ok := e.skips[idx][start]
+	return ok
+}
+
+// skippedAll returns whether the target group with the start time is skipped by all the node indexes.
+func (e *exporter) skippedAll(start int64) bool {
+	if len(e.skips) == 0 {
+		return false
+	}
+	for idx := range e.skips {
+		if !e.skipped(idx, start) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo writes the shard groups not skipped to prChans by worker, no more shard group is started once ctx is done.
+func (e *exporter) WriteTo(ctx context.Context, prChans map[int]chan *nio.PipeReader, cs circles, st *hash.SpreadShard, worker int) {
 	log.Printf("total shard groups: %d", len(e.targetGroups))
 	limit := make(chan struct{}, worker)
-	ch := hash.NewConsistentHash(nodeTotal, hashKey)
-	st := hash.NewShardTpl(shardKey)
 	wg := &sync.WaitGroup{}
 	for _, g := range e.targetGroups {
 		g := g
 		min, max := g.StartTime, g.EndTime
+		if e.skippedAll(min.UnixNano()) {
+			log.Printf("shard group skipped: %d", g.ID)
+			e.events.Emit(e.groupEvent(events.TypeShardGroupSkip, g))
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
 		wg.Add(1)
 		go func() {
 			if worker > 0 {
@@ -93,182 +207,303 @@ func (e *exporter) WriteTo(prChans map[int]chan *nio.PipeReader, nodeTotal int,
 				}
 			}()
 
-			ew := newExportWorker(e)
-			err := ew.Open()
-			if err != nil {
-				log.Printf("export worker open error: %s, shard group: %d, min: %d, max: %d", err, g.ID, min.Unix(), max.Unix())
+			if err := ctx.Err(); err != nil {
+				log.Printf("shard group not started: %d, %s", g.ID, err)
+				e.emitError(g, "shard group not started", err)
 				return
 			}
-			defer ew.Close()
-			rs, err := ew.read(min, max.Add(-1))
+			e.events.Emit(e.groupEvent(events.TypeShardGroupStart, g))
+			shards := e.shardsOf(min, max)
+			series, err := e.readSeries(shards, cs, st)
 			if err != nil {
-				log.Printf("export worker read error: %s, shard group: %d, min: %d, max: %d", err, g.ID, min.Unix(), max.Unix())
+				log.Printf("export worker read series error: %s, shard group: %d, min: %d, max: %d", err, g.ID, min.Unix(), max.Unix())
+				e.emitError(g, "export worker read series", err)
 				return
 			}
-			if rs == nil {
-				log.Printf("export worker read rs is nil, shard group: %d, min: %d, max: %d", g.ID, min.Unix(), max.Unix())
+			if err = e.guard.add(e.writtenSeries(prChans, series, min.UnixNano())); err != nil {
+				log.Printf("export worker stopped: %s, shard group: %d", err, g.ID)
+				e.emitError(g, "export worker stopped", err)
+				e.abort(err)
 				return
 			}
-			defer rs.Close()
+			for _, s := range series {
+				e.addSeries(len(s))
+			}
 
-			err = e.writeBucket(prChans, rs, min, max, ch, st)
+			err = e.writeBucket(prChans, shards, series, min, max, cs, st)
 			if err != nil {
 				log.Printf("export worker write error: %s, shard group: %d, min: %d, max: %d", err, g.ID, min.Unix(), max.Unix())
+				e.emitError(g, "export worker write", err)
 			}
-			log.Printf("shard group done: %d", g.ID)
+			log.Printf("shard group done: %d%s", g.ID, e.groupDone(g))
+			e.events.Emit(e.groupEvent(events.TypeShardGroupDone, g))
 		}()
 	}
 	wg.Wait()
 	log.Print("all shard groups done")
 }
 
-func (e *exporter) writeBucket(prChans map[int]chan *nio.PipeReader, rs *storage.ResultSet, min, max time.Time, h hash.Hash, s hash.Shard) error {
-	pws := make(map[int]*nio.PipeWriter)
-	wrs := make(map[int]*binary.Writer)
-	bws := make(map[int]*binary.BucketWriter)
-	defer func() {
-		for _, bw := range bws {
-			bw.Close()
+// groupEvent returns the event of the target shard group.
+func (e *exporter) groupEvent(typ string, g meta.ShardGroupInfo) events.Event {
+	id := g.ID
+	start, end := events.Range(g.StartTime, g.EndTime)
+	return events.Event{Type: typ, Database: e.db, RetentionPolicy: e.rp, ShardGroup: &id, Start: start, End: end}
+}
+
+// emitError appends the error of the target shard group to the events.
+func (e *exporter) emitError(g meta.ShardGroupInfo, msg string, err error) {
+	ev := e.groupEvent(events.TypeError, g)
+	ev.Message, ev.Error = msg, err.Error()
+	e.events.Emit(ev)
+}
+
+// readSeries reads the series of the shards for the series dictionary by target in key order, without reading any
+// points.
+func (e *exporter) readSeries(shards []*source.Shard, cs circles, s *hash.SpreadShard) (map[int][]*binary.SeriesInfo, error) {
+	types := make(map[string]influxql.DataType)
+	for _, sh := range shards {
+		err := e.src.ReadSeries(sh, func(seriesKey, field []byte, typ influxql.DataType) error {
+			key := string(tsm1.SeriesFieldKeyBytes(string(seriesKey), string(field)))
+			if _, ok := types[key]; !ok {
+				types[key] = typ
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
-		for _, wr := range wrs {
-			wr.Close()
+	}
+	keys := make([]string, 0, len(types))
+	for key := range types {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	series := make(map[int][]*binary.SeriesInfo)
+	var targets []int
+	for _, key := range keys {
+		seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey([]byte(key))
+		name, tags := models.ParseKeyBytes(seriesKey)
+		if !e.selected(name, tags) || e.discard(name, tags) {
+			continue
 		}
-		for _, pw := range pws {
-			pw.Close()
+		tags, field, r := e.empty.Handle(tags, field)
+		if r == empty.PointDropped {
+			continue
 		}
-	}()
-
-	for rs.Next() {
-		if escape.NeedEscape(rs.Name(), rs.Tags()) {
-			log.Printf("discard escaped measurement: %s, tags: %s", rs.Name(), rs.Tags())
+		name, tags, field, _, ok := e.transformSeries(name, tags, field)
+		if !ok {
 			continue
 		}
-		nodeIndex := h.Get(s.GetKey(e.db, rs.Name()))
-		if prChan, pok := prChans[nodeIndex]; pok {
-			if _, bok := bws[nodeIndex]; !bok {
-				buf := buffer.New(int64(4 * 1024 * 1024))
-				pr, pw := nio.Pipe(buf)
-				pws[nodeIndex] = pw
-				wr := binary.NewWriter(pw, e.db, e.rp, e.sd)
-				wrs[nodeIndex] = wr
-				bw, err := wr.NewBucket(min.UnixNano(), max.UnixNano())
-				if err != nil {
-					return err
-				}
-				bws[nodeIndex] = bw
-				prChan <- pr
-			}
-			bw := bws[nodeIndex]
-			err := bw.WriteSeries(rs.Name(), rs.Field(), rs.FieldType(), rs.Tags(), rs.CursorIterator())
-			if err != nil {
-				return err
+		targets = cs.targets(targets[:0], s.GetSeriesKey(e.db, e.rp, name, tags))
+		if si := binary.NewSeriesInfo(name, field, types[key], tags); si != nil {
+			for _, t := range targets {
+				series[t] = append(series[t], si)
 			}
 		}
 	}
-	return nil
+	return series, nil
 }
 
-type exportWorker struct {
-	*exporter
-	tsdbStore *tsdb.Store
-	store     *storage.Store
+// selected returns whether the series is in the series file if given.
+func (e *exporter) selected(name []byte, tags models.Tags) bool {
+	return e.seriesSet == nil || e.seriesSet.HasSeries(name, tags)
 }
 
-func newExportWorker(exporter *exporter) *exportWorker {
-	tsdbConfig := exporter.tsdbConfig
-	store := tsdb.NewStore(tsdbConfig.Dir)
-	store.EngineOptions.MonitorDisabled = true
-	store.EngineOptions.CompactionDisabled = true
-	store.EngineOptions.Config = tsdbConfig
-	store.EngineOptions.EngineVersion = tsdbConfig.Engine
-	store.EngineOptions.IndexVersion = tsdbConfig.Index
-	store.EngineOptions.DatabaseFilter = func(database string) bool {
-		return database == exporter.db
-	}
-	store.EngineOptions.RetentionPolicyFilter = func(_, rp string) bool {
-		return rp == exporter.rp
+// discard returns whether the series needing escaping is discarded, which is transferred escaped in escape mode.
+func (e *exporter) discard(name []byte, tags models.Tags) bool {
+	return e.escapeMode != escapeModeEscape && escape.NeedEscape(name, tags)
+}
+
+// floatFilter returns the filter of non-finite float values by the nonfinite mode, or nil if they are kept.
+func (e *exporter) floatFilter() binary.FloatFilter {
+	if e.nonfinite != nonfiniteDrop && e.nonfinite != nonfiniteZero {
+		return nil
 	}
-	store.EngineOptions.ShardFilter = func(_, _ string, _ uint64) bool {
-		return false
+	return func(a *tsdb.FloatArray) {
+		j := 0
+		for i, v := range a.Values {
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				a.Timestamps[j], a.Values[j] = a.Timestamps[i], v
+				j++
+				continue
+			}
+			e.nonfinites.Add(1)
+			if e.nonfinite == nonfiniteZero {
+				a.Timestamps[j], a.Values[j] = a.Timestamps[i], 0
+				j++
+			}
+		}
+		a.Timestamps, a.Values = a.Timestamps[:j], a.Values[:j]
 	}
+}
 
-	return &exportWorker{
-		exporter:  exporter,
-		tsdbStore: store,
-		store:     &storage.Store{TSDBStore: store},
+// transformSeries returns the measurement, tags and field of the series transformed with the series passed to the
+// transform of its values, or false if the series is dropped. They are returned as given if no transform.
+func (e *exporter) transformSeries(name []byte, tags models.Tags, field []byte) ([]byte, models.Tags, []byte, *transform.Series, bool) {
+	if e.transform == nil {
+		return name, tags, field, nil, true
 	}
+	ts, ok := e.transform.Series(e.db, e.rp, name, tags, field)
+	if !ok {
+		return nil, nil, nil, nil, false
+	}
+	name, tags, field = ts.Key()
+	return name, tags, field, ts, true
 }
 
-func (e *exportWorker) Open() (err error) {
-	err = e.tsdbStore.Open()
-	if err != nil {
-		return err
-	}
-	return nil
+// transformFilter filters the values of a series by the transform in place, the type of the values is kept.
+type transformFilter struct {
+	hook   *transform.Hook
+	series *transform.Series
 }
 
-func (e *exportWorker) Close() error {
-	return e.tsdbStore.Close()
+func (f *transformFilter) FilterFloats(a *tsdb.FloatArray) (err error) {
+	a.Timestamps, a.Values, err = transformArray(f.hook, f.series, a.Timestamps, a.Values)
+	return
 }
 
-// Read creates a ResultSet that reads all points with a timestamp ts, such that start ≤ ts < end.
-func (e *exportWorker) read(min, max time.Time) (*storage.ResultSet, error) {
-	shards, err := e.getShards(min, max)
-	if err != nil {
-		return nil, err
-	}
+func (f *transformFilter) FilterIntegers(a *tsdb.IntegerArray) (err error) {
+	a.Timestamps, a.Values, err = transformArray(f.hook, f.series, a.Timestamps, a.Values)
+	return
+}
 
-	req := storage.ReadRequest{
-		Database: e.db,
-		RP:       e.rp,
-		Shards:   shards,
-		Start:    min.UnixNano(),
-		End:      max.UnixNano(),
-	}
+func (f *transformFilter) FilterUnsigneds(a *tsdb.UnsignedArray) (err error) {
+	a.Timestamps, a.Values, err = transformArray(f.hook, f.series, a.Timestamps, a.Values)
+	return
+}
 
-	return e.store.Read(context.Background(), &req)
+func (f *transformFilter) FilterBooleans(a *tsdb.BooleanArray) (err error) {
+	a.Timestamps, a.Values, err = transformArray(f.hook, f.series, a.Timestamps, a.Values)
+	return
 }
 
-func (e *exportWorker) getShards(min, max time.Time) ([]*tsdb.Shard, error) {
-	groups := e.shardsGroupsByTimeRange(min, max)
-	var ids []uint64
-	for _, g := range groups {
-		for _, s := range g.Shards {
-			ids = append(ids, s.ID)
-		}
-	}
+func (f *transformFilter) FilterStrings(a *tsdb.StringArray) (err error) {
+	a.Timestamps, a.Values, err = transformArray(f.hook, f.series, a.Timestamps, a.Values)
+	return
+}
 
-	shards := e.tsdbStore.Shards(ids)
-	if len(shards) == len(ids) {
-		return shards, nil
+// transformArray transforms the values at the timestamps in place, and returns the ones kept.
+func transformArray[T any](h *transform.Hook, s *transform.Series, timestamps []int64, values []T) ([]int64, []T, error) {
+	j := 0
+	for i, v := range values {
+		tv, ok, err := h.Value(s, timestamps[i], v)
+		if err != nil {
+			return timestamps, values, err
+		}
+		if ok {
+			timestamps[j], values[j] = timestamps[i], tv.(T)
+			j++
+		}
 	}
-
-	return e.openStoreWithShardsIDs(ids)
+	return timestamps[:j], values[:j], nil
 }
 
-func (e *exportWorker) shardsGroupsByTimeRange(min, max time.Time) []meta.ShardGroupInfo {
-	groups := make([]meta.ShardGroupInfo, 0, len(e.sourceGroups))
-	for _, g := range e.sourceGroups {
-		if !g.Overlaps(min, max) {
-			continue
+// writtenSeries returns the series of the node indexes the shard group with the start time is written to.
+func (e *exporter) writtenSeries(prChans map[int]chan *nio.PipeReader, series map[int][]*binary.SeriesInfo, start int64) map[int][]*binary.SeriesInfo {
+	written := make(map[int][]*binary.SeriesInfo, len(series))
+	for idx, infos := range series {
+		if _, ok := prChans[idx]; ok && !e.skipped(idx, start) {
+			written[idx] = infos
 		}
-		groups = append(groups, g)
 	}
-	return groups
+	return written
 }
 
-func (e *exportWorker) openStoreWithShardsIDs(ids []uint64) ([]*tsdb.Shard, error) {
-	e.tsdbStore.Close()
-	e.tsdbStore.EngineOptions.ShardFilter = func(_, _ string, id uint64) bool {
-		for i := range ids {
-			if id == ids[i] {
-				return true
-			}
+// writeBucket writes the series of the shards to the streams of their targets, the points of a series written to
+// several targets are read once.
+func (e *exporter) writeBucket(prChans map[int]chan *nio.PipeReader, shards []*source.Shard, series map[int][]*binary.SeriesInfo, min, max time.Time, cs circles, s *hash.SpreadShard) error {
+	pws := make(map[int]*nio.PipeWriter)
+	wrs := make(map[int]*binary.Writer)
+	bws := make(map[int]*binary.BucketWriter)
+	defer func() {
+		for _, bw := range bws {
+			bw.Close()
 		}
-		return false
+		for _, wr := range wrs {
+			wr.Close()
+		}
+		for _, pw := range pws {
+			pw.Close()
+		}
+	}()
+
+	// bucketWriter returns the bucket writer of the target, or nil if the target is not written
+	bucketWriter := func(nodeIndex int) (*binary.BucketWriter, error) {
+		prChan, pok := prChans[nodeIndex]
+		if !pok || e.skipped(nodeIndex, min.UnixNano()) {
+			return nil, nil
+		}
+		if bw, bok := bws[nodeIndex]; bok {
+			return bw, nil
+		}
+		buf := buffer.New(int64(4 * 1024 * 1024))
+		pr, pw := nio.Pipe(buf)
+		pws[nodeIndex] = pw
+		wr := binary.NewWriter(pw, e.db, e.rp, e.sd)
+		wr.SetSeries(series[nodeIndex])
+		wrs[nodeIndex] = wr
+		bw, err := wr.NewBucket(min.UnixNano(), max.UnixNano())
+		if err != nil {
+			return nil, err
+		}
+		bws[nodeIndex] = bw
+		prChan <- pr
+		return bw, nil
 	}
-	if err := e.tsdbStore.Open(); err != nil {
-		return nil, err
+	// the bucket is written to each target of the series even if none of the points are in the shard group,
+	// so that the target records the shard group transferred
+	nodeIndexes := make([]int, 0, len(series))
+	for nodeIndex := range series {
+		nodeIndexes = append(nodeIndexes, nodeIndex)
+	}
+	sort.Ints(nodeIndexes)
+	for _, nodeIndex := range nodeIndexes {
+		if _, err := bucketWriter(nodeIndex); err != nil {
+			return err
+		}
 	}
-	return e.tsdbStore.Shards(ids), nil
+
+	var targets []int
+	var writers []*binary.BucketWriter
+	return source.ReadMergedShards(e.src, shards, min.UnixNano(), max.UnixNano()-1, func(seriesKey, field []byte, values []tsm1.Value) error {
+		name, tags := models.ParseKeyBytes(seriesKey)
+		if !e.selected(name, tags) {
+			return nil
+		}
+		if e.discard(name, tags) {
+			log.Printf("discard escaped measurement: %s, tags: %s", name, tags)
+			return nil
+		}
+		// the series are handled as read by readSeries, but counted once here
+		tags, field, r := e.empty.Handle(tags, field)
+		e.empty.Add(r, 1)
+		if r == empty.PointDropped {
+			return nil
+		}
+		name, tags, field, ts, ok := e.transformSeries(name, tags, field)
+		if !ok {
+			e.transform.DropSeries()
+			return nil
+		}
+		targets = cs.targets(targets[:0], s.GetSeriesKey(e.db, e.rp, name, tags))
+		writers = writers[:0]
+		for _, nodeIndex := range targets {
+			bw, err := bucketWriter(nodeIndex)
+			if err != nil {
+				return err
+			}
+			if bw != nil {
+				writers = append(writers, bw)
+			}
+		}
+		if len(writers) == 0 {
+			return nil
+		}
+		var af binary.ArrayFilter
+		if ts != nil {
+			af = &transformFilter{hook: e.transform, series: ts}
+		}
+		return binary.WriteValuesTo(writers, name, field, source.ValueType(values[0]), tags, values, e.floatFilter(), af)
+	})
 }
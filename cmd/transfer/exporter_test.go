@@ -0,0 +1,67 @@
+package transfer
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/pkg/transform"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb"
+)
+
+func TestFloatFilter(t *testing.T) {
+	if (&exporter{nonfinite: nonfiniteKeep}).floatFilter() != nil {
+		t.Error("non-finite values filtered in keep mode")
+	}
+	for mode, exp := range map[string]*tsdb.FloatArray{
+		nonfiniteDrop: {Timestamps: []int64{1}, Values: []float64{1.5}},
+		nonfiniteZero: {Timestamps: []int64{0, 1, 2}, Values: []float64{0, 1.5, 0}},
+	} {
+		e := &exporter{nonfinite: mode}
+		a := &tsdb.FloatArray{Timestamps: []int64{0, 1, 2}, Values: []float64{math.NaN(), 1.5, math.Inf(-1)}}
+		e.floatFilter()(a)
+		if !reflect.DeepEqual(a, exp) || e.nonfinites.Load() != 2 {
+			t.Errorf("%s: got %v of %d non-finite values", mode, a, e.nonfinites.Load())
+		}
+	}
+}
+
+// doubleTransformer doubles the integer values and drops the odd ones of the series of host a, and drops the others.
+// The float values are converted to integers, which is invalid.
+type doubleTransformer struct{}
+
+func (doubleTransformer) Series(s *transform.Series) bool {
+	return s.Tags["host"] == "a"
+}
+
+func (doubleTransformer) Value(s *transform.Series, v *transform.Value) bool {
+	if f, ok := v.Value.(float64); ok {
+		v.Value = int64(f)
+		return true
+	}
+	i := v.Value.(int64)
+	v.Value = i * 2
+	return i%2 == 0
+}
+
+func TestTransformSeries(t *testing.T) {
+	e := &exporter{db: "db", rp: "rp", transform: transform.NewHook(doubleTransformer{})}
+	if _, _, _, _, ok := e.transformSeries([]byte("cpu"), models.NewTags(map[string]string{"host": "b"}), []byte("v")); ok {
+		t.Error("series of host b not dropped")
+	}
+	_, _, _, ts, ok := e.transformSeries([]byte("cpu"), models.NewTags(map[string]string{"host": "a"}), []byte("v"))
+	if !ok {
+		t.Fatal("series of host a dropped")
+	}
+	a := &tsdb.IntegerArray{Timestamps: []int64{0, 1, 2, 3}, Values: []int64{0, 1, 2, 3}}
+	if err := (&transformFilter{hook: e.transform, series: ts}).FilterIntegers(a); err != nil {
+		t.Fatal(err)
+	}
+	if exp := (&tsdb.IntegerArray{Timestamps: []int64{0, 2}, Values: []int64{0, 4}}); !reflect.DeepEqual(a, exp) {
+		t.Errorf("unexpected array: %v", a)
+	}
+	if err := (&transformFilter{hook: e.transform, series: ts}).FilterFloats(&tsdb.FloatArray{Timestamps: []int64{0}, Values: []float64{0}}); err == nil {
+		t.Error("expect error of float values")
+	}
+}
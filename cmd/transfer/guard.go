@@ -0,0 +1,113 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/chengshiwen/influx-tool/internal/binary"
+)
+
+const (
+	maxSeriesAbort = "abort"
+	maxSeriesWarn  = "warn"
+)
+
+var errMaxSeries = errors.New("max series per node exceeded")
+
+// seriesGuard counts the distinct series transferred to each node, so that a node exceeding max-series-per-database
+// of the influxdb behind influx-proxy is found during the transfer instead of by the writes rejected after it.
+// The series are counted by the hashes of their keys across the shard groups and retention policies transferred.
+type seriesGuard struct {
+	mu     sync.Mutex
+	max    int
+	abort  bool
+	seed   maphash.Seed
+	series map[int]map[uint64]struct{}
+	warned map[int]bool
+
+	nodeName func(idx int) string // name of the node index in messages
+}
+
+func newSeriesGuard(max int, action string) *seriesGuard {
+	return &seriesGuard{
+		max:    max,
+		abort:  action == maxSeriesAbort,
+		seed:   maphash.MakeSeed(),
+		series: make(map[int]map[uint64]struct{}),
+		warned: make(map[int]bool),
+		nodeName: func(idx int) string {
+			return fmt.Sprintf("node index %d", idx)
+		},
+	}
+}
+
+// add adds the series of a shard group by node index. An error is returned if a node would exceed the max series and
+// the action is abort, then none of the series is added and the shard group should not be written.
+func (g *seriesGuard) add(series map[int][]*binary.SeriesInfo) error {
+	if g == nil || g.max <= 0 {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	added := make(map[int]map[uint64]struct{})
+	for idx, infos := range series {
+		for _, si := range infos {
+			h := maphash.Bytes(g.seed, si.SeriesKey)
+			if _, ok := g.series[idx][h]; ok {
+				continue
+			}
+			if added[idx] == nil {
+				added[idx] = make(map[uint64]struct{})
+			}
+			added[idx][h] = struct{}{}
+		}
+	}
+	for _, idx := range sortedNodes(added) {
+		n := len(g.series[idx]) + len(added[idx])
+		if n <= g.max {
+			continue
+		}
+		if g.abort {
+			return fmt.Errorf("%w: %s would have %d series, max %d", errMaxSeries, g.nodeName(idx), n, g.max)
+		}
+		if !g.warned[idx] {
+			g.warned[idx] = true
+			log.Printf("warning: %s has %d series, exceeding max series per node %d", g.nodeName(idx), n, g.max)
+		}
+	}
+	for idx, hashes := range added {
+		if g.series[idx] == nil {
+			g.series[idx] = make(map[uint64]struct{}, len(hashes))
+		}
+		for h := range hashes {
+			g.series[idx][h] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// logSeries logs the series counted by node index.
+func (g *seriesGuard) logSeries() {
+	if g == nil || g.max <= 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, idx := range sortedNodes(g.series) {
+		log.Printf("%s series: %d, max: %d", g.nodeName(idx), len(g.series[idx]), g.max)
+	}
+}
+
+func sortedNodes(m map[int]map[uint64]struct{}) []int {
+	idxs := make([]int, 0, len(m))
+	for idx := range m {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	return idxs
+}
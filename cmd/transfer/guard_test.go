@@ -0,0 +1,47 @@
+package transfer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/binary"
+)
+
+func TestSeriesGuard(t *testing.T) {
+	series := func(keys ...string) []*binary.SeriesInfo {
+		var infos []*binary.SeriesInfo
+		for _, key := range keys {
+			// the fields of a series are counted once
+			infos = append(infos, &binary.SeriesInfo{SeriesKey: []byte(key), Field: []byte("a")}, &binary.SeriesInfo{SeriesKey: []byte(key), Field: []byte("b")})
+		}
+		return infos
+	}
+
+	g := newSeriesGuard(2, maxSeriesAbort)
+	if err := g.add(map[int][]*binary.SeriesInfo{0: series("cpu,host=a", "cpu,host=b"), 1: series("mem")}); err != nil {
+		t.Fatal(err)
+	}
+	// the series of a later shard group are counted once
+	if err := g.add(map[int][]*binary.SeriesInfo{0: series("cpu,host=a"), 1: series("mem", "disk")}); err != nil {
+		t.Fatal(err)
+	}
+	err := g.add(map[int][]*binary.SeriesInfo{0: series("cpu,host=c"), 1: series("net")})
+	if !errors.Is(err, errMaxSeries) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(g.series[0]) != 2 || len(g.series[1]) != 2 {
+		t.Errorf("series added by the aborted shard group: %d, %d", len(g.series[0]), len(g.series[1]))
+	}
+
+	g = newSeriesGuard(1, maxSeriesWarn)
+	if err = g.add(map[int][]*binary.SeriesInfo{0: series("cpu,host=a", "cpu,host=b")}); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.series[0]) != 2 || !g.warned[0] {
+		t.Errorf("unexpected guard: series=%d, warned=%v", len(g.series[0]), g.warned[0])
+	}
+
+	if err = newSeriesGuard(0, maxSeriesAbort).add(map[int][]*binary.SeriesInfo{0: series("cpu")}); err != nil {
+		t.Errorf("unexpected error of unlimited guard: %v", err)
+	}
+}
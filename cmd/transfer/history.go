@@ -0,0 +1,105 @@
+package transfer
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/history"
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+// stats are the statistics of the transfer, recorded into the history file to estimate the duration of later ones.
+type stats struct {
+	mu     sync.Mutex
+	path   string
+	name   string
+	est    *history.Estimator
+	start  time.Time
+	sizes  map[uint64]int64 // size of target shard groups by id
+	total  int64
+	done   int64
+	series int64
+}
+
+// startStats loads the previous runs of name from the history file, and logs the estimate of the shard groups
+// not skipped.
+func (e *exporter) startStats(historyFile, name string) {
+	st := &e.stats
+	st.path, st.name, st.start = history.Path(historyFile), name, time.Now()
+	st.sizes = make(map[uint64]int64)
+	shardSizes := make(map[uint64]int64)
+	for _, g := range e.targetGroups {
+		if e.skippedAll(g.StartTime.UnixNano()) {
+			continue
+		}
+		st.sizes[g.ID] = e.groupSize(g, shardSizes)
+		st.total += st.sizes[g.ID]
+	}
+	var records []history.Record
+	if st.path != "" {
+		var err error
+		if records, err = history.Load(st.path, st.name); err != nil {
+			log.Printf("load history error: %v", err)
+		}
+	}
+	st.est = history.NewEstimator(records)
+	if st.total > 0 {
+		log.Print(st.est.String(st.total))
+	}
+}
+
+// groupSize returns the size of the source shards read for the target group, in proportion to the time overlapped.
+func (e *exporter) groupSize(g meta.ShardGroupInfo, shardSizes map[uint64]int64) int64 {
+	var size float64
+	for _, sh := range e.shardsOf(g.StartTime, g.EndTime) {
+		if _, ok := shardSizes[sh.ID]; !ok {
+			shardSizes[sh.ID] = sh.Size()
+		}
+		start, end := sh.StartTime, sh.EndTime+1
+		if t := g.StartTime.UnixNano(); t > start {
+			start = t
+		}
+		if t := g.EndTime.UnixNano(); t < end {
+			end = t
+		}
+		size += float64(shardSizes[sh.ID]) * float64(end-start) / float64(sh.EndTime+1-sh.StartTime)
+	}
+	return int64(size)
+}
+
+// addSeries adds the series fields read to the statistics.
+func (e *exporter) addSeries(n int) {
+	e.stats.mu.Lock()
+	e.stats.series += int64(n)
+	e.stats.mu.Unlock()
+}
+
+// groupDone adds the shard group transferred to the progress, which is returned.
+func (e *exporter) groupDone(g meta.ShardGroupInfo) string {
+	st := &e.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.est == nil || st.total <= 0 {
+		return ""
+	}
+	st.done += st.sizes[g.ID]
+	return ", " + st.est.Progress(st.done, st.total, time.Since(st.start))
+}
+
+// record returns the statistics of the transfer so far.
+func (e *exporter) record() history.Record {
+	st := &e.stats
+	return history.NewRecord(st.name, st.total, st.series, time.Since(st.start))
+}
+
+// saveStats appends the statistics of the transfer finished to the history file.
+func (e *exporter) saveStats() {
+	st := &e.stats
+	if st.path == "" || st.total <= 0 {
+		return
+	}
+	if err := history.Append(st.path, e.record()); err != nil {
+		log.Printf("save history error: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/empty"
+	"github.com/chengshiwen/influx-tool/internal/notify"
+	"github.com/chengshiwen/influx-tool/pkg/plan"
+	"github.com/spf13/cobra"
+)
+
+type planCommand struct {
+	*command
+	json bool
+}
+
+// NewPlanCommand returns the command printing the plan of a transfer, which takes the same flags as the transfer.
+func NewPlanCommand() *cobra.Command {
+	tf := &tempflag{}
+	// the flags of transfer not taken by plan are set to their defaults to pass the validation
+	cmd := &planCommand{command: &command{
+		nodeIndex:       make(intSet),
+		maxSeriesAction: maxSeriesAbort,
+		emptyMode:       empty.ModeKeep,
+		nonfinite:       nonfiniteKeep,
+		webhook:         notify.Webhook{Format: notify.FormatJSON},
+	}}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "transfer",
+		Short:         "Print the mapping of source shard groups to target shard groups and nodes of a transfer",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE(tf)
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.sourceDir, "source-dir", "s", "", "source influxdb directory containing meta, data and wal (required)")
+	flags.StringVarP(&cmd.targetDir, "target-dir", "t", "", "target influxdb directory containing meta, data and wal (required)")
+	flags.StringVarP(&cmd.database, "database", "d", "", "database name (required)")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "autogen", "retention policy")
+	flags.DurationVar(&cmd.shardDuration, "shard-duration", time.Hour*24*7, "retention policy shard duration")
+	flags.StringVarP(&tf.start, "start", "S", "", "start time to transfer (RFC3339 format, optional)")
+	flags.StringVarP(&tf.end, "end", "E", "", "end time to transfer (RFC3339 format, optional)")
+	flags.BoolVar(&cmd.skipTsi, "skip-tsi", false, "skip building TSI index on disk (default: false)")
+	flags.IntVarP(&cmd.nodeTotal, "node-total", "n", 1, "total number of node in target circle")
+	flags.StringVarP(&cmd.hashKey, "hash-key", "k", "idx", "hash key for influx proxy: idx, exi or template containing %idx")
+	flags.StringVarP(&cmd.shardKey, "shard-key", "K", "%db,%mm", "shard key for influx proxy, which containing %db, %rp or %mm")
+	flags.StringSliceVar(&cmd.spreadMms, "spread-measurement", nil, "measurements too large for one node delimited by comma, whose series are routed across the nodes by spread-tag (default: none)")
+	flags.StringVar(&cmd.spreadTag, "spread-tag", "", "tag whose value is appended to the shard key of the series of spread-measurement, as the secondary routing key of influx proxy")
+	flags.StringVar(&cmd.escapeMode, "escape-mode", escapeModeDiscard, "mode of the series whose measurement or tags contain comma, space or equals: discard to skip them, or escape to transfer them escaped")
+	flags.BoolVar(&cmd.json, "json", false, "print the plan as json (default: false)")
+	cmd.cobraCmd.MarkFlagRequired("source-dir")
+	cmd.cobraCmd.MarkFlagRequired("target-dir")
+	cmd.cobraCmd.MarkFlagRequired("database")
+	return cmd.cobraCmd
+}
+
+func (cmd *planCommand) runE(tf *tempflag) error {
+	if err := cmd.validate(tf); err != nil {
+		return err
+	}
+	if len(splitList(cmd.retentionPolicy)) > 1 {
+		return errors.New("retention-policy is invalid, only one retention policy can be planned")
+	}
+	p, err := plan.PlanTransfer(plan.TransferOptions{
+		SourceDir:       cmd.sourceDir,
+		TargetDir:       cmd.targetDir,
+		Database:        cmd.database,
+		RetentionPolicy: cmd.retentionPolicy,
+		ShardDuration:   cmd.shardDuration,
+		Start:           cmd.startTime,
+		End:             cmd.endTime,
+		NodeTotal:       cmd.nodeTotal,
+		HashKey:         cmd.hashKey,
+		ShardKey:        cmd.shardKey,
+		Spread:          cmd.spreadMms,
+		SpreadTag:       cmd.spreadTag,
+		Escape:          cmd.escapeMode == escapeModeEscape,
+		TSI:             !cmd.skipTsi,
+	})
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	}
+	return writePlan(os.Stdout, p, cmd.nodeTotal)
+}
+
+// writePlan writes the plan as text, one block per target shard group.
+func writePlan(w io.Writer, p *plan.Transfer, nodeTotal int) error {
+	fmt.Fprintf(w, "transfer %s.%s with shard duration %s to %d nodes: %d target shard groups\n", p.Database, p.RetentionPolicy, p.ShardDuration, nodeTotal, len(p.Groups))
+	for _, g := range p.Groups {
+		fmt.Fprintf(w, "\ntarget shard group %s - %s\n", g.StartTime.Format(time.RFC3339), g.EndTime.Format(time.RFC3339))
+		for _, s := range g.Sources {
+			fmt.Fprintf(w, "  <- source shard group %d %s - %s, shards %v\n", s.ID, s.StartTime.Format(time.RFC3339), s.EndTime.Format(time.RFC3339), s.Shards)
+		}
+		for _, n := range g.Nodes {
+			fmt.Fprintf(w, "  -> node %d: %d measurements, %d series, %s/<shard id>, %s/<shard id>\n", n.Index, n.Measurements, n.Series, n.DataDir, n.WALDir)
+		}
+		if g.Discarded > 0 {
+			fmt.Fprintf(w, "  discarded: %d series which need escaping\n", g.Discarded)
+		}
+	}
+	_, err := fmt.Fprintln(w, "\nshard ids are assigned by the meta of each node when imported")
+	return err
+}
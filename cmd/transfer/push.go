@@ -0,0 +1,199 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/agent"
+	"github.com/chengshiwen/influx-tool/internal/empty"
+	"github.com/chengshiwen/influx-tool/internal/events"
+	"github.com/chengshiwen/influx-tool/internal/notify"
+	"github.com/chengshiwen/influx-tool/internal/ratelimit"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/djherbis/nio/v3"
+	"github.com/spf13/cobra"
+)
+
+type pushCommand struct {
+	*command
+	addr           string
+	config         agent.ClientConfig
+	maxNetworkMbps float64
+	maxNodeMbps    float64
+	timeout        time.Duration
+}
+
+func newPushCommand() *cobra.Command {
+	tf := &tempflag{}
+	cmd := &pushCommand{command: &command{nodeIndex: make(intSet)}}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "push",
+		Short:         "Push influxdb persist data on disk to a transfer agent",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			start := time.Now()
+			err := cmd.runE(tf)
+			cmd.finish(err)
+			cmd.notify(start, err)
+			return err
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.addr, "agent", "a", "", "transfer agent address like host:port (required)")
+	flags.StringVarP(&cmd.sourceDir, "source-dir", "s", "", "source influxdb directory containing meta, data and wal (required)")
+	flags.StringVarP(&cmd.database, "database", "d", "", "database name (required)")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "autogen", "retention policy")
+	flags.DurationVar(&cmd.duration, "duration", time.Hour*0, "retention policy duration (default: 0)")
+	flags.DurationVar(&cmd.shardDuration, "shard-duration", time.Hour*24*7, "retention policy shard duration")
+	flags.StringVarP(&tf.start, "start", "S", "", "start time to transfer (RFC3339 format, optional)")
+	flags.StringVarP(&tf.end, "end", "E", "", "end time to transfer (RFC3339 format, optional)")
+	flags.IntVarP(&cmd.worker, "worker", "w", 0, "number of concurrent workers to transfer (default: 0, unlimited)")
+	flags.IntVarP(&cmd.nodeTotal, "node-total", "n", 1, "total number of node in target circle")
+	flags.VarP(&cmd.nodeIndex, "node-index", "i", "index of node in target circle delimited by comma, [0, node-total) (default: all)")
+	flags.StringVarP(&cmd.hashKey, "hash-key", "k", "idx", "hash key for influx proxy: idx, exi or template containing %idx")
+	flags.StringVarP(&cmd.shardKey, "shard-key", "K", "%db,%mm", "shard key for influx proxy, which containing %db, %rp or %mm")
+	flags.StringSliceVar(&cmd.spreadMms, "spread-measurement", nil, "measurements too large for one node delimited by comma, whose series are routed across the nodes by spread-tag (default: none)")
+	flags.StringVar(&cmd.spreadTag, "spread-tag", "", "tag whose value is appended to the shard key of the series of spread-measurement, as the secondary routing key of influx proxy")
+	flags.BoolVar(&cmd.explain, "explain-routing", false, "print the routing function and the node index of sample measurements, then exit without transferring (default: false)")
+	flags.StringVar(&cmd.config.Token, "token", "", "token to authenticate to the agent")
+	flags.BoolVar(&cmd.config.TLS, "tls", false, "connect to the agent with tls (default: false)")
+	flags.StringVar(&cmd.config.TLSCA, "tls-ca", "", "ca certificate file to verify the agent (default: system roots)")
+	flags.BoolVar(&cmd.config.InsecureSkipVerify, "tls-skip-verify", false, "skip verifying the agent certificate (default: false)")
+	flags.BoolVar(&cmd.config.Compress, "compress", false, "compress the stream with gzip (default: false)")
+	flags.Float64Var(&cmd.maxNetworkMbps, "max-network-mbps", 0, "max bandwidth in Mbps shared by all nodes, measured before compression (default: 0, unlimited)")
+	flags.Float64Var(&cmd.maxNodeMbps, "max-node-mbps", 0, "max bandwidth in Mbps per node, measured before compression (default: 0, unlimited)")
+	flags.DurationVar(&cmd.timeout, "timeout", 0, "timeout of requests to the agent, each push of a shard group is a request (default: 0, no timeout)")
+	flags.DurationVar(&cmd.deadline, "deadline", 0, "deadline of the whole push, after which the pushes in progress are canceled, resumed by the next push (default: 0, no deadline)")
+	flags.IntVar(&cmd.maxSeries, "max-series-per-node", 0, "max series pushed to a node, checked before each shard group is pushed (default: 0, unlimited)")
+	flags.StringVar(&cmd.maxSeriesAction, "max-series-action", maxSeriesAbort, "action once a node exceeds max series per node: abort to stop before pushing the shard group, or warn to log and continue")
+	flags.StringVar(&cmd.escapeMode, "escape-mode", escapeModeDiscard, "mode of the series whose measurement or tags contain comma, space or equals: discard to skip them, or escape to push them escaped")
+	flags.StringVar(&tf.seriesFile, "series-file", "", "file of the series keys to push, one per line like cpu,host=a,region=us as written by influx_inspect, the other series are skipped (default: none)")
+	flags.StringVar(&cmd.emptyMode, "empty-mode", empty.ModeKeep, "handling of the series with empty tag keys, tag values or field names rejected by influxdb: keep, drop-tag to drop the empty tags and the points of empty fields, drop-point, or placeholder to substitute empty-placeholder")
+	flags.StringVar(&cmd.placeholder, "empty-placeholder", "_", "placeholder of the empty tag keys, tag values and field names in placeholder empty mode")
+	flags.StringVar(&cmd.nonfinite, "nonfinite", nonfiniteKeep, "handling of NaN and Inf float values rejected by influxdb writes: keep, drop or zero")
+	flags.StringVar(&tf.transform, "transform", "", "go plugin exporting a Transformer of package pkg/transform, which filters, modifies and routes the series and values pushed (default: none)")
+	flags.StringVar(&cmd.webhook.URL, "notify-webhook", "", "url posted with the summary and statistics as json once the push finishes or fails (default: none)")
+	flags.StringVar(&cmd.webhook.Format, "notify-format", notify.FormatJSON, "payload format of notify-webhook: json, or slack for slack incoming webhooks")
+	flags.StringVar(&cmd.eventsFile, "events-file", "", "file appended with the events of the push as json lines, such as the start and finish of shard groups, checkpoints and errors (default: none)")
+	flags.StringVar(&cmd.historyFile, "history-file", "", "file recording the statistics of pushes to estimate the duration of later ones, '-' to disable (default: ~/.influx-tool/history.jsonl)")
+	cmd.cobraCmd.MarkFlagRequired("agent")
+	cmd.cobraCmd.MarkFlagRequired("source-dir")
+	cmd.cobraCmd.MarkFlagRequired("database")
+	return cmd.cobraCmd
+}
+
+func (cmd *pushCommand) validate(tf *tempflag) error {
+	if err := cmd.command.validate(tf); err != nil {
+		return err
+	}
+	if len(splitList(cmd.retentionPolicy)) > 1 {
+		return errors.New("retention-policy is invalid, only one retention policy can be pushed")
+	}
+	if cmd.maxNetworkMbps < 0 {
+		return errors.New("max-network-mbps is invalid")
+	}
+	if cmd.maxNodeMbps < 0 {
+		return errors.New("max-node-mbps is invalid")
+	}
+	if cmd.timeout < 0 {
+		return errors.New("timeout is invalid")
+	}
+	return nil
+}
+
+func (cmd *pushCommand) runE(tf *tempflag) error {
+	if err := cmd.validate(tf); err != nil {
+		return err
+	}
+	if err := cmd.openEvents(); err != nil {
+		return err
+	}
+	exportServer, err := server.NewServer(cmd.sourceDir, !cmd.skipTsi)
+	if err != nil {
+		return err
+	}
+	defer exportServer.Close()
+	exp, err := newExporter(exportServer, cmd.database, cmd.retentionPolicy, cmd.shardDuration, cmd.startTime, cmd.endTime)
+	if err != nil {
+		return err
+	}
+	if cmd.explain {
+		return cmd.explainRouting(os.Stdout, exp)
+	}
+
+	client, err := agent.Dial(cmd.addr, cmd.config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	log.SetFlags(log.LstdFlags)
+	cmd.warnCircle()
+	ctx, cancel := cmd.context()
+	defer cancel()
+	// resume from the shard groups already imported by the agent
+	for idx := range cmd.nodeIndex {
+		rctx, rcancel := cmd.requestContext(ctx)
+		starts, err := client.Status(rctx, &agent.StatusRequest{Node: idx, Database: exp.db, RetentionPolicy: exp.rp})
+		rcancel()
+		if err != nil {
+			return err
+		}
+		if len(starts) > 0 {
+			log.Printf("node index %d resumes with %d shard groups transferred", idx, len(starts))
+		}
+		exp.Skip(idx, starts)
+	}
+
+	global := ratelimit.NewLimiterMbps(cmd.maxNetworkMbps)
+	return cmd.transfer(ctx, exp, func(idx int, prChan chan *nio.PipeReader) {
+		cmd.pushNode(ctx, client, exp, prChan, idx, global, ratelimit.NewLimiterMbps(cmd.maxNodeMbps))
+	})
+}
+
+// requestContext returns the context of a request to the agent, which is canceled once the timeout exceeded.
+func (cmd *pushCommand) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if cmd.timeout > 0 {
+		return context.WithTimeout(ctx, cmd.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (cmd *pushCommand) pushNode(ctx context.Context, client *agent.Client, exp *exporter, prChan chan *nio.PipeReader, idx int, limiters ...*ratelimit.Limiter) {
+	log.Printf("node index %d push start", idx)
+	pi := &agent.PushInfo{
+		Node:            idx,
+		Database:        exp.db,
+		RetentionPolicy: exp.rp,
+		Duration:        cmd.duration,
+		ShardDuration:   cmd.shardDuration,
+	}
+	wg := &sync.WaitGroup{}
+	for pr := range prChan {
+		wg.Add(1)
+		pr := pr
+		go func() {
+			defer wg.Done()
+			defer pr.Close()
+
+			rctx, rcancel := cmd.requestContext(ctx)
+			defer rcancel()
+			if err := client.Push(rctx, pi, ratelimit.NewReader(pr, limiters...)); err != nil {
+				log.Printf("push error: %s, idx: %d", err, idx)
+				cmd.emitNodeError(exp, idx, "push", err)
+				return
+			}
+			// the shard group pushed is imported by the agent, and resumed by the next push
+			cmd.events.Emit(cmd.nodeEvent(events.TypeCheckpoint, exp, idx))
+		}()
+	}
+	wg.Wait()
+	log.Printf("node index %d push done", idx)
+}
@@ -0,0 +1,412 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/audit"
+	"github.com/chengshiwen/influx-tool/internal/empty"
+	"github.com/chengshiwen/influx-tool/internal/notify"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/djherbis/nio/v3"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/spf13/cobra"
+)
+
+// metaBackupFile is the copy of the meta of the source in the staging directory, taken before the meta is mutated.
+const metaBackupFile = "meta.db.reshard"
+
+type reshardCommand struct {
+	*command
+	readMode string
+	force    bool
+}
+
+// NewReshardCommand returns the command rewriting the shards of a retention policy with a new shard duration in place.
+// The shards are transferred into a staging directory first, and swapped with the ones of the retention policy once
+// all done, so that an interrupted reshard leaves the source untouched and resumes from the staging directory.
+func NewReshardCommand() *cobra.Command {
+	tf := &tempflag{}
+	// the flags of transfer not taken by reshard are set to transfer every series and value as they are
+	cmd := &reshardCommand{command: &command{
+		nodeIndex:       make(intSet),
+		nodeTotal:       1,
+		hashKey:         "idx",
+		shardKey:        "%db,%mm",
+		maxSeriesAction: maxSeriesAbort,
+		escapeMode:      escapeModeEscape,
+		emptyMode:       empty.ModeKeep,
+		nonfinite:       nonfiniteKeep,
+		webhook:         notify.Webhook{Format: notify.FormatJSON},
+	}}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "reshard",
+		Short:         "Rewrite the overlapping shards of a retention policy on disk with a new shard duration",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Annotations:   map[string]string{audit.Annotation: ""},
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE(tf)
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.sourceDir, "dir", "D", "", "influxdb directory containing meta, data and wal, influxd must be stopped (required)")
+	flags.StringVarP(&cmd.database, "database", "d", "", "database name (required)")
+	flags.StringVarP(&cmd.retentionPolicy, "retention-policy", "r", "", "retention policy to reshard (default: default retention policy of database)")
+	flags.DurationVar(&cmd.shardDuration, "shard-duration", 0, "new shard duration of retention policy (required)")
+	flags.StringVar(&cmd.targetDir, "staging-dir", "", "staging influxdb directory the shards are rewritten into before swapped, suffixed with -0 like a node directory of transfer (default: <dir>-reshard)")
+	flags.IntVarP(&cmd.worker, "worker", "w", 0, "number of concurrent workers to reshard (default: 0, unlimited)")
+	flags.BoolVar(&cmd.skipTsi, "skip-tsi", false, "skip building TSI index of the shards rewritten, set index-version of influxd to inmem (default: false)")
+	flags.StringVar(&cmd.readMode, "tsm-read-mode", tsmread.ModeMmap, "mode of reading tsm files to index: mmap, or buffered for the filesystems where mmap performs poorly or faults, such as nfs")
+	flags.BoolVarP(&cmd.force, "force", "f", false, "force reshard without prompting (default: false)")
+	cmd.cobraCmd.MarkFlagRequired("dir")
+	cmd.cobraCmd.MarkFlagRequired("database")
+	cmd.cobraCmd.MarkFlagRequired("shard-duration")
+	return cmd.cobraCmd
+}
+
+func (cmd *reshardCommand) validate(tf *tempflag) error {
+	if cmd.shardDuration <= 0 {
+		return errors.New("shard-duration is invalid")
+	}
+	if !tsmread.ValidMode(cmd.readMode) {
+		return errors.New("tsm-read-mode is invalid, require mmap or buffered")
+	}
+	if cmd.targetDir == "" {
+		cmd.targetDir = strings.TrimRight(cmd.sourceDir, "/") + "-reshard"
+	}
+	if filepath.Clean(nodeDir(cmd.targetDir, 0)) == filepath.Clean(cmd.sourceDir) {
+		return errors.New("staging-dir cannot be the same as dir")
+	}
+	cmd.historyFile = "-"
+	return cmd.command.validate(tf)
+}
+
+func (cmd *reshardCommand) runE(tf *tempflag) error {
+	if err := cmd.validate(tf); err != nil {
+		return err
+	}
+	// the meta backed up is left by a swap failed, and the source is to be restored from it before any reshard
+	if backup := filepath.Join(cmd.nodeDir(0), metaBackupFile); exists(backup) {
+		return fmt.Errorf("previous reshard failed to swap, restore %s from %s or remove it", filepath.Join(cmd.sourceDir, "meta", "meta.db"), backup)
+	}
+	svr, err := server.NewServer(cmd.sourceDir, !cmd.skipTsi)
+	if err != nil {
+		return err
+	}
+	defer svr.Close()
+	exp, err := newExporter(svr, cmd.database, cmd.retentionPolicy, cmd.shardDuration, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	rpi, err := svr.MetaClient().RetentionPolicy(exp.db, exp.rp)
+	if err != nil {
+		return err
+	}
+
+	log.SetFlags(0)
+	overlapped := overlappedGroups(exp.sourceGroups)
+	log.Printf("%s.%s: %d shard groups of %s, %d overlapped, resharded into %d shard groups of %s", exp.db, exp.rp,
+		len(exp.sourceGroups), rpi.ShardGroupDuration, overlapped, len(exp.targetGroups), cmd.shardDuration)
+	if len(exp.sourceGroups) == 0 || (overlapped == 0 && rpi.ShardGroupDuration == cmd.shardDuration) {
+		log.Print("no shard group to reshard")
+		return nil
+	}
+	if err = cmd.preflight(svr, []string{exp.rp}); err != nil {
+		return err
+	}
+
+	if opts := audit.FromCommand(cmd.cobraCmd); opts.Planned() {
+		if ok, err := opts.Gate(cmd.reshardPlan(svr, exp)); err != nil || !ok {
+			return err
+		}
+	} else if !cmd.force {
+		fmt.Print("proceed? [N] ")
+		scan := bufio.NewScanner(os.Stdin)
+		scan.Scan()
+		if scan.Err() != nil {
+			return fmt.Errorf("error reading stdin: %v", scan.Err())
+		}
+
+		if strings.ToLower(scan.Text()) != "y" {
+			return nil
+		}
+	}
+
+	if err = cmd.stage(exp, rpi.Duration); err != nil {
+		return err
+	}
+	if err = cmd.swap(svr, exp); err != nil {
+		// the source is left untouched unless the meta has been backed up
+		if backup := filepath.Join(cmd.nodeDir(0), metaBackupFile); exists(backup) {
+			return fmt.Errorf("%v, the meta before reshard is kept in %s", err, backup)
+		}
+		return err
+	}
+	log.Print("reshard done")
+	return os.RemoveAll(cmd.nodeDir(0))
+}
+
+// overlappedGroups returns the number of shard groups overlapping any other, which are sorted by time.
+func overlappedGroups(groups []meta.ShardGroupInfo) int {
+	n := 0
+	for i, g := range groups {
+		if (i > 0 && groups[i-1].Overlaps(g.StartTime, g.EndTime.Add(-1))) ||
+			(i < len(groups)-1 && groups[i+1].Overlaps(g.StartTime, g.EndTime.Add(-1))) {
+			n++
+		}
+	}
+	return n
+}
+
+// stage transfers the shard groups of the retention policy into the staging directory, resuming the ones staged by
+// a previous reshard. The series are added to the series file of the source once swapped, so none is indexed here.
+func (cmd *reshardCommand) stage(exp *exporter, d time.Duration) error {
+	log.SetFlags(log.LstdFlags)
+	log.Printf("staging into %s", cmd.nodeDir(0))
+	stageServer, err := server.NewServer(cmd.nodeDir(0), false)
+	if err != nil {
+		return err
+	}
+	defer stageServer.Close()
+	imp, err := shard.NewImporter(stageServer, exp.db, exp.rp, cmd.shardDuration, d, false, false)
+	if err != nil {
+		return err
+	}
+	defer imp.Close()
+	starts, err := readState(cmd.nodeDir(0), exp.db, exp.rp)
+	if err != nil {
+		return err
+	}
+	if len(starts) > 0 {
+		log.Printf("reshard resumes with %d shard groups staged", len(starts))
+	}
+	exp.Skip(0, starts)
+
+	if err = cmd.transfer(context.Background(), exp, func(idx int, prChan chan *nio.PipeReader) {
+		cmd.transferNode(exp, imp, prChan, idx)
+	}); err != nil {
+		return err
+	}
+	// the shard groups failed are not recorded into the state, which are staged by the next reshard
+	if starts, err = readState(cmd.nodeDir(0), exp.db, exp.rp); err != nil {
+		return err
+	}
+	if staged := countStaged(exp.targetGroups, starts); staged < len(exp.targetGroups) {
+		return fmt.Errorf("%d of %d shard groups staged, rerun to resume the shard groups left", staged, len(exp.targetGroups))
+	}
+	return nil
+}
+
+// countStaged returns the number of target groups whose start times are staged.
+func countStaged(groups []meta.ShardGroupInfo, starts []int64) int {
+	staged := make(map[int64]struct{}, len(starts))
+	for _, start := range starts {
+		staged[start] = struct{}{}
+	}
+	n := 0
+	for _, g := range groups {
+		if _, ok := staged[g.StartTime.UnixNano()]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// swap replaces the shard groups of the retention policy by the staged ones: the staged shards are checked to be
+// opened, the meta is backed up into the staging directory, the shard duration updated, the source shard groups
+// deleted and the staged ones created, then the staged shards are moved in and indexed, and the source shards removed
+// last, so that the source is restored from the meta backed up if any step fails. The staged shard groups without
+// data are dropped.
+func (cmd *reshardCommand) swap(svr *server.Server, exp *exporter) error {
+	stageServer, err := server.NewServer(cmd.nodeDir(0), false)
+	if err != nil {
+		return err
+	}
+	groups, err := stageServer.MetaClient().ShardGroupsByTimeRange(exp.db, exp.rp, time.Unix(0, models.MinNanoTime), time.Unix(0, models.MaxNanoTime))
+	stageServer.Close()
+	if err != nil {
+		return err
+	}
+	stageDir := filepath.Join(cmd.nodeDir(0), "data", exp.db, exp.rp)
+	var staged []meta.ShardGroupInfo
+	for _, g := range groups {
+		path := filepath.Join(stageDir, strconv.FormatUint(g.Shards[0].ID, 10))
+		n, err := checkStaged(path, cmd.readMode)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			log.Printf("staged shard group %d dropped without data", g.ID)
+			continue
+		}
+		staged = append(staged, g)
+	}
+
+	config := svr.TSDBConfig()
+	metaPath := filepath.Join(cmd.sourceDir, "meta", "meta.db")
+	if err = copyFile(metaPath, filepath.Join(cmd.nodeDir(0), metaBackupFile)); err != nil {
+		return fmt.Errorf("backup meta error: %v", err)
+	}
+	client := svr.MetaClient()
+	if err = client.UpdateRetentionPolicy(exp.db, exp.rp, &meta.RetentionPolicyUpdate{ShardGroupDuration: &cmd.shardDuration}, false); err != nil {
+		return fmt.Errorf("update retention policy error: %v", err)
+	}
+	for _, g := range exp.sourceGroups {
+		if err = client.DeleteShardGroup(exp.db, exp.rp, g.ID); err != nil {
+			return fmt.Errorf("delete shard group %d error: %v", g.ID, err)
+		}
+	}
+	rpDir := filepath.Join(config.Dir, exp.db, exp.rp)
+	var shardPaths []string
+	for _, g := range staged {
+		sgi, err := client.CreateShardGroup(exp.db, exp.rp, g.StartTime)
+		if err != nil {
+			return fmt.Errorf("create shard group error: %v", err)
+		}
+		from := filepath.Join(stageDir, strconv.FormatUint(g.Shards[0].ID, 10))
+		to := filepath.Join(rpDir, strconv.FormatUint(sgi.Shards[0].ID, 10))
+		if err = os.Rename(from, to); err != nil {
+			return err
+		}
+		shardPaths = append(shardPaths, to)
+	}
+	log.Printf("%d shard groups swapped in", len(staged))
+	if err = cmd.index(filepath.Join(config.Dir, exp.db), shardPaths); err != nil {
+		return err
+	}
+
+	for _, g := range exp.sourceGroups {
+		for _, sh := range g.Shards {
+			id := strconv.FormatUint(sh.ID, 10)
+			if err = os.RemoveAll(filepath.Join(rpDir, id)); err != nil {
+				return err
+			}
+			if err = os.RemoveAll(filepath.Join(config.WALDir, exp.db, exp.rp, id)); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("%d source shard groups removed", len(exp.sourceGroups))
+	return nil
+}
+
+// checkStaged opens each tsm file of the staged shard in mode, and returns the number of them.
+func checkStaged(path, mode string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(path, "*."+tsm1.TSMFileExtension))
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		f, err := tsmread.Open(file, mode)
+		if err != nil {
+			return 0, fmt.Errorf("staged tsm file %s is unable to be opened: %v", file, err)
+		}
+		f.Close()
+	}
+	return len(files), nil
+}
+
+// index adds the series of the shards moved in to the series file of the database, and builds their tsi1 index
+// unless skipping tsi.
+func (cmd *reshardCommand) index(dbDir string, shardPaths []string) error {
+	builder, err := shard.NewIndexBuilder(dbDir, cmd.readMode, false)
+	if err != nil {
+		return err
+	}
+	var series int
+	for _, path := range shardPaths {
+		var n int
+		if cmd.skipTsi {
+			n, err = builder.BuildInmem(path, "", true)
+		} else {
+			n, err = builder.BuildTSI1(path, "")
+		}
+		if err != nil {
+			builder.Close()
+			return fmt.Errorf("index shard %s error: %v", path, err)
+		}
+		series += n
+	}
+	if err = builder.Close(); err != nil {
+		return err
+	}
+	log.Printf("%d shards indexed with %d series", len(shardPaths), series)
+	return nil
+}
+
+// reshardPlan returns the audit plan of resharding the retention policy of exp, the ids of the shards created are
+// allocated by the meta once swapped.
+func (cmd *reshardCommand) reshardPlan(svr *server.Server, exp *exporter) *audit.Plan {
+	config := svr.TSDBConfig()
+	stageDir := cmd.nodeDir(0)
+	starts, _ := readState(stageDir, exp.db, exp.rp)
+	p := &audit.Plan{}
+	p.Write(filepath.Join(stageDir, "meta", "meta.db"), "database, retention policy and shard groups staged")
+	p.Write(filepath.Join(stageDir, "data", exp.db, exp.rp), fmt.Sprintf("tsm files of %d shard groups staged", len(exp.targetGroups)-countStaged(exp.targetGroups, starts)))
+	p.Write(filepath.Join(stageDir, "data", exp.db, tsdb.SeriesFileDirectory), "series file")
+	p.Write(filepath.Join(stageDir, stateFile), "appended with the shard groups staged")
+	p.Add(audit.Create, filepath.Join(stageDir, metaBackupFile), "copy of the meta before reshard")
+	p.Add(audit.Rewrite, filepath.Join(cmd.sourceDir, "meta", "meta.db"), fmt.Sprintf("shard duration updated, %d shard groups deleted, %d created", len(exp.sourceGroups), len(exp.targetGroups)))
+	rpDir := filepath.Join(config.Dir, exp.db, exp.rp)
+	p.Rename(filepath.Join(stageDir, "data", exp.db, exp.rp), rpDir, fmt.Sprintf("shards of %d shard groups moved in", len(exp.targetGroups)))
+	for _, g := range exp.sourceGroups {
+		for _, sh := range g.Shards {
+			id := strconv.FormatUint(sh.ID, 10)
+			p.Add(audit.Delete, filepath.Join(rpDir, id), "")
+			if walPath := filepath.Join(config.WALDir, exp.db, exp.rp, id); exists(walPath) {
+				p.Add(audit.Delete, walPath, "")
+			}
+		}
+	}
+	note := "series added"
+	if !cmd.skipTsi {
+		note += ", tsi index of the shards moved in built"
+	}
+	p.Write(filepath.Join(config.Dir, exp.db, tsdb.SeriesFileDirectory), note)
+	p.Add(audit.Delete, stageDir, "")
+	return p
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,134 @@
+package transfer
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/sink"
+	"github.com/chengshiwen/influx-tool/internal/source"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestOverlappedGroups(t *testing.T) {
+	day := 24 * time.Hour
+	group := func(start, d time.Duration) meta.ShardGroupInfo {
+		return meta.ShardGroupInfo{StartTime: time.Unix(0, 0).Add(start), EndTime: time.Unix(0, 0).Add(start + d)}
+	}
+	// the shard duration was changed from 1 day to 7 days at day 2
+	groups := []meta.ShardGroupInfo{group(0, day), group(day, day), group(day, 7*day), group(14*day, 7*day)}
+	if n := overlappedGroups(groups); n != 2 {
+		t.Errorf("got %d overlapped groups, expected 2", n)
+	}
+	if n := overlappedGroups(groups[3:]); n != 0 {
+		t.Errorf("got %d overlapped groups, expected 0", n)
+	}
+
+	starts := []int64{groups[0].StartTime.UnixNano(), groups[3].StartTime.UnixNano(), -1}
+	if n := countStaged(groups, starts); n != 2 {
+		t.Errorf("got %d staged groups, expected 2", n)
+	}
+}
+
+// readLines returns the sorted points of the database in the influxdb directory, read as export does.
+func readLines(t *testing.T, dir, db string) []string {
+	src := source.NewFileSource(filepath.Join(dir, "data"), filepath.Join(dir, "wal"), false, tsmread.ModeMmap)
+	shards, err := src.ListShards(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, sh := range shards {
+		err = src.ReadValues(sh, math.MinInt64, math.MaxInt64, func(seriesKey, field []byte, values []tsm1.Value) error {
+			for _, v := range values {
+				lines = append(lines, fmt.Sprintf("%s %s=%v %d", seriesKey, field, v.Value(), v.UnixNano()))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestReshard(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "influxdb")
+	svr, err := server.NewServer(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 71 hours of points in a shard group of 7 days, part of the target groups of 2 days have no data
+	s := sink.NewShardSink(svr, 7*24*time.Hour, 0, true)
+	if err = s.CreateSchema("db", "autogen"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(0, 0).Add(4 * 24 * time.Hour)
+	values := make([]tsm1.Value, 72)
+	for i := range values {
+		values[i] = tsm1.NewFloatValue(start.Add(time.Duration(i)*time.Hour).UnixNano(), float64(i))
+	}
+	for _, host := range []string{"a", "b"} {
+		if err = s.WriteSeries("db", "autogen", []byte("cpu,host="+host), []byte("usage"), values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	svr.Close()
+	if err = os.MkdirAll(filepath.Join(dir, "wal"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	exp := readLines(t, dir, "db")
+	if len(exp) != 144 {
+		t.Fatalf("unexpected points written: %d", len(exp))
+	}
+
+	c := NewReshardCommand()
+	c.SetArgs([]string{"-D", dir, "-d", "db", "--shard-duration", "48h", "-f"})
+	if err = c.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLines(t, dir, "db"); !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected points after reshard: %d, expected %d", len(got), len(exp))
+	}
+	if _, err = os.Stat(dir + "-reshard-0"); !os.IsNotExist(err) {
+		t.Errorf("staging directory not removed: %v", err)
+	}
+
+	svr, err = server.NewServer(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	groups, err := svr.MetaClient().ShardGroupsByTimeRange("db", "autogen", time.Unix(0, 0), start.Add(7*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(meta.ShardGroupInfos(groups))
+	var got []string
+	for _, g := range groups {
+		got = append(got, fmt.Sprintf("%s %s", g.StartTime.UTC().Format(time.RFC3339), g.EndTime.Sub(g.StartTime)))
+	}
+	// the target groups without data are dropped
+	if exp := []string{"1970-01-05T00:00:00Z 48h0m0s", "1970-01-07T00:00:00Z 48h0m0s"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected shard groups: %q", got)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "data", "db", "autogen"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(groups) {
+		t.Errorf("unexpected shard directories: %d, source shards not removed", len(entries))
+	}
+}
@@ -0,0 +1,366 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/audit"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+type rollbackCommand struct {
+	cobraCmd  *cobra.Command
+	targetDir string
+	nodeTotal int
+	nodeIndex intSet
+	since     string
+	force     bool
+}
+
+// rollbackGroup is a shard group imported by the runs rolled back.
+type rollbackGroup struct {
+	db, rp  string
+	id      uint64
+	start   int64
+	end     int64
+	created bool     // deleted with its shards if created by the runs, restored from the snapshot otherwise
+	shards  []string // data and wal directories of the shards relative to the node directory
+}
+
+// nodeRollback is the rollback of a node directory.
+type nodeRollback struct {
+	dir      string
+	kept     []bucketState // buckets of the runs before since
+	groups   []rollbackGroup
+	snapshot string // snapshot of the since run, empty if none taken
+}
+
+func newRollbackCommand() *cobra.Command {
+	cmd := &rollbackCommand{nodeIndex: make(intSet)}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "rollback",
+		Short:         "Roll back the shard groups and meta of the target written by a transfer run and the later runs",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		Annotations:   map[string]string{audit.Annotation: ""},
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE()
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.targetDir, "target-dir", "t", "", "target influxdb directory of the transfer, suffixed with node index, influxd must be stopped (required)")
+	flags.IntVarP(&cmd.nodeTotal, "node-total", "n", 1, "total number of node in target circle")
+	flags.VarP(&cmd.nodeIndex, "node-index", "i", "index of node in target circle delimited by comma, [0, node-total) (default: all)")
+	flags.StringVar(&cmd.since, "since", "", "id of the transfer run logged as transfer run, which is rolled back with all the later runs (required)")
+	flags.BoolVarP(&cmd.force, "force", "f", false, "force rollback without prompting (default: false)")
+	cmd.cobraCmd.MarkFlagRequired("target-dir")
+	cmd.cobraCmd.MarkFlagRequired("since")
+	return cmd.cobraCmd
+}
+
+func (cmd *rollbackCommand) validate() error {
+	if !snapshot.ValidID(cmd.since) {
+		return errors.New("since is invalid, require the id of transfer run like 20240102T030405.006Z")
+	}
+	if cmd.nodeTotal <= 0 {
+		return errors.New("node-total is invalid")
+	}
+	for idx := range cmd.nodeIndex {
+		if idx < 0 || idx >= cmd.nodeTotal {
+			return errors.New("node-index is invalid")
+		}
+	}
+	if len(cmd.nodeIndex) == 0 {
+		for idx := 0; idx < cmd.nodeTotal; idx++ {
+			cmd.nodeIndex[idx] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (cmd *rollbackCommand) runE() error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	log.SetFlags(0)
+	idxs := make([]int, 0, len(cmd.nodeIndex))
+	for idx := range cmd.nodeIndex {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+
+	var nodes []*nodeRollback
+	for _, idx := range idxs {
+		nr, err := cmd.planNode(nodeDir(cmd.targetDir, idx))
+		if err != nil {
+			return fmt.Errorf("node index %d: %v", idx, err)
+		}
+		if nr == nil {
+			log.Printf("node index %d: no shard group imported since run %s", idx, cmd.since)
+			continue
+		}
+		nodes = append(nodes, nr)
+		cmd.report(idx, nr)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	if opts := audit.FromCommand(cmd.cobraCmd); opts.Planned() {
+		if ok, err := opts.Gate(cmd.plan(nodes)); err != nil || !ok {
+			return err
+		}
+	} else if !cmd.force {
+		fmt.Print("proceed? [N] ")
+		scan := bufio.NewScanner(os.Stdin)
+		scan.Scan()
+		if scan.Err() != nil {
+			return fmt.Errorf("error reading stdin: %v", scan.Err())
+		}
+
+		if strings.ToLower(scan.Text()) != "y" {
+			return nil
+		}
+	}
+
+	for _, nr := range nodes {
+		if err := cmd.rollback(nr); err != nil {
+			return fmt.Errorf("rollback %s error: %v", nr.dir, err)
+		}
+	}
+	log.Print("rollback done")
+	return nil
+}
+
+// planNode returns the rollback of the node directory dir, or nil if no bucket imported since the run.
+func (cmd *rollbackCommand) planNode(dir string) (*nodeRollback, error) {
+	buckets, err := readBuckets(dir)
+	if err != nil {
+		return nil, err
+	}
+	nr := &nodeRollback{dir: dir}
+	var rolled []bucketState
+	// the buckets of the runs without id, such as the ones pushed, are kept
+	for _, bs := range buckets {
+		if bs.Run != "" && bs.Run >= cmd.since {
+			rolled = append(rolled, bs)
+		} else {
+			nr.kept = append(nr.kept, bs)
+		}
+	}
+	if len(rolled) == 0 {
+		return nil, nil
+	}
+	if path := snapshot.Path(dir, cmd.since); snapshot.Exists(path) {
+		nr.snapshot = path
+	}
+
+	svr, err := server.NewServer(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	defer svr.Close()
+	client := svr.MetaClient()
+	// the buckets may not start on a shard group boundary, such as the ones written into the groups of a longer
+	// shard duration adopted, so the groups overlapping them are rolled back, created if any bucket created them
+	seen := make(map[uint64]int)
+	for _, bs := range rolled {
+		groups, err := client.ShardGroupsByTimeRange(bs.Database, bs.RetentionPolicy, time.Unix(0, bs.Start), time.Unix(0, bs.End-1))
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			if i, ok := seen[g.ID]; ok {
+				nr.groups[i].created = nr.groups[i].created || bs.Created
+				continue
+			}
+			seen[g.ID] = len(nr.groups)
+			rg := rollbackGroup{db: bs.Database, rp: bs.RetentionPolicy, id: g.ID, start: g.StartTime.UnixNano(), end: g.EndTime.UnixNano(), created: bs.Created}
+			for _, sh := range g.Shards {
+				id := strconv.FormatUint(sh.ID, 10)
+				rg.shards = append(rg.shards, filepath.Join("data", bs.Database, bs.RetentionPolicy, id), filepath.Join("wal", bs.Database, bs.RetentionPolicy, id))
+			}
+			nr.groups = append(nr.groups, rg)
+		}
+	}
+	// the groups holding the buckets kept are not deleted, but restored from the snapshot or kept
+	for i := range nr.groups {
+		rg := &nr.groups[i]
+		for _, bs := range nr.kept {
+			if bs.Database == rg.db && bs.RetentionPolicy == rg.rp && bs.Start < rg.end && bs.End > rg.start {
+				rg.created = false
+				break
+			}
+		}
+	}
+	return nr, nil
+}
+
+// restorable returns whether the shard path of an existing shard group is in the snapshot of the since run.
+func (nr *nodeRollback) restorable(path string) bool {
+	if nr.snapshot == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(nr.snapshot, path))
+	return err == nil
+}
+
+func (cmd *rollbackCommand) report(idx int, nr *nodeRollback) {
+	var deleted, restored, kept int
+	for _, rg := range nr.groups {
+		switch {
+		case rg.created:
+			deleted++
+		case nr.restorable(rg.shards[0]):
+			restored++
+		default:
+			kept++
+			log.Printf("warning: node index %d: shard group %d of %s.%s existed before run %s and is not in its snapshot, the data written into it is kept",
+				idx, rg.id, rg.db, rg.rp, cmd.since)
+		}
+	}
+	meta := "meta shard groups deleted"
+	if nr.snapshot != "" {
+		meta = "meta restored from " + nr.snapshot
+	}
+	log.Printf("node index %d: %d shard groups deleted, %d restored, %d kept, %s", idx, deleted, restored, kept, meta)
+}
+
+// plan returns the audit plan of rolling back the nodes.
+func (cmd *rollbackCommand) plan(nodes []*nodeRollback) *audit.Plan {
+	p := &audit.Plan{}
+	for _, nr := range nodes {
+		for _, rg := range nr.groups {
+			for _, path := range rg.shards {
+				if !exists(filepath.Join(nr.dir, path)) && !nr.restorable(path) {
+					continue
+				}
+				if rg.created {
+					p.Add(audit.Delete, filepath.Join(nr.dir, path), "")
+				} else if nr.restorable(path) {
+					p.Add(audit.Rewrite, filepath.Join(nr.dir, path), "restored from "+nr.snapshot)
+				}
+			}
+		}
+		if nr.snapshot != "" {
+			p.Add(audit.Rewrite, snapshot.MetaPath(nr.dir), "restored from "+nr.snapshot)
+		} else {
+			p.Add(audit.Rewrite, snapshot.MetaPath(nr.dir), "shard groups deleted")
+		}
+		if len(nr.kept) > 0 {
+			p.Add(audit.Rewrite, filepath.Join(nr.dir, stateFile), "buckets of the runs rolled back removed")
+		} else {
+			p.Add(audit.Delete, filepath.Join(nr.dir, stateFile), "")
+		}
+	}
+	return p
+}
+
+// rollback deletes the shard groups created by the runs rolled back, restores the shards existing before from the
+// snapshot of the since run, and restores the meta from the snapshot or deletes the shard groups from the meta.
+func (cmd *rollbackCommand) rollback(nr *nodeRollback) error {
+	svr, err := server.NewServer(nr.dir, false)
+	if err != nil {
+		return err
+	}
+	defer svr.Close()
+	client := svr.MetaClient()
+	for _, rg := range nr.groups {
+		for _, path := range rg.shards {
+			if rg.created {
+				if err = os.RemoveAll(filepath.Join(nr.dir, path)); err != nil {
+					return err
+				}
+			} else if nr.restorable(path) {
+				if err = snapshot.Restore(nr.dir, cmd.since, path); err != nil {
+					return err
+				}
+			}
+		}
+		if rg.created && nr.snapshot == "" {
+			if err = client.DeleteShardGroup(rg.db, rg.rp, rg.id); err != nil {
+				return err
+			}
+		}
+	}
+	if nr.snapshot != "" {
+		svr.Close()
+		if err = snapshot.Restore(nr.dir, cmd.since, filepath.Join("meta", "meta.db")); err != nil {
+			return err
+		}
+	}
+	if err = writeBuckets(nr.dir, nr.kept); err != nil {
+		return err
+	}
+	log.Printf("%s rolled back", nr.dir)
+	return nil
+}
+
+// readBuckets returns all the buckets of the state file in node directory.
+func readBuckets(dir string) ([]bucketState, error) {
+	f, err := os.Open(filepath.Join(dir, stateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var buckets []bucketState
+	dec := json.NewDecoder(f)
+	for {
+		var bs bucketState
+		if err = dec.Decode(&bs); err == io.EOF {
+			return buckets, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read state error: %v", err)
+		}
+		buckets = append(buckets, bs)
+	}
+}
+
+// writeBuckets replaces the state file in node directory with the buckets, which is removed if none.
+func writeBuckets(dir string, buckets []bucketState) error {
+	path := filepath.Join(dir, stateFile)
+	if len(buckets) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for i := range buckets {
+		if err = enc.Encode(&buckets[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
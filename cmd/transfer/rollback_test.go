@@ -0,0 +1,92 @@
+package transfer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+func TestWriteBuckets(t *testing.T) {
+	dir := t.TempDir()
+	buckets := []bucketState{
+		{Database: "db", RetentionPolicy: "rp", Start: 0, End: 10},
+		{Database: "db", RetentionPolicy: "rp", Start: 10, End: 20, Run: "20240102T030405.006Z", Created: true},
+	}
+	for _, bs := range buckets {
+		if err := appendState(dir, &bs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := readBuckets(dir)
+	if err != nil || !reflect.DeepEqual(got, buckets) {
+		t.Fatalf("got %v, %v", got, err)
+	}
+
+	// the buckets of the runs since the one rolled back are removed
+	if err = writeBuckets(dir, buckets[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if starts, err := readState(dir, "db", "rp"); err != nil || !reflect.DeepEqual(starts, []int64{0}) {
+		t.Fatalf("got %v, %v", starts, err)
+	}
+	if err = writeBuckets(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, stateFile)); !os.IsNotExist(err) {
+		t.Errorf("state file of no bucket is not removed: %v", err)
+	}
+}
+
+func TestPlanNode(t *testing.T) {
+	dir := t.TempDir()
+	svr, err := server.NewServer(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := svr.MetaClient()
+	if _, err = client.CreateDatabaseWithRetentionPolicy("db", &meta.RetentionPolicySpec{Name: "rp", ShardGroupDuration: 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	sgi, err := client.CreateShardGroup("db", "rp", time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.Close()
+
+	// the buckets of 12h are written into the shard group of 24h adopted, the first one creating it, neither of them
+	// starting on the boundary of the group
+	run, half := "20240102T030405.006Z", int64(12*time.Hour)
+	buckets := []bucketState{
+		{Database: "db", RetentionPolicy: "rp", Start: half / 2, End: half + half/2, Run: run, Created: true},
+		{Database: "db", RetentionPolicy: "rp", Start: half + half/2, End: 2 * half, Run: run},
+	}
+	for _, bs := range buckets {
+		if err = appendState(dir, &bs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := &rollbackCommand{since: run}
+	nr, err := cmd.planNode(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nr == nil || len(nr.groups) != 1 || nr.groups[0].id != sgi.ID || !nr.groups[0].created {
+		t.Fatalf("unexpected rollback: %+v", nr)
+	}
+
+	// the shard group holding a bucket of an earlier run is not deleted
+	if err = writeBuckets(dir, append([]bucketState{{Database: "db", RetentionPolicy: "rp", Start: 0, End: half / 2}}, buckets...)); err != nil {
+		t.Fatal(err)
+	}
+	if nr, err = cmd.planNode(dir); err != nil {
+		t.Fatal(err)
+	}
+	if nr == nil || len(nr.groups) != 1 || nr.groups[0].id != sgi.ID || nr.groups[0].created || len(nr.kept) != 1 {
+		t.Fatalf("unexpected rollback: %+v", nr)
+	}
+}
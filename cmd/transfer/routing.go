@@ -0,0 +1,103 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/pkg/hash"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxql"
+)
+
+const routingSamples = 20
+
+// errSampled stops reading the series once the measurements are sampled.
+var errSampled = errors.New("sampled")
+
+// explainRouting writes the routing function and the node index of sample measurements of the source, so that
+// the hash key and shard key can be checked against the influx proxy before transferring.
+func (cmd *command) explainRouting(w io.Writer, exp *exporter) error {
+	names, err := exp.sampleMeasurements(routingSamples)
+	if err != nil {
+		return err
+	}
+	st := cmd.shard()
+	for _, c := range cmd.circles {
+		if len(cmd.circles) > 1 {
+			fmt.Fprintf(w, "circle %d ", c.id)
+		}
+		fmt.Fprintf(w, "routing: node index = consistent hash of shard key %q over %d nodes keyed by %q\n", cmd.shardKey, c.nodeTotal, c.hashKey)
+		if warning := hash.CircleWarning(c.nodeTotal, c.hashKey); warning != "" {
+			fmt.Fprintf(w, "warning: %s\n", warning)
+		}
+	}
+	if len(cmd.spreadMms) > 0 {
+		fmt.Fprintf(w, "spread: series of %s keyed by shard key + \",<%s>\"\n", strings.Join(cmd.spreadMms, ", "), cmd.spreadTag)
+	}
+	fmt.Fprintf(w, "sample keys of %d measurements in %s.%s:\n", len(names), exp.db, exp.rp)
+	for _, name := range names {
+		key := st.GetKey(exp.db, exp.rp, name)
+		if st.Spread(name) {
+			fmt.Fprintf(w, "  %q -> spread across nodes by tag %q\n", hash.SpreadKey(key, "<"+cmd.spreadTag+">"), cmd.spreadTag)
+			continue
+		}
+		fmt.Fprintf(w, "  %q -> %s\n", key, cmd.routedNodes(key))
+	}
+	return nil
+}
+
+// routedNodes returns the nodes the shard key is routed to, one of each circle.
+func (cmd *command) routedNodes(key string) string {
+	targets := cmd.circles.targets(nil, key)
+	names := make([]string, len(targets))
+	for i, t := range targets {
+		_, idx := cmd.circles.node(t)
+		names[i] = fmt.Sprintf("node %d", idx)
+		if len(cmd.circles) > 1 {
+			names[i] = fmt.Sprintf("circle %d %s", cmd.circles[i].id, names[i])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// warnCircle logs the warning of the virtual points abandoned by hash collisions on the circles, if any, since the
+// circles of influx proxy are skewed the same way.
+func (cmd *command) warnCircle() {
+	for _, c := range cmd.circles {
+		if warning := hash.CircleWarning(c.nodeTotal, c.hashKey); warning != "" && len(cmd.circles) > 1 {
+			log.Printf("warning: circle %d: %s", c.id, warning)
+		} else if warning != "" {
+			log.Printf("warning: %s", warning)
+		}
+	}
+}
+
+// sampleMeasurements returns at most n distinct measurements of the source shards, read from the series of the
+// shards in order.
+func (e *exporter) sampleMeasurements(n int) ([][]byte, error) {
+	var names [][]byte
+	seen := make(map[string]struct{})
+	for _, sh := range e.shards {
+		err := e.src.ReadSeries(sh, func(seriesKey, _ []byte, _ influxql.DataType) error {
+			name := models.ParseName(seriesKey)
+			if _, ok := seen[string(name)]; !ok {
+				seen[string(name)] = struct{}{}
+				names = append(names, append([]byte(nil), name...))
+			}
+			if len(names) >= n {
+				return errSampled
+			}
+			return nil
+		})
+		if err == errSampled {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
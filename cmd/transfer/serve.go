@@ -0,0 +1,251 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/chengshiwen/influx-tool/internal/agent"
+	"github.com/chengshiwen/influx-tool/internal/binary"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/spf13/cobra"
+)
+
+// stateFile records the buckets imported in node directory, to resume an interrupted transfer or push.
+const stateFile = "transfer.state"
+
+type serveCommand struct {
+	cobraCmd  *cobra.Command
+	listen    string
+	targetDir string
+	skipTsi   bool
+	adoptRP   bool
+	strict    bool
+	config    agent.ServerConfig
+
+	mu   sync.Mutex
+	svrs map[int]*server.Server
+	imps map[string]*nodeImporter
+}
+
+type nodeImporter struct {
+	*shard.Importer
+	rp string
+}
+
+// bucketState is a line of state file.
+type bucketState struct {
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retention_policy"`
+	Start           int64  `json:"start"`
+	End             int64  `json:"end"`
+	Run             string `json:"run,omitempty"`     // id of the transfer run importing the bucket
+	Created         bool   `json:"created,omitempty"` // whether the shard group is created by the run
+}
+
+func newServeCommand() *cobra.Command {
+	cmd := &serveCommand{
+		svrs: make(map[int]*server.Server),
+		imps: make(map[string]*nodeImporter),
+	}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "serve",
+		Short:         "Serve as a transfer agent importing the data pushed from source side",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE()
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.listen, "listen", "l", ":8090", "address to listen on")
+	flags.StringVarP(&cmd.targetDir, "target-dir", "t", "", "target influxdb directory containing meta, data and wal, suffixed with node index (required)")
+	flags.BoolVar(&cmd.skipTsi, "skip-tsi", false, "skip building TSI index on disk (default: false)")
+	flags.BoolVar(&cmd.adoptRP, "adopt-existing-rp", false, "adopt the retention policy existing in the targets with a different duration, replication or shard duration, whose shard duration must be a multiple of the one pushed, with a warning of the mismatch instead of failing (default: false)")
+	flags.BoolVar(&cmd.strict, "strict-meta", false, "fail the pushes once the retention policy exists in a target with different parameters, which is the behavior without adopt-existing-rp (default: false)")
+	flags.StringVar(&cmd.config.Token, "token", "", "token to authenticate push requests (default: no authentication)")
+	flags.StringVar(&cmd.config.TLSCert, "tls-cert", "", "tls certificate file to serve with (default: no tls)")
+	flags.StringVar(&cmd.config.TLSKey, "tls-key", "", "tls private key file to serve with (require tls-cert)")
+	cmd.cobraCmd.MarkFlagRequired("target-dir")
+	return cmd.cobraCmd
+}
+
+func (cmd *serveCommand) validate() error {
+	if (cmd.config.TLSCert == "") != (cmd.config.TLSKey == "") {
+		return errors.New("tls-cert and tls-key must be specified together")
+	}
+	if cmd.adoptRP && cmd.strict {
+		return errors.New("adopt-existing-rp cannot be specified with strict-meta")
+	}
+	return nil
+}
+
+func (cmd *serveCommand) runE() error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	srv, err := agent.NewServer(cmd, cmd.config)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", cmd.listen)
+	if err != nil {
+		return err
+	}
+	defer cmd.close()
+
+	log.SetFlags(log.LstdFlags)
+	if cmd.config.Token == "" {
+		log.Print("warning: no token specified, push requests are not authenticated")
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Print("agent stopping, waiting for pushes in progress")
+		srv.Stop()
+	}()
+	log.Printf("agent listening on %s", ln.Addr())
+	return srv.Serve(ln)
+}
+
+func (cmd *serveCommand) close() {
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	for _, imp := range cmd.imps {
+		imp.Close()
+	}
+	for _, svr := range cmd.svrs {
+		svr.Close()
+	}
+}
+
+func (cmd *serveCommand) importer(pi *agent.PushInfo) (*shard.Importer, error) {
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	key := fmt.Sprintf("%d/%s", pi.Node, pi.Database)
+	if imp, ok := cmd.imps[key]; ok {
+		if imp.rp != pi.RetentionPolicy {
+			return nil, fmt.Errorf("node index %d is importing %s.%s, restart agent to import another retention policy", pi.Node, pi.Database, imp.rp)
+		}
+		return imp.Importer, nil
+	}
+	svr, ok := cmd.svrs[pi.Node]
+	if !ok {
+		// the meta is validated before the target is opened, which creates it
+		err := shard.ValidateRetentionPolicy(nodeDir(cmd.targetDir, pi.Node), pi.Database, pi.RetentionPolicy, pi.ShardDuration, pi.Duration, cmd.adoptRP)
+		if err != nil {
+			return nil, err
+		}
+		svr, err = server.NewServer(nodeDir(cmd.targetDir, pi.Node), !cmd.skipTsi)
+		if err != nil {
+			return nil, err
+		}
+		cmd.svrs[pi.Node] = svr
+	}
+	imp, err := shard.NewImporter(svr, pi.Database, pi.RetentionPolicy, pi.ShardDuration, pi.Duration, !cmd.skipTsi, cmd.adoptRP)
+	if err != nil {
+		imp.Close()
+		return nil, err
+	}
+	cmd.imps[key] = &nodeImporter{Importer: imp, rp: pi.RetentionPolicy}
+	return imp, nil
+}
+
+// Status implements agent.Handler.
+func (cmd *serveCommand) Status(req *agent.StatusRequest) ([]int64, error) {
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	return readState(nodeDir(cmd.targetDir, req.Node), req.Database, req.RetentionPolicy)
+}
+
+// Import implements agent.Handler.
+func (cmd *serveCommand) Import(pi *agent.PushInfo, r io.Reader) error {
+	imp, err := cmd.importer(pi)
+	if err != nil {
+		log.Printf("create importer error: %s, idx: %d", err, pi.Node)
+		return err
+	}
+	iw := shard.NewImportWorker(imp)
+
+	reader := binary.NewReader(r)
+	h, err := reader.ReadHeader()
+	if err != nil {
+		log.Printf("read header error: %s, idx: %d", err, pi.Node)
+		return err
+	}
+	iw.SetHeader(h)
+
+	var bh *binary.BucketHeader
+	for bh, err = reader.NextBucket(); (bh != nil) && (err == nil); bh, err = reader.NextBucket() {
+		if _, err = importBucket(iw, h, reader, bh); err != nil {
+			log.Printf("import shard error: %s, idx: %d", err, pi.Node)
+			return err
+		}
+		if err = cmd.saveState(pi, bh); err != nil {
+			log.Printf("save state error: %s, idx: %d", err, pi.Node)
+			return err
+		}
+		log.Printf("node index %d shard imported: %s.%s, start: %d, end: %d", pi.Node, pi.Database, pi.RetentionPolicy, bh.Start, bh.End)
+	}
+	if err != nil {
+		log.Printf("next bucket error: %s, idx: %d", err, pi.Node)
+	}
+	return err
+}
+
+func (cmd *serveCommand) saveState(pi *agent.PushInfo, bh *binary.BucketHeader) error {
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	return appendState(nodeDir(cmd.targetDir, pi.Node), &bucketState{Database: pi.Database, RetentionPolicy: pi.RetentionPolicy, Start: bh.Start, End: bh.End})
+}
+
+// readState returns the start times of the buckets of the database and retention policy imported in node directory.
+func readState(dir, db, rp string) ([]int64, error) {
+	f, err := os.Open(filepath.Join(dir, stateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var starts []int64
+	dec := json.NewDecoder(f)
+	for {
+		var bs bucketState
+		if err = dec.Decode(&bs); err == io.EOF {
+			return starts, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read state error: %v", err)
+		}
+		if bs.Database == db && bs.RetentionPolicy == rp {
+			starts = append(starts, bs.Start)
+		}
+	}
+}
+
+// appendState records a bucket imported in node directory, the caller must serialize the calls of a directory.
+func appendState(dir string, bs *bucketState) error {
+	f, err := os.OpenFile(filepath.Join(dir, stateFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = json.NewEncoder(f).Encode(bs); err != nil {
+		return err
+	}
+	return f.Sync()
+}
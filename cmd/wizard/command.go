@@ -0,0 +1,371 @@
+package wizard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	exporter "github.com/chengshiwen/influx-tool/cmd/export"
+	"github.com/chengshiwen/influx-tool/cmd/transfer"
+	"github.com/chengshiwen/influx-tool/internal/preflight"
+	"github.com/chengshiwen/influx-tool/internal/spec"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/spf13/cobra"
+)
+
+type command struct {
+	cobraCmd  *cobra.Command
+	sourceDir string
+	in        *bufio.Scanner
+	out       io.Writer
+}
+
+// Target types asked by the wizard.
+const (
+	targetTransfer = "transfer"
+	targetExport   = "export"
+)
+
+// policy is a retention policy found in the source directory.
+type policy struct {
+	db, rp        string
+	isDefault     bool
+	duration      time.Duration
+	shardDuration time.Duration
+	groups        int
+	start, end    time.Time // time range of the shard groups
+	shards        int
+	tsmFiles      int
+}
+
+func (p *policy) String() string {
+	s := p.db + "." + p.rp
+	if p.isDefault {
+		s += " (default)"
+	}
+	s += fmt.Sprintf(": duration %s, shard duration %s, %d shard groups", p.duration, p.shardDuration, p.groups)
+	if p.groups > 0 {
+		s += fmt.Sprintf(" from %s to %s", p.start.Format(time.RFC3339), p.end.Format(time.RFC3339))
+	}
+	return s + fmt.Sprintf(", %d shards with %d tsm files on disk", p.shards, p.tsmFiles)
+}
+
+// answers are the answers to the questions of the wizard.
+type answers struct {
+	policy    *policy
+	target    string
+	nodeTotal int
+	targetDir string // target directory of transfer
+	out       string // output file of export
+	start     string
+	end       string
+	specPath  string
+}
+
+func NewCommand() *cobra.Command {
+	cmd := &command{in: bufio.NewScanner(os.Stdin), out: os.Stdout}
+	cmd.cobraCmd = &cobra.Command{
+		Args:          cobra.NoArgs,
+		Use:           "wizard",
+		Short:         "Inspect a source influxdb directory, ask a few questions and generate the command line of transfer or export",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.runE()
+		},
+	}
+	flags := cmd.cobraCmd.Flags()
+	flags.SortFlags = false
+	flags.StringVarP(&cmd.sourceDir, "source-dir", "s", "", "source influxdb directory containing meta, data and wal (required)")
+	cmd.cobraCmd.MarkFlagRequired("source-dir")
+	return cmd.cobraCmd
+}
+
+func (cmd *command) runE() error {
+	policies, err := inspect(cmd.sourceDir)
+	if err != nil {
+		return err
+	}
+	if len(policies) == 0 {
+		return fmt.Errorf("no retention policy found in %s", cmd.sourceDir)
+	}
+	fmt.Fprintf(cmd.out, "source %s:\n", cmd.sourceDir)
+	for _, p := range policies {
+		fmt.Fprintf(cmd.out, "  %s\n", p)
+	}
+
+	a, err := cmd.ask(policies)
+	if err != nil {
+		return err
+	}
+	args := a.args(cmd.sourceDir)
+
+	fmt.Fprintln(cmd.out, "\nplan:")
+	if err = cmd.plan(a, args); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.out, "\ncommand line:\n  %s\n", commandLine(args))
+	if a.specPath == "" {
+		return nil
+	}
+	c := newCommand(a.target)
+	if err = c.ParseFlags(args[1:]); err != nil {
+		return err
+	}
+	if err = spec.FromCommand(c).Save(a.specPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.out, "job spec saved to %s, run it with: influx-tool run %s\n", a.specPath, a.specPath)
+	return nil
+}
+
+// ask asks the questions until each is answered validly, the default answer is taken by an empty line.
+func (cmd *command) ask(policies []*policy) (*answers, error) {
+	a := &answers{}
+	def := policies[0]
+	for _, p := range policies {
+		if p.isDefault {
+			def = p
+			break
+		}
+	}
+	fmt.Fprintln(cmd.out)
+	db, err := cmd.question("database", def.db, func(s string) error {
+		for _, p := range policies {
+			if p.db == s {
+				return nil
+			}
+		}
+		return errors.New("database not found")
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the default retention policy of the database is suggested, or its first one if unknown
+	def = nil
+	for _, p := range policies {
+		if p.db == db && (def == nil || p.isDefault) {
+			def = p
+		}
+	}
+	rp, err := cmd.question("retention policy", def.rp, func(s string) error {
+		for _, p := range policies {
+			if p.db == db && p.rp == s {
+				a.policy = p
+				return nil
+			}
+		}
+		return errors.New("retention policy not found")
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.target, err = cmd.question("target type, transfer to the influxdb directories of an influx-proxy circle or export to a line protocol file", targetTransfer, func(s string) error {
+		if s != targetTransfer && s != targetExport {
+			return errors.New("require transfer or export")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Clean(cmd.sourceDir)
+	if a.target == targetTransfer {
+		n, err := cmd.question("circle size, the number of influxdb nodes in the circle", "1", func(s string) error {
+			if n, err := strconv.Atoi(s); err != nil || n <= 0 {
+				return errors.New("require a positive integer")
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		a.nodeTotal, _ = strconv.Atoi(n)
+		if a.targetDir, err = cmd.question("target directory, suffixed with node index", base+"-target", nil); err != nil {
+			return nil, err
+		}
+	} else {
+		if a.out, err = cmd.question("output file", filepath.Join(filepath.Dir(base), db+"-"+rp+".lp"), nil); err != nil {
+			return nil, err
+		}
+	}
+	rfc3339 := func(s string) error {
+		if s == "" {
+			return nil
+		}
+		_, err := time.Parse(time.RFC3339, s)
+		return err
+	}
+	if a.start, err = cmd.question("start time, RFC3339 format or empty for the earliest", "", rfc3339); err != nil {
+		return nil, err
+	}
+	if a.end, err = cmd.question("end time, RFC3339 format or empty for the latest", "", rfc3339); err != nil {
+		return nil, err
+	}
+	if a.specPath, err = cmd.question("job spec file to save, empty to skip", "", nil); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// question prints the question with its default answer, and reads the answer until valid by the validate function if
+// given. An error is returned once the input ends.
+func (cmd *command) question(q, def string, validate func(s string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(cmd.out, "%s [%s]: ", q, def)
+		} else {
+			fmt.Fprintf(cmd.out, "%s: ", q)
+		}
+		if !cmd.in.Scan() {
+			if err := cmd.in.Err(); err != nil {
+				return "", fmt.Errorf("error reading stdin: %v", err)
+			}
+			return "", errors.New("wizard aborted: no more input")
+		}
+		s := strings.TrimSpace(cmd.in.Text())
+		if s == "" {
+			s = def
+		}
+		if validate == nil {
+			return s, nil
+		}
+		if err := validate(s); err != nil {
+			fmt.Fprintf(cmd.out, "invalid answer %q: %v\n", s, err)
+			continue
+		}
+		return s, nil
+	}
+}
+
+// args returns the arguments of the command generated by the answers, starting with the command name.
+func (a *answers) args(sourceDir string) []string {
+	p := a.policy
+	var args []string
+	if a.target == targetTransfer {
+		args = []string{targetTransfer, "--source-dir", sourceDir, "--target-dir", a.targetDir, "--database", p.db, "--retention-policy", p.rp,
+			"--duration", p.duration.String(), "--shard-duration", p.shardDuration.String(), "--node-total", strconv.Itoa(a.nodeTotal)}
+	} else {
+		args = []string{targetExport, "--datadir", filepath.Join(sourceDir, "data"), "--waldir", filepath.Join(sourceDir, "wal"), "--database", p.db,
+			"--retention-policy", p.rp, "--out", a.out}
+	}
+	if a.start != "" {
+		args = append(args, "--start", a.start)
+	}
+	if a.end != "" {
+		args = append(args, "--end", a.end)
+	}
+	return args
+}
+
+// plan prints the plan of the generated command: the mapping of shard groups to nodes by plan transfer, or the points
+// to export by the dry run of export.
+func (cmd *command) plan(a *answers, args []string) error {
+	var c *cobra.Command
+	if a.target == targetTransfer {
+		c = transfer.NewPlanCommand()
+		// plan transfer takes the flags of transfer except duration
+		for i := 1; i < len(args); i += 2 {
+			if args[i] == "--duration" {
+				args = append(args[:i:i], args[i+2:]...)
+				break
+			}
+		}
+	} else {
+		c = exporter.NewCommand()
+		args = append(args, "--dry-run")
+	}
+	c.SetArgs(args[1:])
+	c.SetOut(cmd.out)
+	return c.Execute()
+}
+
+func newCommand(target string) *cobra.Command {
+	if target == targetTransfer {
+		return transfer.NewCommand()
+	}
+	return exporter.NewCommand()
+}
+
+// commandLine returns the shell command line of the args, the arguments with special characters are single quoted.
+func commandLine(args []string) string {
+	quoted := make([]string, 0, len(args)+1)
+	quoted = append(quoted, "influx-tool")
+	for _, arg := range args {
+		quoted = append(quoted, quote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func quote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-+=.,:/@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// inspect returns the retention policies in the meta of the source directory except _internal, sorted by database and
+// retention policy, with the shard groups in the meta and the shards on disk.
+func inspect(sourceDir string) ([]*policy, error) {
+	b, err := os.ReadFile(filepath.Join(sourceDir, "meta", "meta.db"))
+	if err != nil {
+		return nil, err
+	}
+	data := &meta.Data{}
+	if err = data.UnmarshalBinary(b); err != nil {
+		return nil, fmt.Errorf("read meta file error: %v", err)
+	}
+	shards, err := preflight.Inspect(filepath.Join(sourceDir, "data"), "", "")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var policies []*policy
+	for _, dbi := range data.Databases {
+		if dbi.Name == "_internal" {
+			continue
+		}
+		for _, rpi := range dbi.RetentionPolicies {
+			p := &policy{
+				db:            dbi.Name,
+				rp:            rpi.Name,
+				isDefault:     rpi.Name == dbi.DefaultRetentionPolicy,
+				duration:      rpi.Duration,
+				shardDuration: rpi.ShardGroupDuration,
+			}
+			for _, sgi := range rpi.ShardGroups {
+				if sgi.Deleted() {
+					continue
+				}
+				if p.groups == 0 || sgi.StartTime.Before(p.start) {
+					p.start = sgi.StartTime
+				}
+				if p.groups == 0 || sgi.EndTime.After(p.end) {
+					p.end = sgi.EndTime
+				}
+				p.groups++
+			}
+			for _, sh := range shards {
+				if sh.Database == p.db && sh.RetentionPolicy == p.rp {
+					p.shards++
+					p.tsmFiles += sh.TSMFiles
+				}
+			}
+			policies = append(policies, p)
+		}
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].db != policies[j].db {
+			return policies[i].db < policies[j].db
+		}
+		return policies[i].rp < policies[j].rp
+	})
+	return policies, nil
+}
@@ -0,0 +1,103 @@
+package wizard
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+func TestWizard(t *testing.T) {
+	dir := t.TempDir()
+	data := &meta.Data{}
+	if err := data.CreateDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+	for _, rp := range []string{"autogen", "two_weeks"} {
+		rpi := &meta.RetentionPolicyInfo{Name: rp, ReplicaN: 1, ShardGroupDuration: 24 * time.Hour}
+		if rp == "two_weeks" {
+			rpi.Duration, rpi.ShardGroupDuration = 14*24*time.Hour, time.Hour
+		}
+		if err := data.CreateRetentionPolicy("db", rpi, rp == "two_weeks"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := data.CreateShardGroup("db", "two_weeks", time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(dir, "meta"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "meta", "meta.db"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := inspect(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range policies {
+		got = append(got, p.String())
+	}
+	exp := []string{
+		"db.autogen: duration 0s, shard duration 24h0m0s, 0 shard groups, 0 shards with 0 tsm files on disk",
+		"db.two_weeks (default): duration 336h0m0s, shard duration 1h0m0s, 1 shard groups from 2024-01-02T03:00:00Z to 2024-01-02T04:00:00Z, 0 shards with 0 tsm files on disk",
+	}
+	if !cmp.Equal(got, exp) {
+		t.Fatalf("unexpected policies: got=%v, exp=%v", got, exp)
+	}
+
+	for _, tt := range []struct {
+		input string
+		exp   string
+	}{
+		{
+			input: "\n\n\n0\n4\n\n\n2024-01-03\n2024-01-03T00:00:00Z\n\n",
+			exp:   "influx-tool transfer --source-dir " + dir + " --target-dir " + dir + "-target --database db --retention-policy two_weeks --duration 336h0m0s --shard-duration 1h0m0s --node-total 4 --end 2024-01-03T00:00:00Z",
+		},
+		{
+			input: "db\nmonth\nautogen\nexport\nout put.lp\n2024-01-01T00:00:00+08:00\n\n\n",
+			exp:   "influx-tool export --datadir " + filepath.Join(dir, "data") + " --waldir " + filepath.Join(dir, "wal") + " --database db --retention-policy autogen --out 'out put.lp' --start 2024-01-01T00:00:00+08:00",
+		},
+	} {
+		cmd := &command{sourceDir: dir, in: bufio.NewScanner(strings.NewReader(tt.input)), out: io.Discard}
+		a, err := cmd.ask(policies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := commandLine(a.args(dir)); got != tt.exp {
+			t.Errorf("unexpected command line:\ngot=%s\nexp=%s", got, tt.exp)
+		}
+	}
+
+	cmd := &command{sourceDir: dir, in: bufio.NewScanner(strings.NewReader("db\n")), out: io.Discard}
+	if _, err = cmd.ask(policies); err == nil {
+		t.Error("wizard not aborted once the input ends")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for s, exp := range map[string]string{
+		"%db,%mm":      "%db,%mm",
+		"/tmp/a-b.lp":  "/tmp/a-b.lp",
+		"":             "''",
+		"a b":          "'a b'",
+		"it's":         `'it'\''s'`,
+		"cpu,host=a$b": "'cpu,host=a$b'",
+	} {
+		if got := quote(s); got != exp {
+			t.Errorf("quote(%q): got %s, expected %s", s, got, exp)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package agent streams the binary transfer format between a source-side and a target-side influx-tool over gRPC.
+//
+// The service has no generated stubs: messages are raw bytes, either JSON encoded requests and responses
+// or chunks of the binary stream, marshaled by a codec registered for the "raw" content subtype.
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip" // register gzip compressor
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	serviceName = "influxtool.Transfer"
+	codecName   = "raw"
+	chunkSize   = 256 * 1024
+
+	mdAuthorization = "authorization"
+	mdNode          = "x-node"
+	mdDatabase      = "x-database"
+	mdRetention     = "x-retention-policy"
+	mdDuration      = "x-duration"
+	mdShardDuration = "x-shard-duration"
+)
+
+func init() {
+	encoding.RegisterCodec(rawCodec{})
+}
+
+// rawCodec passes *[]byte messages through as they are.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("raw codec: unexpected message type %T", v)
+	}
+	// copy since the transport may still hold the message after SendMsg returns
+	return append([]byte(nil), *b...), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec: unexpected message type %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return codecName
+}
+
+// StatusRequest asks which buckets of a node, database and retention policy have been imported.
+type StatusRequest struct {
+	Node            int    `json:"node"`
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retention_policy"`
+}
+
+// StatusResponse lists the start times of the buckets imported.
+type StatusResponse struct {
+	Starts []int64 `json:"starts"`
+}
+
+// PushInfo describes a binary stream pushed to the target node.
+type PushInfo struct {
+	Node            int
+	Database        string
+	RetentionPolicy string
+	Duration        time.Duration
+	ShardDuration   time.Duration
+}
+
+func (pi *PushInfo) metadata() metadata.MD {
+	return metadata.Pairs(
+		mdNode, strconv.Itoa(pi.Node),
+		mdDatabase, pi.Database,
+		mdRetention, pi.RetentionPolicy,
+		mdDuration, pi.Duration.String(),
+		mdShardDuration, pi.ShardDuration.String(),
+	)
+}
+
+func parsePushInfo(md metadata.MD) (*PushInfo, error) {
+	get := func(key string) string {
+		if vs := md.Get(key); len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}
+	var err error
+	pi := &PushInfo{Database: get(mdDatabase), RetentionPolicy: get(mdRetention)}
+	if pi.Node, err = strconv.Atoi(get(mdNode)); err != nil {
+		return nil, fmt.Errorf("invalid node: %v", err)
+	}
+	if pi.Duration, err = time.ParseDuration(get(mdDuration)); err != nil {
+		return nil, fmt.Errorf("invalid duration: %v", err)
+	}
+	if pi.ShardDuration, err = time.ParseDuration(get(mdShardDuration)); err != nil {
+		return nil, fmt.Errorf("invalid shard duration: %v", err)
+	}
+	if pi.Database == "" || pi.RetentionPolicy == "" {
+		return nil, fmt.Errorf("database and retention policy required")
+	}
+	return pi, nil
+}
+
+// transferServer is the service implemented by Server.
+type transferServer interface {
+	status(in []byte) (interface{}, error)
+	push(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*transferServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Status", Handler: statusHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Push", Handler: pushHandler, ClientStreams: true},
+	},
+}
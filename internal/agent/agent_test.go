@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testHandler struct {
+	pi   *PushInfo
+	data []byte
+	fail bool
+}
+
+func (h *testHandler) Status(req *StatusRequest) ([]int64, error) {
+	if req.Database != "db" {
+		return nil, errors.New("database not found")
+	}
+	return []int64{int64(req.Node), 10}, nil
+}
+
+func (h *testHandler) Import(pi *PushInfo, r io.Reader) error {
+	h.pi = pi
+	if h.fail {
+		return errors.New("import failed")
+	}
+	var err error
+	h.data, err = io.ReadAll(r)
+	return err
+}
+
+func startServer(t *testing.T, h Handler, token string) string {
+	srv, err := NewServer(h, ServerConfig{Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	return ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string, config ClientConfig) *Client {
+	c, err := Dial(addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestPush(t *testing.T) {
+	h := &testHandler{}
+	addr := startServer(t, h, "token")
+	c := dial(t, addr, ClientConfig{Token: "token", Compress: true})
+
+	data := bytes.Repeat([]byte("0123456789"), chunkSize/4)
+	pi := &PushInfo{Node: 1, Database: "db", RetentionPolicy: "rp", Duration: time.Hour, ShardDuration: 24 * time.Hour}
+	if err := c.Push(context.Background(), pi, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(h.pi, pi) {
+		t.Errorf("unexpected push info: got=%+v, exp=%+v", h.pi, pi)
+	}
+	if !bytes.Equal(h.data, data) {
+		t.Errorf("unexpected data length: got=%d, exp=%d", len(h.data), len(data))
+	}
+
+	starts, err := c.Status(context.Background(), &StatusRequest{Node: 2, Database: "db", RetentionPolicy: "rp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(starts, []int64{2, 10}) {
+		t.Errorf("unexpected starts: %v", starts)
+	}
+	if _, err = c.Status(context.Background(), &StatusRequest{Database: "none"}); status.Code(err) != codes.Internal {
+		t.Errorf("unexpected status error: %v", err)
+	}
+}
+
+func TestPushError(t *testing.T) {
+	h := &testHandler{fail: true}
+	addr := startServer(t, h, "token")
+	pi := &PushInfo{Database: "db", RetentionPolicy: "rp"}
+
+	c := dial(t, addr, ClientConfig{Token: "invalid"})
+	if err := c.Push(context.Background(), pi, bytes.NewReader([]byte("data"))); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("unexpected error with invalid token: %v", err)
+	}
+
+	c = dial(t, addr, ClientConfig{Token: "token"})
+	if err := c.Push(context.Background(), pi, bytes.NewReader(make([]byte, 4*chunkSize))); status.Code(err) != codes.Internal {
+		t.Errorf("unexpected error with import failed: %v", err)
+	}
+}
+
+type testExportHandler struct {
+	msgs [][]byte
+	fail bool
+}
+
+func (h *testExportHandler) Export(ctx context.Context, send func(msg []byte) error) error {
+	for _, msg := range h.msgs {
+		if err := send(msg); err != nil {
+			return err
+		}
+	}
+	if h.fail {
+		return errors.New("export failed")
+	}
+	return nil
+}
+
+func TestPull(t *testing.T) {
+	h := &testExportHandler{msgs: [][]byte{[]byte("a"), bytes.Repeat([]byte("b"), chunkSize), []byte("c")}}
+	srv, err := NewExportServer(h, ServerConfig{Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	c := dial(t, ln.Addr().String(), ClientConfig{Token: "token"})
+	var msgs [][]byte
+	if err = c.Pull(context.Background(), func(msg []byte) error {
+		msgs = append(msgs, append([]byte(nil), msg...))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(msgs, h.msgs) {
+		t.Errorf("unexpected messages: got %d, exp %d", len(msgs), len(h.msgs))
+	}
+
+	h.fail = true
+	if err = c.Pull(context.Background(), func([]byte) error { return nil }); status.Code(err) != codes.Internal {
+		t.Errorf("unexpected error with export failed: %v", err)
+	}
+	c = dial(t, ln.Addr().String(), ClientConfig{Token: "invalid"})
+	if err = c.Pull(context.Background(), func([]byte) error { return nil }); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("unexpected error with invalid token: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+)
+
+type ClientConfig struct {
+	Token              string // token to authenticate to the agent
+	TLS                bool
+	TLSCA              string // ca certificate file to verify the agent, empty for system roots
+	InsecureSkipVerify bool
+	Compress           bool // compress the stream with gzip
+}
+
+type Client struct {
+	conn  *grpc.ClientConn
+	token string
+}
+
+func Dial(addr string, config ClientConfig) (*Client, error) {
+	callOpts := []grpc.CallOption{grpc.CallContentSubtype(codecName)}
+	if config.Compress {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+	opts := []grpc.DialOption{grpc.WithDefaultCallOptions(callOpts...)}
+	if config.TLS {
+		tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+		if config.TLSCA != "" {
+			b, err := os.ReadFile(config.TLSCA)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("no certificates found in %s", config.TLSCA)
+			}
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, token: config.Token}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) context(ctx context.Context, md metadata.MD) context.Context {
+	if c.token != "" {
+		md = metadata.Join(md, metadata.Pairs(mdAuthorization, "Bearer "+c.token))
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// Status returns the start times of the buckets already imported by the agent.
+func (c *Client) Status(ctx context.Context, req *StatusRequest) ([]int64, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	if err = c.conn.Invoke(c.context(ctx, metadata.MD{}), "/"+serviceName+"/Status", &in, &out); err != nil {
+		return nil, err
+	}
+	var resp StatusResponse
+	if err = json.Unmarshal(out, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Starts, nil
+}
+
+// Push sends the binary stream read from r to the agent and waits until it is imported.
+func (c *Client) Push(ctx context.Context, pi *PushInfo, r io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.conn.NewStream(c.context(ctx, pi.metadata()), &serviceDesc.Streams[0], "/"+serviceName+"/Push")
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			if serr := stream.SendMsg(&chunk); serr != nil {
+				if serr == io.EOF {
+					// the agent closed the stream, get its error by RecvMsg
+					break
+				}
+				return serr
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if err = stream.CloseSend(); err != nil {
+		return err
+	}
+	var out []byte
+	return stream.RecvMsg(&out)
+}
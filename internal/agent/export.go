@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const exportServiceName = "influxtool.Export"
+
+// ExportHandler streams an export to the clients pulling it.
+type ExportHandler interface {
+	// Export calls send with each message of the export until done, and stops once send fails as the client is gone.
+	Export(ctx context.Context, send func(msg []byte) error) error
+}
+
+// exportServer is the service implemented by ExportServer.
+type exportServer interface {
+	pull(stream grpc.ServerStream) error
+}
+
+var exportServiceDesc = grpc.ServiceDesc{
+	ServiceName: exportServiceName,
+	HandlerType: (*exportServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Pull", Handler: pullHandler, ServerStreams: true},
+	},
+}
+
+// ExportServer serves the export stream to the clients pulling it, such as the arrow record batches of export.
+// Each message is a chunk of raw bytes, the request is empty.
+type ExportServer struct {
+	handler ExportHandler
+	grpc    *grpc.Server
+}
+
+func NewExportServer(handler ExportHandler, config ServerConfig) (*ExportServer, error) {
+	s := &ExportServer{handler: handler}
+	opts, err := serverOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	s.grpc = grpc.NewServer(opts...)
+	s.grpc.RegisterService(&exportServiceDesc, s)
+	return s, nil
+}
+
+// Serve accepts connections on the listener until Stop is called.
+func (s *ExportServer) Serve(ln net.Listener) error {
+	return s.grpc.Serve(ln)
+}
+
+// Stop stops the server after pending pulls finish.
+func (s *ExportServer) Stop() {
+	s.grpc.GracefulStop()
+}
+
+func pullHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(exportServer).pull(stream)
+}
+
+func (s *ExportServer) pull(stream grpc.ServerStream) error {
+	var in []byte
+	if err := stream.RecvMsg(&in); err != nil {
+		return err
+	}
+	err := s.handler.Export(stream.Context(), func(msg []byte) error {
+		return stream.SendMsg(&msg)
+	})
+	if err != nil {
+		if stream.Context().Err() != nil {
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// Pull pulls the export stream from the export server, and calls fn with each message until the stream ends.
+func (c *Client) Pull(ctx context.Context, fn func(msg []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.conn.NewStream(c.context(ctx, nil), &exportServiceDesc.Streams[0], "/"+exportServiceName+"/Pull")
+	if err != nil {
+		return err
+	}
+	in := []byte{}
+	if err = stream.SendMsg(&in); err != nil {
+		return err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return err
+	}
+	var msg []byte
+	for {
+		if err = stream.RecvMsg(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err = fn(msg); err != nil {
+			return err
+		}
+	}
+}
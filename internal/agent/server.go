@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Handler imports the binary streams pushed to the agent.
+type Handler interface {
+	// Status returns the start times of the buckets already imported.
+	Status(req *StatusRequest) ([]int64, error)
+	// Import imports a binary stream, which must be fully consumed or fail.
+	Import(pi *PushInfo, r io.Reader) error
+}
+
+type ServerConfig struct {
+	Token   string // token required from clients, empty for no authentication
+	TLSCert string
+	TLSKey  string
+}
+
+type Server struct {
+	handler Handler
+	grpc    *grpc.Server
+}
+
+func NewServer(handler Handler, config ServerConfig) (*Server, error) {
+	s := &Server{handler: handler}
+	opts, err := serverOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	s.grpc = grpc.NewServer(opts...)
+	s.grpc.RegisterService(&serviceDesc, s)
+	return s, nil
+}
+
+// serverOptions returns the options of the grpc server authenticating the clients by the token, and serving tls if
+// the certificate is given.
+func serverOptions(config ServerConfig) ([]grpc.ServerOption, error) {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+			if err := authorize(ctx, config.Token); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := authorize(ss.Context(), config.Token); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	}
+	if config.TLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	return opts, nil
+}
+
+// Serve accepts connections on the listener until Stop is called.
+func (s *Server) Serve(ln net.Listener) error {
+	return s.grpc.Serve(ln)
+}
+
+// Stop stops the server after pending pushes finish.
+func (s *Server) Stop() {
+	s.grpc.GracefulStop()
+}
+
+func authorize(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vs := md.Get(mdAuthorization); len(vs) > 0 && subtle.ConstantTimeCompare([]byte(vs[0]), []byte("Bearer "+token)) == 1 {
+		return nil
+	}
+	return status.Error(codes.Unauthenticated, "invalid token")
+}
+
+func statusHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	var in []byte
+	if err := dec(&in); err != nil {
+		return nil, err
+	}
+	h := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(transferServer).status(*req.(*[]byte))
+	}
+	if interceptor == nil {
+		return h(ctx, &in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Status"}
+	return interceptor(ctx, &in, info, h)
+}
+
+func (s *Server) status(in []byte) (interface{}, error) {
+	var req StatusRequest
+	if err := json.Unmarshal(in, &req); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid status request: %v", err)
+	}
+	starts, err := s.handler.Status(&req)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out, err := json.Marshal(&StatusResponse{Starts: starts})
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func pushHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(transferServer).push(stream)
+}
+
+func (s *Server) push(stream grpc.ServerStream) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	pi, err := parsePushInfo(md)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// stream.RecvMsg blocks until the handler consumes the previous chunk,
+	// so a slow import holds back the client by http2 flow control
+	pr, pw := io.Pipe()
+	go func() {
+		var chunk []byte
+		for {
+			if err := stream.RecvMsg(&chunk); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = s.handler.Import(pi, pr)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	out := []byte{}
+	return stream.SendMsg(&out)
+}
@@ -0,0 +1,204 @@
+// Package audit lists the filesystem mutations planned by an operation before anything happens, so that the files
+// created, rewritten, renamed or deleted are reviewed and approved, interactively or by an approve file.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// PlanFlag is the flag of the root command writing the audit plan before the operation.
+	PlanFlag = "audit-plan"
+	// ApproveFlag is the flag of the root command approving the audit plan by a plan file reviewed in advance.
+	ApproveFlag = "approve-file"
+	// DryRunFlag is the flag of the root command reporting the mutations of an operation without doing any.
+	DryRunFlag = "dry-run"
+	// Annotation marks the commands planning their filesystem mutations, which support the audit plan and dry run.
+	Annotation = "audit"
+	// DryRunAnnotation marks the commands mutating servers rather than files, which report their writes and
+	// statements instead of the audit plan on dry run.
+	DryRunAnnotation = "dry-run"
+)
+
+const (
+	Create  = "create"
+	Rewrite = "rewrite"
+	Rename  = "rename"
+	Delete  = "delete"
+)
+
+// Op is a filesystem mutation of a path.
+type Op struct {
+	Action string
+	Path   string
+	To     string // new path of rename
+	Note   string // explains the mutation whose exact paths are unknown in advance
+}
+
+func (op Op) String() string {
+	s := fmt.Sprintf("%-7s %s", op.Action, op.Path)
+	if op.To != "" {
+		s += " -> " + op.To
+	}
+	if op.Note != "" {
+		s += "  # " + op.Note
+	}
+	return s
+}
+
+// Plan is the filesystem mutations of an operation in the order they happen.
+type Plan struct {
+	ops []Op
+}
+
+func (p *Plan) Add(action, path, note string) {
+	p.ops = append(p.ops, Op{Action: action, Path: path, Note: note})
+}
+
+func (p *Plan) Rename(from, to, note string) {
+	p.ops = append(p.ops, Op{Action: Rename, Path: from, To: to, Note: note})
+}
+
+// Write adds the path written from the start, which is rewritten if exists or created otherwise.
+func (p *Plan) Write(path, note string) {
+	if _, err := os.Stat(path); err == nil {
+		p.Add(Rewrite, path, note)
+	} else {
+		p.Add(Create, path, note)
+	}
+}
+
+func (p *Plan) Ops() []Op {
+	return p.ops
+}
+
+// Lines returns the mutations as lines, one mutation per line.
+func (p *Plan) Lines() []string {
+	lines := make([]string, len(p.ops))
+	for i, op := range p.ops {
+		lines[i] = op.String()
+	}
+	return lines
+}
+
+// Options are the audit flags of a command.
+type Options struct {
+	Enabled     bool
+	DryRun      bool
+	ApproveFile string
+	In          io.Reader // answers of the interactive approval
+	Out         io.Writer // plan written to
+	Prompt      io.Writer // prompt of the interactive approval
+}
+
+// FromCommand returns the audit flags of c inherited from the root command.
+func FromCommand(c *cobra.Command) Options {
+	o := Options{In: os.Stdin, Out: os.Stdout, Prompt: os.Stderr}
+	o.Enabled, _ = c.Flags().GetBool(PlanFlag)
+	o.ApproveFile, _ = c.Flags().GetString(ApproveFlag)
+	// a local dry-run flag of c such as the one of export shadows the root one
+	o.DryRun, _ = c.InheritedFlags().GetBool(DryRunFlag)
+	return o
+}
+
+// Planned returns whether the plan is required by Gate, for the audit plan or dry run.
+func (o Options) Planned() bool {
+	return o.Enabled || o.DryRun
+}
+
+// Supported returns whether c plans its filesystem mutations for the audit plan.
+func Supported(c *cobra.Command) bool {
+	_, ok := c.Annotations[Annotation]
+	return ok
+}
+
+// DryRunSupported returns whether c reports its mutations without doing any on dry run.
+func DryRunSupported(c *cobra.Command) bool {
+	_, ok := c.Annotations[DryRunAnnotation]
+	return ok || Supported(c)
+}
+
+// Validate checks the audit flags set on the command line of c.
+func Validate(c *cobra.Command) error {
+	o := FromCommand(c)
+	if o.ApproveFile != "" && !o.Enabled {
+		return errors.New("must specify audit plan when approve file given")
+	}
+	if o.Enabled && !Supported(c) {
+		return fmt.Errorf("audit plan is not available for %s", c.CommandPath())
+	}
+	if o.DryRun && !DryRunSupported(c) {
+		return fmt.Errorf("dry run is not available for %s", c.CommandPath())
+	}
+	if o.DryRun && o.ApproveFile != "" {
+		return errors.New("approve file is not available for dry run")
+	}
+	return nil
+}
+
+// Gate writes the plan and returns whether to proceed with it if enabled, or never on dry run. The plan is approved if its mutations are
+// the same as the ones of the approve file, which is a plan written before and reviewed, with the other lines such as
+// the comments and logs ignored, or approved interactively otherwise.
+func (o Options) Gate(p *Plan) (bool, error) {
+	if !o.Planned() {
+		return true, nil
+	}
+	if o.DryRun {
+		fmt.Fprintf(o.Out, "# dry run: %d filesystem mutations, nothing changed\n", len(p.ops))
+	} else {
+		fmt.Fprintf(o.Out, "# audit plan: %d filesystem mutations\n", len(p.ops))
+	}
+	lines := p.Lines()
+	for _, line := range lines {
+		fmt.Fprintln(o.Out, line)
+	}
+	if o.DryRun {
+		return false, nil
+	}
+	if o.ApproveFile != "" {
+		if err := o.approve(lines); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	fmt.Fprint(o.Prompt, "proceed with the audit plan? [N] ")
+	scan := bufio.NewScanner(o.In)
+	scan.Scan()
+	if scan.Err() != nil {
+		return false, fmt.Errorf("error reading stdin: %v", scan.Err())
+	}
+	return strings.ToLower(scan.Text()) == "y", nil
+}
+
+// approve checks the lines of the plan are the same as the ones of the approve file.
+func (o Options) approve(lines []string) error {
+	b, err := os.ReadFile(o.ApproveFile)
+	if err != nil {
+		return err
+	}
+	var approved []string
+	for _, line := range strings.Split(string(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))), "\n") {
+		if action, _, _ := strings.Cut(line, " "); action == Create || action == Rewrite || action == Rename || action == Delete {
+			approved = append(approved, line)
+		}
+	}
+	for i := 0; i < len(lines) || i < len(approved); i++ {
+		switch {
+		case i >= len(approved):
+			return fmt.Errorf("audit plan not approved by %s, unapproved: %s", o.ApproveFile, lines[i])
+		case i >= len(lines):
+			return fmt.Errorf("audit plan not approved by %s, no longer planned: %s", o.ApproveFile, approved[i])
+		case lines[i] != approved[i]:
+			return fmt.Errorf("audit plan not approved by %s, planned: %s, approved: %s", o.ApproveFile, lines[i], approved[i])
+		}
+	}
+	return nil
+}
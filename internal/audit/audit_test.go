@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestGate(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "meta.db")
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := &Plan{}
+	p.Add(Create, "/data/1/000000002-000000002.tsm.tmp", "and the following sequences if larger than 2GB")
+	p.Rename("/data/1/000000002-000000002.tsm.tmp", "/data/1/000000002-000000002.tsm", "")
+	p.Add(Delete, "/data/1/000000002-000000001.tsm", "")
+	p.Write(existing, "")
+	p.Write(filepath.Join(dir, "transfer.state"), "appended")
+
+	var out bytes.Buffer
+	o := Options{Enabled: true, In: strings.NewReader("y\n"), Out: &out, Prompt: &bytes.Buffer{}}
+	if ok, err := o.Gate(p); !ok || err != nil {
+		t.Fatalf("unexpected gate %v, error %v", ok, err)
+	}
+	exp := "# audit plan: 5 filesystem mutations\n" +
+		"create  /data/1/000000002-000000002.tsm.tmp  # and the following sequences if larger than 2GB\n" +
+		"rename  /data/1/000000002-000000002.tsm.tmp -> /data/1/000000002-000000002.tsm\n" +
+		"delete  /data/1/000000002-000000001.tsm\n" +
+		"rewrite " + existing + "\n" +
+		"create  " + filepath.Join(dir, "transfer.state") + "  # appended\n"
+	if out.String() != exp {
+		t.Fatalf("unexpected plan:\n%s", out.String())
+	}
+
+	o.ApproveFile = filepath.Join(dir, "plan.txt")
+	if err := os.WriteFile(o.ApproveFile, append([]byte("preflight: 3 shards\n"), out.Bytes()...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o.In, o.ApproveFile = strings.NewReader(""), ""
+	if ok, err := o.Gate(p); ok || err != nil {
+		t.Errorf("unexpected gate without answer %v, error %v", ok, err)
+	}
+
+	o.ApproveFile = filepath.Join(dir, "plan.txt")
+	if ok, err := o.Gate(p); !ok || err != nil {
+		t.Errorf("unexpected gate of approve file %v, error %v", ok, err)
+	}
+	p.Add(Delete, "/data/1/000000001-000000001.tombstone", "")
+	if ok, err := o.Gate(p); ok || err == nil || !strings.Contains(err.Error(), "unapproved: delete  /data/1/000000001-000000001.tombstone") {
+		t.Errorf("unexpected gate of changed plan %v, error %v", ok, err)
+	}
+
+	out.Reset()
+	o = Options{DryRun: true, In: strings.NewReader("y\n"), Out: &out, Prompt: &bytes.Buffer{}}
+	if ok, err := o.Gate(p); ok || err != nil {
+		t.Errorf("unexpected gate of dry run %v, error %v", ok, err)
+	}
+	if !strings.HasPrefix(out.String(), "# dry run: 6 filesystem mutations, nothing changed\n") {
+		t.Errorf("unexpected dry run:\n%s", out.String())
+	}
+
+	o = Options{}
+	if ok, err := o.Gate(p); !ok || err != nil {
+		t.Errorf("unexpected gate disabled %v, error %v", ok, err)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{Use: "influx-tool"}
+	root.PersistentFlags().Bool(PlanFlag, false, "")
+	root.PersistentFlags().String(ApproveFlag, "", "")
+	root.PersistentFlags().Bool(DryRunFlag, false, "")
+	planned := &cobra.Command{Use: "compact", Annotations: map[string]string{Annotation: ""}}
+	written := &cobra.Command{Use: "cleanup", Annotations: map[string]string{DryRunAnnotation: ""}}
+	shadowed := &cobra.Command{Use: "export"}
+	shadowed.Flags().Bool(DryRunFlag, false, "")
+	other := &cobra.Command{Use: "migrate"}
+	root.AddCommand(planned, written, shadowed, other)
+	return root
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{args: []string{"compact", "--dry-run"}},
+		{args: []string{"cleanup", "--dry-run"}},
+		{args: []string{"export", "--dry-run"}},
+		{args: []string{"migrate"}},
+		{args: []string{"migrate", "--dry-run"}, err: "dry run is not available for influx-tool migrate"},
+		{args: []string{"cleanup", "--audit-plan"}, err: "audit plan is not available for influx-tool cleanup"},
+		{args: []string{"compact", "--dry-run", "--audit-plan", "--approve-file", "plan.txt"}, err: "approve file is not available for dry run"},
+	}
+	for _, tt := range tests {
+		c, args, err := newRootCommand().Find(tt.args)
+		if err == nil {
+			err = c.ParseFlags(args)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Validate(c)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%v: unexpected error %v, expected %q", tt.args, err, tt.err)
+		}
+		if dryRun := FromCommand(c).DryRun; dryRun != (tt.args[0] != "export" && strings.Contains(strings.Join(tt.args, " "), "--dry-run")) {
+			t.Errorf("%v: unexpected dry run %v", tt.args, dryRun)
+		}
+	}
+}
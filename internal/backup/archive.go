@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// legacyBackupPattern matches the shard files of legacy backup like db.rp.00001.00
+var legacyBackupPattern = regexp.MustCompile(`^.+\.\d{5}\.\d{2}$`)
+
+// IsArchive reports whether name is a tar archive possibly containing tsm files,
+// such as a shard file of portable or legacy backup, or a tarball of backup directory.
+func IsArchive(name string) bool {
+	base := path.Base(filepath.ToSlash(name))
+	return strings.HasSuffix(base, ".tar") || isGzipArchive(base) || legacyBackupPattern.MatchString(base)
+}
+
+func isGzipArchive(name string) bool {
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// WalkArchive calls fn for each tsm file in the archive r, descending into nested archives.
+func WalkArchive(r io.Reader, name string, fn func(name string, r io.Reader) error) error {
+	return walkArchive(r, name, false, fn)
+}
+
+// WalkArchiveTombstones calls fn for each tsm and tombstone file in the archive r like WalkArchive. The tombstone
+// file is written before its tsm file by influxd backup, as the files of a shard are written in name order.
+func WalkArchiveTombstones(r io.Reader, name string, fn func(name string, r io.Reader) error) error {
+	return walkArchive(r, name, true, fn)
+}
+
+func walkArchive(r io.Reader, name string, tombstones bool, fn func(name string, r io.Reader) error) error {
+	if isGzipArchive(name) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("read archive %s error: %v", name, err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read archive %s error: %v", name, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if ext := path.Ext(hdr.Name); ext == "."+tsm1.TSMFileExtension || tombstones && ext == "."+tsm1.TombstoneFileExtension {
+			err = fn(hdr.Name, tr)
+		} else if IsArchive(hdr.Name) {
+			err = walkArchive(tr, hdr.Name, tombstones, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ShardPath returns the database and retention policy of a tsm file named db/rp/shard/file.tsm in archive.
+func ShardPath(name string) (db, rp string, ok bool) {
+	dirs := strings.Split(path.Clean(name), "/")
+	if len(dirs) < 4 {
+		return "", "", false
+	}
+	return dirs[len(dirs)-4], dirs[len(dirs)-3], true
+}
+
+// ShardID returns the shard id of a tsm file named db/rp/shard/file.tsm in archive.
+func ShardID(name string) (uint64, bool) {
+	dirs := strings.Split(path.Clean(name), "/")
+	if len(dirs) < 4 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(dirs[len(dirs)-2], 10, 64)
+	return id, err == nil
+}
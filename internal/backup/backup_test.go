@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestIsArchive(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  bool
+	}{
+		{name: "db.autogen.00001.00", exp: true},
+		{name: "/backup/20240101T000000Z.s1.tar.gz", exp: true},
+		{name: "backup.tar", exp: true},
+		{name: "backup.tgz", exp: true},
+		{name: "meta.00", exp: false},
+		{name: "20240101T000000Z.manifest", exp: false},
+		{name: "20240101T000000Z.meta", exp: false},
+	}
+	for _, tt := range tests {
+		if got := IsArchive(tt.name); got != tt.exp {
+			t.Errorf("IsArchive(%q): got=%v, exp=%v", tt.name, got, tt.exp)
+		}
+	}
+}
+
+func TestShardPath(t *testing.T) {
+	db, rp, ok := ShardPath("db/autogen/1/000000001-000000001.tsm")
+	if !ok || db != "db" || rp != "autogen" {
+		t.Errorf("unexpected shard path: db=%s, rp=%s, ok=%v", db, rp, ok)
+	}
+	if _, _, ok = ShardPath("1/000000001-000000001.tsm"); ok {
+		t.Error("shard path without database and retention policy should be invalid")
+	}
+	if id, ok := ShardID("db/autogen/12/000000001-000000001.tsm"); !ok || id != 12 {
+		t.Errorf("unexpected shard id: id=%d, ok=%v", id, ok)
+	}
+	if _, ok := ShardID("db/autogen/shard/000000001-000000001.tsm"); ok {
+		t.Error("shard id not a number should be invalid")
+	}
+}
+
+func TestWalkArchive(t *testing.T) {
+	exp := map[string]tsm1.Values{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2, 2.5)},
+		"cpu,host=b#!~#count": {tsm1.NewIntegerValue(1, 1)},
+		"cpu,host=b#!~#msg":   {tsm1.NewStringValue(3, "ok")},
+	}
+	tsm := writeTSM(t, exp)
+
+	// a portable shard archive nested in a tarball of backup directory
+	shard := writeTar(t, true, map[string][]byte{"db/autogen/1/000000001-000000001.tsm": tsm, "db/autogen/1/fields.idx": {}})
+	archive := writeTar(t, false, map[string][]byte{"backup/20240101T000000Z.s1.tar.gz": shard})
+
+	var names []string
+	err := WalkArchive(bytes.NewReader(archive), "backup.tar", func(name string, r io.Reader) error {
+		names = append(names, name)
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		tr, err := NewTSMReader(b)
+		if err != nil {
+			return err
+		}
+		if tr.KeyCount() != len(exp) {
+			t.Fatalf("unexpected key count: got=%d, exp=%d", tr.KeyCount(), len(exp))
+		}
+		if min, max := tr.TimeRange(); min != 1 || max != 3 {
+			t.Errorf("unexpected time range: min=%d, max=%d", min, max)
+		}
+		for i := 0; i < tr.KeyCount(); i++ {
+			key, _ := tr.KeyAt(i)
+			values, err := tr.ReadAll(key)
+			if err != nil {
+				return err
+			}
+			if !cmp.Equal(values, []tsm1.Value(exp[string(key)]), cmp.Comparer(valueEqual)) {
+				t.Errorf("unexpected values for %s: got=%v, exp=%v", key, values, exp[string(key)])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(names, []string{"db/autogen/1/000000001-000000001.tsm"}) {
+		t.Errorf("unexpected tsm files: %v", names)
+	}
+}
+
+func TestNewTSMReaderInvalid(t *testing.T) {
+	if _, err := NewTSMReader([]byte("not a tsm file")); err == nil {
+		t.Error("expected error for invalid tsm file")
+	}
+}
+
+func valueEqual(a, b tsm1.Value) bool {
+	return a.UnixNano() == b.UnixNano() && a.Value() == b.Value()
+}
+
+func writeTSM(t *testing.T, values map[string]tsm1.Values) []byte {
+	var buf bytes.Buffer
+	w, err := tsm1.NewTSMWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"cpu,host=a#!~#usage", "cpu,host=b#!~#count", "cpu,host=b#!~#msg"} {
+		if err = w.Write([]byte(key), values[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.WriteIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTar(t *testing.T, compress bool, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(&buf)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	for name, b := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
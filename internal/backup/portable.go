@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/influxdata/influxdb/cmd/influxd/backup_util"
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+// LoadPortable loads the meta data and the latest shard files of the portable backup in dir,
+// which may consist of a full backup followed by incremental backups.
+func LoadPortable(dir string) (*meta.Data, []*backup_util.Entry, error) {
+	metaEntry, shards, err := backup_util.LoadIncremental(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if metaEntry == nil {
+		return nil, nil, fmt.Errorf("no manifest files found in %s", dir)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, metaEntry.FileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	var ep backup_util.PortablePacker
+	if err = ep.UnmarshalBinary(b); err != nil {
+		return nil, nil, fmt.Errorf("read meta file %s error: %v", metaEntry.FileName, err)
+	}
+	data := &meta.Data{}
+	if err = data.UnmarshalBinary(ep.Data); err != nil {
+		return nil, nil, fmt.Errorf("read meta file %s error: %v", metaEntry.FileName, err)
+	}
+
+	files := make([]*backup_util.Entry, 0, len(shards))
+	for _, e := range shards {
+		files = append(files, e)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ShardID < files[j].ShardID })
+	return data, files, nil
+}
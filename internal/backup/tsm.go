@@ -0,0 +1,14 @@
+package backup
+
+import (
+	"bytes"
+
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+)
+
+// TSMReader reads a tsm file held in memory, such as one extracted from a backup archive.
+type TSMReader = tsmread.Reader
+
+func NewTSMReader(b []byte) (*TSMReader, error) {
+	return tsmread.NewReaderAt(bytes.NewReader(b), int64(len(b)))
+}
@@ -20,6 +20,7 @@ It has these top-level messages:
 	StringPoints
 	SeriesHeader
 	SeriesFooter
+	SeriesInfo
 */
 package binary
 
@@ -83,13 +84,16 @@ type Header_Version int32
 
 const (
 	Version0 Header_Version = 0
+	Version1 Header_Version = 1
 )
 
 var Header_Version_name = map[int32]string{
 	0: "VERSION_0",
+	1: "VERSION_1",
 }
 var Header_Version_value = map[string]int32{
 	"VERSION_0": 0,
+	"VERSION_1": 1,
 }
 
 func (x Header_Version) String() string {
@@ -102,6 +106,8 @@ type Header struct {
 	Database        string         `protobuf:"bytes,2,opt,name=database,proto3" json:"database,omitempty"`
 	RetentionPolicy string         `protobuf:"bytes,3,opt,name=retention_policy,json=retentionPolicy,proto3" json:"retention_policy,omitempty"`
 	ShardDuration   time.Duration  `protobuf:"varint,4,opt,name=shard_duration,json=shardDuration,proto3,stdduration" json:"shard_duration,omitempty"`
+	Series          []*SeriesInfo  `protobuf:"bytes,5,rep,name=series" json:"series,omitempty"`
+	SeriesCounts    []int64        `protobuf:"varint,6,rep,packed,name=series_counts,json=seriesCounts" json:"series_counts,omitempty"`
 }
 
 func (m *Header) Reset()                    { *m = Header{} }
@@ -196,6 +202,17 @@ func (m *SeriesFooter) String() string            { return proto.CompactTextStri
 func (*SeriesFooter) ProtoMessage()               {}
 func (*SeriesFooter) Descriptor() ([]byte, []int) { return fileDescriptorBinary, []int{9} }
 
+type SeriesInfo struct {
+	FieldType FieldType `protobuf:"varint,1,opt,name=field_type,json=fieldType,proto3,enum=binary.FieldType" json:"field_type,omitempty"`
+	SeriesKey []byte    `protobuf:"bytes,2,opt,name=series_key,json=seriesKey,proto3" json:"series_key,omitempty"`
+	Field     []byte    `protobuf:"bytes,3,opt,name=field,proto3" json:"field,omitempty"`
+}
+
+func (m *SeriesInfo) Reset()                    { *m = SeriesInfo{} }
+func (m *SeriesInfo) String() string            { return proto.CompactTextString(m) }
+func (*SeriesInfo) ProtoMessage()               {}
+func (*SeriesInfo) Descriptor() ([]byte, []int) { return fileDescriptorBinary, []int{10} }
+
 func init() {
 	proto.RegisterType((*Header)(nil), "binary.Header")
 	proto.RegisterType((*BucketHeader)(nil), "binary.BucketHeader")
@@ -207,6 +224,7 @@ func init() {
 	proto.RegisterType((*StringPoints)(nil), "binary.StringPoints")
 	proto.RegisterType((*SeriesHeader)(nil), "binary.SeriesHeader")
 	proto.RegisterType((*SeriesFooter)(nil), "binary.SeriesFooter")
+	proto.RegisterType((*SeriesInfo)(nil), "binary.SeriesInfo")
 	proto.RegisterEnum("binary.FieldType", FieldType_name, FieldType_value)
 	proto.RegisterEnum("binary.Header_Version", Header_Version_name, Header_Version_value)
 }
@@ -247,6 +265,36 @@ func (m *Header) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintBinary(dAtA, i, uint64(m.ShardDuration))
 	}
+	if len(m.Series) > 0 {
+		for _, msg := range m.Series {
+			dAtA[i] = 0x2a
+			i++
+			i = encodeVarintBinary(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.SeriesCounts) > 0 {
+		dAtA3 := make([]byte, len(m.SeriesCounts)*10)
+		var j2 int
+		for _, num1 := range m.SeriesCounts {
+			num := uint64(num1)
+			for num >= 1<<7 {
+				dAtA3[j2] = uint8(uint64(num)&0x7f | 0x80)
+				num >>= 7
+				j2++
+			}
+			dAtA3[j2] = uint8(num)
+			j2++
+		}
+		dAtA[i] = 0x32
+		i++
+		i = encodeVarintBinary(dAtA, i, uint64(j2))
+		i += copy(dAtA[i:], dAtA3[:j2])
+	}
 	return i, nil
 }
 
@@ -400,22 +448,22 @@ func (m *IntegerPoints) MarshalTo(dAtA []byte) (int, error) {
 		}
 	}
 	if len(m.Values) > 0 {
-		dAtA3 := make([]byte, len(m.Values)*10)
-		var j2 int
-		for _, num1 := range m.Values {
-			num := uint64(num1)
+		dAtA6 := make([]byte, len(m.Values)*10)
+		var j5 int
+		for _, num4 := range m.Values {
+			num := uint64(num4)
 			for num >= 1<<7 {
-				dAtA3[j2] = uint8(uint64(num)&0x7f | 0x80)
+				dAtA6[j5] = uint8(uint64(num)&0x7f | 0x80)
 				num >>= 7
-				j2++
+				j5++
 			}
-			dAtA3[j2] = uint8(num)
-			j2++
+			dAtA6[j5] = uint8(num)
+			j5++
 		}
 		dAtA[i] = 0x12
 		i++
-		i = encodeVarintBinary(dAtA, i, uint64(j2))
-		i += copy(dAtA[i:], dAtA3[:j2])
+		i = encodeVarintBinary(dAtA, i, uint64(j5))
+		i += copy(dAtA[i:], dAtA6[:j5])
 	}
 	return i, nil
 }
@@ -459,21 +507,21 @@ func (m *UnsignedPoints) MarshalTo(dAtA []byte) (int, error) {
 		}
 	}
 	if len(m.Values) > 0 {
-		dAtA5 := make([]byte, len(m.Values)*10)
-		var j4 int
+		dAtA8 := make([]byte, len(m.Values)*10)
+		var j7 int
 		for _, num := range m.Values {
 			for num >= 1<<7 {
-				dAtA5[j4] = uint8(uint64(num)&0x7f | 0x80)
+				dAtA8[j7] = uint8(uint64(num)&0x7f | 0x80)
 				num >>= 7
-				j4++
+				j7++
 			}
-			dAtA5[j4] = uint8(num)
-			j4++
+			dAtA8[j7] = uint8(num)
+			j7++
 		}
 		dAtA[i] = 0x12
 		i++
-		i = encodeVarintBinary(dAtA, i, uint64(j4))
-		i += copy(dAtA[i:], dAtA5[:j4])
+		i = encodeVarintBinary(dAtA, i, uint64(j7))
+		i += copy(dAtA[i:], dAtA8[:j7])
 	}
 	return i, nil
 }
@@ -641,6 +689,41 @@ func (m *SeriesFooter) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *SeriesInfo) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SeriesInfo) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.FieldType != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintBinary(dAtA, i, uint64(m.FieldType))
+	}
+	if len(m.SeriesKey) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintBinary(dAtA, i, uint64(len(m.SeriesKey)))
+		i += copy(dAtA[i:], m.SeriesKey)
+	}
+	if len(m.Field) > 0 {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintBinary(dAtA, i, uint64(len(m.Field)))
+		i += copy(dAtA[i:], m.Field)
+	}
+	return i, nil
+}
+
 func encodeFixed64Binary(dAtA []byte, offset int, v uint64) int {
 	dAtA[offset] = uint8(v)
 	dAtA[offset+1] = uint8(v >> 8)
@@ -685,6 +768,19 @@ func (m *Header) Size() (n int) {
 	if m.ShardDuration != 0 {
 		n += 1 + sovBinary(uint64(m.ShardDuration))
 	}
+	if len(m.Series) > 0 {
+		for _, e := range m.Series {
+			l = e.Size()
+			n += 1 + l + sovBinary(uint64(l))
+		}
+	}
+	if len(m.SeriesCounts) > 0 {
+		l = 0
+		for _, e := range m.SeriesCounts {
+			l += sovBinary(uint64(e))
+		}
+		n += 1 + sovBinary(uint64(l)) + l
+	}
 	return n
 }
 
@@ -800,6 +896,23 @@ func (m *SeriesFooter) Size() (n int) {
 	return n
 }
 
+func (m *SeriesInfo) Size() (n int) {
+	var l int
+	_ = l
+	if m.FieldType != 0 {
+		n += 1 + sovBinary(uint64(m.FieldType))
+	}
+	l = len(m.SeriesKey)
+	if l > 0 {
+		n += 1 + l + sovBinary(uint64(l))
+	}
+	l = len(m.Field)
+	if l > 0 {
+		n += 1 + l + sovBinary(uint64(l))
+	}
+	return n
+}
+
 func sovBinary(x uint64) (n int) {
 	for {
 		n++
@@ -938,6 +1051,99 @@ func (m *Header) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBinary
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthBinary
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Series = append(m.Series, &SeriesInfo{})
+			if err := m.Series[len(m.Series)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 6:
+			if wireType == 0 {
+				var v int64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowBinary
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					v |= (int64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				m.SeriesCounts = append(m.SeriesCounts, v)
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowBinary
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= (int(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthBinary
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				for iNdEx < postIndex {
+					var v int64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowBinary
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= (int64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					m.SeriesCounts = append(m.SeriesCounts, v)
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field SeriesCounts", wireType)
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipBinary(dAtA[iNdEx:])
@@ -2089,6 +2295,137 @@ func (m *SeriesFooter) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *SeriesInfo) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowBinary
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesInfo: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesInfo: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FieldType", wireType)
+			}
+			m.FieldType = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBinary
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.FieldType |= (FieldType(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SeriesKey", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBinary
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthBinary
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SeriesKey = append(m.SeriesKey[:0], dAtA[iNdEx:postIndex]...)
+			if m.SeriesKey == nil {
+				m.SeriesKey = []byte{}
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Field", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBinary
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthBinary
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Field = append(m.Field[:0], dAtA[iNdEx:postIndex]...)
+			if m.Field == nil {
+				m.Field = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipBinary(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthBinary
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipBinary(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -2197,42 +2534,45 @@ var (
 func init() { proto.RegisterFile("binary.proto", fileDescriptorBinary) }
 
 var fileDescriptorBinary = []byte{
-	// 584 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x53, 0xcf, 0x4e, 0xdb, 0x4c,
-	0x1c, 0xcc, 0xe2, 0x10, 0x92, 0xdf, 0x67, 0xc2, 0xe2, 0x8f, 0xa2, 0xc8, 0x55, 0x8d, 0x9b, 0x5e,
-	0xd2, 0x7f, 0x01, 0xb5, 0x52, 0xef, 0x44, 0x24, 0x21, 0x2a, 0x72, 0xd0, 0x26, 0x70, 0x8d, 0x36,
-	0x78, 0x31, 0x16, 0xc1, 0x1b, 0xad, 0xd7, 0x48, 0x7e, 0x83, 0x2a, 0xa7, 0x1e, 0x7b, 0xc9, 0xa9,
-	0xaf, 0xd1, 0x07, 0xe0, 0xd8, 0x07, 0xa8, 0xd4, 0x96, 0xbe, 0x48, 0x95, 0x5d, 0xc7, 0xd0, 0x6b,
-	0x6e, 0x3b, 0xbf, 0x99, 0x9d, 0xdd, 0xf5, 0x8c, 0xc1, 0x1c, 0x87, 0x11, 0x15, 0x69, 0x73, 0x2a,
-	0xb8, 0xe4, 0x56, 0x49, 0x23, 0xfb, 0x6d, 0x10, 0xca, 0xab, 0x64, 0xdc, 0xbc, 0xe0, 0x37, 0xfb,
-	0x01, 0x0f, 0xf8, 0xbe, 0xa2, 0xc7, 0xc9, 0xa5, 0x42, 0x0a, 0xa8, 0x95, 0xde, 0x56, 0xff, 0x81,
-	0xa0, 0x74, 0xcc, 0xa8, 0xcf, 0x84, 0x75, 0x00, 0x1b, 0xb7, 0x4c, 0xc4, 0x21, 0x8f, 0x6a, 0xc8,
-	0x45, 0x8d, 0xea, 0xbb, 0xdd, 0x66, 0x76, 0x82, 0x16, 0x34, 0xcf, 0x35, 0x4b, 0x96, 0x32, 0xcb,
-	0x86, 0xb2, 0x4f, 0x25, 0x1d, 0xd3, 0x98, 0xd5, 0xd6, 0x5c, 0xd4, 0xa8, 0x90, 0x1c, 0x5b, 0x2f,
-	0x01, 0x0b, 0x26, 0x59, 0x24, 0x43, 0x1e, 0x8d, 0xa6, 0x7c, 0x12, 0x5e, 0xa4, 0x35, 0x43, 0x69,
-	0xb6, 0xf2, 0xf9, 0xa9, 0x1a, 0x5b, 0xaf, 0xa1, 0x1a, 0x5f, 0x51, 0xe1, 0x8f, 0xfc, 0x44, 0xd0,
-	0xc5, 0xbc, 0x56, 0x74, 0x51, 0xc3, 0x68, 0x15, 0xbf, 0xfc, 0xdc, 0x43, 0x64, 0x53, 0x71, 0x47,
-	0x19, 0x55, 0x7f, 0x03, 0x1b, 0xd9, 0x3d, 0xac, 0xa7, 0x50, 0x39, 0x6f, 0x93, 0x41, 0xaf, 0xef,
-	0x8d, 0x0e, 0x70, 0xc1, 0x36, 0x67, 0x73, 0xb7, 0x9c, 0x71, 0x07, 0x76, 0xf1, 0xd3, 0x57, 0xa7,
-	0x50, 0xff, 0x00, 0x66, 0x2b, 0xb9, 0xb8, 0x66, 0x32, 0x7b, 0xe3, 0x0e, 0xac, 0xc7, 0x92, 0x0a,
-	0xa9, 0x5e, 0x88, 0x89, 0x06, 0x16, 0x06, 0x83, 0x45, 0xbe, 0x7a, 0x02, 0x26, 0x8b, 0x65, 0xbd,
-	0xba, 0xdc, 0xd7, 0xe1, 0x5c, 0x32, 0x51, 0x6f, 0xc3, 0x7f, 0x9d, 0x09, 0xa7, 0xf2, 0x94, 0x87,
-	0x91, 0x8c, 0x2d, 0x07, 0x40, 0x86, 0x37, 0x2c, 0x96, 0xf4, 0x66, 0x1a, 0xd7, 0x90, 0x6b, 0x34,
-	0x30, 0x79, 0x34, 0xb1, 0x76, 0xa1, 0x74, 0x4b, 0x27, 0x09, 0x8b, 0x6b, 0x6b, 0xae, 0xd1, 0x40,
-	0x24, 0x43, 0xf5, 0x2e, 0x6c, 0xf6, 0x22, 0xc9, 0x02, 0x26, 0x56, 0x32, 0x32, 0x72, 0xa3, 0x63,
-	0xa8, 0x9e, 0x45, 0x71, 0x18, 0x44, 0xcc, 0x5f, 0xc9, 0xa9, 0xf8, 0xf8, 0x4a, 0x2d, 0xce, 0x27,
-	0x8c, 0x46, 0x2b, 0x19, 0x95, 0x73, 0xa3, 0x0e, 0x98, 0x03, 0x29, 0xc2, 0x28, 0x58, 0xc9, 0xa7,
-	0x92, 0xfb, 0x24, 0x60, 0x0e, 0x98, 0x08, 0x59, 0x9c, 0xd7, 0x12, 0x2e, 0x43, 0x36, 0xf1, 0x47,
-	0x32, 0x9d, 0xb2, 0xac, 0x99, 0xdb, 0xcb, 0x66, 0x76, 0x16, 0xcc, 0x30, 0x9d, 0x32, 0x52, 0xb9,
-	0x5c, 0x2e, 0xad, 0x67, 0x00, 0xb1, 0x72, 0x18, 0x5d, 0xb3, 0x54, 0xa5, 0x6a, 0x92, 0x8a, 0x9e,
-	0x7c, 0x64, 0xe9, 0xa2, 0x03, 0x4a, 0xab, 0xea, 0x68, 0x12, 0x0d, 0x16, 0x89, 0xeb, 0x63, 0x75,
-	0xe2, 0xaf, 0xbe, 0x21, 0xa8, 0x74, 0x1e, 0x59, 0xae, 0x77, 0x4e, 0xfa, 0x87, 0x43, 0x5c, 0xb0,
-	0xad, 0xd9, 0xdc, 0xad, 0xaa, 0x32, 0x3c, 0xd0, 0xcf, 0x61, 0xa3, 0xe7, 0x0d, 0xdb, 0xdd, 0x36,
-	0xc1, 0xc8, 0xde, 0x99, 0xcd, 0x5d, 0x9c, 0xc5, 0xfc, 0x20, 0x79, 0x01, 0xe5, 0x33, 0x6f, 0xd0,
-	0xeb, 0x7a, 0xed, 0x23, 0xbc, 0x66, 0x3f, 0x99, 0xcd, 0xdd, 0xed, 0x65, 0x82, 0xff, 0xf8, 0xb4,
-	0xfa, 0xfd, 0x93, 0xf6, 0xa1, 0x87, 0x0d, 0xed, 0x93, 0x65, 0xf3, 0x20, 0xd9, 0x83, 0xd2, 0x60,
-	0x48, 0x7a, 0x5e, 0x17, 0x17, 0xed, 0xff, 0x67, 0x73, 0x77, 0x4b, 0x7f, 0xf4, 0x5c, 0xa0, 0x8b,
-	0xdf, 0xda, 0xb9, 0xfb, 0xed, 0x14, 0xee, 0xee, 0x1d, 0xf4, 0xfd, 0xde, 0x41, 0xbf, 0xee, 0x1d,
-	0xf4, 0xf9, 0x8f, 0x53, 0x18, 0x97, 0xd4, 0x4f, 0xff, 0xfe, 0x6f, 0x00, 0x00, 0x00, 0xff, 0xff,
-	0xd5, 0x6e, 0x9c, 0xfe, 0x3b, 0x04, 0x00, 0x00,
+	// 636 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x54, 0xcb, 0x6e, 0xda, 0x4c,
+	0x18, 0xc5, 0x98, 0x38, 0xf0, 0xc5, 0x21, 0xce, 0xfc, 0xf9, 0x23, 0xcb, 0x55, 0x1d, 0xd7, 0xd9,
+	0xb8, 0xa9, 0x4a, 0x2e, 0x95, 0xba, 0x0f, 0x0d, 0x10, 0xab, 0x11, 0x44, 0x03, 0xc9, 0x16, 0x19,
+	0x3c, 0x10, 0x2b, 0xc4, 0x83, 0xec, 0x71, 0x24, 0xde, 0xa0, 0x62, 0xd5, 0x65, 0x37, 0xac, 0xfa,
+	0x1a, 0x7d, 0x80, 0xa8, 0xab, 0xbe, 0x41, 0x5b, 0xfa, 0x22, 0x15, 0xe3, 0x0b, 0x64, 0xcb, 0xa2,
+	0xbb, 0xef, 0x5c, 0x7c, 0x66, 0x98, 0x39, 0x03, 0xc8, 0x3d, 0xcf, 0x77, 0x82, 0x49, 0x65, 0x1c,
+	0x50, 0x46, 0x91, 0x14, 0x23, 0xed, 0xed, 0xd0, 0x63, 0x77, 0x51, 0xaf, 0xd2, 0xa7, 0x0f, 0xc7,
+	0x43, 0x3a, 0xa4, 0xc7, 0x5c, 0xee, 0x45, 0x03, 0x8e, 0x38, 0xe0, 0x53, 0xfc, 0x99, 0xf9, 0x3d,
+	0x0f, 0xd2, 0x25, 0x71, 0x5c, 0x12, 0xa0, 0x13, 0xd8, 0x7c, 0x24, 0x41, 0xe8, 0x51, 0x5f, 0x15,
+	0x0c, 0xc1, 0x2a, 0x9f, 0xed, 0x57, 0x92, 0x15, 0x62, 0x43, 0xe5, 0x36, 0x56, 0x71, 0x6a, 0x43,
+	0x1a, 0x14, 0x5d, 0x87, 0x39, 0x3d, 0x27, 0x24, 0x6a, 0xde, 0x10, 0xac, 0x12, 0xce, 0x30, 0x7a,
+	0x0d, 0x4a, 0x40, 0x18, 0xf1, 0x99, 0x47, 0xfd, 0xee, 0x98, 0x8e, 0xbc, 0xfe, 0x44, 0x15, 0xb9,
+	0x67, 0x27, 0xe3, 0xaf, 0x39, 0x8d, 0xde, 0x40, 0x39, 0xbc, 0x73, 0x02, 0xb7, 0xeb, 0x46, 0x81,
+	0xb3, 0xe0, 0xd5, 0x82, 0x21, 0x58, 0x62, 0xb5, 0xf0, 0xe5, 0xe7, 0x81, 0x80, 0xb7, 0xb9, 0x76,
+	0x91, 0x48, 0xe8, 0x08, 0xa4, 0x90, 0x04, 0x1e, 0x09, 0xd5, 0x0d, 0x43, 0xb4, 0xb6, 0xce, 0x50,
+	0xba, 0xc9, 0x36, 0x67, 0x6d, 0x7f, 0x40, 0x71, 0xe2, 0x40, 0x87, 0xb0, 0x1d, 0x4f, 0xdd, 0x3e,
+	0x8d, 0x7c, 0x16, 0xaa, 0x92, 0x21, 0x5a, 0x22, 0x96, 0x63, 0xf2, 0x03, 0xe7, 0x4c, 0x1b, 0x36,
+	0x93, 0x1f, 0x86, 0x5e, 0x40, 0xe9, 0xb6, 0x86, 0xdb, 0x76, 0xab, 0xd9, 0x3d, 0x51, 0x72, 0x9a,
+	0x3c, 0x9d, 0x19, 0xc5, 0x44, 0x3b, 0x59, 0x15, 0x4f, 0x15, 0xe1, 0x99, 0x78, 0xaa, 0x15, 0x3e,
+	0x7d, 0xd5, 0x73, 0xe6, 0x7b, 0x90, 0xab, 0x51, 0xff, 0x9e, 0xb0, 0xe4, 0x44, 0xf7, 0x60, 0x23,
+	0x64, 0x4e, 0xc0, 0xf8, 0x79, 0x2a, 0x38, 0x06, 0x48, 0x01, 0x91, 0xf8, 0x2e, 0x3f, 0x30, 0x05,
+	0x2f, 0x46, 0xb3, 0x9c, 0x7e, 0x57, 0xa7, 0x94, 0x91, 0xc0, 0xac, 0xc1, 0x56, 0x7d, 0x44, 0x1d,
+	0x76, 0x4d, 0x3d, 0x9f, 0x85, 0x48, 0x07, 0x60, 0xde, 0x03, 0x09, 0x99, 0xf3, 0x30, 0x0e, 0x55,
+	0xc1, 0x10, 0x2d, 0x05, 0xaf, 0x30, 0x68, 0x1f, 0xa4, 0x47, 0x67, 0x14, 0x91, 0x50, 0xcd, 0x1b,
+	0xa2, 0x25, 0xe0, 0x04, 0x99, 0x0d, 0xd8, 0xb6, 0x7d, 0x46, 0x86, 0x24, 0x58, 0x2b, 0x48, 0xcc,
+	0x82, 0x2e, 0xa1, 0x7c, 0xe3, 0x87, 0xde, 0xd0, 0x27, 0xee, 0x5a, 0x49, 0x85, 0xd5, 0x2d, 0x55,
+	0x29, 0x1d, 0x11, 0xc7, 0x5f, 0x2b, 0xa8, 0x98, 0x05, 0xd5, 0x41, 0x6e, 0xb3, 0xc0, 0xf3, 0x87,
+	0x6b, 0xe5, 0x94, 0xb2, 0x9c, 0x08, 0xe4, 0xb8, 0x38, 0xd9, 0x23, 0x80, 0x81, 0x47, 0x46, 0x6e,
+	0x97, 0x4d, 0xc6, 0x24, 0x79, 0x07, 0xbb, 0x69, 0xc5, 0xea, 0x0b, 0xa5, 0x33, 0x19, 0x13, 0x5c,
+	0x1a, 0xa4, 0x23, 0x7a, 0x09, 0x90, 0x94, 0xec, 0x9e, 0x4c, 0xf8, 0xad, 0xca, 0xb8, 0x14, 0x33,
+	0x1f, 0xc9, 0x64, 0xd1, 0x01, 0xee, 0xe5, 0xe5, 0x97, 0x71, 0x0c, 0xcc, 0x72, 0xba, 0x6c, 0x72,
+	0xe3, 0x21, 0xc0, 0xb2, 0xbf, 0xff, 0x68, 0x13, 0x47, 0xdf, 0x04, 0x28, 0xd5, 0x57, 0x22, 0x36,
+	0xea, 0x57, 0xad, 0xf3, 0x8e, 0x92, 0xd3, 0xd0, 0x74, 0x66, 0x94, 0x79, 0x03, 0x97, 0xf2, 0x2b,
+	0xd8, 0xb4, 0x9b, 0x9d, 0x5a, 0xa3, 0x86, 0x15, 0x41, 0xdb, 0x9b, 0xce, 0x0c, 0x25, 0xe9, 0xd6,
+	0xd2, 0x72, 0x08, 0xc5, 0x9b, 0x66, 0xdb, 0x6e, 0x34, 0x6b, 0x17, 0x4a, 0x5e, 0xfb, 0x7f, 0x3a,
+	0x33, 0x76, 0xd3, 0xda, 0x3c, 0xcb, 0xa9, 0xb6, 0x5a, 0x57, 0xb5, 0xf3, 0xa6, 0x22, 0xc6, 0x39,
+	0x49, 0x21, 0x96, 0x96, 0x03, 0x90, 0xda, 0x1d, 0x6c, 0x37, 0x1b, 0x4a, 0x41, 0xfb, 0x6f, 0x3a,
+	0x33, 0x76, 0xe2, 0x9b, 0xce, 0x0c, 0xf1, 0x6b, 0xab, 0xee, 0x3d, 0xfd, 0xd6, 0x73, 0x4f, 0x73,
+	0x5d, 0xf8, 0x31, 0xd7, 0x85, 0x5f, 0x73, 0x5d, 0xf8, 0xfc, 0x47, 0xcf, 0xf5, 0x24, 0xfe, 0xbf,
+	0xf6, 0xee, 0xef, 0x00, 0x99, 0x80, 0x0c, 0x07, 0x1e, 0x05, 0x00, 0x00,
 }
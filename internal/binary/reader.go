@@ -65,10 +65,15 @@ func (r *Reader) ReadHeader() (*Header, error) {
 		return nil, fmt.Errorf("expected header type, got %v", t)
 	}
 	h := &Header{}
-	err = h.Unmarshal(lv)
+	if err = h.Unmarshal(lv); err != nil {
+		return nil, err
+	}
+	if h.Version > Version1 {
+		return nil, fmt.Errorf("unsupported header version %d", h.Version)
+	}
 	*r.state = readBucket
 
-	return h, err
+	return h, nil
 }
 
 func (r *Reader) Close() error {
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/chengshiwen/influx-tool/internal/binary"
+	"github.com/chengshiwen/influx-tool/internal/tlv"
 	"github.com/influxdata/influxdb/models"
 	"github.com/influxdata/influxdb/tsdb"
 	"github.com/influxdata/influxql"
@@ -348,6 +349,55 @@ func TestReader_EmptyBucket(t *testing.T) {
 	assertNil(t, bh)
 }
 
+func TestReader_SeriesHeader(t *testing.T) {
+	var buf bytes.Buffer
+	tags := models.NewTags(map[string]string{"host": "a"})
+	series := []*binary.SeriesInfo{
+		binary.NewSeriesInfo([]byte("cpu"), []byte("idle"), influxql.Float, tags),
+		binary.NewSeriesInfo([]byte("cpu"), []byte("count"), influxql.Integer, tags),
+		binary.NewSeriesInfo([]byte("mem"), []byte("used"), influxql.Integer, models.Tags{}),
+	}
+
+	w := binary.NewWriter(&buf, "db", "rp", time.Hour)
+	w.SetSeries(series)
+	bw, _ := w.NewBucket(0, int64(time.Hour))
+	bw.Close()
+	w.Close()
+
+	r := binary.NewReader(&buf)
+	h, err := r.ReadHeader()
+	assertNoError(t, err)
+	assertEqual(t, h, &binary.Header{
+		Version:         binary.Version1,
+		Database:        "db",
+		RetentionPolicy: "rp",
+		ShardDuration:   time.Hour,
+		Series: []*binary.SeriesInfo{
+			{FieldType: binary.FloatFieldType, SeriesKey: []byte("cpu,host=a"), Field: []byte("idle")},
+			{FieldType: binary.IntegerFieldType, SeriesKey: []byte("cpu,host=a"), Field: []byte("count")},
+			{FieldType: binary.IntegerFieldType, SeriesKey: []byte("mem"), Field: []byte("used")},
+		},
+		SeriesCounts: []int64{1, 2, 0, 0, 0},
+	})
+
+	bh, err := r.NextBucket()
+	assertNoError(t, err)
+	assertEqual(t, bh, &binary.BucketHeader{Start: 0, End: int64(time.Hour)})
+}
+
+func TestReader_UnsupportedVersion(t *testing.T) {
+	var buf bytes.Buffer
+	h := binary.Header{Version: binary.Version1 + 1, Database: "db"}
+	b, _ := h.Marshal()
+	buf.Write(binary.Magic[:])
+	tlv.WriteTLV(&buf, byte(binary.HeaderType), b)
+
+	r := binary.NewReader(&buf)
+	hdr, err := r.ReadHeader()
+	assertError(t, err, fmt.Errorf("unsupported header version %d", 2))
+	assertNil(t, hdr)
+}
+
 func TestReader_States(t *testing.T) {
 	var buf bytes.Buffer
 	r := binary.NewReader(&buf)
@@ -6,10 +6,12 @@ import (
 	"io"
 	"time"
 
+	"github.com/chengshiwen/influx-tool/internal/escape"
 	"github.com/chengshiwen/influx-tool/internal/storage"
 	"github.com/chengshiwen/influx-tool/internal/tlv"
 	"github.com/influxdata/influxdb/models"
 	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 	"github.com/influxdata/influxql"
 )
 
@@ -22,6 +24,7 @@ type Writer struct {
 	bw          *BucketWriter
 	state       writeState
 	wroteHeader bool
+	series      []*SeriesInfo
 
 	msg struct {
 		bucketHeader BucketHeader
@@ -56,6 +59,35 @@ func NewWriter(w io.Writer, db, rp string, sd time.Duration) *Writer {
 	return &Writer{w: wr, db: db, rp: rp, sd: sd}
 }
 
+// NewSeriesInfo returns the series info for the series dictionary of header, or nil if the data type is not supported.
+func NewSeriesInfo(name, field []byte, typ influxql.DataType, tags models.Tags) *SeriesInfo {
+	var ft FieldType
+	switch typ {
+	case influxql.Float:
+		ft = FloatFieldType
+	case influxql.Integer:
+		ft = IntegerFieldType
+	case influxql.Unsigned:
+		ft = UnsignedFieldType
+	case influxql.Boolean:
+		ft = BooleanFieldType
+	case influxql.String:
+		ft = StringFieldType
+	default:
+		return nil
+	}
+	return &SeriesInfo{FieldType: ft, SeriesKey: escape.AppendSeriesKey(nil, name, tags), Field: append([]byte(nil), field...)}
+}
+
+// SetSeries sets the series dictionary of all buckets to be written, which makes the header written in version 1.
+// It must be called before the first bucket, and is intended for the writer of a single bucket.
+func (w *Writer) SetSeries(series []*SeriesInfo) {
+	if w.state != writeHeader {
+		panic(fmt.Sprintf("writer state: got=%v, exp=%v", w.state, writeHeader))
+	}
+	w.series = series
+}
+
 func (w *Writer) WriteStats(o io.Writer) {
 	fmt.Fprintf(o, "total series: %d\n", w.stats.series)
 
@@ -110,6 +142,16 @@ func (w *Writer) writeHeader() {
 		RetentionPolicy: w.rp,
 		ShardDuration:   w.sd,
 	}
+	if w.series != nil {
+		h.Version = Version1
+		h.Series = w.series
+		h.SeriesCounts = make([]int64, 5)
+		for _, s := range w.series {
+			if int(s.FieldType) < len(h.SeriesCounts) {
+				h.SeriesCounts[s.FieldType]++
+			}
+		}
+	}
 	w.writeTypeMessage(HeaderType, &h)
 }
 
@@ -203,7 +245,7 @@ func (bw *BucketWriter) BeginSeries(name, field []byte, typ influxql.DataType, t
 	}
 	bw.w.state = writeSeriesHeader
 
-	bw.key = models.AppendMakeKey(bw.key[:0], name, tags)
+	bw.key = escape.AppendSeriesKey(bw.key[:0], name, tags)
 	bw.field = field
 }
 
@@ -221,139 +263,120 @@ func (bw *BucketWriter) EndSeries() {
 	bw.w.state = writeSeries
 }
 
-func (bw *BucketWriter) WriteIntegerCursor(cur tsdb.IntegerArrayCursor) {
+// beginPoints writes the series header before the first points, and reports whether the points can be written.
+func (bw *BucketWriter) beginPoints(ft FieldType) bool {
 	if bw.hasErr() {
-		return
+		return false
 	}
 
 	if bw.w.state == writeSeriesHeader {
-		bw.w.writeSeriesHeader(bw.key, bw.field, IntegerFieldType)
+		bw.w.writeSeriesHeader(bw.key, bw.field, ft)
 	}
 
 	if bw.w.state != writePoints {
 		panic(fmt.Sprintf("writer state: got=%v, exp=%v", bw.w.state, writePoints))
 	}
+	return true
+}
 
-	var msg IntegerPoints
+func (bw *BucketWriter) WriteIntegerCursor(cur tsdb.IntegerArrayCursor) {
 	for {
 		a := cur.Next()
 		if a.Len() == 0 {
 			break
 		}
-
-		bw.n += a.Len()
-		msg.Timestamps = a.Timestamps
-		msg.Values = a.Values
-		bw.w.writeTypeMessage(IntegerPointsType, &msg)
+		bw.writeIntegerArray(a)
 	}
 }
 
-func (bw *BucketWriter) WriteFloatCursor(cur tsdb.FloatArrayCursor) {
-	if bw.hasErr() {
+func (bw *BucketWriter) writeIntegerArray(a *tsdb.IntegerArray) {
+	if !bw.beginPoints(IntegerFieldType) {
 		return
 	}
 
-	if bw.w.state == writeSeriesHeader {
-		bw.w.writeSeriesHeader(bw.key, bw.field, FloatFieldType)
-	}
-
-	if bw.w.state != writePoints {
-		panic(fmt.Sprintf("writer state: got=%v, exp=%v", bw.w.state, writePoints))
-	}
+	bw.n += a.Len()
+	msg := IntegerPoints{Timestamps: a.Timestamps, Values: a.Values}
+	bw.w.writeTypeMessage(IntegerPointsType, &msg)
+}
 
-	var msg FloatPoints
+func (bw *BucketWriter) WriteFloatCursor(cur tsdb.FloatArrayCursor) {
 	for {
 		a := cur.Next()
 		if a.Len() == 0 {
 			break
 		}
-
-		bw.n += a.Len()
-		msg.Timestamps = a.Timestamps
-		msg.Values = a.Values
-		bw.w.writeTypeMessage(FloatPointsType, &msg)
+		bw.writeFloatArray(a)
 	}
 }
 
-func (bw *BucketWriter) WriteUnsignedCursor(cur tsdb.UnsignedArrayCursor) {
-	if bw.hasErr() {
+func (bw *BucketWriter) writeFloatArray(a *tsdb.FloatArray) {
+	if !bw.beginPoints(FloatFieldType) {
 		return
 	}
 
-	if bw.w.state == writeSeriesHeader {
-		bw.w.writeSeriesHeader(bw.key, bw.field, UnsignedFieldType)
-	}
-
-	if bw.w.state != writePoints {
-		panic(fmt.Sprintf("writer state: got=%v, exp=%v", bw.w.state, writePoints))
-	}
+	bw.n += a.Len()
+	msg := FloatPoints{Timestamps: a.Timestamps, Values: a.Values}
+	bw.w.writeTypeMessage(FloatPointsType, &msg)
+}
 
-	var msg UnsignedPoints
+func (bw *BucketWriter) WriteUnsignedCursor(cur tsdb.UnsignedArrayCursor) {
 	for {
 		a := cur.Next()
 		if a.Len() == 0 {
 			break
 		}
-
-		bw.n += a.Len()
-		msg.Timestamps = a.Timestamps
-		msg.Values = a.Values
-		bw.w.writeTypeMessage(UnsignedPointsType, &msg)
+		bw.writeUnsignedArray(a)
 	}
 }
 
-func (bw *BucketWriter) WriteBooleanCursor(cur tsdb.BooleanArrayCursor) {
-	if bw.hasErr() {
+func (bw *BucketWriter) writeUnsignedArray(a *tsdb.UnsignedArray) {
+	if !bw.beginPoints(UnsignedFieldType) {
 		return
 	}
 
-	if bw.w.state == writeSeriesHeader {
-		bw.w.writeSeriesHeader(bw.key, bw.field, BooleanFieldType)
-	}
-
-	if bw.w.state != writePoints {
-		panic(fmt.Sprintf("writer state: got=%v, exp=%v", bw.w.state, writePoints))
-	}
+	bw.n += a.Len()
+	msg := UnsignedPoints{Timestamps: a.Timestamps, Values: a.Values}
+	bw.w.writeTypeMessage(UnsignedPointsType, &msg)
+}
 
-	var msg BooleanPoints
+func (bw *BucketWriter) WriteBooleanCursor(cur tsdb.BooleanArrayCursor) {
 	for {
 		a := cur.Next()
 		if a.Len() == 0 {
 			break
 		}
-
-		bw.n += a.Len()
-		msg.Timestamps = a.Timestamps
-		msg.Values = a.Values
-		bw.w.writeTypeMessage(BooleanPointsType, &msg)
+		bw.writeBooleanArray(a)
 	}
 }
 
-func (bw *BucketWriter) WriteStringCursor(cur tsdb.StringArrayCursor) {
-	if bw.hasErr() {
+func (bw *BucketWriter) writeBooleanArray(a *tsdb.BooleanArray) {
+	if !bw.beginPoints(BooleanFieldType) {
 		return
 	}
 
-	if bw.w.state == writeSeriesHeader {
-		bw.w.writeSeriesHeader(bw.key, bw.field, StringFieldType)
-	}
-
-	if bw.w.state != writePoints {
-		panic(fmt.Sprintf("writer state: got=%v, exp=%v", bw.w.state, writePoints))
-	}
+	bw.n += a.Len()
+	msg := BooleanPoints{Timestamps: a.Timestamps, Values: a.Values}
+	bw.w.writeTypeMessage(BooleanPointsType, &msg)
+}
 
-	var msg StringPoints
+func (bw *BucketWriter) WriteStringCursor(cur tsdb.StringArrayCursor) {
 	for {
 		a := cur.Next()
 		if a.Len() == 0 {
 			break
 		}
+		bw.writeStringArray(a)
+	}
+}
 
-		bw.n += a.Len()
-		msg.Timestamps = a.Timestamps
-		msg.Values = a.Values
-		bw.w.writeTypeMessage(StringPointsType, &msg)
+func (bw *BucketWriter) writeStringArray(a *tsdb.StringArray) {
+	if !bw.beginPoints(StringFieldType) {
+		return
 	}
+
+	bw.n += a.Len()
+	msg := StringPoints{Timestamps: a.Timestamps, Values: a.Values}
+	bw.w.writeTypeMessage(StringPointsType, &msg)
 }
 
 func (bw *BucketWriter) Close() error {
@@ -407,3 +430,141 @@ func (bw *BucketWriter) WriteSeries(name []byte, field []byte, fieldType influxq
 
 	return nil
 }
+
+// FloatFilter filters the float values of an array in place before they are written, such as the non-finite ones.
+type FloatFilter func(a *tsdb.FloatArray)
+
+// ArrayFilter filters the values of the arrays in place before they are written, such as by a transform, an error
+// stops writing the series.
+type ArrayFilter interface {
+	FilterFloats(a *tsdb.FloatArray) error
+	FilterIntegers(a *tsdb.IntegerArray) error
+	FilterUnsigneds(a *tsdb.UnsignedArray) error
+	FilterBooleans(a *tsdb.BooleanArray) error
+	FilterStrings(a *tsdb.StringArray) error
+}
+
+// WriteValuesTo writes the values of the series to all the bucket writers, so that a series written to several nodes,
+// such as a node of each circle, is read once for all of them. The values are written by arrays of at most the default
+// points per block, and the values of other types than the field type are skipped. The float values are filtered by
+// ff first if not nil, then the values of every type by af if not nil.
+func WriteValuesTo(bws []*BucketWriter, name []byte, field []byte, fieldType influxql.DataType, tags models.Tags, values []tsm1.Value, ff FloatFilter, af ArrayFilter) error {
+	for _, bw := range bws {
+		bw.BeginSeries(name, field, fieldType, tags)
+	}
+
+	for len(values) > 0 {
+		n := len(values)
+		if n > tsdb.DefaultMaxPointsPerBlock {
+			n = tsdb.DefaultMaxPointsPerBlock
+		}
+		if err := writeValuesTo(bws, fieldType, values[:n], ff, af); err != nil {
+			return err
+		}
+		values = values[n:]
+	}
+
+	for _, bw := range bws {
+		bw.EndSeries()
+	}
+	for _, bw := range bws {
+		if err := bw.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeValuesTo writes the values of the field type as an array to all the bucket writers, the array filtered empty
+// is not written.
+func writeValuesTo(bws []*BucketWriter, fieldType influxql.DataType, values []tsm1.Value, ff FloatFilter, af ArrayFilter) error {
+	switch fieldType {
+	case influxql.Integer:
+		a := &tsdb.IntegerArray{}
+		a.Timestamps, a.Values = appendArray(a.Timestamps, a.Values, values)
+		if af != nil {
+			if err := af.FilterIntegers(a); err != nil {
+				return err
+			}
+		}
+		if a.Len() == 0 {
+			return nil
+		}
+		for _, bw := range bws {
+			bw.writeIntegerArray(a)
+		}
+	case influxql.Float:
+		a := &tsdb.FloatArray{}
+		a.Timestamps, a.Values = appendArray(a.Timestamps, a.Values, values)
+		if ff != nil {
+			ff(a)
+		}
+		if af != nil {
+			if err := af.FilterFloats(a); err != nil {
+				return err
+			}
+		}
+		if a.Len() == 0 {
+			return nil
+		}
+		for _, bw := range bws {
+			bw.writeFloatArray(a)
+		}
+	case influxql.Unsigned:
+		a := &tsdb.UnsignedArray{}
+		a.Timestamps, a.Values = appendArray(a.Timestamps, a.Values, values)
+		if af != nil {
+			if err := af.FilterUnsigneds(a); err != nil {
+				return err
+			}
+		}
+		if a.Len() == 0 {
+			return nil
+		}
+		for _, bw := range bws {
+			bw.writeUnsignedArray(a)
+		}
+	case influxql.Boolean:
+		a := &tsdb.BooleanArray{}
+		a.Timestamps, a.Values = appendArray(a.Timestamps, a.Values, values)
+		if af != nil {
+			if err := af.FilterBooleans(a); err != nil {
+				return err
+			}
+		}
+		if a.Len() == 0 {
+			return nil
+		}
+		for _, bw := range bws {
+			bw.writeBooleanArray(a)
+		}
+	case influxql.String:
+		a := &tsdb.StringArray{}
+		a.Timestamps, a.Values = appendArray(a.Timestamps, a.Values, values)
+		if af != nil {
+			if err := af.FilterStrings(a); err != nil {
+				return err
+			}
+		}
+		if a.Len() == 0 {
+			return nil
+		}
+		for _, bw := range bws {
+			bw.writeStringArray(a)
+		}
+	default:
+		return fmt.Errorf("unsupported field type: %s", fieldType)
+	}
+	return nil
+}
+
+// appendArray appends the values of type T and their timestamps, the values of other types are skipped.
+func appendArray[T any](timestamps []int64, vs []T, values []tsm1.Value) ([]int64, []T) {
+	for _, v := range values {
+		if x, ok := v.Value().(T); ok {
+			timestamps = append(timestamps, v.UnixNano())
+			vs = append(vs, x)
+		}
+	}
+	return timestamps, vs
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/influxdata/influxdb/models"
 	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
 	"github.com/influxdata/influxql"
 )
 
@@ -66,6 +67,44 @@ func TestWriter_WriteOneBucketOneSeries(t *testing.T) {
 	assertTypeValue(t, &buf, binary.BucketFooterType, &bf)
 }
 
+func TestWriter_WriteValuesTo(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	var bws []*binary.BucketWriter
+	var ws []*binary.Writer
+	for _, buf := range []*bytes.Buffer{&buf1, &buf2} {
+		w := binary.NewWriter(buf, "db", "rp", time.Second)
+		bw, _ := w.NewBucket(0, int64(time.Second))
+		ws, bws = append(ws, w), append(bws, bw)
+	}
+	// the integer value is skipped from the float series, and the float values are filtered
+	values := []tsm1.Value{tsm1.NewFloatValue(0, 1.5), tsm1.NewIntegerValue(1, 2), tsm1.NewFloatValue(2, -1)}
+	ff := func(a *tsdb.FloatArray) {
+		a.Timestamps, a.Values = a.Timestamps[:1], a.Values[:1]
+	}
+	err := binary.WriteValuesTo(bws, []byte("cpu"), []byte("idle"), influxql.Float, models.NewTags(map[string]string{"host": "host1"}), values, ff, nil)
+	assertNoError(t, err)
+	for i := range ws {
+		bws[i].Close()
+		ws[i].Close()
+	}
+
+	for _, buf := range []*bytes.Buffer{&buf1, &buf2} {
+		var in [8]byte
+		buf.Read(in[:])
+		assertEqual(t, in[:], binary.Magic[:])
+		assertTypeValue(t, buf, binary.HeaderType, &binary.Header{})
+		assertTypeValue(t, buf, binary.BucketHeaderType, &binary.BucketHeader{})
+		var sh binary.SeriesHeader
+		assertTypeValue(t, buf, binary.SeriesHeaderType, &sh)
+		assertEqual(t, sh, binary.SeriesHeader{FieldType: binary.FloatFieldType, SeriesKey: []byte("cpu,host=host1"), Field: []byte("idle")})
+		var fp binary.FloatPoints
+		assertTypeValue(t, buf, binary.FloatPointsType, &fp)
+		assertEqual(t, fp, binary.FloatPoints{Timestamps: []int64{0}, Values: []float64{1.5}})
+		assertTypeValue(t, buf, binary.SeriesFooterType, &binary.SeriesFooter{})
+		assertTypeValue(t, buf, binary.BucketFooterType, &binary.BucketFooter{})
+	}
+}
+
 type intCursor struct {
 	c    int // number of values to return per call to Next
 	keys []int64
@@ -0,0 +1,216 @@
+// Package blocks reads and writes a portable container of compressed tsm blocks, which copies the shards of
+// tsm files between servers without decoding and encoding the values.
+//
+// The container starts with a magic number and a version, followed by type-length-value records. A shard
+// record starts the tsm files of a shard, a file record starts the blocks of a tsm file with its time range,
+// and the block records of a file are sorted by key and time like the tsm file they are read from.
+package blocks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/chengshiwen/influx-tool/internal/tlv"
+)
+
+// Magic is the magic bytes at the start of a container.
+const Magic = "ITSMBLK"
+
+// Version is the version of the container format.
+const Version byte = 1
+
+// RecordType is the type of a record in a container.
+type RecordType byte
+
+const (
+	ShardRecord RecordType = iota + 1
+	FileRecord
+	BlockRecord
+)
+
+// Record is a record read from a container, the fields are only set for its type.
+type Record struct {
+	Type RecordType
+
+	// shard record
+	Database        string
+	RetentionPolicy string
+	ShardID         uint64
+
+	// file and block record
+	MinTime int64
+	MaxTime int64
+
+	// block record
+	Key   []byte
+	Block []byte
+}
+
+// Writer writes the records of a container.
+type Writer struct {
+	w   *bufio.Writer
+	buf []byte
+	err error
+}
+
+// NewWriter returns a writer writing the magic number and version to w.
+func NewWriter(w io.Writer) *Writer {
+	var bw *bufio.Writer
+	if bw, _ = w.(*bufio.Writer); bw == nil {
+		bw = bufio.NewWriter(w)
+	}
+	wr := &Writer{w: bw}
+	_, wr.err = bw.Write(append([]byte(Magic), Version))
+	return wr
+}
+
+// WriteShard starts the tsm files of a shard.
+func (w *Writer) WriteShard(db, rp string, id uint64) error {
+	w.buf = appendString(w.buf[:0], db)
+	w.buf = appendString(w.buf, rp)
+	w.buf = binary.AppendUvarint(w.buf, id)
+	return w.write(ShardRecord)
+}
+
+// WriteFile starts the blocks of a tsm file within the time range [minTime, maxTime].
+func (w *Writer) WriteFile(minTime, maxTime int64) error {
+	w.buf = binary.AppendVarint(w.buf[:0], minTime)
+	w.buf = binary.AppendVarint(w.buf, maxTime)
+	return w.write(FileRecord)
+}
+
+// WriteBlock writes a block of the composite key, the block is the encoded values without checksum.
+func (w *Writer) WriteBlock(key []byte, minTime, maxTime int64, block []byte) error {
+	w.buf = binary.AppendUvarint(w.buf[:0], uint64(len(key)))
+	w.buf = append(w.buf, key...)
+	w.buf = binary.AppendVarint(w.buf, minTime)
+	w.buf = binary.AppendVarint(w.buf, maxTime)
+	w.buf = append(w.buf, block...)
+	return w.write(BlockRecord)
+}
+
+// Flush flushes the buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+func (w *Writer) write(typ RecordType) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = tlv.WriteTLV(w.w, byte(typ), w.buf)
+	return w.err
+}
+
+// Reader reads the records of a container.
+type Reader struct {
+	r   *bufio.Reader
+	rec Record
+}
+
+// NewReader returns a reader of r, or an error if r is not a container of a supported version.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(Magic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("read header: %v", err)
+	}
+	if !bytes.Equal(header[:len(Magic)], []byte(Magic)) {
+		return nil, errors.New("invalid magic number, not a tsm blocks container")
+	}
+	if header[len(Magic)] != Version {
+		return nil, fmt.Errorf("unsupported tsm blocks container version %d", header[len(Magic)])
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF at the end. The record and its bytes are only valid until the next call.
+func (r *Reader) Next() (*Record, error) {
+	typ, buf, err := tlv.ReadTLV(r.r)
+	if err != nil {
+		return nil, err
+	}
+	rec := &r.rec
+	*rec = Record{Type: RecordType(typ)}
+	d := decoder{buf: buf}
+	switch rec.Type {
+	case ShardRecord:
+		rec.Database = string(d.bytes())
+		rec.RetentionPolicy = string(d.bytes())
+		rec.ShardID = d.uvarint()
+	case FileRecord:
+		rec.MinTime = d.varint()
+		rec.MaxTime = d.varint()
+	case BlockRecord:
+		rec.Key = d.bytes()
+		rec.MinTime = d.varint()
+		rec.MaxTime = d.varint()
+		rec.Block = d.buf
+	default:
+		return nil, fmt.Errorf("unknown record type %d", typ)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("read record type %d: %v", typ, d.err)
+	}
+	return rec, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder decodes the fields of a record, the first error is kept and the later fields are zero.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+var errShortRecord = errors.New("record too short")
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errShortRecord
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errShortRecord
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errShortRecord
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
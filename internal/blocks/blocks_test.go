@@ -0,0 +1,62 @@
+package blocks
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.WriteShard("db0", "autogen", 12); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFile(-10, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteBlock([]byte("cpu,host=a#!~#value"), -10, 20, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Type != ShardRecord || rec.Database != "db0" || rec.RetentionPolicy != "autogen" || rec.ShardID != 12 {
+		t.Fatalf("unexpected shard record: %+v", rec)
+	}
+	rec, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Type != FileRecord || rec.MinTime != -10 || rec.MaxTime != 20 {
+		t.Fatalf("unexpected file record: %+v", rec)
+	}
+	rec, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Type != BlockRecord || string(rec.Key) != "cpu,host=a#!~#value" || rec.MinTime != -10 || rec.MaxTime != 20 || !bytes.Equal(rec.Block, []byte{1, 2, 3}) {
+		t.Fatalf("unexpected block record: %+v", rec)
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestNewReaderInvalid(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("# INFLUXDB EXPORT"))); err == nil {
+		t.Fatal("expected error for line protocol export")
+	}
+	if _, err := NewReader(bytes.NewReader([]byte(Magic + "\x09"))); err == nil {
+		t.Fatal("expected error for unsupported version")
+	}
+}
@@ -0,0 +1,183 @@
+// Package empty handles the empty tag keys, tag values and field names of source data, which influxdb rejects
+// when the data is written to a target.
+package empty
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+)
+
+// Modes of handling the empty tag keys, tag values and field names.
+const (
+	ModeKeep        = "keep"
+	ModeDropTag     = "drop-tag"
+	ModeDropPoint   = "drop-point"
+	ModePlaceholder = "placeholder"
+)
+
+// Result is what has been done to a series by Handle.
+type Result int
+
+const (
+	None Result = iota // nothing empty
+	Kept
+	TagDropped
+	PointDropped
+	Substituted
+)
+
+// ValidMode returns whether mode is one of the modes.
+func ValidMode(mode string) bool {
+	return mode == ModeKeep || mode == ModeDropTag || mode == ModeDropPoint || mode == ModePlaceholder
+}
+
+// Handler handles the empty tag keys, tag values and field names by mode. In drop-tag mode, the tags of empty key
+// or value are dropped, and the points of an empty field name are dropped since there is no tag to drop. A Handler
+// counts the results and is safe for concurrent use, a nil Handler counts nothing.
+type Handler struct {
+	mode        string
+	placeholder []byte
+	counts      [Substituted + 1]atomic.Int64
+}
+
+// NewHandler returns a handler of mode, which substitutes placeholder for the empty ones in placeholder mode.
+func NewHandler(mode, placeholder string) *Handler {
+	return &Handler{mode: mode, placeholder: []byte(placeholder)}
+}
+
+// Empty returns whether any tag key, tag value or the field name is empty.
+func Empty(tags models.Tags, field []byte) bool {
+	if len(field) == 0 {
+		return true
+	}
+	for i := range tags {
+		if len(tags[i].Key) == 0 || len(tags[i].Value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseKey parses the series key into the measurement and tags like models.ParseKeyBytes, but keeps the tags of
+// empty keys or values, which models.ParseKeyBytes drops.
+func ParseKey(key []byte) ([]byte, models.Tags) {
+	parts := splitUnescaped(key, ',', -1)
+	name := escape.Unescape(parts[0])
+	var tags models.Tags
+	for _, part := range parts[1:] {
+		kv := splitUnescaped(part, '=', 2)
+		t := models.Tag{Key: escape.Unescape(kv[0])}
+		if len(kv) > 1 {
+			t.Value = escape.Unescape(kv[1])
+		}
+		tags = append(tags, t)
+	}
+	return name, tags
+}
+
+// splitUnescaped splits b by the separator not escaped by a backslash into n parts at most, or all if n < 0.
+func splitUnescaped(b []byte, sep byte, n int) [][]byte {
+	var parts [][]byte
+	start := 0
+	for i := 0; i < len(b) && n != len(parts)+1; i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, b[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, b[start:])
+}
+
+// Handle returns the tags and field of a series handled, the tags are copied if changed. The points of the series
+// should be skipped if the result is PointDropped.
+func (h *Handler) Handle(tags models.Tags, field []byte) (models.Tags, []byte, Result) {
+	if !Empty(tags, field) {
+		return tags, field, None
+	}
+	r := h.handle(field)
+	switch r {
+	case TagDropped:
+		fixed := make(models.Tags, 0, len(tags))
+		for _, t := range tags {
+			if len(t.Key) > 0 && len(t.Value) > 0 {
+				fixed = append(fixed, t)
+			}
+		}
+		return fixed, field, r
+	case Substituted:
+		fixed := tags.Clone()
+		for i := range fixed {
+			if len(fixed[i].Key) == 0 {
+				fixed[i].Key = h.placeholder
+			}
+			if len(fixed[i].Value) == 0 {
+				fixed[i].Value = h.placeholder
+			}
+		}
+		// a substituted key may be out of order
+		sort.Sort(fixed)
+		if len(field) == 0 {
+			field = h.placeholder
+		}
+		return fixed, field, r
+	}
+	return tags, field, r
+}
+
+func (h *Handler) handle(field []byte) Result {
+	switch h.mode {
+	case ModeDropTag:
+		if len(field) == 0 {
+			return PointDropped
+		}
+		return TagDropped
+	case ModeDropPoint:
+		return PointDropped
+	case ModePlaceholder:
+		return Substituted
+	}
+	return Kept
+}
+
+// Add counts n points or series for the result.
+func (h *Handler) Add(r Result, n int) {
+	if h != nil && r != None {
+		h.counts[r].Add(int64(n))
+	}
+}
+
+// Count returns the number counted for the result.
+func (h *Handler) Count(r Result) int64 {
+	if h == nil {
+		return 0
+	}
+	return h.counts[r].Load()
+}
+
+// Report returns the counts of the results in unit like "points", or an empty string if nothing is empty.
+func (h *Handler) Report(unit string) string {
+	var buf bytes.Buffer
+	for _, c := range []struct {
+		r    Result
+		verb string
+	}{{Kept, "kept"}, {TagDropped, "with empty tags dropped"}, {PointDropped, "dropped"}, {Substituted, "substituted"}} {
+		if n := h.Count(c.r); n > 0 {
+			if buf.Len() > 0 {
+				buf.WriteString(", ")
+			}
+			fmt.Fprintf(&buf, "%d %s", n, c.verb)
+		}
+	}
+	if buf.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s with empty tag keys, tag values or field names: %s", unit, buf.String())
+}
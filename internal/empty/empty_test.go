@@ -0,0 +1,66 @@
+package empty
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+func TestHandler(t *testing.T) {
+	tags := models.Tags{{Key: []byte(""), Value: []byte("x")}, {Key: []byte("host"), Value: []byte("a")}, {Key: []byte("zone"), Value: []byte("")}}
+	tests := []struct {
+		mode   string
+		tags   models.Tags
+		field  string
+		result Result
+		key    string
+	}{
+		{ModeKeep, models.NewTags(map[string]string{"host": "a"}), "v", None, "cpu,host=a v"},
+		{ModeKeep, tags, "v", Kept, "cpu,=x,host=a v"},
+		{ModeDropTag, tags, "v", TagDropped, "cpu,host=a v"},
+		{ModeDropTag, tags, "", PointDropped, ""},
+		{ModeDropPoint, tags, "v", PointDropped, ""},
+		{ModePlaceholder, tags, "", Substituted, "cpu,_=x,host=a,zone=_ _"},
+		{ModePlaceholder, models.NewTags(map[string]string{"host": "a"}), "", Substituted, "cpu,host=a _"},
+	}
+	for _, tt := range tests {
+		h := NewHandler(tt.mode, "_")
+		fixed, field, r := h.Handle(tt.tags, []byte(tt.field))
+		h.Add(r, 2)
+		if r != tt.result {
+			t.Errorf("%s %s: got result %d, exp %d", tt.mode, tt.tags, r, tt.result)
+			continue
+		}
+		if r != PointDropped {
+			if key := string(models.MakeKey([]byte("cpu"), fixed)) + " " + string(field); key != tt.key {
+				t.Errorf("%s %s: got %s, exp %s", tt.mode, tt.tags, key, tt.key)
+			}
+		}
+		if r != None && (h.Count(r) != 2 || h.Report("points") == "") {
+			t.Errorf("%s %s: count %d, report %q", tt.mode, tt.tags, h.Count(r), h.Report("points"))
+		}
+	}
+	if string(tags[0].Key) != "" || string(tags[2].Value) != "" {
+		t.Errorf("tags handled in place: %s", tags)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for key, exp := range map[string]string{
+		"cpu":                     "cpu []",
+		"cpu,host=a":              "cpu [host=a]",
+		"cpu,host=":               "cpu [host=]",
+		"cpu,=a,host=":            "cpu [=a host=]",
+		`c\,pu,h\=ost=a\,b\ c,z=`: "c,pu [h=ost=a,b c z=]",
+	} {
+		name, tags := ParseKey([]byte(key))
+		var strs []string
+		for _, t := range tags {
+			strs = append(strs, string(t.Key)+"="+string(t.Value))
+		}
+		if got := fmt.Sprintf("%s %v", name, strs); got != exp {
+			t.Errorf("%s: got %s, exp %s", key, got, exp)
+		}
+	}
+}
@@ -37,3 +37,49 @@ func NeedTagsEscape(tags models.Tags) bool {
 func NeedEscape(name []byte, tags models.Tags) bool {
 	return NeedMeasurementEscape(name) || NeedTagsEscape(tags)
 }
+
+// Measurement returns the measurement name escaped for series keys and line protocol, or name itself if nothing
+// needs escaping. Unlike models.EscapeMeasurement, name is taken as stored without unescaping it first.
+func Measurement(name []byte) []byte {
+	return escapeBytes(name, measurementEscapeCodes[:])
+}
+
+// Tag returns the tag key or value escaped for series keys and line protocol, or b itself if nothing needs escaping.
+func Tag(b []byte) []byte {
+	return escapeBytes(b, tagEscapeCodes[:])
+}
+
+// AppendSeriesKey appends the series key of the measurement and the sorted tags to dst with the bytes escaped, so
+// that the key is parsed back into the same name and tags, which repairs the series NeedEscape reports instead of
+// discarding them. The tags of empty values are skipped as models.MakeKey does.
+func AppendSeriesKey(dst, name []byte, tags models.Tags) []byte {
+	dst = appendEscaped(dst, name, measurementEscapeCodes[:])
+	for i := range tags {
+		t := &tags[i]
+		if len(t.Value) == 0 {
+			continue
+		}
+		dst = append(dst, ',')
+		dst = appendEscaped(dst, t.Key, tagEscapeCodes[:])
+		dst = append(dst, '=')
+		dst = appendEscaped(dst, t.Value, tagEscapeCodes[:])
+	}
+	return dst
+}
+
+func escapeBytes(b, codes []byte) []byte {
+	if bytes.IndexAny(b, string(codes)) == -1 {
+		return b
+	}
+	return appendEscaped(make([]byte, 0, len(b)+4), b, codes)
+}
+
+func appendEscaped(dst, b, codes []byte) []byte {
+	for _, c := range b {
+		if bytes.IndexByte(codes, c) != -1 {
+			dst = append(dst, '\\')
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
@@ -0,0 +1,54 @@
+package escape
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+func TestAppendSeriesKey(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		key  string
+	}{
+		{"cpu", map[string]string{"host": "a"}, "cpu,host=a"},
+		{"cpu", nil, "cpu"},
+		{"cpu load", map[string]string{"host": "a"}, `cpu\ load,host=a`},
+		{"cpu,load", map[string]string{"host": "a"}, `cpu\,load,host=a`},
+		{"cpu=load", map[string]string{"host": "a"}, `cpu=load,host=a`},
+		{"cpu", map[string]string{"host name": "a b"}, `cpu,host\ name=a\ b`},
+		{"cpu", map[string]string{"host,name": "a,b"}, `cpu,host\,name=a\,b`},
+		{"cpu", map[string]string{"host=name": "a=b"}, `cpu,host\=name=a\=b`},
+		{"cpu", map[string]string{"a": "", "b": "1"}, "cpu,b=1"},
+	}
+	for _, tt := range tests {
+		name, tags := []byte(tt.name), models.NewTags(tt.tags)
+		key := AppendSeriesKey(nil, name, tags)
+		if string(key) != tt.key {
+			t.Errorf("%s %v: got key %s, exp %s", tt.name, tt.tags, key, tt.key)
+			continue
+		}
+		if NeedEscape(name, tags) {
+			pname, ptags := models.ParseKeyBytes(key)
+			if !bytes.Equal(pname, name) || !ptags.Equal(tags) {
+				t.Errorf("%s: parsed back into %s %s", key, pname, ptags)
+			}
+		} else if exp := models.MakeKey(name, tags); !bytes.Equal(key, exp) {
+			t.Errorf("%s: differs from models.MakeKey %s", key, exp)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if b := []byte("cpu"); &Measurement(b)[0] != &b[0] {
+		t.Error("measurement not needing escaping is copied")
+	}
+	if got := string(Measurement([]byte("a b,c=d"))); got != `a\ b\,c=d` {
+		t.Errorf("measurement: got %s", got)
+	}
+	if got := string(Tag([]byte("a b,c=d"))); got != `a\ b\,c\=d` {
+		t.Errorf("tag: got %s", got)
+	}
+}
@@ -0,0 +1,102 @@
+// Package events appends the significant events of a long run to a file as json lines, so that external dashboards
+// and post-mortem analysis need not parse the logs.
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Types of the events.
+const (
+	TypeStart           = "start"
+	TypeFinish          = "finish"
+	TypeError           = "error"
+	TypeShardGroupStart = "shard-group-start"
+	TypeShardGroupDone  = "shard-group-done"
+	TypeShardGroupSkip  = "shard-group-skip"
+	TypeCheckpoint      = "checkpoint"
+)
+
+// Event is a line of the events file, the fields irrelevant to the type are omitted.
+type Event struct {
+	Time            time.Time  `json:"time"`
+	Type            string     `json:"type"`
+	Command         string     `json:"command,omitempty"`
+	Database        string     `json:"database,omitempty"`
+	RetentionPolicy string     `json:"retention_policy,omitempty"`
+	ShardGroup      *uint64    `json:"shard_group,omitempty"`
+	Node            string     `json:"node,omitempty"`
+	Start           *time.Time `json:"start,omitempty"`
+	End             *time.Time `json:"end,omitempty"`
+	Elapsed         float64    `json:"elapsed,omitempty"` // seconds since the start event
+	Message         string     `json:"message,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// Log appends the events to a file, it is safe for concurrent use and a nil Log drops the events.
+type Log struct {
+	mu      sync.Mutex
+	f       *os.File
+	enc     *json.Encoder
+	start   time.Time
+	command string
+	err     error // first error of writing
+}
+
+// Open opens the events file of path for appending and writes the start event of command, a nil Log is returned if
+// path is empty.
+func Open(path, command string) (*Log, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{f: f, enc: json.NewEncoder(f), start: time.Now(), command: command}
+	l.Emit(Event{Type: TypeStart, Command: command})
+	return l, nil
+}
+
+// Emit appends the event stamped with the current time and the elapsed seconds.
+func (l *Log) Emit(e Event) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	e.Time = now.UTC()
+	e.Elapsed = now.Sub(l.start).Seconds()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(&e); err != nil && l.err == nil {
+		l.err = err
+	}
+}
+
+// Finish appends the finish event, or the error event if err is not nil, then closes the file. The first error of
+// writing the events is returned.
+func (l *Log) Finish(err error) error {
+	if l == nil {
+		return nil
+	}
+	if err != nil {
+		l.Emit(Event{Type: TypeError, Command: l.command, Error: err.Error()})
+	} else {
+		l.Emit(Event{Type: TypeFinish, Command: l.command})
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if cerr := l.f.Close(); l.err == nil {
+		l.err = cerr
+	}
+	return l.err
+}
+
+// Range returns the pointers of the start and end times for the event.
+func Range(start, end time.Time) (*time.Time, *time.Time) {
+	s, e := start.UTC(), end.UTC()
+	return &s, &e
+}
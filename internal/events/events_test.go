@@ -0,0 +1,65 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	var l *Log
+	l.Emit(Event{Type: TypeCheckpoint})
+	if err := l.Finish(nil); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := Open("", "influx-tool transfer"); l != nil || err != nil {
+		t.Fatalf("unexpected log %v, error %v", l, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	for _, runErr := range []error{nil, errors.New("disk full")} {
+		l, err := Open(path, "influx-tool transfer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := uint64(0)
+		start, end := Range(time.Unix(0, 0), time.Unix(3600, 0))
+		l.Emit(Event{Type: TypeShardGroupDone, Database: "db", RetentionPolicy: "autogen", ShardGroup: &id, Start: start, End: end})
+		if err := l.Finish(runErr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []Event
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	types := []string{TypeStart, TypeShardGroupDone, TypeFinish, TypeStart, TypeShardGroupDone, TypeError}
+	if len(got) != len(types) {
+		t.Fatalf("got %d events, expect %d", len(got), len(types))
+	}
+	for i, e := range got {
+		if e.Type != types[i] || e.Time.IsZero() {
+			t.Errorf("event %d: unexpected %+v", i, e)
+		}
+	}
+	if e := got[1]; e.ShardGroup == nil || *e.ShardGroup != 0 || e.End == nil || !e.End.Equal(time.Unix(3600, 0)) {
+		t.Errorf("unexpected shard group event: %+v", e)
+	}
+	if e := got[5]; e.Command != "influx-tool transfer" || e.Error != "disk full" {
+		t.Errorf("unexpected error event: %+v", e)
+	}
+}
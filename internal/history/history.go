@@ -0,0 +1,211 @@
+// Package history records the statistics of runs into a local file, and estimates the duration of later runs
+// of the same command by the throughput learned from them.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+const (
+	// DisabledMark disables the history file.
+	DisabledMark = "-"
+
+	maxRuns = 10 // recent runs to learn the throughput from
+)
+
+// Record is the statistics of a run.
+type Record struct {
+	Command  string        `json:"command"`
+	Time     time.Time     `json:"time"`
+	Bytes    int64         `json:"bytes"`  // size of the source data read
+	Series   int64         `json:"series"` // series fields read, counted once per shard
+	Duration time.Duration `json:"duration"`
+	Host     string        `json:"host"`
+	CPUs     int           `json:"cpus"`
+	OS       string        `json:"os"`
+	Arch     string        `json:"arch"`
+}
+
+// NewRecord returns the record of a run of command, with the characteristics of this host.
+func NewRecord(command string, bytes, series int64, duration time.Duration) Record {
+	host, _ := os.Hostname()
+	return Record{
+		Command:  command,
+		Time:     time.Now().UTC(),
+		Bytes:    bytes,
+		Series:   series,
+		Duration: duration,
+		Host:     host,
+		CPUs:     runtime.NumCPU(),
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+	}
+}
+
+// Path returns the history file of path, the default file in the home directory if empty, or empty if disabled.
+func Path(path string) string {
+	if path == DisabledMark {
+		return ""
+	}
+	if path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".influx-tool", "history.jsonl")
+}
+
+// Load returns the records of command in the history file, which is empty if the file doesn't exist.
+// Malformed lines are skipped, so that a truncated write doesn't break the later runs.
+func Load(path, command string) ([]Record, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if json.Unmarshal(scanner.Bytes(), &r) == nil && r.Command == command {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Append appends the record to the history file, creating it if needed.
+func Append(path string, r Record) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Estimator estimates the duration of a run by the throughput of the recent runs, preferring the runs on this host.
+type Estimator struct {
+	rate float64 // bytes per second, 0 if unknown
+	runs int
+}
+
+func NewEstimator(records []Record) *Estimator {
+	host, _ := os.Hostname()
+	var local []Record
+	for _, r := range records {
+		if r.Host == host {
+			local = append(local, r)
+		}
+	}
+	if len(local) > 0 {
+		records = local
+	}
+	if len(records) > maxRuns {
+		records = records[len(records)-maxRuns:]
+	}
+
+	e := &Estimator{}
+	var bytes int64
+	var seconds float64
+	for _, r := range records {
+		if r.Bytes > 0 && r.Duration > 0 {
+			bytes += r.Bytes
+			seconds += r.Duration.Seconds()
+			e.runs++
+		}
+	}
+	if seconds > 0 {
+		e.rate = float64(bytes) / seconds
+	}
+	return e
+}
+
+// Estimate returns the estimated duration of reading bytes, false if no run is learned.
+func (e *Estimator) Estimate(bytes int64) (time.Duration, bool) {
+	if e.rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(bytes) / e.rate * float64(time.Second)), true
+}
+
+// Remaining returns the estimated remaining duration after done of total bytes are read in elapsed. The rate learned
+// is blended with the rate of this run in proportion to the progress, false if neither is known.
+func (e *Estimator) Remaining(done, total int64, elapsed time.Duration) (time.Duration, bool) {
+	if total <= 0 {
+		return 0, false
+	}
+	if done >= total {
+		return 0, true
+	}
+	rate := e.rate
+	if done > 0 && elapsed > 0 {
+		current := float64(done) / elapsed.Seconds()
+		if rate <= 0 {
+			rate = current
+		} else {
+			p := float64(done) / float64(total)
+			rate = rate*(1-p) + current*p
+		}
+	}
+	if rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(total-done) / rate * float64(time.Second)), true
+}
+
+// String describes the estimate of reading bytes.
+func (e *Estimator) String(bytes int64) string {
+	d, ok := e.Estimate(bytes)
+	if !ok {
+		return fmt.Sprintf("%s to read, no previous run to estimate the duration", FormatBytes(bytes))
+	}
+	return fmt.Sprintf("%s to read, estimated duration %s by %d previous runs at %s/s", FormatBytes(bytes), d.Round(time.Second), e.runs, FormatBytes(int64(e.rate)))
+}
+
+// Progress describes the progress after done of total bytes are read in elapsed.
+func (e *Estimator) Progress(done, total int64, elapsed time.Duration) string {
+	if total <= 0 {
+		return "progress unknown"
+	}
+	s := fmt.Sprintf("progress %.1f%%", float64(done)*100/float64(total))
+	if d, ok := e.Remaining(done, total, elapsed); ok && done < total {
+		s += fmt.Sprintf(", eta %s", d.Round(time.Second))
+	}
+	return s
+}
+
+// FormatBytes formats bytes in binary units.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,68 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "history.jsonl")
+	records, err := Load(path, "export line")
+	if err != nil || len(records) != 0 {
+		t.Fatalf("unexpected records of missing file: %v, %v", records, err)
+	}
+	if err = Append(path, NewRecord("export line", 100, 1, time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err = Append(path, NewRecord("transfer", 200, 2, time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{\"command\":\"export li\n")
+	f.Close()
+
+	records, err = Load(path, "export line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Bytes != 100 || records[0].Series != 1 || records[0].Duration != time.Second {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+}
+
+func TestEstimator(t *testing.T) {
+	e := NewEstimator(nil)
+	if _, ok := e.Estimate(100); ok {
+		t.Fatal("expected no estimate without records")
+	}
+	if d, ok := e.Remaining(50, 100, 10*time.Second); !ok || d != 10*time.Second {
+		t.Fatalf("unexpected remaining by current rate: %v, %v", d, ok)
+	}
+
+	host, _ := os.Hostname()
+	e = NewEstimator([]Record{
+		{Host: host, Bytes: 100, Duration: time.Second},
+		{Host: host, Bytes: 300, Duration: time.Second},
+		{Host: host + "-other", Bytes: 1, Duration: time.Second},
+	})
+	if d, ok := e.Estimate(400); !ok || d != 2*time.Second {
+		t.Fatalf("unexpected estimate: %v, %v", d, ok)
+	}
+	// half done at the rate learned of 200 B/s and the current rate of 100 B/s
+	if d, ok := e.Remaining(100, 200, time.Second); !ok || d != 100*time.Second/150 {
+		t.Fatalf("unexpected remaining: %v, %v", d, ok)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, exp := range map[int64]string{1000: "1000 B", 1536: "1.5 KiB", 3 << 30: "3.0 GiB"} {
+		if s := FormatBytes(n); s != exp {
+			t.Errorf("FormatBytes(%d) = %s, expected %s", n, s, exp)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Package keyset reads the series keys listed in a file to select the series processed, such as the keys written by
+// influx_inspect, one per line like cpu,host=a,region=us. The keys are normalized, so the order of their tags and a
+// field appended like cpu,host=a#!~#usage do not matter.
+package keyset
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// keyFieldSeparator separates the series key and the field of the keys of tsm files.
+const keyFieldSeparator = "#!~#"
+
+// Set is the series keys escaped as stored in tsm files, with the tags sorted.
+type Set struct {
+	keys map[string]struct{}
+}
+
+// Load reads the series keys of the file, one per line, the empty lines are skipped.
+func Load(path string) (*Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &Set{keys: make(map[string]struct{})}
+	scan := bufio.NewScanner(f)
+	scan.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scan.Scan(); n++ {
+		line := bytes.TrimSpace(scan.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if bytes.Contains(line, []byte(keyFieldSeparator)) {
+			line, _ = tsm1.SeriesAndFieldFromCompositeKey(line)
+		}
+		name, tags := models.ParseKeyBytes(line)
+		if len(name) == 0 {
+			return nil, fmt.Errorf("series key of line %d in %s is invalid: %s", n, path, line)
+		}
+		sort.Sort(tags)
+		s.keys[string(models.MakeKey(name, tags))] = struct{}{}
+	}
+	if err = scan.Err(); err != nil {
+		return nil, fmt.Errorf("read %s error: %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *Set) Len() int {
+	return len(s.keys)
+}
+
+// Has returns whether the series key escaped as stored is in the set.
+func (s *Set) Has(seriesKey []byte) bool {
+	_, ok := s.keys[string(seriesKey)]
+	return ok
+}
+
+// HasSeries returns whether the series of the measurement and tags unescaped is in the set.
+func (s *Set) HasSeries(name []byte, tags models.Tags) bool {
+	return s.Has(models.MakeKey(name, tags))
+}
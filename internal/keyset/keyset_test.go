@@ -0,0 +1,44 @@
+package keyset
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	data := "cpu,region=us,host=a\n\n  mem,host=b#!~#used  \nmy\\ disk,path=/data\\ 1\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 3 {
+		t.Errorf("unexpected len: %d", s.Len())
+	}
+	for _, key := range []string{"cpu,host=a,region=us", "mem,host=b", `my\ disk,path=/data\ 1`} {
+		if !s.Has([]byte(key)) {
+			t.Errorf("key not found: %s", key)
+		}
+	}
+	for _, key := range []string{"cpu,host=a", "cpu", "mem,host=b,region=us"} {
+		if s.Has([]byte(key)) {
+			t.Errorf("unexpected key found: %s", key)
+		}
+	}
+	if !s.HasSeries([]byte("my disk"), models.NewTags(map[string]string{"path": "/data 1"})) {
+		t.Error("series not found by name and tags")
+	}
+
+	if err = os.WriteFile(path, []byte(",host=a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(path); err == nil {
+		t.Error("expected error of invalid key")
+	}
+}
@@ -0,0 +1,103 @@
+// Package notify posts the summary of a finished command to a webhook, so that the operators learn a long run
+// finished without keeping its terminal open.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/history"
+)
+
+const (
+	FormatJSON  = "json"
+	FormatSlack = "slack"
+
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+
+	timeout = 10 * time.Second
+)
+
+// Summary is the summary of a finished command.
+type Summary struct {
+	Command  string           `json:"command"`
+	Status   string           `json:"status"`
+	Error    string           `json:"error,omitempty"`
+	Start    time.Time        `json:"start"`
+	Duration time.Duration    `json:"duration"`
+	Host     string           `json:"host"`
+	Stats    []history.Record `json:"stats,omitempty"` // statistics of the parts finished, such as retention policies
+}
+
+// NewSummary returns the summary of command started at start, which failed with err if not nil.
+func NewSummary(command string, start time.Time, err error, stats []history.Record) Summary {
+	host, _ := os.Hostname()
+	s := Summary{
+		Command:  command,
+		Status:   StatusSuccess,
+		Start:    start.UTC(),
+		Duration: time.Since(start),
+		Host:     host,
+		Stats:    stats,
+	}
+	if err != nil {
+		s.Status, s.Error = StatusFailure, err.Error()
+	}
+	return s
+}
+
+// ValidFormat returns whether format is json or slack.
+func ValidFormat(format string) bool {
+	return format == FormatJSON || format == FormatSlack
+}
+
+// Webhook posts the summaries to a URL.
+type Webhook struct {
+	URL    string
+	Format string // json for the summary itself, or slack for a message of slack incoming webhooks
+}
+
+// Payload returns the body posted for the summary.
+func (w Webhook) Payload(s Summary) ([]byte, error) {
+	if w.Format != FormatSlack {
+		return json.Marshal(s)
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	text := fmt.Sprintf("%s %s on %s in %s", s.Command, s.Status, s.Host, s.Duration.Round(time.Second))
+	if s.Error != "" {
+		text += ": " + s.Error
+	}
+	return json.Marshal(map[string]string{"text": text + "\n```" + string(b) + "```"})
+}
+
+// Send posts the summary to the webhook, an error is returned unless the webhook responds with 2xx.
+func (w Webhook) Send(s Summary) error {
+	if w.URL == "" {
+		return errors.New("webhook url is empty")
+	}
+	body, err := w.Payload(s)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
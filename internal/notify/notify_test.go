@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/history"
+)
+
+func TestWebhook(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		if strings.Contains(string(body), "reject") {
+			http.Error(w, "rejected", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	stats := []history.Record{history.NewRecord("transfer", 1024, 10, time.Minute)}
+	s := NewSummary("influx-tool transfer", time.Now().Add(-time.Minute), nil, stats)
+	if err := (Webhook{URL: srv.URL, Format: FormatJSON}).Send(s); err != nil {
+		t.Fatal(err)
+	}
+	var got Summary
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusSuccess || got.Command != s.Command || len(got.Stats) != 1 || got.Stats[0].Bytes != 1024 {
+		t.Errorf("unexpected summary: %s", body)
+	}
+
+	s = NewSummary("influx-tool export", time.Now(), errors.New("disk full"), nil)
+	if err := (Webhook{URL: srv.URL, Format: FormatSlack}).Send(s); err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]string
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg["text"], "influx-tool export failure on ") || !strings.Contains(msg["text"], "disk full") {
+		t.Errorf("unexpected message: %s", msg["text"])
+	}
+
+	s = NewSummary("reject", time.Now(), nil, nil)
+	if err := (Webhook{URL: srv.URL, Format: FormatJSON}).Send(s); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
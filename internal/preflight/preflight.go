@@ -0,0 +1,177 @@
+// Package preflight inspects the engine and index of the shards in a data directory before reading them, to report
+// the mismatches and refuse the unsupported layouts instead of failing deep inside influxdb.
+package preflight
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Engines and indexes of shards.
+const (
+	EngineTSM1    = "tsm1"
+	EngineUnknown = "unknown"
+	IndexTSI1     = tsdb.TSI1IndexName
+	IndexInmem    = tsdb.InmemIndexName
+)
+
+// fieldsIndexFile is the file of the field types of a shard, which influxd rebuilds by scanning the tsm files if missing.
+const fieldsIndexFile = "fields.idx"
+
+// Shard is the layout of a shard directory.
+type Shard struct {
+	Database        string
+	RetentionPolicy string
+	ID              uint64
+	Path            string
+	Engine          string // tsm1, or unknown for the shard of a single file like b1 and bz1
+	Index           string // tsi1 if the index directory exists, otherwise inmem
+	FieldsIndex     bool   // whether fields.idx exists
+	SeriesFile      bool   // whether the series file of the database exists
+	TSMFiles        int
+}
+
+func (sh Shard) String() string {
+	return fmt.Sprintf("%s/%s/%d", sh.Database, sh.RetentionPolicy, sh.ID)
+}
+
+// Inspect returns the layouts of the shards of the retention policy rp of the database db in dataDir, all databases
+// except _internal if db is empty, and all retention policies if rp is empty. The missing database or retention policy
+// has no shards.
+func Inspect(dataDir, db, rp string) ([]Shard, error) {
+	dbs := []string{db}
+	if db == "" {
+		all, err := subdirs(dataDir)
+		if err != nil {
+			return nil, err
+		}
+		dbs = dbs[:0]
+		for _, name := range all {
+			if name != "_internal" {
+				dbs = append(dbs, name)
+			}
+		}
+	}
+	var shards []Shard
+	for _, db := range dbs {
+		_, err := os.Stat(filepath.Join(dataDir, db, tsdb.SeriesFileDirectory))
+		seriesFile := err == nil
+		rps := []string{rp}
+		if rp == "" {
+			if rps, err = subdirs(filepath.Join(dataDir, db)); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		for _, rp := range rps {
+			if rp == tsdb.SeriesFileDirectory {
+				continue
+			}
+			entries, err := os.ReadDir(filepath.Join(dataDir, db, rp))
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			// the entries not named by shard ids are skipped
+			for _, entry := range entries {
+				id, err := strconv.ParseUint(entry.Name(), 10, 64)
+				if err != nil {
+					continue
+				}
+				sh := Shard{Database: db, RetentionPolicy: rp, ID: id, Path: filepath.Join(dataDir, db, rp, entry.Name()), SeriesFile: seriesFile}
+				if err = sh.inspect(entry.IsDir()); err != nil {
+					return nil, err
+				}
+				shards = append(shards, sh)
+			}
+		}
+	}
+	return shards, nil
+}
+
+// subdirs returns the names of the directories in dir in order.
+func subdirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// inspect fills the engine, index and files of the shard, which are decided the same as influxd opens the shard.
+func (sh *Shard) inspect(isDir bool) error {
+	sh.Engine, sh.Index = EngineUnknown, IndexInmem
+	if !isDir {
+		return nil
+	}
+	sh.Engine = EngineTSM1
+	if _, err := os.Stat(filepath.Join(sh.Path, "index")); err == nil {
+		sh.Index = IndexTSI1
+	}
+	if _, err := os.Stat(filepath.Join(sh.Path, fieldsIndexFile)); err == nil {
+		sh.FieldsIndex = true
+	}
+	files, err := filepath.Glob(filepath.Join(sh.Path, fmt.Sprintf("*.%s", tsm1.TSMFileExtension)))
+	if err != nil {
+		return err
+	}
+	sh.TSMFiles = len(files)
+	return nil
+}
+
+// Check reports the summary of the shards and the mismatches by logf, and returns the error listing the shards of
+// unsupported layouts. index is the index the shards are opened with by influxdb, or empty if the tsm files are read
+// directly without the index.
+func Check(shards []Shard, index string, logf func(format string, v ...interface{})) error {
+	var errs errlist.ErrorList
+	var readAsTSI1, rebuildFields int
+	counts := make(map[string]int)
+	for _, sh := range shards {
+		counts[sh.Engine]++
+		if sh.Engine != EngineTSM1 {
+			errs.Add(fmt.Errorf("shard %s is of unsupported engine %s, require tsm1, convert it by influx_tsm first", sh, sh.Engine))
+			continue
+		}
+		counts[sh.Index]++
+		if !sh.FieldsIndex {
+			counts[fieldsIndexFile]++
+		}
+		if index == "" {
+			continue
+		}
+		switch {
+		case index == IndexTSI1 && sh.Index == IndexInmem && sh.TSMFiles > 0:
+			errs.Add(fmt.Errorf("shard %s has no tsi1 index but is read as tsi1, which misses all its series, build the index by influx_inspect buildtsi or read it as inmem by --skip-tsi", sh))
+		case sh.Index == IndexTSI1 && !sh.SeriesFile:
+			errs.Add(fmt.Errorf("shard %s has tsi1 index but the series file %s of database %s is missing", sh, tsdb.SeriesFileDirectory, sh.Database))
+		case sh.Index == IndexTSI1 && index == IndexInmem:
+			readAsTSI1++
+		}
+		if !sh.FieldsIndex && sh.Index == IndexTSI1 && sh.TSMFiles > 0 {
+			rebuildFields++
+		}
+	}
+	if readAsTSI1 > 0 {
+		logf("preflight: %d shards have tsi1 index, which are read as tsi1 instead of inmem", readAsTSI1)
+	}
+	if rebuildFields > 0 {
+		logf("preflight: %d shards have tsi1 index but no %s, the field types of which are rebuilt by scanning the tsm files slowly", rebuildFields, fieldsIndexFile)
+	}
+	logf("preflight: %d shards, engine %s %d, %s %d, index %s %d, %s %d, %s missing %d", len(shards), EngineTSM1, counts[EngineTSM1],
+		EngineUnknown, counts[EngineUnknown], IndexTSI1, counts[IndexTSI1], IndexInmem, counts[IndexInmem], fieldsIndexFile, counts[fieldsIndexFile])
+	return errs.Err()
+}
@@ -0,0 +1,83 @@
+package preflight
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{
+		"db/_series/00/0000",
+		"db/autogen/1/000000001-000000001.tsm",
+		"db/autogen/1/fields.idx",
+		"db/autogen/1/index/0/L0-00000001.tsl",
+		"db/autogen/2/000000001-000000001.tsm",
+		"db/rp/3",
+		"db/rp/_tmp/x",
+		"_internal/monitor/4/000000001-000000001.tsm",
+		"other/autogen/5/index/0/L0-00000001.tsl",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shards, err := Inspect(dir, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, sh := range shards {
+		got = append(got, fmt.Sprintf("%s %s %s fields=%v series=%v tsm=%d", sh, sh.Engine, sh.Index, sh.FieldsIndex, sh.SeriesFile, sh.TSMFiles))
+	}
+	exp := []string{
+		"db/autogen/1 tsm1 tsi1 fields=true series=true tsm=1",
+		"db/autogen/2 tsm1 inmem fields=false series=true tsm=1",
+		"db/rp/3 unknown inmem fields=false series=true tsm=0",
+		"other/autogen/5 tsm1 tsi1 fields=false series=false tsm=0",
+	}
+	if !cmp.Equal(got, exp) {
+		t.Fatalf("unexpected shards: got=%v, exp=%v", got, exp)
+	}
+	if missing, err := Inspect(dir, "db", "missing"); err != nil || len(missing) != 0 {
+		t.Fatalf("unexpected shards %v, error %v", missing, err)
+	}
+
+	tests := []struct {
+		shards []Shard
+		index  string
+		errs   []string
+	}{
+		{shards[:2], "", nil},
+		{shards[:2], IndexInmem, nil},
+		{shards[:2], IndexTSI1, []string{"db/autogen/2 has no tsi1 index"}},
+		{shards[2:], "", []string{"db/rp/3 is of unsupported engine unknown"}},
+		{shards[3:], IndexInmem, []string{"other/autogen/5 has tsi1 index but the series file"}},
+	}
+	for i, tt := range tests {
+		var logs []string
+		err := Check(tt.shards, tt.index, func(format string, v ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, v...))
+		})
+		if len(logs) == 0 || !strings.HasPrefix(logs[len(logs)-1], fmt.Sprintf("preflight: %d shards", len(tt.shards))) {
+			t.Errorf("%d: unexpected logs %v", i, logs)
+		}
+		if (err == nil) != (len(tt.errs) == 0) {
+			t.Fatalf("%d: unexpected error %v", i, err)
+		}
+		for _, s := range tt.errs {
+			if !strings.Contains(err.Error(), s) {
+				t.Errorf("%d: error %q does not contain %q", i, err, s)
+			}
+		}
+	}
+}
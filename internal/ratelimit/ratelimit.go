@@ -0,0 +1,87 @@
+// Package ratelimit limits the bandwidth shared by concurrent workers with token buckets.
+package ratelimit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket of bytes refilled at a constant rate, with a burst of one second.
+// A nil Limiter is unlimited. Limiter is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter of bytes per second, or nil if unlimited.
+func NewLimiter(bytesPerSec float64) *Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &Limiter{rate: bytesPerSec, tokens: bytesPerSec, last: time.Now()}
+}
+
+// NewLimiterMbps returns a limiter of megabits per second, or nil if unlimited.
+func NewLimiterMbps(mbps float64) *Limiter {
+	return NewLimiter(mbps * 1000 * 1000 / 8)
+}
+
+// WaitN blocks until n bytes are allowed. Tokens may go into debt for n larger than the burst,
+// later callers wait until the debt is paid off, so the average rate is kept.
+func (l *Limiter) WaitN(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
+
+type reader struct {
+	r        io.Reader
+	limiters []*Limiter
+}
+
+// NewReader returns a reader limited by all the limiters, nil limiters are ignored.
+func NewReader(r io.Reader, limiters ...*Limiter) io.Reader {
+	return &reader{r: r, limiters: limiters}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for _, l := range r.limiters {
+		l.WaitN(n)
+	}
+	return n, err
+}
+
+type writer struct {
+	w        io.Writer
+	limiters []*Limiter
+}
+
+// NewWriter returns a writer limited by all the limiters, nil limiters are ignored.
+func NewWriter(w io.Writer, limiters ...*Limiter) io.Writer {
+	return &writer{w: w, limiters: limiters}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	for _, l := range w.limiters {
+		l.WaitN(len(p))
+	}
+	return w.w.Write(p)
+}
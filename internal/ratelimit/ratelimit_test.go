@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewLimiter(t *testing.T) {
+	if NewLimiterMbps(0) != nil {
+		t.Error("limiter of 0 mbps should be unlimited")
+	}
+	if l := NewLimiterMbps(8); l.rate != 1000*1000 {
+		t.Errorf("unexpected rate of 8 mbps: %f", l.rate)
+	}
+	var l *Limiter
+	l.WaitN(1 << 30)
+}
+
+func TestWaitN(t *testing.T) {
+	l := NewLimiter(10000)
+	start := time.Now()
+	// burst is allowed immediately, then 5000 bytes by 4 concurrent workers need 0.5s
+	l.WaitN(10000)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.WaitN(1250)
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("unexpected elapsed: %s", elapsed)
+	}
+}
+
+func TestReader(t *testing.T) {
+	global, node := NewLimiter(1<<20), NewLimiter(2000)
+	start := time.Now()
+	b, err := io.ReadAll(NewReader(bytes.NewReader(make([]byte, 3000)), global, node, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 3000 {
+		t.Errorf("unexpected length: %d", len(b))
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("unexpected elapsed: %s", elapsed)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, NewLimiter(2000), nil)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write(make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() != 3000 {
+		t.Errorf("unexpected length: %d", buf.Len())
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("unexpected elapsed: %s", elapsed)
+	}
+}
@@ -0,0 +1,275 @@
+// Package selfupdate downloads a release of influx-tool for this platform, verifies it by the checksums signed by the
+// release key, and replaces the running binary with it.
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// DefaultAPIURL is the api of the github repository the releases are published to.
+	DefaultAPIURL = "https://api.github.com/repos/chengshiwen/influx-tool"
+
+	program      = "influx-tool"
+	checksumFile = "sha256sums.txt"
+	signatureExt = ".sig"
+	maxAssetSize = 256 * 1024 * 1024
+)
+
+// PublicKey is the ed25519 public key of the release key verifying the signatures of checksums, encoded as base64 of
+// the DER of PKIX, which is set at build time by -ldflags "-X".
+var PublicKey = ""
+
+// Release is a release with the download urls of its assets by name.
+type Release struct {
+	Tag    string
+	Assets map[string]string
+}
+
+// Version returns the version of the release, which is the tag without the v prefix.
+func (r *Release) Version() string {
+	return strings.TrimPrefix(r.Tag, "v")
+}
+
+// Updater fetches the releases from the api, and verifies the downloads by the public key.
+type Updater struct {
+	Client    *http.Client
+	APIURL    string
+	PublicKey string
+}
+
+// Fetch returns the release of tag, or the latest release if tag is empty.
+func (u *Updater) Fetch(tag string) (*Release, error) {
+	url := strings.TrimSuffix(u.APIURL, "/") + "/releases/latest"
+	if tag != "" {
+		url = strings.TrimSuffix(u.APIURL, "/") + "/releases/tags/" + tag
+	}
+	b, err := u.get(url, "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		TagName string `json:"tag_name"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	if err = json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("parse release error: %v", err)
+	}
+	if resp.TagName == "" {
+		return nil, errors.New("release without tag")
+	}
+	r := &Release{Tag: resp.TagName, Assets: make(map[string]string, len(resp.Assets))}
+	for _, asset := range resp.Assets {
+		r.Assets[asset.Name] = asset.URL
+	}
+	return r, nil
+}
+
+// ArchiveName returns the name of the release archive of the version for the platform, named by make release.
+func ArchiveName(version, goos, goarch string) string {
+	ext := ".tar.gz"
+	if goos == "windows" {
+		ext = ".zip"
+	}
+	return fmt.Sprintf("%s-%s-%s-%s%s", program, version, goos, goarch, ext)
+}
+
+// Download downloads the archive of the release for this platform, verifies its checksum signed by the release key,
+// and returns the binary in it.
+func (u *Updater) Download(r *Release) ([]byte, error) {
+	key, err := parsePublicKey(u.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	name := ArchiveName(r.Version(), runtime.GOOS, runtime.GOARCH)
+	assets := make(map[string][]byte, 3)
+	for _, asset := range []string{checksumFile, checksumFile + signatureExt, name} {
+		url, ok := r.Assets[asset]
+		if !ok {
+			return nil, fmt.Errorf("release %s has no asset %s", r.Tag, asset)
+		}
+		if assets[asset], err = u.get(url, "application/octet-stream"); err != nil {
+			return nil, err
+		}
+	}
+	sums := assets[checksumFile]
+	if !ed25519.Verify(key, sums, signature(assets[checksumFile+signatureExt])) {
+		return nil, fmt.Errorf("signature of %s is invalid", checksumFile)
+	}
+	sum, err := checksum(sums, name)
+	if err != nil {
+		return nil, err
+	}
+	if actual := sha256.Sum256(assets[name]); hex.EncodeToString(actual[:]) != sum {
+		return nil, fmt.Errorf("checksum of %s mismatched", name)
+	}
+	return extractBinary(name, assets[name])
+}
+
+func (u *Updater) get(url, accept string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get %s error: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("get %s error: status %d, %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxAssetSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("get %s error: %v", url, err)
+	}
+	if len(b) > maxAssetSize {
+		return nil, fmt.Errorf("get %s error: larger than %d bytes", url, maxAssetSize)
+	}
+	return b, nil
+}
+
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	if s == "" {
+		return nil, errors.New("no public key to verify the release")
+	}
+	der, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("public key is invalid: %v", err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("public key is invalid: %v", err)
+	}
+	key, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is invalid, require ed25519")
+	}
+	return key, nil
+}
+
+// signature returns the signature of the raw bytes signed by openssl pkeyutl, or decoded from base64.
+func signature(b []byte) []byte {
+	if len(b) == ed25519.SignatureSize {
+		return b
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return nil
+	}
+	return sig
+}
+
+// checksum returns the sha256 of the file in the output of sha256sum.
+func checksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum of %s", checksumFile, name)
+}
+
+// extractBinary returns the binary in the tar.gz or zip archive.
+func extractBinary(name string, archive []byte) ([]byte, error) {
+	bin := program
+	if strings.HasSuffix(name, ".zip") {
+		bin += ".exe"
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if path.Base(f.Name) != bin || f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(io.LimitReader(rc, maxAssetSize))
+		}
+		return nil, fmt.Errorf("%s has no %s", name, bin)
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s has no %s", name, bin)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Base(hdr.Name) == bin {
+			return io.ReadAll(io.LimitReader(tr, maxAssetSize))
+		}
+	}
+}
+
+// Replace replaces the binary of exe with bin in place. The binary is written to a temporary file in the same
+// directory first and renamed to exe, the running binary on windows, which cannot be replaced, is renamed to
+// exe.old before.
+func Replace(exe string, bin []byte) error {
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err = f.Write(bin); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err = os.Rename(exe, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp, exe)
+}
@@ -0,0 +1,126 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := ArchiveName("0.5.0", runtime.GOOS, runtime.GOARCH)
+	bin := []byte("new binary")
+	if strings.HasSuffix(name, ".zip") {
+		t.Skip("release archive of windows is zip")
+	}
+	var archive bytes.Buffer
+	gzw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gzw)
+	dir := strings.TrimSuffix(name, ".tar.gz")
+	for _, f := range []struct {
+		name string
+		body []byte
+	}{{dir + "/README.md", []byte("readme")}, {dir + "/influx-tool", bin}} {
+		if err = tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0755, Size: int64(len(f.body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(f.body)
+	}
+	tw.Close()
+	gzw.Close()
+	sum := sha256.Sum256(archive.Bytes())
+	sums := []byte(fmt.Sprintf("%s  other.zip\n%s  %s\n", strings.Repeat("0", 64), hex.EncodeToString(sum[:]), name))
+
+	assets := map[string][]byte{
+		"/download/" + name:                     archive.Bytes(),
+		"/download/sha256sums.txt":              sums,
+		"/download/sha256sums.txt.sig":          ed25519.Sign(priv, sums),
+		"/download/tampered/sha256sums.txt":     append(sums, '\n'),
+		"/download/tampered/sha256sums.txt.sig": ed25519.Sign(priv, sums),
+	}
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases/latest", "/releases/tags/v0.4.9":
+			prefix := ts.URL + "/download/"
+			if r.URL.Path != "/releases/latest" {
+				prefix += "tampered/"
+			}
+			fmt.Fprintf(w, `{"tag_name":"v0.5.0","assets":[{"name":%q,"browser_download_url":%q},{"name":"sha256sums.txt","browser_download_url":%q},{"name":"sha256sums.txt.sig","browser_download_url":%q}]}`,
+				name, ts.URL+"/download/"+name, prefix+"sha256sums.txt", prefix+"sha256sums.txt.sig")
+		default:
+			b, ok := assets[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(b)
+		}
+	}))
+	defer ts.Close()
+
+	u := &Updater{Client: ts.Client(), APIURL: ts.URL, PublicKey: base64.StdEncoding.EncodeToString(der)}
+	r, err := u.Fetch("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version() != "0.5.0" || len(r.Assets) != 3 {
+		t.Fatalf("unexpected release %+v", r)
+	}
+	got, err := u.Download(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, bin) {
+		t.Fatalf("unexpected binary %q", got)
+	}
+
+	if r, err = u.Fetch("v0.4.9"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = u.Download(r); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("expected invalid signature, got %v", err)
+	}
+	if _, err = u.Fetch("v0.0.0"); err == nil {
+		t.Error("expected error of missing release")
+	}
+
+	exe := filepath.Join(t.TempDir(), "influx-tool")
+	if err = os.WriteFile(exe, []byte("old binary"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err = Replace(exe, bin); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(exe)
+	if err != nil || !bytes.Equal(b, bin) {
+		t.Fatalf("unexpected binary %q, error %v", b, err)
+	}
+	if fi, err := os.Stat(exe); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("unexpected mode %v, error %v", fi.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(exe)); len(entries) != 1 {
+		t.Errorf("expected no temporary file left, got %d entries", len(entries))
+	}
+}
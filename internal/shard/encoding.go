@@ -0,0 +1,94 @@
+package shard
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// The names of the encodings of the timestamps and the values of tsm blocks by the high 4 bits of their first bytes.
+var (
+	timeEncodings  = map[byte]string{0: "raw", 1: "simple8b", 2: "rle"}
+	valueEncodings = map[byte]map[byte]string{
+		tsm1.BlockFloat64:  {1: "gorilla"},
+		tsm1.BlockInteger:  {0: "raw", 1: "simple8b", 2: "rle"},
+		tsm1.BlockUnsigned: {0: "raw", 1: "simple8b", 2: "rle"},
+		tsm1.BlockBoolean:  {1: "bitpacked"},
+		tsm1.BlockString:   {1: "snappy"},
+	}
+	blockTypes = map[byte]string{
+		tsm1.BlockFloat64:  "float",
+		tsm1.BlockInteger:  "integer",
+		tsm1.BlockUnsigned: "unsigned",
+		tsm1.BlockBoolean:  "boolean",
+		tsm1.BlockString:   "string",
+	}
+)
+
+// EncodingStats counts the encodings of the timestamps and the values of the tsm blocks written, which are chosen by
+// the encoders of tsm1 per block, so that the compression of the data written can be examined. It is safe for
+// concurrent use by the writers of the workers.
+type EncodingStats struct {
+	mu     sync.Mutex
+	blocks int64
+	counts map[string]int64 // blocks by "timestamps <encoding>" and "<type> <encoding>"
+}
+
+func NewEncodingStats() *EncodingStats {
+	return &EncodingStats{counts: make(map[string]int64)}
+}
+
+// record counts the encodings of the block, which is the encoded values without checksum.
+func (s *EncodingStats) record(block []byte) {
+	if s == nil || len(block) < 2 {
+		return
+	}
+	typ := block[0]
+	tsLen, i := binary.Uvarint(block[1:])
+	if i <= 0 || 1+i+int(tsLen) > len(block) {
+		return
+	}
+	ts, values := block[1+i:1+i+int(tsLen)], block[1+i+int(tsLen):]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks++
+	if len(ts) > 0 {
+		s.counts["timestamps "+encodingName(timeEncodings, ts[0]>>4)]++
+	}
+	if len(values) > 0 {
+		s.counts[blockTypes[typ]+" "+encodingName(valueEncodings[typ], values[0]>>4)]++
+	}
+}
+
+func encodingName(names map[byte]string, enc byte) string {
+	if name, ok := names[enc]; ok {
+		return name
+	}
+	return fmt.Sprintf("encoding-%d", enc)
+}
+
+// Report returns the number of the blocks written and of the blocks of each encoding, empty if none written.
+func (s *EncodingStats) Report() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blocks == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(s.counts))
+	for name := range s.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, s.counts[name])
+	}
+	return fmt.Sprintf("encodings of %d blocks written: %s", s.blocks, strings.Join(parts, ", "))
+}
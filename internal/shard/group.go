@@ -1,4 +1,4 @@
-package transfer
+package shard
 
 import (
 	"time"
@@ -25,7 +25,7 @@ func makeShardGroupsForDuration(min, max time.Time, sd time.Duration) meta.Shard
 }
 
 // PlanShardGroups creates a new ShardGroup set using a shard group duration of sd, for the time spanning start to end.
-func planShardGroups(sourceShards []meta.ShardGroupInfo, sd time.Duration, start, end int64) meta.ShardGroupInfos {
+func PlanShardGroups(sourceShards []meta.ShardGroupInfo, sd time.Duration, start, end int64) meta.ShardGroupInfos {
 	var target []meta.ShardGroupInfo
 	if len(sourceShards) == 0 {
 		return target
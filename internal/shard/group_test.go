@@ -1,4 +1,4 @@
-package transfer
+package shard
 
 import (
 	"math"
@@ -107,7 +107,7 @@ func TestPlanShardGroups(t *testing.T) {
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := planShardGroups(tc.g, tc.d, math.MinInt64, math.MaxInt64)
+			got := PlanShardGroups(tc.g, tc.d, math.MinInt64, math.MaxInt64)
 			if !cmp.Equal(got, tc.exp, cmp.Comparer(shardGroupEqual)) {
 				t.Errorf("unexpected value -got/+exp\n%s", cmp.Diff(got, tc.exp))
 			}
@@ -0,0 +1,428 @@
+package shard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/binary"
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/chengshiwen/influx-tool/internal/server"
+
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+type Importer struct {
+	MetaClient *meta.Client
+	db         string
+	dataDir    string
+	rpi        *meta.RetentionPolicyInfo
+	sfile      *tsdb.SeriesFile
+	buildTsi   bool
+	adoptRP    bool // adopt the retention policy existing with different parameters
+	writerOpts []WriterOption
+}
+
+const (
+	seriesBatchSize     = 1000
+	seriesDictBatchSize = 10000
+)
+
+// NewImporter returns the importer of the retention policy of the database in svr, which are created if not exist. If
+// the retention policy exists with different parameters, it is adopted with a warning if adoptRP and its shard duration
+// is a multiple of sd, or an error is returned otherwise.
+func NewImporter(svr *server.Server, db string, rp string, sd, d time.Duration, buildTsi, adoptRP bool) (*Importer, error) {
+	i := &Importer{
+		MetaClient: svr.MetaClient(),
+		db:         db,
+		dataDir:    svr.TSDBConfig().Dir,
+		buildTsi:   buildTsi,
+		adoptRP:    adoptRP,
+	}
+
+	err := i.createDatabase(retentionPolicySpec(rp, sd, d))
+	if err != nil {
+		return i, err
+	}
+
+	sfile := tsdb.NewSeriesFile(filepath.Join(svr.TSDBConfig().Dir, db, tsdb.SeriesFileDirectory))
+	if err = sfile.Open(); err != nil {
+		return i, err
+	}
+	i.sfile = sfile
+
+	return i, nil
+}
+
+// SetWriterOptions sets the options of the tsm writers of the shards imported, such as the max points per block.
+func (i *Importer) SetWriterOptions(opts ...WriterOption) {
+	i.writerOpts = opts
+}
+
+// Database returns the database imported into.
+func (i *Importer) Database() string {
+	return i.db
+}
+
+// RetentionPolicy returns the retention policy imported into.
+func (i *Importer) RetentionPolicy() string {
+	return i.rpi.Name
+}
+
+func (i *Importer) Close() error {
+	el := errlist.NewErrorList()
+	if i.sfile != nil {
+		el.Add(i.sfile.Close())
+	}
+	return el.Err()
+}
+
+func (i *Importer) createDatabase(rp *meta.RetentionPolicySpec) error {
+	var rpi *meta.RetentionPolicyInfo
+	dbInfo := i.MetaClient.Database(i.db)
+	if dbInfo == nil {
+		return i.createDatabaseWithRetentionPolicy(rp)
+	}
+
+	rpi, err := i.MetaClient.RetentionPolicy(i.db, rp.Name)
+	if err != nil {
+		return err
+	}
+
+	mismatch, err := checkRetentionPolicy(rpi, rp, i.adoptRP)
+	if err != nil {
+		return err
+	}
+	if mismatch != "" {
+		log.Printf("warning: retention policy %s already exists with different parameters, adopted: %s", rp.Name, mismatch)
+		i.rpi = rpi
+		return nil
+	}
+	if _, err := i.MetaClient.CreateRetentionPolicy(i.db, rp, false); err != nil {
+		return err
+	}
+
+	i.rpi, err = i.MetaClient.RetentionPolicy(i.db, rp.Name)
+	return err
+}
+
+// ValidateRetentionPolicy validates the retention policy of the database in the meta of the influxdb directory dir
+// as NewImporter without opening or writing the directory, so that the meta of the targets can be validated on dry
+// run, or before any of them is written. A directory without meta is valid.
+func ValidateRetentionPolicy(dir, db, rp string, sd, d time.Duration, adoptRP bool) error {
+	b, err := os.ReadFile(filepath.Join(dir, "meta", "meta.db"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data := &meta.Data{}
+	if err = data.UnmarshalBinary(b); err != nil {
+		return fmt.Errorf("read meta file error: %v", err)
+	}
+	dbi := data.Database(db)
+	if dbi == nil {
+		return nil
+	}
+	_, err = checkRetentionPolicy(dbi.RetentionPolicy(rp), retentionPolicySpec(rp, sd, d), adoptRP)
+	return err
+}
+
+// retentionPolicySpec returns the spec of the retention policy created by the importer, whose duration less than an
+// hour is infinite.
+func retentionPolicySpec(rp string, sd, d time.Duration) *meta.RetentionPolicySpec {
+	spec := &meta.RetentionPolicySpec{Name: rp, ShardGroupDuration: sd}
+	if d >= time.Hour {
+		spec.Duration = &d
+	}
+	return spec
+}
+
+// checkRetentionPolicy returns the differences of the existing retention policy from spec, empty if it does not exist
+// or matches. The differences are an error unless adopted, and the shard duration not a multiple of the one of spec is
+// an error anyway, since the shard groups of spec are written into the existing ones covering them.
+func checkRetentionPolicy(rpi *meta.RetentionPolicyInfo, spec *meta.RetentionPolicySpec, adopt bool) (string, error) {
+	if rpi == nil {
+		return "", nil
+	}
+	var diffs []string
+	if spec.Duration != nil && rpi.Duration != *spec.Duration {
+		diffs = append(diffs, fmt.Sprintf("duration %s, expected %s", rpi.Duration, *spec.Duration))
+	}
+	if spec.ReplicaN != nil && rpi.ReplicaN != *spec.ReplicaN {
+		diffs = append(diffs, fmt.Sprintf("replication %d, expected %d", rpi.ReplicaN, *spec.ReplicaN))
+	}
+	if rpi.ShardGroupDuration != spec.ShardGroupDuration {
+		diffs = append(diffs, fmt.Sprintf("shard duration %s, expected %s", rpi.ShardGroupDuration, spec.ShardGroupDuration))
+	}
+	if len(diffs) == 0 {
+		return "", nil
+	}
+	mismatch := strings.Join(diffs, ", ")
+	if !adopt {
+		return "", fmt.Errorf("retention policy %v already exists with different parameters: %s", spec.Name, mismatch)
+	}
+	if spec.ShardGroupDuration <= 0 || rpi.ShardGroupDuration%spec.ShardGroupDuration != 0 {
+		return "", fmt.Errorf("retention policy %v cannot be adopted: shard duration %s is not a multiple of %s", spec.Name, rpi.ShardGroupDuration, spec.ShardGroupDuration)
+	}
+	return mismatch, nil
+}
+
+func (i *Importer) createDatabaseWithRetentionPolicy(rp *meta.RetentionPolicySpec) error {
+	var err error
+	var dbInfo *meta.DatabaseInfo
+	if len(rp.Name) == 0 {
+		dbInfo, err = i.MetaClient.CreateDatabase(i.db)
+	} else {
+		dbInfo, err = i.MetaClient.CreateDatabaseWithRetentionPolicy(i.db, rp)
+	}
+	if err != nil {
+		return err
+	}
+	i.rpi = dbInfo.RetentionPolicy(rp.Name)
+	return nil
+}
+
+type ImportWorker struct {
+	*Importer
+	currentShard uint64
+	created      bool // whether the current shard group is created rather than existing
+	sh           *Writer
+	sw           *seriesWriter
+	seriesBuf    []byte
+	lastSeries   []byte                      // series key written last in the current bucket
+	seriesKeys   [][]byte                    // series keys in the dictionary of header
+	fieldTypes   map[string]binary.FieldType // field types by series field key in the dictionary of header
+}
+
+func NewImportWorker(importer *Importer) *ImportWorker {
+	i := &ImportWorker{
+		Importer: importer,
+	}
+	if !i.buildTsi {
+		i.seriesBuf = make([]byte, 0, 2048)
+	}
+	return i
+}
+
+// SetHeader sets the header read from the stream. The series dictionary of a version 1 header is
+// used to create the series in advance and validate the field types of the series to be imported.
+func (i *ImportWorker) SetHeader(h *binary.Header) {
+	i.seriesKeys, i.fieldTypes = nil, nil
+	if h == nil || h.Version < binary.Version1 || len(h.Series) == 0 {
+		return
+	}
+	seen := make(map[string]struct{}, len(h.Series))
+	i.fieldTypes = make(map[string]binary.FieldType, len(h.Series))
+	for _, s := range h.Series {
+		i.fieldTypes[string(tsm1.SeriesFieldKeyBytes(string(s.SeriesKey), string(s.Field)))] = s.FieldType
+		if _, ok := seen[string(s.SeriesKey)]; !ok {
+			seen[string(s.SeriesKey)] = struct{}{}
+			i.seriesKeys = append(i.seriesKeys, s.SeriesKey)
+		}
+	}
+}
+
+// StartBucket starts the shard group of a bucket, and creates the series in the dictionary of header in advance.
+func (i *ImportWorker) StartBucket(start int64, end int64) error {
+	if err := i.StartShardGroup(start, end); err != nil {
+		return err
+	}
+	i.lastSeries = i.lastSeries[:0]
+	if len(i.seriesKeys) > 0 {
+		if err := i.sw.AddSeriesList(i.seriesKeys, seriesDictBatchSize); err != nil {
+			el := errlist.NewErrorList()
+			el.Add(err)
+			el.Add(i.CloseShardGroup())
+			return el.Err()
+		}
+	}
+	return nil
+}
+
+// WriteSeries writes the values of a series field into the bucket started, the series are written in key order and
+// the values of a series field may be split into several calls. The series is created unless it is in the dictionary
+// of header, whose field type must match the one of the values.
+func (i *ImportWorker) WriteSeries(seriesKey, field []byte, values tsm1.Values) error {
+	if len(values) == 0 {
+		return nil
+	}
+	seriesFieldKey := tsm1.SeriesFieldKeyBytes(string(seriesKey), string(field))
+	if ft, ok := i.fieldTypes[string(seriesFieldKey)]; !ok {
+		if !bytes.Equal(seriesKey, i.lastSeries) {
+			if err := i.AddSeries(seriesKey); err != nil {
+				return err
+			}
+			i.lastSeries = append(i.lastSeries[:0], seriesKey...)
+		}
+	} else if vt := valueFieldType(values[0]); ft != vt {
+		return fmt.Errorf("field type conflict: series %s field %s is %s in header, %s in series", seriesKey, field, ft, vt)
+	}
+	return i.Write(seriesFieldKey, values)
+}
+
+// valueFieldType returns the field type of the binary stream of the value.
+func valueFieldType(v tsm1.Value) binary.FieldType {
+	switch v.(type) {
+	case tsm1.IntegerValue:
+		return binary.IntegerFieldType
+	case tsm1.UnsignedValue:
+		return binary.UnsignedFieldType
+	case tsm1.BooleanValue:
+		return binary.BooleanFieldType
+	case tsm1.StringValue:
+		return binary.StringFieldType
+	default:
+		return binary.FloatFieldType
+	}
+}
+
+func (i *ImportWorker) StartShardGroup(start int64, end int64) error {
+	existingSg, err := i.MetaClient.ShardGroupsByTimeRange(i.db, i.rpi.Name, time.Unix(0, start), time.Unix(0, end-1))
+	if err != nil {
+		return err
+	}
+
+	var sgi *meta.ShardGroupInfo
+	var shardID uint64
+	i.created = len(existingSg) == 0
+
+	shardsPath := i.shardPath(i.rpi.Name)
+	var shardPath string
+	if len(existingSg) > 0 {
+		sgi = &existingSg[0]
+		if len(sgi.Shards) > 1 {
+			return fmt.Errorf("multiple shards for the same owner %v and time range %v to %v", sgi.Shards[0].Owners, start, end)
+		}
+
+		shardID = sgi.Shards[0].ID
+
+		shardPath = filepath.Join(shardsPath, strconv.Itoa(int(shardID)))
+		_, err = os.Stat(shardPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+		}
+	} else {
+		sgi, err = i.MetaClient.CreateShardGroup(i.db, i.rpi.Name, time.Unix(0, start))
+		if err != nil {
+			return err
+		}
+		shardID = sgi.Shards[0].ID
+	}
+
+	shardPath = filepath.Join(shardsPath, strconv.Itoa(int(shardID)))
+	if err = os.MkdirAll(shardPath, 0777); err != nil {
+		return err
+	}
+
+	i.sh = NewWriter(shardID, shardsPath, append([]WriterOption{AutoNumber()}, i.writerOpts...)...)
+	i.currentShard = shardID
+
+	err = i.startSeriesFile(i.sfile)
+	return err
+}
+
+// Created returns whether the shard group started last is created by the worker, or existed before.
+func (i *ImportWorker) Created() bool {
+	return i.created
+}
+
+func (i *ImportWorker) shardPath(rp string) string {
+	return filepath.Join(i.dataDir, i.db, rp)
+}
+
+func (i *ImportWorker) removeShardGroup(rp string, shardID uint64) error {
+	shardPath := i.shardPath(rp)
+	err := os.RemoveAll(filepath.Join(shardPath, strconv.Itoa(int(shardID))))
+	return err
+}
+
+func (i *ImportWorker) Write(key []byte, values tsm1.Values) error {
+	if i.sh == nil {
+		return errors.New("importer not currently writing a shard")
+	}
+	i.sh.Write(key, values)
+	if i.sh.Err() != nil {
+		el := errlist.NewErrorList()
+		el.Add(i.sh.Err())
+		el.Add(i.CloseShardGroup())
+		el.Add(i.removeShardGroup(i.rpi.Name, i.currentShard))
+		i.sh = nil
+		i.currentShard = 0
+		return el.Err()
+	}
+	return nil
+}
+
+// WriteBlock writes an encoded block of the key into the current shard, such as a block copied from a tsm file.
+func (i *ImportWorker) WriteBlock(key []byte, minTime, maxTime int64, block []byte) error {
+	if i.sh == nil {
+		return errors.New("importer not currently writing a shard")
+	}
+	i.sh.WriteBlock(key, minTime, maxTime, block)
+	if i.sh.Err() != nil {
+		el := errlist.NewErrorList()
+		el.Add(i.sh.Err())
+		el.Add(i.CloseShardGroup())
+		el.Add(i.removeShardGroup(i.rpi.Name, i.currentShard))
+		i.sh = nil
+		i.currentShard = 0
+		return el.Err()
+	}
+	return nil
+}
+
+func (i *ImportWorker) Close() error {
+	el := errlist.NewErrorList()
+	if i.sh != nil {
+		el.Add(i.CloseShardGroup())
+	}
+	return el.Err()
+}
+
+func (i *ImportWorker) CloseShardGroup() error {
+	el := errlist.NewErrorList()
+	el.Add(i.closeSeriesFile())
+	i.sh.Close()
+	if i.sh.Err() != nil {
+		el.Add(i.sh.Err())
+	}
+	i.sh = nil
+	return el.Err()
+}
+
+func (i *ImportWorker) startSeriesFile(sfile *tsdb.SeriesFile) error {
+	dataPath := filepath.Join(i.dataDir, i.db)
+	shardPath := filepath.Join(i.dataDir, i.db, i.rpi.Name)
+
+	var err error
+	if i.buildTsi {
+		i.sw, err = newTSI1SeriesWriter(sfile, i.db, dataPath, shardPath, int(i.sh.ShardID()))
+	} else {
+		i.sw, err = newInMemSeriesWriter(sfile, i.db, dataPath, shardPath, int(i.sh.ShardID()), i.seriesBuf)
+	}
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (i *ImportWorker) AddSeries(seriesKey []byte) error {
+	return i.sw.AddSeries(seriesKey)
+}
+
+func (i *ImportWorker) closeSeriesFile() error {
+	return i.sw.Close()
+}
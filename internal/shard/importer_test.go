@@ -0,0 +1,120 @@
+package shard
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+func TestCheckRetentionPolicy(t *testing.T) {
+	week := time.Hour * 24 * 7
+	month := week * 4
+	rpi := &meta.RetentionPolicyInfo{Name: "rp", ReplicaN: 1, Duration: month, ShardGroupDuration: week * 2}
+	tests := []struct {
+		spec     *meta.RetentionPolicySpec
+		adopt    bool
+		mismatch string
+		err      string
+	}{
+		{spec: retentionPolicySpec("rp", week*2, month)},
+		{spec: retentionPolicySpec("rp", week*2, 0)},
+		{spec: retentionPolicySpec("rp", week, month), err: "already exists with different parameters: shard duration 336h0m0s, expected 168h0m0s"},
+		{spec: retentionPolicySpec("rp", week, month), adopt: true, mismatch: "shard duration 336h0m0s, expected 168h0m0s"},
+		{spec: retentionPolicySpec("rp", week*2, week), adopt: true, mismatch: "duration 672h0m0s, expected 168h0m0s"},
+		{spec: retentionPolicySpec("rp", week*3, month), adopt: true, err: "cannot be adopted: shard duration 336h0m0s is not a multiple of 504h0m0s"},
+	}
+	for i, tt := range tests {
+		mismatch, err := checkRetentionPolicy(rpi, tt.spec, tt.adopt)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%d: unexpected error %v, expected %q", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || mismatch != tt.mismatch {
+			t.Errorf("%d: unexpected mismatch %q, error %v, expected %q", i, mismatch, err, tt.mismatch)
+		}
+	}
+	if mismatch, err := checkRetentionPolicy(nil, retentionPolicySpec("rp", week, 0), false); mismatch != "" || err != nil {
+		t.Errorf("unexpected mismatch %q, error %v of retention policy not existing", mismatch, err)
+	}
+}
+
+func TestValidateRetentionPolicy(t *testing.T) {
+	dir := t.TempDir()
+	week := time.Hour * 24 * 7
+	if err := ValidateRetentionPolicy(dir, "db", "rp", week, 0, false); err != nil {
+		t.Errorf("unexpected error %v of directory without meta", err)
+	}
+
+	data := &meta.Data{}
+	if err := data.CreateDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+	rpi := &meta.RetentionPolicyInfo{Name: "rp", ReplicaN: 1, ShardGroupDuration: week * 2}
+	if err := data.CreateRetentionPolicy("db", rpi, true); err != nil {
+		t.Fatal(err)
+	}
+	b, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(dir, "meta"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "meta", "meta.db"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateRetentionPolicy(dir, "db", "rp", week, 0, false); err == nil {
+		t.Error("expected error of retention policy with different shard duration")
+	}
+	if err = ValidateRetentionPolicy(dir, "db", "rp", week, 0, true); err != nil {
+		t.Errorf("unexpected error %v of retention policy adopted", err)
+	}
+	for _, name := range [][2]string{{"db", "other"}, {"other", "rp"}} {
+		if err = ValidateRetentionPolicy(dir, name[0], name[1], week, 0, false); err != nil {
+			t.Errorf("unexpected error %v of %s.%s not existing", err, name[0], name[1])
+		}
+	}
+}
+
+func TestNewImporterAdoptRP(t *testing.T) {
+	dir := t.TempDir()
+	week := time.Hour * 24 * 7
+	svr, err := server.NewServer(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	imp, err := NewImporter(svr, "db", "rp", week*2, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imp.Close()
+
+	// created again with the same parameters
+	imp, err = NewImporter(svr, "db", "rp", week*2, 0, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error %v of the same parameters", err)
+	}
+	imp.Close()
+
+	imp, err = NewImporter(svr, "db", "rp", week, 0, false, false)
+	imp.Close()
+	if err == nil {
+		t.Fatal("expected error of different shard duration")
+	}
+	imp, err = NewImporter(svr, "db", "rp", week, 0, false, true)
+	if err != nil {
+		t.Fatalf("unexpected error %v of retention policy adopted", err)
+	}
+	defer imp.Close()
+	if imp.rpi.ShardGroupDuration != week*2 {
+		t.Errorf("unexpected shard duration %s of retention policy adopted", imp.rpi.ShardGroupDuration)
+	}
+}
@@ -0,0 +1,175 @@
+package shard
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxdb/tsdb/index/tsi1"
+)
+
+// IndexDirectory is the directory of the tsi1 index in a shard, and TmpIndexDirectory is the one it is built into.
+const (
+	IndexDirectory    = "index"
+	TmpIndexDirectory = ".index"
+)
+
+// IndexBuilder builds the index of the shards of a database offline from the series keys in their tsm and wal files,
+// the series are created in the series file of the database shared by the shards built concurrently.
+type IndexBuilder struct {
+	db       string
+	readMode string
+	sfile    *tsdb.SeriesFile
+}
+
+// NewIndexBuilder opens the series file of the database directory dbDir like /path/to/influxdb/data/db, which is
+// removed and rebuilt from the shards if rebuild.
+func NewIndexBuilder(dbDir, readMode string, rebuild bool) (*IndexBuilder, error) {
+	path := filepath.Join(dbDir, tsdb.SeriesFileDirectory)
+	if rebuild {
+		if err := os.RemoveAll(path); err != nil {
+			return nil, err
+		}
+	}
+	sfile := tsdb.NewSeriesFile(path)
+	if err := sfile.Open(); err != nil {
+		return nil, err
+	}
+	return &IndexBuilder{db: filepath.Base(filepath.Clean(dbDir)), readMode: readMode, sfile: sfile}, nil
+}
+
+// BuildTSI1 builds the tsi1 index of the shard at shardPath from the series in its tsm files and the wal segments in
+// walPath if any, into a temporary directory renamed to the index once done, replacing the one existing. It returns
+// the number of series indexed.
+func (b *IndexBuilder) BuildTSI1(shardPath, walPath string) (int, error) {
+	tmpPath := filepath.Join(shardPath, TmpIndexDirectory)
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return 0, err
+	}
+	ti := tsi1.NewIndex(b.sfile, b.db, tsi1.WithPath(tmpPath))
+	if err := ti.Open(); err != nil {
+		return 0, fmt.Errorf("error opening tsi1 index %s: %v", tmpPath, err)
+	}
+	sw := &seriesWriter{seriesBatchSize: seriesBatchSize, sfile: b.sfile, idx: &tsi1Adapter{ti: ti}}
+	n, err := b.addSeries(sw, shardPath, walPath)
+	el := errlist.NewErrorList()
+	el.Add(err)
+	el.Add(sw.Close())
+	if err = el.Err(); err != nil {
+		os.RemoveAll(tmpPath)
+		return 0, err
+	}
+	indexPath := filepath.Join(shardPath, IndexDirectory)
+	if err = os.RemoveAll(indexPath); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmpPath, indexPath)
+}
+
+// BuildInmem removes the tsi1 index of the shard at shardPath, whose series are rebuilt in memory by influxd at
+// startup. The series are also created in the series file if addSeries, such as the series file is rebuilt. It returns
+// the number of series added.
+func (b *IndexBuilder) BuildInmem(shardPath, walPath string, addSeries bool) (int, error) {
+	if err := os.RemoveAll(filepath.Join(shardPath, IndexDirectory)); err != nil {
+		return 0, err
+	}
+	if !addSeries {
+		return 0, nil
+	}
+	sw := &seriesWriter{seriesBatchSize: seriesBatchSize, sfile: b.sfile, idx: &seriesFileAdapter{sf: b.sfile}}
+	n, err := b.addSeries(sw, shardPath, walPath)
+	if err != nil {
+		return 0, err
+	}
+	return n, sw.Flush()
+}
+
+// addSeries adds the series keys of the tsm files of the shard and the wal segments, returning the number of series.
+func (b *IndexBuilder) addSeries(sw *seriesWriter, shardPath, walPath string) (int, error) {
+	seen := make(map[string]struct{})
+	add := func(key []byte) error {
+		seriesKey, _ := tsm1.SeriesAndFieldFromCompositeKey(key)
+		if _, ok := seen[string(seriesKey)]; ok {
+			return nil
+		}
+		seen[string(seriesKey)] = struct{}{}
+		// the key of a mmap tsm file is unmapped once closed, but the batch of series is created later
+		return sw.AddSeries(append([]byte(nil), key...))
+	}
+
+	files, err := filepath.Glob(filepath.Join(shardPath, fmt.Sprintf("*.%s", tsm1.TSMFileExtension)))
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		r, err := tsmread.Open(file, b.readMode)
+		if err != nil {
+			return 0, fmt.Errorf("error opening tsm file %s: %v", file, err)
+		}
+		for i := 0; i < r.KeyCount(); i++ {
+			key, _ := r.KeyAt(i)
+			if err = add(key); err != nil {
+				r.Close()
+				return 0, err
+			}
+		}
+		if err = r.Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	if walPath == "" {
+		return len(seen), nil
+	}
+	segments, err := filepath.Glob(filepath.Join(walPath, fmt.Sprintf("%s*.%s", tsm1.WALFilePrefix, tsm1.WALFileExtension)))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(segments)
+	for _, segment := range segments {
+		if err = readWALKeys(segment, add); err != nil {
+			return 0, err
+		}
+	}
+	return len(seen), nil
+}
+
+// readWALKeys calls fn with the keys written in the wal segment, the entries after a corrupt one are skipped the same
+// as influxd loads the segment.
+func readWALKeys(path string, fn func(key []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	r := tsm1.NewWALSegmentReader(f)
+	defer r.Close()
+	for r.Next() {
+		entry, err := r.Read()
+		if err != nil {
+			break
+		}
+		we, ok := entry.(*tsm1.WriteWALEntry)
+		if !ok {
+			continue
+		}
+		for key := range we.Values {
+			if err = fn([]byte(key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close compacts the partitions of the series file and closes it.
+func (b *IndexBuilder) Close() error {
+	el := errlist.NewErrorList()
+	el.Add((&seriesFileAdapter{sf: b.sfile}).Compact())
+	el.Add(b.sfile.Close())
+	return el.Err()
+}
@@ -0,0 +1,146 @@
+package shard
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxdb/tsdb/index/tsi1"
+)
+
+func TestIndexBuilder(t *testing.T) {
+	dir := t.TempDir()
+	dbDir := filepath.Join(dir, "data", "db")
+	shardPath := filepath.Join(dbDir, "autogen", "1")
+	walPath := filepath.Join(dir, "wal", "db", "autogen", "1")
+	for _, path := range []string{shardPath, walPath} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTSMFile(t, filepath.Join(shardPath, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#idle":  {tsm1.NewFloatValue(1, 1)},
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1)},
+		"cpu,host=b#!~#usage": {tsm1.NewFloatValue(1, 1)},
+	})
+	// the series only in the wal are indexed as well
+	f := mustCreate(t, filepath.Join(walPath, "_00001.wal"))
+	w := tsm1.NewWALSegmentWriter(f)
+	entry := &tsm1.WriteWALEntry{Values: map[string][]tsm1.Value{
+		"cpu,host=b#!~#usage": {tsm1.NewFloatValue(2, 2)},
+		"mem,host=a#!~#used":  {tsm1.NewIntegerValue(2, 2)},
+	}}
+	b, err := entry.Encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(entry.Type(), snappy.Encode(nil, b)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	builder, err := NewIndexBuilder(dbDir, tsmread.ModeMmap, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := builder.BuildTSI1(shardPath, walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("unexpected series built: %d", n)
+	}
+	if err = builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(shardPath, TmpIndexDirectory)); !os.IsNotExist(err) {
+		t.Errorf("unexpected temporary index: %v", err)
+	}
+	if got := indexedMeasurements(t, dbDir, shardPath); len(got) != 2 || got[0] != "cpu" || got[1] != "mem" {
+		t.Errorf("unexpected measurements indexed: %v", got)
+	}
+
+	builder, err = NewIndexBuilder(dbDir, tsmread.ModeMmap, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err = builder.BuildInmem(shardPath, "", true); err != nil || n != 2 {
+		t.Errorf("unexpected inmem series added: %d, %v", n, err)
+	}
+	if err = builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(shardPath, IndexDirectory)); !os.IsNotExist(err) {
+		t.Errorf("unexpected index left: %v", err)
+	}
+}
+
+func indexedMeasurements(t *testing.T, dbDir, shardPath string) []string {
+	sfile := tsdb.NewSeriesFile(filepath.Join(dbDir, tsdb.SeriesFileDirectory))
+	if err := sfile.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer sfile.Close()
+	ti := tsi1.NewIndex(sfile, "db", tsi1.WithPath(filepath.Join(shardPath, IndexDirectory)))
+	if err := ti.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer ti.Close()
+	itr, err := ti.MeasurementIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer itr.Close()
+	var names []string
+	for {
+		name, err := itr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == nil {
+			break
+		}
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func mustCreate(t *testing.T, path string) *os.File {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func writeTSMFile(t *testing.T, path string, data map[string][]tsm1.Value) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w, err := tsm1.NewTSMWriter(mustCreate(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err = w.Write([]byte(key), data[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.WriteIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
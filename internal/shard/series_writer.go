@@ -1,4 +1,4 @@
-package transfer
+package shard
 
 import (
 	"fmt"
@@ -54,9 +54,38 @@ func (sw *seriesWriter) AddSeries(key []byte) error {
 	return nil
 }
 
+// AddSeriesList creates the series keys in batches of the batch size, which is used for the series known in advance.
+func (sw *seriesWriter) AddSeriesList(keys [][]byte, batchSize int) error {
+	for len(keys) > 0 {
+		n := batchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		names := make([][]byte, n)
+		tags := make([]models.Tags, n)
+		for j, key := range keys[:n] {
+			names[j], tags[j] = models.ParseKeyBytes(key)
+		}
+		if err := sw.idx.CreateSeriesListIfNotExists(keys[:n], names, tags); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// Flush creates the series keys added but not created yet.
+func (sw *seriesWriter) Flush() error {
+	err := sw.idx.CreateSeriesListIfNotExists(sw.keys, sw.names, sw.tags)
+	sw.keys = sw.keys[:0]
+	sw.names = sw.names[:0]
+	sw.tags = sw.tags[:0]
+	return err
+}
+
 func (sw *seriesWriter) Close() error {
 	el := errlist.NewErrorList()
-	el.Add(sw.idx.CreateSeriesListIfNotExists(sw.keys, sw.names, sw.tags))
+	el.Add(sw.Flush())
 	el.Add(sw.idx.Compact())
 	el.Add(sw.idx.Close())
 	return el.Err()
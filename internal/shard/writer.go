@@ -1,6 +1,7 @@
 package shard
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
@@ -26,6 +27,12 @@ type Writer struct {
 	err      error
 	buf      []byte
 	auto     bool
+
+	maxPoints int            // max points per block, 0 writes the values of each write as a block
+	regular   bool           // blocks cut at the runs of regular timestamps
+	encodings *EncodingStats // encodings of the blocks written, nil if not recorded
+	key       []byte         // key of the pending values
+	pending   tsm1.Values    // values of the key written and not yet encoded into blocks
 }
 
 type WriterOption func(w *Writer)
@@ -58,6 +65,30 @@ func AutoNumber() WriterOption {
 	}
 }
 
+// MaxPointsPerBlock merges the values of the consecutive writes of a key into blocks of at most n points, instead of
+// writing the values of each write as a block.
+func MaxPointsPerBlock(n int) WriterOption {
+	return func(w *Writer) {
+		w.maxPoints = n
+	}
+}
+
+// RegularTimeBlocks cuts the blocks of MaxPointsPerBlock at the start and the end of each run of timestamps at a
+// regular interval of at least a quarter of a block, so that the timestamps of the run are encoded by run length in
+// a block of their own instead of being packed with the irregular ones.
+func RegularTimeBlocks() WriterOption {
+	return func(w *Writer) {
+		w.regular = true
+	}
+}
+
+// RecordEncodings counts the encodings of the blocks written into s.
+func RecordEncodings(s *EncodingStats) WriterOption {
+	return func(w *Writer) {
+		w.encodings = s
+	}
+}
+
 func NewWriter(id uint64, path string, opts ...WriterOption) *Writer {
 	w := &Writer{id: id, path: path, gen: 1, seq: 1, ext: tsm1.TSMFileExtension}
 
@@ -87,6 +118,83 @@ func NewWriter(id uint64, path string, opts ...WriterOption) *Writer {
 }
 
 func (w *Writer) Write(key []byte, values tsm1.Values) {
+	if w.maxPoints <= 0 {
+		w.writeValues(key, values)
+		return
+	}
+	// the values not following the pending ones in time are written into blocks of their own
+	if !bytes.Equal(key, w.key) || (len(w.pending) > 0 && len(values) > 0 && values[0].UnixNano() <= w.pending[len(w.pending)-1].UnixNano()) {
+		w.flush()
+		// the key is copied rather than reused, since the index of the tsm writer keeps it
+		w.key = append([]byte(nil), key...)
+	}
+	w.pending = append(w.pending, values...)
+	// the last block is kept pending to be filled up by the next write
+	for len(w.pending) > w.maxPoints && w.err == nil {
+		w.writePending(w.blockSize())
+	}
+}
+
+// flush writes the pending values into blocks.
+func (w *Writer) flush() {
+	for len(w.pending) > 0 && w.err == nil {
+		w.writePending(w.blockSize())
+	}
+	w.pending = w.pending[:0]
+}
+
+// writePending writes the first n pending values as a block.
+func (w *Writer) writePending(n int) {
+	w.writeValues(w.key, w.pending[:n])
+	w.pending = append(w.pending[:0], w.pending[n:]...)
+}
+
+// blockSize returns the number of the pending values of the next block, which is cut before or after a run of
+// regular timestamps if RegularTimeBlocks.
+func (w *Writer) blockSize() int {
+	n := len(w.pending)
+	if n > w.maxPoints {
+		n = w.maxPoints
+	}
+	if !w.regular {
+		return n
+	}
+	minRun := w.maxPoints / 4
+	if minRun < 3 {
+		minRun = 3
+	}
+	for i := 0; i+1 < n; {
+		j := regularRun(w.pending, i)
+		if j-i >= minRun {
+			if i > 0 {
+				return i
+			}
+			if j < n {
+				return j
+			}
+			return n
+		}
+		// the last value of a run may start the next one
+		i = j - 1
+	}
+	return n
+}
+
+// regularRun returns the end of the run of the values from i, whose timestamps are at the same interval.
+func regularRun(values tsm1.Values, i int) int {
+	j := i + 1
+	if j >= len(values) {
+		return j
+	}
+	d := values[j].UnixNano() - values[i].UnixNano()
+	for j+1 < len(values) && values[j+1].UnixNano()-values[j].UnixNano() == d {
+		j++
+	}
+	return j + 1
+}
+
+// writeValues writes the values as a block.
+func (w *Writer) writeValues(key []byte, values tsm1.Values) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("shard write panic: %v", r)
@@ -102,7 +210,16 @@ func (w *Writer) Write(key []byte, values tsm1.Values) {
 		w.nextTSM()
 	}
 
-	if err := w.tw.Write(key, values); err != nil {
+	if len(values) == 0 {
+		return
+	}
+	var err error
+	if w.buf, err = values.Encode(w.buf[:0]); err != nil {
+		w.err = err
+		return
+	}
+	w.encodings.record(w.buf)
+	if err = w.tw.WriteBlock(key, values.MinTime(), values.MaxTime(), w.buf); err != nil {
 		if err == tsm1.ErrMaxBlocksExceeded {
 			w.closeTSM()
 			w.nextTSM()
@@ -113,6 +230,7 @@ func (w *Writer) Write(key []byte, values tsm1.Values) {
 }
 
 func (w *Writer) WriteV(key []byte, values gen.Values) {
+	w.flush()
 	if w.err != nil {
 		return
 	}
@@ -128,6 +246,7 @@ func (w *Writer) WriteV(key []byte, values gen.Values) {
 		w.err = err
 		return
 	}
+	w.encodings.record(w.buf)
 
 	if err := w.tw.WriteBlock(key, minT, maxT, w.buf); err != nil {
 		if err == tsm1.ErrMaxBlocksExceeded {
@@ -139,8 +258,32 @@ func (w *Writer) WriteV(key []byte, values gen.Values) {
 	}
 }
 
-// Close closes the writer.
+// WriteBlock writes an encoded block of the key as it is, the block must not contain the checksum.
+func (w *Writer) WriteBlock(key []byte, minTime, maxTime int64, block []byte) {
+	w.flush()
+	if w.err != nil {
+		return
+	}
+	w.encodings.record(block)
+
+	if w.tw.Size() > maxTSMFileSize {
+		w.closeTSM()
+		w.nextTSM()
+	}
+
+	if err := w.tw.WriteBlock(key, minTime, maxTime, block); err != nil {
+		if err == tsm1.ErrMaxBlocksExceeded {
+			w.closeTSM()
+			w.nextTSM()
+		} else {
+			w.err = err
+		}
+	}
+}
+
+// Close writes the pending values and closes the writer.
 func (w *Writer) Close() {
+	w.flush()
 	if w.tw != nil {
 		w.closeTSM()
 	}
@@ -175,16 +318,26 @@ func (w *Writer) nextTSM() {
 
 func (w *Writer) closeTSM() {
 	el := errlist.NewErrorList()
-	if err := w.tw.WriteIndex(); err != nil && err != tsm1.ErrNoValues {
+	err := w.tw.WriteIndex()
+	empty := err == tsm1.ErrNoValues
+	if err != nil && !empty {
 		el.Add(err)
 	}
 
 	if err := w.tw.Close(); err != nil {
 		el.Add(err)
 	}
+	// the file without any block is left empty, which is unable to be opened as a tsm file
+	if empty && el.Err() == nil {
+		fileName := w.files[len(w.files)-1]
+		if err := os.Remove(fileName); err != nil {
+			el.Add(err)
+		} else {
+			w.files = w.files[:len(w.files)-1]
+		}
+	}
 
-	err := el.Err()
-	if err != nil {
+	if err = el.Err(); err != nil {
 		w.err = err
 	}
 
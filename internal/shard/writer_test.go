@@ -0,0 +1,111 @@
+package shard
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// readBlocks returns the number of points of each block of the key in the tsm file.
+func readBlocks(t *testing.T, path string, key []byte) []int {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := tsm1.NewTSMReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var points []int
+	for _, e := range r.Entries(key) {
+		values, err := r.ReadAt(&e, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		points = append(points, len(values))
+	}
+	return points
+}
+
+func floatValues(times ...int64) tsm1.Values {
+	values := make(tsm1.Values, len(times))
+	for i, ts := range times {
+		values[i] = tsm1.NewFloatValue(ts, float64(i))
+	}
+	return values
+}
+
+func TestWriterMaxPointsPerBlock(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stats := NewEncodingStats()
+	w := NewWriter(1, dir, MaxPointsPerBlock(5), RecordEncodings(stats))
+	key := []byte("cpu,host=a#!~#usage")
+	// the chunks of a key are merged into blocks of 5 points
+	for _, start := range []int64{0, 4, 8} {
+		w.Write(key, floatValues(start, start+1, start+2, start+3))
+	}
+	// the values not following the pending ones start a new block
+	w.Write(key, floatValues(1, 2))
+	w.Write([]byte("mem,host=a#!~#used"), floatValues(1))
+	w.Close()
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	// the blocks are indexed in time order, the one of the values rewritten is the second
+	if got, exp := readBlocks(t, w.Files()[0], key), []int{5, 2, 5, 2}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected points of blocks: got=%v, exp=%v", got, exp)
+	}
+	if got, exp := stats.Report(), "encodings of 5 blocks written: float gorilla 5, timestamps rle 4, timestamps simple8b 1"; got != exp {
+		t.Errorf("unexpected report: %s", got)
+	}
+}
+
+func TestWriterRegularTimeBlocks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// 3 irregular timestamps followed by a run of 8 at the interval of 10
+	times := []int64{1, 4, 9}
+	for ts := int64(20); ts < 100; ts += 10 {
+		times = append(times, ts)
+	}
+	stats := NewEncodingStats()
+	w := NewWriter(1, dir, MaxPointsPerBlock(8), RegularTimeBlocks(), RecordEncodings(stats))
+	key := []byte("cpu,host=a#!~#usage")
+	w.Write(key, floatValues(times...))
+	w.Close()
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := readBlocks(t, w.Files()[0], key), []int{3, 8}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected points of blocks: got=%v, exp=%v", got, exp)
+	}
+	if got, exp := stats.Report(), "encodings of 2 blocks written: float gorilla 2, timestamps rle 1, timestamps simple8b 1"; got != exp {
+		t.Errorf("unexpected report: %s", got)
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(1, dir)
+	w.Write([]byte("cpu,host=a#!~#usage"), nil)
+	w.Close()
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	// the tsm file without any block is removed rather than left empty
+	if files, _ := filepath.Glob(filepath.Join(dir, "1", "*")); len(files) != 0 || len(w.Files()) != 0 {
+		t.Errorf("unexpected files: %v, %v", files, w.Files())
+	}
+}
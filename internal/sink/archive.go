@@ -0,0 +1,184 @@
+package sink
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// ArchiveSink writes the series into a tar.gz archive with an export file "<db>/<rp>.txt" for each database and
+// retention policy, which can be extracted and imported by the import command with a directory. The export files
+// are spooled into a temporary directory until Close, as the entries of a tar archive are written one by one.
+type ArchiveSink struct {
+	w       io.Writer
+	tmpDir  string
+	entries map[policyKey]*archiveEntry
+}
+
+type archiveEntry struct {
+	path string
+	line *LineSink
+}
+
+// NewArchiveSink returns a sink writing the archive to w, which is closed on Close if it is an io.Closer.
+func NewArchiveSink(w io.Writer) (*ArchiveSink, error) {
+	tmpDir, err := os.MkdirTemp("", "influx-tool-archive-")
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveSink{w: w, tmpDir: tmpDir, entries: make(map[policyKey]*archiveEntry)}, nil
+}
+
+func (s *ArchiveSink) CreateSchema(db, rp string) error {
+	_, err := s.entry(db, rp)
+	return err
+}
+
+func (s *ArchiveSink) WriteSeries(db, rp string, seriesKey, field []byte, values []tsm1.Value) error {
+	e, err := s.entry(db, rp)
+	if err != nil {
+		return err
+	}
+	return e.line.WriteSeries(db, rp, seriesKey, field, values)
+}
+
+// entry returns the spooled export file of the database and retention policy, which is created with the schema.
+func (s *ArchiveSink) entry(db, rp string) (*archiveEntry, error) {
+	key := policyKey{db, rp}
+	if e, ok := s.entries[key]; ok {
+		return e, nil
+	}
+	f, err := os.CreateTemp(s.tmpDir, "entry-")
+	if err != nil {
+		return nil, err
+	}
+	e := &archiveEntry{path: f.Name(), line: NewLineSink(f, false)}
+	s.entries[key] = e
+	if err = e.line.CreateSchema(db, rp); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (s *ArchiveSink) Close() error {
+	el := errlist.NewErrorList()
+	defer os.RemoveAll(s.tmpDir)
+	for _, e := range s.entries {
+		el.Add(e.line.Close())
+	}
+	if el.Err() == nil {
+		el.Add(s.writeArchive())
+	}
+	if c, ok := s.w.(io.Closer); ok {
+		el.Add(c.Close())
+	}
+	return el.Err()
+}
+
+func (s *ArchiveSink) writeArchive() error {
+	keys := make([]policyKey, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].db < keys[j].db || (keys[i].db == keys[j].db && keys[i].rp < keys[j].rp)
+	})
+
+	gzw := gzip.NewWriter(s.w)
+	tw := tar.NewWriter(gzw)
+	for _, key := range keys {
+		if err := writeTarFile(tw, filepath.ToSlash(filepath.Join(key.db, key.rp+".txt")), s.entries[key].path); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ObjectSink writes the archive of ArchiveSink to an object store by a PUT request to the url, which is
+// usually a presigned url of s3, gcs or any compatible store. The archive is spooled into a temporary file until Close.
+type ObjectSink struct {
+	*ArchiveSink
+	client *http.Client
+	url    string
+	file   *os.File
+}
+
+func NewObjectSink(hc *http.Client, url string) (*ObjectSink, error) {
+	f, err := os.CreateTemp("", "influx-tool-object-")
+	if err != nil {
+		return nil, err
+	}
+	as, err := NewArchiveSink(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &ObjectSink{ArchiveSink: as, client: hc, url: url, file: f}, nil
+}
+
+func (s *ObjectSink) Close() error {
+	defer os.Remove(s.file.Name())
+	if err := s.ArchiveSink.Close(); err != nil {
+		return err
+	}
+	return s.upload()
+}
+
+func (s *ObjectSink) upload() error {
+	f, err := os.Open(s.file.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", s.url, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload archive error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload archive error: status %d, %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
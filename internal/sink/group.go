@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"fmt"
+
+	"github.com/chengshiwen/influx-tool/internal/binary"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// GroupSink writes the series of a shard group into its shard through an import worker, such as a bucket of the
+// binary stream of transfer. Unlike ShardSink, the values are written as they come rather than buffered, so the series
+// must be written in key order and the values of each series in time order, and the shard group is written once the
+// sink is closed.
+type GroupSink struct {
+	w  *shard.ImportWorker
+	pk policyKey
+}
+
+// NewGroupSink returns a sink writing to the shard group from start to end of the database and retention policy
+// of the worker, which is created if not exists.
+func NewGroupSink(w *shard.ImportWorker, start, end int64) (*GroupSink, error) {
+	if err := w.StartBucket(start, end); err != nil {
+		return nil, err
+	}
+	return &GroupSink{w: w, pk: policyKey{w.Database(), w.RetentionPolicy()}}, nil
+}
+
+// CreateSchema checks the database and retention policy, which are created with the importer of the worker.
+func (s *GroupSink) CreateSchema(db, rp string) error {
+	if pk := (policyKey{db, rp}); pk != s.pk {
+		return fmt.Errorf("schema of %s is not the one of shard group %s", pk, s.pk)
+	}
+	return nil
+}
+
+func (s *GroupSink) WriteSeries(db, rp string, seriesKey, field []byte, values []tsm1.Value) error {
+	if err := s.CreateSchema(db, rp); err != nil {
+		return err
+	}
+	return s.w.WriteSeries(seriesKey, field, values)
+}
+
+// Created returns whether the shard group is created by the sink, or existed before.
+func (s *GroupSink) Created() bool {
+	return s.w.Created()
+}
+
+func (s *GroupSink) Close() error {
+	return s.w.Close()
+}
+
+// CopyBucket writes the series of the bucket started last by the reader into the sink, until the footer of the bucket.
+func CopyBucket(s Sink, db, rp string, reader *binary.Reader) error {
+	sh, err := reader.NextSeries()
+	for ; sh != nil && err == nil; sh, err = reader.NextSeries() {
+		pr := reader.Points()
+		var next bool
+		for next, err = pr.Next(); next && err == nil; next, err = pr.Next() {
+			if err = s.WriteSeries(db, rp, sh.SeriesKey, sh.Field, pr.Values()); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
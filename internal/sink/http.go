@@ -0,0 +1,271 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+const (
+	DefaultBatchSize = 5000
+	DefaultRetries   = 3
+)
+
+// HTTPOptions are the options of writing batches to a live server.
+type HTTPOptions struct {
+	BatchSize int
+	Retries   int     // retries of a batch failed by network errors or server errors
+	Ledger    *Ledger // batches acked by previous writes to skip, nil to write all
+}
+
+// HTTPSink writes the series to a live server in batches of lines by database and retention policy.
+// Each batch is tagged with a deterministic idempotency key "<db>/<rp>/<shard>/<sequence>" by the Idempotency-Key
+// header, so that the target, or the ledger of acked batches, can drop the batches written again by retries.
+// It is not safe for concurrent use.
+type HTTPSink struct {
+	opts    HTTPOptions
+	batches map[policyKey]*batch
+	skipped int
+	backoff time.Duration // backoff before the first retry, doubled for each later one
+	create  func(db, rp string) error
+	write   func(db, rp, idemKey string, data []byte) (retry bool, err error)
+}
+
+type batch struct {
+	buf   []byte
+	lines int
+	shard uint64 // id of the shard of the source being written
+	seq   int    // sequence of the next batch in the shard
+}
+
+// NewHTTPSink returns a sink writing to an influxdb 1.x server or influx-proxy at the url of conf, the databases and
+// retention policies are created by the client if not exist.
+func NewHTTPSink(c *client.Client, conf client.Config, opts HTTPOptions) *HTTPSink {
+	s := newHTTPSink(opts)
+	hc := &http.Client{
+		Timeout:   conf.Timeout,
+		Transport: &http.Transport{Proxy: conf.Proxy, TLSClientConfig: &tls.Config{InsecureSkipVerify: conf.UnsafeSsl}},
+	}
+	s.create = func(db, rp string) error {
+		return CreateSchema(c, db, rp)
+	}
+	s.write = func(db, rp, idemKey string, data []byte) (bool, error) {
+		return WriteV1(hc, conf, db, rp, "n", idemKey, data)
+	}
+	return s
+}
+
+// NewHTTPv2Sink returns a sink writing to the /api/v2/write endpoint of an influxdb 2.x server at addr, the series of
+// a database and retention policy are written to the bucket "db/rp", which must be mapped by dbrp mappings in advance.
+func NewHTTPv2Sink(hc *http.Client, addr, token, org string, opts HTTPOptions) *HTTPSink {
+	s := newHTTPSink(opts)
+	s.create = func(db, rp string) error {
+		return nil
+	}
+	s.write = func(db, rp, idemKey string, data []byte) (bool, error) {
+		return WriteV2(hc, addr, token, org, db+"/"+rp, "ns", idemKey, data)
+	}
+	return s
+}
+
+// WriteV2 writes the lines of the precision ns, us, ms or s to the bucket by the /api/v2/write endpoint of an
+// influxdb 2.x server at addr, tagged with the idempotency key if not empty. The write is retryable if failed by
+// a network error or a server error.
+func WriteV2(hc *http.Client, addr, token, org, bucket, precision, idemKey string, data []byte) (bool, error) {
+	params := url.Values{}
+	params.Set("org", org)
+	params.Set("bucket", bucket)
+	params.Set("precision", precision)
+	req, err := http.NewRequest("POST", strings.TrimSuffix(addr, "/")+"/api/v2/write?"+params.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	return post(hc, req, "bucket "+bucket, idemKey)
+}
+
+// WriteV1 writes the lines of the precision n, u, ms, s, m or h to the database and retention policy by the /write
+// endpoint of an influxdb 1.x server or influx-proxy at the url of conf, tagged with the idempotency key if not empty.
+// The write is retryable if failed by a network error or a server error.
+func WriteV1(hc *http.Client, conf client.Config, db, rp, precision, idemKey string, data []byte) (bool, error) {
+	u := conf.URL
+	u.Path = path.Join(u.Path, "write")
+	params := url.Values{}
+	params.Set("db", db)
+	params.Set("rp", rp)
+	params.Set("precision", precision)
+	req, err := http.NewRequest("POST", u.String()+"?"+params.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	if conf.Username != "" {
+		req.SetBasicAuth(conf.Username, conf.Password)
+	}
+	return post(hc, req, db+"."+rp, idemKey)
+}
+
+// post sends the write request of the target tagged with the idempotency key, a network error or a server error is
+// retryable since the batch may not be written.
+func post(hc *http.Client, req *http.Request, target, idemKey string) (bool, error) {
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if idemKey != "" {
+		req.Header.Set("Idempotency-Key", idemKey)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("write %s error: %v", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode >= 500, fmt.Errorf("write %s error: status %d, %s", target, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return false, nil
+}
+
+func newHTTPSink(opts HTTPOptions) *HTTPSink {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	return &HTTPSink{opts: opts, batches: make(map[policyKey]*batch), backoff: time.Second}
+}
+
+func (s *HTTPSink) CreateSchema(db, rp string) error {
+	return s.create(db, rp)
+}
+
+// StartShard flushes the batch of the database and retention policy, and keys the later batches by the shard,
+// so that the batches of a shard are the same whenever it is written with the same batch size.
+func (s *HTTPSink) StartShard(db, rp string, id uint64) error {
+	key := policyKey{db, rp}
+	b := s.batch(key)
+	if err := s.flush(key, b); err != nil {
+		return err
+	}
+	b.shard, b.seq = id, 0
+	return nil
+}
+
+func (s *HTTPSink) WriteSeries(db, rp string, seriesKey, field []byte, values []tsm1.Value) error {
+	key := policyKey{db, rp}
+	b := s.batch(key)
+	for len(values) > 0 {
+		n := s.opts.BatchSize - b.lines
+		if n > len(values) {
+			n = len(values)
+		}
+		b.buf = appendLines(b.buf, seriesKey, field, values[:n])
+		b.lines += n
+		values = values[n:]
+		if b.lines >= s.opts.BatchSize {
+			if err := s.flush(key, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Skipped returns the number of batches skipped as acked in the ledger.
+func (s *HTTPSink) Skipped() int {
+	return s.skipped
+}
+
+func (s *HTTPSink) batch(key policyKey) *batch {
+	b := s.batches[key]
+	if b == nil {
+		b = &batch{}
+		s.batches[key] = b
+	}
+	return b
+}
+
+// flush writes the batch unless acked in the ledger, it is retried with backoff by the same idempotency key.
+func (s *HTTPSink) flush(key policyKey, b *batch) error {
+	if b.lines == 0 {
+		return nil
+	}
+	idemKey := fmt.Sprintf("%s/%s/%d/%d", url.PathEscape(key.db), url.PathEscape(key.rp), b.shard, b.seq)
+	b.seq++
+	defer func() {
+		b.buf, b.lines = b.buf[:0], 0
+	}()
+	if s.opts.Ledger.Acked(idemKey) {
+		s.skipped++
+		return nil
+	}
+	backoff := s.backoff
+	for i := 0; ; i++ {
+		retry, err := s.write(key.db, key.rp, idemKey, b.buf)
+		if err == nil {
+			return s.opts.Ledger.Ack(idemKey)
+		}
+		if !retry || i >= s.opts.Retries {
+			return err
+		}
+		log.Printf("%s, retrying batch %s in %s", err, idemKey, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *HTTPSink) Close() error {
+	el := errlist.NewErrorList()
+	for key, b := range s.batches {
+		el.Add(s.flush(key, b))
+	}
+	return el.Err()
+}
+
+// CreateSchema creates the database and the retention policy if not exist, an existing retention policy is kept as it is.
+func CreateSchema(c *client.Client, db, rp string) error {
+	stmts := schemaStatements(db, rp)
+	if err := query(c, stmts[0]); err != nil {
+		return err
+	}
+	if len(stmts) == 1 {
+		return nil
+	}
+	resp, err := c.Query(client.Query{Command: fmt.Sprintf("SHOW RETENTION POLICIES ON %s", influxql.QuoteIdent(db))})
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("show retention policies of %s error: %v", db, err)
+	}
+	for _, result := range resp.Results {
+		for _, row := range result.Series {
+			for _, v := range row.Values {
+				if len(v) > 0 && v[0] == rp {
+					return nil
+				}
+			}
+		}
+	}
+	return query(c, stmts[1])
+}
+
+func query(c *client.Client, stmt string) error {
+	resp, err := c.Query(client.Query{Command: stmt})
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return fmt.Errorf("execute '%s' error: %v", stmt, err)
+	}
+	return nil
+}
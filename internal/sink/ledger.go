@@ -0,0 +1,87 @@
+package sink
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// Ledger records the idempotency keys of the batches acked by the target into a local file, one key per line, so that
+// the batches written already are skipped when migrating again after failures. A nil ledger records nothing and skips
+// nothing.
+type Ledger struct {
+	mu    sync.Mutex
+	f     *os.File
+	acked map[string]struct{}
+}
+
+// OpenLedger loads the keys of the ledger file if exists, and appends the keys acked later to it. An empty path
+// returns a nil ledger.
+func OpenLedger(path string) (*Ledger, error) {
+	if path == "" {
+		return nil, nil
+	}
+	l := &Ledger{acked: make(map[string]struct{})}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if key := scanner.Text(); key != "" {
+				l.acked[key] = struct{}{}
+			}
+		}
+		f.Close()
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+	return l, nil
+}
+
+// Acked reports whether the batch of key is acked by a previous write.
+func (l *Ledger) Acked(key string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.acked[key]
+	return ok
+}
+
+// Ack records the batch of key acked by the target, it is synced to disk before returning.
+func (l *Ledger) Ack(key string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.WriteString(key + "\n"); err != nil {
+		return err
+	}
+	l.acked[key] = struct{}{}
+	return l.f.Sync()
+}
+
+// Len returns the number of batches acked.
+func (l *Ledger) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.acked)
+}
+
+func (l *Ledger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,83 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// LineSink writes the series in the export format of line protocol, which can be imported by the import command.
+// The schemas are written in the DDL section, so all of them must be created before writing any series.
+type LineSink struct {
+	w       io.Writer
+	bw      *bufio.Writer
+	gzw     *gzip.Writer
+	closer  io.Closer
+	buf     []byte
+	dml     bool
+	current policyKey
+}
+
+// NewLineSink returns a sink writing to w, compressed if compress is true, and w is closed on Close if it is an io.Closer.
+func NewLineSink(w io.Writer, compress bool) *LineSink {
+	s := &LineSink{}
+	if c, ok := w.(io.Closer); ok {
+		s.closer = c
+	}
+	s.bw = bufio.NewWriterSize(w, 1024*1024)
+	s.w = s.bw
+	if compress {
+		s.gzw = gzip.NewWriter(s.bw)
+		s.w = s.gzw
+	}
+	fmt.Fprintf(s.w, "# INFLUXDB EXPORT: %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintln(s.w, "# DDL")
+	return s
+}
+
+func (s *LineSink) CreateSchema(db, rp string) error {
+	if s.dml {
+		return fmt.Errorf("schema of %s.%s must be created before writing series", db, rp)
+	}
+	for _, stmt := range schemaStatements(db, rp) {
+		if _, err := fmt.Fprintln(s.w, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *LineSink) WriteSeries(db, rp string, seriesKey, field []byte, values []tsm1.Value) error {
+	if !s.dml {
+		s.dml = true
+		if _, err := fmt.Fprintln(s.w, "# DML"); err != nil {
+			return err
+		}
+	}
+	if key := (policyKey{db, rp}); key != s.current {
+		s.current = key
+		if _, err := fmt.Fprintf(s.w, "# CONTEXT-DATABASE:%s\n# CONTEXT-RETENTION-POLICY:%s\n", db, rp); err != nil {
+			return err
+		}
+	}
+	s.buf = appendLines(s.buf[:0], seriesKey, field, values)
+	_, err := s.w.Write(s.buf)
+	return err
+}
+
+func (s *LineSink) Close() error {
+	el := errlist.NewErrorList()
+	if s.gzw != nil {
+		el.Add(s.gzw.Close())
+	}
+	el.Add(s.bw.Flush())
+	if s.closer != nil {
+		el.Add(s.closer.Close())
+	}
+	return el.Err()
+}
@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/errlist"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+const maxBufferedValues = 1 << 22
+
+// ShardSink writes the series into the shards of an offline influxdb directory. The values are buffered by
+// shard group and flushed into new tsm files of the shards once the buffer is full, so that a shard may be
+// written several times and its tsm files are merged by the compaction of influxd.
+type ShardSink struct {
+	svr       *server.Server
+	sd        time.Duration
+	d         time.Duration
+	buildTsi  bool
+	importers map[policyKey]*shard.Importer
+	groups    map[groupKey]map[string]tsm1.Values // values by composite key of each shard group
+	buffered  int
+}
+
+type groupKey struct {
+	policyKey
+	start int64
+}
+
+// NewShardSink returns a sink writing to the server, the retention policies are created with the duration and shard duration.
+func NewShardSink(svr *server.Server, sd, d time.Duration, buildTsi bool) *ShardSink {
+	return &ShardSink{
+		svr:       svr,
+		sd:        sd,
+		d:         d,
+		buildTsi:  buildTsi,
+		importers: make(map[policyKey]*shard.Importer),
+		groups:    make(map[groupKey]map[string]tsm1.Values),
+	}
+}
+
+func (s *ShardSink) CreateSchema(db, rp string) error {
+	key := policyKey{db, rp}
+	if _, ok := s.importers[key]; ok {
+		return nil
+	}
+	imp, err := shard.NewImporter(s.svr, db, rp, s.sd, s.d, s.buildTsi, false)
+	if err != nil {
+		imp.Close()
+		return err
+	}
+	s.importers[key] = imp
+	return nil
+}
+
+func (s *ShardSink) WriteSeries(db, rp string, seriesKey, field []byte, values []tsm1.Value) error {
+	pk := policyKey{db, rp}
+	if _, ok := s.importers[pk]; !ok {
+		return fmt.Errorf("schema of %s is not created", pk)
+	}
+	key := string(tsm1.SeriesFieldKeyBytes(string(seriesKey), string(field)))
+	for _, v := range values {
+		gk := groupKey{pk, time.Unix(0, v.UnixNano()).Truncate(s.sd).UnixNano()}
+		group := s.groups[gk]
+		if group == nil {
+			group = make(map[string]tsm1.Values)
+			s.groups[gk] = group
+		}
+		group[key] = append(group[key], v)
+	}
+	s.buffered += len(values)
+	if s.buffered >= maxBufferedValues {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered values of each shard group into a new tsm file generation of its shard.
+func (s *ShardSink) flush() error {
+	for gk, group := range s.groups {
+		if err := s.writeGroup(gk, group); err != nil {
+			return fmt.Errorf("write shard group of %s at %s error: %v", gk.policyKey, time.Unix(0, gk.start).UTC().Format(time.RFC3339), err)
+		}
+		delete(s.groups, gk)
+	}
+	s.buffered = 0
+	return nil
+}
+
+func (s *ShardSink) writeGroup(gk groupKey, group map[string]tsm1.Values) error {
+	keys := make([]string, 0, len(group))
+	for key := range group {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := shard.NewImportWorker(s.importers[gk.policyKey])
+	if err := w.StartShardGroup(gk.start, gk.start+int64(s.sd)); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := w.AddSeries([]byte(key)); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Write([]byte(key), group[key].Deduplicate()); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func (s *ShardSink) Close() error {
+	el := errlist.NewErrorList()
+	el.Add(s.flush())
+	for _, imp := range s.importers {
+		el.Add(imp.Close())
+	}
+	return el.Err()
+}
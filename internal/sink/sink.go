@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/pkg/escape"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+// Sink writes the series read from a source, to offline shards, live servers, archives or line protocol files,
+// so that any source can be migrated to any destination.
+type Sink interface {
+	// CreateSchema creates the database and retention policy, it is called for all of them before writing any series.
+	CreateSchema(db, rp string) error
+
+	// WriteSeries writes the values of a series to the database and retention policy, the values of a series
+	// may be split into several calls and are not required to be sorted across calls.
+	WriteSeries(db, rp string, seriesKey, field []byte, values []tsm1.Value) error
+
+	// Close flushes the written series and releases the resources, the sink is not usable after that.
+	Close() error
+}
+
+// ShardStarter is implemented by the sinks keying the writes by the shards of the source, StartShard is called before
+// writing the values of each shard.
+type ShardStarter interface {
+	StartShard(db, rp string, id uint64) error
+}
+
+type policyKey struct {
+	db, rp string
+}
+
+func (k policyKey) String() string {
+	return k.db + "." + k.rp
+}
+
+// schemaStatements returns the statements creating the database and retention policy without modifying existing ones.
+func schemaStatements(db, rp string) []string {
+	stmts := []string{fmt.Sprintf("CREATE DATABASE %s", influxql.QuoteIdent(db))}
+	if rp != "autogen" {
+		stmts = append(stmts, fmt.Sprintf("CREATE RETENTION POLICY %s ON %s DURATION 0s REPLICATION 1", influxql.QuoteIdent(rp), influxql.QuoteIdent(db)))
+	}
+	return stmts
+}
+
+// appendLines appends the values of the series in line protocol, one line per value.
+func appendLines(buf []byte, seriesKey, field []byte, values []tsm1.Value) []byte {
+	// seriesKey are stored escaped, field names are not
+	field = escape.Bytes(field)
+	for _, value := range values {
+		buf = append(buf, seriesKey...)
+		buf = append(buf, ' ')
+		buf = append(buf, field...)
+		buf = append(buf, '=')
+		switch v := value.Value().(type) {
+		case float64:
+			buf = strconv.AppendFloat(buf, v, 'g', -1, 64)
+		case int64:
+			buf = strconv.AppendInt(buf, v, 10)
+			buf = append(buf, 'i')
+		case uint64:
+			buf = strconv.AppendUint(buf, v, 10)
+			buf = append(buf, 'u')
+		case bool:
+			buf = strconv.AppendBool(buf, v)
+		case string:
+			buf = append(buf, '"')
+			buf = append(buf, models.EscapeStringField(v)...)
+			buf = append(buf, '"')
+		default:
+			buf = append(buf, fmt.Sprintf("%v", v)...)
+		}
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, value.UnixNano(), 10)
+		buf = append(buf, '\n')
+	}
+	return buf
+}
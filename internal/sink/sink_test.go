@@ -0,0 +1,262 @@
+package sink
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/binary"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+func TestLineSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewLineSink(&buf, false)
+	if err := s.CreateSchema("db", "autogen"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateSchema("db", "rp 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteSeries("db", "autogen", []byte("cpu,host=a"), []byte("usage idle"), []tsm1.Value{tsm1.NewFloatValue(1, 1.5), tsm1.NewIntegerValue(2, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteSeries("db", "rp 1", []byte("mem"), []byte("msg"), []tsm1.Value{tsm1.NewStringValue(3, `a "b"`), tsm1.NewBooleanValue(4, true), tsm1.NewUnsignedValue(5, 6)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateSchema("db2", "autogen"); err == nil {
+		t.Error("expected error creating schema after writing series")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	exp := []string{
+		"# DDL",
+		"CREATE DATABASE db",
+		"CREATE DATABASE db",
+		`CREATE RETENTION POLICY "rp 1" ON db DURATION 0s REPLICATION 1`,
+		"# DML",
+		"# CONTEXT-DATABASE:db",
+		"# CONTEXT-RETENTION-POLICY:autogen",
+		`cpu,host=a usage\ idle=1.5 1`,
+		`cpu,host=a usage\ idle=3i 2`,
+		"# CONTEXT-DATABASE:db",
+		"# CONTEXT-RETENTION-POLICY:rp 1",
+		`mem msg="a \"b\"" 3`,
+		"mem msg=true 4",
+		"mem msg=6u 5",
+		"",
+	}
+	if !strings.HasPrefix(lines[0], "# INFLUXDB EXPORT: ") || !cmp.Equal(lines[1:], exp) {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestHTTPv2Sink(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" || r.Header.Get("Authorization") != "Token tok" {
+			t.Errorf("unexpected request: %s %v", r.URL, r.Header)
+		}
+		q := r.URL.Query()
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, q.Get("org")+" "+q.Get("bucket")+" "+q.Get("precision")+"\n"+string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	s := NewHTTPv2Sink(ts.Client(), ts.URL, "tok", "org", HTTPOptions{BatchSize: 2})
+	if err := s.CreateSchema("db", "autogen"); err != nil {
+		t.Fatal(err)
+	}
+	values := []tsm1.Value{tsm1.NewFloatValue(1, 1), tsm1.NewFloatValue(2, 2), tsm1.NewFloatValue(3, 3)}
+	if err := s.WriteSeries("db", "autogen", []byte("cpu"), []byte("v"), values); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"org db/autogen ns\ncpu v=1 1\ncpu v=2 2\n",
+		"org db/autogen ns\ncpu v=3 3\n",
+	}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected writes: got=%q, exp=%q", got, exp)
+	}
+}
+
+func TestHTTPSinkLedger(t *testing.T) {
+	var keys []string
+	fails := 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if fails > 0 {
+			fails--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "ledger")
+	migrate := func() *HTTPSink {
+		ledger, err := OpenLedger(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ledger.Close()
+		s := NewHTTPv2Sink(ts.Client(), ts.URL, "", "org", HTTPOptions{BatchSize: 2, Retries: 1, Ledger: ledger})
+		s.backoff = time.Millisecond
+		for _, id := range []uint64{1, 2} {
+			if err = s.StartShard("my db", "autogen", id); err != nil {
+				t.Fatal(err)
+			}
+			values := []tsm1.Value{tsm1.NewFloatValue(1, 1), tsm1.NewFloatValue(2, 2), tsm1.NewFloatValue(3, 3)}
+			if err = s.WriteSeries("my db", "autogen", []byte("cpu"), []byte("v"), values); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	migrate()
+	exp := []string{"my%20db/autogen/1/0", "my%20db/autogen/1/0", "my%20db/autogen/1/1", "my%20db/autogen/2/0", "my%20db/autogen/2/1"}
+	if !cmp.Equal(keys, exp) {
+		t.Fatalf("unexpected keys: got=%v, exp=%v", keys, exp)
+	}
+	keys = nil
+	if s := migrate(); len(keys) != 0 || s.Skipped() != 4 {
+		t.Errorf("expected all batches skipped, got %d skipped, keys %v", s.Skipped(), keys)
+	}
+}
+
+func TestArchiveSink(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := NewArchiveSink(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.CreateSchema("db", "rp"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.WriteSeries("a", "autogen", []byte("cpu"), []byte("v"), []tsm1.Value{tsm1.NewFloatValue(1, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gzr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		b, _ := io.ReadAll(tr)
+		if hdr.Name == "a/autogen.txt" && !strings.HasSuffix(string(b), "# CONTEXT-RETENTION-POLICY:autogen\ncpu v=1 1\n") {
+			t.Errorf("unexpected content of %s:\n%s", hdr.Name, b)
+		}
+	}
+	if exp := []string{"a/autogen.txt", "db/rp.txt"}; !cmp.Equal(names, exp) {
+		t.Errorf("unexpected entries: got=%v, exp=%v", names, exp)
+	}
+}
+
+func TestGroupSink(t *testing.T) {
+	// a bucket of the binary stream of transfer with two series
+	stream := func() *binary.Reader {
+		var buf bytes.Buffer
+		w := binary.NewWriter(&buf, "db", "rp", time.Hour)
+		bw, _ := w.NewBucket(0, int64(time.Hour))
+		if err := binary.WriteValuesTo([]*binary.BucketWriter{bw}, []byte("cpu"), []byte("v"), influxql.Float, models.NewTags(map[string]string{"host": "a"}), []tsm1.Value{tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2, 2.5)}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := binary.WriteValuesTo([]*binary.BucketWriter{bw}, []byte("mem"), []byte("v"), influxql.Integer, nil, []tsm1.Value{tsm1.NewIntegerValue(3, 4)}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		bw.Close()
+		w.Close()
+		r := binary.NewReader(&buf)
+		if _, err := r.ReadHeader(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.NextBucket(); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	var buf bytes.Buffer
+	ls := NewLineSink(&buf, false)
+	ls.CreateSchema("db", "rp")
+	if err := CopyBucket(ls, "db", "rp", stream()); err != nil {
+		t.Fatal(err)
+	}
+	ls.Close()
+	if !strings.HasSuffix(buf.String(), "cpu,host=a v=1.5 1\ncpu,host=a v=2.5 2\nmem v=4i 3\n") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+
+	dir := t.TempDir()
+	svr, err := server.NewServer(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	imp, err := shard.NewImporter(svr, "db", "rp", time.Hour, 0, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imp.Close()
+	for i, created := range []bool{true, false} {
+		s, err := NewGroupSink(shard.NewImportWorker(imp), 0, int64(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.CreateSchema("db", "autogen"); err == nil {
+			t.Error("expected error of the schema of another retention policy")
+		}
+		if err = CopyBucket(s, "db", "rp", stream()); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if s.Created() != created {
+			t.Errorf("unexpected created of write %d: got=%t, exp=%t", i, s.Created(), created)
+		}
+	}
+	// the shard group written twice has a tsm file of each write
+	files, _ := filepath.Glob(filepath.Join(dir, "data", "db", "rp", "*", "*.tsm"))
+	if len(files) != 2 {
+		t.Errorf("unexpected tsm files: %v", files)
+	}
+}
@@ -0,0 +1,136 @@
+// Package snapshot copies the meta of an offline influxdb directory, and optionally the shards to be touched, into a
+// timestamped backup directory before an operation mutates them, so that a failed operation is rolled back by copying
+// them back.
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// Directory is the directory of the snapshots in an influxdb directory, beside meta, data and wal.
+	Directory = ".influx-tool-backup"
+
+	idFormat = "20060102T150405.000Z"
+)
+
+// ID returns the id of the run at now, which names its snapshot and sorts by time.
+func ID(now time.Time) string {
+	return now.UTC().Format(idFormat)
+}
+
+// ValidID returns whether id is the id of a run.
+func ValidID(id string) bool {
+	_, err := time.Parse(idFormat, id)
+	return err == nil
+}
+
+// Path returns the backup directory of the run id in the influxdb directory dir.
+func Path(dir, id string) string {
+	return filepath.Join(dir, Directory, id)
+}
+
+// MetaPath returns the path of the meta of the influxdb directory dir.
+func MetaPath(dir string) string {
+	return filepath.Join(dir, "meta", "meta.db")
+}
+
+// Exists returns whether the influxdb directory dir has a meta to snapshot, which is missing in a new target.
+func Exists(dir string) bool {
+	return exists(MetaPath(dir))
+}
+
+// Take copies the meta of the influxdb directory dir, and the shard directories in paths relative to dir like
+// data/db/rp/1 if any, into the backup directory of the run id, which is returned. The paths missing are skipped.
+// Nothing is taken and empty is returned if dir has no meta.
+func Take(dir, id string, paths []string) (string, error) {
+	if !Exists(dir) {
+		return "", nil
+	}
+	backupDir := Path(dir, id)
+	if exists(backupDir) {
+		return "", fmt.Errorf("backup directory %s already exists", backupDir)
+	}
+	if err := copyFile(MetaPath(dir), filepath.Join(backupDir, "meta", "meta.db")); err != nil {
+		os.RemoveAll(backupDir)
+		return "", err
+	}
+	for _, path := range paths {
+		if err := copyDir(filepath.Join(dir, path), filepath.Join(backupDir, path)); err != nil {
+			os.RemoveAll(backupDir)
+			return "", err
+		}
+	}
+	return backupDir, nil
+}
+
+// Restore replaces the file or directory of path relative to the influxdb directory dir by the one in the backup
+// directory of the run id.
+func Restore(dir, id, path string) error {
+	src := filepath.Join(Path(dir, id), path)
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, path)
+	if err = os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return copyDir(src, dst)
+	}
+	return copyFile(src, dst)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// copyDir copies the files under src into dst recursively, nothing is copied if src is missing.
+func copyDir(src, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		return copyFile(path, filepath.Join(dst, rel))
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,68 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	dir := t.TempDir()
+	id := ID(time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC))
+	if path, err := Take(dir, id, nil); err != nil || path != "" {
+		t.Fatalf("snapshot taken without meta: %q, %v", path, err)
+	}
+
+	writeFile(t, MetaPath(dir), "meta")
+	writeFile(t, filepath.Join(dir, "data", "db", "rp", "1", "000000001-000000001.tsm"), "tsm")
+	writeFile(t, filepath.Join(dir, "data", "db", "rp", "1", "index", "0", "L0-00000001.tsl"), "tsl")
+	writeFile(t, filepath.Join(dir, "data", "db", "rp", "2", "000000001-000000001.tsm"), "untouched")
+	path, err := Take(dir, id, []string{filepath.Join("data", "db", "rp", "1"), filepath.Join("wal", "db", "rp", "1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := filepath.Join(dir, Directory, "20240102T030405.006Z"); path != exp {
+		t.Fatalf("got backup directory %s, expected %s", path, exp)
+	}
+	for name, exp := range map[string]string{
+		filepath.Join("meta", "meta.db"):                                        "meta",
+		filepath.Join("data", "db", "rp", "1", "000000001-000000001.tsm"):       "tsm",
+		filepath.Join("data", "db", "rp", "1", "index", "0", "L0-00000001.tsl"): "tsl",
+	} {
+		b, err := os.ReadFile(filepath.Join(path, name))
+		if err != nil || string(b) != exp {
+			t.Errorf("%s: got %q, %v, expected %q", name, b, err, exp)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(path, "data", "db", "rp", "2")); !os.IsNotExist(err) {
+		t.Errorf("shard not given is copied: %v", err)
+	}
+	if _, err = Take(dir, id, nil); err == nil {
+		t.Error("backup directory overwritten")
+	}
+
+	writeFile(t, filepath.Join(dir, "data", "db", "rp", "1", "000000002-000000001.tsm"), "written")
+	if err = Restore(dir, id, filepath.Join("data", "db", "rp", "1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "data", "db", "rp", "1", "000000002-000000001.tsm")); !os.IsNotExist(err) {
+		t.Errorf("file written after snapshot is not removed by restore: %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "data", "db", "rp", "1", "000000001-000000001.tsm")); err != nil || string(b) != "tsm" {
+		t.Errorf("got %q, %v restored", b, err)
+	}
+	if !ValidID(id) || ValidID("20240102T030405Z") {
+		t.Error("unexpected validity of id")
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,163 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/chengshiwen/influx-tool/internal/backup"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+// ArchiveSource reads the tsm files of shards in backup archives, which is an influxd backup directory
+// or a tarball in portable or legacy format. The tsm files are extracted into memory one at a time.
+type ArchiveSource struct {
+	path        string
+	noTombstone bool // tombstones ignored
+	corrupt     *Corruptions
+}
+
+func NewArchiveSource(path string) *ArchiveSource {
+	return &ArchiveSource{path: path}
+}
+
+func (s *ArchiveSource) IgnoreTombstones() {
+	s.noTombstone = true
+}
+
+func (s *ArchiveSource) RecordCorruptions(c *Corruptions) {
+	s.corrupt = c
+}
+
+func (s *ArchiveSource) ListShards(db, rp string) ([]*Shard, error) {
+	shards := make(map[string]*Shard)
+	err := filepath.Walk(s.path, func(archivePath string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() || !backup.IsArchive(archivePath) {
+			return nil
+		}
+
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return backup.WalkArchive(file, archivePath, func(name string, _ io.Reader) error {
+			shardDB, shardRP, ok := backup.ShardPath(name)
+			if !ok || !matchShard(db, rp, shardDB, shardRP) {
+				return nil
+			}
+			id, ok := backup.ShardID(name)
+			if !ok {
+				return nil
+			}
+			key := filepath.Join(shardDB, shardRP, fmt.Sprint(id))
+			if shards[key] == nil {
+				shards[key] = newShard(id, shardDB, shardRP)
+			}
+			sh := shards[key]
+			if len(sh.files) == 0 || sh.files[len(sh.files)-1] != archivePath {
+				sh.files = append(sh.files, archivePath)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*Shard, 0, len(shards))
+	for _, sh := range shards {
+		// backup files are named by database, retention policy and shard, or by backup time and shard
+		sort.Strings(sh.files)
+		list = append(list, sh)
+	}
+	sortShards(list)
+	return list, nil
+}
+
+func (s *ArchiveSource) ReadSeries(sh *Shard, fn func(seriesKey, field []byte, typ influxql.DataType) error) error {
+	return s.readShard(sh, func(tr *backup.TSMReader, _ archiveFile) error {
+		for i := 0; i < tr.KeyCount(); i++ {
+			key, typ := tr.KeyAt(i)
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			if err := fn(seriesKey, field, tsm1.BlockTypeToInfluxQLDataType(typ)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ArchiveSource) ReadValues(sh *Shard, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	return s.ReadChunkedValues(sh, start, end, 0, fn)
+}
+
+func (s *ArchiveSource) ReadChunkedValues(sh *Shard, start, end int64, maxValues int, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	return s.readShard(sh, func(tr *backup.TSMReader, f archiveFile) error {
+		return readTSM(tr, f.archive, f.name, s.corrupt, start, end, maxValues, fn)
+	})
+}
+
+func (s *ArchiveSource) ReadBlocks(sh *Shard, fileFn func(minTime, maxTime int64) error, blockFn func(key []byte, minTime, maxTime int64, block []byte) error) error {
+	return s.readShard(sh, func(tr *backup.TSMReader, _ archiveFile) error {
+		if err := fileFn(tr.TimeRange()); err != nil {
+			return err
+		}
+		return tr.ReadBlocks(blockFn)
+	})
+}
+
+// readShard calls fn with each tsm file of the shard in the archives with the tombstones written before it applied
+// unless ignored, unreadable files are skipped by the corruptions.
+func (s *ArchiveSource) readShard(sh *Shard, fn func(tr *backup.TSMReader, f archiveFile) error) error {
+	walk := backup.WalkArchiveTombstones
+	if s.noTombstone {
+		walk = backup.WalkArchive
+	}
+	for _, archivePath := range sh.files {
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		tombstones := make(map[string][]byte)
+		err = walk(f, archivePath, func(name string, r io.Reader) error {
+			db, rp, ok := backup.ShardPath(name)
+			if !ok || db != sh.Database || rp != sh.RetentionPolicy {
+				return nil
+			}
+			if id, ok := backup.ShardID(name); !ok || id != sh.ID {
+				return nil
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("read %s in %s error: %v", name, archivePath, err)
+			}
+			if path.Ext(name) == "."+tsm1.TombstoneFileExtension {
+				tombstones[tombstoneKey(archivePath, name)] = b
+				return nil
+			}
+			tr, err := backup.NewTSMReader(b)
+			if err != nil {
+				return s.corrupt.skip(archivePath, name, nil, err)
+			}
+			if t, ok := tombstones[tombstoneKey(archivePath, name)]; ok {
+				if err = tr.ApplyTombstones(t); err != nil {
+					return fmt.Errorf("%s in %s: %v", name, archivePath, err)
+				}
+			}
+			return fn(tr, archiveFile{archive: archivePath, name: name})
+		})
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// CorruptSource is implemented by the sources of tsm and wal files, whose files and keys unable to be read are skipped.
+type CorruptSource interface {
+	// RecordCorruptions records the files and keys skipped into c, or fails the reads on the first one if c is strict.
+	RecordCorruptions(c *Corruptions)
+}
+
+// Corruption is a tsm or wal file, or a key of a tsm file, unable to be read.
+type Corruption struct {
+	Archive string `json:"archive,omitempty"` // archive containing the file, if known
+	File    string `json:"file"`
+	Key     string `json:"key,omitempty"` // empty if the whole file or the rest of the wal segment is skipped
+	Error   string `json:"error"`
+}
+
+// String returns what is unable to be read, such as key "cpu#!~#idle" in 000000001-000000001.tsm.
+func (c Corruption) String() string {
+	s := c.File
+	if c.Archive != "" {
+		s += " in " + c.Archive
+	}
+	if c.Key != "" {
+		s = fmt.Sprintf("key %q in %s", c.Key, s)
+	}
+	return s
+}
+
+// Corruptions records the corruptions skipped by the reads of a source, or fails the reads on the first one if strict.
+// It is safe for concurrent use, and a nil Corruptions only logs the corruptions skipped.
+type Corruptions struct {
+	strict bool
+	mu     sync.Mutex
+	list   []Corruption
+}
+
+func NewCorruptions(strict bool) *Corruptions {
+	return &Corruptions{strict: strict}
+}
+
+// List returns the corruptions skipped in the order recorded.
+func (c *Corruptions) List() []Corruption {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Corruption(nil), c.list...)
+}
+
+// Count returns the numbers of the files and the keys skipped.
+func (c *Corruptions) Count() (files, keys int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cr := range c.list {
+		if cr.Key == "" {
+			files++
+		} else {
+			keys++
+		}
+	}
+	return files, keys
+}
+
+// skip logs and records the file or key of the file in archive unable to be read by err, or returns the error if
+// strict.
+func (c *Corruptions) skip(archive, file string, key []byte, err error) error {
+	cr := Corruption{Archive: archive, File: file, Key: string(key), Error: err.Error()}
+	if c != nil && c.strict {
+		return fmt.Errorf("unable to read %s: %s", cr, cr.Error)
+	}
+	fmt.Fprintf(os.Stderr, "unable to read %s, skipping: %s\n", cr, cr.Error)
+	if c != nil {
+		c.mu.Lock()
+		c.list = append(c.list, cr)
+		c.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package source
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestFileSourceRecordCorruptions(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	shardDir := filepath.Join(dataDir, "db", "autogen", "1")
+	writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5)},
+	})
+	corruptTSM := filepath.Join(shardDir, "000000002-000000001.tsm")
+	if err := os.WriteFile(corruptTSM, []byte("not a tsm file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	walPath := filepath.Join(walDir, "db", "autogen", "1", "_00001.wal")
+	writeWALFile(t, walPath, map[string][]tsm1.Value{
+		"mem,host=b#!~#used": {tsm1.NewIntegerValue(3, 20)},
+	})
+	// the torn write at the end of the segment is skipped, and the entry before it is read
+	appendFile(t, walPath, []byte{1, 0, 0, 0, 9, 1})
+
+	s := NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := s.ListShards("db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCorruptions(false)
+	s.RecordCorruptions(c)
+	n := 0
+	err = s.ReadValues(shards[0], 0, 10, func(seriesKey, field []byte, values []tsm1.Value) error {
+		n += len(values)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("unexpected values read: %d", n)
+	}
+	list := c.List()
+	if len(list) != 2 || list[0].File != corruptTSM || list[1].File != walPath || list[0].Key != "" {
+		t.Fatalf("unexpected corruptions: %+v", list)
+	}
+	if !strings.HasPrefix(list[1].Error, "corrupt at position") {
+		t.Errorf("unexpected wal corruption: %s", list[1].Error)
+	}
+	if files, keys := c.Count(); files != 2 || keys != 0 {
+		t.Errorf("unexpected count: %d files, %d keys", files, keys)
+	}
+
+	s.RecordCorruptions(NewCorruptions(true))
+	err = s.ReadValues(shards[0], 0, 10, func(seriesKey, field []byte, values []tsm1.Value) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "unable to read "+corruptTSM) {
+		t.Errorf("unexpected error of strict: %v", err)
+	}
+	err = s.ReadMergedValues(shards[0], 0, 10, func(seriesKey, field []byte, values []tsm1.Value) error { return nil })
+	if err == nil {
+		t.Error("expected error of strict merged reads")
+	}
+}
+
+func TestCorruptionString(t *testing.T) {
+	tests := []struct {
+		c    Corruption
+		want string
+	}{
+		{Corruption{File: "a.tsm"}, "a.tsm"},
+		{Corruption{File: "a.tsm", Key: "cpu#!~#idle"}, `key "cpu#!~#idle" in a.tsm`},
+		{Corruption{Archive: "data.zip", File: "a.tsm", Key: "cpu#!~#idle"}, `key "cpu#!~#idle" in a.tsm in data.zip`},
+	}
+	for _, tt := range tests {
+		if got := tt.c.String(); got != tt.want {
+			t.Errorf("unexpected string %q, expected %q", got, tt.want)
+		}
+	}
+}
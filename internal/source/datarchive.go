@@ -0,0 +1,460 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/internal/backup"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+// DataArchiveSource reads the tsm and wal files of shards in zip or tar archives of the data and wal directories
+// of influxd without extracting them, the files are named like [prefix/]db/rp/shard/file in the archives. The zip
+// entries are read at random, while a tar archive is streamed from the start once per shard until the last file
+// of the shard, so zip archives are preferred. The tsm files are extracted into memory one at a time, unless they
+// are out of order in a tar archive, where the ones streamed early are held until their turn.
+type DataArchiveSource struct {
+	paths       []string
+	strictOrder bool
+	noTombstone bool   // tombstones ignored
+	kind        string // kind of files read, empty means all
+	files       map[*Shard]*archiveShard
+	corrupt     *Corruptions
+}
+
+// archiveShard is the files of a shard in the archives, sorted as they were written.
+type archiveShard struct {
+	tsm        []archiveFile
+	wal        []archiveFile
+	tombstones []archiveFile
+}
+
+type archiveFile struct {
+	archive string
+	name    string
+	index   int // index of the regular entry in the archive
+}
+
+// errStopArchive stops walking an archive.
+var errStopArchive = errors.New("stop walking archive")
+
+// IsDataArchive reports whether path is a zip or tar archive, which may be gzipped.
+func IsDataArchive(path string) bool {
+	return isZipArchive(path) || isTarArchive(path)
+}
+
+func isZipArchive(path string) bool {
+	return strings.HasSuffix(path, ".zip")
+}
+
+func isTarArchive(path string) bool {
+	return strings.HasSuffix(path, ".tar") || strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// NewDataArchiveSource returns a source of the archives, which contain the data directory and optionally the wal
+// directory, or are the archives of each. If strictOrder, listing shards fails once the numbers of the tsm or wal
+// files are repeated or unparsable, or wal segments are missing.
+func NewDataArchiveSource(paths []string, strictOrder bool) *DataArchiveSource {
+	return &DataArchiveSource{paths: paths, strictOrder: strictOrder}
+}
+
+func (s *DataArchiveSource) IgnoreTombstones() {
+	s.noTombstone = true
+}
+
+func (s *DataArchiveSource) SelectKind(kind string) {
+	s.kind = kind
+}
+
+func (s *DataArchiveSource) RecordCorruptions(c *Corruptions) {
+	s.corrupt = c
+}
+
+func (s *DataArchiveSource) ListShards(db, rp string) ([]*Shard, error) {
+	shards := make(map[string]*Shard)
+	tombstones := make(map[string][]archiveFile)
+	s.files = make(map[*Shard]*archiveShard)
+	for _, archive := range s.paths {
+		err := walkDataArchive(archive, func(idx int, name string, size int64, _ func() (io.ReadCloser, error)) error {
+			isTSM := path.Ext(name) == "."+tsm1.TSMFileExtension
+			isWAL := path.Ext(name) == "."+tsm1.WALFileExtension && strings.HasPrefix(path.Base(name), tsm1.WALFilePrefix)
+			isTombstone := path.Ext(name) == "."+tsm1.TombstoneFileExtension
+			if s.kind == KindTSM {
+				isWAL = false
+			} else if s.kind == KindWAL {
+				isTSM, isTombstone = false, false
+			}
+			if !isTSM && !isWAL && !isTombstone {
+				return nil
+			}
+			dirs := strings.Split(path.Clean(name), "/")
+			if len(dirs) < 4 {
+				return fmt.Errorf("invalid directory structure for %s in %s", name, archive)
+			}
+			dirs = dirs[len(dirs)-4:]
+			if !matchShard(db, rp, dirs[0], dirs[1]) {
+				return nil
+			}
+			id, err := strconv.ParseUint(dirs[2], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid directory structure for %s in %s", name, archive)
+			}
+			key := path.Join(dirs[0], dirs[1], dirs[2])
+			// the tombstones may be walked before the tsm files, and are attached to the shards listed at last
+			if isTombstone {
+				tombstones[key] = append(tombstones[key], archiveFile{archive: archive, name: name, index: idx})
+				return nil
+			}
+			if shards[key] == nil {
+				shards[key] = newShard(id, dirs[0], dirs[1])
+				s.files[shards[key]] = &archiveShard{}
+			}
+			sh := shards[key]
+			sh.size += size
+			f := archiveFile{archive: archive, name: name, index: idx}
+			if isTSM {
+				s.files[sh].tsm = append(s.files[sh].tsm, f)
+			} else {
+				s.files[sh].wal = append(s.files[sh].wal, f)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]*Shard, 0, len(shards))
+	for key, sh := range shards {
+		af := s.files[sh]
+		af.tombstones = tombstones[key]
+		var err error
+		if af.tsm, err = sortArchiveFiles(af.tsm, s.strictOrder, SortTSMFiles); err != nil {
+			return nil, fmt.Errorf("tsm files of shard %d in %s.%s out of order: %v", sh.ID, sh.Database, sh.RetentionPolicy, err)
+		}
+		if af.wal, err = sortArchiveFiles(af.wal, s.strictOrder, SortWALFiles); err != nil {
+			return nil, fmt.Errorf("wal files of shard %d in %s.%s out of order: %v", sh.ID, sh.Database, sh.RetentionPolicy, err)
+		}
+		list = append(list, sh)
+	}
+	sortShards(list)
+	return list, nil
+}
+
+// sortArchiveFiles sorts the files by their names like sort.
+func sortArchiveFiles(files []archiveFile, strict bool, sort func(files []string, strict bool) error) ([]archiveFile, error) {
+	byName := make(map[string]archiveFile, len(files))
+	names := make([]string, len(files))
+	for i, f := range files {
+		key := filepath.Join(f.archive, f.name)
+		byName[key], names[i] = f, key
+	}
+	if err := sort(names, strict); err != nil {
+		return nil, err
+	}
+	for i, key := range names {
+		files[i] = byName[key]
+	}
+	return files, nil
+}
+
+func (s *DataArchiveSource) ReadSeries(sh *Shard, fn func(seriesKey, field []byte, typ influxql.DataType) error) error {
+	err := s.readTSM(sh, func(tr *backup.TSMReader, _ archiveFile) error {
+		for i := 0; i < tr.KeyCount(); i++ {
+			key, typ := tr.KeyAt(i)
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			if err := fn(seriesKey, field, tsm1.BlockTypeToInfluxQLDataType(typ)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.readWAL(sh, func(files []string, open openFunc) error {
+		return readWALFiles(files, open, s.corrupt, func(key []byte, values []tsm1.Value) error {
+			if len(values) == 0 {
+				return nil
+			}
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			return fn(seriesKey, field, ValueType(values[0]))
+		})
+	})
+}
+
+func (s *DataArchiveSource) ReadValues(sh *Shard, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	return s.ReadChunkedValues(sh, start, end, 0, fn)
+}
+
+func (s *DataArchiveSource) ReadChunkedValues(sh *Shard, start, end int64, maxValues int, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	err := s.readTSM(sh, func(tr *backup.TSMReader, f archiveFile) error {
+		return readTSM(tr, f.archive, f.name, s.corrupt, start, end, maxValues, fn)
+	})
+	if err != nil {
+		return err
+	}
+	return s.readWAL(sh, func(files []string, open openFunc) error {
+		return readWALFiles(files, open, s.corrupt, func(key []byte, values []tsm1.Value) error {
+			if values = filterValues(values, start, end); len(values) == 0 {
+				return nil
+			}
+			seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+			return fn(seriesKey, field, values)
+		})
+	})
+}
+
+func (s *DataArchiveSource) ReadDeletes(sh *Shard, fn func(seriesKeys [][]byte, min, max int64) error) error {
+	return s.readWAL(sh, func(files []string, open openFunc) error {
+		return readWALDeletes(files, open, s.corrupt, fn)
+	})
+}
+
+func (s *DataArchiveSource) ReadBlocks(sh *Shard, fileFn func(minTime, maxTime int64) error, blockFn func(key []byte, minTime, maxTime int64, block []byte) error) error {
+	err := s.readTSM(sh, func(tr *backup.TSMReader, _ archiveFile) error {
+		if err := fileFn(tr.TimeRange()); err != nil {
+			return err
+		}
+		return tr.ReadBlocks(blockFn)
+	})
+	if err != nil {
+		return err
+	}
+	return s.readWAL(sh, func(files []string, open openFunc) error {
+		return readWALBlocks(files, open, s.corrupt, fileFn, blockFn)
+	})
+}
+
+// readTSM calls fn with each tsm file of the shard with its tombstones applied unless ignored, unreadable files are
+// skipped by the corruptions.
+func (s *DataArchiveSource) readTSM(sh *Shard, fn func(tr *backup.TSMReader, f archiveFile) error) error {
+	tombstones := make(map[string][]byte)
+	if !s.noTombstone && len(s.files[sh].tombstones) > 0 {
+		err := extractFiles(s.files[sh].tombstones, func(f archiveFile, r io.Reader) error {
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("read %s in %s error: %v", f.name, f.archive, err)
+			}
+			tombstones[tombstoneKey(f.archive, f.name)] = b
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return extractFiles(s.files[sh].tsm, func(f archiveFile, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read %s in %s error: %v", f.name, f.archive, err)
+		}
+		tr, err := backup.NewTSMReader(b)
+		if err != nil {
+			return s.corrupt.skip(f.archive, f.name, nil, err)
+		}
+		if t, ok := tombstones[tombstoneKey(f.archive, f.name)]; ok {
+			if err = tr.ApplyTombstones(t); err != nil {
+				return fmt.Errorf("%s in %s: %v", f.name, f.archive, err)
+			}
+		}
+		return fn(tr, f)
+	})
+}
+
+// tombstoneKey returns the key of the tombstone file of a tsm file, which is the tsm or tombstone file named without
+// the extension.
+func tombstoneKey(archive, name string) string {
+	return archive + ":" + strings.TrimSuffix(name, path.Ext(name))
+}
+
+// readWAL extracts the wal segments of the shard into memory, then calls fn with the segments sorted and opened.
+func (s *DataArchiveSource) readWAL(sh *Shard, fn func(files []string, open openFunc) error) error {
+	wal := s.files[sh].wal
+	if len(wal) == 0 {
+		return nil
+	}
+	segments := make(map[string][]byte, len(wal))
+	err := extractFiles(wal, func(f archiveFile, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read %s in %s error: %v", f.name, f.archive, err)
+		}
+		segments[f.name] = b
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(wal))
+	for i, f := range wal {
+		names[i] = f.name
+	}
+	return fn(names, func(name string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(segments[name])), nil
+	})
+}
+
+// extractFiles calls fn with the reader of each file in the given order. The zip entries are read at random, and a
+// tar archive is streamed until the last file, the files streamed before their turn are held in memory till then.
+func extractFiles(files []archiveFile, fn func(f archiveFile, r io.Reader) error) error {
+	var archives []string
+	byArchive := make(map[string][]archiveFile)
+	for _, f := range files {
+		if byArchive[f.archive] == nil {
+			archives = append(archives, f.archive)
+		}
+		byArchive[f.archive] = append(byArchive[f.archive], f)
+	}
+	for _, archive := range archives {
+		var err error
+		if isZipArchive(archive) {
+			err = extractZip(archive, byArchive[archive], fn)
+		} else {
+			err = extractTar(archive, byArchive[archive], fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTar calls fn with the reader of each file in the tar archive in the given order.
+func extractTar(archive string, files []archiveFile, fn func(f archiveFile, r io.Reader) error) error {
+	order := make(map[int]int, len(files)) // order of the files by index in archive
+	last := 0
+	for i, f := range files {
+		order[f.index] = i
+		if f.index > last {
+			last = f.index
+		}
+	}
+	next := 0
+	pending := make(map[int][]byte)
+	return walkDataArchive(archive, func(idx int, _ string, _ int64, open func() (io.ReadCloser, error)) error {
+		if i, ok := order[idx]; ok {
+			r, err := open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			if i != next {
+				b, err := io.ReadAll(r)
+				if err != nil {
+					return fmt.Errorf("read %s in %s error: %v", files[i].name, archive, err)
+				}
+				pending[i] = b
+			} else if err = fn(files[i], r); err != nil {
+				return err
+			} else {
+				next++
+			}
+			for b, ok := pending[next]; ok; b, ok = pending[next] {
+				delete(pending, next)
+				if err = fn(files[next], bytes.NewReader(b)); err != nil {
+					return err
+				}
+				next++
+			}
+		}
+		if idx >= last {
+			return errStopArchive
+		}
+		return nil
+	})
+}
+
+// extractZip calls fn with the reader of each file in the zip archive in the given order.
+func extractZip(archive string, files []archiveFile, fn func(f archiveFile, r io.Reader) error) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("read archive %s error: %v", archive, err)
+	}
+	defer zr.Close()
+	for _, f := range files {
+		if f.archive != archive {
+			continue
+		}
+		if f.index >= len(zr.File) {
+			return fmt.Errorf("read %s in %s error: archive changed", f.name, archive)
+		}
+		r, err := zr.File[f.index].Open()
+		if err != nil {
+			return fmt.Errorf("read %s in %s error: %v", f.name, archive, err)
+		}
+		err = fn(f, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDataArchive calls fn with the index, name and size of each regular entry in the zip or tar archive, fn may
+// open the entry to read until it returns. Walking stops once fn returns errStopArchive, which is not returned.
+func walkDataArchive(archive string, fn func(idx int, name string, size int64, open func() (io.ReadCloser, error)) error) error {
+	if isZipArchive(archive) {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return fmt.Errorf("read archive %s error: %v", archive, err)
+		}
+		defer zr.Close()
+		for idx, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			if err = fn(idx, f.Name, int64(f.UncompressedSize64), f.Open); err != nil {
+				if err == errStopArchive {
+					return nil
+				}
+				return err
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if !strings.HasSuffix(archive, ".tar") {
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("read archive %s error: %v", archive, err)
+		}
+		defer gr.Close()
+		r = gr
+	}
+	tr := tar.NewReader(r)
+	for idx := 0; ; idx++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read archive %s error: %v", archive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = fn(idx, hdr.Name, hdr.Size, func() (io.ReadCloser, error) { return io.NopCloser(tr), nil })
+		if err == errStopArchive {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
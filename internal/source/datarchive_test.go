@@ -0,0 +1,198 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestDataArchiveSource(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "influxdb")
+	dataDir, walDir := filepath.Join(root, "data"), filepath.Join(root, "wal")
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "2", "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(5, 3.5)},
+	})
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(5, 2.5)},
+	})
+	writeTSMFile(t, filepath.Join(dataDir, "db", "rp", "10", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"mem,host=a#!~#used": {tsm1.NewIntegerValue(2, 10)},
+	})
+	// the value at 1 is deleted, and the block is re-encoded without it
+	ts := tsm1.NewTombstoner(filepath.Join(dataDir, "db", "autogen", "2", "000000001-000000001.tsm"), nil)
+	if err := ts.AddRange([][]byte{[]byte("cpu,host=a#!~#usage")}, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	writeWALFile(t, filepath.Join(walDir, "db", "rp", "10", "_00002.wal"), map[string][]tsm1.Value{
+		"mem,host=b#!~#used": {tsm1.NewIntegerValue(3, 30)},
+	})
+	writeWALFile(t, filepath.Join(walDir, "db", "rp", "10", "_00001.wal"), map[string][]tsm1.Value{
+		"mem,host=b#!~#used": {tsm1.NewIntegerValue(3, 20)},
+	})
+
+	exp := readAll(t, NewFileSource(dataDir, walDir, false, tsmread.ModeMmap))
+	if got := readAll(t, NewFileSource(dataDir, walDir, false, tsmread.ModeBuffered)); !cmp.Equal(got, exp) {
+		t.Errorf("buffered: unexpected values: got=%v, exp=%v", got, exp)
+	}
+	for _, line := range []string{"cpu,host=a usage=1.5 1", "cpu,host=a#!~#usage 1-5"} {
+		if containsString(exp, line) {
+			t.Errorf("unexpected %q deleted by tombstone: %v", line, exp)
+		}
+	}
+	fs := NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	fs.IgnoreTombstones()
+	expIgnored := readAll(t, fs)
+	if !containsString(expIgnored, "cpu,host=a usage=1.5 1") {
+		t.Errorf("expect the value deleted by tombstone: %v", expIgnored)
+	}
+	zipPath, tarPath := filepath.Join(dir, "influxdb.zip"), filepath.Join(dir, "influxdb.tar.gz")
+	writeZip(t, zipPath, root)
+	writeTarGz(t, tarPath, root)
+	for _, path := range []string{zipPath, tarPath} {
+		if !IsDataArchive(path) {
+			t.Fatalf("%s: expect data archive", path)
+		}
+		if got := readAll(t, NewDataArchiveSource([]string{path}, false)); !cmp.Equal(got, exp) {
+			t.Errorf("%s: unexpected values: got=%v, exp=%v", path, got, exp)
+		}
+		s := NewDataArchiveSource([]string{path}, false)
+		s.IgnoreTombstones()
+		if got := readAll(t, s); !cmp.Equal(got, expIgnored) {
+			t.Errorf("%s: unexpected values ignoring tombstones: got=%v, exp=%v", path, got, expIgnored)
+		}
+	}
+	if _, err := NewDataArchiveSource([]string{zipPath}, true).ListShards("db", ""); err != nil {
+		t.Error(err)
+	}
+}
+
+// readAll returns the shards, values and blocks read from the source.
+func readAll(t *testing.T, s Source) []string {
+	shards, err := s.ListShards("db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, sh := range shards {
+		got = append(got, fmt.Sprintf("shard %s.%s.%d size>0=%v", sh.Database, sh.RetentionPolicy, sh.ID, sh.Size() > 0))
+		err = s.ReadValues(sh, 0, 10, func(seriesKey, field []byte, values []tsm1.Value) error {
+			for _, v := range values {
+				got = append(got, fmt.Sprintf("%s %s=%v %d", seriesKey, field, v.Value(), v.UnixNano()))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.(BlockSource).ReadBlocks(sh, func(minTime, maxTime int64) error {
+			got = append(got, fmt.Sprintf("file %d-%d", minTime, maxTime))
+			return nil
+		}, func(key []byte, minTime, maxTime int64, block []byte) error {
+			got = append(got, fmt.Sprintf("%s %d-%d", key, minTime, maxTime))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return got
+}
+
+// walkFiles calls fn with the files under root in reverse order of names, so that the archives are out of order.
+func walkFiles(t *testing.T, root string, fn func(name, path string)) {
+	var paths []string
+	err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err == nil && !f.IsDir() {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := len(paths) - 1; i >= 0; i-- {
+		name, err := filepath.Rel(filepath.Dir(root), paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn(filepath.ToSlash(name), paths[i])
+	}
+}
+
+func writeZip(t *testing.T, path, root string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	walkFiles(t, root, func(name, path string) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copyFile(t, w, path)
+	})
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, path, root string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	walkFiles(t, root, func(name, path string) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: fi.Size(), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		copyFile(t, tw, path)
+	})
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyFile(t *testing.T, w io.Writer, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = io.Copy(w, f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,495 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chengshiwen/influx-tool/internal/ratelimit"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+// FileSource reads the tsm and wal files of shards in the data and wal directories of influxd.
+type FileSource struct {
+	dataDir     string
+	walDir      string
+	strictOrder bool
+	readMode    string
+	noTombstone bool   // tombstones ignored
+	kind        string // kind of files read, empty means all
+	limiter     *ratelimit.Limiter
+	corrupt     *Corruptions
+}
+
+// NewFileSource returns a source of the data and wal directories, whose tsm files are read in readMode of tsmread.
+// If strictOrder, listing shards fails once the numbers of the tsm or wal files are repeated or unparsable, or wal
+// segments are missing between the others.
+func NewFileSource(dataDir, walDir string, strictOrder bool, readMode string) *FileSource {
+	return &FileSource{dataDir: dataDir, walDir: walDir, strictOrder: strictOrder, readMode: readMode}
+}
+
+func (s *FileSource) IgnoreTombstones() {
+	s.noTombstone = true
+}
+
+func (s *FileSource) SelectKind(kind string) {
+	s.kind = kind
+}
+
+func (s *FileSource) RecordCorruptions(c *Corruptions) {
+	s.corrupt = c
+}
+
+// open opens the tsm file of path in the read mode, or without its tombstones if ignored.
+func (s *FileSource) open(path string) (tsmread.File, error) {
+	var r tsmread.File
+	var err error
+	if s.noTombstone {
+		r, err = tsmread.OpenIgnoringTombstones(path)
+	} else {
+		r, err = tsmread.Open(path, s.readMode)
+	}
+	if err != nil || s.limiter == nil {
+		return r, err
+	}
+	return &limitedFile{File: r, l: s.limiter}, nil
+}
+
+func (s *FileSource) ListShards(db, rp string) ([]*Shard, error) {
+	shards := make(map[string]*Shard)
+	walk := func(dir string, match func(path string) bool, add func(sh *Shard, path string)) error {
+		return filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if f.IsDir() || !match(path) {
+				return nil
+			}
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			dirs := strings.Split(relPath, string(os.PathSeparator))
+			if len(dirs) < 4 {
+				return fmt.Errorf("invalid directory structure for %s", path)
+			}
+			if !matchShard(db, rp, dirs[0], dirs[1]) {
+				return nil
+			}
+			id, err := strconv.ParseUint(dirs[2], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid directory structure for %s", path)
+			}
+			key := filepath.Join(dirs[0], dirs[1], dirs[2])
+			if shards[key] == nil {
+				shards[key] = newShard(id, dirs[0], dirs[1])
+			}
+			add(shards[key], path)
+			return nil
+		})
+	}
+
+	if s.kind != KindWAL {
+		err := walk(s.dataDir, func(path string) bool {
+			return filepath.Ext(path) == "."+tsm1.TSMFileExtension
+		}, func(sh *Shard, path string) {
+			sh.files = append(sh.files, path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.kind != KindTSM {
+		err := walk(s.walDir, func(path string) bool {
+			return filepath.Ext(path) == "."+tsm1.WALFileExtension && strings.HasPrefix(filepath.Base(path), tsm1.WALFilePrefix)
+		}, func(sh *Shard, path string) {
+			sh.walFiles = append(sh.walFiles, path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]*Shard, 0, len(shards))
+	var err error
+	for _, sh := range shards {
+		// we need to make sure we read the same order that the files were written
+		if err = SortTSMFiles(sh.files, s.strictOrder); err != nil {
+			return nil, fmt.Errorf("tsm files of shard %d in %s.%s out of order: %v", sh.ID, sh.Database, sh.RetentionPolicy, err)
+		}
+		if err = SortWALFiles(sh.walFiles, s.strictOrder); err != nil {
+			return nil, fmt.Errorf("wal files of shard %d in %s.%s out of order: %v", sh.ID, sh.Database, sh.RetentionPolicy, err)
+		}
+		list = append(list, sh)
+	}
+	sortShards(list)
+	return list, nil
+}
+
+// DefaultPolicy reads the default retention policy of the database from the meta.db of the meta directory, which is
+// the sibling of the data directory as laid out by influxd, empty if not found.
+func (s *FileSource) DefaultPolicy(db string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(filepath.Dir(filepath.Clean(s.dataDir)), "meta", "meta.db"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	data := &meta.Data{}
+	if err = data.UnmarshalBinary(b); err != nil {
+		return "", fmt.Errorf("read meta file error: %v", err)
+	}
+	if dbi := data.Database(db); dbi != nil {
+		return dbi.DefaultRetentionPolicy, nil
+	}
+	return "", nil
+}
+
+func (s *FileSource) ReadSeries(sh *Shard, fn func(seriesKey, field []byte, typ influxql.DataType) error) error {
+	for _, path := range sh.files {
+		err := readTSMFile(path, s.open, s.corrupt, func(r tsmread.File) error {
+			for i := 0; i < r.KeyCount(); i++ {
+				key, typ := r.KeyAt(i)
+				seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+				if err := fn(seriesKey, field, tsm1.BlockTypeToInfluxQLDataType(typ)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return readWALFiles(sh.walFiles, s.openWAL, s.corrupt, func(key []byte, values []tsm1.Value) error {
+		if len(values) == 0 {
+			return nil
+		}
+		seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+		return fn(seriesKey, field, ValueType(values[0]))
+	})
+}
+
+func (s *FileSource) ReadTimeRange(sh *Shard) {
+	min, max := int64(math.MaxInt64), int64(math.MinInt64)
+	// the files unable to be read are not skipped, which are left to the reads of the values to report
+	strict := NewCorruptions(true)
+	for _, path := range sh.files {
+		err := readTSMFile(path, s.open, strict, func(r tsmread.File) error {
+			fmin, fmax := r.TimeRange()
+			if fmin < min {
+				min = fmin
+			}
+			if fmax > max {
+				max = fmax
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	for _, path := range sh.walFiles {
+		err := readWALFile(path, s.openWAL, strict, func(entry tsm1.WALEntry) error {
+			if t, ok := entry.(*tsm1.WriteWALEntry); ok {
+				for _, values := range t.Values {
+					for _, v := range values {
+						ts := v.UnixNano()
+						if ts < min {
+							min = ts
+						}
+						if ts > max {
+							max = ts
+						}
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	if min <= max {
+		sh.StartTime, sh.EndTime = min, max
+	}
+}
+
+func (s *FileSource) ReadValues(sh *Shard, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	return s.ReadChunkedValues(sh, start, end, 0, fn)
+}
+
+func (s *FileSource) ReadChunkedValues(sh *Shard, start, end int64, maxValues int, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	for _, path := range sh.files {
+		err := readTSMFile(path, s.open, s.corrupt, func(r tsmread.File) error {
+			return readTSM(r, "", path, s.corrupt, start, end, maxValues, fn)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return readWALFiles(sh.walFiles, s.openWAL, s.corrupt, func(key []byte, values []tsm1.Value) error {
+		if values = filterValues(values, start, end); len(values) == 0 {
+			return nil
+		}
+		seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+		return fn(seriesKey, field, values)
+	})
+}
+
+func (s *FileSource) ReadIndexes(sh *Shard, fn func(key []byte, typ byte, entries []tsm1.IndexEntry) error) error {
+	var entries []tsm1.IndexEntry
+	for _, path := range sh.files {
+		err := readTSMFile(path, s.open, s.corrupt, func(r tsmread.File) error {
+			for i := 0; i < r.KeyCount(); i++ {
+				key, typ := r.KeyAt(i)
+				if err := fn(key, typ, r.ReadEntries(key, &entries)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileSource) ReadDeletes(sh *Shard, fn func(seriesKeys [][]byte, min, max int64) error) error {
+	return readWALDeletes(sh.walFiles, s.openWAL, s.corrupt, fn)
+}
+
+func (s *FileSource) ReadBlocks(sh *Shard, fileFn func(minTime, maxTime int64) error, blockFn func(key []byte, minTime, maxTime int64, block []byte) error) error {
+	for _, path := range sh.files {
+		err := readTSMFile(path, s.open, s.corrupt, func(r tsmread.File) error {
+			if err := fileFn(r.TimeRange()); err != nil {
+				return err
+			}
+			return r.ReadBlocks(blockFn)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return readWALBlocks(sh.walFiles, s.openWAL, s.corrupt, fileFn, blockFn)
+}
+
+// readWALBlocks reads the values in the wal files like a tsm file, the values of each key are sorted and deduplicated,
+// then encoded into blocks of at most the default points per block.
+func readWALBlocks(files []string, open openFunc, c *Corruptions, fileFn func(minTime, maxTime int64) error, blockFn func(key []byte, minTime, maxTime int64, block []byte) error) error {
+	cache := make(map[string]tsm1.Values)
+	err := readWALFiles(files, open, c, func(key []byte, values []tsm1.Value) error {
+		cache[string(key)] = append(cache[string(key)], values...)
+		return nil
+	})
+	if err != nil || len(cache) == 0 {
+		return err
+	}
+
+	keys := make([]string, 0, len(cache))
+	minTime, maxTime := int64(math.MaxInt64), int64(math.MinInt64)
+	for key, values := range cache {
+		values = values.Deduplicate()
+		cache[key] = values
+		keys = append(keys, key)
+		if t := values.MinTime(); t < minTime {
+			minTime = t
+		}
+		if t := values.MaxTime(); t > maxTime {
+			maxTime = t
+		}
+	}
+	sort.Strings(keys)
+
+	if err = fileFn(minTime, maxTime); err != nil {
+		return err
+	}
+	var block []byte
+	for _, key := range keys {
+		values := cache[key]
+		for len(values) > 0 {
+			n := len(values)
+			if n > tsdb.DefaultMaxPointsPerBlock {
+				n = tsdb.DefaultMaxPointsPerBlock
+			}
+			if block, err = values[:n].Encode(block[:0]); err != nil {
+				return fmt.Errorf("encode wal values of %s error: %v", key, err)
+			}
+			if err = blockFn([]byte(key), values[0].UnixNano(), values[n-1].UnixNano(), block); err != nil {
+				return err
+			}
+			values = values[n:]
+		}
+	}
+	return nil
+}
+
+// readTSMFile opens the tsm file by open for fn, missing files are skipped, and unreadable files are skipped by c.
+func readTSMFile(path string, open func(path string) (tsmread.File, error), c *Corruptions, fn func(r tsmread.File) error) error {
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "skipped missing file: %s\n", path)
+			return nil
+		}
+		return err
+	}
+
+	r, err := open(path)
+	if err != nil {
+		return c.skip("", path, nil, err)
+	}
+	defer r.Close()
+
+	return fn(r)
+}
+
+// tsmReader is implemented by tsmread.File and by backup.TSMReader for tsm files extracted from backups.
+type tsmReader interface {
+	KeyCount() int
+	KeyAt(idx int) ([]byte, byte)
+	ReadChunks(key []byte, start, end int64, maxValues int, fn func(values []tsm1.Value) error) error
+	TimeRange() (int64, int64)
+}
+
+// readTSM calls fn with the values of each key in the tsm file within the time range [start, end], in chunks of
+// about maxValues values if not 0. The rest of a key unable to be read is skipped by c.
+func readTSM(r tsmReader, archive, path string, c *Corruptions, start, end int64, maxValues int, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	if minTime, maxTime := r.TimeRange(); minTime > end || maxTime < start {
+		return nil
+	}
+
+	for i := 0; i < r.KeyCount(); i++ {
+		key, _ := r.KeyAt(i)
+		seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+		var fnErr error
+		err := r.ReadChunks(key, start, end, maxValues, func(values []tsm1.Value) error {
+			fnErr = fn(seriesKey, field, values)
+			return fnErr
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+		if err != nil {
+			if err = c.skip(archive, path, key, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// openFunc opens a file by name, a nil reader is returned to skip a missing file.
+type openFunc func(name string) (io.ReadCloser, error)
+
+// openFile opens the file of path, missing files are skipped.
+func openFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "skipped missing file: %s\n", path)
+			return nil, nil
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// readWALFiles calls fn with the values of each write entry in the wal files, in the order the wal received the data.
+// The rest of a segment unable to be read is skipped by c.
+func readWALFiles(files []string, open openFunc, c *Corruptions, fn func(key []byte, values []tsm1.Value) error) error {
+	warned := false
+	for _, path := range files {
+		err := readWALFile(path, open, c, func(entry tsm1.WALEntry) error {
+			switch t := entry.(type) {
+			case *tsm1.DeleteWALEntry, *tsm1.DeleteRangeWALEntry:
+				if !warned {
+					warned = true
+					fmt.Fprintf(os.Stderr, `WARNING: detected deletes in wal file %s.
+Some series may be brought back by replaying this data.
+To resolve, you can either let the shard snapshot prior to reading the data
+or manually editing the read data.
+`, path)
+				}
+			case *tsm1.WriteWALEntry:
+				for key, values := range t.Values {
+					if err := fn([]byte(key), values); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readWALDeletes calls fn with the series keys and the time range of each delete entry in the wal files, the keys of
+// the entries are the keys of series and fields, which are reduced to the series.
+func readWALDeletes(files []string, open openFunc, c *Corruptions, fn func(seriesKeys [][]byte, min, max int64) error) error {
+	for _, path := range files {
+		err := readWALFile(path, open, c, func(entry tsm1.WALEntry) error {
+			switch t := entry.(type) {
+			case *tsm1.DeleteWALEntry:
+				return fn(seriesKeys(t.Keys), math.MinInt64, math.MaxInt64)
+			case *tsm1.DeleteRangeWALEntry:
+				return fn(seriesKeys(t.Keys), t.Min, t.Max)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesKeys returns the distinct series keys of the keys in order.
+func seriesKeys(keys [][]byte) [][]byte {
+	seen := make(map[string]struct{}, len(keys))
+	series := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		seriesKey, _ := tsm1.SeriesAndFieldFromCompositeKey(key)
+		if _, ok := seen[string(seriesKey)]; ok {
+			continue
+		}
+		seen[string(seriesKey)] = struct{}{}
+		series = append(series, seriesKey)
+	}
+	return series
+}
+
+func readWALFile(path string, open openFunc, c *Corruptions, fn func(entry tsm1.WALEntry) error) error {
+	f, err := open(path)
+	if err != nil || f == nil {
+		return err
+	}
+	defer f.Close()
+
+	r := tsm1.NewWALSegmentReader(f)
+	defer r.Close()
+
+	for r.Next() {
+		entry, err := r.Read()
+		if err != nil {
+			return c.skip("", path, nil, fmt.Errorf("corrupt at position %d: %v", r.Count(), err))
+		}
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+const httpChunkSize = 10000
+
+// HTTPSource reads shards from a live server by chunked queries. The series of a shard are those of its retention
+// policy, and the values are queried by measurement within the time range of the shard. It is not safe for concurrent use.
+type HTTPSource struct {
+	client *client.Client
+	fields map[policyKey]map[string]map[string]string // field types by retention policy, measurement and field
+}
+
+type policyKey struct {
+	db, rp string
+}
+
+// NewHTTPSource returns a source reading from the server of the client, whose precision must be "ns".
+func NewHTTPSource(c *client.Client) *HTTPSource {
+	return &HTTPSource{client: c, fields: make(map[policyKey]map[string]map[string]string)}
+}
+
+func (s *HTTPSource) ListShards(db, rp string) ([]*Shard, error) {
+	results, err := s.query("SHOW SHARDS", "")
+	if err != nil {
+		return nil, err
+	}
+	var shards []*Shard
+	for _, result := range results {
+		for _, row := range result.Series {
+			columns := make(map[string]int, len(row.Columns))
+			for i, c := range row.Columns {
+				columns[c] = i
+			}
+			for _, v := range row.Values {
+				get := func(c string) interface{} {
+					if i, ok := columns[c]; ok && i < len(v) {
+						return v[i]
+					}
+					return nil
+				}
+				shardDB, _ := get("database").(string)
+				shardRP, _ := get("retention_policy").(string)
+				if !matchShard(db, rp, shardDB, shardRP) {
+					continue
+				}
+				n, _ := get("id").(json.Number)
+				id, err := strconv.ParseUint(string(n), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid shard id %v of %s.%s", get("id"), shardDB, shardRP)
+				}
+				sh := newShard(id, shardDB, shardRP)
+				if t, err := time.Parse(time.RFC3339Nano, fmt.Sprint(get("start_time"))); err == nil {
+					sh.StartTime = t.UnixNano()
+				}
+				if t, err := time.Parse(time.RFC3339Nano, fmt.Sprint(get("end_time"))); err == nil {
+					sh.EndTime = t.UnixNano() - 1
+				}
+				shards = append(shards, sh)
+			}
+		}
+	}
+	sortShards(shards)
+	return shards, nil
+}
+
+func (s *HTTPSource) DefaultPolicy(db string) (string, error) {
+	results, err := s.query("SHOW RETENTION POLICIES ON "+influxql.QuoteIdent(db), "")
+	if err != nil {
+		return "", err
+	}
+	for _, result := range results {
+		for _, row := range result.Series {
+			name, def := -1, -1
+			for i, c := range row.Columns {
+				switch c {
+				case "name":
+					name = i
+				case "default":
+					def = i
+				}
+			}
+			if name < 0 || def < 0 {
+				continue
+			}
+			for _, v := range row.Values {
+				if len(v) <= name || len(v) <= def {
+					continue
+				}
+				if isDefault, _ := v[def].(bool); isDefault {
+					rp, _ := v[name].(string)
+					return rp, nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+func (s *HTTPSource) ReadSeries(sh *Shard, fn func(seriesKey, field []byte, typ influxql.DataType) error) error {
+	fields, err := s.fieldTypes(sh.Database, sh.RetentionPolicy)
+	if err != nil {
+		return err
+	}
+	stmt := fmt.Sprintf("SHOW SERIES ON %s FROM %s./.*/", influxql.QuoteIdent(sh.Database), influxql.QuoteIdent(sh.RetentionPolicy))
+	results, err := s.query(stmt, sh.Database)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		for _, row := range result.Series {
+			for _, v := range row.Values {
+				if len(v) == 0 {
+					continue
+				}
+				key, _ := v[0].(string)
+				for field, typ := range fields[string(models.ParseName([]byte(key)))] {
+					if err = fn([]byte(key), []byte(field), influxql.DataTypeFromString(typ)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *HTTPSource) ReadValues(sh *Shard, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	if start < sh.StartTime {
+		start = sh.StartTime
+	}
+	if end > sh.EndTime {
+		end = sh.EndTime
+	}
+	if start > end {
+		return nil
+	}
+	fields, err := s.fieldTypes(sh.Database, sh.RetentionPolicy)
+	if err != nil {
+		return err
+	}
+	for m, types := range fields {
+		stmt := fmt.Sprintf("SELECT * FROM %s WHERE time >= %d AND time <= %d GROUP BY *", influxql.QuoteIdent(sh.RetentionPolicy, m), start, end)
+		results, err := s.query(stmt, sh.Database)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			for _, row := range result.Series {
+				seriesKey := models.MakeKey([]byte(row.Name), models.NewTags(row.Tags))
+				for i := 1; i < len(row.Columns); i++ {
+					values, err := columnValues(row.Values, i, types[row.Columns[i]])
+					if err != nil {
+						return fmt.Errorf("read %s field %s error: %v", seriesKey, row.Columns[i], err)
+					}
+					if len(values) == 0 {
+						continue
+					}
+					if err = fn(seriesKey, []byte(row.Columns[i]), values); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fieldTypes returns the field types by measurement and field of the retention policy, which are queried once.
+func (s *HTTPSource) fieldTypes(db, rp string) (map[string]map[string]string, error) {
+	if fields, ok := s.fields[policyKey{db, rp}]; ok {
+		return fields, nil
+	}
+	stmt := fmt.Sprintf("SHOW FIELD KEYS ON %s FROM %s./.*/", influxql.QuoteIdent(db), influxql.QuoteIdent(rp))
+	results, err := s.query(stmt, db)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]map[string]string)
+	for _, result := range results {
+		for _, row := range result.Series {
+			if fields[row.Name] == nil {
+				fields[row.Name] = make(map[string]string)
+			}
+			for _, v := range row.Values {
+				if len(v) < 2 {
+					continue
+				}
+				field, _ := v[0].(string)
+				typ, _ := v[1].(string)
+				fields[row.Name][field] = typ
+			}
+		}
+	}
+	s.fields[policyKey{db, rp}] = fields
+	return fields, nil
+}
+
+func (s *HTTPSource) query(stmt, db string) ([]client.Result, error) {
+	resp, err := s.client.Query(client.Query{Command: stmt, Database: db, Chunked: true, ChunkSize: httpChunkSize})
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("execute '%s' error: %v", stmt, err)
+	}
+	return resp.Results, nil
+}
+
+// columnValues returns the non-null values of the column in rows, whose first column is the time in nanoseconds.
+func columnValues(rows [][]interface{}, col int, typ string) ([]tsm1.Value, error) {
+	var values []tsm1.Value
+	for _, r := range rows {
+		if col >= len(r) || r[col] == nil {
+			continue
+		}
+		n, ok := r[0].(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("invalid time %v, precision must be ns", r[0])
+		}
+		ts, err := n.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %v, precision must be ns", r[0])
+		}
+		v, err := fieldValue(r[col], typ)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, tsm1.NewValue(ts, v))
+	}
+	return values, nil
+}
+
+// fieldValue converts a json value to the field type named as in SHOW FIELD KEYS.
+func fieldValue(v interface{}, typ string) (interface{}, error) {
+	switch x := v.(type) {
+	case json.Number:
+		switch typ {
+		case "integer":
+			return x.Int64()
+		case "unsigned":
+			return strconv.ParseUint(string(x), 10, 64)
+		default:
+			return x.Float64()
+		}
+	case string, bool:
+		return x, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", v, v)
+}
@@ -0,0 +1,79 @@
+package source
+
+import (
+	"io"
+	"math"
+
+	"github.com/chengshiwen/influx-tool/internal/ratelimit"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// LimitSource is implemented by the sources of local tsm and wal files, whose reads from disk are limited.
+type LimitSource interface {
+	// LimitReads limits the bytes of the tsm blocks and the wal files read by l, nil means unlimited.
+	LimitReads(l *ratelimit.Limiter)
+}
+
+func (s *FileSource) LimitReads(l *ratelimit.Limiter) {
+	s.limiter = l
+}
+
+// limitedFile is a tsm file whose blocks are waited for by the limiter before being read.
+type limitedFile struct {
+	tsmread.File
+	l       *ratelimit.Limiter
+	entries []tsm1.IndexEntry
+}
+
+// wait waits for the size of the blocks of key overlapping the time range [start, end].
+func (f *limitedFile) wait(key []byte, start, end int64) {
+	n := 0
+	for _, e := range f.ReadEntries(key, &f.entries) {
+		if e.OverlapsTimeRange(start, end) {
+			n += int(e.Size)
+		}
+	}
+	f.l.WaitN(n)
+}
+
+func (f *limitedFile) ReadAll(key []byte) ([]tsm1.Value, error) {
+	f.wait(key, math.MinInt64, math.MaxInt64)
+	return f.File.ReadAll(key)
+}
+
+func (f *limitedFile) ReadRange(key []byte, start, end int64) ([]tsm1.Value, error) {
+	f.wait(key, start, end)
+	return f.File.ReadRange(key, start, end)
+}
+
+func (f *limitedFile) ReadChunks(key []byte, start, end int64, maxValues int, fn func(values []tsm1.Value) error) error {
+	f.wait(key, start, end)
+	return f.File.ReadChunks(key, start, end, maxValues, fn)
+}
+
+func (f *limitedFile) ReadBytes(e *tsm1.IndexEntry, b []byte) (uint32, []byte, error) {
+	f.l.WaitN(int(e.Size))
+	return f.File.ReadBytes(e, b)
+}
+
+func (f *limitedFile) ReadBlocks(fn func(key []byte, minTime, maxTime int64, block []byte) error) error {
+	return f.File.ReadBlocks(func(key []byte, minTime, maxTime int64, block []byte) error {
+		f.l.WaitN(len(block))
+		return fn(key, minTime, maxTime, block)
+	})
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// openWAL opens the wal file of path as openFile, read through the limiter if any.
+func (s *FileSource) openWAL(path string) (io.ReadCloser, error) {
+	f, err := openFile(path)
+	if f == nil || err != nil || s.limiter == nil {
+		return f, err
+	}
+	return limitedReadCloser{ratelimit.NewReader(f, s.limiter), f}, nil
+}
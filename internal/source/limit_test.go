@@ -0,0 +1,63 @@
+package source
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/ratelimit"
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestFileSourceLimitReads(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(5, 2.5)},
+	})
+	writeWALFile(t, filepath.Join(walDir, "db", "autogen", "1", "_00001.wal"), map[string][]tsm1.Value{
+		"mem,host=b#!~#used": {tsm1.NewIntegerValue(3, 20)},
+	})
+
+	s := NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := s.ListShards("db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := ratelimit.NewLimiter(200)
+	l.WaitN(200) // the burst is used up, so every byte read is waited for
+	s.LimitReads(l)
+
+	start := time.Now()
+	n := 0
+	err = s.ReadValues(shards[0], 0, 10, func(seriesKey, field []byte, values []tsm1.Value) error {
+		n += len(values)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("unexpected values read: %d", n)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("unexpected elapsed: %s", elapsed)
+	}
+
+	var blocks int
+	start = time.Now()
+	err = s.ReadBlocks(shards[0], func(minTime, maxTime int64) error { return nil }, func(key []byte, minTime, maxTime int64, block []byte) error {
+		blocks++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks != 2 {
+		t.Errorf("unexpected blocks read: %d", blocks)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("unexpected elapsed: %s", elapsed)
+	}
+}
@@ -0,0 +1,211 @@
+package source
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// ReadMergedValues reads the keys of the tsm files of the shard in order by merging them, and the values of the wal
+// loaded by key, which are bounded by the cache size of influxdb. The values of a key are appended in the order of
+// the tsm files and the wal, then deduplicated with the later ones kept, so that the points both in tsm and wal files
+// not snapshotted or in tsm files of overlapping generations are read once.
+func (s *FileSource) ReadMergedValues(sh *Shard, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	wal := make(map[string][]tsm1.Value)
+	err := readWALFiles(sh.walFiles, s.openWAL, s.corrupt, func(key []byte, values []tsm1.Value) error {
+		if values = filterValues(values, start, end); len(values) > 0 {
+			wal[string(key)] = append(wal[string(key)], values...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var readers []tsmread.File
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+	for _, path := range sh.files {
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "skipped missing file: %s\n", path)
+				continue
+			}
+			return err
+		}
+		r, err := s.open(path)
+		if err != nil {
+			if err = s.corrupt.skip("", path, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if minTime, maxTime := r.TimeRange(); minTime > end || maxTime < start {
+			r.Close()
+			continue
+		}
+		readers = append(readers, r)
+	}
+	return mergeValues(readers, wal, s.corrupt, start, end, fn)
+}
+
+// mergeValues calls fn with the values of each key of the tsm readers and the wal in key order, the values of a key
+// in the later readers and the wal overwrite the ones of the same timestamps in the earlier readers. The keys unable to
+// be read are skipped by c.
+func mergeValues(readers []tsmread.File, wal map[string][]tsm1.Value, c *Corruptions, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	walKeys := make([]string, 0, len(wal))
+	for key := range wal {
+		walKeys = append(walKeys, key)
+	}
+	sort.Strings(walKeys)
+
+	idx := make([]int, len(readers))
+	w := 0
+	for {
+		var key []byte
+		for i, r := range readers {
+			if idx[i] < r.KeyCount() {
+				if k, _ := r.KeyAt(idx[i]); key == nil || bytes.Compare(k, key) < 0 {
+					key = k
+				}
+			}
+		}
+		if w < len(walKeys) && (key == nil || walKeys[w] < string(key)) {
+			key = []byte(walKeys[w])
+		}
+		if key == nil {
+			return nil
+		}
+
+		var values tsm1.Values
+		for i, r := range readers {
+			if idx[i] >= r.KeyCount() {
+				continue
+			}
+			if k, _ := r.KeyAt(idx[i]); !bytes.Equal(k, key) {
+				continue
+			}
+			idx[i]++
+			vs, err := r.ReadRange(key, start, end)
+			if err != nil {
+				if err = c.skip("", r.Path(), key, err); err != nil {
+					return err
+				}
+				continue
+			}
+			values = append(values, vs...)
+		}
+		if w < len(walKeys) && walKeys[w] == string(key) {
+			values = append(values, wal[walKeys[w]]...)
+			w++
+		}
+		if len(values) == 0 {
+			continue
+		}
+		seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+		if err := fn(seriesKey, field, values.Deduplicate()); err != nil {
+			return err
+		}
+	}
+}
+
+// errMergeStopped stops reading the shards once the merge returns.
+var errMergeStopped = errors.New("merge stopped")
+
+// mergedSeries is the values of a composite key read from a shard.
+type mergedSeries struct {
+	key    []byte
+	values []tsm1.Value
+}
+
+// shardStream streams the series of a shard read by ReadMergedValues, err is set before series is closed.
+type shardStream struct {
+	series chan mergedSeries
+	err    error
+}
+
+// ReadMergedShards calls fn once with the values of each series in the shards within the time range [start, end] in
+// key order, merging the series read by ReadMergedValues of each shard, so that the series of shards in several shard
+// groups are read as influxdb reads them across the groups. The values of a series are appended in the order of the
+// shards then deduplicated with the later ones kept, and the shards are read concurrently holding a series each at
+// most. An error returned by fn stops reading.
+func ReadMergedShards(src MergeSource, shards []*Shard, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error {
+	if len(shards) == 1 {
+		return src.ReadMergedValues(shards[0], start, end, fn)
+	}
+
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	streams := make([]*shardStream, len(shards))
+	for i, sh := range shards {
+		s := &shardStream{series: make(chan mergedSeries)}
+		streams[i] = s
+		wg.Add(1)
+		go func(sh *Shard) {
+			defer wg.Done()
+			defer close(s.series)
+			s.err = src.ReadMergedValues(sh, start, end, func(seriesKey, field []byte, values []tsm1.Value) error {
+				select {
+				case s.series <- mergedSeries{key: tsm1.SeriesFieldKeyBytes(string(seriesKey), string(field)), values: values}:
+					return nil
+				case <-done:
+					return errMergeStopped
+				}
+			})
+		}(sh)
+	}
+
+	heads := make([]*mergedSeries, len(streams))
+	next := func(i int) error {
+		if ms, ok := <-streams[i].series; ok {
+			heads[i] = &ms
+			return nil
+		}
+		heads[i] = nil
+		return streams[i].err
+	}
+	for i := range streams {
+		if err := next(i); err != nil {
+			return err
+		}
+	}
+	for {
+		var key []byte
+		for _, h := range heads {
+			if h != nil && (key == nil || bytes.Compare(h.key, key) < 0) {
+				key = h.key
+			}
+		}
+		if key == nil {
+			return nil
+		}
+
+		var values tsm1.Values
+		for i, h := range heads {
+			if h == nil || !bytes.Equal(h.key, key) {
+				continue
+			}
+			values = append(values, h.values...)
+			if err := next(i); err != nil {
+				return err
+			}
+		}
+		seriesKey, field := tsm1.SeriesAndFieldFromCompositeKey(key)
+		if err := fn(seriesKey, field, values.Deduplicate()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package source
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// fileOrder is the numbers parsed from a file name to order the files as they were written.
+type fileOrder struct {
+	path   string
+	nums   [2]int
+	parsed bool
+}
+
+// SortTSMFiles sorts the tsm files by generation and sequence parsed as numbers, so that the files are ordered as
+// written even if the numbers exceed the zero padded width of the names. Unparsable names are sorted by name after
+// the others. If strict, an error is returned for unparsable names and repeated generation and sequence.
+func SortTSMFiles(files []string, strict bool) error {
+	return sortFiles(files, strict, false, func(name string) ([2]int, bool) {
+		gen, seq, err := tsm1.DefaultParseFileName(name)
+		return [2]int{gen, seq}, err == nil
+	})
+}
+
+// SortWALFiles sorts the wal segments by id parsed as a number, so that the writes are replayed in order even if
+// the id exceeds the zero padded width of the names. Unparsable names are sorted by name after the others.
+// If strict, an error is returned for unparsable names, repeated ids and gaps between ids of missing segments.
+func SortWALFiles(files []string, strict bool) error {
+	return sortFiles(files, strict, true, func(name string) ([2]int, bool) {
+		name = strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), tsm1.WALFilePrefix), "."+tsm1.WALFileExtension)
+		id, err := strconv.Atoi(name)
+		return [2]int{id}, err == nil && id >= 0
+	})
+}
+
+func sortFiles(files []string, strict, contiguous bool, parse func(name string) ([2]int, bool)) error {
+	orders := make([]fileOrder, len(files))
+	for i, path := range files {
+		orders[i].path = path
+		orders[i].nums, orders[i].parsed = parse(path)
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		if a.parsed != b.parsed {
+			return a.parsed
+		}
+		if a.parsed && a.nums != b.nums {
+			return a.nums[0] < b.nums[0] || (a.nums[0] == b.nums[0] && a.nums[1] < b.nums[1])
+		}
+		return a.path < b.path
+	})
+	for i := range orders {
+		files[i] = orders[i].path
+	}
+	if !strict {
+		return nil
+	}
+
+	for i, o := range orders {
+		if !o.parsed {
+			return fmt.Errorf("file name of %s is not numbered", o.path)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := orders[i-1]
+		if prev.nums == o.nums {
+			return fmt.Errorf("files %s and %s have the same number", prev.path, o.path)
+		}
+		if contiguous && o.nums[0] != prev.nums[0]+1 {
+			return fmt.Errorf("files between %s and %s are missing", prev.path, o.path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package source
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSortWALFiles(t *testing.T) {
+	files := []string{"wal/_100000.wal", "wal/_99999.wal", "wal/_99998.wal", "wal/_100001.wal"}
+	if err := SortWALFiles(files, true); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"wal/_99998.wal", "wal/_99999.wal", "wal/_100000.wal", "wal/_100001.wal"}
+	if !cmp.Equal(files, exp) {
+		t.Fatalf("unexpected order: got=%v, exp=%v", files, exp)
+	}
+
+	for _, files := range [][]string{
+		{"wal/_00001.wal", "wal/_00003.wal"},
+		{"wal/_00001.wal", "wal/_1.wal"},
+		{"wal/_00001.wal", "wal/_x.wal"},
+	} {
+		if err := SortWALFiles(files, false); err != nil {
+			t.Errorf("unexpected error of %v: %v", files, err)
+		}
+		if err := SortWALFiles(files, true); err == nil {
+			t.Errorf("expected strict order error of %v", files)
+		}
+	}
+}
+
+func TestSortTSMFiles(t *testing.T) {
+	files := []string{"x.tsm", "1000000000-000000001.tsm", "000000002-000000010.tsm", "000000002-000000002.tsm", "999999999-000000001.tsm"}
+	if err := SortTSMFiles(files, false); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"000000002-000000002.tsm", "000000002-000000010.tsm", "999999999-000000001.tsm", "1000000000-000000001.tsm", "x.tsm"}
+	if !cmp.Equal(files, exp) {
+		t.Fatalf("unexpected order: got=%v, exp=%v", files, exp)
+	}
+	if err := SortTSMFiles(files, true); err == nil {
+		t.Fatal("expected strict order error of unparsable name")
+	}
+	if err := SortTSMFiles(files[:4], true); err != nil {
+		t.Fatal(err)
+	}
+	if err := SortTSMFiles([]string{"1-1.tsm", "000000001-000000001.tsm"}, true); err == nil {
+		t.Fatal("expected strict order error of repeated numbers")
+	}
+}
@@ -0,0 +1,78 @@
+package source
+
+import (
+	"path/filepath"
+
+	"github.com/chengshiwen/influx-tool/internal/backup"
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+// PortableSource reads the shards of an influxd backup -portable directory by its manifests, the shard files of the
+// latest backup are read if followed by incremental backups, instead of every archive in the directory. The meta of
+// the backup gives the time range of the shards and the retention policies of the databases.
+type PortableSource struct {
+	*ArchiveSource
+	meta  *meta.Data
+	files map[uint64]string // shard file of each shard id
+}
+
+// IsPortableBackup returns whether the path is a backup directory with manifests, which is read as a portable backup.
+func IsPortableBackup(path string) bool {
+	matches, err := filepath.Glob(filepath.Join(path, "*.manifest"))
+	return err == nil && len(matches) > 0
+}
+
+// NewPortableSource returns the source of the portable backup in dir, or an error if it has no manifest.
+func NewPortableSource(dir string) (*PortableSource, error) {
+	data, entries, err := backup.LoadPortable(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &PortableSource{ArchiveSource: NewArchiveSource(dir), meta: data, files: make(map[uint64]string, len(entries))}
+	for _, e := range entries {
+		s.files[e.ShardID] = filepath.Join(dir, e.FileName)
+	}
+	return s, nil
+}
+
+// Meta returns the meta of the databases in the backup.
+func (s *PortableSource) Meta() *meta.Data {
+	return s.meta
+}
+
+func (s *PortableSource) ListShards(db, rp string) ([]*Shard, error) {
+	var list []*Shard
+	for _, dbi := range s.meta.Databases {
+		for _, rpi := range dbi.RetentionPolicies {
+			if !matchShard(db, rp, dbi.Name, rpi.Name) {
+				continue
+			}
+			for _, sgi := range rpi.ShardGroups {
+				if sgi.Deleted() {
+					continue
+				}
+				for _, si := range sgi.Shards {
+					path, ok := s.files[si.ID]
+					if !ok {
+						continue
+					}
+					sh := newShard(si.ID, dbi.Name, rpi.Name)
+					// the end time of a shard group is exclusive
+					sh.StartTime, sh.EndTime = sgi.StartTime.UnixNano(), sgi.EndTime.UnixNano()-1
+					sh.files = []string{path}
+					list = append(list, sh)
+				}
+			}
+		}
+	}
+	sortShards(list)
+	return list, nil
+}
+
+// DefaultPolicy returns the default retention policy of the database in the meta of the backup, empty if not found.
+func (s *PortableSource) DefaultPolicy(db string) (string, error) {
+	if dbi := s.meta.Database(db); dbi != nil {
+		return dbi.DefaultRetentionPolicy, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,114 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/cmd/influxd/backup_util"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestPortableSource(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "backup")
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// writeShard writes the shard archive of the values into the backup, named by the backup time
+	writeShard := func(backupTime string, id uint64, values map[string][]tsm1.Value) backup_util.Entry {
+		root := filepath.Join(dir, backupTime, fmt.Sprint(id), "db")
+		writeTSMFile(t, filepath.Join(root, "autogen", fmt.Sprint(id), "000000001-000000001.tsm"), values)
+		name := fmt.Sprintf("%s.s%d.tar.gz", backupTime, id)
+		writeTarGz(t, filepath.Join(backupDir, name), root)
+		return backup_util.Entry{Database: "db", Policy: "autogen", ShardID: id, FileName: name}
+	}
+
+	data := &meta.Data{}
+	if err := data.CreateDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := data.CreateRetentionPolicy("db", &meta.RetentionPolicyInfo{Name: "autogen", ReplicaN: 1, ShardGroupDuration: time.Hour}, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []int64{0, 3600} {
+		if err := data.CreateShardGroup("db", "autogen", time.Unix(ts, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = backup_util.PortablePacker{Data: b}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(backupDir, "20240102T000000Z.meta"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeManifest := func(backupTime string, files ...backup_util.Entry) {
+		b, err := json.Marshal(backup_util.Manifest{Meta: backup_util.MetaEntry{FileName: "20240102T000000Z.meta"}, Files: files})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(backupDir, backupTime+".manifest"), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the full backup is followed by an incremental backup of shard 1, whose value at 1s is rewritten
+	writeManifest("20240101T000000Z",
+		writeShard("20240101T000000Z", 1, map[string][]tsm1.Value{"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1e9, 1)}}),
+		writeShard("20240101T000000Z", 2, map[string][]tsm1.Value{"cpu,host=a#!~#usage": {tsm1.NewFloatValue(3601e9, 3)}}))
+	writeManifest("20240102T000000Z",
+		writeShard("20240102T000000Z", 1, map[string][]tsm1.Value{"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1e9, 2)}}))
+
+	if !IsPortableBackup(backupDir) || IsPortableBackup(dir) || IsPortableBackup(filepath.Join(backupDir, "20240102T000000Z.meta")) {
+		t.Error("unexpected portable backup detected")
+	}
+	s, err := NewPortableSource(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, err := s.ListShards("db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, sh := range shards {
+		got = append(got, fmt.Sprintf("shard %d %d-%d", sh.ID, sh.StartTime, sh.EndTime))
+		err = s.ReadValues(sh, sh.StartTime, sh.EndTime, func(seriesKey, field []byte, values []tsm1.Value) error {
+			for _, v := range values {
+				got = append(got, fmt.Sprintf("%s %s=%v %d", seriesKey, field, v.Value(), v.UnixNano()))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := []string{
+		"shard 1 0-3599999999999",
+		"cpu,host=a usage=2 1000000000",
+		"shard 2 3600000000000-7199999999999",
+		"cpu,host=a usage=3 3601000000000",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("unexpected values:\ngot=%q\nexp=%q", got, exp)
+	}
+	if rp, err := s.DefaultPolicy("db"); err != nil || rp != "autogen" {
+		t.Errorf("unexpected default policy %q, error %v", rp, err)
+	}
+	if shards, err = s.ListShards("other", ""); err != nil || len(shards) != 0 {
+		t.Errorf("unexpected shards of other database: %d, error %v", len(shards), err)
+	}
+
+	if _, err = NewPortableSource(dir); err == nil {
+		t.Error("expected error of directory without manifest")
+	}
+}
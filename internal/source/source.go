@@ -0,0 +1,185 @@
+package source
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+// Shard is a shard of a source, the files of which are only known to the source listing it.
+type Shard struct {
+	ID              uint64
+	Database        string
+	RetentionPolicy string
+	StartTime       int64 // min time of the shard, math.MinInt64 if unknown
+	EndTime         int64 // max time of the shard, math.MaxInt64 if unknown
+
+	files    []string
+	walFiles []string
+	size     int64 // uncompressed size of the files in data archives
+}
+
+// Source reads the series and values of shards, from local tsm and wal files, backup archives or a live server,
+// so that the data can be exported, filtered and routed regardless of where it comes from.
+type Source interface {
+	// ListShards returns the shards of the database and retention policy sorted by database, retention policy
+	// and id, empty database or retention policy means all, and _internal is only returned if given.
+	ListShards(db, rp string) ([]*Shard, error)
+
+	// ReadSeries calls fn with the series key, field and field type of each series in the shard without reading
+	// the values, a series may be repeated.
+	ReadSeries(sh *Shard, fn func(seriesKey, field []byte, typ influxql.DataType) error) error
+
+	// ReadValues calls fn with the values of each series in the shard within the time range [start, end],
+	// the values of a series may be split into several calls. An error returned by fn stops reading.
+	ReadValues(sh *Shard, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error
+}
+
+// BlockSource is implemented by the sources of tsm files, which read the blocks of values encoded as they are stored,
+// so that shards can be copied without decoding and encoding the values.
+type BlockSource interface {
+	// ReadBlocks calls fileFn with the time range of each tsm file in the shard, then blockFn with each block of the
+	// file in key and time order, the block is the encoded values without checksum. The values in the wal are sorted
+	// and encoded into the blocks of an extra file at last. An error returned by fileFn or blockFn stops reading.
+	ReadBlocks(sh *Shard, fileFn func(minTime, maxTime int64) error, blockFn func(key []byte, minTime, maxTime int64, block []byte) error) error
+}
+
+// MergeSource is implemented by the sources of local tsm and wal files, which read the values of each series merged
+// across the files of a shard, as influxdb reads them.
+type MergeSource interface {
+	// ReadMergedValues calls fn once with the values of each series in the shard within the time range [start, end]
+	// in key order, the values of the tsm files and the wal are merged in time order, and the value written last is
+	// kept for each timestamp. An error returned by fn stops reading.
+	ReadMergedValues(sh *Shard, start, end int64, fn func(seriesKey, field []byte, values []tsm1.Value) error) error
+}
+
+// TombstoneSource is implemented by the sources of tsm files, whose tombstones are applied unless ignored.
+type TombstoneSource interface {
+	// IgnoreTombstones reads the values deleted by the tombstones of the tsm files too.
+	IgnoreTombstones()
+}
+
+// The kinds of files read by the sources of tsm and wal files.
+const (
+	KindAll = "all"
+	KindTSM = "tsm"
+	KindWAL = "wal"
+)
+
+// KindSource is implemented by the sources of tsm and wal files, which read only the files of a kind if selected.
+type KindSource interface {
+	// SelectKind reads only the tsm files or the wal files of the shards, or both by all. The shards without the files
+	// of the kind are not listed.
+	SelectKind(kind string)
+}
+
+// ChunkSource is implemented by the sources of tsm files, which decode the blocks of a series one by one, so that the
+// values of a series held in memory are limited.
+type ChunkSource interface {
+	// ReadChunkedValues is ReadValues with the values of a series in a tsm file split into chunks of about maxValues
+	// values in time order, the blocks overlapping each other are merged into the same chunk which may exceed it.
+	ReadChunkedValues(sh *Shard, start, end int64, maxValues int, fn func(seriesKey, field []byte, values []tsm1.Value) error) error
+}
+
+// IndexSource is implemented by the sources of local tsm files, which read the index of the files without the blocks.
+type IndexSource interface {
+	// ReadIndexes calls fn with the key, block type and index entries of each key in the tsm files of the shard, the
+	// entries are only valid until fn returns. An error returned by fn stops reading.
+	ReadIndexes(sh *Shard, fn func(key []byte, typ byte, entries []tsm1.IndexEntry) error) error
+}
+
+// RangeSource is implemented by the sources of local tsm and wal files, whose shards are listed without time range.
+type RangeSource interface {
+	// ReadTimeRange sets the time range of the shard to the min and max time of the values in its files, reading the
+	// index of the tsm files and the values of the wal. It is left unknown if any file is unable to be read.
+	ReadTimeRange(sh *Shard)
+}
+
+// PolicySource is implemented by the sources knowing the meta of the databases, which read the default retention
+// policies of them.
+type PolicySource interface {
+	// DefaultPolicy returns the default retention policy of the database, empty if the database or its meta is unknown.
+	DefaultPolicy(db string) (string, error)
+}
+
+// DeleteSource is implemented by the sources of wal files, which read the deletes of series recorded in the wal.
+type DeleteSource interface {
+	// ReadDeletes calls fn with the series keys and the time range [min, max] of each delete in the wal files of the
+	// shard, in the order the wal received them. The series deleted entirely are of [math.MinInt64, math.MaxInt64].
+	ReadDeletes(sh *Shard, fn func(seriesKeys [][]byte, min, max int64) error) error
+}
+
+// Size returns the total size of the tsm and wal files of the shard on disk or in data archives, 0 if read from
+// backup archives or a server.
+func (sh *Shard) Size() int64 {
+	size := sh.size
+	for _, files := range [][]string{sh.files, sh.walFiles} {
+		for _, file := range files {
+			ext := filepath.Ext(file)
+			if ext != "."+tsm1.TSMFileExtension && ext != "."+tsm1.WALFileExtension {
+				continue
+			}
+			if fi, err := os.Stat(file); err == nil {
+				size += fi.Size()
+			}
+		}
+	}
+	return size
+}
+
+func newShard(id uint64, db, rp string) *Shard {
+	return &Shard{ID: id, Database: db, RetentionPolicy: rp, StartTime: math.MinInt64, EndTime: math.MaxInt64}
+}
+
+func matchShard(db, rp, shardDB, shardRP string) bool {
+	if db == "" {
+		return shardDB != "_internal" && (rp == "" || shardRP == rp)
+	}
+	return shardDB == db && (rp == "" || shardRP == rp)
+}
+
+func sortShards(shards []*Shard) {
+	sort.Slice(shards, func(i, j int) bool {
+		a, b := shards[i], shards[j]
+		if a.Database != b.Database {
+			return a.Database < b.Database
+		}
+		if a.RetentionPolicy != b.RetentionPolicy {
+			return a.RetentionPolicy < b.RetentionPolicy
+		}
+		return a.ID < b.ID
+	})
+}
+
+// filterValues returns the values within the time range [start, end], filtered in place.
+func filterValues(values []tsm1.Value, start, end int64) []tsm1.Value {
+	filtered := values[:0]
+	for _, v := range values {
+		if ts := v.UnixNano(); ts >= start && ts <= end {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+// ValueType returns the field type of the value.
+func ValueType(v tsm1.Value) influxql.DataType {
+	switch v.(type) {
+	case tsm1.FloatValue:
+		return influxql.Float
+	case tsm1.IntegerValue:
+		return influxql.Integer
+	case tsm1.UnsignedValue:
+		return influxql.Unsigned
+	case tsm1.BooleanValue:
+		return influxql.Boolean
+	case tsm1.StringValue:
+		return influxql.String
+	default:
+		return influxql.Unknown
+	}
+}
@@ -0,0 +1,470 @@
+package source
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/chengshiwen/influx-tool/internal/tsmread"
+	"github.com/golang/snappy"
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/client"
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+)
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(5, 2.5)},
+	})
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "10", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"mem,host=a#!~#used": {tsm1.NewIntegerValue(2, 10)},
+	})
+	writeTSMFile(t, filepath.Join(dataDir, "_internal", "monitor", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"runtime#!~#alloc": {tsm1.NewIntegerValue(1, 1)},
+	})
+	writeWALFile(t, filepath.Join(walDir, "db", "autogen", "10", "_00001.wal"), map[string][]tsm1.Value{
+		"mem,host=b#!~#used": {tsm1.NewIntegerValue(3, 20)},
+	})
+
+	s := NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := s.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, sh := range shards {
+		ids = append(ids, fmt.Sprintf("%s.%s.%d", sh.Database, sh.RetentionPolicy, sh.ID))
+	}
+	if !cmp.Equal(ids, []string{"db.autogen.2", "db.autogen.10"}) {
+		t.Fatalf("unexpected shards: %v", ids)
+	}
+
+	var got []string
+	for _, sh := range shards {
+		err = s.ReadValues(sh, 2, 5, func(seriesKey, field []byte, values []tsm1.Value) error {
+			for _, v := range values {
+				got = append(got, fmt.Sprintf("%s %s=%v %d", seriesKey, field, v.Value(), v.UnixNano()))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp := []string{"cpu,host=a usage=2.5 5", "mem,host=a used=10 2", "mem,host=b used=20 3"}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected values: got=%v, exp=%v", got, exp)
+	}
+
+	got = got[:0]
+	err = s.ReadSeries(shards[1], func(seriesKey, field []byte, typ influxql.DataType) error {
+		got = append(got, string(seriesKey)+" "+string(field)+" "+typ.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp = []string{"mem,host=a used integer", "mem,host=b used integer"}; !cmp.Equal(got, exp) {
+		t.Errorf("unexpected series: got=%v, exp=%v", got, exp)
+	}
+
+	got = got[:0]
+	err = s.ReadBlocks(shards[1], func(minTime, maxTime int64) error {
+		got = append(got, fmt.Sprintf("file %d-%d", minTime, maxTime))
+		return nil
+	}, func(key []byte, minTime, maxTime int64, block []byte) error {
+		values, err := tsm1.DecodeBlock(block, nil)
+		if err != nil {
+			return err
+		}
+		got = append(got, fmt.Sprintf("%s %d-%d %d", key, minTime, maxTime, len(values)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp = []string{"file 2-2", "mem,host=a#!~#used 2-2 1", "file 3-3", "mem,host=b#!~#used 3-3 1"}; !cmp.Equal(got, exp) {
+		t.Errorf("unexpected blocks: got=%v, exp=%v", got, exp)
+	}
+
+	if shards, err = s.ListShards("_internal", ""); err != nil || len(shards) != 1 {
+		t.Errorf("unexpected shards of _internal: %v, %v", shards, err)
+	}
+}
+
+func TestSelectKind(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5)},
+	})
+	writeWALFile(t, filepath.Join(walDir, "db", "autogen", "1", "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(2, 2.5)},
+	})
+	writeWALFile(t, filepath.Join(walDir, "db", "autogen", "2", "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=b#!~#usage": {tsm1.NewFloatValue(3, 3.5)},
+	})
+	zipPath := filepath.Join(t.TempDir(), "data.zip")
+	writeZip(t, zipPath, dir)
+
+	for _, kind := range []string{KindAll, KindTSM, KindWAL} {
+		exp := map[string][]string{
+			KindAll: {"shard db.autogen.1", "cpu,host=a usage=1.5 1", "cpu,host=a usage=2.5 2", "shard db.autogen.2", "cpu,host=b usage=3.5 3"},
+			KindTSM: {"shard db.autogen.1", "cpu,host=a usage=1.5 1"},
+			KindWAL: {"shard db.autogen.1", "cpu,host=a usage=2.5 2", "shard db.autogen.2", "cpu,host=b usage=3.5 3"},
+		}[kind]
+		for _, s := range []Source{NewFileSource(dataDir, walDir, false, tsmread.ModeMmap), NewDataArchiveSource([]string{zipPath}, false)} {
+			s.(KindSource).SelectKind(kind)
+			shards, err := s.ListShards("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, sh := range shards {
+				got = append(got, fmt.Sprintf("shard %s.%s.%d", sh.Database, sh.RetentionPolicy, sh.ID))
+				err = s.ReadValues(sh, math.MinInt64, math.MaxInt64, func(seriesKey, field []byte, values []tsm1.Value) error {
+					for _, v := range values {
+						got = append(got, fmt.Sprintf("%s %s=%v %d", seriesKey, field, v.Value(), v.UnixNano()))
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !cmp.Equal(got, exp) {
+				t.Errorf("%s of %T: got=%v, exp=%v", kind, s, got, exp)
+			}
+		}
+	}
+}
+
+func TestReadTimeRange(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(5, 1.5), tsm1.NewFloatValue(8, 2.5)},
+	})
+	writeWALFile(t, filepath.Join(walDir, "db", "autogen", "1", "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=b#!~#usage": {tsm1.NewFloatValue(2, 3.5), tsm1.NewFloatValue(3, 4.5)},
+	})
+	if err := os.MkdirAll(filepath.Join(dataDir, "db", "autogen", "2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "db", "autogen", "2", "000000001-000000001.tsm"), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := s.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, sh := range shards {
+		s.ReadTimeRange(sh)
+		got = append(got, fmt.Sprintf("shard %d %d-%d", sh.ID, sh.StartTime, sh.EndTime))
+	}
+	// the time range of the shard unable to be read is left unknown
+	exp := []string{"shard 1 2-8", fmt.Sprintf("shard 2 %d-%d", int64(math.MinInt64), int64(math.MaxInt64))}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected time ranges: got=%v, exp=%v", got, exp)
+	}
+}
+
+func TestReadMergedValues(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	shardDir := filepath.Join(dataDir, "db", "autogen", "1")
+	writeTSMFile(t, filepath.Join(shardDir, "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(2, 2.5)},
+		"cpu,host=b#!~#usage": {tsm1.NewFloatValue(1, 10.5)},
+	})
+	writeTSMFile(t, filepath.Join(shardDir, "000000002-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(2, 3.5), tsm1.NewFloatValue(3, 4.5)},
+		"mem,host=a#!~#used":  {tsm1.NewIntegerValue(1, 20)},
+	})
+	walShardDir := filepath.Join(walDir, "db", "autogen", "1")
+	writeWALFile(t, filepath.Join(walShardDir, "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(3, 5.5), tsm1.NewFloatValue(6, 6.5)},
+		"cpu,host=c#!~#usage": {tsm1.NewFloatValue(1, 30.5)},
+	})
+	writeWALFile(t, filepath.Join(walShardDir, "_00002.wal"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(3, 7.5)},
+	})
+
+	s := NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := s.ListShards("", "")
+	if err != nil || len(shards) != 1 {
+		t.Fatalf("unexpected shards %v, error %v", shards, err)
+	}
+	var got []string
+	err = s.ReadMergedValues(shards[0], 1, 5, func(seriesKey, field []byte, values []tsm1.Value) error {
+		var vs []string
+		for _, v := range values {
+			vs = append(vs, fmt.Sprintf("%v@%d", v.Value(), v.UnixNano()))
+		}
+		got = append(got, fmt.Sprintf("%s %s=%s", seriesKey, field, strings.Join(vs, ",")))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"cpu,host=a usage=1.5@1,3.5@2,7.5@3",
+		"cpu,host=b usage=10.5@1",
+		"cpu,host=c usage=30.5@1",
+		"mem,host=a used=20@1",
+	}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected values: got=%v, exp=%v", got, exp)
+	}
+}
+
+func TestReadMergedShards(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "1", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu#!~#usage":        {tsm1.NewFloatValue(1, 1.5)},
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 2.5), tsm1.NewFloatValue(2, 3.5)},
+	})
+	writeTSMFile(t, filepath.Join(dataDir, "db", "autogen", "2", "000000001-000000001.tsm"), map[string][]tsm1.Value{
+		"cpu!#!~#usage":       {tsm1.NewFloatValue(3, 4.5)},
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(2, 5.5), tsm1.NewFloatValue(3, 6.5)},
+	})
+	writeWALFile(t, filepath.Join(walDir, "db", "autogen", "3", "_00001.wal"), map[string][]tsm1.Value{
+		"cpu,host=a#!~#usage": {tsm1.NewFloatValue(4, 7.5)},
+		"mem#!~#used":         {tsm1.NewIntegerValue(4, 10)},
+	})
+
+	s := NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := s.ListShards("", "")
+	if err != nil || len(shards) != 3 {
+		t.Fatalf("unexpected shards %v, error %v", shards, err)
+	}
+	var got []string
+	err = ReadMergedShards(s, shards, 1, 4, func(seriesKey, field []byte, values []tsm1.Value) error {
+		var vs []string
+		for _, v := range values {
+			vs = append(vs, fmt.Sprintf("%v@%d", v.Value(), v.UnixNano()))
+		}
+		got = append(got, fmt.Sprintf("%s %s=%s", seriesKey, field, strings.Join(vs, ",")))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the series are in the order of the composite keys, and the values of the later shards are kept
+	exp := []string{
+		"cpu! usage=4.5@3",
+		"cpu usage=1.5@1",
+		"cpu,host=a usage=2.5@1,5.5@2,6.5@3,7.5@4",
+		"mem used=10@4",
+	}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected values: got=%v, exp=%v", got, exp)
+	}
+
+	stop := fmt.Errorf("stop")
+	if err = ReadMergedShards(s, shards, 1, 4, func(seriesKey, field []byte, values []tsm1.Value) error { return stop }); err != stop {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReadDeletes(t *testing.T) {
+	dir := t.TempDir()
+	dataDir, walDir := filepath.Join(dir, "data"), filepath.Join(dir, "wal")
+	if err := os.MkdirAll(filepath.Join(dataDir, "db", "autogen", "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeWALEntries(t, filepath.Join(walDir, "db", "autogen", "1", "_00001.wal"),
+		&tsm1.WriteWALEntry{Values: map[string][]tsm1.Value{"cpu,host=a#!~#usage": {tsm1.NewFloatValue(1, 1.5)}}},
+		&tsm1.DeleteRangeWALEntry{Keys: [][]byte{[]byte("cpu,host=a#!~#usage"), []byte("cpu,host=a#!~#idle"), []byte("cpu,host=b#!~#usage")}, Min: 1, Max: 5},
+		&tsm1.DeleteWALEntry{Keys: [][]byte{[]byte("mem#!~#used")}},
+	)
+
+	s := NewFileSource(dataDir, walDir, false, tsmread.ModeMmap)
+	shards, err := s.ListShards("", "")
+	if err != nil || len(shards) != 1 {
+		t.Fatalf("unexpected shards %v, error %v", shards, err)
+	}
+	var got []string
+	err = s.ReadDeletes(shards[0], func(seriesKeys [][]byte, min, max int64) error {
+		got = append(got, fmt.Sprintf("%q %d %d", seriesKeys, min, max))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{`["cpu,host=a" "cpu,host=b"] 1 5`, fmt.Sprintf(`["mem"] %d %d`, int64(math.MinInt64), int64(math.MaxInt64))}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected deletes: got=%v, exp=%v", got, exp)
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileSource(filepath.Join(dir, "data"), filepath.Join(dir, "wal"), false, tsmread.ModeMmap)
+	if rp, err := s.DefaultPolicy("db"); err != nil || rp != "" {
+		t.Fatalf("unexpected default policy without meta %q, error %v", rp, err)
+	}
+
+	data := &meta.Data{}
+	if err := data.CreateDatabase("db"); err != nil {
+		t.Fatal(err)
+	}
+	for _, rp := range []string{"autogen", "rp1"} {
+		if err := data.CreateRetentionPolicy("db", &meta.RetentionPolicyInfo{Name: rp, ReplicaN: 1}, rp == "rp1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(dir, "meta"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "meta", "meta.db"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if rp, err := s.DefaultPolicy("db"); err != nil || rp != "rp1" {
+		t.Errorf("unexpected default policy %q, error %v", rp, err)
+	}
+	if rp, err := s.DefaultPolicy("other"); err != nil || rp != "" {
+		t.Errorf("unexpected default policy of unknown database %q, error %v", rp, err)
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	responses := map[string]string{
+		"SHOW SHARDS":                             `{"results":[{"statement_id":0,"series":[{"name":"db","columns":["id","database","retention_policy","shard_group","start_time","end_time","expiry_time","owners"],"values":[[3,"db","autogen",3,"1970-01-01T00:00:00Z","1970-01-08T00:00:00Z","1970-01-08T00:00:00Z",""]]},{"name":"_internal","columns":["id","database","retention_policy","shard_group","start_time","end_time","expiry_time","owners"],"values":[[1,"_internal","monitor",1,"1970-01-01T00:00:00Z","1970-01-02T00:00:00Z","1970-01-09T00:00:00Z",""]]}]}]}`,
+		`SHOW RETENTION POLICIES ON db`:           `{"results":[{"statement_id":0,"series":[{"columns":["name","duration","shardGroupDuration","replicaN","default"],"values":[["autogen","0s","168h0m0s",1,false],["rp1","0s","168h0m0s",1,true]]}]}]}`,
+		`SHOW FIELD KEYS ON db FROM autogen./.*/`: `{"results":[{"statement_id":0,"series":[{"name":"cpu","columns":["fieldKey","fieldType"],"values":[["count","integer"],["usage","float"],["ok","boolean"]]}]}]}`,
+		`SELECT * FROM "autogen".cpu WHERE time >= 0 AND time <= 604799999999999 GROUP BY *`: `{"results":[{"statement_id":0,"series":[{"name":"cpu","tags":{"host":"a"},"columns":["time","count","ok","usage"],"values":[[1,3,true,2],[2,null,null,2.5]]}]}]}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		q := r.FormValue("q")
+		resp, ok := responses[q]
+		if !ok {
+			t.Errorf("unexpected query: %s", q)
+			resp = `{"results":[{"statement_id":0}]}`
+		}
+		if r.FormValue("epoch") != "ns" {
+			t.Errorf("unexpected epoch: %s", r.FormValue("epoch"))
+		}
+		fmt.Fprintln(w, resp)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	c, err := client.NewClient(client.Config{URL: *u, Precision: "ns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewHTTPSource(c)
+	shards, err := s.ListShards("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 1 || shards[0].ID != 3 || shards[0].StartTime != 0 || shards[0].EndTime != 7*24*3600*1e9-1 {
+		t.Fatalf("unexpected shards: %+v", shards)
+	}
+
+	if rp, err := s.DefaultPolicy("db"); err != nil || rp != "rp1" {
+		t.Errorf("unexpected default policy %q, error %v", rp, err)
+	}
+
+	var got []string
+	err = s.ReadValues(shards[0], math.MinInt64, math.MaxInt64, func(seriesKey, field []byte, values []tsm1.Value) error {
+		for _, v := range values {
+			got = append(got, fmt.Sprintf("%s %s=%v(%T) %d", seriesKey, field, v.Value(), v.Value(), v.UnixNano()))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"cpu,host=a count=3(int64) 1",
+		"cpu,host=a ok=true(bool) 1",
+		"cpu,host=a usage=2(float64) 1",
+		"cpu,host=a usage=2.5(float64) 2",
+	}
+	if !cmp.Equal(got, exp) {
+		t.Errorf("unexpected values:\ngot=%s\nexp=%s", strings.Join(got, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+func writeTSMFile(t *testing.T, path string, values map[string][]tsm1.Value) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the keys are written in sorted order
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err = w.Write([]byte(key), values[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.WriteIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeWALFile(t *testing.T, path string, values map[string][]tsm1.Value) {
+	writeWALEntries(t, path, &tsm1.WriteWALEntry{Values: values})
+}
+
+func writeWALEntries(t *testing.T, path string, entries ...tsm1.WALEntry) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := tsm1.NewWALSegmentWriter(f)
+	for _, entry := range entries {
+		b, err := entry.Encode(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Write(entry.Type(), snappy.Encode(nil, b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
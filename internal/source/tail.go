@@ -0,0 +1,115 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// WALTail reads the entries appended to the wal files of a wal directory since they were last read, so that the
+// points written to a live node are followed after its files are exported.
+type WALTail struct {
+	src     *FileSource
+	db      string
+	offsets map[string]int64 // read offset of each wal file by path
+	warned  bool
+}
+
+// NewWALTail returns the tail of the wal files of the database in the wal directory, empty database means all.
+func NewWALTail(walDir, db string) *WALTail {
+	src := NewFileSource("", walDir, false, "")
+	src.SelectKind(KindWAL)
+	return &WALTail{src: src, db: db, offsets: make(map[string]int64)}
+}
+
+// Mark marks the wal files as read up to their current sizes, so that only the entries appended later are read.
+func (t *WALTail) Mark() error {
+	shards, err := t.src.ListShards(t.db, "")
+	if err != nil {
+		return err
+	}
+	for _, sh := range shards {
+		for _, path := range sh.walFiles {
+			fi, err := os.Stat(path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return err
+			}
+			t.offsets[path] = fi.Size()
+		}
+	}
+	return nil
+}
+
+// Read calls fn with the write entries appended to each wal file of each shard since last read from the offset, in
+// the order the wal received them. The shards and wal files created since are read from the start, and the wal files
+// removed once compacted are forgotten. An entry being appended is left to the next read, and the entries of a file
+// are read again by the next read if fn returns an error, which stops reading.
+func (t *WALTail) Read(fn func(sh *Shard, path string, offset int64, entries []*tsm1.WriteWALEntry) error) error {
+	shards, err := t.src.ListShards(t.db, "")
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]struct{}, len(t.offsets))
+	for _, sh := range shards {
+		for _, path := range sh.walFiles {
+			seen[path] = struct{}{}
+			entries, n, err := t.readFile(path, t.offsets[path])
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 {
+				if err = fn(sh, path, t.offsets[path], entries); err != nil {
+					return err
+				}
+			}
+			t.offsets[path] += n
+		}
+	}
+	for path := range t.offsets {
+		if _, ok := seen[path]; !ok {
+			delete(t.offsets, path)
+		}
+	}
+	return nil
+}
+
+// readFile returns the write entries of the wal file from the offset, and the size of the complete entries read.
+func (t *WALTail) readFile(path string, offset int64) ([]*tsm1.WriteWALEntry, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// removed by a compaction since listed
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	r := tsm1.NewWALSegmentReader(f)
+	var entries []*tsm1.WriteWALEntry
+	for r.Next() {
+		entry, err := r.Read()
+		if err != nil {
+			// partially written, or corrupt which is read again as influxdb would stop at it too
+			break
+		}
+		switch e := entry.(type) {
+		case *tsm1.WriteWALEntry:
+			entries = append(entries, e)
+		case *tsm1.DeleteWALEntry, *tsm1.DeleteRangeWALEntry:
+			if !t.warned {
+				t.warned = true
+				fmt.Fprintf(os.Stderr, "WARNING: ignored deletes in wal file %s, the deleted points are kept in the output\n", path)
+			}
+		}
+	}
+	return entries, r.Count(), nil
+}
@@ -0,0 +1,125 @@
+package source
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// encodeWALEntry returns the entry as written to a wal segment.
+func encodeWALEntry(t *testing.T, values map[string][]tsm1.Value) []byte {
+	entry := &tsm1.WriteWALEntry{Values: values}
+	b, err := entry.Encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.CreateTemp(t.TempDir(), "entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := tsm1.NewWALSegmentWriter(f)
+	if err = w.Write(entry.Type(), snappy.Encode(nil, b)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func appendFile(t *testing.T, path string, data []byte) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALTail(t *testing.T) {
+	dir := t.TempDir()
+	seg := filepath.Join(dir, "db0", "rp0", "1", "_00001.wal")
+	writeWALFile(t, seg, map[string][]tsm1.Value{"cpu#!~#v": {tsm1.NewValue(1, 1.0)}})
+
+	tail := NewWALTail(dir, "db0")
+	if err := tail.Mark(); err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	read := func() {
+		t.Helper()
+		got = got[:0]
+		err := tail.Read(func(sh *Shard, path string, offset int64, entries []*tsm1.WriteWALEntry) error {
+			for _, e := range entries {
+				for _, values := range e.Values {
+					for _, v := range values {
+						got = append(got, v.UnixNano())
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	read()
+	if len(got) != 0 {
+		t.Fatalf("marked entries read: %v", got)
+	}
+
+	appendFile(t, seg, encodeWALEntry(t, map[string][]tsm1.Value{"cpu#!~#v": {tsm1.NewValue(2, 2.0)}}))
+	read()
+	if len(got) != 1 || got[0] != 2 {
+		t.Fatalf("appended entry: got %v, want [2]", got)
+	}
+
+	// an entry being appended is read once complete
+	entry := encodeWALEntry(t, map[string][]tsm1.Value{"cpu#!~#v": {tsm1.NewValue(3, 3.0)}})
+	appendFile(t, seg, entry[:len(entry)/2])
+	read()
+	if len(got) != 0 {
+		t.Fatalf("partial entry read: %v", got)
+	}
+	appendFile(t, seg, entry[len(entry)/2:])
+	read()
+	if len(got) != 1 || got[0] != 3 {
+		t.Fatalf("completed entry: got %v, want [3]", got)
+	}
+
+	// the entries of a failed call are read again
+	appendFile(t, seg, encodeWALEntry(t, map[string][]tsm1.Value{"cpu#!~#v": {tsm1.NewValue(4, 4.0)}}))
+	err := tail.Read(func(sh *Shard, path string, offset int64, entries []*tsm1.WriteWALEntry) error {
+		return os.ErrClosed
+	})
+	if err != os.ErrClosed {
+		t.Fatalf("got error %v, want %v", err, os.ErrClosed)
+	}
+	read()
+	if len(got) != 1 || got[0] != 4 {
+		t.Fatalf("entry read again: got %v, want [4]", got)
+	}
+
+	// the files of new shards are read from the start, and removed files are forgotten
+	writeWALFile(t, filepath.Join(dir, "db0", "rp0", "2", "_00001.wal"), map[string][]tsm1.Value{"cpu#!~#v": {tsm1.NewValue(5, 5.0)}})
+	if err := os.Remove(seg); err != nil {
+		t.Fatal(err)
+	}
+	read()
+	if len(got) != 1 || got[0] != 5 {
+		t.Fatalf("new shard: got %v, want [5]", got)
+	}
+	if _, ok := tail.offsets[seg]; ok {
+		t.Fatal("removed file not forgotten")
+	}
+}
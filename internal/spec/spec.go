@@ -0,0 +1,174 @@
+// Package spec records the command line of a command as a job spec file and replays it, so that complex
+// invocations are stored declaratively, reviewed and versioned instead of copied and pasted.
+package spec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const Version = 1
+
+// Spec is a job spec, the command path without the program name and the flags set on the command line.
+type Spec struct {
+	Version int               `yaml:"version"`
+	Command string            `yaml:"command"`
+	Flags   map[string]Values `yaml:"flags,omitempty"`
+}
+
+// Values are the values of a flag, a scalar for most flags, or a sequence for the flags set multiple times.
+type Values []string
+
+func (v *Values) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*v = Values{node.Value}
+		return nil
+	case yaml.SequenceNode:
+		var values []string
+		if err := node.Decode(&values); err != nil {
+			return err
+		}
+		*v = values
+		return nil
+	default:
+		return fmt.Errorf("line %d: flag value must be a scalar or a sequence", node.Line)
+	}
+}
+
+// MarshalYAML writes the values untagged, so that numbers and booleans are not quoted as strings.
+func (v Values) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, s := range v {
+		n := &yaml.Node{Kind: yaml.ScalarNode, Value: s}
+		if s == "" {
+			n.Style = yaml.DoubleQuotedStyle
+		}
+		node.Content = append(node.Content, n)
+	}
+	if len(v) == 1 {
+		return node.Content[0], nil
+	}
+	return node, nil
+}
+
+// FromCommand records the flags changed on the command line of c, except the excluded ones.
+func FromCommand(c *cobra.Command, exclude ...string) *Spec {
+	s := &Spec{
+		Version: Version,
+		Command: strings.TrimPrefix(c.CommandPath(), c.Root().Name()+" "),
+		Flags:   make(map[string]Values),
+	}
+	c.Flags().Visit(func(f *pflag.Flag) {
+		for _, name := range exclude {
+			if f.Name == name {
+				return
+			}
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			s.Flags[f.Name] = sv.GetSlice()
+		} else {
+			s.Flags[f.Name] = Values{f.Value.String()}
+		}
+	})
+	return s
+}
+
+// Save writes the spec to path, readable only by the owner since the flags may contain tokens.
+func (s *Spec) Save(path string) error {
+	var buf bytes.Buffer
+	buf.WriteString("# influx-tool job spec, replay with: influx-tool run " + path + "\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// Load reads the spec from path, unknown fields are rejected.
+func Load(path string) (*Spec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spec{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err = dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("spec %s is invalid: %v", path, err)
+	}
+	if s.Version != Version {
+		return nil, fmt.Errorf("spec %s is invalid: unsupported version %d", path, s.Version)
+	}
+	if strings.TrimSpace(s.Command) == "" {
+		return nil, fmt.Errorf("spec %s is invalid: command is required", path)
+	}
+	return s, nil
+}
+
+// Find returns the runnable command of the spec in the commands of root.
+func (s *Spec) Find(root *cobra.Command) (*cobra.Command, error) {
+	c, rest, err := root.Find(strings.Fields(s.Command))
+	if err != nil || c == root || len(rest) > 0 {
+		return nil, fmt.Errorf("command %q is unknown", s.Command)
+	}
+	if !c.Runnable() {
+		return nil, fmt.Errorf("command %q is not runnable", s.Command)
+	}
+	return c, nil
+}
+
+// Args validates the flags of the spec against the command c found, and returns the arguments to execute the root
+// command with. The flags must be known by c and not excluded, repeated only if the flag accepts multiple values,
+// and the required flags must be set. The values are validated by c itself before running.
+func (s *Spec) Args(c *cobra.Command, exclude ...string) ([]string, error) {
+	names := make([]string, 0, len(s.Flags))
+	for name := range s.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := strings.Fields(s.Command)
+	for _, name := range names {
+		for _, ex := range exclude {
+			if name == ex {
+				return nil, fmt.Errorf("flag %q is not allowed in a spec", name)
+			}
+		}
+		f := c.Flag(name)
+		if f == nil {
+			return nil, fmt.Errorf("flag %q is unknown to command %q", name, s.Command)
+		}
+		values := s.Flags[name]
+		if _, ok := f.Value.(pflag.SliceValue); !ok && len(values) != 1 {
+			return nil, fmt.Errorf("flag %q requires a single value", name)
+		}
+		for _, v := range values {
+			args = append(args, "--"+name+"="+v)
+		}
+	}
+
+	var missing []string
+	c.LocalFlags().VisitAll(func(f *pflag.Flag) {
+		if _, required := f.Annotations[cobra.BashCompOneRequiredFlag]; required {
+			if _, ok := s.Flags[f.Name]; !ok {
+				missing = append(missing, f.Name)
+			}
+		}
+	})
+	if len(missing) > 0 {
+		return nil, errors.New("required flags are missing: " + strings.Join(missing, ", "))
+	}
+	return args, nil
+}
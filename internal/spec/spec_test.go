@@ -0,0 +1,72 @@
+package spec
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func newRoot() (*cobra.Command, *cobra.Command) {
+	root := &cobra.Command{Use: "tool"}
+	root.PersistentFlags().String("save-spec", "", "")
+	sub := &cobra.Command{Use: "sub", RunE: func(c *cobra.Command, args []string) error { return nil }}
+	sub.Flags().StringP("dir", "d", "", "")
+	sub.Flags().Int("total", 1, "")
+	sub.Flags().StringArrayP("measurement", "m", nil, "")
+	sub.MarkFlagRequired("dir")
+	root.AddCommand(sub)
+	return root, sub
+}
+
+func TestSaveLoad(t *testing.T) {
+	root, sub := newRoot()
+	root.SetArgs([]string{"sub", "-d", "/data", "--total", "3", "-m", "cpu", "-m", "", "--save-spec", "x"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "job.yaml")
+	if err := FromCommand(sub, "save-spec").Save(path); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.Find(root)
+	if err != nil || c != sub {
+		t.Fatalf("unexpected command: %v, %v", c, err)
+	}
+	args, err := s.Args(c, "save-spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"sub", "--dir=/data", "--measurement=cpu", "--measurement=", "--total=3"}
+	if !reflect.DeepEqual(args, exp) {
+		t.Fatalf("unexpected args: %q", args)
+	}
+}
+
+func TestArgsInvalid(t *testing.T) {
+	root, sub := newRoot()
+	tests := []struct {
+		name string
+		spec *Spec
+	}{
+		{name: "unknown flag", spec: &Spec{Command: "sub", Flags: map[string]Values{"dir": {"a"}, "foo": {"1"}}}},
+		{name: "excluded flag", spec: &Spec{Command: "sub", Flags: map[string]Values{"dir": {"a"}, "save-spec": {"b"}}}},
+		{name: "repeated flag", spec: &Spec{Command: "sub", Flags: map[string]Values{"dir": {"a", "b"}}}},
+		{name: "required flag", spec: &Spec{Command: "sub", Flags: map[string]Values{"total": {"2"}}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.spec.Args(sub, "save-spec"); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+	if _, err := (&Spec{Command: "other"}).Find(root); err == nil {
+		t.Fatal("expected error for unknown command")
+	}
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/influxdata/influxdb/services/meta"
+	"github.com/influxdata/influxdb/tsdb"
+)
+
+// Reader reads the series of a database and retention policy from the shards on disk.
+// Shards are opened lazily, only those overlapping the time range to read.
+type Reader struct {
+	db, rp    string
+	groups    []meta.ShardGroupInfo
+	tsdbStore *tsdb.Store
+	store     *Store
+}
+
+func NewReader(tsdbConfig tsdb.Config, db, rp string, groups []meta.ShardGroupInfo) *Reader {
+	store := tsdb.NewStore(tsdbConfig.Dir)
+	store.EngineOptions.MonitorDisabled = true
+	store.EngineOptions.CompactionDisabled = true
+	store.EngineOptions.Config = tsdbConfig
+	store.EngineOptions.EngineVersion = tsdbConfig.Engine
+	store.EngineOptions.IndexVersion = tsdbConfig.Index
+	store.EngineOptions.DatabaseFilter = func(database string) bool {
+		return database == db
+	}
+	store.EngineOptions.RetentionPolicyFilter = func(_, policy string) bool {
+		return policy == rp
+	}
+	store.EngineOptions.ShardFilter = func(_, _ string, _ uint64) bool {
+		return false
+	}
+
+	return &Reader{
+		db:        db,
+		rp:        rp,
+		groups:    groups,
+		tsdbStore: store,
+		store:     &Store{TSDBStore: store},
+	}
+}
+
+func (r *Reader) Open() (err error) {
+	err = r.tsdbStore.Open()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Reader) Close() error {
+	return r.tsdbStore.Close()
+}
+
+// Read creates a ResultSet that reads all points with a timestamp ts, such that min ≤ ts ≤ max.
+func (r *Reader) Read(min, max time.Time) (*ResultSet, error) {
+	shards, err := r.getShards(min, max)
+	if err != nil {
+		return nil, err
+	}
+
+	req := ReadRequest{
+		Database: r.db,
+		RP:       r.rp,
+		Shards:   shards,
+		Start:    min.UnixNano(),
+		End:      max.UnixNano(),
+	}
+
+	return r.store.Read(context.Background(), &req)
+}
+
+func (r *Reader) getShards(min, max time.Time) ([]*tsdb.Shard, error) {
+	groups := r.shardsGroupsByTimeRange(min, max)
+	var ids []uint64
+	for _, g := range groups {
+		for _, s := range g.Shards {
+			ids = append(ids, s.ID)
+		}
+	}
+
+	shards := r.tsdbStore.Shards(ids)
+	if len(shards) == len(ids) {
+		return shards, nil
+	}
+
+	return r.openStoreWithShardsIDs(ids)
+}
+
+func (r *Reader) shardsGroupsByTimeRange(min, max time.Time) []meta.ShardGroupInfo {
+	groups := make([]meta.ShardGroupInfo, 0, len(r.groups))
+	for _, g := range r.groups {
+		if !g.Overlaps(min, max) {
+			continue
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func (r *Reader) openStoreWithShardsIDs(ids []uint64) ([]*tsdb.Shard, error) {
+	r.tsdbStore.Close()
+	r.tsdbStore.EngineOptions.ShardFilter = func(_, _ string, id uint64) bool {
+		for i := range ids {
+			if id == ids[i] {
+				return true
+			}
+		}
+		return false
+	}
+	if err := r.tsdbStore.Open(); err != nil {
+		return nil, err
+	}
+	return r.tsdbStore.Shards(ids), nil
+}
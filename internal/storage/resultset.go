@@ -55,6 +55,7 @@ func (r *ResultSet) CursorIterator() *CursorIterator {
 	r.ci.req.Tags = r.row.tags
 	r.ci.req.Field = r.row.field.n
 	r.ci.itrs = r.row.query
+	r.ci.err = nil
 
 	return &r.ci
 }
@@ -64,10 +65,11 @@ type CursorIterator struct {
 	req  tsdb.CursorRequest
 	itrs tsdb.CursorIterators
 	cur  tsdb.Cursor
+	err  error
 }
 
 func (ci *CursorIterator) Next() bool {
-	if len(ci.itrs) == 0 {
+	if len(ci.itrs) == 0 || ci.err != nil {
 		return false
 	}
 
@@ -75,12 +77,20 @@ func (ci *CursorIterator) Next() bool {
 	ci.cur = nil
 	for ci.cur == nil && len(ci.itrs) > 0 {
 		shard, ci.itrs = ci.itrs[0], ci.itrs[1:]
-		ci.cur, _ = shard.Next(ci.ctx, &ci.req)
+		if ci.cur, ci.err = shard.Next(ci.ctx, &ci.req); ci.err != nil {
+			ci.cur = nil
+			return false
+		}
 	}
 
 	return ci.cur != nil
 }
 
+// Err returns the error creating the cursor of a shard that stopped the iteration.
+func (ci *CursorIterator) Err() error {
+	return ci.err
+}
+
 func (ci *CursorIterator) Cursor() tsdb.Cursor {
 	return ci.cur
 }
@@ -0,0 +1,423 @@
+// Package tsmread reads tsm files by mmap like influxd, or by buffered reads for the filesystems where mmap performs
+// poorly or faults, such as NFS and some container filesystems.
+package tsmread
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+// Modes of reading tsm files.
+const (
+	ModeMmap     = "mmap"
+	ModeBuffered = "buffered"
+)
+
+// ValidMode returns whether mode is mmap or buffered.
+func ValidMode(mode string) bool {
+	return mode == ModeMmap || mode == ModeBuffered
+}
+
+// File is a tsm file opened by mmap or buffered reads, the tombstones of which are applied unless opened by
+// OpenIgnoringTombstones.
+type File interface {
+	Path() string
+	KeyCount() int
+	KeyAt(idx int) ([]byte, byte)
+	TimeRange() (int64, int64)
+	ReadAll(key []byte) ([]tsm1.Value, error)
+	// ReadRange returns the values for a key within the time range [start, end], the blocks out of it are not read.
+	ReadRange(key []byte, start, end int64) ([]tsm1.Value, error)
+	// ReadChunks calls fn with the values for a key within the time range [start, end] in chunks of about maxValues
+	// values in time order, decoding the blocks one by one, or in a chunk if maxValues is 0.
+	ReadChunks(key []byte, start, end int64, maxValues int, fn func(values []tsm1.Value) error) error
+	ReadEntries(key []byte, entries *[]tsm1.IndexEntry) []tsm1.IndexEntry
+	ReadBytes(e *tsm1.IndexEntry, b []byte) (uint32, []byte, error)
+	// ReadBlocks calls fn with each block in key and time order, the block is the encoded values without checksum.
+	// The blocks with values deleted by tombstones are re-encoded without them.
+	ReadBlocks(fn func(key []byte, minTime, maxTime int64, block []byte) error) error
+	Close() error
+}
+
+// Open opens the tsm file of path in mode.
+func Open(path, mode string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var r File
+	if mode == ModeBuffered {
+		r, err = NewFileReader(f)
+	} else {
+		var tr *tsm1.TSMReader
+		if tr, err = tsm1.NewTSMReader(f); err == nil {
+			r = MmapReader{tr}
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// OpenIgnoringTombstones opens the tsm file of path by buffered reads without applying its tombstones, so that the
+// values deleted are read too.
+func OpenIgnoringTombstones(path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newFileReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// MmapReader is the file opened by mmap, which adds ReadBlocks to tsm1.TSMReader.
+type MmapReader struct {
+	*tsm1.TSMReader
+}
+
+func (r MmapReader) ReadRange(key []byte, start, end int64) ([]tsm1.Value, error) {
+	return readRange(r.ReadEntries(key, nil), r.TombstoneRange(key), r.ReadBytes, start, end)
+}
+
+func (r MmapReader) ReadChunks(key []byte, start, end int64, maxValues int, fn func(values []tsm1.Value) error) error {
+	return readChunks(r.ReadEntries(key, nil), r.TombstoneRange(key), r.ReadBytes, start, end, maxValues, fn)
+}
+
+func (r MmapReader) ReadBlocks(fn func(key []byte, minTime, maxTime int64, block []byte) error) error {
+	iter := r.BlockIterator()
+	var lastKey []byte
+	var tombstones []tsm1.TimeRange
+	for iter.Next() {
+		key, minTime, maxTime, _, _, block, err := iter.Read()
+		if err != nil {
+			return fmt.Errorf("read block of %s error: %v", r.Path(), err)
+		}
+		if !bytes.Equal(key, lastKey) {
+			lastKey, tombstones = append(lastKey[:0], key...), r.TombstoneRange(key)
+		}
+		if minTime, maxTime, block, err = excludeTombstones(tombstones, minTime, maxTime, block); err != nil {
+			return fmt.Errorf("read block of %s error: %v", r.Path(), err)
+		}
+		if block == nil {
+			continue
+		}
+		if err = fn(key, minTime, maxTime, block); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// Reader reads a tsm file by ReadAt with the index loaded into memory, such as a file held in memory or read by
+// buffered reads.
+type Reader struct {
+	r      io.ReaderAt
+	size   int64
+	path   string
+	closer io.Closer
+	index  tsm1.TSMIndex
+}
+
+// NewReaderAt returns the reader of the tsm file of size read by r, without tombstones.
+func NewReaderAt(r io.ReaderAt, size int64) (*Reader, error) {
+	// header is 4 bytes magic number and 1 byte version, footer is 8 bytes index offset
+	if size < 13 {
+		return nil, errors.New("tsm file too small")
+	}
+	var header [5]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[:4]) != tsm1.MagicNumber {
+		return nil, errors.New("invalid tsm file magic number")
+	}
+	if header[4] != tsm1.Version {
+		return nil, fmt.Errorf("unsupported tsm file version %d", header[4])
+	}
+	var footer [8]byte
+	indexEnd := size - 8
+	if _, err := r.ReadAt(footer[:], indexEnd); err != nil {
+		return nil, err
+	}
+	indexStart := binary.BigEndian.Uint64(footer[:])
+	if indexStart < 5 || indexStart > uint64(indexEnd) {
+		return nil, errors.New("invalid tsm file index offset")
+	}
+	b := make([]byte, indexEnd-int64(indexStart))
+	if _, err := r.ReadAt(b, int64(indexStart)); err != nil {
+		return nil, err
+	}
+	index := tsm1.NewIndirectIndex()
+	if err := index.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, size: size, index: index}, nil
+}
+
+// NewFileReader returns the reader of the tsm file f by buffered reads with the tombstones applied, f is closed by
+// the reader.
+func NewFileReader(f *os.File) (*Reader, error) {
+	r, err := newFileReader(f)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.applyTombstones(r.path); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// newFileReader returns the reader of the tsm file f by buffered reads without tombstones.
+func newFileReader(f *os.File) (*Reader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReaderAt(f, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	r.path, r.closer = f.Name(), f
+	return r, nil
+}
+
+// ApplyTombstones applies the tombstones of the tombstone file content b, for the tsm files read from archives whose
+// tombstone files are not next to them on disk.
+func (r *Reader) ApplyTombstones(b []byte) error {
+	// the tombstones of all the versions are read by tsm1.Tombstoner, which reads the file next to a tsm file only
+	dir, err := os.MkdirTemp("", "influx-tool-tombstone")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	name := "000000001-000000001."
+	if err = os.WriteFile(filepath.Join(dir, name+tsm1.TombstoneFileExtension), b, 0644); err != nil {
+		return err
+	}
+	return r.applyTombstones(filepath.Join(dir, name+tsm1.TSMFileExtension))
+}
+
+// applyTombstones applies the tombstones of the tombstone file next to the tsm file of path.
+func (r *Reader) applyTombstones(path string) error {
+	err := tsm1.NewTombstoner(path, r.index.ContainsKey).Walk(func(t tsm1.Tombstone) error {
+		r.index.DeleteRange([][]byte{t.Key}, t.Min, t.Max)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("read tombstones of %s error: %v", r.path, err)
+	}
+	return nil
+}
+
+func (r *Reader) Path() string {
+	return r.path
+}
+
+func (r *Reader) KeyCount() int {
+	return r.index.KeyCount()
+}
+
+func (r *Reader) KeyAt(idx int) ([]byte, byte) {
+	return r.index.KeyAt(idx)
+}
+
+func (r *Reader) TimeRange() (int64, int64) {
+	return r.index.TimeRange()
+}
+
+func (r *Reader) ReadEntries(key []byte, entries *[]tsm1.IndexEntry) []tsm1.IndexEntry {
+	return r.index.ReadEntries(key, entries)
+}
+
+// ReadBytes returns the checksum and the encoded values of the block of the entry, b is reused if large enough.
+func (r *Reader) ReadBytes(e *tsm1.IndexEntry, b []byte) (uint32, []byte, error) {
+	b, err := r.readBlock(e, b)
+	if err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(b[:4]), b[4:], nil
+}
+
+// readBlock reads the block of the entry starting with 4 bytes checksum into b, which is reused if large enough.
+func (r *Reader) readBlock(e *tsm1.IndexEntry, b []byte) ([]byte, error) {
+	if e.Size < 4 || e.Offset+int64(e.Size) > r.size {
+		return nil, fmt.Errorf("block out of range: offset %d, size %d", e.Offset, e.Size)
+	}
+	if cap(b) < int(e.Size) {
+		b = make([]byte, e.Size)
+	}
+	b = b[:e.Size]
+	if _, err := r.r.ReadAt(b, e.Offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ReadAll returns all values for a key in all blocks, except the ones deleted by tombstones.
+func (r *Reader) ReadAll(key []byte) ([]tsm1.Value, error) {
+	var values tsm1.Values
+	var buf []byte
+	entries := r.index.Entries(key)
+	for i := range entries {
+		var err error
+		if buf, err = r.readBlock(&entries[i], buf); err != nil {
+			return nil, err
+		}
+		vs, err := tsm1.DecodeBlock(buf[4:], nil)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, vs...)
+	}
+	if len(entries) > 1 {
+		values = values.Deduplicate()
+	}
+	for _, t := range r.index.TombstoneRange(key) {
+		values = values.Exclude(t.Min, t.Max)
+	}
+	return values, nil
+}
+
+func (r *Reader) ReadRange(key []byte, start, end int64) ([]tsm1.Value, error) {
+	return readRange(r.index.Entries(key), r.index.TombstoneRange(key), r.ReadBytes, start, end)
+}
+
+func (r *Reader) ReadChunks(key []byte, start, end int64, maxValues int, fn func(values []tsm1.Value) error) error {
+	return readChunks(r.index.Entries(key), r.index.TombstoneRange(key), r.ReadBytes, start, end, maxValues, fn)
+}
+
+// readRange decodes the blocks of the entries overlapping the time range [start, end] read by readBytes, and returns
+// their values within the range except the ones deleted by tombstones.
+func readRange(entries []tsm1.IndexEntry, tombstones []tsm1.TimeRange, readBytes func(e *tsm1.IndexEntry, b []byte) (uint32, []byte, error), start, end int64) ([]tsm1.Value, error) {
+	var values []tsm1.Value
+	err := readChunks(entries, tombstones, readBytes, start, end, 0, func(vs []tsm1.Value) error {
+		values = vs
+		return nil
+	})
+	return values, err
+}
+
+// readChunks decodes the blocks of the entries overlapping the time range [start, end] read by readBytes one by one,
+// and calls fn with their values within the range except the ones deleted by tombstones, once the values decoded
+// reach maxValues and the next block starts after them. The entries are sorted by min time, so the blocks overlapping
+// are merged into the same chunk, which exceeds maxValues by them, and the chunks are in time order.
+func readChunks(entries []tsm1.IndexEntry, tombstones []tsm1.TimeRange, readBytes func(e *tsm1.IndexEntry, b []byte) (uint32, []byte, error), start, end int64, maxValues int, fn func(values []tsm1.Value) error) error {
+	var values tsm1.Values
+	var buf []byte
+	blocks := 0
+	maxTime := int64(math.MinInt64)
+	flush := func() error {
+		if blocks > 1 {
+			values = values.Deduplicate()
+		}
+		for _, t := range tombstones {
+			values = values.Exclude(t.Min, t.Max)
+		}
+		var err error
+		if len(values) > 0 {
+			err = fn(values)
+		}
+		values, blocks, maxTime = nil, 0, math.MinInt64
+		return err
+	}
+	for i := range entries {
+		if !entries[i].OverlapsTimeRange(start, end) {
+			continue
+		}
+		_, b, err := readBytes(&entries[i], buf)
+		if err != nil {
+			return err
+		}
+		vs, err := tsm1.DecodeBlock(b, nil)
+		if err != nil {
+			return err
+		}
+		values = append(values, tsm1.Values(vs).Include(start, end)...)
+		buf, blocks = b, blocks+1
+		if entries[i].MaxTime > maxTime {
+			maxTime = entries[i].MaxTime
+		}
+		if maxValues > 0 && len(values) >= maxValues && (i == len(entries)-1 || entries[i+1].MinTime > maxTime) {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+func (r *Reader) ReadBlocks(fn func(key []byte, minTime, maxTime int64, block []byte) error) error {
+	for i := 0; i < r.index.KeyCount(); i++ {
+		key, _ := r.index.KeyAt(i)
+		tombstones := r.index.TombstoneRange(key)
+		for _, e := range r.index.Entries(key) {
+			_, block, err := r.ReadBytes(&e, nil)
+			if err != nil {
+				return err
+			}
+			minTime, maxTime, block, err := excludeTombstones(tombstones, e.MinTime, e.MaxTime, block)
+			if err != nil {
+				return err
+			}
+			if block == nil {
+				continue
+			}
+			if err = fn(key, minTime, maxTime, block); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// excludeTombstones returns the block re-encoded without the values deleted by the tombstones if any of them are,
+// or a nil block if all of them are. The block is decoded only if overlapping a tombstone.
+func excludeTombstones(tombstones []tsm1.TimeRange, minTime, maxTime int64, block []byte) (int64, int64, []byte, error) {
+	overlapped := false
+	for _, t := range tombstones {
+		if t.Min <= maxTime && t.Max >= minTime {
+			overlapped = true
+			break
+		}
+	}
+	if !overlapped {
+		return minTime, maxTime, block, nil
+	}
+	vs, err := tsm1.DecodeBlock(block, nil)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	values := tsm1.Values(vs)
+	for _, t := range tombstones {
+		values = values.Exclude(t.Min, t.Max)
+	}
+	if len(values) == 0 {
+		return 0, 0, nil, nil
+	}
+	if block, err = values.Encode(nil); err != nil {
+		return 0, 0, nil, err
+	}
+	return values[0].UnixNano(), values[len(values)-1].UnixNano(), block, nil
+}
+
+// Close closes the file read if any.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
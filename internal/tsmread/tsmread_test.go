@@ -0,0 +1,179 @@
+package tsmread
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/tsdb/engine/tsm1"
+)
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000000001-000000001.tsm")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := []struct {
+		key    string
+		values []tsm1.Value
+	}{
+		{"cpu,host=a#!~#usage", []tsm1.Value{tsm1.NewFloatValue(1, 1.5), tsm1.NewFloatValue(3, 3.5)}},
+		{"cpu,host=a#!~#usage", []tsm1.Value{tsm1.NewFloatValue(4, 4.5), tsm1.NewFloatValue(5, 5.5)}},
+		{"cpu,host=b#!~#usage", []tsm1.Value{tsm1.NewFloatValue(2, 2.5)}},
+		{"mem,host=a#!~#used", []tsm1.Value{tsm1.NewIntegerValue(4, 10)}},
+	}
+	for _, b := range blocks {
+		if err = w.Write([]byte(b.key), b.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.WriteIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts := tsm1.NewTombstoner(path, nil)
+	if err = ts.AddRange([][]byte{[]byte("cpu,host=a#!~#usage")}, 5, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err = ts.Add([][]byte{[]byte("cpu,host=b#!~#usage")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = ts.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"range 1-5",
+		"cpu,host=a#!~#usage: 1 1.5 3 3.5 4 4.5",
+		"cpu,host=a#!~#usage: block 1-3",
+		"cpu,host=a#!~#usage: block 4-5",
+		"cpu,host=a#!~#usage: range 3-5 3 3.5 4 4.5",
+		"mem,host=a#!~#used: 4 10",
+		"mem,host=a#!~#used: block 4-4",
+		"mem,host=a#!~#used: range 3-5 4 10",
+	}
+	for _, mode := range []string{ModeMmap, ModeBuffered} {
+		r, err := Open(path, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		minTime, maxTime := r.TimeRange()
+		got := []string{fmt.Sprintf("range %d-%d", minTime, maxTime)}
+		for i := 0; i < r.KeyCount(); i++ {
+			key, _ := r.KeyAt(i)
+			values, err := r.ReadAll(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := fmt.Sprintf("%s:", key)
+			for _, v := range values {
+				s += fmt.Sprintf(" %d %v", v.UnixNano(), v.Value())
+			}
+			got = append(got, s)
+			for _, e := range r.ReadEntries(key, nil) {
+				got = append(got, fmt.Sprintf("%s: block %d-%d", key, e.MinTime, e.MaxTime))
+			}
+			// the values in range of the blocks overlapping it, the tombstones applied
+			if values, err = r.ReadRange(key, 3, 5); err != nil {
+				t.Fatal(err)
+			}
+			if len(values) > 0 {
+				s = fmt.Sprintf("%s: range 3-5", key)
+				for _, v := range values {
+					s += fmt.Sprintf(" %d %v", v.UnixNano(), v.Value())
+				}
+				got = append(got, s)
+			}
+		}
+		if err = r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(got, exp) {
+			t.Errorf("%s: unexpected result: got=%v, exp=%v", mode, got, exp)
+		}
+	}
+}
+
+func TestReadChunks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000000001-000000001.tsm")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := tsm1.NewTSMWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("cpu,host=a#!~#usage")
+	// the third block overlaps the second one, such as the blocks of files compacted with points written out of order
+	for _, values := range [][]tsm1.Value{
+		{tsm1.NewIntegerValue(1, 1), tsm1.NewIntegerValue(2, 2)},
+		{tsm1.NewIntegerValue(3, 3), tsm1.NewIntegerValue(4, 4)},
+		{tsm1.NewIntegerValue(4, 40), tsm1.NewIntegerValue(5, 5), tsm1.NewIntegerValue(6, 6)},
+		{tsm1.NewIntegerValue(7, 7), tsm1.NewIntegerValue(8, 8)},
+	} {
+		if err = w.Write(key, values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.WriteIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts := tsm1.NewTombstoner(path, nil)
+	if err = ts.AddRange([][]byte{key}, 8, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err = ts.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		maxValues  int
+		start, end int64
+		exp        []string
+	}{
+		{maxValues: 2, start: 0, end: 10, exp: []string{"1=1 2=2", "3=3 4=40 5=5 6=6", "7=7"}},
+		{maxValues: 5, start: 0, end: 10, exp: []string{"1=1 2=2 3=3 4=40 5=5 6=6", "7=7"}},
+		{maxValues: 1, start: 2, end: 3, exp: []string{"2=2", "3=3"}},
+		{maxValues: 0, start: 0, end: 10, exp: []string{"1=1 2=2 3=3 4=40 5=5 6=6 7=7"}},
+	}
+	for _, mode := range []string{ModeMmap, ModeBuffered} {
+		r, err := Open(path, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			var got []string
+			err = r.ReadChunks(key, tt.start, tt.end, tt.maxValues, func(values []tsm1.Value) error {
+				var s []string
+				for _, v := range values {
+					s = append(s, fmt.Sprintf("%d=%v", v.UnixNano(), v.Value()))
+				}
+				got = append(got, strings.Join(s, " "))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(got, tt.exp) {
+				t.Errorf("%s: max values %d: unexpected chunks: got=%v, exp=%v", mode, tt.maxValues, got, tt.exp)
+			}
+		}
+		if err = r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
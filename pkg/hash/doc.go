@@ -0,0 +1,9 @@
+// Package hash routes the measurements and series to the nodes of influx proxy circles, by the shard key rendered
+// from a template like "%db,%mm" and the consistent hash of the node keys idx, exi or a template containing %idx,
+// the same as influx-proxy does.
+//
+// It is a module of its own, github.com/chengshiwen/influx-tool/pkg/hash, tagged as pkg/hash/vX.Y.Z, so that other
+// tools and services can route identically without depending on influx-tool. Within a major version, the exported
+// API is kept compatible and the routing of every key is kept unchanged, which is pinned by the golden vectors in
+// testdata/golden.json.
+package hash
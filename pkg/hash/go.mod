@@ -0,0 +1,5 @@
+module github.com/chengshiwen/influx-tool/pkg/hash
+
+go 1.21
+
+require stathat.com/c/consistent v1.0.0
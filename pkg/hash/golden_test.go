@@ -0,0 +1,66 @@
+package hash
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+)
+
+// goldenVectors are the routing of keys recorded in testdata/golden.json, which must be kept by every release of the
+// same major version, so that the tools depending on this module route the same keys to the same nodes as
+// influx-proxy and each other. The routes are rendered by the circles of the influx-proxy release recorded, by
+// "make golden" running testdata/gen in a checkout of the release pinned by INFLUX_PROXY, and the templates are
+// recorded by hand. The vectors must never be regenerated to make a change pass.
+type goldenVectors struct {
+	InfluxProxy string           `json:"influx_proxy"` // influx-proxy release rendering the routes
+	Routes      []routeVector    `json:"routes"`
+	Templates   []templateVector `json:"templates"`
+}
+
+type routeVector struct {
+	HashKey   string         `json:"hash_key"`
+	NodeTotal int            `json:"node_total"`
+	Nodes     map[string]int `json:"nodes"`  // node index by shard key
+	Points    []int          `json:"points"` // virtual points by node index
+}
+
+type templateVector struct {
+	Template string `json:"template"`
+	Key      string `json:"key"`    // rendered by db "db", rp "autogen" and measurement "cpu"
+	KeyV2    string `json:"key_v2"` // rendered by org "org", bucket "bucket" and measurement "cpu"
+}
+
+func TestGolden(t *testing.T) {
+	b, err := os.ReadFile("testdata/golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g goldenVectors
+	if err = json.Unmarshal(b, &g); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Routes) == 0 || len(g.Templates) == 0 {
+		t.Fatal("no golden vectors")
+	}
+	for _, v := range g.Routes {
+		ch := NewConsistentHash(v.NodeTotal, v.HashKey)
+		for key, idx := range v.Nodes {
+			if got := ch.Get(key); got != idx {
+				t.Errorf("%d nodes keyed by %s: key %q routed to node %d, want %d", v.NodeTotal, v.HashKey, key, got, idx)
+			}
+		}
+		if points := VirtualPoints(v.NodeTotal, v.HashKey); !slices.Equal(points, v.Points) {
+			t.Errorf("%d nodes keyed by %s: got virtual points %v, want %v", v.NodeTotal, v.HashKey, points, v.Points)
+		}
+	}
+	for _, v := range g.Templates {
+		st := NewShardTpl(v.Template)
+		if key := st.GetKey("db", "autogen", []byte("cpu")); key != v.Key {
+			t.Errorf("template %q: got key %q, want %q", v.Template, key, v.Key)
+		}
+		if key := st.GetKeyV2("org", "bucket", "cpu"); key != v.KeyV2 {
+			t.Errorf("template %q: got key v2 %q, want %q", v.Template, key, v.KeyV2)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package hash
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+
+	"stathat.com/c/consistent"
+)
+
+var (
+	HashKeyIdx      = "idx"
+	HashKeyExi      = "exi"
+	HashKeyVarIdx   = "%idx"
+	ShardKeyVarOrg  = "%org"
+	ShardKeyVarBk   = "%bk"
+	ShardKeyVarDb   = "%db"
+	ShardKeyVarRp   = "%rp"
+	ShardKeyVarMm   = "%mm"
+	ShardKeyOrgBkMm = "%org,%bk,%mm"
+	ShardKeyDbMm    = "%db,%mm"
+)
+
+type Hash interface {
+	Get(key string) int
+}
+
+type ConsistentHash struct {
+	consistent *consistent.Consistent
+	mapToIdx   map[string]int
+	cache      sync.Map
+}
+
+func NewConsistentHash(nodeTotal int, hashKey string) *ConsistentHash {
+	ch := &ConsistentHash{
+		consistent: consistent.New(),
+		mapToIdx:   make(map[string]int),
+	}
+	ch.consistent.NumberOfReplicas = virtualReplicas
+	for idx := 0; idx < nodeTotal; idx++ {
+		key := nodeKey(hashKey, idx)
+		ch.consistent.Add(key)
+		ch.mapToIdx[key] = idx
+	}
+	return ch
+}
+
+const virtualReplicas = 256
+
+func nodeKey(hashKey string, idx int) string {
+	switch hashKey {
+	case HashKeyExi:
+		// exi: extended index, no hash collision will occur before idx <= 100000, which has been tested
+		return "|" + strconv.Itoa(idx)
+	case HashKeyIdx:
+		// idx: index, each additional backend causes 10% hash collision from 11th backend
+		return strconv.Itoa(idx)
+	default:
+		// %idx: custom template like "backend-%idx"
+		return strings.ReplaceAll(hashKey, HashKeyVarIdx, strconv.Itoa(idx))
+	}
+}
+
+// VirtualPoints returns the virtual points left on the circle by node index. The virtual points of a node are hashed
+// from its key prefixed with the replica number, and a point is abandoned by the earlier node once a later node is
+// hashed to it, e.g. replica 11 of node "1" and replica 1 of node "11" are both "111" under idx, so that the earlier
+// nodes are left with fewer points and receive less of the keys.
+func VirtualPoints(nodeTotal int, hashKey string) []int {
+	owners := make(map[uint32]int, nodeTotal*virtualReplicas)
+	for idx := 0; idx < nodeTotal; idx++ {
+		key := nodeKey(hashKey, idx)
+		for i := 0; i < virtualReplicas; i++ {
+			// the same as the hash of a virtual point in consistent
+			owners[crc32.ChecksumIEEE([]byte(strconv.Itoa(i)+key))] = idx
+		}
+	}
+	points := make([]int, nodeTotal)
+	for _, idx := range owners {
+		points[idx]++
+	}
+	return points
+}
+
+// CircleWarning returns the warning of the virtual points abandoned by hash collisions on the circle, with the nodes
+// left with the fewest and the most points, or an empty string if none is abandoned.
+func CircleWarning(nodeTotal int, hashKey string) string {
+	points := VirtualPoints(nodeTotal, hashKey)
+	total, min, max := 0, 0, 0
+	for idx, n := range points {
+		total += n
+		if n < points[min] {
+			min = idx
+		}
+		if n > points[max] {
+			max = idx
+		}
+	}
+	abandoned := nodeTotal*virtualReplicas - total
+	if abandoned == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d virtual points abandoned by hash collisions of hash key %q, ", abandoned, nodeTotal*virtualReplicas, hashKey)
+	fmt.Fprintf(&b, "node index %d keeps the fewest %d and node index %d the most %d of %d points, the keys are unevenly distributed", min, points[min], max, points[max], virtualReplicas)
+	if hashKey == HashKeyIdx {
+		b.WriteString(", exi or a template containing %idx is recommended for new circles")
+	}
+	return b.String()
+}
+
+func (ch *ConsistentHash) Get(key string) int {
+	if idx, ok := ch.cache.Load(key); ok {
+		return idx.(int)
+	}
+	str, _ := ch.consistent.Get(key)
+	idx := ch.mapToIdx[str]
+	ch.cache.Store(key, idx)
+	return idx
+}
+
+type Shard interface {
+	GetKey(db, rp string, mm []byte) string
+}
+
+// ValidShardKey reports whether the template of influxdb v1 contains %db, %rp or %mm.
+func ValidShardKey(tpl string) bool {
+	return strings.Contains(tpl, ShardKeyVarDb) || strings.Contains(tpl, ShardKeyVarRp) || strings.Contains(tpl, ShardKeyVarMm)
+}
+
+type ShardTpl struct {
+	tpl   string
+	parts []string
+	freq  map[string]int
+}
+
+var ShardKeyVar = []string{ShardKeyVarOrg, ShardKeyVarBk, ShardKeyVarDb, ShardKeyVarRp, ShardKeyVarMm}
+
+func NewShardTpl(tpl string) *ShardTpl {
+	st := &ShardTpl{tpl: tpl, freq: make(map[string]int)}
+	for _, v := range ShardKeyVar {
+		st.freq[v] = 0
+	}
+	for i := 0; i < len(tpl); {
+		for j := i; j < len(tpl); {
+			found := false
+			for _, v := range ShardKeyVar {
+				n := len(v)
+				if j <= len(tpl)-n && tpl[j:j+n] == v {
+					if j > i {
+						st.parts = append(st.parts, tpl[i:j])
+					}
+					st.parts = append(st.parts, tpl[j:j+n])
+					st.freq[tpl[j:j+n]]++
+					i, j = j+n, j+n
+					found = true
+					break
+				}
+			}
+			if found {
+				continue
+			}
+			j++
+			if j == len(tpl) {
+				st.parts = append(st.parts, tpl[i:j])
+				i = j
+				break
+			}
+		}
+	}
+	return st
+}
+
+// GetKey returns the shard key of the measurement, the retention policy is only rendered by templates
+// containing %rp, such as "%db,%rp,%mm" hashing it separately or "%db.%rp,%mm" combined with the database.
+func (st *ShardTpl) GetKey(db, rp string, mm []byte) string {
+	var b strings.Builder
+	b.Grow(len(st.tpl) + st.varDiffLen(db, ShardKeyVarDb) + st.varDiffLen(rp, ShardKeyVarRp) + st.varByteDiffLen(mm, ShardKeyVarMm))
+	for _, part := range st.parts {
+		if part == ShardKeyVarDb {
+			b.WriteString(db)
+		} else if part == ShardKeyVarRp {
+			b.WriteString(rp)
+		} else if part == ShardKeyVarMm {
+			b.Write(mm)
+		} else {
+			b.WriteString(part)
+		}
+	}
+	return b.String()
+}
+
+func (st *ShardTpl) GetKeyV2(org, bk, mm string) string {
+	var b strings.Builder
+	b.Grow(len(st.tpl) + st.varDiffLen(org, ShardKeyVarOrg) + st.varDiffLen(bk, ShardKeyVarBk) + st.varDiffLen(mm, ShardKeyVarMm))
+	for _, part := range st.parts {
+		if part == ShardKeyVarOrg {
+			b.WriteString(org)
+		} else if part == ShardKeyVarBk {
+			b.WriteString(bk)
+		} else if part == ShardKeyVarMm {
+			b.WriteString(mm)
+		} else {
+			b.WriteString(part)
+		}
+	}
+	return b.String()
+}
+
+func (st *ShardTpl) varDiffLen(r string, v string) int {
+	return (len(r) - len(v)) * st.freq[v]
+}
+
+func (st *ShardTpl) varByteDiffLen(r []byte, v string) int {
+	return (len(r) - len(v)) * st.freq[v]
+}
+
+// SpreadShard renders the shard key of a series, which is the shard key of its measurement, suffixed with the value
+// of the spread tag for the spread measurements, so that the series of a measurement too large for one node are
+// routed across the nodes by the tag, as newer versions of influx proxy do. The series without the tag are routed
+// by the measurement.
+type SpreadShard struct {
+	Shard
+	measurements map[string]struct{}
+	tag          []byte
+}
+
+func NewSpreadShard(s Shard, measurements []string, tag string) *SpreadShard {
+	ss := &SpreadShard{Shard: s, measurements: make(map[string]struct{}, len(measurements)), tag: []byte(tag)}
+	for _, mm := range measurements {
+		ss.measurements[mm] = struct{}{}
+	}
+	return ss
+}
+
+// Spread reports whether the series of the measurement are spread by the tag.
+func (ss *SpreadShard) Spread(mm []byte) bool {
+	_, ok := ss.measurements[string(mm)]
+	return ok
+}
+
+// Tags are the tags of a series, such as models.Tags of influxdb, which are not depended on by this module.
+type Tags interface {
+	Get(key []byte) []byte
+}
+
+// GetSeriesKey returns the shard key of the series of the measurement with the tags.
+func (ss *SpreadShard) GetSeriesKey(db, rp string, mm []byte, tags Tags) string {
+	key := ss.GetKey(db, rp, mm)
+	if !ss.Spread(mm) {
+		return key
+	}
+	if v := tags.Get(ss.tag); len(v) > 0 {
+		return SpreadKey(key, string(v))
+	}
+	return key
+}
+
+// SpreadKey returns the shard key of a series of a spread measurement, by the shard key of the measurement and the
+// value of the spread tag of the series.
+func SpreadKey(key, value string) string {
+	return key + "," + value
+}
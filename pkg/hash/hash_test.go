@@ -2,14 +2,23 @@ package hash
 
 import (
 	"slices"
+	"strconv"
 	"testing"
 )
 
+// testTags are the tags of a series by key.
+type testTags map[string]string
+
+func (tags testTags) Get(key []byte) []byte {
+	return []byte(tags[string(key)])
+}
+
 func TestShardTpl(t *testing.T) {
 	tests := []struct {
 		name   string
 		tpl    string
 		db     string
+		rp     string
 		mm     string
 		parts  []string
 		dbCnt  int
@@ -116,13 +125,35 @@ func TestShardTpl(t *testing.T) {
 			mmCnt:  2,
 			render: "shardmeasurementdatabasemeasurementdatabasekey",
 		},
+		{
+			name:   "test11",
+			tpl:    "%db,%rp,%mm",
+			db:     "database",
+			rp:     "autogen",
+			mm:     "measurement",
+			parts:  []string{"%db", ",", "%rp", ",", "%mm"},
+			dbCnt:  1,
+			mmCnt:  1,
+			render: "database,autogen,measurement",
+		},
+		{
+			name:   "test12",
+			tpl:    "%db.%rp,%mm",
+			db:     "database",
+			rp:     "autogen",
+			mm:     "measurement",
+			parts:  []string{"%db", ".", "%rp", ",", "%mm"},
+			dbCnt:  1,
+			mmCnt:  1,
+			render: "database.autogen,measurement",
+		},
 	}
 	for _, tt := range tests {
 		st := NewShardTpl(tt.tpl)
 		if !slices.Equal(st.parts, tt.parts) || st.freq[ShardKeyVarDb] != tt.dbCnt || st.freq[ShardKeyVarMm] != tt.mmCnt {
 			t.Errorf("%v: got %+v, %d, %d, want %+v, %d, %d", tt.name, st.parts, st.freq[ShardKeyVarDb], st.freq[ShardKeyVarMm], tt.parts, tt.dbCnt, tt.mmCnt)
 		}
-		if render := st.GetKey(tt.db, []byte(tt.mm)); render != tt.render {
+		if render := st.GetKey(tt.db, tt.rp, []byte(tt.mm)); render != tt.render {
 			t.Errorf("%v: got %s, want %s", tt.name, render, tt.render)
 		}
 	}
@@ -272,3 +303,59 @@ func TestShardTplV2(t *testing.T) {
 		}
 	}
 }
+
+func TestSpreadShard(t *testing.T) {
+	ss := NewSpreadShard(NewShardTpl("%db,%mm"), []string{"big"}, "host")
+	tests := []struct {
+		name string
+		mm   string
+		tags testTags
+		key  string
+	}{
+		{name: "not spread", mm: "cpu", tags: testTags{"host": "a"}, key: "db,cpu"},
+		{name: "spread", mm: "big", tags: testTags{"host": "a", "region": "x"}, key: "db,big,a"},
+		{name: "without tag", mm: "big", tags: testTags{"region": "x"}, key: "db,big"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key := ss.GetSeriesKey("db", "autogen", []byte(tt.mm), tt.tags); key != tt.key {
+				t.Errorf("got key %q, want %q", key, tt.key)
+			}
+		})
+	}
+
+	ch := NewConsistentHash(4, HashKeyIdx)
+	nodes := make(map[int]struct{})
+	for i := 0; i < 100; i++ {
+		tags := testTags{"host": "server" + strconv.Itoa(i)}
+		nodes[ch.Get(ss.GetSeriesKey("db", "autogen", []byte("big"), tags))] = struct{}{}
+	}
+	if len(nodes) != 4 {
+		t.Errorf("spread measurement routed to %d nodes, want 4", len(nodes))
+	}
+}
+
+func TestVirtualPoints(t *testing.T) {
+	tests := []struct {
+		nodeTotal int
+		hashKey   string
+		abandoned bool
+	}{
+		{nodeTotal: 10, hashKey: HashKeyIdx, abandoned: false},
+		{nodeTotal: 30, hashKey: HashKeyIdx, abandoned: true},
+		{nodeTotal: 30, hashKey: HashKeyExi, abandoned: false},
+		{nodeTotal: 30, hashKey: "backend-%idx", abandoned: false},
+	}
+	for _, tt := range tests {
+		total := 0
+		for _, n := range VirtualPoints(tt.nodeTotal, tt.hashKey) {
+			total += n
+		}
+		if abandoned := total < tt.nodeTotal*virtualReplicas; abandoned != tt.abandoned {
+			t.Errorf("%d nodes keyed by %s: got %d points, want abandoned %v", tt.nodeTotal, tt.hashKey, total, tt.abandoned)
+		}
+		if warning := CircleWarning(tt.nodeTotal, tt.hashKey); (warning != "") != tt.abandoned {
+			t.Errorf("%d nodes keyed by %s: unexpected warning %q", tt.nodeTotal, tt.hashKey, warning)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+//go:build ignore
+
+// Command gen renders the routes of testdata/golden.json by the circles of a pinned influx-proxy release, so that the
+// routing of this module is pinned to the one of influx-proxy rather than to itself. It is copied into a checkout of
+// the release and run there by "make golden", which records the release into the golden vectors. The templates of the golden vectors are kept
+// as they are, since %rp and the v2 keys are extensions of this module.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chengshiwen/influx-proxy/backend"
+)
+
+// the node totals and hash keys of the routes, the keys routed are kept from the golden vectors
+var (
+	nodeTotals = []int{1, 2, 3, 5, 8, 11, 16, 30}
+	hashKeys   = []string{"idx", "exi", "backend-%idx"}
+)
+
+const virtualReplicas = 256
+
+func main() {
+	if len(os.Args) != 3 {
+		log.Fatal("usage: go run golden/main.go <influx-proxy version> <golden.json>")
+	}
+	version, path := os.Args[1], os.Args[2]
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var golden map[string]json.RawMessage
+	if err = json.Unmarshal(b, &golden); err != nil {
+		log.Fatal(err)
+	}
+	var routes []struct {
+		Nodes map[string]int `json:"nodes"`
+	}
+	if err = json.Unmarshal(golden["routes"], &routes); err != nil || len(routes) == 0 {
+		log.Fatalf("no routes in %s: %v", path, err)
+	}
+	var keys []string
+	for key := range routes[0].Nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	dataDir, err := os.MkdirTemp("", "golden-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	var rendered []map[string]interface{}
+	for _, hashKey := range hashKeys {
+		for _, nodeTotal := range nodeTotals {
+			nodes, points := route(dataDir, hashKey, nodeTotal, keys)
+			rendered = append(rendered, map[string]interface{}{"hash_key": hashKey, "node_total": nodeTotal, "nodes": nodes, "points": points})
+		}
+	}
+	if golden["routes"], err = json.Marshal(rendered); err != nil {
+		log.Fatal(err)
+	}
+	golden["influx_proxy"], _ = json.Marshal(version)
+	if b, err = json.MarshalIndent(golden, "", "  "); err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// route returns the node index of each key routed by a circle of influx-proxy, and the virtual points left on the
+// circle by node index.
+func route(dataDir, hashKey string, nodeTotal int, keys []string) (map[string]int, []int) {
+	cfg := &backend.CircleConfig{Name: "circle"}
+	for idx := 0; idx < nodeTotal; idx++ {
+		cfg.Backends = append(cfg.Backends, &backend.BackendConfig{Name: fmt.Sprintf("node-%d", idx), Url: "http://127.0.0.1:8086"})
+	}
+	circle := backend.NewCircle(cfg, &backend.ProxyConfig{HashKey: hashKey, DataDir: dataDir}, 0)
+	nodes := make(map[string]int, len(keys))
+	for _, key := range keys {
+		idx, err := strconv.Atoi(strings.TrimPrefix(circle.GetBackend(key).Name, "node-"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		nodes[key] = idx
+	}
+	// the virtual points are hashed as stathat.com/c/consistent required by the release, from the node keys of the
+	// circle, and a point is owned by the node added last to it
+	owners := make(map[uint32]int)
+	for idx := 0; idx < nodeTotal; idx++ {
+		key := nodeKey(hashKey, idx)
+		for i := 0; i < virtualReplicas; i++ {
+			owners[crc32.ChecksumIEEE([]byte(strconv.Itoa(i)+key))] = idx
+		}
+	}
+	points := make([]int, nodeTotal)
+	for _, idx := range owners {
+		points[idx]++
+	}
+	return nodes, points
+}
+
+// nodeKey returns the key of node index added to the circle by Circle.addRouter of influx-proxy.
+func nodeKey(hashKey string, idx int) string {
+	switch hashKey {
+	case "exi":
+		return "|" + strconv.Itoa(idx)
+	case "idx":
+		return strconv.Itoa(idx)
+	default:
+		return strings.ReplaceAll(hashKey, "%idx", strconv.Itoa(idx))
+	}
+}
@@ -0,0 +1,179 @@
+// Package plan plans how the shard groups of a source influxdb are transferred to the shard groups and nodes
+// of the targets, without writing anything, so that the plan can be reviewed separately from its execution.
+package plan
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chengshiwen/influx-tool/internal/escape"
+	"github.com/chengshiwen/influx-tool/internal/server"
+	"github.com/chengshiwen/influx-tool/internal/shard"
+	"github.com/chengshiwen/influx-tool/internal/storage"
+	"github.com/chengshiwen/influx-tool/pkg/hash"
+	"github.com/influxdata/influxdb/models"
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+// TransferOptions are the options of a transfer to plan, the same as the flags of the transfer command.
+type TransferOptions struct {
+	SourceDir       string
+	TargetDir       string
+	Database        string
+	RetentionPolicy string // default retention policy of the database if empty
+	ShardDuration   time.Duration
+	Start           int64 // unix nanoseconds, inclusive
+	End             int64 // unix nanoseconds, inclusive
+	NodeTotal       int
+	HashKey         string
+	ShardKey        string
+	Spread          []string // measurements whose series are routed by SpreadTag
+	SpreadTag       string
+	Escape          bool // escape the series which need escaping instead of discarding them
+	TSI             bool // read the source index as tsi1
+}
+
+// Transfer is the plan of a transfer.
+type Transfer struct {
+	Database        string        `json:"database"`
+	RetentionPolicy string        `json:"retention_policy"`
+	ShardDuration   time.Duration `json:"shard_duration"`
+	Groups          []Group       `json:"groups"`
+}
+
+// Group is a target shard group, with the source shard groups it is read from and the nodes it is written to.
+type Group struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Sources   []Source  `json:"sources"`
+	Nodes     []Node    `json:"nodes"`
+	Discarded int       `json:"discarded"` // series fields which need escaping and are not transferred
+}
+
+// Source is a source shard group.
+type Source struct {
+	ID        uint64    `json:"id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Shards    []uint64  `json:"shards"`
+}
+
+// Node is a target node of a shard group, with the directories the shard is written to.
+type Node struct {
+	Index        int    `json:"index"`
+	Measurements int    `json:"measurements"`
+	Series       int    `json:"series"` // series fields
+	DataDir      string `json:"data_dir"`
+	WALDir       string `json:"wal_dir"`
+}
+
+// NodeDir returns the influxdb directory of node index, suffixed to target directory.
+func NodeDir(targetDir string, idx int) string {
+	return fmt.Sprintf("%s-%d", strings.TrimRight(targetDir, "/"), idx)
+}
+
+// ShardGroups returns the target shard groups of duration sd for the time spanning start to end, each with the
+// source shard groups it overlaps. The sources must be sorted by time.
+func ShardGroups(sources []meta.ShardGroupInfo, sd time.Duration, start, end int64) []Group {
+	targets := shard.PlanShardGroups(sources, sd, start, end)
+	groups := make([]Group, len(targets))
+	for i, t := range targets {
+		groups[i] = Group{StartTime: t.StartTime, EndTime: t.EndTime}
+		for _, s := range sources {
+			if s.Overlaps(t.StartTime, t.EndTime.Add(-1)) {
+				src := Source{ID: s.ID, StartTime: s.StartTime, EndTime: s.EndTime}
+				for _, sh := range s.Shards {
+					src.Shards = append(src.Shards, sh.ID)
+				}
+				groups[i].Sources = append(groups[i].Sources, src)
+			}
+		}
+	}
+	return groups
+}
+
+// PlanTransfer plans a transfer by reading the meta and index of the source, the points are not read.
+func PlanTransfer(opts TransferOptions) (*Transfer, error) {
+	svr, err := server.NewServer(opts.SourceDir, opts.TSI)
+	if err != nil {
+		return nil, err
+	}
+	defer svr.Close()
+	client := svr.MetaClient()
+
+	dbi := client.Database(opts.Database)
+	if dbi == nil {
+		return nil, fmt.Errorf("database '%s' does not exist", opts.Database)
+	}
+	rp := opts.RetentionPolicy
+	if rp == "" {
+		rp = dbi.DefaultRetentionPolicy
+	}
+	if rpi, err := client.RetentionPolicy(opts.Database, rp); rpi == nil || err != nil {
+		return nil, fmt.Errorf("retention policy '%s' does not exist", rp)
+	}
+
+	p := &Transfer{Database: opts.Database, RetentionPolicy: rp, ShardDuration: opts.ShardDuration}
+	sources, err := client.ShardGroupsByTimeRange(opts.Database, rp, time.Unix(0, models.MinNanoTime), time.Unix(0, models.MaxNanoTime))
+	if err != nil || len(sources) == 0 {
+		return p, err
+	}
+	sort.Sort(meta.ShardGroupInfos(sources))
+	p.Groups = ShardGroups(sources, opts.ShardDuration, opts.Start, opts.End)
+
+	r := storage.NewReader(svr.TSDBConfig(), opts.Database, rp, sources)
+	if err = r.Open(); err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	ch := hash.NewConsistentHash(opts.NodeTotal, opts.HashKey)
+	st := hash.NewSpreadShard(hash.NewShardTpl(opts.ShardKey), opts.Spread, opts.SpreadTag)
+	for i := range p.Groups {
+		if err = p.planNodes(&p.Groups[i], r, opts, ch, st); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// planNodes routes the series of the group to the nodes as the transfer does, by the measurement of each series,
+// or by its tag for the spread measurements.
+func (p *Transfer) planNodes(g *Group, r *storage.Reader, opts TransferOptions, h hash.Hash, s *hash.SpreadShard) error {
+	rs, err := r.Read(g.StartTime, g.EndTime.Add(-1))
+	if err != nil || rs == nil {
+		return err
+	}
+	defer rs.Close()
+
+	nodes := make(map[int]*Node)
+	measurements := make(map[int]map[string]struct{})
+	for rs.Next() {
+		if !opts.Escape && escape.NeedEscape(rs.Name(), rs.Tags()) {
+			g.Discarded++
+			continue
+		}
+		idx := h.Get(s.GetSeriesKey(p.Database, p.RetentionPolicy, rs.Name(), rs.Tags()))
+		n := nodes[idx]
+		if n == nil {
+			dir := NodeDir(opts.TargetDir, idx)
+			n = &Node{
+				Index:   idx,
+				DataDir: filepath.Join(dir, "data", p.Database, p.RetentionPolicy),
+				WALDir:  filepath.Join(dir, "wal", p.Database, p.RetentionPolicy),
+			}
+			nodes[idx] = n
+			measurements[idx] = make(map[string]struct{})
+		}
+		n.Series++
+		measurements[idx][string(rs.Name())] = struct{}{}
+	}
+	for idx, n := range nodes {
+		n.Measurements = len(measurements[idx])
+		g.Nodes = append(g.Nodes, *n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].Index < g.Nodes[j].Index })
+	return nil
+}
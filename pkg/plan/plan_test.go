@@ -0,0 +1,39 @@
+package plan
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/services/meta"
+)
+
+func TestShardGroups(t *testing.T) {
+	day := 24 * time.Hour
+	sources := []meta.ShardGroupInfo{
+		{ID: 1, StartTime: time.Unix(0, 0).UTC(), EndTime: time.Unix(0, 0).UTC().Add(day), Shards: []meta.ShardInfo{{ID: 11}}},
+		{ID: 2, StartTime: time.Unix(0, 0).UTC().Add(day), EndTime: time.Unix(0, 0).UTC().Add(2 * day), Shards: []meta.ShardInfo{{ID: 12}}},
+		{ID: 3, StartTime: time.Unix(0, 0).UTC().Add(3 * day), EndTime: time.Unix(0, 0).UTC().Add(4 * day), Shards: []meta.ShardInfo{{ID: 13}}},
+	}
+	groups := ShardGroups(sources, 2*day, math.MinInt64, math.MaxInt64)
+	if len(groups) != 2 {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+	if len(groups[0].Sources) != 2 || groups[0].Sources[0].ID != 1 || groups[0].Sources[1].ID != 2 {
+		t.Fatalf("unexpected sources of group 0: %+v", groups[0].Sources)
+	}
+	if len(groups[1].Sources) != 1 || groups[1].Sources[0].ID != 3 || groups[1].Sources[0].Shards[0] != 13 {
+		t.Fatalf("unexpected sources of group 1: %+v", groups[1].Sources)
+	}
+
+	groups = ShardGroups(sources, day, time.Unix(0, 0).Add(day).UnixNano(), time.Unix(0, 0).Add(2*day).UnixNano()-1)
+	if len(groups) != 1 || len(groups[0].Sources) != 1 || groups[0].Sources[0].ID != 2 {
+		t.Fatalf("unexpected groups of time range: %+v", groups)
+	}
+}
+
+func TestNodeDir(t *testing.T) {
+	if dir := NodeDir("/data/influxdb/", 2); dir != "/data/influxdb-2" {
+		t.Fatalf("unexpected node dir: %s", dir)
+	}
+}
@@ -0,0 +1,76 @@
+package transform
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+// Hook applies a Transformer to the series and values read by a pipeline, and counts the series and values dropped.
+// It is safe for concurrent use as the Transformer is.
+type Hook struct {
+	t      Transformer
+	series atomic.Int64
+	values atomic.Int64
+}
+
+// NewHook returns the hook of t.
+func NewHook(t Transformer) *Hook {
+	return &Hook{t: t}
+}
+
+// Series returns the field of the series of the database and retention policy transformed, or false if dropped.
+func (h *Hook) Series(db, rp string, name []byte, tags models.Tags, field []byte) (*Series, bool) {
+	s := &Series{
+		Database:        db,
+		RetentionPolicy: rp,
+		Measurement:     string(name),
+		Tags:            tags.Map(),
+		Field:           string(field),
+	}
+	return s, h.t.Series(s)
+}
+
+// Value returns the value of the field of the series at t transformed, or false if dropped. An error is returned
+// if the type of the value is changed.
+func (h *Hook) Value(s *Series, t int64, v interface{}) (interface{}, bool, error) {
+	tv := Value{Time: t, Value: v}
+	if !h.t.Value(s, &tv) {
+		h.values.Add(1)
+		return nil, false, nil
+	}
+	if reflect.TypeOf(tv.Value) != reflect.TypeOf(v) {
+		return nil, false, fmt.Errorf("transform changed the type of field %s of %s from %T to %T", s.Field, s.Measurement, v, tv.Value)
+	}
+	return tv.Value, true, nil
+}
+
+// DropSeries counts a series dropped.
+func (h *Hook) DropSeries() {
+	h.series.Add(1)
+}
+
+// DropValues counts the values of a series dropped.
+func (h *Hook) DropValues(n int) {
+	h.values.Add(int64(n))
+}
+
+// Report returns the summary of the series and values dropped, such as "transform dropped 1 series of shards and
+// 10 values", or empty if none.
+func (h *Hook) Report(series string) string {
+	if h == nil {
+		return ""
+	}
+	n, values := h.series.Load(), h.values.Load()
+	if n == 0 && values == 0 {
+		return ""
+	}
+	return fmt.Sprintf("transform dropped %d %s and %d values", n, series, values)
+}
+
+// Key returns the measurement, tags and field of the series.
+func (s *Series) Key() ([]byte, models.Tags, []byte) {
+	return []byte(s.Measurement), models.NewTags(s.Tags), []byte(s.Field)
+}
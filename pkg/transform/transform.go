@@ -0,0 +1,96 @@
+// Package transform is the interface of the point transforms applied by export and transfer, so that custom rules
+// such as remapping tenants or converting units are applied without forking the tool.
+//
+// A transform is a go plugin, a main package built by `go build -buildmode=plugin` with the same go version and the
+// same versions of influx-tool and the packages they share, which exports a variable named Transformer implementing
+// Transformer:
+//
+//	package main
+//
+//	import "github.com/chengshiwen/influx-tool/pkg/transform"
+//
+//	type rules struct{}
+//
+//	func (rules) Series(s *transform.Series) bool {
+//		if s.Tags["tenant"] == "old" {
+//			s.Tags["tenant"] = "new"
+//		}
+//		return s.Measurement != "debug"
+//	}
+//
+//	func (rules) Value(s *transform.Series, v *transform.Value) bool {
+//		if f, ok := v.Value.(float64); ok && s.Field == "temp_f" {
+//			v.Value = (f - 32) * 5 / 9
+//		}
+//		return true
+//	}
+//
+//	var Transformer transform.Transformer = rules{}
+//
+// WASM modules are not supported, since no wasm runtime is built into the tool.
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"plugin"
+	"strings"
+)
+
+// Symbol is the name of the variable exported by a plugin.
+const Symbol = "Transformer"
+
+// Series is a field of a series read, which is modified in place to rename the measurement, tags or field. The
+// measurement and tags renamed route the series as well, to the nodes of the renamed series key by transfer.
+type Series struct {
+	Database        string // read only
+	RetentionPolicy string // read only
+	Measurement     string
+	Tags            map[string]string
+	Field           string
+}
+
+// Value is a value of a field, which is modified in place.
+type Value struct {
+	Time  int64       // unix nanoseconds, read only
+	Value interface{} // float64, int64, uint64, bool or string, whose type must be kept
+}
+
+// Transformer filters, modifies and routes the points read by export and transfer. It is called by several
+// goroutines at once, and must be safe for concurrent use.
+type Transformer interface {
+	// Series is called before the values of each field of a series, it returns false to drop all of them. It must
+	// return the same for the same series, since a series may be passed more than once, such as by each node of
+	// transfer.
+	Series(s *Series) bool
+	// Value is called for each value of the field of the series transformed by Series, it returns false to drop
+	// the value.
+	Value(s *Series, v *Value) bool
+}
+
+// Load opens the plugin of the path and returns its Transformer.
+func Load(path string) (Transformer, error) {
+	if strings.HasSuffix(path, ".wasm") {
+		return nil, errors.New("wasm transform is not supported, require a go plugin")
+	}
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(Symbol)
+	if err != nil {
+		return nil, err
+	}
+	// a variable is looked up as a pointer to it
+	switch t := sym.(type) {
+	case *Transformer:
+		if *t == nil {
+			return nil, fmt.Errorf("%s of plugin is nil", Symbol)
+		}
+		return *t, nil
+	case Transformer:
+		return t, nil
+	default:
+		return nil, fmt.Errorf("%s of plugin is %T, require transform.Transformer", Symbol, sym)
+	}
+}
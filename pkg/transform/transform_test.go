@@ -0,0 +1,55 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/influxdata/influxdb/models"
+)
+
+type testTransformer struct{}
+
+func (testTransformer) Series(s *Series) bool {
+	s.Measurement = s.Database + "_" + s.Measurement
+	return true
+}
+
+func (testTransformer) Value(s *Series, v *Value) bool {
+	if v.Time == 0 {
+		v.Value = "string"
+	}
+	return v.Time != 1
+}
+
+func TestHook(t *testing.T) {
+	h := NewHook(testTransformer{})
+	s, ok := h.Series("db", "rp", []byte("cpu"), models.NewTags(map[string]string{"host": "a"}), []byte("v"))
+	if !ok {
+		t.Fatal("series dropped")
+	}
+	if name, tags, field := s.Key(); string(name) != "db_cpu" || tags.GetString("host") != "a" || string(field) != "v" {
+		t.Errorf("unexpected series: %s %s %s", name, tags, field)
+	}
+	if _, _, err := h.Value(s, 0, 1.5); err == nil {
+		t.Error("expect error of the type changed")
+	}
+	if _, ok, err := h.Value(s, 1, 1.5); ok || err != nil {
+		t.Errorf("unexpected value kept: %v, %v", ok, err)
+	}
+	if v, ok, err := h.Value(s, 2, 1.5); !ok || err != nil || v != 1.5 {
+		t.Errorf("unexpected value: %v, %v, %v", v, ok, err)
+	}
+	if report := h.Report("series"); report != "transform dropped 0 series and 1 values" {
+		t.Errorf("unexpected report: %s", report)
+	}
+	if report := (*Hook)(nil).Report("series"); report != "" {
+		t.Errorf("unexpected report of nil hook: %s", report)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, path := range []string{"transform.wasm", "none.so"} {
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: expect error", path)
+		}
+	}
+}